@@ -45,6 +45,7 @@
 	"tailscale.com/types/key"
 	"tailscale.com/util/clientmetric"
 	"tailscale.com/util/eventbus"
+	"tailscale.com/wgengine/filter/filtertype"
 )
 
 // defaultClient is the default Client when using the legacy
@@ -770,6 +771,58 @@ func (lc *Client) PushFile(ctx context.Context, target tailcfg.StableNodeID, siz
 	return bestError(fmt.Errorf("%s: %s", res.Status, all), all)
 }
 
+// PushFileDir starts sending the Taildrop directory name to target. The files
+// described by manifest must then be sent with [Client.PushFile], named by
+// their paths within the directory prefixed with name and a slash. The
+// receiver only makes the files available once all have been received.
+//
+// The name parameter is the original directory name, not escaped.
+func (lc *Client) PushFileDir(ctx context.Context, target tailcfg.StableNodeID, name string, manifest apitype.TaildropDirManifest) error {
+	_, err := lc.send(ctx, "PUT", "/localapi/v0/file-put-dir/"+string(target)+"/"+url.PathEscape(name), 200, jsonBody(manifest))
+	return err
+}
+
+// SSHUserCert requests an OpenSSH user certificate for req.PublicKey from
+// the peer with the given stable node ID, which must be acting as an SSH
+// user CA. The certificate permits access as those of req.Users that the
+// tailnet SSH policy lets this node's identity access on the peer.
+func (lc *Client) SSHUserCert(ctx context.Context, peer tailcfg.StableNodeID, req apitype.SSHUserCertRequest) (*apitype.SSHUserCertResponse, error) {
+	body, err := lc.send(ctx, "POST", "/localapi/v0/ssh-user-cert?peer="+url.QueryEscape(string(peer)), 200, jsonBody(req))
+	if err != nil {
+		return nil, err
+	}
+	return decodeJSON[*apitype.SSHUserCertResponse](body)
+}
+
+// SSHRecordings returns the SSH session recordings that the local Tailscale
+// daemon stores locally, oldest first.
+func (lc *Client) SSHRecordings(ctx context.Context) ([]apitype.SSHRecording, error) {
+	body, err := lc.get200(ctx, "/localapi/v0/ssh-recordings/")
+	if err != nil {
+		return nil, err
+	}
+	return decodeJSON[[]apitype.SSHRecording](body)
+}
+
+// SSHRecording returns the asciinema cast file of the locally stored SSH
+// session recording with the given name, as returned by [Client.SSHRecordings].
+func (lc *Client) SSHRecording(ctx context.Context, name string) (io.ReadCloser, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", "http://"+apitype.LocalAPIHost+"/localapi/v0/ssh-recordings/"+url.PathEscape(name), nil)
+	if err != nil {
+		return nil, err
+	}
+	res, err := lc.doLocalRequestNiceError(req)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != 200 {
+		body, _ := io.ReadAll(res.Body)
+		res.Body.Close()
+		return nil, fmt.Errorf("HTTP %s: %s", res.Status, body)
+	}
+	return res.Body, nil
+}
+
 // CheckIPForwarding asks the local Tailscale daemon whether it looks like the
 // machine is properly configured to forward IP packets as a subnet router
 // or exit node.
@@ -924,6 +977,84 @@ func (lc *Client) QueryDNS(ctx context.Context, name string, queryType string) (
 	return res.Bytes, res.Resolvers, nil
 }
 
+// DNSCache returns the state of the DNS forwarder's response cache. If
+// withResponses is true, the cached responses are included.
+func (lc *Client) DNSCache(ctx context.Context, withResponses bool) (*apitype.DNSCache, error) {
+	if !buildfeatures.HasDNS {
+		return nil, feature.ErrUnavailable
+	}
+	body, err := lc.get200(ctx, fmt.Sprintf("/localapi/v0/dns-cache?responses=%v", withResponses))
+	if err != nil {
+		return nil, err
+	}
+	return decodeJSON[*apitype.DNSCache](body)
+}
+
+// FlushDNSCache removes all responses from the DNS forwarder's response
+// cache.
+func (lc *Client) FlushDNSCache(ctx context.Context) error {
+	if !buildfeatures.HasDNS {
+		return feature.ErrUnavailable
+	}
+	_, err := lc.send(ctx, "POST", "/localapi/v0/dns-cache-flush", http.StatusNoContent, nil)
+	return err
+}
+
+// DNSQueryLog returns the records of recent DNS queries answered by
+// tailscaled's internal resolver, oldest first.
+func (lc *Client) DNSQueryLog(ctx context.Context) ([]apitype.DNSQueryLogEntry, error) {
+	if !buildfeatures.HasDNS {
+		return nil, feature.ErrUnavailable
+	}
+	body, err := lc.get200(ctx, "/localapi/v0/dns-query-log")
+	if err != nil {
+		return nil, err
+	}
+	return decodeJSON[[]apitype.DNSQueryLogEntry](body)
+}
+
+// StreamDNSQueryLog returns an iterator over the records of DNS queries
+// answered by tailscaled's internal resolver from now on. Query logging is
+// enabled for as long as the iteration continues.
+func (lc *Client) StreamDNSQueryLog(ctx context.Context) iter.Seq2[apitype.DNSQueryLogEntry, error] {
+	return func(yield func(apitype.DNSQueryLogEntry, error) bool) {
+		if !buildfeatures.HasDNS {
+			yield(apitype.DNSQueryLogEntry{}, feature.ErrUnavailable)
+			return
+		}
+		req, err := http.NewRequestWithContext(ctx, "GET",
+			"http://"+apitype.LocalAPIHost+"/localapi/v0/dns-query-log?follow=true", nil)
+		if err != nil {
+			yield(apitype.DNSQueryLogEntry{}, err)
+			return
+		}
+		res, err := lc.doLocalRequestNiceError(req)
+		if err != nil {
+			yield(apitype.DNSQueryLogEntry{}, err)
+			return
+		}
+		defer res.Body.Close()
+		if res.StatusCode != http.StatusOK {
+			body, _ := io.ReadAll(res.Body)
+			yield(apitype.DNSQueryLogEntry{}, bestError(errors.New(res.Status), body))
+			return
+		}
+		dec := json.NewDecoder(bufio.NewReader(res.Body))
+		for {
+			var e apitype.DNSQueryLogEntry
+			if err := dec.Decode(&e); err == io.EOF {
+				return
+			} else if err != nil {
+				yield(apitype.DNSQueryLogEntry{}, err)
+				return
+			}
+			if !yield(e, nil) {
+				return
+			}
+		}
+	}
+}
+
 // StartLoginInteractive starts an interactive login.
 func (lc *Client) StartLoginInteractive(ctx context.Context) error {
 	_, err := lc.send(ctx, "POST", "/localapi/v0/login-interactive", http.StatusNoContent, nil)
@@ -1213,6 +1344,37 @@ func (lc *Client) DebugPacketFilterRules(ctx context.Context) ([]tailcfg.FilterR
 	return decodeJSON[[]tailcfg.FilterRule](body)
 }
 
+// DebugPacketFilterStats returns the per-rule hit counters of the current
+// device's packet filter.
+func (lc *Client) DebugPacketFilterStats(ctx context.Context) (*filtertype.Stats, error) {
+	body, err := lc.get200(ctx, "/localapi/v0/debug-packet-filter-stats")
+	if err != nil {
+		return nil, err
+	}
+	return decodeJSON[*filtertype.Stats](body)
+}
+
+// DebugSimulatePacketFilterRules replays the new inbound flows recently seen
+// by the current device's packet filter against a packet filter made of the
+// provided rules, reporting the verdicts of both.
+func (lc *Client) DebugSimulatePacketFilterRules(ctx context.Context, rules []tailcfg.FilterRule) ([]filtertype.SimulatedFlow, error) {
+	return lc.debugSimulatePacketFilter(ctx, "rules", rules)
+}
+
+// DebugSimulatePacketFilterMatches is like DebugSimulatePacketFilterRules,
+// but takes the candidate packet filter as matches.
+func (lc *Client) DebugSimulatePacketFilterMatches(ctx context.Context, matches []filtertype.Match) ([]filtertype.SimulatedFlow, error) {
+	return lc.debugSimulatePacketFilter(ctx, "matches", matches)
+}
+
+func (lc *Client) debugSimulatePacketFilter(ctx context.Context, typ string, candidate any) ([]filtertype.SimulatedFlow, error) {
+	body, err := lc.send(ctx, "POST", "/localapi/v0/debug-packet-filter-simulate?type="+typ, 200, jsonBody(candidate))
+	if err != nil {
+		return nil, err
+	}
+	return decodeJSON[[]filtertype.SimulatedFlow](body)
+}
+
 // DebugSetExpireIn marks the current node key to expire in d.
 //
 // This is meant primarily for debug and testing.
@@ -91,6 +91,53 @@ type modifyRequest struct {
 	return nil
 }
 
+// NetworkLockGenerateModify returns the unsigned AUMs that add and/or remove
+// key(s) to the tailnet key authority, for signing with [tka.SignUpdates] and
+// submitting with NetworkLockSubmitModify.
+func (lc *Client) NetworkLockGenerateModify(ctx context.Context, addKeys, removeKeys []tka.Key) ([]tka.AUM, error) {
+	type modifyRequest struct {
+		AddKeys    []tka.Key
+		RemoveKeys []tka.Key
+	}
+	body, err := lc.send(ctx, "POST", "/localapi/v0/tka/generate-modify", 200, jsonBody(modifyRequest{AddKeys: addKeys, RemoveKeys: removeKeys}))
+	if err != nil {
+		return nil, fmt.Errorf("error: %w", err)
+	}
+	res, err := decodeJSON[[]tkatype.MarshaledAUM](body)
+	if err != nil {
+		return nil, err
+	}
+	aums := make([]tka.AUM, len(res))
+	for i, b := range res {
+		if err := aums[i].Unserialize(b); err != nil {
+			return nil, fmt.Errorf("decoding AUM: %w", err)
+		}
+	}
+	return aums, nil
+}
+
+// NetworkLockSubmitModify submits AUMs from NetworkLockGenerateModify, once
+// signed, to the control plane.
+func (lc *Client) NetworkLockSubmitModify(ctx context.Context, aums []tka.AUM) error {
+	req := make([]tkatype.MarshaledAUM, len(aums))
+	for i, aum := range aums {
+		req[i] = aum.Serialize()
+	}
+	if _, err := lc.send(ctx, "POST", "/localapi/v0/tka/submit-modify", 204, jsonBody(req)); err != nil {
+		return fmt.Errorf("error: %w", err)
+	}
+	return nil
+}
+
+// NetworkLockSubmitSignature transmits a node-key signature, made by the
+// caller with a trusted key, to the control plane.
+func (lc *Client) NetworkLockSubmitSignature(ctx context.Context, sig tka.NodeKeySignature) error {
+	if _, err := lc.send(ctx, "POST", "/localapi/v0/tka/submit-signature", 200, bytes.NewReader(sig.Serialize())); err != nil {
+		return fmt.Errorf("error: %w", err)
+	}
+	return nil
+}
+
 // NetworkLockSign signs the specified node-key and transmits that signature to the control plane.
 // rotationPublic, if specified, must be an ed25519 public key.
 func (lc *Client) NetworkLockSign(ctx context.Context, nodeKey key.NodePublic, rotationPublic []byte) error {
@@ -130,6 +177,16 @@ func (lc *Client) NetworkLockLog(ctx context.Context, maxEntries int) ([]ipnstat
 	return decodeJSON[[]ipnstate.NetworkLockUpdate](body)
 }
 
+// NetworkLockExport returns an export of the tailnet key authority's active
+// chain of AUMs, for auditing.
+func (lc *Client) NetworkLockExport(ctx context.Context) (*tka.ChainExport, error) {
+	body, err := lc.send(ctx, "GET", "/localapi/v0/tka/export", 200, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error: %w", err)
+	}
+	return decodeJSON[*tka.ChainExport](body)
+}
+
 // NetworkLockForceLocalDisable forcibly shuts down network lock on this node.
 func (lc *Client) NetworkLockForceLocalDisable(ctx context.Context) error {
 	// This endpoint expects an empty JSON stanza as the payload.
@@ -5,6 +5,8 @@
 package apitype
 
 import (
+	"time"
+
 	"tailscale.com/tailcfg"
 	"tailscale.com/types/dnstype"
 	"tailscale.com/util/ctxkey"
@@ -56,6 +58,95 @@ type WaitingFile struct {
 	Size int64
 }
 
+// TaildropDirManifest describes the files of a directory sent with Taildrop.
+// The sender PUTs it to the receiving node before the files themselves.
+type TaildropDirManifest struct {
+	Files []TaildropDirFile
+}
+
+// TaildropDirFile is a file within a directory sent with Taildrop.
+type TaildropDirFile struct {
+	// Name is the slash-separated path of the file, relative to
+	// the directory being sent.
+	Name string
+	Size int64
+}
+
+// SSHUserCertRequest is the body POSTed to the LocalAPI endpoint
+// /ssh-user-cert to get an OpenSSH user certificate from a peer acting as
+// an SSH user CA.
+type SSHUserCertRequest struct {
+	// PublicKey is the SSH public key to certify, in authorized_keys format.
+	PublicKey string
+
+	// Users are the local users on the CA's node to request access as.
+	// Those that the tailnet SSH policy permits become the certificate's
+	// principals.
+	Users []string
+
+	// Duration is the requested validity period of the certificate.
+	// If zero, the CA's default is used. The CA may shorten it.
+	Duration time.Duration `json:",omitempty"`
+}
+
+// SSHUserCertResponse is the response to a LocalAPI /ssh-user-cert request.
+type SSHUserCertResponse struct {
+	// Certificate is the signed certificate in authorized_keys format,
+	// as stored in OpenSSH's "-cert.pub" files.
+	Certificate string
+
+	// CAPublicKey is the CA's public key in authorized_keys format,
+	// for use with OpenSSH's TrustedUserCAKeys.
+	CAPublicKey string
+
+	// Principals are the users the certificate permits access as.
+	Principals []string
+
+	// ValidBefore is when the certificate expires.
+	ValidBefore time.Time
+}
+
+// SSHRecording describes an SSH session recording stored locally by
+// tailscaled, as listed by the LocalAPI endpoint /ssh-recordings/.
+type SSHRecording struct {
+	// Name is the recording's file name, with which it's fetched from
+	// /ssh-recordings/<name>.
+	Name string
+
+	// Size is the size of the recording in bytes.
+	Size int64
+
+	// ModTime is when the recording was last written to.
+	ModTime time.Time
+
+	// The following fields are from the recording's header, and are
+	// zero if it couldn't be read.
+
+	// Start is when the recording started.
+	Start time.Time `json:",omitzero"`
+
+	// SSHUser is the username as presented by the SSH client.
+	SSHUser string `json:",omitempty"`
+
+	// LocalUser is the user that the session ran as.
+	LocalUser string `json:",omitempty"`
+
+	// SrcNode is the name of the node that the connection came from.
+	SrcNode string `json:",omitempty"`
+
+	// Command is the command that the session ran, or the name of its
+	// subsystem, such as "sftp". It's empty for shell sessions.
+	Command string `json:",omitempty"`
+
+	// Forwarding is whether the recording is of the port and agent
+	// forwarding channels of a connection, rather than of a session.
+	Forwarding bool `json:",omitempty"`
+
+	// ConnectionID identifies the SSH connection that the recording is
+	// of a session or the forwarding of.
+	ConnectionID string `json:",omitempty"`
+}
+
 // SetPushDeviceTokenRequest is the body POSTed to the LocalAPI endpoint /set-device-token.
 type SetPushDeviceTokenRequest struct {
 	// PushDeviceToken is the iOS/macOS APNs device token (and any future Android equivalent).
@@ -95,6 +186,56 @@ type DNSQueryResponse struct {
 	Resolvers []*dnstype.Resolver
 }
 
+// DNSCache describes the state of the DNS forwarder's response cache, as
+// returned via LocalAPI.
+type DNSCache struct {
+	// Enabled is whether the cache is enabled. If false, the other
+	// fields are empty.
+	Enabled bool
+
+	Entries    int    // number of cached responses
+	MaxEntries int    // maximum number of cached responses
+	Hits       uint64 // queries answered from the cache
+	Misses     uint64 // cacheable queries sent upstream
+
+	// Responses are the unexpired cached responses, most recently used
+	// first. It's only populated if requested.
+	Responses []DNSCacheEntry `json:",omitempty"`
+}
+
+// DNSCacheEntry describes a cached DNS response.
+type DNSCacheEntry struct {
+	Route    string    // DNS route suffix the query was resolved via, or empty for the default or explicit resolvers
+	Name     string    // query name
+	Type     string    // query type, such as "A" or "AAAA"
+	RCode    string    // response code, such as "RCodeSuccess"
+	Negative bool      // whether this is a cached NXDOMAIN or NODATA response
+	Expires  time.Time // when the response expires from the cache
+}
+
+// DNSQueryLogEntry is a record of a DNS query answered by the internal DNS
+// resolver, as returned via LocalAPI and written to the DNS query log.
+type DNSQueryLogEntry struct {
+	Time     time.Time // when the query was received
+	Source   string    // IP:port the query came from
+	Peer     string    // name of the node the query came from, if known
+	User     string    // login name of the owner of Peer, if known
+	Local    bool      // whether the query came from this device
+	FromPeer bool      // whether the query came from a peer using this device as an exit node or app connector
+
+	Name string // query name
+	Type string // query type, such as "A" or "AAAA"
+
+	Forwarded bool   // whether the query was forwarded upstream, rather than answered by MagicDNS
+	Route     string `json:",omitempty"` // DNS route suffix the query was forwarded via
+	Upstream  string `json:",omitempty"` // upstream resolver that answered
+	Cached    bool   `json:",omitempty"` // whether the response came from the forwarder's cache
+
+	RCode   string        `json:",omitempty"` // response code, such as "Success" or "NameError"; empty if Error is set
+	Error   string        `json:",omitempty"` // error, if no response could be produced
+	Latency time.Duration // time taken to produce the response
+}
+
 // OptionalFeatures describes which optional features are enabled in the build.
 type OptionalFeatures struct {
 	// Features is the map of optional feature names to whether they are
@@ -0,0 +1,131 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/netip"
+	"os"
+	"strings"
+	"time"
+
+	"tailscale.com/derp/derpserver"
+	"tailscale.com/net/tsaddr"
+	"tailscale.com/types/key"
+)
+
+// adminPathPrefix is the path prefix of the admin API.
+const adminPathPrefix = "/derp/admin/"
+
+// readAdminToken reads the admin API bearer token from the file at path,
+// trimming whitespace.
+func readAdminToken(path string) (string, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	tok := strings.TrimSpace(string(b))
+	if tok == "" {
+		return "", fmt.Errorf("admin token file %q is empty", path)
+	}
+	return tok, nil
+}
+
+// adminDrainResponse is the response to a drain request.
+type adminDrainResponse struct {
+	Timeout time.Duration // how long clients are given to leave; zero for the default
+	Clients int           // number of connected clients, including mesh peers
+}
+
+// adminHandler returns the handler for the admin API, which requires
+// requests to come from a loopback or Tailscale address (see
+// [adminAllowedFrom]) and to carry token as a bearer token. It serves:
+//
+//   - GET clients: the connected clients, as a JSON array of
+//     derpserver.ClientInfo.
+//   - POST disconnect?key=nodekey:…: closes the connections of the client
+//     with that key, replying with the number closed.
+//   - POST drain[?timeout=30s]: calls drain with the timeout, which
+//     should start gracefully shutting down the server with
+//     derpserver.Server.Drain, as on SIGTERM with --drain-timeout: new
+//     non-mesh clients are refused, connected ones are told to move to
+//     another server, and the server exits once they have or the timeout
+//     (zero for the default) passes.
+func adminHandler(s *derpserver.Server, token string, drain func(timeout time.Duration)) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET "+adminPathPrefix+"clients", func(w http.ResponseWriter, r *http.Request) {
+		writeAdminJSON(w, s.Clients())
+	})
+	mux.HandleFunc("POST "+adminPathPrefix+"disconnect", func(w http.ResponseWriter, r *http.Request) {
+		var k key.NodePublic
+		if err := k.UnmarshalText([]byte(r.FormValue("key"))); err != nil {
+			http.Error(w, "invalid key: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		n := s.DisconnectClient(k)
+		log.Printf("admin: disconnected %d connection(s) of %v", n, k.ShortString())
+		writeAdminJSON(w, n)
+	})
+	mux.HandleFunc("POST "+adminPathPrefix+"drain", func(w http.ResponseWriter, r *http.Request) {
+		var timeout time.Duration
+		if v := r.FormValue("timeout"); v != "" {
+			var err error
+			if timeout, err = time.ParseDuration(v); err != nil || timeout < 0 {
+				http.Error(w, "invalid timeout value", http.StatusBadRequest)
+				return
+			}
+		}
+		log.Printf("admin: draining (timeout %v)", timeout)
+		drain(timeout)
+		writeAdminJSON(w, adminDrainResponse{
+			Timeout: timeout,
+			Clients: len(s.Clients()),
+		})
+	})
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !adminAllowedFrom(r) {
+			http.Error(w, "admin API is only available over loopback or Tailscale", http.StatusForbidden)
+			return
+		}
+		got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
+
+// adminAllowedFrom reports whether r comes directly from a loopback or
+// Tailscale address. The admin API is mounted on the public DERP listener,
+// so the bearer token alone shouldn't expose it to the internet.
+func adminAllowedFrom(r *http.Request) bool {
+	if r.Header.Get("X-Forwarded-For") != "" {
+		// Conservatively refuse proxied requests, as tsweb.AllowDebugAccess does.
+		return false
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return false
+	}
+	ip, err := netip.ParseAddr(host)
+	if err != nil {
+		return false
+	}
+	ip = ip.Unmap()
+	return ip.IsLoopback() || tsaddr.IsTailscaleIP(ip)
+}
+
+func writeAdminJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	e := json.NewEncoder(w)
+	e.SetIndent("", "\t")
+	e.Encode(v)
+}
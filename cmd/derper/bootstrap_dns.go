@@ -37,6 +37,58 @@ type dnsEntryMap struct {
 	bootstrapLookupMap  syncs.Map[string, bool]
 )
 
+var (
+	// bootstrapDNSOverride and unpublishedDNSOverride, if non-nil, are
+	// the bootstrap DNS names set by the config file, overriding the
+	// --bootstrap-dns-names and --unpublished-bootstrap-dns-names flags.
+	bootstrapDNSOverride   syncs.AtomicValue[*string]
+	unpublishedDNSOverride syncs.AtomicValue[*string]
+
+	// bootstrapDNSChanged is sent to when the bootstrap DNS names change,
+	// to make refreshBootstrapDNSLoop refresh them immediately.
+	bootstrapDNSChanged = make(chan struct{}, 1)
+)
+
+// bootstrapDNSNames returns the comma-separated list of bootstrap DNS
+// names to publish.
+func bootstrapDNSNames() string {
+	if v := bootstrapDNSOverride.Load(); v != nil {
+		return *v
+	}
+	return *bootstrapDNS
+}
+
+// unpublishedDNSNames returns the comma-separated list of unpublished
+// bootstrap DNS names.
+func unpublishedDNSNames() string {
+	if v := unpublishedDNSOverride.Load(); v != nil {
+		return *v
+	}
+	return *unpublishedDNS
+}
+
+// setBootstrapDNSOverrides sets the bootstrap DNS names from the config
+// file, refreshing them if they changed. A nil slice means to use the
+// corresponding flag.
+func setBootstrapDNSOverrides(published, unpublished []string) {
+	toOverride := func(names []string) *string {
+		if names == nil {
+			return nil
+		}
+		s := strings.Join(names, ",")
+		return &s
+	}
+	wasPub, wasUnpub := bootstrapDNSNames(), unpublishedDNSNames()
+	bootstrapDNSOverride.Store(toOverride(published))
+	unpublishedDNSOverride.Store(toOverride(unpublished))
+	if bootstrapDNSNames() != wasPub || unpublishedDNSNames() != wasUnpub {
+		select {
+		case bootstrapDNSChanged <- struct{}{}:
+		default:
+		}
+	}
+}
+
 var (
 	bootstrapDNSRequests        = expvar.NewInt("counter_bootstrap_dns_requests")
 	publishedDNSHits            = expvar.NewInt("counter_bootstrap_dns_published_hits")
@@ -53,23 +105,29 @@ func init() {
 }
 
 func refreshBootstrapDNSLoop() {
-	if *bootstrapDNS == "" && *unpublishedDNS == "" {
-		return
-	}
 	for {
 		refreshBootstrapDNS()
 		refreshUnpublishedDNS()
-		time.Sleep(10 * time.Minute)
+		select {
+		case <-time.After(10 * time.Minute):
+		case <-bootstrapDNSChanged:
+		}
 	}
 }
 
 func refreshBootstrapDNS() {
-	if *bootstrapDNS == "" {
+	names := bootstrapDNSNames()
+	if names == "" {
+		if dnsCache.Load() != nil {
+			// The names were removed from the config file.
+			dnsCache.Store(nil)
+			dnsCacheBytes.Store(nil)
+		}
 		return
 	}
 	ctx, cancel := context.WithTimeout(context.Background(), refreshTimeout)
 	defer cancel()
-	dnsEntries := resolveList(ctx, *bootstrapDNS)
+	dnsEntries := resolveList(ctx, names)
 	// Randomize the order of the IPs for each name to avoid the client biasing
 	// to IPv6
 	for _, vv := range dnsEntries.IPs {
@@ -86,12 +144,14 @@ func refreshBootstrapDNS() {
 }
 
 func refreshUnpublishedDNS() {
-	if *unpublishedDNS == "" {
+	names := unpublishedDNSNames()
+	if names == "" {
+		unpublishedDNSCache.Store(nil)
 		return
 	}
 	ctx, cancel := context.WithTimeout(context.Background(), refreshTimeout)
 	defer cancel()
-	dnsEntries := resolveList(ctx, *unpublishedDNS)
+	dnsEntries := resolveList(ctx, names)
 	unpublishedDNSCache.Store(dnsEntries)
 }
 
@@ -37,6 +37,7 @@
 	"syscall"
 	"time"
 
+	"github.com/tailscale/hujson"
 	"github.com/tailscale/setec/client/setec"
 	"golang.org/x/time/rate"
 	"tailscale.com/atomicfile"
@@ -44,6 +45,7 @@
 	"tailscale.com/metrics"
 	"tailscale.com/net/ktimeout"
 	"tailscale.com/net/stunserver"
+	"tailscale.com/tstime"
 	"tailscale.com/tsweb"
 	"tailscale.com/types/key"
 	"tailscale.com/types/logger"
@@ -82,11 +84,19 @@
 	verifyClientURL = flag.String("verify-client-url", "", "if non-empty, an admission controller URL for permitting client connections; see tailcfg.DERPAdmitClientRequest")
 	verifyFailOpen  = flag.Bool("verify-client-url-fail-open", true, "whether we fail open if --verify-client-url is unreachable")
 
+	drainTimeout   = flag.Duration("drain-timeout", 0, "if non-zero, on SIGINT or SIGTERM, tell clients to move to another server and wait up to this long for them to disconnect before exiting")
+	drainAltRegion = flag.Int("drain-alt-region", 0, "if non-zero, the ID of the DERP region that clients are told to prefer while the server drains on shutdown; see --drain-timeout")
+
+	adminTokenFile = flag.String("admin-token-file", "", "if non-empty, path to a file containing a bearer token that enables the admin API at "+adminPathPrefix+", reachable only over loopback or Tailscale, for listing, disconnecting and draining clients; whitespace is trimmed")
+
 	socket = flag.String("socket", "", "optional alternate path to tailscaled socket (only relevant when using --verify-clients)")
 
 	acceptConnLimit = flag.Float64("accept-connection-limit", math.Inf(+1), "rate limit for accepting new connection")
 	acceptConnBurst = flag.Int("accept-connection-burst", math.MaxInt, "burst limit for accepting new connection")
 
+	perClientRateLimit = flag.Int64("per-client-rate-limit", 0, "rate limit for packets sent by each client, in bytes per second; 0 means no limit. Mesh peers are not limited. Overridden by the config file's ClientRateLimit, if set.")
+	perClientRateBurst = flag.Int64("per-client-rate-burst", 0, "burst size for --per-client-rate-limit, in bytes; raised to the maximum DERP frame size if smaller")
+
 	// tcpKeepAlive is intentionally long, to reduce battery cost. There is an L7 keepalive on a higher frequency schedule.
 	tcpKeepAlive = flag.Duration("tcp-keepalive-time", 10*time.Minute, "TCP keepalive time")
 	// tcpUserTimeout is intentionally short, so that hung connections are cleaned up promptly. DERPs should be nearby users.
@@ -106,8 +116,132 @@
 const setecMeshKeyName = "meshkey"
 const meshKeyEnvVar = "TAILSCALE_DERPER_MESH_KEY"
 
+// config is the contents of the config file named by -c, which is HuJSON
+// (JSON with comments and trailing commas).
+//
+// All fields but PrivateKey may be changed while derper is running; the
+// file is reloaded when it changes or derper receives SIGHUP. Fields left
+// unset keep the value of the corresponding command-line flag.
 type config struct {
 	PrivateKey key.NodePrivate
+
+	// MeshWith, if non-nil, are the hostnames to mesh with. It overrides
+	// the --mesh-with flag.
+	MeshWith []string `json:",omitempty"`
+
+	// VerifyClients, if non-nil, overrides the --verify-clients flag.
+	VerifyClients *bool `json:",omitempty"`
+
+	// VerifyClientURL, if non-nil, overrides the --verify-client-url flag.
+	VerifyClientURL *string `json:",omitempty"`
+
+	// VerifyClientURLFailOpen, if non-nil, overrides the
+	// --verify-client-url-fail-open flag.
+	VerifyClientURLFailOpen *bool `json:",omitempty"`
+
+	// BootstrapDNSNames, if non-nil, are the hostnames to make available
+	// at /bootstrap-dns. It overrides the --bootstrap-dns-names flag.
+	BootstrapDNSNames []string `json:",omitempty"`
+
+	// UnpublishedBootstrapDNSNames, if non-nil, overrides the
+	// --unpublished-bootstrap-dns-names flag.
+	UnpublishedBootstrapDNSNames []string `json:",omitempty"`
+
+	// TCPWriteTimeout, if non-nil, overrides the --tcp-write-timeout flag.
+	TCPWriteTimeout *tstime.GoDuration `json:",omitempty"`
+
+	// ClientRateLimit, if non-nil, is the rate limit for packets sent by
+	// each client. It overrides the --per-client-rate-limit and
+	// --per-client-rate-burst flags.
+	ClientRateLimit *derpserver.ClientRateLimit `json:",omitempty"`
+
+	// ClientRateLimits are the rate limits for packets sent by clients
+	// with particular node keys, overriding the default limit. A zero
+	// limit exempts the client from rate limiting.
+	ClientRateLimits map[key.NodePublic]derpserver.ClientRateLimit `json:",omitempty"`
+}
+
+// clientRateLimits returns the default and per-key client rate limits
+// configured by cfg and the command-line flags.
+func (cfg config) clientRateLimits() (def derpserver.ClientRateLimit, perKey map[key.NodePublic]derpserver.ClientRateLimit) {
+	def = derpserver.ClientRateLimit{
+		BytesPerSecond: *perClientRateLimit,
+		Burst:          *perClientRateBurst,
+	}
+	if cfg.ClientRateLimit != nil {
+		def = *cfg.ClientRateLimit
+	}
+	return def, cfg.ClientRateLimits
+}
+
+// meshWith returns the host tuples of the servers to mesh with.
+func (cfg config) meshWith() []string {
+	if cfg.MeshWith != nil {
+		return cfg.MeshWith
+	}
+	if *meshWith == "" {
+		return nil
+	}
+	return strings.Split(*meshWith, ",")
+}
+
+// valid reports whether cfg can be applied.
+func (cfg config) valid() error {
+	for _, hostTuple := range cfg.meshWith() {
+		if _, _, err := parseMeshHostTuple(hostTuple); err != nil {
+			return err
+		}
+	}
+	if cfg.TCPWriteTimeout != nil && cfg.TCPWriteTimeout.Duration < 0 {
+		return fmt.Errorf("negative TCPWriteTimeout %v", cfg.TCPWriteTimeout)
+	}
+	if cfg.ClientRateLimit != nil && cfg.ClientRateLimit.BytesPerSecond < 0 {
+		return errors.New("negative ClientRateLimit")
+	}
+	return nil
+}
+
+// apply applies the settings in cfg (and the flags it doesn't override)
+// to s and mesh. If it returns an error, none of them have been applied.
+func (cfg config) apply(s *derpserver.Server, mesh *meshPeers) error {
+	if err := cfg.valid(); err != nil {
+		return err
+	}
+	if len(cfg.meshWith()) > 0 && !s.HasMeshKey() {
+		return errors.New("mesh peers require a mesh key; see --mesh-psk-file")
+	}
+	// Everything that can fail is done before anything is changed, so
+	// that a config that fails to apply leaves s as it was.
+	commitMesh, err := mesh.prepare(cfg.meshWith())
+	if err != nil {
+		return err
+	}
+	s.SetVerifyClient(*cmp.Or(cfg.VerifyClients, verifyClients))
+	s.SetVerifyClientURL(*cmp.Or(cfg.VerifyClientURL, verifyClientURL))
+	s.SetVerifyClientURLFailOpen(*cmp.Or(cfg.VerifyClientURLFailOpen, verifyFailOpen))
+	tcpWriteTimeout := *tcpWriteTimeout
+	if cfg.TCPWriteTimeout != nil {
+		tcpWriteTimeout = cfg.TCPWriteTimeout.Duration
+	}
+	s.SetTCPWriteTimeout(tcpWriteTimeout)
+	def, perKey := cfg.clientRateLimits()
+	s.SetClientRateLimits(def, perKey)
+	setBootstrapDNSOverrides(cfg.BootstrapDNSNames, cfg.UnpublishedBootstrapDNSNames)
+	commitMesh()
+	return nil
+}
+
+// parseConfig parses the HuJSON contents of a config file.
+func parseConfig(b []byte) (config, error) {
+	var cfg config
+	b, err := hujson.Standardize(b)
+	if err != nil {
+		return cfg, err
+	}
+	if err := json.Unmarshal(b, &cfg); err != nil {
+		return cfg, err
+	}
+	return cfg, nil
 }
 
 func loadConfig() config {
@@ -130,8 +264,8 @@ func loadConfig() config {
 		log.Fatal(err)
 		panic("unreachable")
 	default:
-		var cfg config
-		if err := json.Unmarshal(b, &cfg); err != nil {
+		cfg, err := parseConfig(b)
+		if err != nil {
 			log.Fatalf("derper: config: %v", err)
 		}
 		return cfg
@@ -187,11 +321,7 @@ func main() {
 	serveTLS := tsweb.IsProd443(*addr) || *certMode == "manual"
 
 	s := derpserver.New(cfg.PrivateKey, log.Printf)
-	s.SetVerifyClient(*verifyClients)
 	s.SetTailscaledSocketPath(*socket)
-	s.SetVerifyClientURL(*verifyClientURL)
-	s.SetVerifyClientURLFailOpen(*verifyFailOpen)
-	s.SetTCPWriteTimeout(*tcpWriteTimeout)
 
 	var meshKey string
 	if *dev {
@@ -241,8 +371,17 @@ func main() {
 		log.Println("DERP mesh key configured")
 	}
 
-	if err := startMesh(s); err != nil {
-		log.Fatalf("startMesh: %v", err)
+	mesh := newMeshPeers(s)
+	if err := cfg.apply(s, mesh); err != nil {
+		log.Fatalf("derper: config: %v", err)
+	}
+	if def, perKey := cfg.clientRateLimits(); !def.IsZero() || len(perKey) > 0 {
+		log.Printf("Client rate limit: %v (%d per-client overrides)", def, len(perKey))
+	}
+	if *configPath != "" {
+		go watchConfig(ctx, *configPath, cfg, func(cfg config) error {
+			return cfg.apply(s, mesh)
+		})
 	}
 	expvar.Publish("derp", s.ExpVar())
 
@@ -268,6 +407,19 @@ func main() {
 	mux.HandleFunc("/derp/latency-check", derpserver.ProbeHandler)
 
 	go refreshBootstrapDNSLoop()
+	adminDrain := make(chan time.Duration, 1)
+	if *adminTokenFile != "" {
+		tok, err := readAdminToken(*adminTokenFile)
+		if err != nil {
+			log.Fatalf("derper: %v", err)
+		}
+		mux.Handle(adminPathPrefix, adminHandler(s, tok, func(timeout time.Duration) {
+			select {
+			case adminDrain <- timeout:
+			default: // already draining
+			}
+		}))
+	}
 	mux.HandleFunc("/bootstrap-dns", tsweb.BrowserHeaderHandlerFunc(handleBootstrapDNS))
 	mux.Handle("/", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		tsweb.AddBrowserHeaders(w)
@@ -334,7 +486,19 @@ func main() {
 		WriteTimeout: 30 * time.Second,
 	}
 	go func() {
-		<-ctx.Done()
+		drain, timeout := *drainTimeout > 0, *drainTimeout
+		select {
+		case <-ctx.Done():
+		case t := <-adminDrain:
+			drain, timeout = true, cmp.Or(t, *drainTimeout, derpserver.DefaultDrainTimeout)
+		}
+		if drain {
+			log.Printf("derper: draining for up to %v", timeout)
+			s.Drain(context.Background(), derpserver.DrainConfig{
+				AltRegionID: *drainAltRegion,
+				Timeout:     timeout,
+			})
+		}
 		httpsrv.Shutdown(ctx)
 	}()
 
@@ -6,13 +6,19 @@
 import (
 	"bytes"
 	"context"
+	"encoding/json"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"reflect"
+	"slices"
 	"strings"
 	"testing"
+	"time"
 
 	"tailscale.com/derp/derpserver"
 	"tailscale.com/tstest/deptest"
+	"tailscale.com/types/key"
 )
 
 func TestProdAutocertHostPolicy(t *testing.T) {
@@ -98,6 +104,197 @@ func TestNoContent(t *testing.T) {
 	}
 }
 
+func TestConfigClientRateLimits(t *testing.T) {
+	k := key.NewNode().Public()
+	var cfg config
+	if err := json.Unmarshal(fmt.Appendf(nil, `{
+		"ClientRateLimits": {%q: {"BytesPerSecond": 100, "Burst": 1000}}
+	}`, k.String()), &cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	oldLimit, oldBurst := *perClientRateLimit, *perClientRateBurst
+	defer func() { *perClientRateLimit, *perClientRateBurst = oldLimit, oldBurst }()
+	*perClientRateLimit, *perClientRateBurst = 5000, 50000
+
+	def, perKey := cfg.clientRateLimits()
+	if want := (derpserver.ClientRateLimit{BytesPerSecond: 5000, Burst: 50000}); def != want {
+		t.Errorf("default limit from flags = %+v; want %+v", def, want)
+	}
+	if got, want := perKey[k], (derpserver.ClientRateLimit{BytesPerSecond: 100, Burst: 1000}); got != want {
+		t.Errorf("limit for %v = %+v; want %+v", k.ShortString(), got, want)
+	}
+
+	cfg.ClientRateLimit = &derpserver.ClientRateLimit{BytesPerSecond: 1}
+	if def, _ := cfg.clientRateLimits(); def != *cfg.ClientRateLimit {
+		t.Errorf("default limit from config = %+v; want %+v", def, *cfg.ClientRateLimit)
+	}
+}
+
+func TestReloadConfig(t *testing.T) {
+	priv := key.NewNode()
+	prev := config{PrivateKey: priv}
+
+	var applied []config
+	apply := func(cfg config) error {
+		if err := cfg.valid(); err != nil {
+			return err
+		}
+		applied = append(applied, cfg)
+		return nil
+	}
+
+	// HuJSON, with a changed private key that must be ignored.
+	newPriv, err := key.NewNode().MarshalText()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg, ok := reloadConfig(fmt.Appendf(nil, `{
+		// Comments and trailing commas are allowed.
+		"PrivateKey": %q,
+		"MeshWith": ["derp1a.example.com", "derp1b.example.com/10.0.0.2",],
+		"VerifyClients": true,
+		"TCPWriteTimeout": "5s",
+	}`, newPriv), prev, apply)
+	if !ok {
+		t.Fatal("valid config not applied")
+	}
+	if !cfg.PrivateKey.Equal(priv) {
+		t.Error("PrivateKey changed on reload")
+	}
+	if got, want := cfg.meshWith(), []string{"derp1a.example.com", "derp1b.example.com/10.0.0.2"}; !slices.Equal(got, want) {
+		t.Errorf("meshWith = %q; want %q", got, want)
+	}
+	if cfg.VerifyClients == nil || !*cfg.VerifyClients {
+		t.Errorf("VerifyClients = %v; want true", cfg.VerifyClients)
+	}
+	if cfg.TCPWriteTimeout == nil || cfg.TCPWriteTimeout.Duration != 5*time.Second {
+		t.Errorf("TCPWriteTimeout = %v; want 5s", cfg.TCPWriteTimeout)
+	}
+
+	for _, bad := range []string{
+		`{"MeshWith": ["a/b/c"]}`,
+		`{"TCPWriteTimeout": "-1s"}`,
+		`{"VerifyClients": "yes"}`,
+		`{`,
+	} {
+		if got, ok := reloadConfig([]byte(bad), cfg, apply); ok {
+			t.Errorf("invalid config %s applied", bad)
+		} else if !reflect.DeepEqual(got, cfg) {
+			t.Errorf("invalid config %s changed config to %+v", bad, got)
+		}
+	}
+	if len(applied) != 1 {
+		t.Errorf("applied %d configs; want 1", len(applied))
+	}
+}
+
+func TestAdminHandler(t *testing.T) {
+	s := derpserver.New(key.NewNode(), t.Logf)
+	defer s.Close()
+	var drains []time.Duration
+	h := adminHandler(s, "secret", func(timeout time.Duration) {
+		drains = append(drains, timeout)
+	})
+
+	doFrom := func(remoteAddr, method, path, token string) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest(method, adminPathPrefix+path, nil)
+		req.RemoteAddr = remoteAddr
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+	do := func(method, path, token string) *httptest.ResponseRecorder {
+		t.Helper()
+		return doFrom("127.0.0.1:1234", method, path, token)
+	}
+
+	for _, remote := range []string{"192.0.2.1:1234", "[2001:db8::1]:1234"} {
+		if rec := doFrom(remote, "POST", "drain", "secret"); rec.Code != http.StatusForbidden {
+			t.Errorf("from %v: code = %d; want %d", remote, rec.Code, http.StatusForbidden)
+		}
+	}
+	for _, remote := range []string{"[::1]:1234", "100.64.0.1:1234", "[fd7a:115c:a1e0::1]:1234"} {
+		if rec := doFrom(remote, "GET", "clients", "secret"); rec.Code != http.StatusOK {
+			t.Errorf("from %v: code = %d; want %d", remote, rec.Code, http.StatusOK)
+		}
+	}
+
+	for _, tok := range []string{"", "wrong"} {
+		if rec := do("GET", "clients", tok); rec.Code != http.StatusUnauthorized {
+			t.Errorf("token %q: code = %d; want %d", tok, rec.Code, http.StatusUnauthorized)
+		}
+	}
+
+	rec := do("GET", "clients", "secret")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("clients: code = %d: %s", rec.Code, rec.Body)
+	}
+	var clients []derpserver.ClientInfo
+	if err := json.Unmarshal(rec.Body.Bytes(), &clients); err != nil {
+		t.Fatal(err)
+	}
+	if len(clients) != 0 {
+		t.Errorf("clients = %+v; want none", clients)
+	}
+
+	if rec := do("POST", "disconnect?key=bogus", "secret"); rec.Code != http.StatusBadRequest {
+		t.Errorf("disconnect bogus key: code = %d; want %d", rec.Code, http.StatusBadRequest)
+	}
+	rec = do("POST", "disconnect?key="+key.NewNode().Public().String(), "secret")
+	if rec.Code != http.StatusOK || strings.TrimSpace(rec.Body.String()) != "0" {
+		t.Errorf("disconnect: code = %d, body %q; want 200, 0", rec.Code, rec.Body)
+	}
+
+	if rec := do("GET", "drain", "secret"); rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("GET drain: code = %d; want %d", rec.Code, http.StatusMethodNotAllowed)
+	}
+	for _, bad := range []string{"bogus", "-1s"} {
+		if rec := do("POST", "drain?timeout="+bad, "secret"); rec.Code != http.StatusBadRequest {
+			t.Errorf("drain with timeout %q: code = %d; want %d", bad, rec.Code, http.StatusBadRequest)
+		}
+	}
+	if rec := do("POST", "drain", "secret"); rec.Code != http.StatusOK {
+		t.Errorf("drain: code = %d; want 200", rec.Code)
+	}
+	if rec := do("POST", "drain?timeout=1m", "secret"); rec.Code != http.StatusOK {
+		t.Errorf("drain with timeout: code = %d; want 200", rec.Code)
+	}
+	if want := []time.Duration{0, time.Minute}; !slices.Equal(drains, want) {
+		t.Errorf("drains = %v; want %v", drains, want)
+	}
+}
+
+func TestConfigApplyAtomic(t *testing.T) {
+	s := derpserver.New(key.NewNode(), t.Logf)
+	defer s.Close()
+	if err := s.SetMeshKey("0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"); err != nil {
+		t.Fatal(err)
+	}
+	mesh := newMeshPeers(s)
+
+	// The mesh peer passes validation but can't have a client made for
+	// it, after the other settings would have been applied.
+	was := bootstrapDNSNames()
+	cfg := config{
+		BootstrapDNSNames: []string{"changed.example.com"},
+		MeshWith:          []string{"bad host.example.com"},
+	}
+	if err := cfg.apply(s, mesh); err == nil {
+		t.Fatal("apply succeeded; want error")
+	}
+	if got := bootstrapDNSNames(); got != was {
+		t.Errorf("after failed apply, bootstrap DNS names = %q; want %q", got, was)
+	}
+	if len(mesh.peers) != 0 {
+		t.Errorf("after failed apply, mesh peers = %v; want none", mesh.peers)
+	}
+}
+
 func TestDeps(t *testing.T) {
 	deptest.DepChecker{
 		BadDeps: map[string]string{
@@ -0,0 +1,78 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// configPollInterval is how often watchConfig checks the config file for
+// changes.
+const configPollInterval = 5 * time.Second
+
+// watchConfig watches the config file at path, which had the contents cur
+// at startup, and calls apply with its new contents whenever it changes or
+// derper receives SIGHUP, until ctx is done.
+//
+// A file that fails to parse or apply is logged and otherwise ignored, and
+// the previous settings stay in effect.
+func watchConfig(ctx context.Context, path string, cur config, apply func(config) error) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	last, _ := os.ReadFile(path)
+	ticker := time.NewTicker(configPollInterval)
+	defer ticker.Stop()
+	for {
+		force := false
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-hup:
+			force = true
+		}
+		b, err := os.ReadFile(path)
+		if err != nil {
+			log.Printf("config: %v", err)
+			continue
+		}
+		if !force && bytes.Equal(b, last) {
+			continue
+		}
+		last = b
+		if cfg, ok := reloadConfig(b, cur, apply); ok {
+			cur = cfg
+		}
+	}
+}
+
+// reloadConfig parses b as the new contents of the config file and applies
+// it, reporting whether it was applied. prev is the config currently in
+// effect.
+func reloadConfig(b []byte, prev config, apply func(config) error) (_ config, ok bool) {
+	cfg, err := parseConfig(b)
+	if err != nil {
+		log.Printf("config: not reloading: %v", err)
+		return prev, false
+	}
+	if !cfg.PrivateKey.Equal(prev.PrivateKey) {
+		// The server's key can't change while it's running.
+		log.Printf("config: ignoring PrivateKey change; restart derper to use the new key")
+		cfg.PrivateKey = prev.PrivateKey
+	}
+	if err := apply(cfg); err != nil {
+		log.Printf("config: not reloading: %v", err)
+		return prev, false
+	}
+	log.Printf("config: reloaded")
+	return cfg, true
+}
@@ -10,6 +10,7 @@
 	"log"
 	"net"
 	"strings"
+	"sync"
 
 	"tailscale.com/derp"
 	"tailscale.com/derp/derphttp"
@@ -18,40 +19,126 @@
 	"tailscale.com/types/logger"
 )
 
-func startMesh(s *derpserver.Server) error {
-	if *meshWith == "" {
-		return nil
+// meshPeers manages the server's connections to the other servers in its
+// region, as configured by --mesh-with or the config file's MeshWith.
+type meshPeers struct {
+	s *derpserver.Server
+
+	mu    sync.Mutex
+	peers map[string]*meshPeer // keyed by host tuple
+}
+
+// meshPeer is a connection to a single mesh peer.
+type meshPeer struct {
+	cancel context.CancelFunc
+	c      *derphttp.Client
+}
+
+func newMeshPeers(s *derpserver.Server) *meshPeers {
+	return &meshPeers{
+		s:     s,
+		peers: map[string]*meshPeer{},
+	}
+}
+
+// prepare prepares to set the servers to mesh with to hostTuples, each a
+// hostname optionally followed by a slash and a hostname to dial instead.
+// It checks hostTuples and creates clients, not yet connected, for the
+// servers not previously in the set. The returned commit func then
+// connects to those servers and disconnects from the ones no longer in the
+// set. If prepare returns an error, nothing has changed.
+func (m *meshPeers) prepare(hostTuples []string) (commit func(), err error) {
+	if len(hostTuples) > 0 && !m.s.HasMeshKey() {
+		return nil, errors.New("--mesh-with requires --mesh-psk-file")
 	}
-	if !s.HasMeshKey() {
-		return errors.New("--mesh-with requires --mesh-psk-file")
+	want := map[string]bool{}
+	for _, hostTuple := range hostTuples {
+		if _, _, err := parseMeshHostTuple(hostTuple); err != nil {
+			return nil, err
+		}
+		want[hostTuple] = true
 	}
-	for _, hostTuple := range strings.Split(*meshWith, ",") {
-		if err := startMeshWithHost(s, hostTuple); err != nil {
-			return err
+
+	m.mu.Lock()
+	var added []string
+	for hostTuple := range want {
+		if _, ok := m.peers[hostTuple]; !ok {
+			added = append(added, hostTuple)
+		}
+	}
+	m.mu.Unlock()
+
+	clients := map[string]*derphttp.Client{}
+	for _, hostTuple := range added {
+		c, err := newMeshClient(m.s, hostTuple)
+		if err != nil {
+			for _, c := range clients {
+				c.Close()
+			}
+			return nil, err
 		}
+		clients[hostTuple] = c
 	}
-	return nil
+
+	return func() {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		for hostTuple, p := range m.peers {
+			if want[hostTuple] {
+				continue
+			}
+			log.Printf("mesh: removing %q", hostTuple)
+			// Canceling the context stops the watch loop once Close
+			// breaks its connection, and the loop removes the peer's
+			// packet forwarders on the way out.
+			p.cancel()
+			p.c.Close()
+			delete(m.peers, hostTuple)
+		}
+		for hostTuple, c := range clients {
+			if _, ok := m.peers[hostTuple]; ok {
+				c.Close() // added by another commit in the meantime
+				continue
+			}
+			ctx, cancel := context.WithCancel(context.Background())
+			startMeshClient(ctx, m.s, c, hostTuple)
+			m.peers[hostTuple] = &meshPeer{cancel: cancel, c: c}
+		}
+	}, nil
 }
 
-func startMeshWithHost(s *derpserver.Server, hostTuple string) error {
-	var host string
-	var dialHost string
+// parseMeshHostTuple parses a --mesh-with entry into the hostname of the
+// server and the hostname to dial to reach it.
+func parseMeshHostTuple(hostTuple string) (host, dialHost string, err error) {
 	hostParts := strings.Split(hostTuple, "/")
 	if len(hostParts) > 2 {
-		return fmt.Errorf("too many components in host tuple %q", hostTuple)
+		return "", "", fmt.Errorf("too many components in host tuple %q", hostTuple)
 	}
 	host = hostParts[0]
+	if host == "" {
+		return "", "", fmt.Errorf("empty hostname in host tuple %q", hostTuple)
+	}
 	if len(hostParts) == 2 {
 		dialHost = hostParts[1]
 	} else {
 		dialHost = hostParts[0]
 	}
+	return host, dialHost, nil
+}
+
+// newMeshClient returns a client, not yet connected, for the mesh peer
+// hostTuple.
+func newMeshClient(s *derpserver.Server, hostTuple string) (*derphttp.Client, error) {
+	host, dialHost, err := parseMeshHostTuple(hostTuple)
+	if err != nil {
+		return nil, err
+	}
 
-	logf := logger.WithPrefix(log.Printf, fmt.Sprintf("mesh(%q): ", host))
+	logf := meshLogf(host)
 	netMon := netmon.NewStatic() // good enough for cmd/derper; no need for netns fanciness
 	c, err := derphttp.NewClient(s.PrivateKey(), "https://"+host+"/derp", logf, netMon)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	c.MeshKey = s.MeshKey()
 	c.WatchConnectionChanges = true
@@ -71,9 +158,21 @@ func startMeshWithHost(s *derpserver.Server, hostTuple string) error {
 		})
 	}
 
+	return c, nil
+}
+
+// meshLogf returns the logger for the connection to mesh peer host.
+func meshLogf(host string) logger.Logf {
+	return logger.WithPrefix(log.Printf, fmt.Sprintf("mesh(%q): ", host))
+}
+
+// startMeshClient starts c, a client made by newMeshClient for hostTuple,
+// watching the peer's connections until ctx is done.
+func startMeshClient(ctx context.Context, s *derpserver.Server, c *derphttp.Client, hostTuple string) {
+	host, _, _ := parseMeshHostTuple(hostTuple)
+	logf := meshLogf(host)
 	add := func(m derp.PeerPresentMessage) { s.AddPacketForwarder(m.Key, c) }
 	remove := func(m derp.PeerGoneMessage) { s.RemovePacketForwarder(m.Peer, c) }
 	notifyError := func(err error) {}
-	go c.RunWatchConnectionLoop(context.Background(), s.PublicKey(), logf, add, remove, notifyError)
-	return nil
+	go c.RunWatchConnectionLoop(ctx, s.PublicKey(), logf, add, remove, notifyError)
 }
@@ -260,6 +260,7 @@ func newRootCmd() *ffcli.Command {
 			pingCmd,
 			ncCmd,
 			sshCmd,
+			sshCertCmd,
 			nilOrCall(maybeFunnelCmd),
 			nilOrCall(maybeServeCmd),
 			versionCmd,
@@ -12,6 +12,7 @@
 	"fmt"
 	"net/netip"
 	"slices"
+	"strings"
 
 	"github.com/peterbourgon/ff/v3/ffcli"
 	"tailscale.com/net/udprelay/status"
@@ -46,6 +47,28 @@ func runPeerRelaySessions(ctx context.Context, args []string) error {
 		f("%d", *srv.UDPPort)
 	}
 	f("\n")
+	if pol := srv.Policy; pol.HasACL() {
+		f("Allowed tags: %s\n", strings.Join(pol.AllowTags, ", "))
+		f("Allowed users: %s\n", strings.Join(pol.AllowUsers, ", "))
+	}
+	if srv.Policy.MaxEndpoints > 0 {
+		f("Max sessions: %d\n", srv.Policy.MaxEndpoints)
+	}
+	if srv.Policy.MaxBytesPerSecond > 0 {
+		f("Max bytes/s per client: %d\n", srv.Policy.MaxBytesPerSecond)
+	}
+	if srv.Policy.MaxPacketsPerSecond > 0 {
+		f("Max packets/s per client: %d\n", srv.Policy.MaxPacketsPerSecond)
+	}
+	if srv.Policy.BindLifetime > 0 {
+		f("Bind lifetime: %v\n", srv.Policy.BindLifetime)
+	}
+	if srv.Policy.SteadyStateLifetime > 0 {
+		f("Idle timeout: %v\n", srv.Policy.SteadyStateLifetime)
+	}
+	if srv.AllocationsDeniedByPolicy > 0 || srv.AllocationsDeniedByQuota > 0 {
+		f("Sessions denied: %d by policy, %d by quota\n", srv.AllocationsDeniedByPolicy, srv.AllocationsDeniedByQuota)
+	}
 	f("Sessions count: %d\n", len(srv.Sessions))
 	if len(srv.Sessions) == 0 {
 		Stdout.Write(buf.Bytes())
@@ -59,10 +82,14 @@ func runPeerRelaySessions(ctx context.Context, args []string) error {
 			}
 			return "<no handshake>"
 		}
-		return fmt.Sprintf("%s(%s) --> %s(%s), Packets: %d Bytes: %d",
+		s := fmt.Sprintf("%s(%s) --> %s(%s), Packets: %d Bytes: %d",
 			fmtEndpoint(a.Endpoint), a.ShortDisco,
 			fmtEndpoint(z.Endpoint), z.ShortDisco,
 			a.PacketsTx, a.BytesTx)
+		if a.PacketsDropped > 0 {
+			s += fmt.Sprintf(" Dropped packets: %d Dropped bytes: %d", a.PacketsDropped, a.BytesDropped)
+		}
+		return s
 	}
 
 	f("\n")
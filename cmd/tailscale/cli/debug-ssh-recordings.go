@@ -0,0 +1,273 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build !ios && !ts_omit_ssh
+
+package cli
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/peterbourgon/ff/v3/ffcli"
+	gossh "golang.org/x/crypto/ssh"
+	"tailscale.com/sessionrecording"
+)
+
+func init() {
+	debugSSHRecordingsCmd = mkDebugSSHRecordingsCmd
+}
+
+func mkDebugSSHRecordingsCmd() *ffcli.Command {
+	return &ffcli.Command{
+		Name:       "ssh-recordings",
+		ShortUsage: "tailscale debug ssh-recordings <list|play|verify>",
+		ShortHelp:  "List, play and verify SSH session recordings stored locally",
+		LongHelp: strings.TrimSpace(`
+SSH session recordings are stored locally by tailscaled when the tailnet's SSH
+policy doesn't configure recorders for them, if tailscaled is run with
+TS_SSH_RECORDING_DIR set to the directory to store them in.
+
+Tamper-evident recordings, as made when the SSH policy requires them, can also
+be verified when read from stdin, as of those stored by recorder nodes.
+`),
+		Subcommands: []*ffcli.Command{
+			{
+				Name:       "list",
+				ShortUsage: "tailscale debug ssh-recordings list [--json]",
+				ShortHelp:  "List the recordings, oldest first",
+				Exec:       runSSHRecordingsList,
+				FlagSet: (func() *flag.FlagSet {
+					fs := newFlagSet("list")
+					fs.BoolVar(&sshRecordingsArgs.json, "json", false, "output in JSON format")
+					return fs
+				})(),
+			},
+			{
+				Name:       "play",
+				ShortUsage: "tailscale debug ssh-recordings play [--speed=N] [--max-idle=D] <name>",
+				ShortHelp:  "Replay a recording in the terminal",
+				Exec:       runSSHRecordingsPlay,
+				FlagSet: (func() *flag.FlagSet {
+					fs := newFlagSet("play")
+					fs.Float64Var(&sshRecordingsArgs.speed, "speed", 1, "factor to speed up playback by")
+					fs.DurationVar(&sshRecordingsArgs.maxIdle, "max-idle", 2*time.Second, "longest pause between outputs, or 0 for no limit")
+					return fs
+				})(),
+			},
+			{
+				Name:       "verify",
+				ShortUsage: "tailscale debug ssh-recordings verify [--node=<host|ip> | --key=<file>] <name|->",
+				ShortHelp:  "Verify that a tamper-evident recording is complete and unmodified",
+				LongHelp: strings.TrimSpace(`
+The verify subcommand checks the hash chain and signatures of a tamper-evident
+recording, given its name or "-" to read it from stdin. The recording must be
+signed with the SSH host key of the node that made it, as known to this node
+from the tailnet, or with a key read from a file in authorized_keys format,
+such as a node's /etc/ssh/ssh_host_ed25519_key.pub.
+
+Recordings given by name are those stored by this node, which is the default
+for --node. Recordings read from stdin need --node or --key.
+`),
+				Exec: runSSHRecordingsVerify,
+				FlagSet: (func() *flag.FlagSet {
+					fs := newFlagSet("verify")
+					fs.StringVar(&sshRecordingsArgs.node, "node", "", "node that made the recording, whose SSH host key it must be signed with (default this node, for recordings given by name)")
+					fs.StringVar(&sshRecordingsArgs.keyFile, "key", "", "file of the SSH public key that the recording must be signed with, in authorized_keys format")
+					return fs
+				})(),
+			},
+		},
+		Exec: func(ctx context.Context, args []string) error {
+			return flag.ErrHelp
+		},
+	}
+}
+
+var sshRecordingsArgs struct {
+	json    bool
+	speed   float64
+	maxIdle time.Duration
+	node    string
+	keyFile string
+}
+
+func runSSHRecordingsList(ctx context.Context, args []string) error {
+	if len(args) > 0 {
+		return errors.New("unexpected arguments")
+	}
+	recs, err := localClient.SSHRecordings(ctx)
+	if err != nil {
+		return err
+	}
+	if sshRecordingsArgs.json {
+		e := json.NewEncoder(Stdout)
+		e.SetIndent("", "  ")
+		return e.Encode(recs)
+	}
+	if len(recs) == 0 {
+		outln("No recordings.")
+		return nil
+	}
+	w := tabwriter.NewWriter(Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tSTART\tFROM\tUSER\tCOMMAND\tSIZE")
+	for _, rec := range recs {
+		start := "-"
+		if !rec.Start.IsZero() {
+			start = rec.Start.Local().Format(time.DateTime)
+		}
+		user := rec.SSHUser
+		if rec.LocalUser != "" && rec.LocalUser != rec.SSHUser {
+			user += " (" + rec.LocalUser + ")"
+		}
+		command := rec.Command
+		switch {
+		case rec.Forwarding:
+			command = "(forwarding)"
+		case command == "":
+			command = "(shell)"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%d\n", rec.Name, start, rec.SrcNode, user, command, rec.Size)
+	}
+	return w.Flush()
+}
+
+func runSSHRecordingsPlay(ctx context.Context, args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: tailscale debug ssh-recordings play [--speed=N] [--max-idle=D] <name>")
+	}
+	if sshRecordingsArgs.speed <= 0 {
+		return errors.New("--speed must be positive")
+	}
+	rc, err := localClient.SSHRecording(ctx, args[0])
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	return sessionrecording.Play(ctx, Stdout, rc, sessionrecording.PlayOptions{
+		Speed:   sshRecordingsArgs.speed,
+		MaxIdle: sshRecordingsArgs.maxIdle,
+	})
+}
+
+func runSSHRecordingsVerify(ctx context.Context, args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: tailscale debug ssh-recordings verify [--node=<host|ip> | --key=<file>] <name|->")
+	}
+	keys, signer, err := trustedRecordingKeys(ctx, args[0] == "-")
+	if err != nil {
+		return err
+	}
+	var r io.Reader = os.Stdin
+	if args[0] != "-" {
+		rc, err := localClient.SSHRecording(ctx, args[0])
+		if err != nil {
+			return err
+		}
+		defer rc.Close()
+		r = rc
+	}
+	v, err := sessionrecording.VerifyChain(r, keys)
+	if errors.Is(err, sessionrecording.ErrUntrustedKey) {
+		return fmt.Errorf("verification failed: recording signed with key %s, not the SSH host key of %s", sshKeyFingerprint(v.Header.Chain.PublicKey), signer)
+	}
+	if err != nil && !errors.Is(err, sessionrecording.ErrRecordingTruncated) {
+		return fmt.Errorf("verification failed: %w", err)
+	}
+	if v.Signatures > 0 {
+		outln("Signed by host key", sshKeyFingerprint(v.Header.Chain.PublicKey), "of", signer)
+	}
+	if err != nil {
+		return fmt.Errorf("%w; the first %d lines are verified", err, v.Lines)
+	}
+	printf("Verified %d lines with %d signatures.\n", v.Lines, v.Signatures)
+	return nil
+}
+
+// trustedRecordingKeys returns the Ed25519 SSH host keys that a recording
+// must be signed with, from --key or the node from --node, along with a
+// description of whose they are. The node defaults to this one, unless the
+// recording is read from stdin.
+func trustedRecordingKeys(ctx context.Context, fromStdin bool) (keys []ed25519.PublicKey, signer string, err error) {
+	node, keyFile := sshRecordingsArgs.node, sshRecordingsArgs.keyFile
+	var authorizedKeys []string
+	switch {
+	case node != "" && keyFile != "":
+		return nil, "", errors.New("--node and --key are mutually exclusive")
+	case keyFile != "":
+		b, err := os.ReadFile(keyFile)
+		if err != nil {
+			return nil, "", err
+		}
+		authorizedKeys = strings.Split(string(b), "\n")
+		signer = keyFile
+	case node == "" && fromStdin:
+		return nil, "", errors.New("--node or --key is required to verify a recording read from stdin")
+	default:
+		var ip string
+		if node == "" {
+			st, err := localClient.StatusWithoutPeers(ctx)
+			if err != nil {
+				return nil, "", err
+			}
+			if st.Self == nil || len(st.Self.TailscaleIPs) == 0 {
+				return nil, "", errors.New("this node has no Tailscale IP")
+			}
+			ip, signer = st.Self.TailscaleIPs[0].String(), "this node"
+		} else {
+			ip, _, err = tailscaleIPFromArg(ctx, node)
+			if err != nil {
+				return nil, "", err
+			}
+			signer = node
+		}
+		who, err := localClient.WhoIs(ctx, ip)
+		if err != nil {
+			return nil, "", fmt.Errorf("looking up %s: %w", signer, err)
+		}
+		if who.Node != nil {
+			authorizedKeys = who.Node.Hostinfo.SSH_HostKeys().AsSlice()
+		}
+	}
+	for _, line := range authorizedKeys {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		pk, _, _, _, err := gossh.ParseAuthorizedKey([]byte(line))
+		if err != nil {
+			if keyFile != "" {
+				return nil, "", fmt.Errorf("parsing %s: %w", keyFile, err)
+			}
+			continue
+		}
+		if cpk, ok := pk.(gossh.CryptoPublicKey); ok {
+			if k, ok := cpk.CryptoPublicKey().(ed25519.PublicKey); ok {
+				keys = append(keys, k)
+			}
+		}
+	}
+	if len(keys) == 0 {
+		return nil, "", fmt.Errorf("no Ed25519 SSH host key known for %s", signer)
+	}
+	return keys, signer, nil
+}
+
+// sshKeyFingerprint returns the SHA256 fingerprint of the Ed25519 public key
+// pub, as OpenSSH shows for host keys.
+func sshKeyFingerprint(pub []byte) string {
+	pk, err := gossh.NewPublicKey(ed25519.PublicKey(pub))
+	if err != nil {
+		return fmt.Sprintf("(invalid key %x)", pub)
+	}
+	return gossh.FingerprintSHA256(pk)
+}
@@ -24,8 +24,10 @@
 	"os"
 	"runtime"
 	"runtime/debug"
+	"slices"
 	"strconv"
 	"strings"
+	"text/tabwriter"
 	"time"
 
 	"github.com/peterbourgon/ff/v3/ffcli"
@@ -48,12 +50,14 @@
 	"tailscale.com/types/logger"
 	"tailscale.com/util/eventbus"
 	"tailscale.com/util/must"
+	"tailscale.com/wgengine/filter/filtertype"
 )
 
 var (
-	debugCaptureCmd   func() *ffcli.Command // or nil
-	debugPortmapCmd   func() *ffcli.Command // or nil
-	debugPeerRelayCmd func() *ffcli.Command // or nil
+	debugCaptureCmd       func() *ffcli.Command // or nil
+	debugPortmapCmd       func() *ffcli.Command // or nil
+	debugPeerRelayCmd     func() *ffcli.Command // or nil
+	debugSSHRecordingsCmd func() *ffcli.Command // or nil
 )
 
 func debugCmd() *ffcli.Command {
@@ -364,6 +368,42 @@ func debugCmd() *ffcli.Command {
 				ShortHelp:  "Print Go's runtime/debug.BuildInfo",
 				Exec:       runGoBuildInfo,
 			},
+			{
+				Name:       "filter-stats",
+				ShortUsage: "tailscale debug filter-stats [--json] [--unused]",
+				ShortHelp:  "Print per-rule hit counters of the packet filter",
+				Exec:       runDebugFilterStats,
+				FlagSet: (func() *flag.FlagSet {
+					fs := newFlagSet("filter-stats")
+					fs.BoolVar(&filterStatsArgs.json, "json", false, "output in JSON format")
+					fs.BoolVar(&filterStatsArgs.unused, "unused", false, "only print rules that have never matched")
+					return fs
+				})(),
+			},
+			{
+				Name:       "filter-simulate",
+				ShortUsage: "tailscale debug filter-simulate [--matches] [--all] [--json] <rules.json>",
+				ShortHelp:  "Replay recent inbound flows against a candidate packet filter",
+				LongHelp: strings.TrimSpace(`
+The 'tailscale debug filter-simulate' command replays the new inbound flows
+recently seen by this node's packet filter against a candidate packet filter,
+and prints the flows whose verdict would change.
+
+The candidate is read from the named file, or from stdin if the file is "-".
+It is a JSON array of tailcfg.FilterRule values, as printed by
+'tailscale debug localapi POST debug-packet-filter-rules', or with --matches,
+a JSON array of filter matches, as printed by 'tailscale debug localapi POST
+debug-packet-filter-matches'.
+`),
+				Exec: runDebugFilterSimulate,
+				FlagSet: (func() *flag.FlagSet {
+					fs := newFlagSet("filter-simulate")
+					fs.BoolVar(&filterSimulateArgs.matches, "matches", false, "the file contains filter matches rather than filter rules")
+					fs.BoolVar(&filterSimulateArgs.all, "all", false, "print all flows, not just those whose verdict would change")
+					fs.BoolVar(&filterSimulateArgs.json, "json", false, "output in JSON format")
+					return fs
+				})(),
+			},
 			{
 				Name:       "peer-relay-servers",
 				ShortUsage: "tailscale debug peer-relay-servers",
@@ -382,10 +422,122 @@ func debugCmd() *ffcli.Command {
 				})(),
 			},
 			ccall(debugPeerRelayCmd),
+			ccall(debugSSHRecordingsCmd),
 		}...),
 	}
 }
 
+var filterStatsArgs struct {
+	json   bool
+	unused bool
+}
+
+func runDebugFilterStats(ctx context.Context, args []string) error {
+	if len(args) > 0 {
+		return errors.New("unexpected arguments")
+	}
+	st, err := localClient.DebugPacketFilterStats(ctx)
+	if err != nil {
+		return err
+	}
+	if filterStatsArgs.unused {
+		st.Rules = slices.DeleteFunc(st.Rules, func(rs filtertype.RuleStats) bool { return rs.Hits > 0 })
+	}
+	if filterStatsArgs.json {
+		e := json.NewEncoder(Stdout)
+		e.SetIndent("", "\t")
+		return e.Encode(st)
+	}
+
+	now := time.Now()
+	lastHit := func(hits uint64, t time.Time) string {
+		if hits == 0 {
+			return "-"
+		}
+		return now.Sub(t).Round(time.Second).String() + " ago"
+	}
+	tw := tabwriter.NewWriter(Stdout, 0, 2, 2, ' ', 0)
+	fmt.Fprintf(tw, "HITS\tLAST HIT\tRULE\n")
+	for _, rs := range st.Rules {
+		fmt.Fprintf(tw, "%d\t%s\t%s\n", rs.Hits, lastHit(rs.Hits, rs.LastHit), rs.Rule)
+	}
+	fmt.Fprintf(tw, "%d\t%s\t%s\n", st.NoMatch, lastHit(st.NoMatch, st.LastNoMatch), "(no rule matched; dropped)")
+	return tw.Flush()
+}
+
+var filterSimulateArgs struct {
+	matches bool
+	all     bool
+	json    bool
+}
+
+func runDebugFilterSimulate(ctx context.Context, args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: tailscale debug filter-simulate [flags] <rules.json>")
+	}
+	var data []byte
+	var err error
+	if args[0] == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(args[0])
+	}
+	if err != nil {
+		return err
+	}
+
+	var flows []filtertype.SimulatedFlow
+	if filterSimulateArgs.matches {
+		var matches []filtertype.Match
+		if err := json.Unmarshal(data, &matches); err != nil {
+			return fmt.Errorf("parsing matches: %w", err)
+		}
+		flows, err = localClient.DebugSimulatePacketFilterMatches(ctx, matches)
+	} else {
+		var rules []tailcfg.FilterRule
+		if err := json.Unmarshal(data, &rules); err != nil {
+			return fmt.Errorf("parsing filter rules: %w", err)
+		}
+		flows, err = localClient.DebugSimulatePacketFilterRules(ctx, rules)
+	}
+	if err != nil {
+		return err
+	}
+	if !filterSimulateArgs.all {
+		flows = slices.DeleteFunc(flows, func(f filtertype.SimulatedFlow) bool { return f.WasAccepted == f.WouldAccept })
+	}
+	if filterSimulateArgs.json {
+		e := json.NewEncoder(Stdout)
+		e.SetIndent("", "\t")
+		return e.Encode(flows)
+	}
+	if len(flows) == 0 {
+		outln("No recent flows would change verdict.")
+		return nil
+	}
+
+	verdict := func(accept bool) string {
+		if accept {
+			return "accept"
+		}
+		return "drop"
+	}
+	now := time.Now()
+	tw := tabwriter.NewWriter(Stdout, 0, 2, 2, ' ', 0)
+	fmt.Fprintf(tw, "CHANGE\tPROTO\tSRC\tDST\tLAST SEEN\n")
+	for _, f := range flows {
+		change := verdict(f.WasAccepted)
+		switch {
+		case f.WouldAccept && !f.WasAccepted:
+			change = "newly allowed"
+		case !f.WouldAccept && f.WasAccepted:
+			change = "newly denied"
+		}
+		fmt.Fprintf(tw, "%s\t%v\t%v\t%v\t%v ago\n", change, f.Proto, f.Src, f.Dst, now.Sub(f.LastSeen).Round(time.Second))
+	}
+	return tw.Flush()
+}
+
 func runGoBuildInfo(ctx context.Context, args []string) error {
 	bi, ok := debug.ReadBuildInfo()
 	if !ok {
@@ -0,0 +1,94 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package cli
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/peterbourgon/ff/v3/ffcli"
+	"tailscale.com/client/tailscale/apitype"
+)
+
+var dnsCacheCmd = &ffcli.Command{
+	Name:       "cache",
+	ShortUsage: "tailscale dns cache [--flush]",
+	Exec:       runDNSCache,
+	ShortHelp:  "Print or flush the DNS forwarder's response cache",
+	LongHelp: strings.TrimSpace(`
+The 'tailscale dns cache' subcommand prints the responses that the internal DNS
+forwarder (100.100.100.100) has cached from upstream resolvers, along with the
+cache hit rate.
+
+Responses are cached for no longer than their records' TTLs. Negative responses
+(NXDOMAIN and NODATA) are cached as specified by RFC 2308.
+
+The --flush flag removes all cached responses.
+`),
+	FlagSet: (func() *flag.FlagSet {
+		fs := newFlagSet("cache")
+		fs.BoolVar(&dnsCacheArgs.flush, "flush", false, "remove all cached responses")
+		return fs
+	})(),
+}
+
+// dnsCacheArgs are the arguments for the "dns cache" subcommand.
+var dnsCacheArgs struct {
+	flush bool
+}
+
+func runDNSCache(ctx context.Context, args []string) error {
+	if len(args) > 0 {
+		return flag.ErrHelp
+	}
+	if dnsCacheArgs.flush {
+		if err := localClient.FlushDNSCache(ctx); err != nil {
+			return err
+		}
+		fmt.Println("DNS cache flushed.")
+		return nil
+	}
+	c, err := localClient.DNSCache(ctx, true)
+	if err != nil {
+		return err
+	}
+	if !c.Enabled {
+		fmt.Println("The DNS response cache is disabled.")
+		return nil
+	}
+	fmt.Println(dnsCacheSummary(c))
+	if len(c.Responses) == 0 {
+		return nil
+	}
+	fmt.Println()
+	now := time.Now()
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tTYPE\tRESPONSE\tROUTE\tEXPIRES")
+	for _, e := range c.Responses {
+		route := e.Route
+		if route == "" {
+			route = "-"
+		}
+		res := strings.TrimPrefix(e.RCode, "RCode")
+		if e.Negative && res == "Success" {
+			res = "NoData"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\tin %v\n", e.Name, e.Type, res, route, e.Expires.Sub(now).Round(time.Second))
+	}
+	return w.Flush()
+}
+
+// dnsCacheSummary returns a one-line summary of c's size and hit rate.
+func dnsCacheSummary(c *apitype.DNSCache) string {
+	rate := "n/a"
+	if total := c.Hits + c.Misses; total > 0 {
+		rate = fmt.Sprintf("%.1f%%", float64(c.Hits)*100/float64(total))
+	}
+	return fmt.Sprintf("%d/%d responses cached; %d hits, %d misses (hit rate: %s)", c.Entries, c.MaxEntries, c.Hits, c.Misses, rate)
+}
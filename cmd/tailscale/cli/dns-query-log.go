@@ -0,0 +1,141 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/peterbourgon/ff/v3/ffcli"
+	"tailscale.com/client/tailscale/apitype"
+)
+
+var dnsQueryLogCmd = &ffcli.Command{
+	Name:       "query-log",
+	ShortUsage: "tailscale dns query-log [--follow] [--json]",
+	Exec:       runDNSQueryLog,
+	ShortHelp:  "Print the log of DNS queries answered by the internal DNS resolver",
+	LongHelp: strings.TrimSpace(`
+The 'tailscale dns query-log' subcommand prints the DNS queries recently answered
+by the internal DNS resolver (100.100.100.100): when each query was received,
+where it came from, the name and type queried, the DNS route and upstream
+resolver it was forwarded to, the response code, and how long it took.
+
+Query logging is off by default. It's enabled while 'tailscale dns query-log
+--follow' is running, and always if the DNSQueryLog system policy is enabled,
+which logs queries to a rotating file in tailscaled's state directory, or if
+tailscaled is run with TS_DNS_QUERY_LOG_FILE set to the path of a file to log
+queries to.
+
+Logged queries stay on the device, unless tailscaled is run with
+TS_DNS_QUERY_LOG_LOGTAIL=1, which uploads them to a log collection of their
+own, separate from tailscaled's logs, on the logging service tailscaled uses
+or the logtail server at TS_DNS_QUERY_LOG_LOGTAIL_URL.
+
+The --follow flag prints queries as they're answered, until interrupted.
+`),
+	FlagSet: (func() *flag.FlagSet {
+		fs := newFlagSet("query-log")
+		fs.BoolVar(&dnsQueryLogArgs.follow, "follow", false, "print queries as they are answered")
+		fs.BoolVar(&dnsQueryLogArgs.json, "json", false, "output in JSON format, one query per line")
+		return fs
+	})(),
+}
+
+// dnsQueryLogArgs are the arguments for the "dns query-log" subcommand.
+var dnsQueryLogArgs struct {
+	follow bool
+	json   bool
+}
+
+func runDNSQueryLog(ctx context.Context, args []string) error {
+	if len(args) > 0 {
+		return flag.ErrHelp
+	}
+	var print func(apitype.DNSQueryLogEntry) error
+	var w *tabwriter.Writer
+	if dnsQueryLogArgs.json {
+		enc := json.NewEncoder(os.Stdout)
+		print = func(e apitype.DNSQueryLogEntry) error { return enc.Encode(e) }
+	} else {
+		w = tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "TIME\tSOURCE\tNAME\tTYPE\tROUTE\tUPSTREAM\tRESPONSE\tLATENCY")
+		print = func(e apitype.DNSQueryLogEntry) error {
+			fmt.Fprintln(w, dnsQueryLogLine(e))
+			if dnsQueryLogArgs.follow {
+				return w.Flush()
+			}
+			return nil
+		}
+	}
+
+	if !dnsQueryLogArgs.follow {
+		entries, err := localClient.DNSQueryLog(ctx)
+		if err != nil {
+			return err
+		}
+		if len(entries) == 0 && !dnsQueryLogArgs.json {
+			fmt.Println("No DNS queries logged. Query logging is only enabled while following the log (--follow) or if configured in tailscaled.")
+			return nil
+		}
+		for _, e := range entries {
+			if err := print(e); err != nil {
+				return err
+			}
+		}
+		if w != nil {
+			return w.Flush()
+		}
+		return nil
+	}
+
+	if w != nil {
+		w.Flush()
+	}
+	for e, err := range localClient.StreamDNSQueryLog(ctx) {
+		if err != nil {
+			return err
+		}
+		if err := print(e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// dnsQueryLogLine returns e formatted as a tab-separated line for the
+// query-log table.
+func dnsQueryLogLine(e apitype.DNSQueryLogEntry) string {
+	source := e.Source
+	if e.Local {
+		source = "local"
+	} else if e.Peer != "" {
+		source = e.Peer
+	}
+	route, upstream := "-", "-"
+	if e.Forwarded {
+		route = e.Route
+		if route == "" {
+			route = "."
+		}
+		upstream = e.Upstream
+		if e.Cached {
+			upstream = "(cached)"
+		} else if upstream == "" {
+			upstream = "-"
+		}
+	}
+	res := e.RCode
+	if e.Error != "" {
+		res = "error: " + e.Error
+	}
+	return fmt.Sprintf("%s\t%s\t%s\t%s\t%s\t%s\t%s\t%v",
+		e.Time.Local().Format(time.TimeOnly), source, e.Name, e.Type, route, upstream, res, e.Latency.Round(time.Microsecond))
+}
@@ -29,6 +29,8 @@
 
 - The MagicDNS configuration provided by the coordination server.
 
+- The DNS forwarder's response cache hit rate.
+
 - Details on which resolver(s) Tailscale believes the system is using by
   default.
 
@@ -209,6 +211,17 @@ func runDNSStatus(ctx context.Context, args []string) error {
 		fmt.Print("\n")
 	}
 
+	fmt.Println("=== DNS forwarder cache ===")
+	fmt.Print("\n")
+	if c, err := localClient.DNSCache(ctx, false); err != nil {
+		fmt.Printf("  (failed to read DNS cache state: %v)\n", err)
+	} else if !c.Enabled {
+		fmt.Println("  (the DNS response cache is disabled)")
+	} else {
+		fmt.Println(dnsCacheSummary(c))
+		fmt.Println("Run 'tailscale dns cache' to list cached responses.")
+	}
+	fmt.Print("\n")
 	fmt.Println("=== System DNS configuration ===")
 	fmt.Print("\n")
 	fmt.Println("This is the DNS configuration that Tailscale believes your operating system is using.\nTailscale may use this configuration if 'Override Local DNS' is disabled in the admin console,\nor if no resolvers are provided by the coordination server.")
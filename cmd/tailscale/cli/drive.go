@@ -7,6 +7,7 @@
 
 import (
 	"context"
+	"flag"
 	"fmt"
 	"path/filepath"
 	"strings"
@@ -16,12 +17,19 @@
 )
 
 const (
-	driveShareUsage   = "tailscale drive share <name> <path>"
+	driveShareUsage   = "tailscale drive share [flags] <name> <path>"
 	driveRenameUsage  = "tailscale drive rename <oldname> <newname>"
 	driveUnshareUsage = "tailscale drive unshare <name>"
 	driveListUsage    = "tailscale drive list"
 )
 
+var driveShareArgs struct {
+	readOnly     bool
+	allowUsers   string
+	allowTags    string
+	hideDotFiles bool
+}
+
 func init() {
 	maybeDriveCmd = driveCmd
 }
@@ -44,6 +52,14 @@ func driveCmd() *ffcli.Command {
 				ShortUsage: driveShareUsage,
 				Exec:       runDriveShare,
 				ShortHelp:  "[ALPHA] Create or modify a share",
+				FlagSet: (func() *flag.FlagSet {
+					fs := newFlagSet("share")
+					fs.BoolVar(&driveShareArgs.readOnly, "read-only", false, "prevent modifying the share, even by nodes with read-write access")
+					fs.StringVar(&driveShareArgs.allowUsers, "allow-users", "", "comma-separated login names of the users whose untagged nodes may access the share")
+					fs.StringVar(&driveShareArgs.allowTags, "allow-tags", "", "comma-separated tags of the nodes that may access the share")
+					fs.BoolVar(&driveShareArgs.hideDotFiles, "hide-dotfiles", false, "hide files and directories whose names start with a dot")
+					return fs
+				})(),
 			},
 			{
 				Name:       "rename",
@@ -81,8 +97,12 @@ func runDriveShare(ctx context.Context, args []string) error {
 	}
 
 	err = localClient.DriveShareSet(ctx, &drive.Share{
-		Name: name,
-		Path: absolutePath,
+		Name:         name,
+		Path:         absolutePath,
+		ReadOnly:     driveShareArgs.readOnly,
+		AllowedUsers: splitDriveShareList(driveShareArgs.allowUsers),
+		AllowedTags:  splitDriveShareList(driveShareArgs.allowTags),
+		HideDotFiles: driveShareArgs.hideDotFiles,
 	})
 	if err == nil {
 		fmt.Printf("Sharing %q as %q\n", path, name)
@@ -90,6 +110,17 @@ func runDriveShare(ctx context.Context, args []string) error {
 	return err
 }
 
+// splitDriveShareList splits a comma-separated flag value into its elements.
+func splitDriveShareList(s string) []string {
+	var list []string
+	for v := range strings.SplitSeq(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			list = append(list, v)
+		}
+	}
+	return list
+}
+
 // runDriveUnshare is the entry point for the "tailscale drive unshare" command.
 func runDriveUnshare(ctx context.Context, args []string) error {
 	if len(args) != 1 {
@@ -225,6 +256,12 @@ func buildShareLongHelp() string {
 	  }
 	}]
 
+Shares can further restrict access on top of the ACLs. For example, to make the docs share read-only even for nodes granted read-write access, and to only allow access from nodes owned by alice@example.com or tagged with tag:backup, you would run:
+
+  $ tailscale drive share --read-only --allow-users=alice@example.com --allow-tags=tag:backup docs /Users/me/Documents
+
+Files and directories whose names start with a dot can be hidden from other machines with --hide-dotfiles.
+
 You can rename shares, for example you could rename the above share by running:
 
   $ tailscale drive rename docs newdocs
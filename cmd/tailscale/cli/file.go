@@ -12,6 +12,7 @@
 	"flag"
 	"fmt"
 	"io"
+	"io/fs"
 	"log"
 	"mime"
 	"net/http"
@@ -69,7 +70,7 @@ func (c *countingReader) Read(buf []byte) (int, error) {
 
 var fileCpCmd = &ffcli.Command{
 	Name:       "cp",
-	ShortUsage: "tailscale file cp <files...> <target>:",
+	ShortUsage: "tailscale file cp [-r] <files...> <target>:",
 	ShortHelp:  "Copy file(s) to a host",
 	Exec:       runCp,
 	FlagSet: (func() *flag.FlagSet {
@@ -77,14 +78,16 @@ func (c *countingReader) Read(buf []byte) (int, error) {
 		fs.StringVar(&cpArgs.name, "name", "", "alternate filename to use, especially useful when <file> is \"-\" (stdin)")
 		fs.BoolVar(&cpArgs.verbose, "verbose", false, "verbose output")
 		fs.BoolVar(&cpArgs.targets, "targets", false, "list possible file cp targets")
+		fs.BoolVar(&cpArgs.recursive, "r", false, "copy directories recursively")
 		return fs
 	})(),
 }
 
 var cpArgs struct {
-	name    string
-	verbose bool
-	targets bool
+	name      string
+	verbose   bool
+	targets   bool
+	recursive bool
 }
 
 func runCp(ctx context.Context, args []string) error {
@@ -159,7 +162,16 @@ func runCp(ctx context.Context, args []string) error {
 				return err
 			}
 			if fi.IsDir() {
-				return errors.New("directories not supported")
+				if !cpArgs.recursive {
+					return errors.New("directories not supported without -r")
+				}
+				if name == "" {
+					name = filepath.Base(fileArg)
+				}
+				if err := sendDir(ctx, stableID, fileArg, name); err != nil {
+					return err
+				}
+				continue
 			}
 			contentLength = fi.Size()
 			fileContents = &countingReader{Reader: io.LimitReader(f, contentLength)}
@@ -176,26 +188,105 @@ func runCp(ctx context.Context, args []string) error {
 			log.Printf("sending %q to %v/%v/%v ...", name, target, ip, stableID)
 		}
 
-		var group sync.WaitGroup
-		ctxProgress, cancelProgress := context.WithCancel(ctx)
-		defer cancelProgress()
-		if isatty.IsTerminal(os.Stderr.Fd()) {
-			group.Go(func() { progressPrinter(ctxProgress, name, fileContents.n.Load, contentLength) })
+		if err := pushFile(ctx, stableID, name, fileContents, contentLength); err != nil {
+			return err
 		}
+		if cpArgs.verbose {
+			log.Printf("sent %q", name)
+		}
+	}
+	return nil
+}
+
+// pushFile sends contents to the node stableID as the named file,
+// printing its progress if stderr is a terminal.
+func pushFile(ctx context.Context, stableID tailcfg.StableNodeID, name string, contents *countingReader, contentLength int64) error {
+	var group sync.WaitGroup
+	ctxProgress, cancelProgress := context.WithCancel(ctx)
+	defer cancelProgress()
+	if isatty.IsTerminal(os.Stderr.Fd()) {
+		group.Go(func() { progressPrinter(ctxProgress, name, contents.n.Load, contentLength) })
+	}
 
-		err := localClient.PushFile(ctx, stableID, contentLength, name, fileContents)
-		cancelProgress()
-		group.Wait() // wait for progress printer to stop before reporting the error
+	err := localClient.PushFile(ctx, stableID, contentLength, name, contents)
+	cancelProgress()
+	group.Wait() // wait for progress printer to stop before reporting the error
+	return err
+}
+
+// sendDir sends the regular files within the directory root and its
+// subdirectories to the node stableID, as a directory with the given name.
+// The receiver makes the files available once all of them have been sent.
+func sendDir(ctx context.Context, stableID tailcfg.StableNodeID, root, name string) error {
+	var manifest apitype.TaildropDirManifest
+	err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
 		if err != nil {
 			return err
 		}
-		if cpArgs.verbose {
-			log.Printf("sent %q", name)
+		if d.IsDir() {
+			return nil
+		}
+		if !d.Type().IsRegular() {
+			fmt.Fprintf(Stderr, "# warning: skipping %s: not a regular file\n", p)
+			return nil
+		}
+		fi, err := d.Info()
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return err
 		}
+		manifest.Files = append(manifest.Files, apitype.TaildropDirFile{
+			Name: filepath.ToSlash(rel),
+			Size: fi.Size(),
+		})
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if cpArgs.verbose {
+		log.Printf("sending directory %q of %d files ...", name, len(manifest.Files))
+	}
+	if err := localClient.PushFileDir(ctx, stableID, name, manifest); err != nil {
+		return err
+	}
+	for _, df := range manifest.Files {
+		if err := sendDirFile(ctx, stableID, filepath.Join(root, filepath.FromSlash(df.Name)), name+"/"+df.Name, df.Size); err != nil {
+			return err
+		}
+	}
+	if cpArgs.verbose {
+		log.Printf("sent directory %q", name)
 	}
 	return nil
 }
 
+// sendDirFile sends the file at filePath as the named file within a
+// directory being sent, checking that it still has the size listed in
+// the directory's manifest.
+func sendDirFile(ctx context.Context, stableID tailcfg.StableNodeID, filePath, name string, size int64) error {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if fi.Size() != size {
+		return fmt.Errorf("%s changed size while sending directory", filePath)
+	}
+	if cpArgs.verbose {
+		log.Printf("sending %q ...", name)
+	}
+	return pushFile(ctx, stableID, name, &countingReader{Reader: io.LimitReader(f, size)}, size)
+}
+
 func progressPrinter(ctx context.Context, name string, contentCount func() int64, contentLength int64) {
 	var rateValueFast, rateValueSlow tsrate.Value
 	rateValueFast.HalfLife = 1 * time.Second  // fast response for rate measurement
@@ -526,7 +617,16 @@ func receiveFile(ctx context.Context, wf apitype.WaitingFile, dir string) (targe
 		return "", 0, fmt.Errorf("opening inbox file %q: %w", wf.Name, err)
 	}
 	defer rc.Close()
-	f, err := openFileOrSubstitute(dir, wf.Name, getArgs.conflict)
+	if parent := path.Dir(wf.Name); parent != "." {
+		// A file within a received directory.
+		if !filepath.IsLocal(filepath.FromSlash(wf.Name)) {
+			return "", 0, fmt.Errorf("invalid inbox file name %q", wf.Name)
+		}
+		if err := os.MkdirAll(filepath.Join(dir, filepath.FromSlash(parent)), 0755); err != nil {
+			return "", 0, err
+		}
+	}
+	f, err := openFileOrSubstitute(dir, filepath.FromSlash(wf.Name), getArgs.conflict)
 	if err != nil {
 		return "", 0, err
 	}
@@ -0,0 +1,142 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build !ts_omit_tailnetlock
+
+package cli
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/peterbourgon/ff/v3/ffcli"
+	"tailscale.com/tka"
+	"tailscale.com/types/key"
+)
+
+// nlSignerArg is the --signer flag of the lock subcommands that sign.
+var nlSignerArg string
+
+// addNLSignerFlag adds the --signer flag to fs.
+func addNLSignerFlag(fs *flag.FlagSet) {
+	fs.StringVar(&nlSignerArg, "signer", "", `command to run as an external signer holding the tailnet lock key to sign with, instead of signing with this node's key; see "tailscale lock soft-signer --help"`)
+}
+
+// startExternalSigner runs command, split on spaces, as an external signer
+// process. The returned stop func stops the signer and waits for it to
+// exit.
+func startExternalSigner(ctx context.Context, command string) (_ *tka.ExternalSigner, stop func() error, _ error) {
+	args := strings.Fields(command)
+	if len(args) == 0 {
+		return nil, nil, errors.New("empty signer command")
+	}
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Stderr = os.Stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, nil, fmt.Errorf("starting signer: %w", err)
+	}
+	stop = func() error {
+		stdin.Close()
+		return cmd.Wait()
+	}
+	s, err := tka.NewExternalSigner(stdout, stdin)
+	if err != nil {
+		stop()
+		return nil, nil, err
+	}
+	return s, stop, nil
+}
+
+// withExternalSigner runs f with the external signer from --signer.
+func withExternalSigner(ctx context.Context, f func(*tka.ExternalSigner) error) error {
+	s, stop, err := startExternalSigner(ctx, nlSignerArg)
+	if err != nil {
+		return err
+	}
+	err = f(s)
+	if serr := stop(); serr != nil && err == nil {
+		err = fmt.Errorf("signer: %w", serr)
+	}
+	return err
+}
+
+// nlModifyWithSigner adds and/or removes trusted keys, signing the change
+// with signer.
+func nlModifyWithSigner(ctx context.Context, signer tka.Signer, addKeys, removeKeys []tka.Key) error {
+	aums, err := localClient.NetworkLockGenerateModify(ctx, addKeys, removeKeys)
+	if err != nil {
+		return err
+	}
+	if len(aums) == 0 {
+		return nil
+	}
+	if err := tka.SignUpdates(aums, signer); err != nil {
+		return err
+	}
+	return localClient.NetworkLockSubmitModify(ctx, aums)
+}
+
+// nlSignWithSigner signs nodeKey with signer and submits the signature.
+func nlSignWithSigner(ctx context.Context, signer tka.NodeKeySigner, nodeKey key.NodePublic, rotationKey key.NLPublic) error {
+	var rotationPublic []byte
+	if !rotationKey.IsZero() {
+		rotationPublic = rotationKey.Verifier()
+	}
+	sig, err := tka.SignNodeKey(signer, nodeKey, rotationPublic)
+	if err != nil {
+		return err
+	}
+	return localClient.NetworkLockSubmitSignature(ctx, sig)
+}
+
+var nlSoftSignerCmd = &ffcli.Command{
+	Name:       "soft-signer",
+	ShortUsage: "tailscale lock soft-signer <key-file>",
+	ShortHelp:  "Act as an external signer for a tailnet lock key stored in a file",
+	LongHelp: strings.TrimSpace(`
+
+The 'tailscale lock soft-signer' command serves the external signer protocol
+on its standard input and output, signing with the tailnet lock private key
+(tlpriv:...) in the given file. It's a reference implementation of the
+protocol, for use with the --signer flag of 'tailscale lock sign', 'add' and
+'remove':
+
+  tailscale lock sign --signer="tailscale lock soft-signer key.txt" <node-key>
+
+External signers read requests and write responses as newline-delimited JSON
+objects. A request {"Op":"public"} is answered with {"Public":"tlpub:..."},
+and a request {"Op":"sign","Kind":"aum","Hash":"<base64>"} with
+{"Signature":"<base64>"}, the ed25519 signature of the hash. Kind is "aum" or
+"node-key". Failures are reported as {"Error":"..."}.
+
+`),
+	Exec: runNetworkLockSoftSigner,
+}
+
+func runNetworkLockSoftSigner(ctx context.Context, args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: tailscale lock soft-signer <key-file>")
+	}
+	b, err := os.ReadFile(args[0])
+	if err != nil {
+		return err
+	}
+	var priv key.NLPrivate
+	if err := priv.UnmarshalText([]byte(strings.TrimSpace(string(b)))); err != nil {
+		return fmt.Errorf("decoding key: %w", err)
+	}
+	return tka.ServeExternalSigner(os.Stdin, Stdout, priv)
+}
@@ -49,8 +49,10 @@ func init() {
 		nlDisableCmd,
 		nlDisablementKDFCmd,
 		nlLogCmd,
+		nlExportCmd,
 		nlLocalDisableCmd,
 		nlRevokeKeysCmd,
+		nlSoftSignerCmd,
 	},
 	Exec: runNetworkLockNoSubcommand,
 }
@@ -303,11 +305,16 @@ func runNetworkLockStatus(ctx context.Context, args []string) error {
 
 var nlAddCmd = &ffcli.Command{
 	Name:       "add",
-	ShortUsage: "tailscale lock add <public-key>...",
+	ShortUsage: "tailscale lock add [--signer=<command>] <public-key>...",
 	ShortHelp:  "Add one or more trusted signing keys to tailnet lock",
 	Exec: func(ctx context.Context, args []string) error {
 		return runNetworkLockModify(ctx, args, nil)
 	},
+	FlagSet: (func() *flag.FlagSet {
+		fs := newFlagSet("lock add")
+		addNLSignerFlag(fs)
+		return fs
+	})(),
 }
 
 var nlRemoveArgs struct {
@@ -316,12 +323,13 @@ func runNetworkLockStatus(ctx context.Context, args []string) error {
 
 var nlRemoveCmd = &ffcli.Command{
 	Name:       "remove",
-	ShortUsage: "tailscale lock remove [--re-sign=false] <public-key>...",
+	ShortUsage: "tailscale lock remove [--re-sign=false] [--signer=<command>] <public-key>...",
 	ShortHelp:  "Remove one or more trusted signing keys from tailnet lock",
 	Exec:       runNetworkLockRemove,
 	FlagSet: (func() *flag.FlagSet {
 		fs := newFlagSet("lock remove")
 		fs.BoolVar(&nlRemoveArgs.resign, "re-sign", true, "resign signatures which would be invalidated by removal of trusted signing keys")
+		addNLSignerFlag(fs)
 		return fs
 	})(),
 }
@@ -331,6 +339,17 @@ func runNetworkLockRemove(ctx context.Context, args []string) error {
 	if err != nil {
 		return err
 	}
+	if nlSignerArg != "" {
+		return withExternalSigner(ctx, func(s *tka.ExternalSigner) error {
+			return networkLockRemove(ctx, removeKeys, s)
+		})
+	}
+	return networkLockRemove(ctx, removeKeys, nil)
+}
+
+// networkLockRemove removes trust in removeKeys, signing with signer if
+// non-nil or else with this node's key.
+func networkLockRemove(ctx context.Context, removeKeys []tka.Key, signer *tka.ExternalSigner) error {
 	st, err := localClient.NetworkLockStatus(ctx)
 	if err != nil {
 		return fixTailscaledConnectError(err)
@@ -345,12 +364,19 @@ func runNetworkLockRemove(ctx context.Context, args []string) error {
 	if nlRemoveArgs.resign {
 		// Validate we are not removing trust in ourselves while resigning. This is because
 		// we resign with our own key, so the signatures would be immediately invalid.
+		ourKeyID := st.PublicKey.KeyID()
+		if signer != nil {
+			ourKeyID = signer.KeyID()
+		}
 		for _, k := range removeKeys {
 			kID, err := k.ID()
 			if err != nil {
 				return fmt.Errorf("computing KeyID for key %v: %w", k, err)
 			}
-			if bytes.Equal(st.PublicKey.KeyID(), kID) {
+			if bytes.Equal(ourKeyID, kID) {
+				if signer != nil {
+					return errors.New("cannot remove the signer's key while resigning; use a different signer or --re-sign=false")
+				}
 				return errors.New("cannot remove local trusted signing key while resigning; run command on a different node or with --re-sign=false")
 			}
 		}
@@ -376,6 +402,16 @@ func runNetworkLockRemove(ctx context.Context, args []string) error {
 				// Safety: NetworkLockAffectedSigs() verifies all signatures before
 				// successfully returning.
 				rotationKey, _ := sig.UnverifiedWrappingPublic()
+				if signer != nil {
+					newSig, err := tka.SignNodeKey(signer, nodeKey, rotationKey)
+					if err == nil {
+						err = localClient.NetworkLockSubmitSignature(ctx, newSig)
+					}
+					if err != nil {
+						return fmt.Errorf("failed to sign %v: %w", nodeKey, err)
+					}
+					continue
+				}
 				if err := localClient.NetworkLockSign(ctx, nodeKey, []byte(rotationKey)); err != nil {
 					return fmt.Errorf("failed to sign %v: %w", nodeKey, err)
 				}
@@ -395,6 +431,9 @@ func runNetworkLockRemove(ctx context.Context, args []string) error {
 		}
 	}
 
+	if signer != nil {
+		return nlModifyWithSigner(ctx, signer, nil, removeKeys)
+	}
 	return localClient.NetworkLockModify(ctx, nil, removeKeys)
 }
 
@@ -463,6 +502,11 @@ func runNetworkLockModify(ctx context.Context, addArgs, removeArgs []string) err
 		return err
 	}
 
+	if nlSignerArg != "" {
+		return withExternalSigner(ctx, func(s *tka.ExternalSigner) error {
+			return nlModifyWithSigner(ctx, s, addKeys, removeKeys)
+		})
+	}
 	if err := localClient.NetworkLockModify(ctx, addKeys, removeKeys); err != nil {
 		return err
 	}
@@ -471,7 +515,7 @@ func runNetworkLockModify(ctx context.Context, addArgs, removeArgs []string) err
 
 var nlSignCmd = &ffcli.Command{
 	Name:       "sign",
-	ShortUsage: "tailscale lock sign <node-key> [<rotation-key>]\ntailscale lock sign <auth-key>",
+	ShortUsage: "tailscale lock sign [--signer=<command>] <node-key> [<rotation-key>]\ntailscale lock sign <auth-key>",
 	ShortHelp:  "Sign a node or pre-approved auth key",
 	LongHelp: `Either:
   - signs a node key and transmits the signature to the coordination
@@ -480,8 +524,16 @@ func runNetworkLockModify(ctx context.Context, addArgs, removeArgs []string) err
     used to bring up nodes under tailnet lock
 
 If any of the key arguments begin with "file:", the key is retrieved from
-the file at the path specified in the argument suffix.`,
+the file at the path specified in the argument suffix.
+
+Node keys are signed with this node's tailnet lock key, unless --signer
+is given, in which case they're signed by that external signer instead.`,
 	Exec: runNetworkLockSign,
+	FlagSet: (func() *flag.FlagSet {
+		fs := newFlagSet("lock sign")
+		addNLSignerFlag(fs)
+		return fs
+	})(),
 }
 
 func runNetworkLockSign(ctx context.Context, args []string) error {
@@ -519,6 +571,12 @@ func runNetworkLockSign(ctx context.Context, args []string) error {
 		}
 	}
 
+	if nlSignerArg != "" {
+		return withExternalSigner(ctx, func(s *tka.ExternalSigner) error {
+			return nlSignWithSigner(ctx, s, nodeKey, rotationKey)
+		})
+	}
+
 	err := localClient.NetworkLockSign(ctx, nodeKey, []byte(rotationKey.Verifier()))
 	// Provide a better help message for when someone clicks through the signing flow
 	// on the wrong device.
@@ -731,6 +789,45 @@ func printNetworkLockLog(updates []ipnstate.NetworkLockUpdate, out io.Writer, js
 	return nil
 }
 
+var nlExportCmd = &ffcli.Command{
+	Name:       "export",
+	ShortUsage: "tailscale lock export [<file>]",
+	ShortHelp:  "Export the history of tailnet lock for offline auditing",
+	LongHelp: strings.TrimSpace(`
+
+The 'tailscale lock export' command writes the full chain of changes to
+tailnet lock known to this node to the given file, or to standard output,
+as JSON. The export can be verified and rendered without access to a node
+using the tl-audit command.
+
+`),
+	Exec: runNetworkLockExport,
+}
+
+func runNetworkLockExport(ctx context.Context, args []string) error {
+	if len(args) > 1 {
+		return errors.New("usage: tailscale lock export [<file>]")
+	}
+	export, err := localClient.NetworkLockExport(ctx)
+	if err != nil {
+		return fixTailscaledConnectError(err)
+	}
+	j, err := jsonv1.MarshalIndent(export, "", "\t")
+	if err != nil {
+		return err
+	}
+	j = append(j, '\n')
+	if len(args) == 0 {
+		Stdout.Write(j)
+		return nil
+	}
+	if err := os.WriteFile(args[0], j, 0644); err != nil {
+		return err
+	}
+	printf("Exported %d updates to %s\n", len(export.AUMs), args[0])
+	return nil
+}
+
 func runTskeyWrapCmd(ctx context.Context, args []string) error {
 	if len(args) != 1 {
 		return errors.New("usage: lock tskey-wrap <tailscale pre-auth key>")
@@ -23,6 +23,7 @@
 	"sort"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/peterbourgon/ff/v3/ffcli"
 	"tailscale.com/client/local"
@@ -176,6 +177,17 @@ type serveEnv struct {
 	tun              bool                     // redirect traffic to OS for service
 	allServices      bool                     // apply config file to all services
 	acceptAppCaps    []tailcfg.PeerCapability // app capabilities to forward
+	stripPrefix      string                   // path prefix to remove before proxying
+	addPrefix        string                   // path prefix to add before proxying
+	requestHeaders   map[string]string        // static headers to set on proxied requests
+	responseHeaders  map[string]string        // static headers to set on responses
+	identityHeaders  map[string]string        // renamed Tailscale identity headers
+	rateLimit        float64                  // per-source requests per second
+	rateLimitBurst   int                      // per-source burst size
+	backends         []string                 // additional backends to load-balance across
+	lbPolicy         string                   // load balancing policy
+	healthCheckEvery time.Duration            // active health check interval
+	healthCheckPath  string                   // active HTTP health check path
 
 	lc localServeClient // localClient interface, specific to serve
 	// optional stuff for tests:
@@ -13,8 +13,10 @@
 	"fmt"
 	"io"
 	"log"
+	"maps"
 	"math"
 	"net"
+	"net/http"
 	"net/url"
 	"os"
 	"os/signal"
@@ -33,6 +35,7 @@
 	"tailscale.com/ipn/conffile"
 	"tailscale.com/ipn/ipnstate"
 	"tailscale.com/tailcfg"
+	"tailscale.com/tstime"
 	"tailscale.com/types/ipproto"
 	"tailscale.com/util/dnsname"
 	"tailscale.com/util/mak"
@@ -134,6 +137,97 @@ func (u *acceptAppCapsFlag) String() string {
 	return strings.Join(s, ",")
 }
 
+// validHeaderName matches valid HTTP header field names (RFC 9110 tokens).
+var validHeaderName = regexp.MustCompile("^[!#$%&'*+\\-.^_`|~0-9A-Za-z]+$")
+
+// headerFlag is a flag.Value for a repeatable "Name: value" header flag.
+type headerFlag struct {
+	Value *map[string]string
+}
+
+// Set adds the "Name: value" header in s.
+func (f *headerFlag) Set(s string) error {
+	k, v, ok := strings.Cut(s, ":")
+	k = strings.TrimSpace(k)
+	if !ok || !validHeaderName.MatchString(k) {
+		return fmt.Errorf("invalid header %q; want the form \"Name: value\"", s)
+	}
+	v = strings.TrimSpace(v)
+	if strings.ContainsAny(v, "\r\n\x00") {
+		return fmt.Errorf("invalid value for header %q", k)
+	}
+	mak.Set(f.Value, http.CanonicalHeaderKey(k), v)
+	return nil
+}
+
+// String returns the headers as a comma-separated list.
+func (f *headerFlag) String() string {
+	var s []string
+	for _, k := range slices.Sorted(maps.Keys(*f.Value)) {
+		s = append(s, k+": "+(*f.Value)[k])
+	}
+	return strings.Join(s, ", ")
+}
+
+// identityHeaderNames are the Tailscale identity headers that can be renamed
+// with --identity-header.
+var identityHeaderNames = []string{
+	"Tailscale-User-Login",
+	"Tailscale-User-Name",
+	"Tailscale-User-Profile-Pic",
+}
+
+// identityHeaderFlag is a flag.Value for the repeatable --identity-header
+// flag, of the form "Tailscale-User-Login=X-Forwarded-User".
+type identityHeaderFlag struct {
+	Value *map[string]string
+}
+
+// Set adds the "default=new" header rename in s.
+func (f *identityHeaderFlag) Set(s string) error {
+	from, to, ok := strings.Cut(s, "=")
+	from = http.CanonicalHeaderKey(strings.TrimSpace(from))
+	to = strings.TrimSpace(to)
+	if !ok || !validHeaderName.MatchString(to) {
+		return fmt.Errorf("invalid identity header %q; want the form \"Tailscale-User-Login=X-Forwarded-User\"", s)
+	}
+	if !slices.Contains(identityHeaderNames, from) {
+		return fmt.Errorf("%q is not a Tailscale identity header; want one of %s", from, strings.Join(identityHeaderNames, ", "))
+	}
+	mak.Set(f.Value, from, http.CanonicalHeaderKey(to))
+	return nil
+}
+
+// String returns the header renames as a comma-separated list.
+func (f *identityHeaderFlag) String() string {
+	var s []string
+	for _, k := range slices.Sorted(maps.Keys(*f.Value)) {
+		s = append(s, k+"="+(*f.Value)[k])
+	}
+	return strings.Join(s, ",")
+}
+
+// backendsFlag is a flag.Value for the repeatable --backend flag, which
+// also accepts a comma-separated list.
+type backendsFlag struct {
+	Value *[]string
+}
+
+// Set appends the backends in s.
+func (f *backendsFlag) Set(s string) error {
+	for v := range strings.SplitSeq(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			*f.Value = append(*f.Value, v)
+		}
+	}
+	return nil
+}
+
+// String returns the backends as a comma-separated list.
+func (f *backendsFlag) String() string {
+	return strings.Join(*f.Value, ",")
+}
+
 var serveHelpCommon = strings.TrimSpace(`
 <target> can be a file, directory, text, or most commonly the location to a service running on the
 local machine. The location to the location service can be expressed as a port number (e.g., 3000),
@@ -244,7 +338,18 @@ func newServeV2Command(e *serveEnv, subcmd serveMode) *ffcli.Command {
 				fs.Var(&acceptAppCapsFlag{Value: &e.acceptAppCaps}, "accept-app-caps", "App capabilities to forward to the server (specify multiple capabilities with a comma-separated list)")
 				fs.Var(&serviceNameFlag{Value: &e.service}, "service", "Serve for a service with distinct virtual IP instead on node itself.")
 				fs.BoolVar(&e.tun, "tun", false, "Forward all traffic to the local machine (default false), only supported for services. Refer to docs for more information.")
+				fs.StringVar(&e.stripPrefix, "strip-prefix", "", "Path prefix to remove from requests (after the --set-path mount point) before proxying them")
+				fs.StringVar(&e.addPrefix, "add-prefix", "", "Path prefix to add to requests (after --strip-prefix is applied) before proxying them")
+				fs.Var(&headerFlag{Value: &e.requestHeaders}, "set-request-header", `Header to set on proxied requests, as "Name: value" (can be repeated)`)
+				fs.Var(&headerFlag{Value: &e.responseHeaders}, "set-response-header", `Header to set on responses, as "Name: value" (can be repeated)`)
+				fs.Var(&identityHeaderFlag{Value: &e.identityHeaders}, "identity-header", `Rename a Tailscale identity header sent to the proxied server, e.g. "Tailscale-User-Login=X-Forwarded-User" (can be repeated)`)
+				fs.Float64Var(&e.rateLimit, "rate-limit", 0, "Maximum requests per second accepted from each source node (default 0, no limit)")
+				fs.IntVar(&e.rateLimitBurst, "rate-limit-burst", 0, "Number of requests each source node may make at once before --rate-limit applies (default: the rate limit, rounded up)")
 			}
+			fs.Var(&backendsFlag{Value: &e.backends}, "backend", "Additional backend to load-balance across together with <target>, in the same form (can be repeated)")
+			fs.StringVar(&e.lbPolicy, "lb-policy", "", `Load balancing policy for --backend: "round-robin" (default) or "least-conn"`)
+			fs.DurationVar(&e.healthCheckEvery, "health-check-interval", 0, "How often to actively health check each backend when using --backend (default 0, passive checks only)")
+			fs.StringVar(&e.healthCheckPath, "health-check-path", "", "HTTP path requested by active health checks of web backends (default: check that a TCP connection succeeds)")
 			fs.UintVar(&e.tcp, "tcp", 0, "Expose a TCP forwarder to forward raw TCP packets at the specified port")
 			fs.UintVar(&e.tlsTerminatedTCP, "tls-terminated-tcp", 0, "Expose a TCP forwarder to forward TLS-terminated TCP packets at the specified port")
 			fs.UintVar(&e.proxyProtocol, "proxy-protocol", 0, "PROXY protocol version (1 or 2) for TCP forwarding")
@@ -1190,7 +1295,35 @@ func (e *serveEnv) applyWebServe(sc *ipn.ServeConfig, dnsName string, srvPort ui
 		}
 		h.Proxy = t
 		h.AcceptAppCaps = caps
+		for _, be := range e.backends {
+			t, err := ipn.ExpandProxyTargetValue(be, []string{"http", "https", "https+insecure", "unix"}, "http")
+			if err != nil {
+				return fmt.Errorf("invalid backend %q: %w", be, err)
+			}
+			h.Backends = append(h.Backends, t)
+		}
+		lb, err := e.loadBalancer()
+		if err != nil {
+			return err
+		}
+		h.LoadBalancer = lb
+		h.StripPrefix = e.stripPrefix
+		h.AddPrefix = e.addPrefix
+		h.SetRequestHeaders = e.requestHeaders
+		h.IdentityHeaders = e.identityHeaders
 	}
+	if h.Proxy == "" && len(e.backends) > 0 {
+		return errors.New("--backend can only be used when serving a proxy target")
+	}
+	if h.Proxy == "" && (e.stripPrefix != "" || e.addPrefix != "" || len(e.requestHeaders) > 0 || len(e.identityHeaders) > 0) {
+		return errors.New("--strip-prefix, --add-prefix, --set-request-header and --identity-header can only be used when serving a proxy target")
+	}
+	if e.rateLimit < 0 || e.rateLimitBurst < 0 {
+		return errors.New("--rate-limit and --rate-limit-burst must not be negative")
+	}
+	h.SetResponseHeaders = e.responseHeaders
+	h.RateLimit = e.rateLimit
+	h.RateLimitBurst = e.rateLimitBurst
 
 	// TODO: validation needs to check nested foreground configs
 	svcName := tailcfg.AsServiceName(dnsName)
@@ -1230,22 +1363,79 @@ func (e *serveEnv) applyTCPServe(sc *ipn.ServeConfig, dnsName string, srcType se
 		return fmt.Errorf("cannot serve TCP; already serving web on %d for %s", srcPort, dnsName)
 	}
 
+	var backends []string
+	for _, be := range e.backends {
+		beURL, err := ipn.ExpandProxyTargetValue(be, []string{"tcp"}, "tcp")
+		if err == nil {
+			var u *url.URL
+			u, err = url.Parse(beURL)
+			if err == nil {
+				backends = append(backends, u.Host)
+			}
+		}
+		if err != nil {
+			return fmt.Errorf("invalid backend %q: %v", be, err)
+		}
+	}
+	lb, err := e.loadBalancer()
+	if err != nil {
+		return err
+	}
+	setBackends := func() {
+		if h := sc.GetTCPPortHandler(srcPort, svcName); h != nil && len(backends) > 0 {
+			h.Backends = backends
+			h.LoadBalancer = lb
+		}
+	}
+
 	// TODO: needs to account for multiple configs from foreground mode
 	if svcName := tailcfg.AsServiceName(dnsName); svcName != "" {
 		sc.SetTCPForwardingForService(srcPort, dstURL.Host, terminateTLS, svcName, proxyProtocol, mds)
+		setBackends()
 		return nil
 	}
 
 	// TODO: needs to account for multiple configs from foreground mode
 	if svcName != "" {
 		sc.SetTCPForwardingForService(srcPort, dstURL.Host, terminateTLS, svcName, proxyProtocol, mds)
+		setBackends()
 		return nil
 	}
 
 	sc.SetTCPForwarding(srcPort, dstURL.Host, terminateTLS, proxyProtocol, dnsName)
+	setBackends()
 	return nil
 }
 
+// loadBalancer returns the LoadBalancer configured by the --lb-policy and
+// --health-check-* flags. It returns an error if they are invalid, or set
+// without --backend.
+func (e *serveEnv) loadBalancer() (ipn.LoadBalancer, error) {
+	lb := ipn.LoadBalancer{
+		Policy:              e.lbPolicy,
+		HealthCheckInterval: tstime.GoDuration{Duration: e.healthCheckEvery},
+		HealthCheckPath:     e.healthCheckPath,
+	}
+	if len(e.backends) == 0 {
+		if lb != (ipn.LoadBalancer{}) {
+			return lb, errors.New("--lb-policy, --health-check-interval and --health-check-path require --backend")
+		}
+		return lb, nil
+	}
+	switch lb.Policy {
+	case "", ipn.LoadBalanceRoundRobin, ipn.LoadBalanceLeastConn:
+	default:
+		return lb, fmt.Errorf("invalid --lb-policy %q; want %q or %q", lb.Policy, ipn.LoadBalanceRoundRobin, ipn.LoadBalanceLeastConn)
+	}
+	if e.healthCheckEvery < 0 {
+		return lb, errors.New("--health-check-interval must not be negative")
+	}
+	if lb.HealthCheckPath != "" && !strings.HasPrefix(lb.HealthCheckPath, "/") {
+		return lb, errors.New("--health-check-path must start with /")
+	}
+	return lb, nil
+}
+
 func (e *serveEnv) applyFunnel(sc *ipn.ServeConfig, dnsName string, srvPort uint16, allowFunnel bool) {
 	hp := ipn.HostPort(net.JoinHostPort(dnsName, strconv.Itoa(int(srvPort))))
 
@@ -17,12 +17,14 @@
 	"strconv"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/google/go-cmp/cmp"
 	"github.com/peterbourgon/ff/v3/ffcli"
 	"tailscale.com/ipn"
 	"tailscale.com/ipn/ipnstate"
 	"tailscale.com/tailcfg"
+	"tailscale.com/tstime"
 	"tailscale.com/types/views"
 )
 
@@ -922,6 +924,110 @@ type group struct {
 				},
 			},
 		},
+		{
+			name: "proxy_rewrite_headers_and_rate_limit",
+			steps: []step{{
+				command: cmd("serve --bg --set-path=/app --strip-prefix=/v1 --add-prefix=/api --set-request-header=x-env:prod --set-response-header=Server:ts --identity-header=Tailscale-User-Login=X-Forwarded-User --rate-limit=10 --rate-limit-burst=20 3000"),
+				want: &ipn.ServeConfig{
+					TCP: map[uint16]*ipn.TCPPortHandler{443: {HTTPS: true}},
+					Web: map[ipn.HostPort]*ipn.WebServerConfig{
+						"foo.test.ts.net:443": {Handlers: map[string]*ipn.HTTPHandler{
+							"/app": {
+								Proxy:              "http://127.0.0.1:3000",
+								StripPrefix:        "/v1",
+								AddPrefix:          "/api",
+								SetRequestHeaders:  map[string]string{"X-Env": "prod"},
+								SetResponseHeaders: map[string]string{"Server": "ts"},
+								IdentityHeaders:    map[string]string{"Tailscale-User-Login": "X-Forwarded-User"},
+								RateLimit:          10,
+								RateLimitBurst:     20,
+							},
+						}},
+					},
+				},
+			}},
+		},
+		{
+			name: "text_with_response_header",
+			steps: []step{{
+				command: cmd("serve --bg --set-response-header=Cache-Control:no-store text:hi"),
+				want: &ipn.ServeConfig{
+					TCP: map[uint16]*ipn.TCPPortHandler{443: {HTTPS: true}},
+					Web: map[ipn.HostPort]*ipn.WebServerConfig{
+						"foo.test.ts.net:443": {Handlers: map[string]*ipn.HTTPHandler{
+							"/": {
+								Text:               "hi",
+								SetResponseHeaders: map[string]string{"Cache-Control": "no-store"},
+							},
+						}},
+					},
+				},
+			}},
+		},
+		{
+			name: "strip_prefix_requires_proxy",
+			steps: []step{{
+				command: cmd("serve --bg --strip-prefix=/v1 text:hi"),
+				wantErr: anyErr(),
+			}},
+		},
+		{
+			name: "invalid_identity_header",
+			steps: []step{{
+				command: cmd("serve --bg --identity-header=X-Foo=X-Bar 3000"),
+				wantErr: anyErr(),
+			}},
+		},
+		{
+			name: "proxy_load_balanced",
+			steps: []step{{
+				command: cmd("serve --bg --backend=3001 --backend=localhost:3002 --lb-policy=least-conn --health-check-interval=5s --health-check-path=/healthz 3000"),
+				want: &ipn.ServeConfig{
+					TCP: map[uint16]*ipn.TCPPortHandler{443: {HTTPS: true}},
+					Web: map[ipn.HostPort]*ipn.WebServerConfig{
+						"foo.test.ts.net:443": {Handlers: map[string]*ipn.HTTPHandler{
+							"/": {
+								Proxy:    "http://127.0.0.1:3000",
+								Backends: []string{"http://127.0.0.1:3001", "http://localhost:3002"},
+								LoadBalancer: ipn.LoadBalancer{
+									Policy:              ipn.LoadBalanceLeastConn,
+									HealthCheckInterval: tstime.GoDuration{Duration: 5 * time.Second},
+									HealthCheckPath:     "/healthz",
+								},
+							},
+						}},
+					},
+				},
+			}},
+		},
+		{
+			name: "tcp_load_balanced",
+			steps: []step{{
+				command: cmd("serve --tcp=5432 --bg --backend=tcp://localhost:5433 tcp://localhost:5432"),
+				want: &ipn.ServeConfig{
+					TCP: map[uint16]*ipn.TCPPortHandler{
+						5432: {
+							TCPForward: "localhost:5432",
+							Backends:   []string{"localhost:5433"},
+						},
+					},
+				},
+			}},
+		},
+		{
+			name: "invalid_lb_policy",
+			steps: []step{{
+				command: cmd("serve --bg --backend=3001 --lb-policy=random 3000"),
+				wantErr: anyErr(),
+			}},
+		},
+		{
+			name: "lb_policy_without_backends",
+			steps: []step{{
+				command: cmd("serve --bg --lb-policy=least-conn 3000"),
+				wantErr: anyErr(),
+			}},
+		},
 		{
 			name: "tcp_with_proxy_protocol_v1",
 			steps: []step{{
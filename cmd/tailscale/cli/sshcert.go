@@ -0,0 +1,109 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package cli
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/peterbourgon/ff/v3/ffcli"
+	"tailscale.com/client/tailscale/apitype"
+)
+
+var sshCertCmd = &ffcli.Command{
+	Name:       "ssh-cert",
+	ShortUsage: "tailscale ssh-cert [flags] <host>",
+	ShortHelp:  "Get an OpenSSH user certificate from a Tailscale machine",
+	LongHelp: strings.TrimSpace(`
+
+The 'tailscale ssh-cert' command gets an OpenSSH user certificate for one of
+your SSH public keys from a Tailscale machine acting as an SSH user CA (one
+running tailscaled with TS_SSH_USER_CA=1).
+
+The certificate permits access as those of the requested users that the
+tailnet's SSH policy lets this machine access the CA's machine as. It's
+accepted by the CA's Tailscale SSH server even from connections that the
+policy doesn't otherwise permit, such as those through a jump host, and by
+OpenSSH servers configured to trust the CA with TrustedUserCAKeys.
+
+The certificate is written next to the public key with a "-cert.pub" suffix,
+where the 'ssh' command finds it automatically.
+`),
+	Exec: runSSHCert,
+	FlagSet: func() *flag.FlagSet {
+		fs := newFlagSet("ssh-cert")
+		fs.StringVar(&sshCertArgs.users, "users", "", "comma-separated users to request access as (default: the current user)")
+		fs.StringVar(&sshCertArgs.key, "key", "", "path of the SSH public key to certify (default: ~/.ssh/id_ed25519.pub)")
+		fs.DurationVar(&sshCertArgs.duration, "duration", 0, "requested validity period of the certificate (default: the CA's default)")
+		fs.BoolVar(&sshCertArgs.printCA, "print-ca", false, "print the CA's public key, for use with TrustedUserCAKeys")
+		return fs
+	}(),
+}
+
+var sshCertArgs struct {
+	users    string
+	key      string
+	duration time.Duration
+	printCA  bool
+}
+
+func runSSHCert(ctx context.Context, args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: tailscale ssh-cert [flags] <host>")
+	}
+	st, err := localClient.Status(ctx)
+	if err != nil {
+		return err
+	}
+	ps, ok := peerStatusFromArg(st, args[0])
+	if !ok {
+		return fmt.Errorf("unknown host %q", args[0])
+	}
+
+	users := strings.FieldsFunc(sshCertArgs.users, func(r rune) bool { return r == ',' || r == ' ' })
+	if len(users) == 0 {
+		lu, err := user.Current()
+		if err != nil {
+			return err
+		}
+		users = []string{lu.Username}
+	}
+	keyPath := sshCertArgs.key
+	if keyPath == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return err
+		}
+		keyPath = filepath.Join(home, ".ssh", "id_ed25519.pub")
+	}
+	pub, err := os.ReadFile(keyPath)
+	if err != nil {
+		return err
+	}
+
+	res, err := localClient.SSHUserCert(ctx, ps.ID, apitype.SSHUserCertRequest{
+		PublicKey: string(pub),
+		Users:     users,
+		Duration:  sshCertArgs.duration,
+	})
+	if err != nil {
+		return err
+	}
+	certPath := strings.TrimSuffix(keyPath, ".pub") + "-cert.pub"
+	if err := os.WriteFile(certPath, []byte(res.Certificate+"\n"), 0644); err != nil {
+		return err
+	}
+	printf("Wrote certificate to %s for %s, valid until %s.\n", certPath, strings.Join(res.Principals, ", "), res.ValidBefore.Local().Format(time.RFC1123))
+	if sshCertArgs.printCA {
+		outln(res.CAPublicKey)
+	}
+	return nil
+}
@@ -226,6 +226,18 @@ func TestOmitUseProxy(t *testing.T) {
 	}.Check(t)
 }
 
+func TestOmitTKABolt(t *testing.T) {
+	deptest.DepChecker{
+		GOOS:   "linux",
+		GOARCH: "amd64",
+		Tags:   "ts_omit_tkabolt,ts_include_cli",
+		BadDeps: map[string]string{
+			"go.etcd.io/bbolt":            "unexpected dep with ts_omit_tkabolt",
+			"tailscale.com/tka/boltchonk": "unexpected dep with ts_omit_tkabolt",
+		},
+	}.Check(t)
+}
+
 func minTags() string {
 	var tags []string
 	for _, f := range slices.Sorted(maps.Keys(featuretags.Features)) {
@@ -0,0 +1,227 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+// Program tl-audit verifies an export of a tailnet's Tailnet Lock history,
+// as written by 'tailscale lock export', and renders the state of Tailnet
+// Lock after each change.
+//
+// It replays the chain of updates as a node does, checking that every
+// update is signed by keys that were trusted at the time. It doesn't need
+// access to a node or the network, so exports can be audited offline.
+//
+// Usage:
+//
+//	tl-audit [-format=json|dot] <export-file>
+//
+// The JSON format lists each update with the resulting state; the dot
+// format is a Graphviz diagram of the chain. If verification fails, the
+// updates verified so far are rendered, followed by the error, and
+// tl-audit exits with status 1.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+
+	"tailscale.com/tka"
+)
+
+var format = flag.String("format", "json", "output format: json or dot")
+
+func main() {
+	flag.Parse()
+	if flag.NArg() != 1 {
+		log.Fatal("usage: tl-audit [-format=json|dot] <export-file>")
+	}
+
+	var b []byte
+	var err error
+	if flag.Arg(0) == "-" {
+		b, err = io.ReadAll(os.Stdin)
+	} else {
+		b, err = os.ReadFile(flag.Arg(0))
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	var export tka.ChainExport
+	if err := json.Unmarshal(b, &export); err != nil {
+		log.Fatalf("decoding export: %v", err)
+	}
+
+	steps, verr := export.Verify()
+	switch *format {
+	case "json":
+		err = writeJSON(os.Stdout, &export, steps, verr)
+	case "dot":
+		err = writeDot(os.Stdout, steps, verr)
+	default:
+		log.Fatalf("unknown format %q", *format)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	if verr != nil {
+		log.Fatalf("verification failed: %v", verr)
+	}
+}
+
+// keyID returns the string form of a key ID used by 'tailscale lock'.
+func keyID(id []byte) string {
+	return fmt.Sprintf("tlpub:%x", id)
+}
+
+type auditJSON struct {
+	Head  string
+	Valid bool
+	Error string `json:",omitempty"`
+
+	// Truncated is whether the chain starts at a checkpoint that isn't
+	// the genesis update, in which case its history before that point
+	// isn't known.
+	Truncated bool `json:",omitempty"`
+
+	Steps []stepJSON
+}
+
+type stepJSON struct {
+	Hash     string
+	Parent   string `json:",omitempty"`
+	Kind     string
+	SignedBy []string
+
+	// The change made by the update, depending on Kind.
+	Key   *keyJSON          `json:",omitempty"`
+	KeyID string            `json:",omitempty"`
+	Votes *uint             `json:",omitempty"`
+	Meta  map[string]string `json:",omitempty"`
+
+	State stateJSON // after the update
+}
+
+type keyJSON struct {
+	KeyID string
+	Kind  string
+	Votes uint
+	Meta  map[string]string `json:",omitempty"`
+}
+
+type stateJSON struct {
+	Keys               []keyJSON
+	DisablementSecrets int
+	StateID1           uint64 `json:",omitempty"`
+	StateID2           uint64 `json:",omitempty"`
+}
+
+// keyIDOf returns the string form of k's ID.
+func keyIDOf(k tka.Key) string {
+	id, err := k.ID()
+	if err != nil {
+		return fmt.Sprintf("<error: %v>", err)
+	}
+	return keyID(id)
+}
+
+func keyToJSON(k tka.Key) keyJSON {
+	return keyJSON{KeyID: keyIDOf(k), Kind: k.Kind.String(), Votes: k.Votes, Meta: k.Meta}
+}
+
+func stepToJSON(s tka.AuditStep) stepJSON {
+	out := stepJSON{
+		Hash:  s.Hash.String(),
+		Kind:  s.AUM.MessageKind.String(),
+		Votes: s.AUM.Votes,
+		Meta:  s.AUM.Meta,
+		State: stateJSON{
+			DisablementSecrets: len(s.State.DisablementSecrets),
+			StateID1:           s.State.StateID1,
+			StateID2:           s.State.StateID2,
+		},
+	}
+	if parent, ok := s.AUM.Parent(); ok {
+		out.Parent = parent.String()
+	}
+	for _, sig := range s.AUM.Signatures {
+		out.SignedBy = append(out.SignedBy, keyID(sig.KeyID))
+	}
+	if s.AUM.Key != nil {
+		k := keyToJSON(*s.AUM.Key)
+		out.Key = &k
+	}
+	if len(s.AUM.KeyID) > 0 {
+		out.KeyID = keyID(s.AUM.KeyID)
+	}
+	for _, k := range s.State.Keys {
+		out.State.Keys = append(out.State.Keys, keyToJSON(k))
+	}
+	return out
+}
+
+func writeJSON(w io.Writer, export *tka.ChainExport, steps []tka.AuditStep, verr error) error {
+	out := auditJSON{
+		Head:  export.Head.String(),
+		Valid: verr == nil,
+		Steps: []stepJSON{},
+	}
+	if verr != nil {
+		out.Error = verr.Error()
+	}
+	if len(steps) > 0 {
+		_, out.Truncated = steps[0].AUM.Parent()
+	}
+	for _, s := range steps {
+		out.Steps = append(out.Steps, stepToJSON(s))
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "\t")
+	return enc.Encode(out)
+}
+
+// shortHash returns an abbreviated hash for display.
+func shortHash(h tka.AUMHash) string {
+	return h.String()[:12]
+}
+
+func writeDot(w io.Writer, steps []tka.AuditStep, verr error) error {
+	var b strings.Builder
+	b.WriteString("digraph tailnet_lock {\n")
+	b.WriteString("\tnode [shape=box, fontname=\"monospace\"];\n")
+	for i, s := range steps {
+		var label strings.Builder
+		fmt.Fprintf(&label, "%s %s\\n", s.AUM.MessageKind, shortHash(s.Hash))
+		switch {
+		case s.AUM.Key != nil:
+			fmt.Fprintf(&label, "key: %s (votes %d)\\n", keyIDOf(*s.AUM.Key), s.AUM.Key.Votes)
+		case len(s.AUM.KeyID) > 0:
+			fmt.Fprintf(&label, "key: %s\\n", keyID(s.AUM.KeyID))
+		}
+		for _, sig := range s.AUM.Signatures {
+			fmt.Fprintf(&label, "signed by: %s\\n", keyID(sig.KeyID))
+		}
+		fmt.Fprintf(&label, "trusted keys after: %d\\l", len(s.State.Keys))
+		for _, k := range s.State.Keys {
+			fmt.Fprintf(&label, "  %s (votes %d)\\l", keyIDOf(k), k.Votes)
+		}
+		fmt.Fprintf(&b, "\t%q [label=\"%s\"];\n", s.Hash.String(), label.String())
+		if parent, ok := s.AUM.Parent(); ok {
+			if i == 0 {
+				fmt.Fprintf(&b, "\t%q [label=\"%s\\n(history not exported)\", style=dashed];\n", parent.String(), shortHash(parent))
+			}
+			fmt.Fprintf(&b, "\t%q -> %q;\n", parent.String(), s.Hash.String())
+		}
+	}
+	if verr != nil {
+		fmt.Fprintf(&b, "\terror [label=%q, color=red, fontcolor=red];\n", "verification failed: "+verr.Error())
+		if len(steps) > 0 {
+			fmt.Fprintf(&b, "\t%q -> error [color=red];\n", steps[len(steps)-1].Hash.String())
+		}
+	}
+	b.WriteString("}\n")
+	_, err := io.WriteString(w, b.String())
+	return err
+}
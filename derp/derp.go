@@ -125,8 +125,10 @@
 	// frameRestarting is sent from server to client for the
 	// server to declare that it's restarting. Payload is two big
 	// endian uint32 durations in milliseconds: when to reconnect,
-	// and how long to try total. See ServerRestartingMessage docs for
-	// more details on how the client should interpret them.
+	// and how long to try total. It may be followed by a third big endian
+	// uint32: the ID of a DERP region the client should prefer while the
+	// server is unavailable. See ServerRestartingMessage docs for more
+	// details on how the client should interpret them.
 	FrameRestarting = FrameType(0x15)
 )
 
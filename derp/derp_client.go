@@ -467,6 +467,11 @@ type ServerRestartingMessage struct {
 	// A server should not send a TryFor duration more than a few
 	// seconds.
 	TryFor time.Duration
+
+	// AltRegionID, if non-zero, is the ID of a DERP region that the
+	// server suggests the client use while it's unavailable, such as when
+	// the server is being drained before being decommissioned.
+	AltRegionID int
 }
 
 func (ServerRestartingMessage) msg() {}
@@ -641,6 +646,9 @@ func (c *Client) recvTimeout(timeout time.Duration) (m ReceivedMessage, err erro
 			}
 			m.ReconnectIn = time.Duration(binary.BigEndian.Uint32(b[0:4])) * time.Millisecond
 			m.TryFor = time.Duration(binary.BigEndian.Uint32(b[4:8])) * time.Millisecond
+			if n >= 12 {
+				m.AltRegionID = int(binary.BigEndian.Uint32(b[8:12]))
+			}
 			return m, nil
 		}
 	}
@@ -629,3 +629,114 @@ func TestURLDial(t *testing.T) {
 		t.Fatalf("rc.Connect: %v", err)
 	}
 }
+
+func TestDrain(t *testing.T) {
+	serverURL, s, ln := newTestServer(t, key.NewNode())
+	defer s.Close()
+
+	newClient := func() *derphttp.Client {
+		t.Helper()
+		c, err := derphttp.NewClient(key.NewNode(), serverURL, t.Logf, netmon.NewStatic())
+		if err != nil {
+			t.Fatal(err)
+		}
+		c.SetURLDialer(ln.Dial)
+		t.Cleanup(func() { c.Close() })
+		return c
+	}
+	// recvNotice returns the drain notice sent to c.
+	recvNotice := func(c *derphttp.Client) (derp.HealthMessage, derp.ServerRestartingMessage) {
+		t.Helper()
+		var health derp.HealthMessage
+		for {
+			m, err := c.Recv()
+			if err != nil {
+				t.Fatalf("Recv: %v", err)
+			}
+			switch m := m.(type) {
+			case derp.HealthMessage:
+				health = m
+			case derp.ServerRestartingMessage:
+				return health, m
+			}
+		}
+	}
+
+	client := newClient()
+	waitConnect(t, client)
+	mesh := newWatcherClient(t, key.NewNode(), serverURL, ln)
+	defer mesh.Close()
+	waitConnect(t, mesh)
+
+	drained := make(chan error, 1)
+	go func() {
+		drained <- s.Drain(context.Background(), derpserver.DrainConfig{
+			ReconnectIn: time.Second,
+			TryFor:      5 * time.Second,
+			AltRegionID: 7,
+			Timeout:     time.Minute,
+		})
+	}()
+
+	health, restarting := recvNotice(client)
+	if health.Problem == "" {
+		t.Error("health problem is empty")
+	}
+	if want := (derp.ServerRestartingMessage{ReconnectIn: time.Second, TryFor: 5 * time.Second, AltRegionID: 7}); restarting != want {
+		t.Errorf("restarting = %+v; want %+v", restarting, want)
+	}
+	if !s.IsDraining() {
+		t.Error("IsDraining = false while draining")
+	}
+
+	// New clients are refused, but Drain keeps waiting for the existing
+	// one, and not for the mesh peer.
+	if m, err := newClient().Recv(); err == nil {
+		t.Errorf("new client while draining got %T; want error", m)
+	}
+	select {
+	case err := <-drained:
+		t.Fatalf("Drain returned early: %v", err)
+	default:
+	}
+
+	client.Close()
+	select {
+	case err := <-drained:
+		if err != nil {
+			t.Fatalf("Drain: %v", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("Drain didn't return after the client left")
+	}
+	// The mesh peer is disconnected by the final Close.
+	for {
+		if _, err := mesh.Recv(); err != nil {
+			break
+		}
+	}
+}
+
+func TestDrainTimeout(t *testing.T) {
+	serverURL, s, ln := newTestServer(t, key.NewNode())
+	defer s.Close()
+
+	c, err := derphttp.NewClient(key.NewNode(), serverURL, t.Logf, netmon.NewStatic())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	c.SetURLDialer(ln.Dial)
+	waitConnect(t, c)
+
+	if err := s.Drain(context.Background(), derpserver.DrainConfig{Timeout: 100 * time.Millisecond}); err != nil {
+		t.Fatalf("Drain: %v", err)
+	}
+	// The client ignored the notice, so it's disconnected once the
+	// timeout expires.
+	for {
+		if _, err := c.Recv(); err != nil {
+			break
+		}
+	}
+}
@@ -39,6 +39,7 @@
 	"github.com/axiomhq/hyperloglog"
 	"go4.org/mem"
 	"golang.org/x/sync/errgroup"
+	xrate "golang.org/x/time/rate"
 	"tailscale.com/client/local"
 	"tailscale.com/derp"
 	"tailscale.com/derp/derpconst"
@@ -164,6 +165,11 @@ type Server struct {
 	multiForwarderDeleted      expvar.Int
 	removePktForwardOther      expvar.Int
 	sclientWriteTimeouts       expvar.Int
+	drainRejects               expvar.Int       // client connections refused while draining
+	drainNoticesSent           expvar.Int       // drain notices sent to clients
+	packetsThrottled           expvar.Int       // send frames delayed by client rate limits
+	bytesThrottled             expvar.Int       // bytes of send frames delayed by client rate limits
+	throttleDelayMs            expvar.Int       // total time send frames were delayed by client rate limits
 	avgQueueDuration           *uint64          // In milliseconds; accessed atomically
 	tcpRtt                     metrics.LabelMap // histogram
 	meshUpdateBatchSize        *metrics.Histogram
@@ -173,10 +179,18 @@ type Server struct {
 	// verifyClientsLocalTailscaled only accepts client connections to the DERP
 	// server if the clientKey is a known peer in the network, as specified by a
 	// running tailscaled's client's LocalAPI.
-	verifyClientsLocalTailscaled bool
+	verifyClientsLocalTailscaled atomic.Bool
 
-	verifyClientsURL         string
-	verifyClientsURLFailOpen bool
+	verifyClientsURL         syncs.AtomicValue[string]
+	verifyClientsURLFailOpen atomic.Bool
+
+	// draining is whether the server is refusing new client
+	// connections; see SetDraining.
+	draining atomic.Bool
+
+	// drainNotice, if non-nil, is the notice that clients are sent while
+	// the server drains; see Drain.
+	drainNotice syncs.AtomicValue[*DrainConfig]
 
 	mu       syncs.Mutex
 	closed   bool
@@ -198,10 +212,18 @@ type Server struct {
 	// maps from netip.AddrPort to a client's public key
 	keyOfAddr map[netip.AddrPort]key.NodePublic
 
+	// defaultRateLimit and perKeyRateLimits are the client rate limits
+	// set by SetClientRateLimits.
+	defaultRateLimit ClientRateLimit
+	perKeyRateLimits map[key.NodePublic]ClientRateLimit
+	// rateLimiters are the rate limiters of connected clients, other
+	// than mesh peers.
+	rateLimiters map[key.NodePublic]*clientRateLimiter
+
 	// Sets the client send queue depth for the server.
 	perClientSendQueueDepth int
 
-	tcpWriteTimeout time.Duration
+	tcpWriteTimeout syncs.AtomicValue[time.Duration]
 
 	clock tstime.Clock
 }
@@ -378,8 +400,8 @@ func New(privateKey key.NodePrivate, logf logger.Logf) *Server {
 		meshUpdateLoopCount: metrics.NewHistogram([]float64{0, 1, 2, 5, 10, 20, 50, 100}),
 		bufferedWriteFrames: metrics.NewHistogram([]float64{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 15, 20, 25, 50, 100}),
 		keyOfAddr:           map[netip.AddrPort]key.NodePublic{},
+		rateLimiters:        map[key.NodePublic]*clientRateLimiter{},
 		clock:               tstime.StdClock{},
-		tcpWriteTimeout:     DefaultTCPWiteTimeout,
 	}
 	s.initMetacert()
 	s.packetsRecvDisco = s.packetsRecvByKind.Get(string(packetKindDisco))
@@ -388,6 +410,7 @@ func New(privateKey key.NodePrivate, logf logger.Logf) *Server {
 	genDroppedCounters()
 
 	s.perClientSendQueueDepth = getPerClientSendQueueDepth()
+	s.tcpWriteTimeout.Store(DefaultTCPWiteTimeout)
 	return s
 }
 
@@ -471,22 +494,24 @@ func (s *Server) SetMeshKey(v string) error {
 
 // SetVerifyClients sets whether this DERP server verifies clients through tailscaled.
 //
-// It must be called before serving begins.
+// It may be called at any time; it affects clients that connect afterwards.
 func (s *Server) SetVerifyClient(v bool) {
-	s.verifyClientsLocalTailscaled = v
+	s.verifyClientsLocalTailscaled.Store(v)
 }
 
 // SetVerifyClientURL sets the admission controller URL to use for verifying clients.
 // If empty, all clients are accepted (unless restricted by SetVerifyClient checking
 // against tailscaled).
+//
+// It may be called at any time; it affects clients that connect afterwards.
 func (s *Server) SetVerifyClientURL(v string) {
-	s.verifyClientsURL = v
+	s.verifyClientsURL.Store(v)
 }
 
 // SetVerifyClientURLFailOpen sets whether to allow clients to connect if the
 // admission controller URL is unreachable.
 func (s *Server) SetVerifyClientURLFailOpen(v bool) {
-	s.verifyClientsURLFailOpen = v
+	s.verifyClientsURLFailOpen.Store(v)
 }
 
 // SetTailscaledSocketPath sets the unix socket path to use to talk to
@@ -502,8 +527,10 @@ func (s *Server) SetTailscaledSocketPath(path string) {
 // SetTCPWriteTimeout sets the timeout for writing to connected clients.
 // This timeout does not apply to mesh connections.
 // Defaults to 2 seconds.
+//
+// It may be called at any time; it affects subsequent writes.
 func (s *Server) SetTCPWriteTimeout(d time.Duration) {
-	s.tcpWriteTimeout = d
+	s.tcpWriteTimeout.Store(d)
 }
 
 // HasMeshKey reports whether the server is configured with a mesh key.
@@ -562,6 +589,79 @@ func (s *Server) IsClientConnectedForTest(k key.NodePublic) bool {
 	return x.activeClient.Load() != nil
 }
 
+// SetDraining sets whether the server is draining. A draining server
+// refuses new client connections, other than from mesh peers, so that
+// clients find another server in the region. Clients that are already
+// connected are unaffected.
+//
+// Undraining a server that was told to Drain clears the health problem it
+// reported to clients.
+func (s *Server) SetDraining(v bool) {
+	s.draining.Store(v)
+	if !v && s.drainNotice.Swap(nil) != nil {
+		s.notifyClientsOfDrain()
+	}
+}
+
+// IsDraining reports whether the server is draining; see SetDraining.
+func (s *Server) IsDraining() bool {
+	return s.draining.Load()
+}
+
+// ClientInfo describes a client connection to the server, as returned
+// by Clients.
+type ClientInfo struct {
+	Key         key.NodePublic
+	RemoteAddr  netip.AddrPort `json:",omitzero"` // zero if not known
+	ConnectedAt time.Time
+	IsHome      bool `json:",omitempty"` // whether the client says this is its home DERP server
+	IsMeshPeer  bool `json:",omitempty"` // whether the client is a mesh peer (or uses the mesh key)
+	IsProber    bool `json:",omitempty"` // whether the client is a prober
+	IsNotIdeal  bool `json:",omitempty"` // whether the client says this isn't its ideal server in the region
+	IsDup       bool `json:",omitempty"` // whether the client's key has more than one connection
+	IsDisabled  bool `json:",omitempty"` // whether packets to the connection are disabled because of duplicates
+}
+
+// Clients returns information about the connections of all clients
+// connected to the server, in no particular order.
+func (s *Server) Clients() []ClientInfo {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var ret []ClientInfo
+	for _, cs := range s.clients {
+		cs.ForeachClient(func(c *sclient) {
+			ret = append(ret, ClientInfo{
+				Key:         c.key,
+				RemoteAddr:  c.remoteIPPort,
+				ConnectedAt: c.connectedAt,
+				IsHome:      c.preferred.Load(),
+				IsMeshPeer:  c.canMesh,
+				IsProber:    c.info.IsProber,
+				IsNotIdeal:  c.isNotIdealConn,
+				IsDup:       c.isDup.Load(),
+				IsDisabled:  c.isDisabled.Load(),
+			})
+		})
+	}
+	return ret
+}
+
+// DisconnectClient closes all connections of the client with key k and
+// returns how many there were. The client may reconnect.
+func (s *Server) DisconnectClient(k key.NodePublic) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	cs, ok := s.clients[k]
+	if !ok {
+		return 0
+	}
+	n := cs.Len()
+	cs.ForeachClient(func(c *sclient) {
+		go c.nc.Close()
+	})
+	return n
+}
+
 // Accept adds a new connection to the server and serves it.
 //
 // The provided bufio ReadWriter must be already connected to nc.
@@ -800,7 +900,7 @@ func (s *Server) unregisterClient(c *sclient) {
 	delete(s.keyOfAddr, c.remoteIPPort)
 
 	s.curClients.Add(-1)
-	if c.preferred {
+	if c.preferred.Load() {
 		s.curHomeClients.Add(-1)
 	}
 	if c.isNotIdealConn {
@@ -922,6 +1022,11 @@ func (s *Server) accept(ctx context.Context, nc derp.Conn, brw *bufio.ReadWriter
 		return fmt.Errorf("receive client key: %v", err)
 	}
 
+	if s.draining.Load() && !s.isMeshPeer(clientInfo) {
+		s.drainRejects.Add(1)
+		return nil
+	}
+
 	remoteIPPort, _ := netip.ParseAddrPort(remoteAddr)
 	if err := s.verifyClient(ctx, clientKey, clientInfo, remoteIPPort.Addr()); err != nil {
 		return fmt.Errorf("client %v rejected: %v", clientKey, err)
@@ -944,10 +1049,11 @@ func (s *Server) accept(ctx context.Context, nc derp.Conn, brw *bufio.ReadWriter
 		done:           ctx.Done(),
 		remoteIPPort:   remoteIPPort,
 		connectedAt:    s.clock.Now(),
-		sendQueue:      make(chan pkt, s.perClientSendQueueDepth),
+		sendQueue:      newFairQueue(s.perClientSendQueueDepth),
 		discoSendQueue: make(chan pkt, s.perClientSendQueueDepth),
 		sendPongCh:     make(chan [8]byte, 1),
 		peerGone:       make(chan peerGoneMsg),
+		drainNotify:    make(chan struct{}, 1),
 		canMesh:        s.isMeshPeer(clientInfo),
 		isNotIdealConn: IdealNodeContextKey.Value(ctx) != "",
 		peerGoneLim:    rate.NewLimiter(rate.Every(time.Second), 3),
@@ -955,6 +1061,9 @@ func (s *Server) accept(ctx context.Context, nc derp.Conn, brw *bufio.ReadWriter
 
 	if c.canMesh {
 		c.meshUpdate = make(chan struct{}, 1) // must be buffered; >1 is fine but wasteful
+	} else {
+		c.rateLimiter = s.acquireRateLimiter(clientKey)
+		defer s.releaseRateLimiter(clientKey)
 	}
 	if clientInfo != nil {
 		c.info = *clientInfo
@@ -968,6 +1077,10 @@ func (s *Server) accept(ctx context.Context, nc derp.Conn, brw *bufio.ReadWriter
 
 	s.registerClient(c)
 	defer s.unregisterClient(c)
+	if s.drainNotice.Load() != nil {
+		// Raced with Drain notifying the already registered clients.
+		c.notifyDrain()
+	}
 
 	err = s.sendServerInfo(c.bw, clientKey)
 	if err != nil {
@@ -1025,6 +1138,9 @@ func (c *sclient) run(ctx context.Context) error {
 		case derp.FrameNotePreferred:
 			err = c.handleFrameNotePreferred(ft, fl)
 		case derp.FrameSendPacket:
+			if err := c.rateLimit(ctx, int(fl)); err != nil {
+				return err
+			}
 			err = c.handleFrameSendPacket(ft, fl)
 		case derp.FrameForwardPacket:
 			err = c.handleFrameForwardPacket(ft, fl)
@@ -1280,13 +1396,28 @@ func (c *sclient) sendPkt(dst *sclient, p pkt) error {
 	s := c.s
 	dstKey := dst.key
 
-	// Attempt to queue for sending up to 3 times. On each attempt, if
-	// the queue is full, try to drop from queue head to prioritize
-	// fresher packets.
-	sendQueue := dst.sendQueue
-	if disco.LooksLikeDiscoWrapper(p.bs) {
-		sendQueue = dst.discoSendQueue
+	if !disco.LooksLikeDiscoWrapper(p.bs) {
+		select {
+		case <-dst.done:
+			s.recordDrop(p.bs, c.key, dstKey, dropReasonGoneDisconnected)
+			dst.debugLogf("sendPkt dropped, dst gone")
+			return nil
+		default:
+		}
+		// If the queue is full, the fair queue drops the oldest packet
+		// from whichever sender has the most packets queued.
+		if dropped, ok := dst.sendQueue.enqueue(p); ok {
+			s.recordDrop(dropped.bs, dropped.src, dstKey, dropReasonQueueHead)
+			c.recordQueueTime(dropped.enqueuedAt)
+		}
+		dst.debugLogf("sendPkt enqueued")
+		return nil
 	}
+
+	// Attempt to queue disco packets for sending up to 3 times. On each
+	// attempt, if the queue is full, try to drop from queue head to
+	// prioritize fresher packets.
+	sendQueue := dst.discoSendQueue
 	for attempt := 0; attempt < 3; attempt++ {
 		select {
 		case <-dst.done:
@@ -1378,7 +1509,7 @@ func (s *Server) verifyClient(ctx context.Context, clientKey key.NodePublic, inf
 	}
 
 	// tailscaled-based verification:
-	if s.verifyClientsLocalTailscaled {
+	if s.verifyClientsLocalTailscaled.Load() {
 		_, err := s.localClient.WhoIsNodeKey(ctx, clientKey)
 		if err == local.ErrPeerNotFound {
 			return fmt.Errorf("peer %v not authorized (not found in local tailscaled)", clientKey)
@@ -1393,7 +1524,7 @@ func (s *Server) verifyClient(ctx context.Context, clientKey key.NodePublic, inf
 	}
 
 	// admission controller-based verification:
-	if s.verifyClientsURL != "" {
+	if verifyURL := s.verifyClientsURL.Load(); verifyURL != "" {
 		ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 		defer cancel()
 
@@ -1404,13 +1535,13 @@ func (s *Server) verifyClient(ctx context.Context, clientKey key.NodePublic, inf
 		if err != nil {
 			return err
 		}
-		req, err := http.NewRequestWithContext(ctx, "POST", s.verifyClientsURL, bytes.NewReader(jreq))
+		req, err := http.NewRequestWithContext(ctx, "POST", verifyURL, bytes.NewReader(jreq))
 		if err != nil {
 			return err
 		}
 		res, err := http.DefaultClient.Do(req)
 		if err != nil {
-			if s.verifyClientsURLFailOpen {
+			if s.verifyClientsURLFailOpen.Load() {
 				s.logf("admission controller unreachable; allowing client %v", clientKey)
 				return nil
 			}
@@ -1624,11 +1755,12 @@ type sclient struct {
 	logf           logger.Logf
 	done           <-chan struct{}  // closed when connection closes
 	remoteIPPort   netip.AddrPort   // zero if remoteAddr is not ip:port.
-	sendQueue      chan pkt         // packets queued to this client; never closed
+	sendQueue      *fairQueue       // packets queued to this client
 	discoSendQueue chan pkt         // important packets queued to this client; never closed
 	sendPongCh     chan [8]byte     // pong replies to send to the client; never closed
 	peerGone       chan peerGoneMsg // write request that a peer is not at this server (not used by mesh peers)
 	meshUpdate     chan struct{}    // write request to write peerStateChange
+	drainNotify    chan struct{}    // write request to write the drain notice (not used by mesh peers)
 	canMesh        bool             // clientInfo had correct mesh token for inter-region routing
 	isNotIdealConn bool             // client indicated it is not its ideal node in the region
 	isDup          atomic.Bool      // whether more than 1 sclient for key is connected
@@ -1638,7 +1770,7 @@ type sclient struct {
 	// Owned by run, not thread-safe.
 	br          *bufio.Reader
 	connectedAt time.Time
-	preferred   bool
+	preferred   atomic.Bool // written only by run
 
 	// Owned by sendLoop, not thread-safe.
 	sawSrc map[key.NodePublic]set.Handle
@@ -1662,6 +1794,10 @@ type sclient struct {
 	// client that it's trying to establish a direct connection
 	// through us with a peer we have no record of.
 	peerGoneLim *rate.Limiter
+
+	// rateLimiter limits how fast the client may send packets. It's
+	// nil for mesh peers, which aren't rate limited.
+	rateLimiter *clientRateLimiter
 }
 
 func (c *sclient) presentFlags() derp.PeerPresentFlags {
@@ -1711,10 +1847,10 @@ type peerGoneMsg struct {
 }
 
 func (c *sclient) setPreferred(v bool) {
-	if c.preferred == v {
+	if c.preferred.Load() == v {
 		return
 	}
-	c.preferred = v
+	c.preferred.Store(v)
 	var homeMove *expvar.Int
 	if v {
 		c.s.curHomeClients.Add(1)
@@ -1768,11 +1904,16 @@ func (c *sclient) onSendLoopDone() {
 		c.s.removePeerGoneFromRegionWatcher(peer, h)
 	}
 
-	// Drain the send queue to count dropped packets
+	// Drain the send queues to count dropped packets
+	for {
+		pkt, ok := c.sendQueue.dequeue()
+		if !ok {
+			break
+		}
+		c.s.recordDrop(pkt.bs, pkt.src, c.key, dropReasonGoneDisconnected)
+	}
 	for {
 		select {
-		case pkt := <-c.sendQueue:
-			c.s.recordDrop(pkt.bs, pkt.src, c.key, dropReasonGoneDisconnected)
 		case pkt := <-c.discoSendQueue:
 			c.s.recordDrop(pkt.bs, pkt.src, c.key, dropReasonGoneDisconnected)
 		default:
@@ -1809,9 +1950,11 @@ func (c *sclient) sendLoop(ctx context.Context) error {
 		case <-c.meshUpdate:
 			werr = c.sendMeshUpdates()
 			continue
-		case msg := <-c.sendQueue:
-			werr = c.sendPacket(msg.src, msg.bs)
-			c.recordQueueTime(msg.enqueuedAt)
+		case <-c.sendQueue.ready:
+			if msg, ok := c.sendQueue.dequeue(); ok {
+				werr = c.sendPacket(msg.src, msg.bs)
+				c.recordQueueTime(msg.enqueuedAt)
+			}
 			continue
 		case msg := <-c.discoSendQueue:
 			werr = c.sendPacket(msg.src, msg.bs)
@@ -1820,6 +1963,9 @@ func (c *sclient) sendLoop(ctx context.Context) error {
 		case msg := <-c.sendPongCh:
 			werr = c.sendPong(msg)
 			continue
+		case <-c.drainNotify:
+			werr = c.sendDrainNotice()
+			continue
 		case <-keepAliveTickChannel:
 			werr = c.sendKeepAlive()
 			continue
@@ -1843,14 +1989,18 @@ func (c *sclient) sendLoop(ctx context.Context) error {
 			werr = c.sendPeerGone(msg.peer, msg.reason)
 		case <-c.meshUpdate:
 			werr = c.sendMeshUpdates()
-		case msg := <-c.sendQueue:
-			werr = c.sendPacket(msg.src, msg.bs)
-			c.recordQueueTime(msg.enqueuedAt)
+		case <-c.sendQueue.ready:
+			if msg, ok := c.sendQueue.dequeue(); ok {
+				werr = c.sendPacket(msg.src, msg.bs)
+				c.recordQueueTime(msg.enqueuedAt)
+			}
 		case msg := <-c.discoSendQueue:
 			werr = c.sendPacket(msg.src, msg.bs)
 			c.recordQueueTime(msg.enqueuedAt)
 		case msg := <-c.sendPongCh:
 			werr = c.sendPong(msg)
+		case <-c.drainNotify:
+			werr = c.sendDrainNotice()
 		case <-keepAliveTickChannel:
 			werr = c.sendKeepAlive()
 		}
@@ -1858,7 +2008,7 @@ func (c *sclient) sendLoop(ctx context.Context) error {
 }
 
 func (c *sclient) setWriteDeadline() {
-	d := c.s.tcpWriteTimeout
+	d := c.s.tcpWriteTimeout.Load()
 	if c.canMesh {
 		// Trusted peers get more tolerance.
 		//
@@ -2235,6 +2385,26 @@ func (s *Server) ExpVar() expvar.Var {
 	m.Set("multiforwarder_deleted", &s.multiForwarderDeleted)
 	m.Set("packet_forwarder_delete_other_value", &s.removePktForwardOther)
 	m.Set("sclient_write_timeouts", &s.sclientWriteTimeouts)
+	m.Set("gauge_draining", expvar.Func(func() any {
+		if s.draining.Load() {
+			return 1
+		}
+		return 0
+	}))
+	m.Set("counter_drain_rejects", &s.drainRejects)
+	m.Set("counter_drain_notices_sent", &s.drainNoticesSent)
+	m.Set("packets_throttled", &s.packetsThrottled)
+	m.Set("bytes_throttled", &s.bytesThrottled)
+	m.Set("throttle_delay_ms", &s.throttleDelayMs)
+	m.Set("gauge_rate_limited_clients", s.expVarFunc(func() any {
+		var n int
+		for _, rl := range s.rateLimiters {
+			if rl.lim.Limit() != xrate.Inf {
+				n++
+			}
+		}
+		return n
+	}))
 	m.Set("average_queue_duration_ms", expvar.Func(func() any {
 		return math.Float64frombits(atomic.LoadUint64(s.avgQueueDuration))
 	}))
@@ -2282,7 +2452,7 @@ func (s *Server) ConsistencyCheck() error {
 			len(s.clients)))
 	}
 
-	if s.verifyClientsLocalTailscaled {
+	if s.verifyClientsLocalTailscaled.Load() {
 		if err := s.checkVerifyClientsLocalTailscaled(); err != nil {
 			errs = append(errs, err.Error())
 		}
@@ -27,6 +27,7 @@
 	"golang.org/x/time/rate"
 	"tailscale.com/derp"
 	"tailscale.com/derp/derpconst"
+	"tailscale.com/tstest"
 	"tailscale.com/types/key"
 	"tailscale.com/types/logger"
 )
@@ -975,3 +976,240 @@ func BenchmarkSenderCardinalityOverhead(b *testing.B) {
 		}
 	})
 }
+
+func TestFairQueue(t *testing.T) {
+	a, b := pubAll(1), pubAll(2)
+	mk := func(src key.NodePublic, id byte) pkt { return pkt{src: src, bs: []byte{id}} }
+	drain := func(q *fairQueue) (got []byte) {
+		for {
+			p, ok := q.dequeue()
+			if !ok {
+				return got
+			}
+			got = append(got, p.bs[0])
+		}
+	}
+
+	t.Run("round-robin", func(t *testing.T) {
+		q := newFairQueue(8)
+		for i := range byte(3) {
+			if _, dropped := q.enqueue(mk(a, 'a'+i)); dropped {
+				t.Fatal("unexpected drop")
+			}
+		}
+		q.enqueue(mk(b, 'x'))
+		if got, want := string(drain(q)), "axbc"; got != want {
+			t.Errorf("dequeued %q; want %q", got, want)
+		}
+		if len(q.bySrc) != 0 || q.rr.Len() != 0 || q.maxLen != 0 {
+			t.Errorf("queue not empty after draining: %v, %d senders, maxLen %d", q.bySrc, q.rr.Len(), q.maxLen)
+		}
+	})
+
+	t.Run("drop-from-longest", func(t *testing.T) {
+		q := newFairQueue(4)
+		q.enqueue(mk(b, 'x'))
+		for i := range byte(3) {
+			q.enqueue(mk(a, 'a'+i))
+		}
+		// The queue is full. A packet from b drops a's oldest packet,
+		// not b's.
+		dropped, ok := q.enqueue(mk(b, 'y'))
+		if !ok || dropped.bs[0] != 'a' {
+			t.Fatalf("dropped %q, %v; want 'a'", dropped.bs, ok)
+		}
+		if got, want := string(drain(q)), "xbyc"; got != want {
+			t.Errorf("dequeued %q; want %q", got, want)
+		}
+	})
+
+	t.Run("ready", func(t *testing.T) {
+		q := newFairQueue(4)
+		select {
+		case <-q.ready:
+			t.Fatal("empty queue is ready")
+		default:
+		}
+		q.enqueue(mk(a, 'a'))
+		q.enqueue(mk(b, 'x'))
+		for range 2 {
+			select {
+			case <-q.ready:
+			default:
+				t.Fatal("non-empty queue isn't ready")
+			}
+			if _, ok := q.dequeue(); !ok {
+				t.Fatal("dequeue failed")
+			}
+		}
+		select {
+		case <-q.ready:
+			t.Fatal("drained queue is ready")
+		default:
+		}
+	})
+}
+
+func BenchmarkFairQueue(b *testing.B) {
+	for _, senders := range []int{1, 100, 10000} {
+		b.Run(fmt.Sprintf("senders=%d", senders), func(b *testing.B) {
+			srcs := make([]key.NodePublic, senders)
+			for i := range srcs {
+				srcs[i] = key.NewNode().Public()
+			}
+			// Keep the queue full, so every enqueue also drops a packet.
+			q := newFairQueue(senders)
+			for _, src := range srcs {
+				q.enqueue(pkt{src: src})
+			}
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := range b.N {
+				q.enqueue(pkt{src: srcs[i%senders]})
+				q.dequeue()
+			}
+		})
+	}
+}
+
+// serveTCPForTest serves s on a local TCP listener until the test ends,
+// returning a func that connects a client with the given key to it.
+func serveTCPForTest(t *testing.T, s *Server) (newClient func(key.NodePrivate) *derp.Client) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			brw := bufio.NewReadWriter(bufio.NewReader(c), bufio.NewWriter(c))
+			go s.Accept(ctx, c, brw, c.RemoteAddr().String())
+		}
+	}()
+	return func(k key.NodePrivate) *derp.Client {
+		t.Helper()
+		c, err := net.Dial("tcp", ln.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { c.Close() })
+		brw := bufio.NewReadWriter(bufio.NewReader(c), bufio.NewWriter(c))
+		client, err := derp.NewClient(k, c, brw, logger.Discard)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return client
+	}
+}
+
+func TestClientRateLimit(t *testing.T) {
+	s := New(key.NewNode(), t.Logf)
+	defer s.Close()
+	newClient := serveTCPForTest(t, s)
+
+	limited, unlimited, dst := key.NewNode(), key.NewNode(), key.NewNode()
+	s.SetClientRateLimits(ClientRateLimit{BytesPerSecond: 1 << 20}, map[key.NodePublic]ClientRateLimit{
+		unlimited.Public(): {},
+	})
+	dstClient := newClient(dst)
+	if _, err := dstClient.Recv(); err != nil { // ServerInfo
+		t.Fatal(err)
+	}
+
+	// sendAll sends packets from k to dst, and returns how many of them
+	// the server throttled.
+	sendAll := func(k key.NodePrivate) int64 {
+		t.Helper()
+		c := newClient(k)
+		before := s.packetsThrottled.Value()
+		const n = 4
+		msg := make([]byte, 60000)
+		for range n {
+			if err := c.Send(dst.Public(), msg); err != nil {
+				t.Fatal(err)
+			}
+		}
+		for got := 0; got < n; {
+			m, err := dstClient.Recv()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, ok := m.(derp.ReceivedPacket); ok {
+				got++
+			}
+		}
+		return s.packetsThrottled.Value() - before
+	}
+
+	// After its initial burst of about 64KiB, the limited client sends
+	// at 1MiB/s, so its later packets are throttled.
+	if n := sendAll(limited); n == 0 {
+		t.Error("limited client wasn't throttled")
+	}
+	if n := sendAll(unlimited); n != 0 {
+		t.Errorf("unlimited client was throttled %d times", n)
+	}
+
+	// Lifting the default limit applies to connected clients.
+	s.SetClientRateLimits(ClientRateLimit{}, nil)
+	s.mu.Lock()
+	rl := s.rateLimiters[limited.Public()]
+	s.mu.Unlock()
+	if rl == nil {
+		t.Fatal("no rate limiter for connected client")
+	}
+	if got := rl.lim.Limit(); got != rate.Inf {
+		t.Errorf("limit after update = %v; want Inf", got)
+	}
+}
+
+func TestDrainAndDisconnect(t *testing.T) {
+	s := New(key.NewNode(), t.Logf)
+	defer s.Close()
+	newClient := serveTCPForTest(t, s)
+
+	k := key.NewNode()
+	c := newClient(k)
+	if _, err := c.Recv(); err != nil { // ServerInfo
+		t.Fatal(err)
+	}
+	if err := tstest.WaitFor(5*time.Second, func() error {
+		if got := s.Clients(); len(got) != 1 || got[0].Key != k.Public() {
+			return fmt.Errorf("Clients = %+v; want just %v", got, k.Public())
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	s.SetDraining(true)
+	if !s.IsDraining() {
+		t.Fatal("IsDraining = false after SetDraining(true)")
+	}
+	rejected := newClient(key.NewNode())
+	if m, err := rejected.Recv(); err == nil {
+		t.Fatalf("new client while draining got %T; want error", m)
+	}
+	if got := s.drainRejects.Value(); got != 1 {
+		t.Errorf("drainRejects = %d; want 1", got)
+	}
+	if got := len(s.Clients()); got != 1 {
+		t.Errorf("while draining, %d clients; want existing client kept", got)
+	}
+
+	if got := s.DisconnectClient(key.NewNode().Public()); got != 0 {
+		t.Errorf("DisconnectClient(unknown) = %d; want 0", got)
+	}
+	if got := s.DisconnectClient(k.Public()); got != 1 {
+		t.Errorf("DisconnectClient = %d; want 1", got)
+	}
+	if m, err := c.Recv(); err == nil {
+		t.Fatalf("disconnected client got %T; want error", m)
+	}
+}
@@ -0,0 +1,159 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package derpserver
+
+import (
+	"context"
+	"encoding/binary"
+	"time"
+
+	"tailscale.com/derp"
+)
+
+// DefaultDrainTimeout is how long Drain waits for clients to leave by
+// default.
+const DefaultDrainTimeout = 30 * time.Second
+
+// DrainConfig configures Server.Drain.
+type DrainConfig struct {
+	// Problem is the connection health problem reported to clients
+	// while the server drains. If empty, a generic message is used.
+	Problem string
+
+	// ReconnectIn and TryFor are the hints sent to clients in the
+	// restarting frame; see derp.ServerRestartingMessage.
+	ReconnectIn time.Duration
+	TryFor      time.Duration
+
+	// AltRegionID, if non-zero, is the ID of the DERP region that clients
+	// should prefer while the server is unavailable.
+	AltRegionID int
+
+	// Timeout is the longest Drain waits for clients to leave before
+	// closing the server. If zero, DefaultDrainTimeout is used.
+	Timeout time.Duration
+}
+
+func (dc *DrainConfig) problem() string {
+	if dc.Problem != "" {
+		return dc.Problem
+	}
+	return "DERP server is shutting down; reconnect to another server"
+}
+
+// Drain gracefully shuts down the server. It refuses new client
+// connections (as SetDraining does), tells the connected clients that the
+// server is going away, and waits for them to disconnect, up to
+// cfg.Timeout or until ctx is done, before closing the server and any
+// remaining connections. Mesh peers aren't told to leave or waited for.
+//
+// Clients are sent a health frame with cfg.Problem, followed by a
+// restarting frame with cfg's hints.
+func (s *Server) Drain(ctx context.Context, cfg DrainConfig) error {
+	s.SetDraining(true)
+	s.drainNotice.Store(&cfg)
+	s.notifyClientsOfDrain()
+
+	timeout := cfg.Timeout
+	if timeout <= 0 {
+		timeout = DefaultDrainTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	tick, tickc := s.clock.NewTicker(100 * time.Millisecond)
+	defer tick.Stop()
+	for n := s.numNonMeshClients(); n > 0; n = s.numNonMeshClients() {
+		select {
+		case <-tickc:
+		case <-ctx.Done():
+			s.logf("derp: drain stopped with %d clients still connected (%v); closing", n, ctx.Err())
+			return s.Close()
+		}
+	}
+	s.logf("derp: drained; closing")
+	return s.Close()
+}
+
+// numNonMeshClients returns the number of client connections that aren't
+// from mesh peers.
+func (s *Server) numNonMeshClients() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := 0
+	for _, cs := range s.clients {
+		cs.ForeachClient(func(c *sclient) {
+			if !c.canMesh {
+				n++
+			}
+		})
+	}
+	return n
+}
+
+// notifyClientsOfDrain asks the send loops of all non-mesh clients to
+// send them the current drain notice.
+func (s *Server) notifyClientsOfDrain() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, cs := range s.clients {
+		cs.ForeachClient(func(c *sclient) {
+			c.notifyDrain()
+		})
+	}
+}
+
+// notifyDrain asks c's send loop to send c the server's current drain
+// notice. It does not block.
+func (c *sclient) notifyDrain() {
+	if c.canMesh {
+		return
+	}
+	select {
+	case c.drainNotify <- struct{}{}:
+	default:
+	}
+}
+
+// sendDrainNotice sends the server's current drain notice, without
+// flushing: a health frame and a restarting frame if the server is
+// draining, or a health frame clearing the problem if it no longer is.
+func (c *sclient) sendDrainNotice() error {
+	cfg := c.s.drainNotice.Load()
+	if cfg == nil {
+		return c.sendHealth("")
+	}
+	c.s.drainNoticesSent.Add(1)
+	if err := c.sendHealth(cfg.problem()); err != nil {
+		return err
+	}
+	return c.sendRestarting(cfg)
+}
+
+// sendHealth sends a health frame with the given problem, without
+// flushing. An empty problem means the connection is healthy.
+func (c *sclient) sendHealth(problem string) error {
+	c.setWriteDeadline()
+	if err := derp.WriteFrameHeader(c.bw.bw(), derp.FrameHealth, uint32(len(problem))); err != nil {
+		return err
+	}
+	_, err := c.bw.bw().WriteString(problem)
+	return err
+}
+
+// sendRestarting sends a restarting frame with cfg's hints, without
+// flushing.
+func (c *sclient) sendRestarting(cfg *DrainConfig) error {
+	payload := make([]byte, 8, 12)
+	binary.BigEndian.PutUint32(payload[0:4], uint32(cfg.ReconnectIn.Milliseconds()))
+	binary.BigEndian.PutUint32(payload[4:8], uint32(cfg.TryFor.Milliseconds()))
+	if cfg.AltRegionID != 0 {
+		payload = binary.BigEndian.AppendUint32(payload, uint32(cfg.AltRegionID))
+	}
+	c.setWriteDeadline()
+	if err := derp.WriteFrameHeader(c.bw.bw(), derp.FrameRestarting, uint32(len(payload))); err != nil {
+		return err
+	}
+	_, err := c.bw.Write(payload)
+	return err
+}
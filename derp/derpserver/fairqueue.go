@@ -0,0 +1,171 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package derpserver
+
+import (
+	"container/list"
+	"sync"
+
+	"tailscale.com/types/key"
+)
+
+// fairQueue is a bounded queue of packets to send to a client that's fair
+// across the packets' senders.
+//
+// Each sender's packets are kept in their own FIFO, and senders are served
+// round-robin, one packet at a time. When the queue is full, the oldest
+// packet of the sender with the most packets queued is dropped. So a sender
+// that sends faster than the client can receive mostly delays and drops its
+// own packets, rather than those of other senders.
+//
+// All operations are O(1) in the number of senders.
+type fairQueue struct {
+	// ready has a value in it whenever the queue is non-empty.
+	ready chan struct{}
+
+	mu    sync.Mutex
+	max   int                              // max number of packets queued
+	n     int                              // number of packets queued
+	bySrc map[key.NodePublic]*fairSrcQueue // senders with packets queued
+	rr    list.List                        // of *fairSrcQueue with packets queued, in service order
+
+	// byLen[i] is the list of *fairSrcQueue with i packets queued, for
+	// 0 < i <= max. Lists are allocated on first use.
+	byLen []*list.List
+	// maxLen is the length of the longest sender queue, or 0 if q is empty.
+	maxLen int
+}
+
+// fairSrcQueue is the FIFO of a single sender's packets in a fairQueue.
+type fairSrcQueue struct {
+	src     key.NodePublic
+	pkts    []pkt
+	rrElem  *list.Element // in fairQueue.rr
+	lenElem *list.Element // in fairQueue.byLen[len(pkts)]
+}
+
+// newFairQueue returns a new fairQueue holding up to depth packets, which
+// is raised to 1 if needed.
+func newFairQueue(depth int) *fairQueue {
+	depth = max(depth, 1)
+	return &fairQueue{
+		ready: make(chan struct{}, 1),
+		max:   depth,
+		bySrc: map[key.NodePublic]*fairSrcQueue{},
+		byLen: make([]*list.List, depth+1),
+	}
+}
+
+// signalLocked notes that q is non-empty.
+//
+// q.mu must be held.
+func (q *fairQueue) signalLocked() {
+	select {
+	case q.ready <- struct{}{}:
+	default:
+	}
+}
+
+// enqueue adds p to q. If q was full, it returns the packet that was
+// dropped to make room.
+func (q *fairQueue) enqueue(p pkt) (dropped pkt, ok bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.n >= q.max {
+		dropped, ok = q.dropLocked(p.src)
+	}
+	sq, exists := q.bySrc[p.src]
+	if !exists {
+		sq = &fairSrcQueue{src: p.src}
+		q.bySrc[p.src] = sq
+		sq.rrElem = q.rr.PushBack(sq)
+	}
+	sq.pkts = append(sq.pkts, p)
+	q.n++
+	q.moveLenLocked(sq, len(sq.pkts)-1)
+	q.signalLocked()
+	return dropped, ok
+}
+
+// moveLenLocked moves sq from the byLen bucket for oldLen packets to the
+// one for its current length, removing it from q entirely if it's empty.
+//
+// q.mu must be held.
+func (q *fairQueue) moveLenLocked(sq *fairSrcQueue, oldLen int) {
+	if sq.lenElem != nil {
+		q.byLen[oldLen].Remove(sq.lenElem)
+		sq.lenElem = nil
+	}
+	n := len(sq.pkts)
+	if n == 0 {
+		q.removeSrcLocked(sq)
+	} else {
+		if q.byLen[n] == nil {
+			q.byLen[n] = list.New()
+		}
+		sq.lenElem = q.byLen[n].PushBack(sq)
+		q.maxLen = max(q.maxLen, n)
+	}
+	// Lengths change by one at a time, so if the longest queue shrank,
+	// the new longest is at most one shorter.
+	if q.maxLen > 0 && q.byLen[q.maxLen].Len() == 0 {
+		q.maxLen--
+	}
+}
+
+// popLocked removes and returns the oldest packet in sq.
+//
+// q.mu must be held.
+func (q *fairQueue) popLocked(sq *fairSrcQueue) pkt {
+	p := sq.pkts[0]
+	sq.pkts[0] = pkt{}
+	sq.pkts = sq.pkts[1:]
+	q.n--
+	q.moveLenLocked(sq, len(sq.pkts)+1)
+	return p
+}
+
+// dropLocked removes the oldest packet from the longest of q's sender
+// queues, preferring src's queue in a tie.
+//
+// q.mu must be held.
+func (q *fairQueue) dropLocked(src key.NodePublic) (pkt, bool) {
+	if q.maxLen == 0 {
+		return pkt{}, false
+	}
+	longest := q.bySrc[src]
+	if longest == nil || len(longest.pkts) < q.maxLen {
+		longest = q.byLen[q.maxLen].Front().Value.(*fairSrcQueue)
+	}
+	return q.popLocked(longest), true
+}
+
+// removeSrcLocked removes the empty sender queue sq from q.
+//
+// q.mu must be held.
+func (q *fairQueue) removeSrcLocked(sq *fairSrcQueue) {
+	delete(q.bySrc, sq.src)
+	q.rr.Remove(sq.rrElem)
+	sq.rrElem = nil
+}
+
+// dequeue removes and returns the next packet to send, from the sender
+// whose turn it is. It reports false if q is empty.
+func (q *fairQueue) dequeue() (pkt, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.n == 0 {
+		return pkt{}, false
+	}
+	sq := q.rr.Front().Value.(*fairSrcQueue)
+	p := q.popLocked(sq)
+	if sq.rrElem != nil {
+		// Move sq to the back of the line.
+		q.rr.MoveToBack(sq.rrElem)
+	}
+	if q.n > 0 {
+		q.signalLocked()
+	}
+	return p, true
+}
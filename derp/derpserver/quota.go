@@ -0,0 +1,164 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package derpserver
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	xrate "golang.org/x/time/rate"
+	"tailscale.com/derp"
+	"tailscale.com/types/key"
+)
+
+// minRateLimitBurst is the smallest token bucket size, in bytes, that a
+// client rate limit may have: enough for one maximum-sized send frame.
+const minRateLimitBurst = derp.KeyLen + derp.MaxPacketSize
+
+// ClientRateLimit is a token bucket limit on the rate at which a client may
+// send packets through the server.
+//
+// A client that exceeds its limit isn't disconnected and its packets aren't
+// dropped; the server stops reading from its connection until it's back
+// within its limit, pushing back on the client via TCP flow control.
+type ClientRateLimit struct {
+	// BytesPerSecond is the sustained rate, in bytes per second, at which
+	// the client may send. Zero means no limit.
+	BytesPerSecond int64 `json:",omitempty"`
+
+	// Burst is the size of the token bucket in bytes: how much the client
+	// may send at once after being idle. If less than the size of a
+	// maximum-sized frame (or zero), that size is used instead.
+	Burst int64 `json:",omitempty"`
+}
+
+// IsZero reports whether l is the zero value, meaning no limit.
+func (l ClientRateLimit) IsZero() bool { return l == ClientRateLimit{} }
+
+func (l ClientRateLimit) String() string {
+	if l.BytesPerSecond <= 0 {
+		return "unlimited"
+	}
+	return fmt.Sprintf("%d B/s (burst %d B)", l.BytesPerSecond, l.burst())
+}
+
+// limit returns l's sustained rate as an x/time/rate Limit.
+func (l ClientRateLimit) limit() xrate.Limit {
+	if l.BytesPerSecond <= 0 {
+		return xrate.Inf
+	}
+	return xrate.Limit(l.BytesPerSecond)
+}
+
+// burst returns l's token bucket size, raised to minRateLimitBurst if
+// needed.
+func (l ClientRateLimit) burst() int {
+	return int(max(l.Burst, minRateLimitBurst))
+}
+
+// SetClientRateLimits sets the rate limits for clients sending packets
+// through the server. def applies to all clients without an entry in
+// perKey. Mesh peers are never rate limited.
+//
+// It may be called at any time. Connected clients switch to their new limit
+// immediately.
+func (s *Server) SetClientRateLimits(def ClientRateLimit, perKey map[key.NodePublic]ClientRateLimit) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.defaultRateLimit = def
+	s.perKeyRateLimits = perKey
+	now := time.Now()
+	for k, rl := range s.rateLimiters {
+		rl.set(now, s.rateLimitForKeyLocked(k))
+	}
+}
+
+// rateLimitForKeyLocked returns the rate limit for clients with key k.
+//
+// s.mu must be held.
+func (s *Server) rateLimitForKeyLocked(k key.NodePublic) ClientRateLimit {
+	if l, ok := s.perKeyRateLimits[k]; ok {
+		return l
+	}
+	return s.defaultRateLimit
+}
+
+// clientRateLimiter is the token bucket for the connections of a single
+// client key. It's shared by all of a key's connections so that a client
+// can't get around its limit by connecting multiple times.
+type clientRateLimiter struct {
+	refs int // number of connections using the limiter; guarded by Server.mu
+	lim  *xrate.Limiter
+}
+
+func (rl *clientRateLimiter) set(now time.Time, l ClientRateLimit) {
+	rl.lim.SetLimitAt(now, l.limit())
+	rl.lim.SetBurstAt(now, l.burst())
+}
+
+// acquireRateLimiter returns the rate limiter for clients with key k,
+// creating it if needed. Each call must be paired with a call to
+// releaseRateLimiter.
+func (s *Server) acquireRateLimiter(k key.NodePublic) *clientRateLimiter {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	rl, ok := s.rateLimiters[k]
+	if !ok {
+		l := s.rateLimitForKeyLocked(k)
+		rl = &clientRateLimiter{lim: xrate.NewLimiter(l.limit(), l.burst())}
+		s.rateLimiters[k] = rl
+	}
+	rl.refs++
+	return rl
+}
+
+// releaseRateLimiter releases a rate limiter returned by acquireRateLimiter.
+func (s *Server) releaseRateLimiter(k key.NodePublic) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	rl, ok := s.rateLimiters[k]
+	if !ok {
+		return
+	}
+	rl.refs--
+	if rl.refs <= 0 {
+		delete(s.rateLimiters, k)
+	}
+}
+
+// reserve reserves n bytes from the token bucket, returning how long the
+// client must wait before sending them. If the client doesn't wait that
+// long after all, it must call cancel to return the bytes to the bucket.
+func (rl *clientRateLimiter) reserve(now time.Time, n int) (delay time.Duration, cancel func()) {
+	if rl.lim.Limit() == xrate.Inf {
+		return 0, nil
+	}
+	r := rl.lim.ReserveN(now, min(n, rl.lim.Burst()))
+	return r.DelayFrom(now), r.Cancel
+}
+
+// rateLimit waits until c is within its rate limit to send a frame of n
+// bytes, or until ctx is done.
+func (c *sclient) rateLimit(ctx context.Context, n int) error {
+	if c.rateLimiter == nil {
+		return nil
+	}
+	delay, cancel := c.rateLimiter.reserve(time.Now(), n)
+	if delay <= 0 {
+		return nil
+	}
+	c.s.packetsThrottled.Add(1)
+	c.s.bytesThrottled.Add(int64(n))
+	c.s.throttleDelayMs.Add(delay.Milliseconds())
+	t := time.NewTimer(delay)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		cancel()
+		return ctx.Err()
+	}
+}
@@ -14,6 +14,8 @@ func (src *Share) Clone() *Share {
 	dst := new(Share)
 	*dst = *src
 	dst.BookmarkData = append(src.BookmarkData[:0:0], src.BookmarkData...)
+	dst.AllowedUsers = append(src.AllowedUsers[:0:0], src.AllowedUsers...)
+	dst.AllowedTags = append(src.AllowedTags[:0:0], src.AllowedTags...)
 	return dst
 }
 
@@ -23,6 +25,10 @@ func (src *Share) Clone() *Share {
 	Path         string
 	As           string
 	BookmarkData []byte
+	ReadOnly     bool
+	AllowedUsers []string
+	AllowedTags  []string
+	HideDotFiles bool
 }{})
 
 // Clone duplicates src into dst and reports whether it succeeded.
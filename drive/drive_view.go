@@ -105,10 +105,30 @@ func (v ShareView) BookmarkData() views.ByteSlice[[]byte] {
 	return views.ByteSliceOf(v.ж.BookmarkData)
 }
 
+// ReadOnly, if true, prevents remote nodes from modifying the share,
+// even if they've been granted read-write access to it.
+func (v ShareView) ReadOnly() bool { return v.ж.ReadOnly }
+
+// AllowedUsers and AllowedTags, if either is non-empty, further restrict
+// the remote nodes that can access the share, on top of the access
+// granted by capabilities. Untagged nodes are allowed if they're owned
+// by one of the AllowedUsers (by login name), tagged nodes are allowed if
+// they have any of the AllowedTags.
+func (v ShareView) AllowedUsers() views.Slice[string] { return views.SliceOf(v.ж.AllowedUsers) }
+func (v ShareView) AllowedTags() views.Slice[string]  { return views.SliceOf(v.ж.AllowedTags) }
+
+// HideDotFiles, if true, hides files and directories whose names start
+// with a dot from remote nodes, who can neither list nor access them.
+func (v ShareView) HideDotFiles() bool { return v.ж.HideDotFiles }
+
 // A compilation failure here means this code must be regenerated, with the command at the top of this file.
 var _ShareViewNeedsRegeneration = Share(struct {
 	Name         string
 	Path         string
 	As           string
 	BookmarkData []byte
+	ReadOnly     bool
+	AllowedUsers []string
+	AllowedTags  []string
+	HideDotFiles bool
 }{})
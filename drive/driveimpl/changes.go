@@ -0,0 +1,563 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package driveimpl
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"maps"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"tailscale.com/drive/driveimpl/shared"
+	"tailscale.com/util/rands"
+	"tailscale.com/util/set"
+)
+
+const (
+	// maxTrackedChanges is the number of changes that a changeTracker
+	// remembers. Clients with older sync tokens have to start over.
+	maxTrackedChanges = 10000
+
+	// maxScanEntries is the most files and directories that a changeTracker
+	// tracks. Changes to larger shares aren't tracked.
+	maxScanEntries = 100000
+
+	// minScanInterval is the minimum time between scans of a whole share.
+	// Changes made through the FileServer in the meantime are found by
+	// scanning just the files and directories they were made to.
+	minScanInterval = 5 * time.Second
+
+	// minCachedDirAge is how long ago a directory must have been modified
+	// for its listing to be reused by later scans while its modification
+	// time is unchanged. Entries added sooner after the directory was
+	// listed might not change its modification time, on filesystems whose
+	// timestamps are coarse.
+	minCachedDirAge = 2 * time.Second
+
+	// syncPollInterval is how often a share is rescanned while clients are
+	// waiting for changes, to find changes that weren't made through the
+	// FileServer.
+	syncPollInterval = minScanInterval
+
+	// maxSyncWait is the longest time a client can wait for changes.
+	maxSyncWait = time.Minute
+
+	// maxReportSize is the maximum size of a REPORT request body.
+	maxReportSize = 1 << 20
+
+	syncTokenPrefix = "http://tailscale.com/ns/drive/sync/"
+)
+
+// errTooManyFiles is returned by changeTracker.scan for shares with more
+// than maxScanEntries files and directories.
+var errTooManyFiles = errors.New("too many files to track changes")
+
+// changeTracker tracks changes to the files in a share, in order to serve
+// sync-collection REPORT requests (RFC 6578). This lets clients keep a copy
+// of the share up to date without listing the whole share each time.
+//
+// Changes made through the FileServer are found by scanning the paths they
+// were made to. Changes made outside of Taildrive are found by periodically
+// scanning the whole share, reusing the listings of directories that haven't
+// been modified since. Clients can long-poll for changes by sending a
+// "Prefer: wait=<seconds>" header.
+type changeTracker struct {
+	root   string // local path of the share
+	hashes *contentHashFS
+
+	// epoch identifies this changeTracker in sync tokens, so that tokens from
+	// previous runs aren't mistaken for ours.
+	epoch string
+
+	scanMu sync.Mutex // held while scanning
+	// dirs are the listings of the directories found by the last scan of
+	// the whole share, by path as in files, with "" for the share itself.
+	// It's guarded by scanMu.
+	dirs map[string]dirListing
+
+	// mu guards the below values.
+	mu       sync.Mutex
+	lastScan time.Time            // of the whole share; zero if never scanned
+	rescan   bool                 // whether the whole share must be rescanned before lastScan+minScanInterval
+	pending  set.Set[string]      // paths modified through the FileServer since they were last scanned
+	tooLarge bool                 // whether the last scan found more than maxScanEntries files
+	files    map[string]fileState // by slash-separated path relative to root
+	seq      int64                // sequence number of the latest change
+	dropped  int64                // sequence number of the latest change no longer in changes
+	changes  []change             // oldest first
+	wake     chan struct{}        // closed when the share may have been modified
+}
+
+// fileState is the state of a file or directory, as of the last scan.
+type fileState struct {
+	size    int64
+	modTime time.Time
+	isDir   bool
+}
+
+func stateOf(fi fs.FileInfo) fileState {
+	return fileState{
+		size:    fi.Size(),
+		modTime: fi.ModTime(),
+		isDir:   fi.IsDir(),
+	}
+}
+
+func (st fileState) equal(o fileState) bool {
+	return st.size == o.size && st.modTime.Equal(o.modTime) && st.isDir == o.isDir
+}
+
+// dirListing is the listing of a directory.
+type dirListing struct {
+	modTime time.Time // of the directory when it was listed
+	names   []string
+}
+
+// change records that the file or directory at path was created, modified or
+// deleted.
+type change struct {
+	seq  int64
+	path string
+}
+
+func newChangeTracker(root string, hashes *contentHashFS) *changeTracker {
+	return &changeTracker{
+		root:   root,
+		hashes: hashes,
+		epoch:  rands.HexString(16),
+		wake:   make(chan struct{}),
+	}
+}
+
+// notify tells the changeTracker that the file or directory at p, a
+// slash-separated path relative to the share, may have been modified through
+// the FileServer. If rescan is true, other files may have been modified too,
+// so the whole share is rescanned.
+func (c *changeTracker) notify(p string, rescan bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if rescan {
+		c.rescan = true
+	} else {
+		c.pending.Make()
+		c.pending.Add(p)
+	}
+	close(c.wake)
+	c.wake = make(chan struct{})
+}
+
+// scan scans the share for changes. The whole share is scanned if it hasn't
+// been for minScanInterval or it was modified in ways that need it;
+// otherwise, only the paths it was modified at through the FileServer are.
+// It returns errTooManyFiles if the share is too large for its changes to be
+// tracked.
+func (c *changeTracker) scan() error {
+	c.scanMu.Lock()
+	defer c.scanMu.Unlock()
+
+	c.mu.Lock()
+	full := c.lastScan.IsZero() || c.rescan || time.Since(c.lastScan) >= minScanInterval
+	pending := c.pending
+	c.pending = nil
+	if full {
+		c.rescan = false
+		c.lastScan = time.Now()
+	}
+	tooLarge := c.tooLarge
+	c.mu.Unlock()
+
+	switch {
+	case full:
+		return c.scanAllLocked()
+	case tooLarge:
+		return errTooManyFiles
+	}
+	for _, p := range slices.Sorted(maps.Keys(pending)) {
+		c.scanPathLocked(p)
+	}
+	return nil
+}
+
+// scanAllLocked scans the whole share. c.scanMu must be held.
+func (c *changeTracker) scanAllLocked() error {
+	files := make(map[string]fileState)
+	dirs := make(map[string]dirListing)
+	complete := true
+	if fi, err := os.Stat(c.root); err == nil && fi.IsDir() {
+		complete = c.walk("", fi.ModTime(), time.Now(), files, dirs)
+	}
+	c.dirs = dirs
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !complete {
+		// Forget everything, so that clients start over once there are
+		// few enough files again.
+		c.tooLarge = true
+		c.files = nil
+		c.changes = nil
+		c.seq++
+		c.dropped = c.seq
+		return errTooManyFiles
+	}
+	c.tooLarge = false
+	if c.files == nil {
+		// This is the first scan, there's nothing to compare to.
+		c.files = files
+		return nil
+	}
+	var changed []string
+	for p, st := range files {
+		if old, ok := c.files[p]; !ok || !old.equal(st) {
+			changed = append(changed, p)
+		}
+	}
+	for p := range c.files {
+		if _, ok := files[p]; !ok {
+			changed = append(changed, p)
+		}
+	}
+	slices.Sort(changed)
+	for _, p := range changed {
+		c.addChangeLocked(p)
+	}
+	c.files = files
+	return nil
+}
+
+// walk adds the files and directories within the directory at rel, which
+// was last modified at modTime, to files, and the listings of it and its
+// subdirectories to dirs. It lists only those directories whose listings in
+// c.dirs are out of date, given the time the scan started. It reports whether
+// it found no more than maxScanEntries files and directories in total.
+// c.scanMu must be held.
+func (c *changeTracker) walk(rel string, modTime, started time.Time, files map[string]fileState, dirs map[string]dirListing) bool {
+	l, ok := c.dirs[rel]
+	if !ok || !l.modTime.Equal(modTime) || started.Sub(modTime) < minCachedDirAge {
+		ents, err := os.ReadDir(c.localPath(rel))
+		if err != nil {
+			// Skip anything we can't read.
+			return true
+		}
+		l = dirListing{modTime: modTime, names: make([]string, len(ents))}
+		for i, ent := range ents {
+			l.names[i] = ent.Name()
+		}
+	}
+	dirs[rel] = l
+	for _, name := range l.names {
+		if len(files) >= maxScanEntries {
+			return false
+		}
+		p := path.Join(rel, name)
+		fi, err := os.Lstat(c.localPath(p))
+		if err != nil {
+			continue
+		}
+		st := stateOf(fi)
+		files[p] = st
+		if st.isDir && !c.walk(p, st.modTime, started, files, dirs) {
+			return false
+		}
+	}
+	return true
+}
+
+// scanPathLocked scans the file or directory at p, and its parent directory,
+// after they were modified through the FileServer. c.scanMu must be held.
+func (c *changeTracker) scanPathLocked(p string) {
+	if p == "" {
+		return
+	}
+	// The directories' listings are out of date, even if their
+	// modification times aren't.
+	parent := path.Dir(p)
+	if parent == "." {
+		parent = ""
+	}
+	delete(c.dirs, parent)
+	delete(c.dirs, p)
+
+	found := make(map[string]fileState)
+	if fi, err := os.Lstat(c.localPath(p)); err == nil {
+		st := stateOf(fi)
+		found[p] = st
+		if st.isDir {
+			c.walk(p, st.modTime, time.Now(), found, make(map[string]dirListing))
+		}
+	}
+	if parent != "" {
+		if fi, err := os.Lstat(c.localPath(parent)); err == nil {
+			found[parent] = stateOf(fi)
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.files == nil {
+		return
+	}
+	var changed []string
+	for q, st := range found {
+		if old, ok := c.files[q]; !ok || !old.equal(st) {
+			changed = append(changed, q)
+		}
+	}
+	for q := range c.files {
+		if _, ok := found[q]; !ok && (q == p || q == parent || strings.HasPrefix(q, p+"/")) {
+			changed = append(changed, q)
+		}
+	}
+	slices.Sort(changed)
+	for _, q := range changed {
+		if st, ok := found[q]; ok {
+			c.files[q] = st
+		} else {
+			delete(c.files, q)
+		}
+		c.addChangeLocked(q)
+	}
+}
+
+// addChangeLocked records a change to the file or directory at p. c.mu must
+// be held.
+func (c *changeTracker) addChangeLocked(p string) {
+	c.seq++
+	c.changes = append(c.changes, change{seq: c.seq, path: p})
+	if n := len(c.changes) - maxTrackedChanges; n > 0 {
+		c.dropped = c.changes[n-1].seq
+		c.changes = slices.Delete(c.changes, 0, n)
+	}
+}
+
+// localPath returns the local path of the file or directory at the
+// slash-separated path p relative to the share.
+func (c *changeTracker) localPath(p string) string {
+	return filepath.Join(c.root, filepath.FromSlash(p))
+}
+
+func (c *changeTracker) syncToken(seq int64) string {
+	return fmt.Sprintf("%s%s/%d", syncTokenPrefix, c.epoch, seq)
+}
+
+// parseSyncToken parses a sync token returned by syncToken.
+func (c *changeTracker) parseSyncToken(token string) (seq int64, ok bool) {
+	epoch, seqStr, ok := strings.Cut(strings.TrimPrefix(token, syncTokenPrefix), "/")
+	if !ok || epoch != c.epoch {
+		return 0, false
+	}
+	seq, err := strconv.ParseInt(seqStr, 10, 64)
+	return seq, err == nil
+}
+
+// syncCollection is the body of a sync-collection REPORT request.
+type syncCollection struct {
+	XMLName   xml.Name `xml:"DAV: sync-collection"`
+	SyncToken string   `xml:"DAV: sync-token"`
+	SyncLevel string   `xml:"DAV: sync-level"`
+}
+
+// syncResult is a file or directory reported in response to a sync-collection
+// REPORT request.
+type syncResult struct {
+	path  string
+	state fileState
+	found bool
+}
+
+// ServeHTTP serves sync-collection REPORT requests for the directory at
+// r.URL.Path, listing the files and directories within it that were created,
+// modified or deleted since the sync token in the request.
+func (c *changeTracker) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	wantHash := shared.RequestsContentHash(r)
+	var req syncCollection
+	if err := xml.NewDecoder(io.LimitReader(r.Body, maxReportSize)).Decode(&req); err != nil {
+		http.Error(w, "unsupported report", http.StatusBadRequest)
+		return
+	}
+	var depth int
+	switch strings.TrimSpace(req.SyncLevel) {
+	case "1":
+		depth = 1
+	case "infinite":
+		depth = -1
+	default:
+		http.Error(w, "invalid sync-level", http.StatusBadRequest)
+		return
+	}
+
+	if err := c.scan(); err != nil {
+		w.Header().Set("Content-Type", "text/xml; charset=utf-8")
+		w.WriteHeader(http.StatusInsufficientStorage)
+		io.WriteString(w, `<?xml version="1.0" encoding="UTF-8"?><D:error xmlns:D="DAV:"><D:number-of-matches-within-limits/></D:error>`)
+		return
+	}
+
+	dir := strings.Trim(r.URL.Path, "/")
+	if dir != "" {
+		c.mu.Lock()
+		st, ok := c.files[dir]
+		c.mu.Unlock()
+		if !ok || !st.isDir {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+	}
+	inScope := func(p string) bool {
+		rel := p
+		if dir != "" {
+			var ok bool
+			rel, ok = strings.CutPrefix(p, dir+"/")
+			if !ok {
+				return false
+			}
+		}
+		return depth < 0 || !strings.Contains(rel, "/")
+	}
+
+	var since int64
+	initial := strings.TrimSpace(req.SyncToken) == ""
+	if !initial {
+		var ok bool
+		since, ok = c.parseSyncToken(strings.TrimSpace(req.SyncToken))
+		c.mu.Lock()
+		ok = ok && since >= c.dropped && since <= c.seq
+		c.mu.Unlock()
+		if !ok {
+			w.Header().Set("Content-Type", "text/xml; charset=utf-8")
+			w.WriteHeader(http.StatusForbidden)
+			io.WriteString(w, `<?xml version="1.0" encoding="UTF-8"?><D:error xmlns:D="DAV:"><D:valid-sync-token/></D:error>`)
+			return
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), preferredWait(r))
+	defer cancel()
+	poll := time.NewTicker(syncPollInterval)
+	defer poll.Stop()
+	for {
+		c.mu.Lock()
+		results, seq := c.resultsLocked(initial, since, inScope)
+		wake := c.wake
+		c.mu.Unlock()
+
+		if len(results) > 0 || initial {
+			c.writeResults(w, r, results, seq, wantHash)
+			return
+		}
+		select {
+		case <-wake:
+		case <-poll.C:
+		case <-ctx.Done():
+			if r.Context().Err() == nil {
+				// Timed out waiting for changes.
+				c.writeResults(w, r, nil, seq, false)
+			}
+			return
+		}
+		c.scan() // errors are reported by the next request
+	}
+}
+
+// resultsLocked returns the files and directories within scope that changed
+// since the given sequence number, or all of them if initial is true, along
+// with the sequence number of the latest change. c.mu must be held.
+func (c *changeTracker) resultsLocked(initial bool, since int64, inScope func(string) bool) ([]syncResult, int64) {
+	var results []syncResult
+	if initial {
+		for p, st := range c.files {
+			if inScope(p) {
+				results = append(results, syncResult{path: p, state: st, found: true})
+			}
+		}
+	} else {
+		seen := make(map[string]bool)
+		for _, ch := range c.changes {
+			if ch.seq <= since || seen[ch.path] || !inScope(ch.path) {
+				continue
+			}
+			seen[ch.path] = true
+			st, found := c.files[ch.path]
+			results = append(results, syncResult{path: ch.path, state: st, found: found})
+		}
+	}
+	slices.SortFunc(results, func(a, b syncResult) int {
+		return strings.Compare(a.path, b.path)
+	})
+	return results, c.seq
+}
+
+// writeResults writes the given results as a multistatus response to a
+// sync-collection REPORT request.
+func (c *changeTracker) writeResults(w http.ResponseWriter, r *http.Request, results []syncResult, seq int64, wantHash bool) {
+	var buf bytes.Buffer
+	buf.WriteString(`<?xml version="1.0" encoding="UTF-8"?><D:multistatus xmlns:D="DAV:">`)
+	for _, res := range results {
+		href := "/" + res.path
+		if res.found && res.state.isDir {
+			href += "/"
+		}
+		href = shared.EscapeForXML((&url.URL{Path: href}).EscapedPath())
+		if !res.found {
+			fmt.Fprintf(&buf, `<D:response><D:href>%s</D:href><D:status>HTTP/1.1 404 Not Found</D:status></D:response>`, href)
+			continue
+		}
+
+		fmt.Fprintf(&buf, `<D:response><D:href>%s</D:href><D:propstat><D:prop>`, href)
+		fmt.Fprintf(&buf, `<D:displayname>%s</D:displayname>`, shared.EscapeForXML(path.Base(res.path)))
+		fmt.Fprintf(&buf, `<D:getlastmodified>%s</D:getlastmodified>`, res.state.modTime.UTC().Format(http.TimeFormat))
+		if res.state.isDir {
+			buf.WriteString(`<D:resourcetype><D:collection/></D:resourcetype>`)
+		} else {
+			buf.WriteString(`<D:resourcetype></D:resourcetype>`)
+			fmt.Fprintf(&buf, `<D:getcontentlength>%d</D:getcontentlength>`, res.state.size)
+			fmt.Fprintf(&buf, `<D:getetag>"%x%x"</D:getetag>`, res.state.modTime.UnixNano(), res.state.size)
+			if wantHash {
+				sum, err := c.hashes.contentHash(r.Context(), "/"+res.path, res.state.size, res.state.modTime)
+				if err == nil {
+					fmt.Fprintf(&buf, `<sha256 xmlns="%s">%s</sha256>`, shared.ContentHashProperty.Space, sum)
+				}
+			}
+		}
+		buf.WriteString(`</D:prop><D:status>HTTP/1.1 200 OK</D:status></D:propstat></D:response>`)
+	}
+	fmt.Fprintf(&buf, `<D:sync-token>%s</D:sync-token></D:multistatus>`, shared.EscapeForXML(c.syncToken(seq)))
+
+	w.Header().Set("Content-Type", "text/xml; charset=utf-8")
+	w.WriteHeader(http.StatusMultiStatus)
+	w.Write(buf.Bytes())
+}
+
+// preferredWait returns how long to wait for changes, as requested by the
+// "wait" preference of the Prefer header (RFC 7240).
+func preferredWait(r *http.Request) time.Duration {
+	for _, v := range r.Header.Values("Prefer") {
+		for pref := range strings.SplitSeq(v, ",") {
+			secs, ok := strings.CutPrefix(strings.TrimSpace(pref), "wait=")
+			if !ok {
+				continue
+			}
+			n, err := strconv.Atoi(secs)
+			if err != nil || n < 0 {
+				return 0
+			}
+			return min(time.Duration(n)*time.Second, maxSyncWait)
+		}
+	}
+	return 0
+}
@@ -0,0 +1,104 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package driveimpl
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestChangeTrackerScan(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, contents string) {
+		t.Helper()
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(contents), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("a/x", "x")
+	write("b/y", "y")
+	// Directories modified long enough ago have their listings reused.
+	old := time.Now().Add(-time.Hour)
+	for _, d := range []string{"a", "b"} {
+		if err := os.Chtimes(filepath.Join(dir, d), old, old); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	c := newChangeTracker(dir, nil)
+	var seq int64
+	changed := func() []string {
+		t.Helper()
+		if err := c.scan(); err != nil {
+			t.Fatalf("scan: %v", err)
+		}
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		var paths []string
+		for _, ch := range c.changes {
+			if ch.seq > seq {
+				paths = append(paths, ch.path)
+			}
+		}
+		seq = c.seq
+		slices.Sort(paths)
+		return paths
+	}
+	rescan := func() {
+		c.mu.Lock()
+		c.lastScan = c.lastScan.Add(-minScanInterval)
+		c.mu.Unlock()
+	}
+	if got := changed(); len(got) != 0 {
+		t.Errorf("first scan found changes %q", got)
+	}
+
+	// Changes made through the FileServer are found without a rescan.
+	write("a/x", "xx")
+	c.notify("a/x", false)
+	if diff := cmp.Diff([]string{"a/x"}, changed()); diff != "" {
+		t.Errorf("changes after notify (-want, +got):\n%s", diff)
+	}
+
+	// Other changes are found by rescans, with those of directories whose
+	// modification time is unchanged found by listing them again only
+	// after they're notified.
+	write("b/z", "z")
+	if got := changed(); len(got) != 0 {
+		t.Errorf("changes found before rescan: %q", got)
+	}
+	if err := os.Chtimes(filepath.Join(dir, "b"), old, old); err != nil {
+		t.Fatal(err)
+	}
+	write("a/x", "xxx")
+	rescan()
+	if diff := cmp.Diff([]string{"a/x"}, changed()); diff != "" {
+		t.Errorf("changes after rescan (-want, +got):\n%s", diff)
+	}
+	c.notify("b", false)
+	if diff := cmp.Diff([]string{"b/z"}, changed()); diff != "" {
+		t.Errorf("changes after notify of directory (-want, +got):\n%s", diff)
+	}
+
+	if err := os.RemoveAll(filepath.Join(dir, "b")); err != nil {
+		t.Fatal(err)
+	}
+	c.notify("b", false)
+	if diff := cmp.Diff([]string{"b", "b/y", "b/z"}, changed()); diff != "" {
+		t.Errorf("changes after removing directory (-want, +got):\n%s", diff)
+	}
+	rescan()
+	if got := changed(); len(got) != 0 {
+		t.Errorf("rescan found changes %q", got)
+	}
+}
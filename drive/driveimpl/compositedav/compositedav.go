@@ -36,6 +36,11 @@ type Child struct {
 	// with this Child's WebDAV service.
 	Transport http.RoundTripper
 
+	// HideDotFiles, if true, hides files and directories whose names start
+	// with a dot, returning 404 for requests to them and omitting them from
+	// PROPFIND results.
+	HideDotFiles bool
+
 	rp       *httputil.ReverseProxy
 	initOnce sync.Once
 }
@@ -103,6 +108,9 @@ func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	case "LOCK":
 		h.handleLOCK(w, r, pathComponents, mpl)
 		return
+	case "REPORT":
+		h.handleREPORT(w, r, pathComponents, mpl)
+		return
 	}
 
 	_, shouldInvalidate := cacheInvalidatingMethods[r.Method]
@@ -160,13 +168,20 @@ func (h *Handler) delegate(mpl int, pathComponents []string, w http.ResponseWrit
 			http.Error(w, "Destination across shares is not supported", http.StatusBadRequest)
 			return
 		}
-		updatedDest := shared.JoinEscaped(destinationComponents[mpl:]...)
+		destinationComponents = destinationComponents[mpl:]
+		if hasDotComponent(destinationComponents) {
+			if child := h.GetChild(pathComponents[0]); child != nil && child.HideDotFiles {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+		}
+		updatedDest := shared.JoinEscaped(destinationComponents...)
 		r.Header.Set("Destination", updatedDest)
 	}
 
 	childName := pathComponents[0]
 	child := h.GetChild(childName)
-	if child == nil {
+	if child == nil || child.HideDotFiles && hasDotComponent(pathComponents[1:]) {
 		w.WriteHeader(http.StatusNotFound)
 		return
 	}
@@ -250,6 +265,14 @@ func (h *Handler) findChildLocked(name string) (int, *Child) {
 	return i, child
 }
 
+// hasDotComponent reports whether any of the given path components is the name
+// of a dot file.
+func hasDotComponent(pathComponents []string) bool {
+	return slices.ContainsFunc(pathComponents, func(c string) bool {
+		return strings.HasPrefix(c, ".")
+	})
+}
+
 func (h *Handler) logf(format string, args ...any) {
 	if h.Logf != nil {
 		h.Logf(format, args...)
@@ -17,11 +17,20 @@
 var (
 	responseHrefRegex = regexp.MustCompile(`(?s)(<D:(response|lockroot)>)<D:href>/?([^<]*)/?</D:href>`)
 	ifHrefRegex       = regexp.MustCompile(`^<(https?://[^/]+)?([^>]+)>`)
+	responseRegex     = regexp.MustCompile(`(?s)<D:response><D:href>([^<]*)</D:href>.*?</D:response>`)
 )
 
 func (h *Handler) handlePROPFIND(w http.ResponseWriter, r *http.Request, pathComponents []string, mpl int) {
 	if shouldDelegateToChild(r, pathComponents, mpl) {
 		// Delegate to a Child.
+		if shared.RequestsContentHash(r) {
+			// The StatCache doesn't distinguish between requested properties,
+			// so don't use it for content hashes.
+			status, result := h.delegateRewriting(w, r, pathComponents, mpl)
+			respondRewritten(w, status, result)
+			return
+		}
+
 		depth := getDepth(r)
 
 		status, result := h.StatCache.getOr(r.URL.Path, depth, func() (int, []byte) {
@@ -46,6 +55,19 @@ func (h *Handler) handleLOCK(w http.ResponseWriter, r *http.Request, pathCompone
 	http.Error(w, "locking of top level directories is not allowed", http.StatusMethodNotAllowed)
 }
 
+// handleREPORT handles REPORT requests, such as sync-collection reports. These
+// are only supported within a Child.
+func (h *Handler) handleREPORT(w http.ResponseWriter, r *http.Request, pathComponents []string, mpl int) {
+	if !shared.IsRoot(r.URL.Path) && len(pathComponents) >= mpl {
+		// Delegate to a Child.
+		status, result := h.delegateRewriting(w, r, pathComponents, mpl)
+		respondRewritten(w, status, result)
+		return
+	}
+
+	http.Error(w, "reports on top level directories are not supported", http.StatusMethodNotAllowed)
+}
+
 // shouldDelegateToChild decides whether a request should be delegated to a
 // child filesystem, as opposed to being handled by this filesystem. It checks
 // the depth of the requested path, and if it's deeper than the portion of the
@@ -61,12 +83,29 @@ func (h *Handler) delegateRewriting(w http.ResponseWriter, r *http.Request, path
 
 	h.delegate(mpl, pathComponents[mpl-1:], bw, r)
 
+	b := bw.buf.Bytes()
+	if child := h.GetChild(pathComponents[mpl-1]); child != nil && child.HideDotFiles {
+		b = removeDotFileResponses(b)
+	}
+
 	// Fixup paths to add the requested path as a prefix, escaped for inclusion in XML.
 	pp := shared.EscapeForXML(shared.Join(pathComponents[0:mpl]...))
-	b := responseHrefRegex.ReplaceAll(bw.buf.Bytes(), []byte(fmt.Sprintf("$1<D:href>%s/$3</D:href>", pp)))
+	b = responseHrefRegex.ReplaceAll(b, []byte(fmt.Sprintf("$1<D:href>%s/$3</D:href>", pp)))
 	return bw.status, b
 }
 
+// removeDotFileResponses removes the responses for dot files from the given
+// multistatus response body.
+func removeDotFileResponses(b []byte) []byte {
+	return responseRegex.ReplaceAllFunc(b, func(response []byte) []byte {
+		href := string(responseRegex.FindSubmatch(response)[1])
+		if hasDotComponent(strings.Split(strings.Trim(href, "/"), "/")) {
+			return nil
+		}
+		return response
+	})
+}
+
 func respondRewritten(w http.ResponseWriter, status int, result []byte) {
 	w.Header().Del("Content-Length")
 	w.WriteHeader(status)
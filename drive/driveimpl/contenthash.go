@@ -0,0 +1,134 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package driveimpl
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/tailscale/xnet/webdav"
+	"tailscale.com/drive/driveimpl/shared"
+	"tailscale.com/util/lru"
+)
+
+// maxCachedContentHashes is the maximum number of content hashes that a
+// contentHashFS remembers.
+const maxCachedContentHashes = 10000
+
+type contentHashKey struct{}
+
+// withContentHash returns a context that makes contentHashFS report the
+// content hashes of files opened with it.
+func withContentHash(ctx context.Context) context.Context {
+	return context.WithValue(ctx, contentHashKey{}, true)
+}
+
+// contentHashFS extends a webdav.FileSystem to report the SHA-256 hashes of
+// files' contents as the shared.ContentHashProperty dead property, if the
+// context passed to OpenFile was returned by withContentHash.
+type contentHashFS struct {
+	webdav.FileSystem
+
+	mu     sync.Mutex
+	hashes lru.Cache[string, contentHash] // keyed by name
+}
+
+// contentHash is the hash of a file's contents, along with the size and
+// modification time of the file at the time it was hashed.
+type contentHash struct {
+	size    int64
+	modTime time.Time
+	sum     string
+}
+
+func newContentHashFS(fs webdav.FileSystem) *contentHashFS {
+	return &contentHashFS{
+		FileSystem: fs,
+		hashes:     lru.Cache[string, contentHash]{MaxEntries: maxCachedContentHashes},
+	}
+}
+
+func (fs *contentHashFS) OpenFile(ctx context.Context, name string, flag int, perm os.FileMode) (webdav.File, error) {
+	f, err := fs.FileSystem.OpenFile(ctx, name, flag, perm)
+	if err != nil || flag != os.O_RDONLY || ctx.Value(contentHashKey{}) == nil {
+		return f, err
+	}
+	return &contentHashFile{File: f, fs: fs, ctx: ctx, name: name}, nil
+}
+
+// contentHash returns the hex-encoded SHA-256 hash of the contents of the named
+// file, which has the given size and modification time. Hashes are cached
+// until the file's size or modification time change.
+func (fs *contentHashFS) contentHash(ctx context.Context, name string, size int64, modTime time.Time) (string, error) {
+	fs.mu.Lock()
+	h, ok := fs.hashes.GetOk(name)
+	fs.mu.Unlock()
+	if ok && h.size == size && h.modTime.Equal(modTime) {
+		return h.sum, nil
+	}
+
+	f, err := fs.FileSystem.OpenFile(ctx, name, os.O_RDONLY, 0)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	sha := sha256.New()
+	if _, err := io.Copy(sha, f); err != nil {
+		return "", err
+	}
+	h = contentHash{size: size, modTime: modTime, sum: hex.EncodeToString(sha.Sum(nil))}
+
+	fs.mu.Lock()
+	fs.hashes.Set(name, h)
+	fs.mu.Unlock()
+	return h.sum, nil
+}
+
+// contentHashFile implements webdav.DeadPropsHolder to report the content
+// hash of a file.
+type contentHashFile struct {
+	webdav.File
+	fs   *contentHashFS
+	ctx  context.Context
+	name string
+}
+
+// DeadProps implements webdav.DeadPropsHolder.
+func (f *contentHashFile) DeadProps() (map[xml.Name]webdav.Property, error) {
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if fi.IsDir() {
+		return nil, nil
+	}
+	sum, err := f.fs.contentHash(f.ctx, f.name, fi.Size(), fi.ModTime())
+	if err != nil {
+		return nil, err
+	}
+	return map[xml.Name]webdav.Property{
+		shared.ContentHashProperty: {
+			XMLName:  shared.ContentHashProperty,
+			InnerXML: []byte(sum),
+		},
+	}, nil
+}
+
+// Patch implements webdav.DeadPropsHolder. Dead properties can't be patched.
+func (f *contentHashFile) Patch(patches []webdav.Proppatch) ([]webdav.Propstat, error) {
+	pstat := webdav.Propstat{Status: http.StatusForbidden}
+	for _, patch := range patches {
+		for _, p := range patch.Props {
+			pstat.Props = append(pstat.Props, webdav.Property{XMLName: p.XMLName})
+		}
+	}
+	return []webdav.Propstat{pstat}, nil
+}
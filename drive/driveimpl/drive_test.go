@@ -4,6 +4,7 @@
 package driveimpl
 
 import (
+	"encoding/xml"
 	"fmt"
 	"io"
 	"io/fs"
@@ -133,6 +134,65 @@ func TestPermissions(t *testing.T) {
 	}
 }
 
+func TestShareOptions(t *testing.T) {
+	const (
+		shareRO     = "read only"
+		shareACL    = "acl"
+		shareHidden = "hidden"
+	)
+
+	s := newSystem(t)
+
+	s.addRemote(remote1)
+	s.remotes[remote1].principal = drive.Principal{LoginName: "alice@example.com"}
+	s.addShareWithOptions(remote1, shareRO, drive.PermissionReadWrite, drive.Share{ReadOnly: true})
+	s.addShareWithOptions(remote1, shareACL, drive.PermissionReadWrite, drive.Share{AllowedUsers: []string{"bob@example.com"}})
+	s.addShareWithOptions(remote1, shareHidden, drive.PermissionReadWrite, drive.Share{HideDotFiles: true})
+	s.addShareWithOptions(remote1, share11, drive.PermissionReadWrite, drive.Share{
+		AllowedUsers: []string{"alice@example.com"},
+		AllowedTags:  []string{"tag:server"},
+	})
+
+	s.checkDirList("shares not allowed for principal should be hidden", shared.Join(domain, remote1), shareHidden, shareRO, share11)
+	s.writeFile("writing file to share not allowed for principal should fail", remote1, shareACL, file111, "hello world", false)
+	s.writeFile("writing file to share allowed for principal should succeed", remote1, share11, file111, "hello world", true)
+
+	s.writeFile("writing file to read-only share should fail", remote1, shareRO, file111, "hello world", false)
+	s.write(remote1, shareRO, file111, "hello world")
+	s.checkFileContents(remote1, shareRO, file111)
+	if err := s.client.Remove(pathTo(remote1, shareRO, file111)); err == nil {
+		t.Error("deleting file from read-only share should fail")
+	}
+
+	s.write(remote1, shareHidden, file111, "hello world")
+	s.write(remote1, shareHidden, ".secret", "hello world")
+	if err := os.Mkdir(filepath.Join(s.remotes[remote1].shares[shareHidden], ".git"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	s.write(remote1, shareHidden, ".git/config", "hello world")
+	s.checkDirList("dot files should be hidden", shared.Join(domain, remote1, shareHidden), file111)
+	ms := s.syncCollection(remote1, shareHidden, "", "infinite", 0)
+	if diff := cmp.Diff(map[string]int{file111: 200}, ms.statuses(remote1, shareHidden)); diff != "" {
+		t.Errorf("dot files should be hidden from sync (-want, +got):\n%s", diff)
+	}
+	if _, err := s.client.Read(pathTo(remote1, shareHidden, ".secret")); err == nil {
+		t.Error("reading hidden file should fail")
+	}
+	if _, err := s.client.ReadDir(pathTo(remote1, shareHidden, ".git")); err == nil {
+		t.Error("listing hidden directory should fail")
+	}
+	if _, err := s.client.Read(pathTo(remote1, shareHidden, ".git/config")); err == nil {
+		t.Error("reading file in hidden directory should fail")
+	}
+	s.writeFile("writing hidden file should fail", remote1, shareHidden, ".other", "hello world", false)
+	s.renameFile("renaming file to hidden name should fail", remote1, shareHidden, file111, shareHidden, ".renamed", false)
+	s.checkFileContents(remote1, shareHidden, file111)
+
+	// Tagged nodes are checked against the allowed tags, not their owner.
+	s.remotes[remote1].principal = drive.Principal{LoginName: "alice@example.com", Tags: []string{"tag:other"}}
+	s.checkDirList("shares not allowed for tagged principal should be hidden", shared.Join(domain, remote1), shareHidden, shareRO)
+}
+
 // TestMissingPaths verifies that the fileserver running at localhost
 // correctly handles paths with missing required components.
 //
@@ -427,6 +487,106 @@ func TestUNLOCK(t *testing.T) {
 	}
 }
 
+func TestSyncCollection(t *testing.T) {
+	s := newSystem(t)
+
+	s.addRemote(remote1)
+	s.addShare(remote1, share11, drive.PermissionReadWrite)
+	s.writeFile("writing file to read/write remote should succeed", remote1, share11, file111, "hello world", true)
+	if err := s.client.Mkdir(pathTo(remote1, share11, "dir"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	s.writeFile("writing file to subdirectory should succeed", remote1, share11, "dir/"+file112, "hello", true)
+
+	ms := s.syncCollection(remote1, share11, "", "infinite", 0)
+	if diff := cmp.Diff(map[string]int{file111: 200, "dir": 200, "dir/" + file112: 200}, ms.statuses(remote1, share11)); diff != "" {
+		t.Errorf("initial sync (-want, +got):\n%s", diff)
+	}
+	ms = s.syncCollection(remote1, share11, "", "1", 0)
+	if diff := cmp.Diff(map[string]int{file111: 200, "dir": 200}, ms.statuses(remote1, share11)); diff != "" {
+		t.Errorf("initial sync at level 1 (-want, +got):\n%s", diff)
+	}
+	token := ms.SyncToken
+
+	ms = s.syncCollection(remote1, share11, token, "infinite", 0)
+	if len(ms.Responses) != 0 || ms.SyncToken != token {
+		t.Errorf("sync without changes returned %d responses and token %q, want none and %q", len(ms.Responses), ms.SyncToken, token)
+	}
+
+	// Changes made while waiting are returned as soon as they're made.
+	done := make(chan *multistatus)
+	go func() {
+		done <- s.syncCollection(remote1, share11, token, "infinite", 30)
+	}()
+	time.Sleep(100 * time.Millisecond)
+	if err := s.client.Remove(pathTo(remote1, share11, file111)); err != nil {
+		t.Fatal(err)
+	}
+	ms = <-done
+	if diff := cmp.Diff(map[string]int{file111: 404}, ms.statuses(remote1, share11)); diff != "" {
+		t.Errorf("sync after delete (-want, +got):\n%s", diff)
+	}
+
+	s.writeFile("overwriting file in subdirectory should succeed", remote1, share11, "dir/"+file112, "hello world", true)
+	s.writeFile("writing file to read/write remote should succeed", remote1, share11, file112, "hello world", true)
+	ms2 := s.syncCollection(remote1, share11, token, "infinite", 0)
+	if diff := cmp.Diff(map[string]int{file111: 404, file112: 200, "dir/" + file112: 200}, ms2.statuses(remote1, share11)); diff != "" {
+		t.Errorf("sync from first token (-want, +got):\n%s", diff)
+	}
+	ms3 := s.syncCollection(remote1, share11, ms.SyncToken, "1", 0)
+	if diff := cmp.Diff(map[string]int{file112: 200}, ms3.statuses(remote1, share11)); diff != "" {
+		t.Errorf("sync at level 1 from second token (-want, +got):\n%s", diff)
+	}
+
+	if status := s.syncCollectionStatus(remote1, share11, "http://tailscale.com/ns/drive/sync/bogus/1"); status != http.StatusForbidden {
+		t.Errorf("sync with invalid token got status %d, want %d", status, http.StatusForbidden)
+	}
+
+	// Sync tokens remain valid when other shares change.
+	s.addShare(remote1, share12, drive.PermissionReadWrite)
+	ms4 := s.syncCollection(remote1, share11, ms3.SyncToken, "infinite", 0)
+	if len(ms4.Responses) != 0 || ms4.SyncToken != ms3.SyncToken {
+		t.Errorf("sync after adding share returned %d responses and token %q, want none and %q", len(ms4.Responses), ms4.SyncToken, ms3.SyncToken)
+	}
+}
+
+func TestContentHash(t *testing.T) {
+	s := newSystem(t)
+
+	s.addRemote(remote1)
+	s.addShare(remote1, share11, drive.PermissionReadWrite)
+	s.write(remote1, share11, file111, "hello world")
+	const wantHash = "b94d27b9934d3e08a52e52d7da7dabfac484efe37a5380ee9088f7ace2efcde9" // sha256("hello world")
+
+	propfind := func(body string) *multistatus {
+		req, err := http.NewRequest("PROPFIND", s.urlTo(remote1, share11, file111), strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Depth", "0")
+		return s.doMultistatus(req)
+	}
+	ms := propfind(`<?xml version="1.0"?><D:propfind xmlns:D="DAV:"><D:allprop/></D:propfind>`)
+	if len(ms.Responses) != 1 || ms.Responses[0].Propstat.Prop.SHA256 != "" {
+		t.Errorf("allprop returned content hash: %+v", ms.Responses)
+	}
+	ms = propfind(`<?xml version="1.0"?><D:propfind xmlns:D="DAV:" xmlns:T="http://tailscale.com/ns/drive/"><D:prop><D:getcontentlength/><T:sha256/></D:prop></D:propfind>`)
+	if len(ms.Responses) != 1 || ms.Responses[0].Propstat.Prop.SHA256 != wantHash {
+		t.Errorf("content hash not returned: %+v", ms.Responses)
+	}
+
+	ms = s.syncCollection(remote1, share11, "", "1", 0, `<T:sha256 xmlns:T="http://tailscale.com/ns/drive/"/>`)
+	if len(ms.Responses) != 1 || ms.Responses[0].Propstat.Prop.SHA256 != wantHash {
+		t.Errorf("content hash not returned by sync: %+v", ms.Responses)
+	}
+
+	s.write(remote1, share11, file111, "hello world!")
+	ms = propfind(`<?xml version="1.0"?><D:propfind xmlns:D="DAV:"><D:prop><sha256 xmlns="http://tailscale.com/ns/drive/"/></D:prop></D:propfind>`)
+	if len(ms.Responses) != 1 || ms.Responses[0].Propstat.Prop.SHA256 == wantHash {
+		t.Errorf("stale content hash returned: %+v", ms.Responses)
+	}
+}
+
 type local struct {
 	l  net.Listener
 	fs *FileSystemForLocal
@@ -437,7 +597,9 @@ type remote struct {
 	fs          *FileSystemForRemote
 	fileServer  *FileServer
 	shares      map[string]string
+	options     map[string]drive.Share
 	permissions map[string]drive.Permission
+	principal   drive.Principal
 	mu          sync.RWMutex
 }
 
@@ -452,7 +614,7 @@ func (r *remote) unfreeze() {
 func (r *remote) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 	r.mu.RLock()
 	defer r.mu.RUnlock()
-	r.fs.ServeHTTPWithPerms(r.permissions, w, req)
+	r.fs.ServeHTTPWithPerms(r.permissions, r.principal, w, req)
 }
 
 type system struct {
@@ -514,6 +676,7 @@ func (s *system) addRemote(name string) string {
 		fileServer:  fileServer,
 		fs:          NewFileSystemForRemote(log.Printf),
 		shares:      make(map[string]string),
+		options:     make(map[string]drive.Share),
 		permissions: make(map[string]drive.Permission),
 	}
 	r.fs.SetFileServerAddr(fileServer.Addr())
@@ -539,6 +702,12 @@ func (s *system) addRemote(name string) string {
 }
 
 func (s *system) addShare(remoteName, shareName string, permission drive.Permission) {
+	s.addShareWithOptions(remoteName, shareName, permission, drive.Share{})
+}
+
+// addShareWithOptions adds a share like addShare, using the options
+// (ReadOnly, AllowedUsers, etc.) from opts.
+func (s *system) addShareWithOptions(remoteName, shareName string, permission drive.Permission, opts drive.Share) {
 	r, ok := s.remotes[remoteName]
 	if !ok {
 		s.t.Fatalf("unknown remote %q", remoteName)
@@ -546,14 +715,15 @@ func (s *system) addShare(remoteName, shareName string, permission drive.Permiss
 
 	f := s.t.TempDir()
 	r.shares[shareName] = f
+	r.options[shareName] = opts
 	r.permissions[shareName] = permission
 
 	shares := make([]*drive.Share, 0, len(r.shares))
 	for shareName, folder := range r.shares {
-		shares = append(shares, &drive.Share{
-			Name: shareName,
-			Path: folder,
-		})
+		share := r.options[shareName]
+		share.Name = shareName
+		share.Path = folder
+		shares = append(shares, &share)
 	}
 	slices.SortFunc(shares, drive.CompareShares)
 	r.fs.SetShares(shares)
@@ -735,6 +905,111 @@ func fileInfoToStatic(fi fs.FileInfo, fixupMode bool) fs.FileInfo {
 	}
 }
 
+// multistatus is a parsed WebDAV multistatus response.
+type multistatus struct {
+	Responses []struct {
+		Href     string `xml:"href"`
+		Status   string `xml:"status"`
+		Propstat struct {
+			Prop struct {
+				SHA256 string `xml:"http://tailscale.com/ns/drive/ sha256"`
+			} `xml:"prop"`
+			Status string `xml:"status"`
+		} `xml:"propstat"`
+	} `xml:"response"`
+	SyncToken string `xml:"sync-token"`
+}
+
+// statuses returns the status of each response, keyed by path relative to the
+// given share.
+func (ms *multistatus) statuses(remoteName, shareName string) map[string]int {
+	prefix := "/" + pathTo(remoteName, shareName, "") + "/"
+	statuses := make(map[string]int)
+	for _, r := range ms.Responses {
+		// Only the part of the href below the share is escaped.
+		p, err := url.PathUnescape(strings.TrimPrefix(r.Href, prefix))
+		if err != nil {
+			p = r.Href
+		}
+		status := r.Status
+		if status == "" {
+			status = r.Propstat.Status
+		}
+		var code int
+		fmt.Sscanf(status, "HTTP/1.1 %d", &code)
+		statuses[strings.TrimSuffix(p, "/")] = code
+	}
+	return statuses
+}
+
+func (s *system) urlTo(remoteName, shareName, name string) string {
+	u := fmt.Sprintf("http://%s/%s/%s/%s",
+		s.local.l.Addr(),
+		url.PathEscape(domain),
+		url.PathEscape(remoteName),
+		url.PathEscape(shareName))
+	if name != "" {
+		u += "/" + url.PathEscape(name)
+	}
+	return u
+}
+
+func (s *system) newSyncCollectionRequest(remoteName, shareName, token, level string, props ...string) *http.Request {
+	body := fmt.Sprintf(`<?xml version="1.0"?><D:sync-collection xmlns:D="DAV:"><D:sync-token>%s</D:sync-token><D:sync-level>%s</D:sync-level><D:prop><D:getetag/>%s</D:prop></D:sync-collection>`,
+		token, level, strings.Join(props, ""))
+	req, err := http.NewRequest("REPORT", s.urlTo(remoteName, shareName, ""), strings.NewReader(body))
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	return req
+}
+
+// syncCollection sends a sync-collection REPORT for the given share, waiting
+// up to wait seconds for changes.
+func (s *system) syncCollection(remoteName, shareName, token, level string, wait int, props ...string) *multistatus {
+	req := s.newSyncCollectionRequest(remoteName, shareName, token, level, props...)
+	req.Header.Set("Prefer", fmt.Sprintf("wait=%d", wait))
+	return s.doMultistatus(req)
+}
+
+// syncCollectionStatus sends a sync-collection REPORT for the given share and
+// returns the response's status code.
+func (s *system) syncCollectionStatus(remoteName, shareName, token string) int {
+	req := s.newSyncCollectionRequest(remoteName, shareName, token, "infinite")
+	client := &http.Client{
+		Transport: &http.Transport{DisableKeepAlives: true},
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	resp.Body.Close()
+	return resp.StatusCode
+}
+
+func (s *system) doMultistatus(req *http.Request) *multistatus {
+	client := &http.Client{
+		Transport: &http.Transport{DisableKeepAlives: true},
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusMultiStatus {
+		s.t.Fatalf("%s %s: got status %d, want %d: %s", req.Method, req.URL, resp.StatusCode, http.StatusMultiStatus, body)
+	}
+	var ms multistatus
+	if err := xml.Unmarshal(body, &ms); err != nil {
+		s.t.Fatalf("failed to parse multistatus: %v\n%s", err, body)
+	}
+	return &ms
+}
+
 func pathTo(remote, share, name string) string {
 	return path.Join(domain, remote, share, name)
 }
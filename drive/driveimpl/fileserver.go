@@ -8,8 +8,10 @@
 	"crypto/subtle"
 	"encoding/hex"
 	"fmt"
+	"maps"
 	"net"
 	"net/http"
+	"strings"
 	"sync"
 
 	"github.com/tailscale/xnet/webdav"
@@ -22,8 +24,12 @@
 type FileServer struct {
 	ln            net.Listener
 	secretToken   string
-	shareHandlers map[string]http.Handler
-	sharesMu      sync.RWMutex
+	shareHandlers map[string]*shareHandler
+	// oldShareHandlers are the shareHandlers cleared by ClearSharesLocked,
+	// for AddShareLocked to reuse until UnlockShares is called, so that
+	// their locks and changes are kept.
+	oldShareHandlers map[string]*shareHandler
+	sharesMu         sync.RWMutex
 }
 
 // NewFileServer constructs a FileServer.
@@ -57,7 +63,7 @@ func NewFileServer() (*FileServer, error) {
 	return &FileServer{
 		ln:            ln,
 		secretToken:   secretToken,
-		shareHandlers: make(map[string]http.Handler),
+		shareHandlers: make(map[string]*shareHandler),
 	}, nil
 }
 
@@ -89,21 +95,38 @@ func (s *FileServer) LockShares() {
 
 // UnlockShares unlocks the map of shares.
 func (s *FileServer) UnlockShares() {
+	s.oldShareHandlers = nil
 	s.sharesMu.Unlock()
 }
 
 // ClearSharesLocked clears the map of shares, assuming that LockShares() has
 // been called first.
 func (s *FileServer) ClearSharesLocked() {
-	s.shareHandlers = make(map[string]http.Handler)
+	if s.oldShareHandlers == nil {
+		s.oldShareHandlers = s.shareHandlers
+	} else {
+		maps.Copy(s.oldShareHandlers, s.shareHandlers)
+	}
+	s.shareHandlers = make(map[string]*shareHandler)
 }
 
 // AddShareLocked adds a share to the map of shares, assuming that LockShares()
-// has been called first.
+// has been called first. If a share with the same name and path was cleared
+// since then, it's added back as it was, so that its clients' locks and sync
+// tokens remain valid.
 func (s *FileServer) AddShareLocked(share, path string) {
-	s.shareHandlers[share] = &webdav.Handler{
-		FileSystem: &birthTimingFS{webdav.Dir(path)},
-		LockSystem: webdav.NewMemLS(),
+	if h, ok := s.oldShareHandlers[share]; ok && h.path == path {
+		s.shareHandlers[share] = h
+		return
+	}
+	fs := newContentHashFS(&birthTimingFS{webdav.Dir(path)})
+	s.shareHandlers[share] = &shareHandler{
+		path: path,
+		webdav: &webdav.Handler{
+			FileSystem: fs,
+			LockSystem: webdav.NewMemLS(),
+		},
+		changes: newChangeTracker(path, fs),
 	}
 }
 
@@ -161,6 +184,33 @@ func (s *FileServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	h.ServeHTTP(w, r)
 }
 
+// shareHandler serves a single share over WebDAV, along with sync-collection
+// REPORTs of changes to it.
+type shareHandler struct {
+	path    string // local path of the share
+	webdav  *webdav.Handler
+	changes *changeTracker
+}
+
+func (h *shareHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "REPORT":
+		h.changes.ServeHTTP(w, r)
+		return
+	case "PROPFIND":
+		if shared.RequestsContentHash(r) {
+			r = r.WithContext(withContentHash(r.Context()))
+		}
+	}
+	h.webdav.ServeHTTP(w, r)
+	if writeMethods[r.Method] {
+		// Copies and moves modify paths other than the request's, at
+		// their destinations.
+		rescan := r.Method == "COPY" || r.Method == "MOVE"
+		h.changes.notify(strings.Trim(r.URL.Path, "/"), rescan)
+	}
+}
+
 func (s *FileServer) Close() error {
 	return s.ln.Close()
 }
@@ -159,6 +159,7 @@ func (s *FileSystemForRemote) buildChild(share *drive.Share) *compositedav.Child
 		Child: &dirfs.Child{
 			Name: share.Name,
 		},
+		HideDotFiles: share.HideDotFiles,
 		BaseURL: func() (string, error) {
 			secretToken, _, err := getTokenAndAddr(share.Name)
 			if err != nil {
@@ -199,11 +200,29 @@ func (s *FileSystemForRemote) buildChild(share *drive.Share) *compositedav.Child
 }
 
 // ServeHTTPWithPerms implements drive.FileSystemForRemote.
-func (s *FileSystemForRemote) ServeHTTPWithPerms(permissions drive.Permissions, w http.ResponseWriter, r *http.Request) {
+func (s *FileSystemForRemote) ServeHTTPWithPerms(permissions drive.Permissions, principal drive.Principal, w http.ResponseWriter, r *http.Request) {
+	s.mu.RLock()
+	shares := s.shares
+	childrenMap := s.children
+	s.mu.RUnlock()
+
+	// permissionFor returns the permission to the named share after applying
+	// the share's own restrictions.
+	permissionFor := func(name string) drive.Permission {
+		permission := permissions.For(name)
+		i, found := slices.BinarySearchFunc(shares, name, func(s *drive.Share, name string) int {
+			return strings.Compare(s.Name, name)
+		})
+		if found {
+			permission = shares[i].PermissionFor(principal, permission)
+		}
+		return permission
+	}
+
 	isWrite := writeMethods[r.Method]
 	if isWrite {
 		share := shared.CleanAndSplit(r.URL.Path)[0]
-		switch permissions.For(share) {
+		switch permissionFor(share) {
 		case drive.PermissionNone:
 			// If we have no permissions to this share, treat it as not found
 			// to avoid leaking any information about the share's existence.
@@ -215,14 +234,10 @@ func (s *FileSystemForRemote) ServeHTTPWithPerms(permissions drive.Permissions,
 		}
 	}
 
-	s.mu.RLock()
-	childrenMap := s.children
-	s.mu.RUnlock()
-
 	children := make([]*compositedav.Child, 0, len(childrenMap))
 	// filter out shares to which the connecting principal has no access
 	for name, child := range childrenMap {
-		if permissions.For(name) == drive.PermissionNone {
+		if permissionFor(name) == drive.PermissionNone {
 			continue
 		}
 
@@ -6,11 +6,50 @@
 import (
 	"bytes"
 	"encoding/xml"
+	"io"
+	"net/http"
 )
 
+// ContentHashProperty is the name of the WebDAV property holding the
+// hex-encoded SHA-256 hash of a file's contents. Since computing it requires
+// reading the whole file, it's only returned when explicitly requested.
+var ContentHashProperty = xml.Name{Space: "http://tailscale.com/ns/drive/", Local: "sha256"}
+
+// maxRequestXMLSize is the maximum size of a PROPFIND or REPORT request body
+// that RequestsContentHash inspects.
+const maxRequestXMLSize = 1 << 20
+
 // EscapeForXML escapes the given string for use in XML text.
 func EscapeForXML(s string) string {
 	result := bytes.NewBuffer(nil)
 	xml.Escape(result, []byte(s))
 	return result.String()
 }
+
+// RequestsContentHash reports whether the XML body of the given PROPFIND or
+// REPORT request explicitly names the ContentHashProperty. It replaces r.Body
+// so that the body can still be read afterwards.
+func RequestsContentHash(r *http.Request) bool {
+	if r.Body == nil || r.Body == http.NoBody {
+		return false
+	}
+	b, err := io.ReadAll(io.LimitReader(r.Body, maxRequestXMLSize))
+	r.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(b), r.Body), r.Body}
+	if err != nil {
+		return false
+	}
+
+	dec := xml.NewDecoder(bytes.NewReader(b))
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			return false
+		}
+		if se, ok := tok.(xml.StartElement); ok && se.Name == ContentHashProperty {
+			return true
+		}
+	}
+}
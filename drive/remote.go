@@ -9,7 +9,10 @@
 	"bytes"
 	"errors"
 	"net/http"
+	"slices"
 	"strings"
+
+	"tailscale.com/types/views"
 )
 
 var (
@@ -46,6 +49,55 @@ type Share struct {
 	// hold on to a security-scoped bookmark. That bookmark is stored here. See
 	// https://developer.apple.com/documentation/security/app_sandbox/accessing_files_from_the_macos_app_sandbox#4144043
 	BookmarkData []byte `json:"bookmarkData,omitempty"`
+
+	// ReadOnly, if true, prevents remote nodes from modifying the share,
+	// even if they've been granted read-write access to it.
+	ReadOnly bool `json:"readOnly,omitempty"`
+
+	// AllowedUsers and AllowedTags, if either is non-empty, further restrict
+	// the remote nodes that can access the share, on top of the access
+	// granted by capabilities. Untagged nodes are allowed if they're owned
+	// by one of the AllowedUsers (by login name), tagged nodes are allowed if
+	// they have any of the AllowedTags.
+	AllowedUsers []string `json:"allowedUsers,omitempty"`
+	AllowedTags  []string `json:"allowedTags,omitempty"`
+
+	// HideDotFiles, if true, hides files and directories whose names start
+	// with a dot from remote nodes, who can neither list nor access them.
+	HideDotFiles bool `json:"hideDotFiles,omitempty"`
+}
+
+// Principal identifies a remote node accessing shares, for the purpose of
+// checking it against Share.AllowedUsers and Share.AllowedTags.
+type Principal struct {
+	// LoginName is the login name of the user who owns the node. It's ignored
+	// for tagged nodes.
+	LoginName string
+
+	// Tags are the node's tags, if any.
+	Tags []string
+}
+
+// PermissionFor returns the permission that principal has to the share, given
+// that it was granted permission to it by capabilities.
+func (s *Share) PermissionFor(principal Principal, permission Permission) Permission {
+	if len(s.AllowedUsers) > 0 || len(s.AllowedTags) > 0 {
+		allowed := false
+		if len(principal.Tags) > 0 {
+			allowed = slices.ContainsFunc(principal.Tags, func(tag string) bool {
+				return slices.Contains(s.AllowedTags, tag)
+			})
+		} else {
+			allowed = principal.LoginName != "" && slices.Contains(s.AllowedUsers, principal.LoginName)
+		}
+		if !allowed {
+			return PermissionNone
+		}
+	}
+	if s.ReadOnly && permission > PermissionReadOnly {
+		return PermissionReadOnly
+	}
+	return permission
 }
 
 func ShareViewsEqual(a, b ShareView) bool {
@@ -55,7 +107,9 @@ func ShareViewsEqual(a, b ShareView) bool {
 	if !a.Valid() || !b.Valid() {
 		return false
 	}
-	return a.Name() == b.Name() && a.Path() == b.Path() && a.As() == b.As() && a.BookmarkData().Equal(b.ж.BookmarkData)
+	return a.Name() == b.Name() && a.Path() == b.Path() && a.As() == b.As() && a.BookmarkData().Equal(b.ж.BookmarkData) &&
+		a.ReadOnly() == b.ReadOnly() && a.HideDotFiles() == b.HideDotFiles() &&
+		views.SliceEqual(a.AllowedUsers(), b.AllowedUsers()) && views.SliceEqual(a.AllowedTags(), b.AllowedTags())
 }
 
 func SharesEqual(a, b *Share) bool {
@@ -65,7 +119,9 @@ func SharesEqual(a, b *Share) bool {
 	if a == nil || b == nil {
 		return false
 	}
-	return a.Name == b.Name && a.Path == b.Path && a.As == b.As && bytes.Equal(a.BookmarkData, b.BookmarkData)
+	return a.Name == b.Name && a.Path == b.Path && a.As == b.As && bytes.Equal(a.BookmarkData, b.BookmarkData) &&
+		a.ReadOnly == b.ReadOnly && a.HideDotFiles == b.HideDotFiles &&
+		slices.Equal(a.AllowedUsers, b.AllowedUsers) && slices.Equal(a.AllowedTags, b.AllowedTags)
 }
 
 func CompareShares(a, b *Share) int {
@@ -103,8 +159,9 @@ type FileSystemForRemote interface {
 
 	// ServeHTTPWithPerms behaves like the similar method from http.Handler but
 	// also accepts a Permissions map that captures the permissions of the
-	// connecting node.
-	ServeHTTPWithPerms(permissions Permissions, w http.ResponseWriter, r *http.Request)
+	// connecting node, and the Principal identifying it, which are further
+	// restricted by each Share's options.
+	ServeHTTPWithPerms(permissions Permissions, principal Principal, w http.ResponseWriter, r *http.Request)
 
 	// Close() stops serving the WebDAV content
 	Close() error
@@ -38,3 +38,32 @@ func TestNormalizeShareName(t *testing.T) {
 		})
 	}
 }
+
+func TestSharePermissionFor(t *testing.T) {
+	alice := Principal{LoginName: "alice@example.com"}
+	server := Principal{LoginName: "alice@example.com", Tags: []string{"tag:other", "tag:server"}}
+	tests := []struct {
+		name      string
+		share     Share
+		principal Principal
+		granted   Permission
+		want      Permission
+	}{
+		{"no-restrictions", Share{}, alice, PermissionReadWrite, PermissionReadWrite},
+		{"read-only", Share{ReadOnly: true}, alice, PermissionReadWrite, PermissionReadOnly},
+		{"read-only-none", Share{ReadOnly: true}, alice, PermissionNone, PermissionNone},
+		{"allowed-user", Share{AllowedUsers: []string{"alice@example.com"}}, alice, PermissionReadWrite, PermissionReadWrite},
+		{"allowed-user-not-granted", Share{AllowedUsers: []string{"alice@example.com"}}, alice, PermissionNone, PermissionNone},
+		{"other-user", Share{AllowedUsers: []string{"bob@example.com"}}, alice, PermissionReadWrite, PermissionNone},
+		{"only-tags", Share{AllowedTags: []string{"tag:server"}}, alice, PermissionReadWrite, PermissionNone},
+		{"allowed-tag", Share{AllowedTags: []string{"tag:server"}, ReadOnly: true}, server, PermissionReadWrite, PermissionReadOnly},
+		{"tagged-owner", Share{AllowedUsers: []string{"alice@example.com"}}, server, PermissionReadWrite, PermissionNone},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.share.PermissionFor(tt.principal, tt.granted); got != tt.want {
+				t.Errorf("PermissionFor = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,52 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package envknob
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSetenvRegistered(t *testing.T) {
+	const (
+		strVar      = "TS_TEST_ENVKNOB_STRING"
+		boolVar     = "TS_TEST_ENVKNOB_BOOL"
+		optBoolVar  = "TS_TEST_ENVKNOB_OPT_BOOL"
+		durationVar = "TS_TEST_ENVKNOB_DURATION"
+		intVar      = "TS_TEST_ENVKNOB_INT"
+	)
+	str := RegisterString(strVar)
+	b := RegisterBool(boolVar)
+	optBool := RegisterOptBool(optBoolVar)
+	dur := RegisterDuration(durationVar)
+	n := RegisterInt(intVar)
+
+	Setenv(strVar, "foo")
+	Setenv(boolVar, "true")
+	Setenv(optBoolVar, "false")
+	Setenv(durationVar, "3s")
+	Setenv(intVar, "42")
+	if got := str(); got != "foo" {
+		t.Errorf("string = %q, want %q", got, "foo")
+	}
+	if got := b(); !got {
+		t.Errorf("bool = %v, want true", got)
+	}
+	if got, ok := optBool().Get(); got || !ok {
+		t.Errorf("opt bool = %v, %v; want false, true", got, ok)
+	}
+	if got := dur(); got != 3*time.Second {
+		t.Errorf("duration = %v, want 3s", got)
+	}
+	if got := n(); got != 42 {
+		t.Errorf("int = %v, want 42", got)
+	}
+
+	for _, v := range []string{strVar, boolVar, optBoolVar, durationVar, intVar} {
+		Setenv(v, "")
+	}
+	if str() != "" || b() || optBool() != "" || dur() != 0 || n() != 0 {
+		t.Errorf("after unsetting: %q, %v, %q, %v, %v; want zero values", str(), b(), optBool(), dur(), n())
+	}
+}
@@ -0,0 +1,13 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+// Code generated by gen.go; DO NOT EDIT.
+
+//go:build ts_omit_tkabolt
+
+package buildfeatures
+
+// HasTKABolt is whether the binary was built with support for modular feature "Opt-in single-file Tailnet Lock storage (TS_TKA_BOLT_STORAGE)".
+// Specifically, it's whether the binary was NOT built with the "ts_omit_tkabolt" build tag.
+// It's a const so it can be used for dead code elimination.
+const HasTKABolt = false
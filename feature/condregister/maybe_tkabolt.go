@@ -0,0 +1,8 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build !ts_omit_tkabolt && !ts_omit_tailnetlock && !plan9 && !js && !wasip1
+
+package condregister
+
+import _ "tailscale.com/feature/tkabolt"
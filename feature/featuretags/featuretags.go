@@ -262,6 +262,7 @@ type FeatureMeta struct {
 	},
 	"tailnetlock": {Sym: "TailnetLock", Desc: "Tailnet Lock support"},
 	"tap":         {Sym: "Tap", Desc: "Experimental Layer 2 (ethernet) support"},
+	"tkabolt":     {Sym: "TKABolt", Desc: "Opt-in single-file Tailnet Lock storage (TS_TKA_BOLT_STORAGE)", Deps: []FeatureTag{"tailnetlock"}},
 	"tpm":         {Sym: "TPM", Desc: "TPM support"},
 	"unixsocketidentity": {
 		Sym:  "UnixSocketIdentity",
@@ -10,8 +10,11 @@
 	"fmt"
 	"net/http"
 	"net/netip"
+	"reflect"
+	"strings"
 
 	"tailscale.com/disco"
+	"tailscale.com/envknob"
 	"tailscale.com/feature"
 	"tailscale.com/ipn"
 	"tailscale.com/ipn/ipnext"
@@ -21,6 +24,7 @@
 	"tailscale.com/net/udprelay/status"
 	"tailscale.com/syncs"
 	"tailscale.com/tailcfg"
+	"tailscale.com/tstime"
 	"tailscale.com/types/key"
 	"tailscale.com/types/logger"
 	"tailscale.com/types/ptr"
@@ -33,6 +37,73 @@
 // It is also the [extension] name and the log prefix.
 const featureName = "relayserver"
 
+// The following environment variables configure the peer relay server's
+// [status.ServerPolicy], unless the node has the
+// [tailcfg.NodeAttrRelayServerPolicy] node attribute; see its docs.
+var (
+	envAllowTags           = envknob.RegisterString("TS_RELAY_SERVER_ALLOW_TAGS")  // comma-separated
+	envAllowUsers          = envknob.RegisterString("TS_RELAY_SERVER_ALLOW_USERS") // comma-separated login names
+	envMaxEndpoints        = envknob.RegisterInt("TS_RELAY_SERVER_MAX_ENDPOINTS")
+	envMaxBytesPerSecond   = envknob.RegisterInt("TS_RELAY_SERVER_MAX_BYTES_PER_SECOND")
+	envMaxPacketsPerSecond = envknob.RegisterInt("TS_RELAY_SERVER_MAX_PACKETS_PER_SECOND")
+	envBindLifetime        = envknob.RegisterDuration("TS_RELAY_SERVER_BIND_LIFETIME")
+	envIdleTimeout         = envknob.RegisterDuration("TS_RELAY_SERVER_IDLE_TIMEOUT")
+)
+
+// policyFromEnv returns the peer relay server policy configured by
+// environment variables.
+func policyFromEnv() status.ServerPolicy {
+	split := func(s string) []string {
+		return strings.FieldsFunc(s, func(r rune) bool { return r == ',' || r == ' ' })
+	}
+	return status.ServerPolicy{
+		AllowTags:           split(envAllowTags()),
+		AllowUsers:          split(envAllowUsers()),
+		MaxEndpoints:        envMaxEndpoints(),
+		MaxBytesPerSecond:   int64(envMaxBytesPerSecond()),
+		MaxPacketsPerSecond: int64(envMaxPacketsPerSecond()),
+		BindLifetime:        envBindLifetime(),
+		SteadyStateLifetime: envIdleTimeout(),
+	}
+}
+
+// capPolicy is the value of the [tailcfg.NodeAttrRelayServerPolicy] node
+// attribute: a [status.ServerPolicy], with durations as Go duration
+// strings.
+type capPolicy struct {
+	AllowTags           []string
+	AllowUsers          []string
+	MaxEndpoints        int
+	MaxBytesPerSecond   int64
+	MaxPacketsPerSecond int64
+	BindLifetime        tstime.GoDuration
+	SteadyStateLifetime tstime.GoDuration
+}
+
+// policyForNode returns the peer relay server policy for the self node:
+// that of its [tailcfg.NodeAttrRelayServerPolicy] node attribute, if it has
+// one, or else that configured by environment variables.
+func policyForNode(self tailcfg.NodeView, logf logger.Logf) status.ServerPolicy {
+	if !self.Valid() || !self.HasCap(tailcfg.NodeAttrRelayServerPolicy) {
+		return policyFromEnv()
+	}
+	ps, err := tailcfg.UnmarshalNodeCapViewJSON[capPolicy](self.CapMap(), tailcfg.NodeAttrRelayServerPolicy)
+	if err != nil || len(ps) == 0 {
+		logf("invalid %s node attribute (%v); using environment policy", tailcfg.NodeAttrRelayServerPolicy, err)
+		return policyFromEnv()
+	}
+	p := ps[0]
+	return status.ServerPolicy{
+		AllowTags:           p.AllowTags,
+		AllowUsers:          p.AllowUsers,
+		MaxEndpoints:        p.MaxEndpoints,
+		MaxBytesPerSecond:   p.MaxBytesPerSecond,
+		MaxPacketsPerSecond: p.MaxPacketsPerSecond,
+		BindLifetime:        p.BindLifetime.Duration,
+		SteadyStateLifetime: p.SteadyStateLifetime.Duration,
+	}
+}
+
 func init() {
 	feature.Register(featureName)
 	ipnext.RegisterExtension(featureName, newExtension)
@@ -72,7 +143,8 @@ func newExtension(logf logger.Logf, sb ipnext.SafeBackend) (ipnext.Extension, er
 		newServerFn: func(logf logger.Logf, port uint16, onlyStaticAddrPorts bool) (relayServer, error) {
 			return udprelay.NewServer(logf, port, onlyStaticAddrPorts, sb.Sys().UserMetricsRegistry())
 		},
-		logf: logger.WithPrefix(logf, featureName+": "),
+		logf:   logger.WithPrefix(logf, featureName+": "),
+		policy: policyFromEnv(),
 	}
 	e.ec = sb.Sys().Bus.Get().Client("relayserver.extension")
 	e.respPub = eventbus.Publish[magicsock.UDPRelayAllocResp](e.ec)
@@ -84,8 +156,10 @@ func newExtension(logf logger.Logf, sb ipnext.SafeBackend) (ipnext.Extension, er
 // relayServer is an interface for [udprelay.Server].
 type relayServer interface {
 	Close() error
-	AllocateEndpoint(discoA, discoB key.DiscoPublic) (endpoint.ServerEndpoint, error)
+	AllocateEndpoint(discoA, discoB key.DiscoPublic, idA, idB udprelay.ClientIdentity) (endpoint.ServerEndpoint, error)
 	GetSessions() []status.ServerSession
+	SetPolicy(status.ServerPolicy)
+	GetPolicyStatus() (p status.ServerPolicy, deniedByPolicy, deniedByQuota uint64)
 	SetDERPMapView(tailcfg.DERPMapView)
 	SetStaticAddrPorts(addrPorts views.Slice[netip.AddrPort])
 }
@@ -99,12 +173,14 @@ type extension struct {
 	respPub     *eventbus.Publisher[magicsock.UDPRelayAllocResp]
 
 	mu                            syncs.Mutex                 // guards the following fields
+	policy                        status.ServerPolicy         // from policyForNode
 	shutdown                      bool                        // true if Shutdown() has been called
 	rs                            relayServer                 // nil when disabled
 	port                          *uint16                     // ipn.Prefs.RelayServerPort, nil if disabled
 	staticEndpoints               views.Slice[netip.AddrPort] // ipn.Prefs.RelayServerStaticEndpoints
 	derpMapView                   tailcfg.DERPMapView         // latest seen over the eventbus
 	hasNodeAttrDisableRelayServer bool                        // [tailcfg.NodeAttrDisableRelayServer]
+	host                          ipnext.Host                 // from Init
 }
 
 // Name implements [ipnext.Extension].
@@ -115,6 +191,9 @@ func (e *extension) Name() string {
 // Init implements [ipnext.Extension] by registering callbacks and providers
 // for the duration of the extension's lifetime.
 func (e *extension) Init(host ipnext.Host) error {
+	e.mu.Lock()
+	e.host = host
+	e.mu.Unlock()
 	profile, prefs := host.Profiles().CurrentProfileState()
 	e.profileStateChanged(profile, prefs, false)
 	host.Hooks().ProfileStateChange.Add(e.profileStateChanged)
@@ -146,7 +225,13 @@ func (e *extension) onAllocReq(req magicsock.UDPRelayAllocReq) {
 			return
 		}
 	}
-	se, err := e.rs.AllocateEndpoint(req.Message.ClientDisco[0], req.Message.ClientDisco[1])
+	var ids [2]udprelay.ClientIdentity
+	if e.policy.HasACL() {
+		for i, k := range req.Message.ClientDisco {
+			ids[i] = e.clientIdentityLocked(k)
+		}
+	}
+	se, err := e.rs.AllocateEndpoint(req.Message.ClientDisco[0], req.Message.ClientDisco[1], ids[0], ids[1])
 	if err != nil {
 		e.logf("error allocating endpoint: %v", err)
 		return
@@ -174,6 +259,29 @@ func (e *extension) onAllocReq(req magicsock.UDPRelayAllocReq) {
 	})
 }
 
+// clientIdentityLocked returns the identity of the peer with disco key k,
+// or the zero value if it's not known.
+func (e *extension) clientIdentityLocked(k key.DiscoPublic) udprelay.ClientIdentity {
+	if e.host == nil {
+		return udprelay.ClientIdentity{}
+	}
+	nb := e.host.NodeBackend()
+	peers := nb.AppendMatchingPeers(nil, func(n tailcfg.NodeView) bool {
+		return n.DiscoKey() == k
+	})
+	if len(peers) == 0 {
+		return udprelay.ClientIdentity{}
+	}
+	n := peers[0]
+	if n.IsTagged() {
+		return udprelay.ClientIdentity{Tags: n.Tags().AsSlice()}
+	}
+	if u, ok := nb.UserByID(n.User()); ok {
+		return udprelay.ClientIdentity{User: u.LoginName()}
+	}
+	return udprelay.ClientIdentity{}
+}
+
 func (e *extension) tryStartRelayServerLocked() {
 	rs, err := e.newServerFn(e.logf, *e.port, false)
 	if err != nil {
@@ -182,6 +290,7 @@ func (e *extension) tryStartRelayServerLocked() {
 	}
 	e.rs = rs
 	e.rs.SetDERPMapView(e.derpMapView)
+	e.rs.SetPolicy(e.policy)
 }
 
 func (e *extension) relayServerShouldBeRunningLocked() bool {
@@ -211,9 +320,23 @@ func (e *extension) selfNodeViewChanged(nodeView tailcfg.NodeView) {
 	e.mu.Lock()
 	defer e.mu.Unlock()
 	e.hasNodeAttrDisableRelayServer = nodeView.HasCap(tailcfg.NodeAttrDisableRelayServer)
+	e.setPolicyLocked(policyForNode(nodeView, e.logf))
 	e.handleRelayServerLifetimeLocked()
 }
 
+// setPolicyLocked sets the relay server's policy to p, applying it to the
+// running server, if any.
+func (e *extension) setPolicyLocked(p status.ServerPolicy) {
+	if reflect.DeepEqual(p, e.policy) {
+		return
+	}
+	e.logf("policy changed to %+v", p)
+	e.policy = p
+	if e.rs != nil {
+		e.rs.SetPolicy(p)
+	}
+}
+
 func (e *extension) profileStateChanged(_ ipn.LoginProfileView, prefs ipn.PrefsView, sameNode bool) {
 	e.mu.Lock()
 	defer e.mu.Unlock()
@@ -266,5 +389,6 @@ func (e *extension) serverStatus() status.ServerStatus {
 	}
 	st.UDPPort = ptr.To(*e.port)
 	st.Sessions = e.rs.GetSessions()
+	st.Policy, st.AllocationsDeniedByPolicy, st.AllocationsDeniedByQuota = e.rs.GetPolicyStatus()
 	return st
 }
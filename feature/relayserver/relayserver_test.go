@@ -9,8 +9,10 @@
 	"reflect"
 	"slices"
 	"testing"
+	"time"
 
 	"tailscale.com/ipn"
+	"tailscale.com/net/udprelay"
 	"tailscale.com/net/udprelay/endpoint"
 	"tailscale.com/net/udprelay/status"
 	"tailscale.com/tailcfg"
@@ -247,14 +249,19 @@ func mockRelayServerNotZeroVal() *mockRelayServer {
 type mockRelayServer struct {
 	set       bool
 	addrPorts views.Slice[netip.AddrPort]
+	policy    status.ServerPolicy
 }
 
 func (m *mockRelayServer) Close() error { return nil }
-func (m *mockRelayServer) AllocateEndpoint(_, _ key.DiscoPublic) (endpoint.ServerEndpoint, error) {
+func (m *mockRelayServer) AllocateEndpoint(_, _ key.DiscoPublic, _, _ udprelay.ClientIdentity) (endpoint.ServerEndpoint, error) {
 	return endpoint.ServerEndpoint{}, errors.New("not implemented")
 }
 func (m *mockRelayServer) GetSessions() []status.ServerSession { return nil }
 func (m *mockRelayServer) SetDERPMapView(tailcfg.DERPMapView)  { return }
+func (m *mockRelayServer) SetPolicy(p status.ServerPolicy)     { m.policy = p }
+func (m *mockRelayServer) GetPolicyStatus() (status.ServerPolicy, uint64, uint64) {
+	return status.ServerPolicy{}, 0, 0
+}
 func (m *mockRelayServer) SetStaticAddrPorts(aps views.Slice[netip.AddrPort]) {
 	m.addrPorts = aps
 }
@@ -372,3 +379,54 @@ func Test_extension_handleRelayServerLifetimeLocked(t *testing.T) {
 		})
 	}
 }
+
+func Test_extension_selfNodeViewChangedPolicy(t *testing.T) {
+	sys := tsd.NewSystem()
+	ipne, err := newExtension(logger.Discard, mockSafeBackend{sys})
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := ipne.(*extension)
+	defer e.Shutdown()
+	e.newServerFn = func(logf logger.Logf, port uint16, onlyStaticAddrPorts bool) (relayServer, error) {
+		return &mockRelayServer{}, nil
+	}
+	e.port = ptr.To(uint16(1))
+
+	selfWithPolicy := func(policy string) tailcfg.NodeView {
+		n := &tailcfg.Node{}
+		if policy != "" {
+			n.CapMap = tailcfg.NodeCapMap{
+				tailcfg.NodeAttrRelayServerPolicy: {tailcfg.RawMessage(policy)},
+			}
+		}
+		return n.View()
+	}
+	envPolicy := policyFromEnv()
+
+	e.selfNodeViewChanged(selfWithPolicy(""))
+	rs := e.rs.(*mockRelayServer)
+	if !reflect.DeepEqual(rs.policy, envPolicy) {
+		t.Errorf("policy without node attribute = %+v; want %+v", rs.policy, envPolicy)
+	}
+
+	// The policy is applied to the running server when the node attribute
+	// changes.
+	e.selfNodeViewChanged(selfWithPolicy(`{"AllowTags": ["tag:relay-ok"], "MaxEndpoints": 10, "SteadyStateLifetime": "1m"}`))
+	want := status.ServerPolicy{
+		AllowTags:           []string{"tag:relay-ok"},
+		MaxEndpoints:        10,
+		SteadyStateLifetime: time.Minute,
+	}
+	if e.rs != rs {
+		t.Fatal("relay server restarted on policy change")
+	}
+	if !reflect.DeepEqual(rs.policy, want) {
+		t.Errorf("policy = %+v; want %+v", rs.policy, want)
+	}
+
+	e.selfNodeViewChanged(selfWithPolicy(`{"MaxEndpoints": "lots"}`))
+	if !reflect.DeepEqual(rs.policy, envPolicy) {
+		t.Errorf("policy with invalid node attribute = %+v; want %+v", rs.policy, envPolicy)
+	}
+}
@@ -23,9 +23,10 @@
 
 // fileDeleter manages asynchronous deletion of files after deleteDelay.
 type fileDeleter struct {
-	logf  logger.Logf
-	clock tstime.DefaultClock
-	event func(string) // called for certain events; for testing only
+	logf    logger.Logf
+	clock   tstime.DefaultClock
+	event   func(string) // called for certain events; for testing only
+	removed func()       // called after files are deleted
 
 	mu     sync.Mutex
 	queue  list.List
@@ -48,6 +49,7 @@ func (d *fileDeleter) Init(m *manager, eventHook func(string)) {
 	d.logf = m.opts.Logf
 	d.clock = m.opts.Clock
 	d.event = eventHook
+	d.removed = m.invalidateInboxSize
 	d.fs = m.opts.fileOps
 
 	d.byName = make(map[string]*list.Element)
@@ -106,6 +108,37 @@ func (d *fileDeleter) Init(m *manager, eventHook func(string)) {
 				d.Insert(filename)
 			}
 		}
+
+		// Partial directory transfers are likewise deleted
+		// unless they're active.
+		tree, ok := d.fs.(dirFileOps)
+		if !ok {
+			return
+		}
+		dirs, err := tree.ListDirs()
+		if err != nil {
+			d.logf("deleter: ListDirs error: %v", err)
+			return
+		}
+		for _, dirname := range dirs {
+			if d.shutdownCtx.Err() != nil {
+				return // terminate early
+			}
+			nameID, ok := strings.CutSuffix(dirname, partialSuffix)
+			if !ok {
+				continue
+			}
+			if i := strings.LastIndexByte(nameID, '.'); i > 0 {
+				key := incomingFileKey{clientID(nameID[i+len("."):]), nameID[:i]}
+				m.dirTransfers.LoadFunc(key, func(_ *dirTransfer, loaded bool) {
+					if !loaded {
+						d.Insert(dirname)
+					}
+				})
+			} else {
+				d.Insert(dirname)
+			}
+		}
 	})
 }
 
@@ -158,11 +191,12 @@ func (d *fileDeleter) waitAndDelete(wait time.Duration) {
 					continue
 				}
 			}
-			if err := d.fs.Remove(file.name); err != nil && !os.IsNotExist(err) {
+			if err := d.remove(file.name); err != nil && !os.IsNotExist(err) {
 				d.logf("could not delete: %v", redactError(err))
 				failed = append(failed, elem)
 				continue
 			}
+			d.removed()
 			d.queue.Remove(elem)
 			delete(d.byName, file.name)
 			d.event("deleted " + file.name)
@@ -181,6 +215,17 @@ func (d *fileDeleter) waitAndDelete(wait time.Duration) {
 	}
 }
 
+// remove removes the named file, or the named staging directory
+// of a directory transfer along with its contents.
+func (d *fileDeleter) remove(name string) error {
+	if tree, ok := d.fs.(dirFileOps); ok && strings.HasSuffix(name, partialSuffix) {
+		if fi, err := d.fs.Stat(name); err == nil && fi.IsDir() {
+			return tree.RemoveAll(name)
+		}
+	}
+	return d.fs.Remove(name)
+}
+
 // Remove dequeues baseName from eventual deletion.
 func (d *fileDeleter) Remove(baseName string) {
 	d.mu.Lock()
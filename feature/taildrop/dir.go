@@ -0,0 +1,382 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package taildrop
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"tailscale.com/client/tailscale/apitype"
+	"tailscale.com/envknob"
+	"tailscale.com/util/set"
+	"tailscale.com/version/distro"
+)
+
+// A peer sends a directory by first sending a manifest of the files within
+// it (see [manager.StartDir]), followed by each of the files using
+// [manager.PutFile] with a name of the form "dir/sub/file.jpg".
+//
+// The files are received into a staging directory named like a partial
+// file ("dir.<id>.partial"), where they can be resumed like any other file.
+// Once every file in the manifest has been received, the staging directory
+// is renamed into place and its files appear in [manager.WaitingFiles].
+
+var (
+	ErrDirNotSupported = errors.New("directory transfers not supported")
+	ErrNoDirTransfer   = errors.New("no directory transfer in progress")
+
+	errInvalidManifest = errors.New("invalid manifest")
+)
+
+const (
+	// dirManifestName is the name of the file within a staging directory
+	// that holds the manifest. User files can't have a partial suffix,
+	// so it can't collide with a received file.
+	dirManifestName = "manifest" + partialSuffix
+
+	// maxDirFiles is the maximum number of files in a received directory.
+	maxDirFiles = 100_000
+
+	// maxRelNameLen is the maximum length of the name of a file
+	// relative to its received directory.
+	maxRelNameLen = 4096
+)
+
+// dirFileOps is implemented by a [FileOps] that can receive directories.
+type dirFileOps interface {
+	// ListDirs returns the base names of all directories
+	// in the root directory.
+	ListDirs() ([]string, error)
+
+	// ListTree returns the slash-separated names, relative to the
+	// directory name, of all regular files within it and its subdirectories.
+	ListTree(name string) ([]string, error)
+
+	// RemoveAll removes the named file or directory and any children.
+	RemoveAll(name string) error
+
+	// RenameDir renames the directory name to newName, a base name,
+	// choosing another name if newName already exists.
+	// It returns the full new path.
+	RenameDir(name, newName string) (newPath string, err error)
+}
+
+// partialName returns the name of the file that name is received into
+// while being sent by id. Files within a directory are received into
+// the directory's staging directory.
+func (id clientID) partialName(name string) string {
+	if dir, rel, ok := strings.Cut(name, "/"); ok {
+		// Don't use path.Join, which would clean away any ".."
+		// elements instead of leaving them for validation.
+		return dir + id.partialSuffix() + "/" + rel
+	}
+	return name + id.partialSuffix()
+}
+
+// validateRelName validates the slash-separated name of a file
+// relative to its received directory.
+func validateRelName(name string) error {
+	if name == "" || len(name) > maxRelNameLen {
+		return ErrInvalidFileName
+	}
+	for elem := range strings.SplitSeq(name, "/") {
+		if err := validateBaseName(elem); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validateDirManifest validates the files listed in a directory manifest.
+func validateDirManifest(manifest *apitype.TaildropDirManifest) error {
+	if len(manifest.Files) > maxDirFiles {
+		return fmt.Errorf("too many files: %d > %d", len(manifest.Files), maxDirFiles)
+	}
+	names := make(set.Set[string], len(manifest.Files))
+	for _, f := range manifest.Files {
+		if err := validateRelName(f.Name); err != nil {
+			return err
+		}
+		if f.Size < 0 {
+			return fmt.Errorf("invalid size %d", f.Size)
+		}
+		if names.Contains(f.Name) {
+			return ErrInvalidFileName
+		}
+		names.Add(f.Name)
+	}
+	// A file can't also be a directory containing another file.
+	for _, f := range manifest.Files {
+		for dir := path.Dir(f.Name); dir != "."; dir = path.Dir(dir) {
+			if names.Contains(dir) {
+				return ErrInvalidFileName
+			}
+		}
+	}
+	return nil
+}
+
+// checkDirManifest checks whether the receive policy allows
+// receiving all the files in manifest.
+func (m *manager) checkDirManifest(manifest *apitype.TaildropDirManifest) error {
+	p := m.policy.Load()
+	var total int64
+	for _, f := range manifest.Files {
+		if !p.allowsName(f.Name) {
+			return ErrFileNotAllowed
+		}
+		if p.MaxFileSize > 0 && f.Size > p.MaxFileSize {
+			return ErrFileTooLarge
+		}
+		total += f.Size
+	}
+	if p.MaxInboxSize > 0 && !m.opts.DirectFileMode && m.inboxSize()+total > p.MaxInboxSize {
+		return ErrInboxFull
+	}
+	return nil
+}
+
+// dirTransfer is the state of a directory being received.
+type dirTransfer struct {
+	staging string           // name of the staging directory
+	files   map[string]int64 // declared file sizes by relative name; immutable
+
+	mu      sync.Mutex
+	pending set.Set[string] // files not yet completely received
+	done    bool            // whether the directory was moved into place
+}
+
+// newDirTransfer returns the state of a transfer into the staging directory
+// with the given manifest, using the sizes of any files already received
+// to determine which are pending.
+func (m *manager) newDirTransfer(staging string, manifest *apitype.TaildropDirManifest) *dirTransfer {
+	dt := &dirTransfer{
+		staging: staging,
+		files:   make(map[string]int64, len(manifest.Files)),
+		pending: make(set.Set[string]),
+	}
+	for _, f := range manifest.Files {
+		dt.files[f.Name] = f.Size
+		fi, err := m.opts.fileOps.Stat(staging + "/" + f.Name)
+		if err != nil || !fi.Mode().IsRegular() || fi.Size() != f.Size {
+			dt.pending.Add(f.Name)
+		}
+	}
+	return dt
+}
+
+// StartDir starts receiving a directory named dirName from the given
+// client id, containing the files described by manifest. The files are then
+// sent with [manager.PutFile] using names prefixed by dirName and a slash.
+//
+// If the same directory is already being received from id, the transfer is
+// restarted with the new manifest. Files that were already received are
+// kept so that they can be resumed.
+func (m *manager) StartDir(id clientID, dirName string, manifest *apitype.TaildropDirManifest) error {
+	switch {
+	case m == nil || m.opts.fileOps == nil:
+		return ErrNoTaildrop
+	case !envknob.CanTaildrop():
+		return ErrNoTaildrop
+	case distro.Get() == distro.Unraid && !m.opts.DirectFileMode:
+		return ErrNotAccessible
+	}
+	tree, ok := m.opts.fileOps.(dirFileOps)
+	if !ok {
+		return ErrDirNotSupported
+	}
+	if err := validateBaseName(dirName); err != nil {
+		return err
+	}
+	if err := validateDirManifest(manifest); err != nil {
+		return fmt.Errorf("%w: %w", errInvalidManifest, err)
+	}
+
+	if err := m.checkDirManifest(manifest); err != nil {
+		return err
+	}
+
+	staging := dirName + id.partialSuffix()
+	m.deleter.Remove(staging)
+	defer m.invalidateInboxSize() // for the files removed and the manifest
+
+	// Remove any files left by a previous transfer that aren't part of
+	// this one, or that are larger than their new declared size.
+	if names, err := tree.ListTree(staging); err == nil {
+		sizes := make(map[string]int64, len(manifest.Files))
+		for _, f := range manifest.Files {
+			sizes[f.Name] = f.Size
+		}
+		for _, name := range names {
+			size, ok := sizes[name]
+			if ok {
+				if fi, err := m.opts.fileOps.Stat(staging + "/" + name); err == nil && fi.Size() <= size {
+					continue
+				}
+			}
+			if err := m.opts.fileOps.Remove(staging + "/" + name); err != nil && !os.IsNotExist(err) {
+				return m.redactAndLogError("Remove", err)
+			}
+		}
+	}
+
+	// Write the manifest, which marks the staging directory as
+	// an active transfer.
+	manifestName := staging + "/" + dirManifestName
+	if err := m.opts.fileOps.Remove(manifestName); err != nil && !os.IsNotExist(err) {
+		return m.redactAndLogError("Remove", err)
+	}
+	wc, _, err := m.opts.fileOps.OpenWriter(manifestName, 0, 0o666)
+	if err != nil {
+		return m.redactAndLogError("Create", err)
+	}
+	err = json.NewEncoder(wc).Encode(manifest)
+	if cerr := wc.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		m.deleter.Insert(staging)
+		return m.redactAndLogError("Write", err)
+	}
+
+	key := incomingFileKey{id, dirName}
+	dt := m.newDirTransfer(staging, manifest)
+	m.dirTransfers.Store(key, dt)
+
+	dt.mu.Lock()
+	defer dt.mu.Unlock()
+	if len(dt.pending) == 0 {
+		return m.finishDirLocked(key, dt)
+	}
+	return nil
+}
+
+// loadDirTransfer returns the state of the directory named dirName
+// being received from id.
+// It returns [ErrNoDirTransfer] if there is no such transfer.
+func (m *manager) loadDirTransfer(id clientID, dirName string) (*dirTransfer, error) {
+	key := incomingFileKey{id, dirName}
+	staging := dirName + id.partialSuffix()
+	manifestName := staging + "/" + dirManifestName
+
+	// The staging directory may have been deleted since
+	// the transfer was last active.
+	if _, err := m.opts.fileOps.Stat(manifestName); err != nil {
+		m.dirTransfers.Delete(key)
+		if os.IsNotExist(err) {
+			return nil, ErrNoDirTransfer
+		}
+		return nil, redactError(err)
+	}
+	if dt, ok := m.dirTransfers.Load(key); ok {
+		return dt, nil
+	}
+
+	// Otherwise, the transfer was started before we last restarted.
+	rc, err := m.opts.fileOps.OpenReader(manifestName)
+	if err != nil {
+		return nil, redactError(err)
+	}
+	defer rc.Close()
+	var manifest apitype.TaildropDirManifest
+	if err := json.NewDecoder(io.LimitReader(rc, 64<<20)).Decode(&manifest); err != nil {
+		return nil, fmt.Errorf("reading manifest: %w", err)
+	}
+	if err := validateDirManifest(&manifest); err != nil {
+		return nil, fmt.Errorf("reading manifest: %w", err)
+	}
+	dt, _ := m.dirTransfers.LoadOrStore(key, m.newDirTransfer(staging, &manifest))
+	return dt, nil
+}
+
+// receivedDirFile records that the file rel within dt was completely
+// received, and moves the directory into place if it was the last one.
+func (m *manager) receivedDirFile(key incomingFileKey, dt *dirTransfer, rel string) error {
+	dt.mu.Lock()
+	defer dt.mu.Unlock()
+	dt.pending.Delete(rel)
+	if len(dt.pending) > 0 || dt.done {
+		return nil
+	}
+	return m.finishDirLocked(key, dt)
+}
+
+// finishDirLocked moves the completely received directory of dt into place.
+// dt.mu must be held.
+func (m *manager) finishDirLocked(key incomingFileKey, dt *dirTransfer) error {
+	tree := m.opts.fileOps.(dirFileOps)
+	if err := m.opts.fileOps.Remove(dt.staging + "/" + dirManifestName); err != nil && !os.IsNotExist(err) {
+		return m.redactAndLogError("Remove", err)
+	}
+	m.invalidateInboxSize()
+	finalPath, err := tree.RenameDir(dt.staging, key.name)
+	if err != nil {
+		m.deleter.Insert(dt.staging)
+		return m.redactAndLogError("Rename", err)
+	}
+	dt.done = true
+	m.dirTransfers.WithLock(func(transfers map[incomingFileKey]*dirTransfer) {
+		if transfers[key] == dt {
+			delete(transfers, key)
+		}
+	})
+	m.opts.Logf("received directory of %d files as %q", len(dt.files), redactString(filepath.Base(finalPath)))
+
+	m.totalReceived.Add(1)
+	m.opts.SendFileNotify()
+	return nil
+}
+
+// waitingDirFiles returns the files within received directories,
+// named by their slash-separated paths relative to [Handler.Dir].
+func (m *manager) waitingDirFiles() ([]apitype.WaitingFile, error) {
+	tree, ok := m.opts.fileOps.(dirFileOps)
+	if !ok {
+		return nil, nil
+	}
+	dirs, err := tree.ListDirs()
+	if err != nil {
+		return nil, redactError(err)
+	}
+	var ret []apitype.WaitingFile
+	for _, dir := range dirs {
+		if isPartialOrDeleted(dir) {
+			continue // still being received
+		}
+		names, err := tree.ListTree(dir)
+		if err != nil {
+			continue
+		}
+		nameSet := set.Of(names...)
+		for _, rel := range names {
+			if isPartialOrDeleted(rel) || nameSet.Contains(rel+deletedSuffix) {
+				continue
+			}
+			name := dir + "/" + rel
+			fi, err := m.opts.fileOps.Stat(name)
+			if err != nil {
+				continue
+			}
+			ret = append(ret, apitype.WaitingFile{Name: name, Size: fi.Size()})
+		}
+	}
+	return ret, nil
+}
+
+// removeEmptyParents removes the directories containing the file
+// named name, from the innermost, for as long as they're empty.
+func (m *manager) removeEmptyParents(name string) {
+	for dir := path.Dir(name); dir != "." && dir != "/"; dir = path.Dir(dir) {
+		if m.opts.fileOps.Remove(dir) != nil {
+			return
+		}
+	}
+}
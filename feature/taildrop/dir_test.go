@@ -0,0 +1,163 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package taildrop
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"tailscale.com/client/tailscale/apitype"
+	"tailscale.com/util/must"
+)
+
+func TestDirTransfer(t *testing.T) {
+	dir := t.TempDir()
+	m := managerOptions{Logf: t.Logf, fileOps: must.Get(newFileOps(dir))}.New()
+	defer m.Shutdown()
+
+	id := clientID("n1")
+	files := map[string]string{
+		"a.txt":         "hello",
+		"sub/b.txt":     strings.Repeat("b", 1000),
+		"sub/deep/c.md": "",
+	}
+	var manifest apitype.TaildropDirManifest
+	for name, content := range files {
+		manifest.Files = append(manifest.Files, apitype.TaildropDirFile{Name: name, Size: int64(len(content))})
+	}
+	must.Do(m.StartDir(id, "photos", &manifest))
+
+	// Files can't be sent for directories that weren't started,
+	// or that aren't in the manifest.
+	if _, err := m.PutFile(id, "other/a.txt", strings.NewReader("x"), 0, 1); err != ErrNoDirTransfer {
+		t.Errorf("PutFile for unknown directory: err = %v, want %v", err, ErrNoDirTransfer)
+	}
+	if _, err := m.PutFile("n2", "photos/a.txt", strings.NewReader("x"), 0, 1); err != ErrNoDirTransfer {
+		t.Errorf("PutFile from other client: err = %v, want %v", err, ErrNoDirTransfer)
+	}
+	if _, err := m.PutFile(id, "photos/x.txt", strings.NewReader("x"), 0, 1); err != ErrInvalidFileName {
+		t.Errorf("PutFile for unlisted file: err = %v, want %v", err, ErrInvalidFileName)
+	}
+	if _, err := m.PutFile(id, "photos/../a.txt", strings.NewReader("x"), 0, 1); err != ErrInvalidFileName {
+		t.Errorf("PutFile with dot-dot: err = %v, want %v", err, ErrInvalidFileName)
+	}
+
+	waitingNames := func() []string {
+		var names []string
+		for _, wf := range must.Get(m.WaitingFiles()) {
+			names = append(names, wf.Name)
+		}
+		return names
+	}
+
+	must.Get(m.PutFile(id, "photos/a.txt", strings.NewReader(files["a.txt"]), 0, int64(len(files["a.txt"]))))
+	must.Get(m.PutFile(id, "photos/sub/deep/c.md", strings.NewReader(""), 0, 0))
+	if got := waitingNames(); len(got) != 0 {
+		t.Errorf("WaitingFiles before directory complete = %q, want none", got)
+	}
+
+	// Interrupt the last file, then resume it using the block hashes.
+	want := files["sub/b.txt"]
+	r := io.MultiReader(strings.NewReader(want[:300]), iotest.ErrReader(io.ErrClosedPipe))
+	if _, err := m.PutFile(id, "photos/sub/b.txt", r, 0, int64(len(want))); err == nil {
+		t.Fatal("interrupted PutFile succeeded")
+	}
+	if m.HasFilesWaiting() {
+		t.Error("HasFilesWaiting before directory complete = true")
+	}
+	next, close, err := m.HashPartialFile(id, "photos/sub/b.txt")
+	must.Do(err)
+	offset, rest, err := resumeReader(strings.NewReader(want), next)
+	must.Do(err)
+	must.Do(close())
+	if offset == 0 {
+		t.Error("resumed at offset 0")
+	}
+	must.Get(m.PutFile(id, "photos/sub/b.txt", rest, offset, int64(len(want))-offset))
+
+	wantNames := []string{"photos/a.txt", "photos/sub/b.txt", "photos/sub/deep/c.md"}
+	if got := waitingNames(); strings.Join(got, ",") != strings.Join(wantNames, ",") {
+		t.Errorf("WaitingFiles = %q, want %q", got, wantNames)
+	}
+	if !m.HasFilesWaiting() {
+		t.Error("HasFilesWaiting = false, want true")
+	}
+	for name, content := range files {
+		got := must.Get(os.ReadFile(filepath.Join(dir, "photos", filepath.FromSlash(name))))
+		if !bytes.Equal(got, []byte(content)) {
+			t.Errorf("content of %q mismatches", name)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "photos", dirManifestName)); !os.IsNotExist(err) {
+		t.Errorf("manifest left behind: %v", err)
+	}
+
+	// Sending the same directory again doesn't collide.
+	manifest = apitype.TaildropDirManifest{Files: []apitype.TaildropDirFile{{Name: "a.txt", Size: 1}}}
+	must.Do(m.StartDir(id, "photos", &manifest))
+	must.Get(m.PutFile(id, "photos/a.txt", strings.NewReader("x"), 0, 1))
+	if _, err := os.Stat(filepath.Join(dir, "photos (1)", "a.txt")); err != nil {
+		t.Errorf("second directory not received: %v", err)
+	}
+
+	// Deleting files removes their directories once they're empty.
+	for _, name := range append(wantNames, "photos (1)/a.txt") {
+		must.Do(m.DeleteFile(name))
+	}
+	if entries := must.Get(os.ReadDir(dir)); len(entries) != 0 {
+		t.Errorf("directory not empty after deleting all files: %v", entries)
+	}
+}
+
+func TestRenameDirExisting(t *testing.T) {
+	dir := t.TempDir()
+	fops := must.Get(newFileOps(dir)).(dirFileOps)
+	must.Do(os.Mkdir(filepath.Join(dir, "photos"), 0700))
+	for i, want := range []string{"photos (1)", "photos (2)"} {
+		staging := filepath.Join(dir, "staging")
+		must.Do(os.Mkdir(staging, 0700))
+		got, err := fops.RenameDir("staging", "photos")
+		if err != nil {
+			t.Fatalf("RenameDir #%d: %v", i, err)
+		}
+		if got != filepath.Join(dir, want) {
+			t.Errorf("RenameDir #%d = %q, want %q", i, got, filepath.Join(dir, want))
+		}
+	}
+}
+
+func TestValidateDirManifest(t *testing.T) {
+	tests := []struct {
+		name  string
+		files []string
+		ok    bool
+	}{
+		{"empty", nil, true},
+		{"nested", []string{"a", "b/c", "b/d/e"}, true},
+		{"dot-dot", []string{"../a"}, false},
+		{"absolute", []string{"/a"}, false},
+		{"trailing-slash", []string{"a/"}, false},
+		{"double-slash", []string{"a//b"}, false},
+		{"partial", []string{"a/b.partial"}, false},
+		{"duplicate", []string{"a/b", "a/b"}, false},
+		{"file-and-dir", []string{"a/b", "a/b/c"}, false},
+		{"backslash", []string{`a\b`}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var manifest apitype.TaildropDirManifest
+			for _, name := range tt.files {
+				manifest.Files = append(manifest.Files, apitype.TaildropDirFile{Name: name})
+			}
+			if err := validateDirManifest(&manifest); (err == nil) != tt.ok {
+				t.Errorf("validateDirManifest(%q) = %v, want ok=%v", tt.files, err, tt.ok)
+			}
+		})
+	}
+}
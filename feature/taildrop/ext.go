@@ -22,12 +22,14 @@
 	"tailscale.com/ipn"
 	"tailscale.com/ipn/ipnext"
 	"tailscale.com/ipn/ipnstate"
+	"tailscale.com/syncs"
 	"tailscale.com/tailcfg"
 	"tailscale.com/tstime"
 	"tailscale.com/types/empty"
 	"tailscale.com/types/logger"
 	"tailscale.com/util/osshare"
 	"tailscale.com/util/set"
+	"tailscale.com/util/syspolicy/policyclient"
 )
 
 func init() {
@@ -47,6 +49,7 @@ func newExtension(logf logger.Logf, b ipnext.SafeBackend) (ipnext.Extension, err
 		stateStore: b.Sys().StateStore.Get(),
 		logf:       logger.WithPrefix(logf, "taildrop: "),
 	}
+	e.policy.Store(policyFromSys(b.Sys().PolicyClientOrDefault()))
 	e.setPlatformDefaultDirectFileRoot()
 	return e, nil
 }
@@ -76,6 +79,11 @@ type Extension struct {
 	// This is currently being used for Android to use the Storage Access Framework.
 	fileOps FileOps
 
+	// policy restricts the files that are received; from policyFromSys.
+	policy syncs.AtomicValue[receivePolicy]
+
+	unregisterPolicyChange func() // from Init; nil if registration failed
+
 	nodeBackendForTest ipnext.NodeBackend // if non-nil, pretend we're this node state for tests
 
 	mu             sync.Mutex // Lock order: lb.mu > e.mu
@@ -103,6 +111,12 @@ func (e *Extension) Init(h ipnext.Host) error {
 	h.Hooks().SetPeerStatus.Add(e.setPeerStatus)
 	h.Hooks().BackendStateChange.Add(e.onBackendStateChange)
 
+	unregister, err := e.sb.Sys().PolicyClientOrDefault().RegisterChangeCallback(e.onPolicyChange)
+	if err != nil {
+		e.logf("can't watch for receive policy changes: %v", err)
+	}
+	e.unregisterPolicyChange = unregister
+
 	// TODO(nickkhyl): remove this after the profileManager refactoring.
 	// See tailscale/tailscale#15974.
 	// This same workaround appears in feature/portlist/portlist.go.
@@ -129,6 +143,21 @@ func (e *Extension) onSelfChange(self tailcfg.NodeView) {
 	osshare.SetFileSharingEnabled(e.capFileSharing, e.logf)
 }
 
+// onPolicyChange applies changes to the system policy settings of the
+// receive policy.
+func (e *Extension) onPolicyChange(change policyclient.PolicyChange) {
+	if !change.HasChangedAnyOf(policyKeys...) {
+		return
+	}
+	p := policyFromSys(e.sb.Sys().PolicyClientOrDefault())
+	e.policy.Store(p)
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if mgr := e.manager(); mgr != nil {
+		mgr.setPolicy(p)
+	}
+}
+
 func (e *Extension) setMgrLocked(mgr *manager) {
 	if old := e.mgr.Swap(mgr); old != nil {
 		old.Shutdown()
@@ -180,6 +209,7 @@ func (e *Extension) onChangeProfile(profile ipn.LoginProfileView, _ ipn.PrefsVie
 		State:          e.stateStore,
 		DirectFileMode: isDirectFileMode,
 		fileOps:        fops,
+		Policy:         e.policy.Load(),
 		SendFileNotify: e.sendFileNotify,
 	}.New())
 }
@@ -219,6 +249,21 @@ func (e *Extension) hasCapFileSharing() bool {
 	return e.capFileSharing
 }
 
+// allowsSender reports whether the receive policy accepts files from peer.
+func (e *Extension) allowsSender(peer tailcfg.NodeView) bool {
+	p := e.policy.Load()
+	if !p.restrictsSenders() {
+		return true
+	}
+	var loginName string
+	if !peer.IsTagged() {
+		if u, ok := e.nodeBackend().UserByID(peer.User()); ok {
+			loginName = u.LoginName()
+		}
+	}
+	return p.allowsSender(peer, loginName)
+}
+
 // manager returns the active Manager, or nil.
 //
 // Methods on a nil Manager are safe to call.
@@ -231,6 +276,9 @@ func (e *Extension) Clock() tstime.Clock {
 }
 
 func (e *Extension) Shutdown() error {
+	if e.unregisterPolicyChange != nil {
+		e.unregisterPolicyChange()
+	}
 	e.manager().Shutdown() // no-op on nil receiver
 	return nil
 }
@@ -38,4 +38,12 @@ type FileOps interface {
 	OpenReader(name string) (io.ReadCloser, error)
 }
 
+// localFileOps is implemented by a [FileOps] whose paths, as returned by
+// OpenWriter and Rename, are of files on the local filesystem, which
+// other programs can open.
+type localFileOps interface {
+	FileOps
+	isLocal()
+}
+
 var newFileOps func(dir string) (FileOps, error)
@@ -25,6 +25,8 @@
 // It is used on non-Android platforms.
 type fsFileOps struct{ rootDir string }
 
+var _ localFileOps = fsFileOps{}
+
 func init() {
 	newFileOps = func(dir string) (FileOps, error) {
 		if dir == "" {
@@ -196,26 +198,124 @@ func (f fsFileOps) OpenReader(name string) (io.ReadCloser, error) {
 	return os.Open(path)
 }
 
-// joinDir is like [filepath.Join] but returns an error if baseName is too long,
-// is a relative path instead of a basename, or is otherwise invalid or unsafe for incoming files.
-func joinDir(dir, baseName string) (string, error) {
+func (f fsFileOps) ListDirs() ([]string, error) {
+	entries, err := os.ReadDir(f.rootDir)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, e := range entries {
+		if e.IsDir() {
+			names = append(names, e.Name())
+		}
+	}
+	return names, nil
+}
+
+func (f fsFileOps) ListTree(name string) ([]string, error) {
+	root, err := joinDir(f.rootDir, name)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	err = filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.Type().IsRegular() {
+			rel, err := filepath.Rel(root, p)
+			if err != nil {
+				return err
+			}
+			names = append(names, filepath.ToSlash(rel))
+		}
+		return nil
+	})
+	return names, err
+}
+
+func (fsFileOps) isLocal() {}
+
+func (f fsFileOps) RemoveAll(name string) error {
+	path, err := joinDir(f.rootDir, name)
+	if err != nil {
+		return err
+	}
+	return os.RemoveAll(path)
+}
+
+// RenameDir moves a received directory into place as newName,
+// which must be a base name. If newName already exists, it uses the
+// next free name of the form "newName (1)", "newName (2)", etc., trying
+// up to 10 names. Unlike Rename, it never treats an existing directory
+// as a duplicate of the received one.
+func (f fsFileOps) RenameDir(name, newName string) (newPath string, err error) {
+	src, err := joinDir(f.rootDir, name)
+	if err != nil {
+		return "", err
+	}
+	if strings.Contains(newName, "/") {
+		return "", fmt.Errorf("invalid newName %q: must not contain path separators", newName)
+	}
+
+	const maxRetries = 10
+	for range maxRetries {
+		dst, err := joinDir(f.rootDir, newName)
+		if err != nil {
+			return "", err
+		}
+		renameMu.Lock()
+		_, statErr := os.Lstat(dst)
+		if os.IsNotExist(statErr) {
+			err = os.Rename(src, dst)
+			renameMu.Unlock()
+			if err != nil {
+				return "", err
+			}
+			return dst, nil
+		}
+		renameMu.Unlock()
+		if statErr != nil {
+			return "", statErr
+		}
+		newName = nextFilename(newName)
+	}
+	return "", fmt.Errorf("too many retries trying to rename %q to %q", name, newName)
+}
+
+// joinDir is like [filepath.Join] but returns an error if name is too long,
+// is an absolute path, or is otherwise invalid or unsafe for incoming files.
+// The name is either a base name or, for files within a received directory,
+// a slash-separated path of base names.
+func joinDir(dir, name string) (string, error) {
+	if len(name) > maxRelNameLen {
+		return "", ErrInvalidFileName
+	}
+	for elem := range strings.SplitSeq(name, "/") {
+		if !validJoinElem(elem) {
+			return "", ErrInvalidFileName
+		}
+	}
+	return filepath.Join(dir, filepath.FromSlash(name)), nil
+}
+
+// validJoinElem reports whether baseName is a valid element of a name
+// passed to [joinDir].
+func validJoinElem(baseName string) bool {
 	if !utf8.ValidString(baseName) ||
 		strings.TrimSpace(baseName) != baseName ||
 		len(baseName) > 255 {
-		return "", ErrInvalidFileName
+		return false
 	}
 	// TODO: validate unicode normalization form too? Varies by platform.
 	clean := path.Clean(baseName)
 	if clean != baseName || clean == "." || clean == ".." {
-		return "", ErrInvalidFileName
+		return false
 	}
 	for _, r := range baseName {
 		if !validFilenameRune(r) {
-			return "", ErrInvalidFileName
+			return false
 		}
 	}
-	if !filepath.IsLocal(baseName) {
-		return "", ErrInvalidFileName
-	}
-	return filepath.Join(dir, baseName), nil
+	return filepath.IsLocal(baseName)
 }
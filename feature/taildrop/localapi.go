@@ -33,12 +33,14 @@
 
 func init() {
 	localapi.Register("file-put/", serveFilePut)
+	localapi.Register("file-put-dir/", serveFilePutDir)
 	localapi.Register("files/", serveFiles)
 	localapi.Register("file-targets", serveFileTargets)
 }
 
 var (
-	metricFilePutCalls = clientmetric.NewCounter("localapi_file_put")
+	metricFilePutCalls    = clientmetric.NewCounter("localapi_file_put")
+	metricFilePutDirCalls = clientmetric.NewCounter("localapi_file_put_dir")
 )
 
 // serveFilePut sends a file to another node.
@@ -166,6 +168,80 @@ func serveFilePut(h *localapi.Handler, w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// serveFilePutDir starts sending a directory to another node. The request
+// body is the JSON [apitype.TaildropDirManifest] of the files within the
+// directory, which is forwarded to the peer. The files are then sent with
+// file-put, using names of the form "dirname/sub/file" escaped as one path
+// element.
+//
+// URL format:
+//
+//   - PUT /localapi/v0/file-put-dir/:stableID/:escaped-dirname
+func serveFilePutDir(h *localapi.Handler, w http.ResponseWriter, r *http.Request) {
+	metricFilePutDirCalls.Add(1)
+
+	if !h.PermitWrite {
+		http.Error(w, "file access denied", http.StatusForbidden)
+		return
+	}
+	if r.Method != "PUT" {
+		http.Error(w, "want PUT to put directory", http.StatusBadRequest)
+		return
+	}
+
+	ext, ok := ipnlocal.GetExt[*Extension](h.LocalBackend())
+	if !ok {
+		http.Error(w, "misconfigured taildrop extension", http.StatusInternalServerError)
+		return
+	}
+
+	upath, ok := strings.CutPrefix(r.URL.EscapedPath(), "/localapi/v0/file-put-dir/")
+	if !ok {
+		http.Error(w, "misconfigured", http.StatusInternalServerError)
+		return
+	}
+	peerIDStr, dirnameEscaped, ok := strings.Cut(upath, "/")
+	if !ok || dirnameEscaped == "" {
+		http.Error(w, "bogus URL", http.StatusBadRequest)
+		return
+	}
+
+	fts, err := ext.FileTargets()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	peerID := tailcfg.StableNodeID(peerIDStr)
+	var ft *apitype.FileTarget
+	for _, x := range fts {
+		if x.Node.StableID == peerID {
+			ft = x
+			break
+		}
+	}
+	if ft == nil {
+		http.Error(w, "node not found", http.StatusNotFound)
+		return
+	}
+	dstURL, err := url.Parse(ft.PeerAPIURL)
+	if err != nil {
+		http.Error(w, "bogus peer URL", http.StatusInternalServerError)
+		return
+	}
+
+	outReq, err := http.NewRequestWithContext(r.Context(), "PUT", "http://peer/v0/put-dir/"+dirnameEscaped, r.Body)
+	if err != nil {
+		http.Error(w, "bogus outreq", http.StatusInternalServerError)
+		return
+	}
+	outReq.ContentLength = r.ContentLength
+	outReq.Header.Set("Content-Type", "application/json")
+
+	rp := httputil.NewSingleHostReverseProxy(dstURL)
+	rp.Transport = h.LocalBackend().Dialer().PeerAPITransport()
+	rp.ServeHTTP(w, outReq)
+}
+
 func multiFilePost(h *localapi.Handler, progressUpdates chan (ipn.OutgoingFile), w http.ResponseWriter, r *http.Request, peerID tailcfg.StableNodeID, dstURL *url.URL) {
 	_, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
 	if err != nil {
@@ -5,6 +5,7 @@
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
@@ -12,6 +13,7 @@
 	"strings"
 	"time"
 
+	"tailscale.com/client/tailscale/apitype"
 	"tailscale.com/ipn/ipnlocal"
 	"tailscale.com/tailcfg"
 	"tailscale.com/tstime"
@@ -21,10 +23,12 @@
 
 func init() {
 	ipnlocal.RegisterPeerAPIHandler("/v0/put/", handlePeerPut)
+	ipnlocal.RegisterPeerAPIHandler("/v0/put-dir/", handlePeerPutDir)
 }
 
 var (
-	metricPutCalls = clientmetric.NewCounter("peerapi_put")
+	metricPutCalls    = clientmetric.NewCounter("peerapi_put")
+	metricPutDirCalls = clientmetric.NewCounter("peerapi_put_dir")
 )
 
 // canPutFile reports whether h can put a file ("Taildrop") to this node.
@@ -50,6 +54,7 @@ func handlePeerPut(h ipnlocal.PeerAPIHandler, w http.ResponseWriter, r *http.Req
 type extensionForPut interface {
 	manager() *manager
 	hasCapFileSharing() bool
+	allowsSender(peer tailcfg.NodeView) bool
 	Clock() tstime.Clock
 }
 
@@ -73,14 +78,20 @@ func handlePeerPutWithBackend(h ipnlocal.PeerAPIHandler, ext extensionForPut, w
 		http.Error(w, ErrNoTaildrop.Error(), http.StatusForbidden)
 		return
 	}
+	if !ext.allowsSender(h.Peer()) {
+		http.Error(w, ErrSenderNotAllowed.Error(), http.StatusForbidden)
+		return
+	}
 	rawPath := r.URL.EscapedPath()
 	prefix, ok := strings.CutPrefix(rawPath, "/v0/put/")
 	if !ok {
 		http.Error(w, "misconfigured internals", http.StatusForbidden)
 		return
 	}
+	// Files within a directory transfer have their slashes escaped,
+	// so the name is always a single path element.
 	baseName, err := url.PathUnescape(prefix)
-	if err != nil {
+	if err != nil || strings.Contains(prefix, "/") {
 		http.Error(w, ErrInvalidFileName.Error(), http.StatusBadRequest)
 		return
 	}
@@ -139,25 +150,102 @@ func handlePeerPutWithBackend(h ipnlocal.PeerAPIHandler, ext extensionForPut, w
 			offset = ranges[0].Start
 		}
 		n, err := taildropMgr.PutFile(clientID(fmt.Sprint(id)), baseName, r.Body, offset, r.ContentLength)
-		switch err {
-		case nil:
-			d := ext.Clock().Since(t0).Round(time.Second / 10)
-			h.Logf("got put of %s in %v from %v/%v", approxSize(n), d, h.RemoteAddr().Addr(), h.Peer().ComputedName)
-			io.WriteString(w, "{}\n")
-		case ErrNoTaildrop:
-			http.Error(w, err.Error(), http.StatusForbidden)
-		case ErrInvalidFileName:
-			http.Error(w, err.Error(), http.StatusBadRequest)
-		case ErrFileExists:
-			http.Error(w, err.Error(), http.StatusConflict)
-		default:
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+		if err != nil {
+			http.Error(w, err.Error(), putErrorStatus(err))
+			return
 		}
+		d := ext.Clock().Since(t0).Round(time.Second / 10)
+		h.Logf("got put of %s in %v from %v/%v", approxSize(n), d, h.RemoteAddr().Addr(), h.Peer().ComputedName)
+		io.WriteString(w, "{}\n")
 	default:
 		http.Error(w, "expected method GET or PUT", http.StatusMethodNotAllowed)
 	}
 }
 
+func handlePeerPutDir(h ipnlocal.PeerAPIHandler, w http.ResponseWriter, r *http.Request) {
+	ext, ok := ipnlocal.GetExt[*Extension](h.LocalBackend())
+	if !ok {
+		http.Error(w, "miswired", http.StatusInternalServerError)
+		return
+	}
+	handlePeerPutDirWithBackend(h, ext, w, r)
+}
+
+// handlePeerPutDirWithBackend starts receiving a directory. The request body
+// is the JSON [apitype.TaildropDirManifest] of the files within it, which are
+// then sent individually to /v0/put/ with names prefixed by the directory name.
+//
+// URL format:
+//
+//   - PUT /v0/put-dir/:escaped-dirname
+func handlePeerPutDirWithBackend(h ipnlocal.PeerAPIHandler, ext extensionForPut, w http.ResponseWriter, r *http.Request) {
+	if r.Method != "PUT" {
+		http.Error(w, "expected method PUT", http.StatusMethodNotAllowed)
+		return
+	}
+	metricPutDirCalls.Add(1)
+
+	taildropMgr := ext.manager()
+	if taildropMgr == nil {
+		h.Logf("taildrop: no taildrop manager")
+		http.Error(w, "failed to get taildrop manager", http.StatusInternalServerError)
+		return
+	}
+	if !canPutFile(h) || !ext.hasCapFileSharing() {
+		http.Error(w, ErrNoTaildrop.Error(), http.StatusForbidden)
+		return
+	}
+	if !ext.allowsSender(h.Peer()) {
+		http.Error(w, ErrSenderNotAllowed.Error(), http.StatusForbidden)
+		return
+	}
+	prefix, ok := strings.CutPrefix(r.URL.EscapedPath(), "/v0/put-dir/")
+	if !ok {
+		http.Error(w, "misconfigured internals", http.StatusForbidden)
+		return
+	}
+	dirName, err := url.PathUnescape(prefix)
+	if err != nil {
+		http.Error(w, ErrInvalidFileName.Error(), http.StatusBadRequest)
+		return
+	}
+	var manifest apitype.TaildropDirManifest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<20)).Decode(&manifest); err != nil {
+		http.Error(w, fmt.Sprintf("invalid manifest: %v", err), http.StatusBadRequest)
+		return
+	}
+	id := clientID(h.Peer().StableID())
+	if err := taildropMgr.StartDir(id, dirName, &manifest); err != nil {
+		http.Error(w, err.Error(), putErrorStatus(err))
+		return
+	}
+	h.Logf("starting put of directory of %d files from %v/%v", len(manifest.Files), h.RemoteAddr().Addr(), h.Peer().ComputedName)
+	io.WriteString(w, "{}\n")
+}
+
+// putErrorStatus returns the HTTP status code for an error receiving
+// a file or directory.
+func putErrorStatus(err error) int {
+	switch {
+	case err == ErrNoTaildrop, err == ErrFileNotAllowed, err == ErrFileRejected:
+		return http.StatusForbidden
+	case err == ErrInvalidFileName, errors.Is(err, errInvalidManifest):
+		return http.StatusBadRequest
+	case err == ErrFileExists:
+		return http.StatusConflict
+	case err == ErrNoDirTransfer:
+		return http.StatusPreconditionFailed
+	case err == ErrFileTooLarge:
+		return http.StatusRequestEntityTooLarge
+	case err == ErrInboxFull:
+		return http.StatusInsufficientStorage
+	case err == ErrDirNotSupported:
+		return http.StatusNotImplemented
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
 func approxSize(n int64) string {
 	if n <= 1<<10 {
 		return "<=1KB"
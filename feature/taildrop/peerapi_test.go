@@ -57,6 +57,7 @@ type fakeExtension struct {
 	capFileSharing bool
 	clock          tstime.Clock
 	taildrop       *manager
+	policy         receivePolicy
 }
 
 func (lb *fakeExtension) manager() *manager {
@@ -66,6 +67,9 @@ func (lb *fakeExtension) Clock() tstime.Clock { return lb.clock }
 func (lb *fakeExtension) hasCapFileSharing() bool {
 	return lb.capFileSharing
 }
+func (lb *fakeExtension) allowsSender(peer tailcfg.NodeView) bool {
+	return lb.policy.allowsSender(peer, "")
+}
 
 type peerAPITestEnv struct {
 	taildrop *manager
@@ -153,6 +157,7 @@ func TestHandlePeerAPI(t *testing.T) {
 		capSharing bool // self node has file sharing capability
 		debugCap   bool // self node has debug capability
 		omitRoot   bool // don't configure
+		policy     receivePolicy
 		reqs       []*http.Request
 		checks     []check
 	}{
@@ -472,6 +477,86 @@ func(t *testing.T, env *peerAPITestEnv) {
 				},
 			),
 		},
+		{
+			name:       "put_dir",
+			isSelf:     true,
+			capSharing: true,
+			reqs: []*http.Request{
+				httptest.NewRequest("PUT", "/v0/put-dir/photos", strings.NewReader(`{"Files":[{"Name":"a.txt","Size":4},{"Name":"sub/b.txt","Size":4}]}`)),
+				httptest.NewRequest("PUT", "/v0/put/photos%2Fa.txt", strings.NewReader("fizz")),
+				httptest.NewRequest("PUT", "/v0/put/photos%2Fsub%2Fb.txt", strings.NewReader("buzz")),
+			},
+			checks: checks(
+				httpStatus(200),
+				func(t *testing.T, env *peerAPITestEnv) {
+					got, err := env.taildrop.WaitingFiles()
+					if err != nil {
+						t.Fatalf("WaitingFiles error: %v", err)
+					}
+					want := []apitype.WaitingFile{{Name: "photos/a.txt", Size: 4}, {Name: "photos/sub/b.txt", Size: 4}}
+					if diff := cmp.Diff(got, want); diff != "" {
+						t.Fatalf("WaitingFile mismatch (-got +want):\n%s", diff)
+					}
+				},
+			),
+		},
+		{
+			name:       "put_dir_bad_manifest",
+			isSelf:     true,
+			capSharing: true,
+			reqs: []*http.Request{
+				httptest.NewRequest("PUT", "/v0/put-dir/photos", strings.NewReader(`{"Files":[{"Name":"../a.txt","Size":4}]}`)),
+			},
+			checks: checks(
+				httpStatus(400),
+				bodyContains("invalid manifest"),
+			),
+		},
+		{
+			name:       "put_dir_file_without_manifest",
+			isSelf:     true,
+			capSharing: true,
+			reqs: []*http.Request{
+				httptest.NewRequest("PUT", "/v0/put/photos%2Fa.txt", strings.NewReader("fizz")),
+			},
+			checks: checks(
+				httpStatus(412),
+				bodyContains("no directory transfer in progress"),
+			),
+		},
+		{
+			name:       "policy_sender_not_allowed",
+			isSelf:     true,
+			capSharing: true,
+			policy:     receivePolicy{AllowTags: []string{"tag:server"}},
+			reqs:       []*http.Request{httptest.NewRequest("PUT", "/v0/put/foo", strings.NewReader("baz"))},
+			checks: checks(
+				httpStatus(http.StatusForbidden),
+				bodyContains("sender not allowed"),
+			),
+		},
+		{
+			name:       "policy_extension_not_allowed",
+			isSelf:     true,
+			capSharing: true,
+			policy:     receivePolicy{AllowExtensions: []string{".jpg"}},
+			reqs:       []*http.Request{httptest.NewRequest("PUT", "/v0/put/foo.exe", strings.NewReader("baz"))},
+			checks: checks(
+				httpStatus(http.StatusForbidden),
+				bodyContains("file type not allowed"),
+			),
+		},
+		{
+			name:       "policy_file_too_large",
+			isSelf:     true,
+			capSharing: true,
+			policy:     receivePolicy{MaxFileSize: 2},
+			reqs:       []*http.Request{httptest.NewRequest("PUT", "/v0/put/foo", strings.NewReader("baz"))},
+			checks: checks(
+				httpStatus(http.StatusRequestEntityTooLarge),
+				bodyContains("size limit"),
+			),
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -496,6 +581,7 @@ func(t *testing.T, env *peerAPITestEnv) {
 			e.taildrop = managerOptions{
 				Logf:    e.logBuf.Logf,
 				fileOps: fo,
+				Policy:  tt.policy,
 			}.New()
 
 			ext := &fakeExtension{
@@ -503,6 +589,7 @@ func(t *testing.T, env *peerAPITestEnv) {
 				capFileSharing: tt.capSharing,
 				clock:          &tstest.Clock{},
 				taildrop:       e.taildrop,
+				policy:         tt.policy,
 			}
 			e.ph = &peerAPIHandler{
 				isSelf:   tt.isSelf,
@@ -514,7 +601,11 @@ func(t *testing.T, env *peerAPITestEnv) {
 				if req.Host == "example.com" {
 					req.Host = "100.100.100.101:12345"
 				}
-				handlePeerPutWithBackend(e.ph, ext, e.rr, req)
+				if strings.HasPrefix(req.URL.Path, "/v0/put-dir/") {
+					handlePeerPutDirWithBackend(e.ph, ext, e.rr, req)
+				} else {
+					handlePeerPutWithBackend(e.ph, ext, e.rr, req)
+				}
 			}
 			for _, f := range tt.checks {
 				f(t, &e)
@@ -0,0 +1,318 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package taildrop
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"os/exec"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"tailscale.com/envknob"
+	"tailscale.com/tailcfg"
+	"tailscale.com/util/syspolicy/pkey"
+	"tailscale.com/util/syspolicy/policyclient"
+)
+
+var (
+	ErrSenderNotAllowed = errors.New("sender not allowed by Taildrop receive policy")
+	ErrFileNotAllowed   = errors.New("file type not allowed by Taildrop receive policy")
+	ErrFileTooLarge     = errors.New("file exceeds Taildrop size limit")
+	ErrInboxFull        = errors.New("Taildrop inbox is full")
+	ErrFileRejected     = errors.New("file rejected by Taildrop scanner")
+)
+
+// The following environment variables configure the [receivePolicy]
+// settings that aren't set by system policy; see policyFromSys.
+var (
+	envAllowUsers      = envknob.RegisterString("TS_TAILDROP_ALLOW_USERS")      // comma-separated login names
+	envAllowTags       = envknob.RegisterString("TS_TAILDROP_ALLOW_TAGS")       // comma-separated
+	envAllowExtensions = envknob.RegisterString("TS_TAILDROP_ALLOW_EXTENSIONS") // comma-separated, e.g. "jpg,png"
+	envMaxFileSize     = envknob.RegisterInt("TS_TAILDROP_MAX_FILE_SIZE")       // bytes
+	envMaxInboxSize    = envknob.RegisterInt("TS_TAILDROP_MAX_INBOX_SIZE")      // bytes
+	envScanner         = envknob.RegisterString("TS_TAILDROP_SCANNER")
+)
+
+// scanTimeout is the maximum time the scanner may take to scan a file.
+const scanTimeout = 5 * time.Minute
+
+// receivePolicy restricts the files that are received with Taildrop.
+// The zero value accepts all files from any sender permitted to send them.
+type receivePolicy struct {
+	// AllowUsers and AllowTags, if either is non-empty, restrict senders
+	// to untagged nodes owned by the listed users (by login name) and
+	// nodes with any of the listed tags.
+	AllowUsers []string
+	AllowTags  []string
+
+	// AllowExtensions, if non-empty, restricts received files to those
+	// with the listed extensions, in lower case with a leading dot.
+	AllowExtensions []string
+
+	// MaxFileSize, if positive, is the maximum size of a received file.
+	MaxFileSize int64
+
+	// MaxInboxSize, if positive, is the maximum total size of the files
+	// waiting in the inbox, including those still being received.
+	// It's ignored in DirectFileMode, where received files are
+	// moved out of the inbox as soon as they're received.
+	MaxInboxSize int64
+
+	// Scanner, if non-empty, is a command that is run with the path of
+	// each received file before it appears in WaitingFiles (or is moved
+	// into place in DirectFileMode). The file is deleted if the command
+	// exits with a non-zero status. It can only be run on files on the
+	// local filesystem (see localFileOps); with any other FileOps, all
+	// files are rejected.
+	Scanner string
+}
+
+// policyKeys are the system policy settings read by policyFromSys.
+var policyKeys = []pkey.Key{
+	pkey.TaildropAllowUsers,
+	pkey.TaildropAllowTags,
+	pkey.TaildropAllowExtensions,
+	pkey.TaildropMaxFileSize,
+	pkey.TaildropMaxInboxSize,
+	pkey.TaildropScanner,
+}
+
+// policyFromSys returns the receive policy configured by system policy,
+// with environment variables configuring the settings it doesn't set.
+func policyFromSys(polc policyclient.Client) receivePolicy {
+	split := func(s string) []string {
+		return strings.FieldsFunc(s, func(r rune) bool { return r == ',' || r == ' ' })
+	}
+	strs := func(k pkey.Key, def []string) []string {
+		if v, err := polc.GetStringArray(k, def); err == nil {
+			return v
+		}
+		return def
+	}
+	size := func(k pkey.Key, def int) int64 {
+		if v, err := polc.GetUint64(k, uint64(def)); err == nil && v <= math.MaxInt64 {
+			return int64(v)
+		}
+		return int64(def)
+	}
+	scanner, err := polc.GetString(pkey.TaildropScanner, envScanner())
+	if err != nil {
+		scanner = envScanner()
+	}
+	p := receivePolicy{
+		AllowUsers:   strs(pkey.TaildropAllowUsers, split(envAllowUsers())),
+		AllowTags:    strs(pkey.TaildropAllowTags, split(envAllowTags())),
+		MaxFileSize:  size(pkey.TaildropMaxFileSize, envMaxFileSize()),
+		MaxInboxSize: size(pkey.TaildropMaxInboxSize, envMaxInboxSize()),
+		Scanner:      scanner,
+	}
+	for _, ext := range strs(pkey.TaildropAllowExtensions, split(envAllowExtensions())) {
+		p.AllowExtensions = append(p.AllowExtensions, "."+strings.ToLower(strings.TrimPrefix(ext, ".")))
+	}
+	return p
+}
+
+// restrictsSenders reports whether p limits who can send files.
+func (p *receivePolicy) restrictsSenders() bool {
+	return len(p.AllowUsers) > 0 || len(p.AllowTags) > 0
+}
+
+// allowsSender reports whether p accepts files from peer, which is owned
+// by the user with the given login name if it's untagged.
+func (p *receivePolicy) allowsSender(peer tailcfg.NodeView, loginName string) bool {
+	if !p.restrictsSenders() {
+		return true
+	}
+	if peer.IsTagged() {
+		for _, tag := range peer.Tags().All() {
+			if slices.Contains(p.AllowTags, tag) {
+				return true
+			}
+		}
+		return false
+	}
+	return loginName != "" && slices.Contains(p.AllowUsers, loginName)
+}
+
+// allowsName reports whether p accepts a file with the given name,
+// which may be a slash-separated path within a received directory.
+func (p *receivePolicy) allowsName(name string) bool {
+	if len(p.AllowExtensions) == 0 {
+		return true
+	}
+	ext := strings.ToLower(path.Ext(name))
+	return ext != "" && slices.Contains(p.AllowExtensions, ext)
+}
+
+// checkFile checks whether the policy allows receiving the named file,
+// whose size is declared to be size, or -1 if unknown.
+// The partialName is the name the file is received into, whose current
+// size isn't counted against the inbox size limit.
+//
+// It returns the maximum number of bytes that may be written to the
+// partial file starting at offset, or -1 if there is no limit, along
+// with the error to report if more are received.
+func (m *manager) checkFile(name, partialName string, offset, size int64) (limit int64, limitErr, err error) {
+	p := m.policy.Load()
+	if !p.allowsName(name) {
+		return 0, nil, ErrFileNotAllowed
+	}
+	if p.Scanner != "" && !m.canScan() {
+		return 0, nil, ErrFileRejected
+	}
+	limit = -1
+	if p.MaxFileSize > 0 {
+		if size > p.MaxFileSize {
+			return 0, nil, ErrFileTooLarge
+		}
+		limit, limitErr = max(p.MaxFileSize-offset, 0), ErrFileTooLarge
+	}
+	if p.MaxInboxSize > 0 && !m.opts.DirectFileMode {
+		used := m.inboxSize()
+		if fi, err := m.opts.fileOps.Stat(partialName); err == nil {
+			used -= fi.Size()
+		}
+		avail := max(p.MaxInboxSize-used, 0)
+		if size >= 0 && size-offset > avail {
+			return 0, nil, ErrInboxFull
+		}
+		if limit < 0 || avail < limit {
+			limit, limitErr = avail, ErrInboxFull
+		}
+	}
+	return limit, limitErr, nil
+}
+
+// inboxUsage tracks the total size of the files in the inbox, for
+// receivePolicy.MaxInboxSize. It's kept as a running total, so that the
+// inbox needn't be listed for every file received, and recomputed from
+// the files after any are removed.
+type inboxUsage struct {
+	mu    sync.Mutex
+	known bool  // whether size is known
+	size  int64 // if known
+	gen   int64 // incremented when size is recomputed or invalidated
+}
+
+// inboxSize returns the total size of the files in the inbox,
+// including partial files and directories being received.
+func (m *manager) inboxSize() int64 {
+	u := &m.inbox
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if !u.known {
+		u.size = m.scanInboxSize()
+		u.known = true
+		u.gen++
+	}
+	return u.size
+}
+
+// inboxGen returns the generation of the inbox's running total, for a
+// later call to addInboxSize.
+func (m *manager) inboxGen() int64 {
+	m.inbox.mu.Lock()
+	defer m.inbox.mu.Unlock()
+	return m.inbox.gen
+}
+
+// addInboxSize adds n bytes, written to the inbox since the running
+// total's generation was gen, to the running total. If the total has been
+// recomputed since then, which may have counted some of them already,
+// it's invalidated instead.
+func (m *manager) addInboxSize(gen, n int64) {
+	u := &m.inbox
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if u.known && u.gen == gen {
+		u.size += n
+		return
+	}
+	u.known = false
+	u.gen++
+}
+
+// invalidateInboxSize notes that files have been removed from the inbox,
+// or written to it without being counted, so that the running total of
+// their size must be recomputed.
+func (m *manager) invalidateInboxSize() {
+	u := &m.inbox
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.known = false
+	u.gen++
+}
+
+// scanInboxSize returns the total size of the files in the inbox, from
+// the files themselves.
+func (m *manager) scanInboxSize() int64 {
+	var total int64
+	names, _ := m.opts.fileOps.ListFiles()
+	if tree, ok := m.opts.fileOps.(dirFileOps); ok {
+		dirs, _ := tree.ListDirs()
+		for _, dir := range dirs {
+			files, _ := tree.ListTree(dir)
+			for _, f := range files {
+				names = append(names, dir+"/"+f)
+			}
+		}
+	}
+	for _, name := range names {
+		if fi, err := m.opts.fileOps.Stat(name); err == nil {
+			total += fi.Size()
+		}
+	}
+	return total
+}
+
+// canScan reports whether the policy's scanner can be run on received
+// files, which requires them to be on the local filesystem.
+func (m *manager) canScan() bool {
+	_, ok := m.opts.fileOps.(localFileOps)
+	return ok
+}
+
+// scanFile runs the policy's scanner, if any, on the received file at
+// the given path, as returned by the manager's FileOps. It returns
+// [ErrFileRejected] if the scanner rejects it, or if it can't be run
+// because the FileOps isn't a [localFileOps], so that a configured
+// scanner never lets a file through unscanned.
+func (m *manager) scanFile(filePath string) error {
+	scanner := m.policy.Load().Scanner
+	if scanner == "" {
+		return nil
+	}
+	if !m.canScan() {
+		m.opts.Logf("rejecting received file: scanner unsupported by %T", m.opts.fileOps)
+		return ErrFileRejected
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), scanTimeout)
+	defer cancel()
+	out, err := exec.CommandContext(ctx, scanner, filePath).CombinedOutput()
+	if err != nil {
+		redacted := redactString(filePath)
+		line, _, _ := bytes.Cut(bytes.TrimSpace(out), []byte("\n"))
+		line = bytes.ReplaceAll(line, []byte(filePath), []byte(redacted))
+		m.opts.Logf("scanner rejected %s: %v: %s", redacted, err, truncateOutput(line))
+		return ErrFileRejected
+	}
+	return nil
+}
+
+// truncateOutput returns b as a string of at most 200 bytes,
+// for logging scanner output.
+func truncateOutput(b []byte) string {
+	const max = 200
+	if len(b) > max {
+		return fmt.Sprintf("%s...", b[:max])
+	}
+	return string(b)
+}
@@ -0,0 +1,237 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package taildrop
+
+import (
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"tailscale.com/client/tailscale/apitype"
+	"tailscale.com/envknob"
+	"tailscale.com/tailcfg"
+	"tailscale.com/util/must"
+	"tailscale.com/util/syspolicy/pkey"
+	"tailscale.com/util/syspolicy/policyclient"
+	"tailscale.com/util/syspolicy/policytest"
+)
+
+func TestPolicyAllowsSender(t *testing.T) {
+	p := receivePolicy{AllowUsers: []string{"alice@example.com"}, AllowTags: []string{"tag:server"}}
+	tagged := (&tailcfg.Node{Tags: []string{"tag:other", "tag:server"}}).View()
+	otherTag := (&tailcfg.Node{Tags: []string{"tag:other"}}).View()
+	untagged := (&tailcfg.Node{}).View()
+
+	tests := []struct {
+		name  string
+		peer  tailcfg.NodeView
+		login string
+		want  bool
+	}{
+		{"allowed-user", untagged, "alice@example.com", true},
+		{"other-user", untagged, "bob@example.com", false},
+		{"unknown-user", untagged, "", false},
+		{"allowed-tag", tagged, "", true},
+		{"other-tag", otherTag, "", false},
+		// Tagged nodes aren't owned by the user they were created by.
+		{"tagged-with-user", otherTag, "alice@example.com", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := p.allowsSender(tt.peer, tt.login); got != tt.want {
+				t.Errorf("allowsSender = %v, want %v", got, tt.want)
+			}
+		})
+	}
+	var zero receivePolicy
+	if !zero.allowsSender(untagged, "") {
+		t.Error("zero policy rejected sender")
+	}
+}
+
+func TestPolicyFromSys(t *testing.T) {
+	for k, v := range map[string]string{
+		"TS_TAILDROP_ALLOW_EXTENSIONS": "JPG, .png",
+		"TS_TAILDROP_ALLOW_USERS":      "alice@example.com,bob@example.com",
+		"TS_TAILDROP_MAX_FILE_SIZE":    "100",
+	} {
+		envknob.Setenv(k, v)
+		t.Cleanup(func() { envknob.Setenv(k, "") })
+	}
+	p := policyFromSys(policyclient.NoPolicyClient{})
+	if got := strings.Join(p.AllowExtensions, ","); got != ".jpg,.png" {
+		t.Errorf("AllowExtensions = %q", got)
+	}
+	if len(p.AllowUsers) != 2 {
+		t.Errorf("AllowUsers = %q", p.AllowUsers)
+	}
+	if p.MaxFileSize != 100 {
+		t.Errorf("MaxFileSize = %v, want 100", p.MaxFileSize)
+	}
+	for name, want := range map[string]bool{
+		"a.jpg":     true,
+		"a.JPG":     true,
+		"dir/b.png": true,
+		"a.jpg.exe": false,
+		"jpg":       false,
+	} {
+		if got := p.allowsName(name); got != want {
+			t.Errorf("allowsName(%q) = %v, want %v", name, got, want)
+		}
+	}
+
+	// System policy takes precedence over the environment.
+	polc := policytest.Config{
+		pkey.TaildropAllowExtensions: []string{"PDF"},
+		pkey.TaildropMaxFileSize:     uint64(50),
+		pkey.TaildropScanner:         "/usr/bin/scan",
+	}
+	p = policyFromSys(polc)
+	if got := strings.Join(p.AllowExtensions, ","); got != ".pdf" {
+		t.Errorf("AllowExtensions = %q, want .pdf", got)
+	}
+	if p.MaxFileSize != 50 || p.Scanner != "/usr/bin/scan" {
+		t.Errorf("MaxFileSize, Scanner = %v, %q; want 50, /usr/bin/scan", p.MaxFileSize, p.Scanner)
+	}
+	if len(p.AllowUsers) != 2 {
+		t.Errorf("AllowUsers = %q, want those from the environment", p.AllowUsers)
+	}
+}
+
+func TestPolicySizeLimits(t *testing.T) {
+	dir := t.TempDir()
+	m := managerOptions{
+		Logf:    t.Logf,
+		fileOps: must.Get(newFileOps(dir)),
+		Policy:  receivePolicy{MaxFileSize: 10, MaxInboxSize: 15},
+	}.New()
+	defer m.Shutdown()
+
+	if _, err := m.PutFile("", "big", strings.NewReader("0123456789x"), 0, 11); err != ErrFileTooLarge {
+		t.Errorf("PutFile of declared large file: err = %v, want %v", err, ErrFileTooLarge)
+	}
+	// Files of unknown length are cut off at the limit.
+	if _, err := m.PutFile("", "big", strings.NewReader("0123456789x"), 0, -1); err != ErrFileTooLarge {
+		t.Errorf("PutFile of large file: err = %v, want %v", err, ErrFileTooLarge)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "big.partial")); !os.IsNotExist(err) {
+		t.Errorf("partial file of rejected file left behind: %v", err)
+	}
+
+	must.Get(m.PutFile("", "a", strings.NewReader("0123456789"), 0, 10))
+	if _, err := m.PutFile("", "b", strings.NewReader("012345"), 0, 6); err != ErrInboxFull {
+		t.Errorf("PutFile into full inbox: err = %v, want %v", err, ErrInboxFull)
+	}
+	if _, err := m.PutFile("", "b", strings.NewReader("012345"), 0, -1); err != ErrInboxFull {
+		t.Errorf("PutFile of unknown length into full inbox: err = %v, want %v", err, ErrInboxFull)
+	}
+	must.Get(m.PutFile("", "b", strings.NewReader("01234"), 0, 5))
+
+	// Directories are checked as a whole before any file is sent.
+	must.Do(m.DeleteFile("a"))
+	manifest := &apitype.TaildropDirManifest{Files: []apitype.TaildropDirFile{{Name: "x", Size: 6}, {Name: "y", Size: 5}}}
+	if err := m.StartDir("", "d", manifest); err != ErrInboxFull {
+		t.Errorf("StartDir into full inbox: err = %v, want %v", err, ErrInboxFull)
+	}
+}
+
+func TestInboxSize(t *testing.T) {
+	dir := t.TempDir()
+	m := managerOptions{
+		Logf:    t.Logf,
+		fileOps: must.Get(newFileOps(dir)),
+		Policy:  receivePolicy{MaxInboxSize: 100},
+	}.New()
+	defer m.Shutdown()
+
+	check := func(want int64) {
+		t.Helper()
+		if got := m.inboxSize(); got != want {
+			t.Errorf("inboxSize = %v, want %v", got, want)
+		}
+		if got := m.scanInboxSize(); got != want {
+			t.Errorf("scanInboxSize = %v, want %v", got, want)
+		}
+	}
+	check(0)
+	must.Get(m.PutFile("", "a", strings.NewReader("0123456789"), 0, 10))
+	check(10)
+	// Resumed files only add what's written.
+	r := io.MultiReader(strings.NewReader("01234"), iotest.ErrReader(io.ErrUnexpectedEOF))
+	if _, err := m.PutFile("id", "b", r, 0, 10); err == nil {
+		t.Fatal("PutFile of interrupted file succeeded")
+	}
+	check(15)
+	must.Get(m.PutFile("id", "b", strings.NewReader("56789"), 5, 5))
+	check(20)
+	must.Do(m.DeleteFile("a"))
+	check(10)
+
+	// Files added other than by the manager are counted once the running
+	// total is recomputed.
+	must.Do(os.WriteFile(filepath.Join(dir, "c"), []byte("01234"), 0o666))
+	m.invalidateInboxSize()
+	check(15)
+}
+
+func TestPolicyScanner(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("test uses a shell script")
+	}
+	sh, err := exec.LookPath("sh")
+	if err != nil {
+		t.Skip(err)
+	}
+	dir := t.TempDir()
+	scanner := filepath.Join(t.TempDir(), "scan")
+	script := "#!" + sh + "\nif grep -q EICAR \"$1\"; then echo \"$1: virus found\"; exit 1; fi\n"
+	must.Do(os.WriteFile(scanner, []byte(script), 0o755))
+
+	m := managerOptions{
+		Logf:    t.Logf,
+		fileOps: must.Get(newFileOps(dir)),
+		Policy:  receivePolicy{Scanner: scanner},
+	}.New()
+	defer m.Shutdown()
+
+	must.Get(m.PutFile("", "clean.txt", strings.NewReader("hello"), 0, 5))
+	if _, err := m.PutFile("", "bad.txt", strings.NewReader("EICAR"), 0, 5); err != ErrFileRejected {
+		t.Errorf("PutFile of rejected file: err = %v, want %v", err, ErrFileRejected)
+	}
+	wfs := must.Get(m.WaitingFiles())
+	if len(wfs) != 1 || wfs[0].Name != "clean.txt" {
+		t.Errorf("WaitingFiles = %v, want only clean.txt", wfs)
+	}
+	if entries := must.Get(os.ReadDir(dir)); len(entries) != 1 {
+		t.Errorf("rejected file left behind: %v", entries)
+	}
+
+	// Files that aren't on the local filesystem can't be scanned,
+	// so they're all rejected.
+	dir2 := t.TempDir()
+	m2 := managerOptions{
+		Logf:    t.Logf,
+		fileOps: remoteFileOps{must.Get(newFileOps(dir2))},
+		Policy:  receivePolicy{Scanner: scanner},
+	}.New()
+	defer m2.Shutdown()
+	if _, err := m2.PutFile("", "clean.txt", strings.NewReader("hello"), 0, 5); err != ErrFileRejected {
+		t.Errorf("PutFile with non-local FileOps: err = %v, want %v", err, ErrFileRejected)
+	}
+	if entries := must.Get(os.ReadDir(dir2)); len(entries) != 0 {
+		t.Errorf("rejected file left behind: %v", entries)
+	}
+	if err := m2.scanFile(filepath.Join(dir2, "clean.txt")); err != ErrFileRejected {
+		t.Errorf("scanFile with non-local FileOps: err = %v, want %v", err, ErrFileRejected)
+	}
+}
+
+// remoteFileOps is a FileOps that isn't a localFileOps, like those whose
+// paths aren't on the local filesystem.
+type remoteFileOps struct{ FileOps }
@@ -79,7 +79,7 @@ func (m *manager) HashPartialFile(id clientID, baseName string) (next func() (bl
 	noopNext := func() (blockChecksum, error) { return blockChecksum{}, io.EOF }
 	noopClose := func() error { return nil }
 
-	f, err := m.opts.fileOps.OpenReader(baseName + id.partialSuffix())
+	f, err := m.opts.fileOps.OpenReader(id.partialName(baseName))
 	if err != nil {
 		if os.IsNotExist(err) {
 			return noopNext, noopClose, nil
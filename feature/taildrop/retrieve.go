@@ -53,6 +53,9 @@ func (m *manager) HasFilesWaiting() bool {
 		// Found at least one downloadable file
 		return true
 	}
+	if files, _ := m.waitingDirFiles(); len(files) > 0 {
+		return true
+	}
 
 	// No waiting files → update negative‑result cache
 	m.emptySince.Store(total)
@@ -61,6 +64,8 @@ func (m *manager) HasFilesWaiting() bool {
 
 // WaitingFiles returns the list of files that have been sent by a
 // peer that are waiting in [Handler.Dir].
+// Files within received directories are named by their slash-separated
+// paths, and only appear once the whole directory has been received.
 // This always returns nil when [Handler.DirectFileMode] is false.
 func (m *manager) WaitingFiles() ([]apitype.WaitingFile, error) {
 	if m == nil || m.opts.fileOps == nil {
@@ -91,6 +96,11 @@ func (m *manager) WaitingFiles() ([]apitype.WaitingFile, error) {
 			Size: fi.Size(),
 		})
 	}
+	dirFiles, err := m.waitingDirFiles()
+	if err != nil {
+		return nil, err
+	}
+	ret = append(ret, dirFiles...)
 	sort.Slice(ret, func(i, j int) bool { return ret[i].Name < ret[j].Name })
 	return ret, nil
 }
@@ -108,6 +118,7 @@ func (m *manager) DeleteFile(baseName string) error {
 	var bo *backoff.Backoff
 	logf := m.opts.Logf
 	t0 := m.opts.Clock.Now()
+	defer m.invalidateInboxSize()
 	for {
 		err := m.opts.fileOps.Remove(baseName)
 		if err != nil && !os.IsNotExist(err) {
@@ -137,6 +148,7 @@ func (m *manager) DeleteFile(baseName string) error {
 			logf("peerapi: failed to DeleteFile: %v", err)
 			return err
 		}
+		m.removeEmptyParents(baseName)
 		return nil
 	}
 }
@@ -6,6 +6,7 @@
 import (
 	"fmt"
 	"io"
+	"strings"
 	"sync"
 	"time"
 
@@ -59,7 +60,10 @@ func (f *incomingFile) Write(p []byte) (n int, err error) {
 }
 
 // PutFile stores a file into [manager.Dir] from a given client id.
-// The baseName must be a base filename without any slashes.
+// The baseName must be a base filename without any slashes,
+// unless it names a file within a directory being received
+// (see [manager.StartDir]), in which case it's the slash-separated
+// path of the file starting with the directory name.
 // The length is the expected length of content to read from r,
 // it may be negative to indicate that it is unknown.
 // It returns the length of the entire file.
@@ -81,15 +85,58 @@ func (m *manager) PutFile(id clientID, baseName string, r io.Reader, offset, len
 		return 0, ErrNotAccessible
 	}
 
-	if err := validateBaseName(baseName); err != nil {
+	// A name within a directory is received as part of
+	// a directory transfer started with [manager.StartDir].
+	partialName := id.partialName(baseName)
+	cleanupName := partialName
+	dirName, relName, inDir := strings.Cut(baseName, "/")
+	var dt *dirTransfer
+	if inDir {
+		if err := validateBaseName(dirName); err != nil {
+			return 0, err
+		}
+		if err := validateRelName(relName); err != nil {
+			return 0, err
+		}
+		if dt, err = m.loadDirTransfer(id, dirName); err != nil {
+			return 0, err
+		}
+		size, ok := dt.files[relName]
+		if !ok {
+			return 0, ErrInvalidFileName
+		}
+		if length >= 0 && offset+length != size {
+			return 0, fmt.Errorf("file size %d does not match manifest size %d", offset+length, size)
+		}
+		dt.mu.Lock()
+		dt.pending.Add(relName)
+		dt.mu.Unlock()
+		cleanupName = dt.staging
+	} else if err := validateBaseName(baseName); err != nil {
 		return 0, err
 	}
 
+	limit, limitErr, err := m.checkFile(baseName, partialName, offset, length)
+	if err != nil {
+		return 0, err
+	}
+	if limit >= 0 {
+		// Read one more byte than the limit to detect exceeding it.
+		r = io.LimitReader(r, limit+1)
+	}
+
 	// and make sure we don't delete it while uploading:
 	m.deleter.Remove(baseName)
+	if inDir {
+		m.deleter.Remove(cleanupName)
+	}
 
 	// Create (if not already) the partial file with read-write permissions.
-	partialName := baseName + id.partialSuffix()
+	inboxGen := m.inboxGen()
+	var prevSize int64 // of the partial file, which OpenWriter may truncate
+	if fi, err := m.opts.fileOps.Stat(partialName); err == nil {
+		prevSize = fi.Size()
+	}
 	wc, partialPath, err := m.opts.fileOps.OpenWriter(partialName, offset, 0o666)
 	if err != nil {
 		return 0, m.redactAndLogError("Create", err)
@@ -97,7 +144,8 @@ func (m *manager) PutFile(id clientID, baseName string, r io.Reader, offset, len
 	defer func() {
 		wc.Close()
 		if err != nil {
-			m.deleter.Insert(partialName) // mark partial file for eventual deletion
+			m.deleter.Insert(cleanupName) // mark partial file for eventual deletion
+			m.invalidateInboxSize()
 		}
 	}()
 
@@ -139,6 +187,10 @@ func (m *manager) PutFile(id clientID, baseName string, r io.Reader, offset, len
 	if err != nil {
 		return 0, m.redactAndLogError("Copy", err)
 	}
+	if limit >= 0 && copyLength > limit {
+		m.opts.fileOps.Remove(partialName)
+		return 0, limitErr
+	}
 	if length >= 0 && copyLength != length {
 		return 0, m.redactAndLogError("Copy", fmt.Errorf("copied %d bytes; expected %d", copyLength, length))
 	}
@@ -146,12 +198,31 @@ func (m *manager) PutFile(id clientID, baseName string, r io.Reader, offset, len
 		return 0, m.redactAndLogError("Close", err)
 	}
 
+	m.addInboxSize(inboxGen, offset+copyLength-prevSize)
+
 	fileLength = offset + copyLength
+	if inDir && fileLength != dt.files[relName] {
+		return 0, m.redactAndLogError("Copy", fmt.Errorf("received %d bytes; expected %d", fileLength, dt.files[relName]))
+	}
+
+	if err := m.scanFile(partialPath); err != nil {
+		m.opts.fileOps.Remove(partialName)
+		return 0, err
+	}
 
 	inFile.mu.Lock()
 	inFile.done = true
 	inFile.mu.Unlock()
 
+	// Files within a directory stay in its staging directory
+	// until the whole directory has been received.
+	if inDir {
+		if err := m.receivedDirFile(incomingFileKey{id, dirName}, dt, relName); err != nil {
+			return 0, err
+		}
+		return fileLength, nil
+	}
+
 	// 6) Finalize (rename/move) the partial into place via FileOps.Rename
 	finalPath, err := m.opts.fileOps.Rename(partialPath, baseName)
 	if err != nil {
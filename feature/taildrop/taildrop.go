@@ -88,6 +88,10 @@ type managerOptions struct {
 	// use fsFileOps.
 	fileOps FileOps
 
+	// Policy restricts the files that are received, until changed
+	// with the manager's setPolicy.
+	Policy receivePolicy
+
 	// SendFileNotify is called periodically while a file is actively
 	// receiving the contents for the file. There is a final call
 	// to the function when reception completes.
@@ -99,8 +103,16 @@ type managerOptions struct {
 type manager struct {
 	opts managerOptions
 
+	// policy restricts the files that are received.
+	policy syncs.AtomicValue[receivePolicy]
+	// inbox tracks the total size of the files in the inbox.
+	inbox inboxUsage
+
 	// incomingFiles is a map of files actively being received.
 	incomingFiles syncs.Map[incomingFileKey, *incomingFile]
+	// dirTransfers is a map of directories being received,
+	// keyed by the sender and directory name.
+	dirTransfers syncs.Map[incomingFileKey, *dirTransfer]
 	// deleter managers asynchronous deletion of files.
 	deleter fileDeleter
 
@@ -122,11 +134,17 @@ func (opts managerOptions) New() *manager {
 		opts.SendFileNotify = func() {}
 	}
 	m := &manager{opts: opts}
+	m.policy.Store(opts.Policy)
 	m.deleter.Init(m, func(string) {})
 	m.emptySince.Store(-1) // invalidate this cache
 	return m
 }
 
+// setPolicy sets the receive policy for files received from now on.
+func (m *manager) setPolicy(p receivePolicy) {
+	m.policy.Store(p)
+}
+
 // Shutdown shuts down the Manager.
 // It blocks until all spawned goroutines have stopped running.
 func (m *manager) Shutdown() {
@@ -0,0 +1,37 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build !ts_omit_tailnetlock && !plan9 && !js && !wasip1
+
+// Package tkabolt registers support for storing Tailnet Lock state in a
+// single database file (see [boltchonk]), which is enabled with the
+// TS_TKA_BOLT_STORAGE environment variable.
+//
+// It's linked into tailscaled unless built with ts_omit_tkabolt. Other
+// programs, such as those using tsnet, can import it to enable it.
+package tkabolt
+
+import (
+	"tailscale.com/envknob"
+	"tailscale.com/ipn/ipnlocal"
+	"tailscale.com/tka"
+	"tailscale.com/tka/boltchonk"
+)
+
+// useBoltStorage is whether to store TKA state in a single database file
+// rather than a directory of files, migrating any existing directory.
+//
+// Once migrated, state is read from the database regardless of this knob.
+var useBoltStorage = envknob.RegisterBool("TS_TKA_BOLT_STORAGE")
+
+func init() {
+	ipnlocal.HookOpenTKADatabase.Set(openDatabase)
+}
+
+func openDatabase(dir string, create bool) (tka.CompactableChonk, error) {
+	c, err := boltchonk.OpenDir(dir, create, useBoltStorage())
+	if c == nil {
+		return nil, err
+	}
+	return c, err
+}
@@ -122,7 +122,7 @@ func (src *ServeConfig) Clone() *ServeConfig {
 			if v == nil {
 				dst.TCP[k] = nil
 			} else {
-				dst.TCP[k] = ptr.To(*v)
+				dst.TCP[k] = v.Clone()
 			}
 		}
 	}
@@ -184,7 +184,7 @@ func (src *ServiceConfig) Clone() *ServiceConfig {
 			if v == nil {
 				dst.TCP[k] = nil
 			} else {
-				dst.TCP[k] = ptr.To(*v)
+				dst.TCP[k] = v.Clone()
 			}
 		}
 	}
@@ -216,6 +216,7 @@ func (src *TCPPortHandler) Clone() *TCPPortHandler {
 	}
 	dst := new(TCPPortHandler)
 	*dst = *src
+	dst.Backends = append(src.Backends[:0:0], src.Backends...)
 	return dst
 }
 
@@ -224,6 +225,8 @@ func (src *TCPPortHandler) Clone() *TCPPortHandler {
 	HTTPS         bool
 	HTTP          bool
 	TCPForward    string
+	Backends      []string
+	LoadBalancer  LoadBalancer
 	TerminateTLS  string
 	ProxyProtocol int
 }{})
@@ -237,16 +240,29 @@ func (src *HTTPHandler) Clone() *HTTPHandler {
 	dst := new(HTTPHandler)
 	*dst = *src
 	dst.AcceptAppCaps = append(src.AcceptAppCaps[:0:0], src.AcceptAppCaps...)
+	dst.SetResponseHeaders = maps.Clone(src.SetResponseHeaders)
+	dst.Backends = append(src.Backends[:0:0], src.Backends...)
+	dst.SetRequestHeaders = maps.Clone(src.SetRequestHeaders)
+	dst.IdentityHeaders = maps.Clone(src.IdentityHeaders)
 	return dst
 }
 
 // A compilation failure here means this code must be regenerated, with the command at the top of this file.
 var _HTTPHandlerCloneNeedsRegeneration = HTTPHandler(struct {
-	Path          string
-	Proxy         string
-	Text          string
-	AcceptAppCaps []tailcfg.PeerCapability
-	Redirect      string
+	Path               string
+	Proxy              string
+	Text               string
+	AcceptAppCaps      []tailcfg.PeerCapability
+	Redirect           string
+	SetResponseHeaders map[string]string
+	RateLimit          float64
+	RateLimitBurst     int
+	Backends           []string
+	LoadBalancer       LoadBalancer
+	StripPrefix        string
+	AddPrefix          string
+	SetRequestHeaders  map[string]string
+	IdentityHeaders    map[string]string
 }{})
 
 // Clone makes a deep copy of WebServerConfig.
@@ -814,6 +814,17 @@ func (v TCPPortHandlerView) HTTP() bool { return v.ж.HTTP }
 // It is mutually exclusive with HTTPS.
 func (v TCPPortHandlerView) TCPForward() string { return v.ж.TCPForward }
 
+// Backends, if non-empty, lists additional IP:port destinations to
+// forward TCP connections to. Connections are load-balanced across
+// TCPForward and Backends as configured by LoadBalancer. If connecting
+// to the chosen backend fails, the others are tried in turn.
+// It is only used if TCPForward is non-empty.
+func (v TCPPortHandlerView) Backends() views.Slice[string] { return views.SliceOf(v.ж.Backends) }
+
+// LoadBalancer configures how connections are spread across TCPForward
+// and Backends. It is only used if Backends is non-empty.
+func (v TCPPortHandlerView) LoadBalancer() LoadBalancer { return v.ж.LoadBalancer }
+
 // TerminateTLS, if non-empty, means that tailscaled should terminate the
 // TLS connections before forwarding them to TCPForward, permitting only the
 // SNI name with this value. It is only used if TCPForward is non-empty.
@@ -831,6 +842,8 @@ func (v TCPPortHandlerView) ProxyProtocol() int { return v.ж.ProxyProtocol }
 	HTTPS         bool
 	HTTP          bool
 	TCPForward    string
+	Backends      []string
+	LoadBalancer  LoadBalancer
 	TerminateTLS  string
 	ProxyProtocol int
 }{})
@@ -925,13 +938,76 @@ func (v HTTPHandlerView) AcceptAppCaps() views.Slice[tailcfg.PeerCapability] {
 //   - ${REQUEST_URI}: replaced with the request's full URI (path and query string)
 func (v HTTPHandlerView) Redirect() string { return v.ж.Redirect }
 
+// SetResponseHeaders are static headers set on responses sent back to
+// the client, replacing any values set by the handler or proxy backend.
+func (v HTTPHandlerView) SetResponseHeaders() views.Map[string, string] {
+	return views.MapOf(v.ж.SetResponseHeaders)
+}
+
+// RateLimit, if positive, is the maximum sustained number of requests
+// per second accepted from each source node (or, for Funnel requests,
+// each client IP). Requests over the limit get HTTP 429 (Too Many
+// Requests).
+func (v HTTPHandlerView) RateLimit() float64 { return v.ж.RateLimit }
+
+// RateLimitBurst is the number of requests a source may make at once
+// before RateLimit applies. If zero, RateLimit rounded up (minimum 1)
+// is used. It is only used if RateLimit is positive.
+func (v HTTPHandlerView) RateLimitBurst() int { return v.ж.RateLimitBurst }
+
+// Backends, if non-empty, lists additional proxy backends, in the same
+// forms as Proxy. Requests are load-balanced across Proxy and Backends
+// as configured by LoadBalancer. Unlike TCP forwarding, a request isn't
+// retried on another backend if the chosen one fails; it fails with a
+// 502, and the failure counts against the backend's health.
+func (v HTTPHandlerView) Backends() views.Slice[string] { return views.SliceOf(v.ж.Backends) }
+
+// LoadBalancer configures how requests are spread across Proxy and
+// Backends. It is only used if Backends is non-empty.
+func (v HTTPHandlerView) LoadBalancer() LoadBalancer { return v.ж.LoadBalancer }
+
+// StripPrefix, if non-empty, is removed from the start of the request
+// path before it is proxied. It is applied after the mount point has
+// been trimmed, so for a handler mounted at "/app/" with StripPrefix
+// "/v1", a request for "/app/v1/foo" is proxied as "/foo".
+func (v HTTPHandlerView) StripPrefix() string { return v.ж.StripPrefix }
+
+// AddPrefix, if non-empty, is prepended to the request path before it is
+// proxied, after any StripPrefix has been removed. Together with
+// StripPrefix it can be used to rewrite one path prefix to another.
+func (v HTTPHandlerView) AddPrefix() string { return v.ж.AddPrefix }
+
+// SetRequestHeaders are static headers set on requests sent to the
+// proxy backend, replacing any values sent by the client.
+func (v HTTPHandlerView) SetRequestHeaders() views.Map[string, string] {
+	return views.MapOf(v.ж.SetRequestHeaders)
+}
+
+// IdentityHeaders optionally renames the Tailscale identity headers
+// sent to the proxy backend. The keys are the default header names
+// ("Tailscale-User-Login", "Tailscale-User-Name" or
+// "Tailscale-User-Profile-Pic") and the values are the names to use
+// instead. Client-supplied values for both names are always removed.
+func (v HTTPHandlerView) IdentityHeaders() views.Map[string, string] {
+	return views.MapOf(v.ж.IdentityHeaders)
+}
+
 // A compilation failure here means this code must be regenerated, with the command at the top of this file.
 var _HTTPHandlerViewNeedsRegeneration = HTTPHandler(struct {
-	Path          string
-	Proxy         string
-	Text          string
-	AcceptAppCaps []tailcfg.PeerCapability
-	Redirect      string
+	Path               string
+	Proxy              string
+	Text               string
+	AcceptAppCaps      []tailcfg.PeerCapability
+	Redirect           string
+	SetResponseHeaders map[string]string
+	RateLimit          float64
+	RateLimitBurst     int
+	Backends           []string
+	LoadBalancer       LoadBalancer
+	StripPrefix        string
+	AddPrefix          string
+	SetRequestHeaders  map[string]string
+	IdentityHeaders    map[string]string
 }{})
 
 // View returns a read-only view of WebServerConfig.
@@ -0,0 +1,436 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package ipnlocal
+
+import (
+	"cmp"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+
+	"tailscale.com/client/tailscale/apitype"
+	"tailscale.com/envknob"
+	"tailscale.com/feature/buildfeatures"
+	"tailscale.com/logpolicy"
+	"tailscale.com/logtail"
+	"tailscale.com/net/dns/resolver"
+	"tailscale.com/syncs"
+	"tailscale.com/types/logid"
+	"tailscale.com/util/clientmetric"
+	"tailscale.com/util/set"
+	"tailscale.com/util/syspolicy/pkey"
+)
+
+var (
+	// dnsQueryLogFile, if set, is the path of a file to append DNS query
+	// log records to, one JSON object per line. It takes precedence over
+	// the [pkey.DNSQueryLog] policy setting, which logs them to
+	// dnsQueryLogFileName in the state directory.
+	dnsQueryLogFile = envknob.RegisterString("TS_DNS_QUERY_LOG_FILE")
+
+	// dnsQueryLogLogtail is whether to upload DNS query log records to
+	// their own logtail collection, dnsQueryLogCollection, which is
+	// separate from tailscaled's log.
+	dnsQueryLogLogtail = envknob.RegisterBool("TS_DNS_QUERY_LOG_LOGTAIL")
+
+	// dnsQueryLogLogtailURL, if set, is the base URL of the logtail server
+	// to upload DNS query log records to, rather than the one tailscaled
+	// logs to.
+	dnsQueryLogLogtailURL = envknob.RegisterString("TS_DNS_QUERY_LOG_LOGTAIL_URL")
+)
+
+// dnsQueryLogCollection is the logtail collection that DNS query log
+// records are uploaded to, if enabled.
+const dnsQueryLogCollection = "dnsqueries.log.tailscale.io"
+
+// dnsQueryLogFileName is the name of the DNS query log file in the state
+// directory, when enabled by policy.
+const dnsQueryLogFileName = "dns-queries.log"
+
+var metricDNSQueryLogDropped = clientmetric.NewCounter("dns_query_log_dropped")
+
+const (
+	// dnsQueryLogRecent is the number of recent DNS query log records kept
+	// in memory.
+	dnsQueryLogRecent = 1000
+
+	// dnsQueryLogMaxFileSize is the size at which the DNS query log file is
+	// rotated.
+	dnsQueryLogMaxFileSize = 10 << 20
+
+	// dnsQueryLogMaxFiles is the number of rotated DNS query log files
+	// kept, in addition to the current one.
+	dnsQueryLogMaxFiles = 5
+)
+
+// dnsQueryLog receives records of the DNS queries answered by the internal
+// resolver and writes them to the configured sinks: the in-memory log of
+// recent queries, LocalAPI followers, a rotating local file and a logtail
+// collection of their own.
+//
+// Query logging is opt-in. The resolver only produces records while the
+// file sink is enabled, by policy or envknob, the logtail sink is enabled
+// by envknob, or a follower is attached. Records are never written to
+// tailscaled's own log.
+type dnsQueryLog struct {
+	b    *LocalBackend
+	tail *logtail.Logger // or nil if not uploading records
+
+	entc    chan resolver.QueryLogEntry
+	started atomic.Bool // whether the run goroutine was started
+
+	mu        syncs.Mutex
+	path      string                                       // log file path, or empty for no file sink
+	hooked    bool                                         // whether the resolver's QueryLogger is set
+	recent    []apitype.DNSQueryLogEntry                   // ring buffer of up to dnsQueryLogRecent records
+	recentPos int                                          // index of the next record in recent, once full
+	followers set.HandleSet[chan apitype.DNSQueryLogEntry] // attached LocalAPI followers
+	f         *os.File                                     // current log file, or nil if not open
+	fsize     int64                                        // size of f
+}
+
+// initDNSQueryLog sets up DNS query logging, enabling the file and
+// logtail sinks if they're configured.
+func (b *LocalBackend) initDNSQueryLog() {
+	if !buildfeatures.HasDNS {
+		return
+	}
+	q := &dnsQueryLog{
+		b:    b,
+		entc: make(chan resolver.QueryLogEntry, 256),
+	}
+	b.dnsQueryLog = q
+	if dnsQueryLogLogtail() {
+		q.tail = b.newDNSQueryLogtail()
+	}
+	q.reconfigure()
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.updateHookLocked()
+}
+
+// newDNSQueryLogtail returns a logtail logger that uploads DNS query log
+// records to dnsQueryLogCollection, or nil if that's not possible.
+func (b *LocalBackend) newDNSQueryLogtail() *logtail.Logger {
+	if !buildfeatures.HasLogTail {
+		b.logf("dnsquerylog: logtail support not compiled in")
+		return nil
+	}
+	if b.backendLogID.IsZero() {
+		b.logf("dnsquerylog: logtail disabled, as logging isn't in use")
+		return nil
+	}
+	baseURL := cmp.Or(strings.TrimRight(dnsQueryLogLogtailURL(), "/"), logpolicy.LogURL())
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		b.logf("dnsquerylog: invalid logtail URL: %v", err)
+		return nil
+	}
+	b.logf("DNS query logging to %s/c/%s enabled", baseURL, dnsQueryLogCollection)
+	tr := logpolicy.TransportOptions{
+		Host:   u.Host,
+		NetMon: b.sys.NetMon.Get(),
+		Health: b.health,
+		Logf:   b.logf,
+	}.New()
+	return logtail.NewLogger(logtail.Config{
+		BaseURL:      baseURL,
+		Collection:   dnsQueryLogCollection,
+		PrivateID:    dnsQueryLogID(b.backendLogID),
+		Bus:          b.sys.Bus.Get(),
+		CompressLogs: true,
+		Stderr:       io.Discard,
+		HTTPC:        &http.Client{Transport: tr},
+	}, b.logf)
+}
+
+// dnsQueryLogID derives the private log ID that DNS query log records are
+// uploaded with from a node's backend log ID, in the same way as
+// [sockstatlog.SockstatLogID], so that the records are kept apart from
+// the node's other logs.
+func dnsQueryLogID(logID logid.PublicID) logid.PrivateID {
+	return logid.PrivateID(sha256.Sum256([]byte(logID.String() + "dnsquery")))
+}
+
+// shutdown flushes and stops the logtail sink, if any.
+func (q *dnsQueryLog) shutdown(ctx context.Context) {
+	if q.tail != nil {
+		q.tail.Shutdown(ctx)
+	}
+}
+
+// filePath returns the path of the log file that's configured, by envknob or
+// policy, or the empty string if the file sink is disabled.
+func (q *dnsQueryLog) filePath() string {
+	if path := dnsQueryLogFile(); path != "" {
+		return path
+	}
+	if on, _ := q.b.polc.GetBoolean(pkey.DNSQueryLog, false); !on {
+		return ""
+	}
+	root := q.b.TailscaleVarRoot()
+	if root == "" {
+		q.b.logf("dnsquerylog: no state directory for the DNS query log")
+		return ""
+	}
+	return filepath.Join(root, dnsQueryLogFileName)
+}
+
+// reconfigure enables or disables the file sink, as currently configured.
+func (q *dnsQueryLog) reconfigure() {
+	path := q.filePath()
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if path == q.path {
+		return
+	}
+	if q.f != nil {
+		q.f.Close()
+		q.f = nil
+	}
+	q.path = path
+	if path != "" {
+		q.b.logf("DNS query logging to %q enabled", path)
+	} else {
+		q.b.logf("DNS query logging to file disabled")
+	}
+	q.updateHookLocked()
+}
+
+// persistentLocked reports whether q has a sink that's always active.
+//
+// q.mu must be held.
+func (q *dnsQueryLog) persistentLocked() bool {
+	return q.path != "" || q.tail != nil
+}
+
+// updateHookLocked installs or removes the resolver's QueryLogger
+// depending on whether any sink is active.
+//
+// q.mu must be held.
+func (q *dnsQueryLog) updateHookLocked() {
+	want := q.persistentLocked() || len(q.followers) > 0
+	if want == q.hooked {
+		return
+	}
+	manager, ok := q.b.sys.DNSManager.GetOK()
+	if !ok {
+		q.b.logf("dnsquerylog: DNS manager not available")
+		return
+	}
+	if want {
+		if !q.started.Swap(true) {
+			q.b.goTracker.Go(func() { q.run(q.b.ctx) })
+		}
+		manager.Resolver().SetQueryLogger(q.onQuery)
+	} else {
+		manager.Resolver().SetQueryLogger(nil)
+		q.recent = nil
+		q.recentPos = 0
+	}
+	q.hooked = want
+}
+
+// onQuery is the resolver's QueryLogger. It hands e off to the run
+// goroutine, dropping it if that's fallen behind.
+func (q *dnsQueryLog) onQuery(e *resolver.QueryLogEntry) {
+	select {
+	case q.entc <- *e:
+	default:
+		metricDNSQueryLogDropped.Add(1)
+	}
+}
+
+// run writes the records received by onQuery to q's sinks until ctx is
+// done.
+func (q *dnsQueryLog) run(ctx context.Context) {
+	defer func() {
+		q.mu.Lock()
+		defer q.mu.Unlock()
+		if q.f != nil {
+			q.f.Close()
+			q.f = nil
+		}
+	}()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case e := <-q.entc:
+			q.record(q.convert(&e))
+		}
+	}
+}
+
+// convert converts e to its LocalAPI form, identifying the node it came
+// from.
+func (q *dnsQueryLog) convert(e *resolver.QueryLogEntry) apitype.DNSQueryLogEntry {
+	ret := apitype.DNSQueryLogEntry{
+		Time:      e.Time,
+		Source:    e.Source.String(),
+		FromPeer:  e.FromPeer,
+		Name:      e.Name,
+		Type:      strings.TrimPrefix(e.Type.String(), "Type"),
+		Forwarded: e.Forwarded,
+		Route:     e.Route,
+		Upstream:  e.Upstream,
+		Cached:    e.Cached,
+		Latency:   e.Latency,
+	}
+	if e.Err != nil {
+		ret.Error = e.Err.Error()
+	} else {
+		ret.RCode = strings.TrimPrefix(e.RCode.String(), "RCode")
+	}
+	if e.Source.Addr().IsLoopback() {
+		ret.Local = true
+	}
+	if n, u, ok := q.b.WhoIs("udp", e.Source); ok {
+		ret.Peer = n.ComputedName()
+		ret.User = u.LoginName
+		ret.Local = n.ID() == q.b.currentNode().Self().ID()
+	}
+	return ret
+}
+
+// record writes e to q's sinks.
+func (q *dnsQueryLog) record(e apitype.DNSQueryLogEntry) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if !q.hooked {
+		// Raced with the last follower detaching.
+		return
+	}
+	if len(q.recent) < dnsQueryLogRecent {
+		q.recent = append(q.recent, e)
+	} else {
+		q.recent[q.recentPos] = e
+		q.recentPos = (q.recentPos + 1) % dnsQueryLogRecent
+	}
+	for _, ch := range q.followers {
+		select {
+		case ch <- e:
+		default:
+			// Follower has fallen behind; drop it.
+		}
+	}
+	if !q.persistentLocked() {
+		return
+	}
+	j, err := json.Marshal(e)
+	if err != nil {
+		return
+	}
+	if q.tail != nil {
+		q.tail.Write(j)
+	}
+	if q.path != "" {
+		if err := q.writeFileLocked(append(j, '\n')); err != nil {
+			q.b.logf("dnsquerylog: %v", err)
+		}
+	}
+}
+
+// writeFileLocked appends line to the log file, rotating it first if it
+// would grow too large.
+//
+// q.mu must be held.
+func (q *dnsQueryLog) writeFileLocked(line []byte) error {
+	if q.f != nil && q.fsize+int64(len(line)) > dnsQueryLogMaxFileSize {
+		q.f.Close()
+		q.f = nil
+		rotateDNSQueryLogFiles(q.path)
+	}
+	if q.f == nil {
+		if err := os.MkdirAll(filepath.Dir(q.path), 0700); err != nil {
+			return err
+		}
+		f, err := os.OpenFile(q.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+		if err != nil {
+			return err
+		}
+		fi, err := f.Stat()
+		if err != nil {
+			f.Close()
+			return err
+		}
+		q.f, q.fsize = f, fi.Size()
+	}
+	n, err := q.f.Write(line)
+	q.fsize += int64(n)
+	return err
+}
+
+// rotateDNSQueryLogFiles renames path to path.1, path.1 to path.2, and so
+// on, removing the oldest file.
+func rotateDNSQueryLogFiles(path string) {
+	os.Remove(fmt.Sprintf("%s.%d", path, dnsQueryLogMaxFiles))
+	for i := dnsQueryLogMaxFiles - 1; i > 0; i-- {
+		os.Rename(fmt.Sprintf("%s.%d", path, i), fmt.Sprintf("%s.%d", path, i+1))
+	}
+	os.Rename(path, path+".1")
+}
+
+// DNSQueryLog returns the recent records of DNS queries answered by the
+// internal resolver, oldest first. It returns nil if query logging is not
+// enabled.
+func (b *LocalBackend) DNSQueryLog() ([]apitype.DNSQueryLogEntry, error) {
+	if !buildfeatures.HasDNS {
+		return nil, errors.New("DNS support not compiled in")
+	}
+	q := b.dnsQueryLog
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	ret := make([]apitype.DNSQueryLogEntry, 0, len(q.recent))
+	ret = append(ret, q.recent[q.recentPos:]...)
+	ret = append(ret, q.recent[:q.recentPos]...)
+	return ret, nil
+}
+
+// FollowDNSQueryLog enables DNS query logging, if it's not already enabled,
+// and calls fn with each record of a DNS query answered by the internal
+// resolver until ctx is done or fn returns false.
+//
+// If fn doesn't keep up with the rate of queries, FollowDNSQueryLog returns
+// an error.
+func (b *LocalBackend) FollowDNSQueryLog(ctx context.Context, fn func(apitype.DNSQueryLogEntry) bool) error {
+	if !buildfeatures.HasDNS {
+		return errors.New("DNS support not compiled in")
+	}
+	q := b.dnsQueryLog
+	ch := make(chan apitype.DNSQueryLogEntry, 64)
+	q.mu.Lock()
+	h := q.followers.Add(ch)
+	q.updateHookLocked()
+	q.mu.Unlock()
+	defer func() {
+		q.mu.Lock()
+		delete(q.followers, h)
+		q.updateHookLocked()
+		q.mu.Unlock()
+	}()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case e := <-ch:
+			if !fn(e) {
+				return nil
+			}
+			if len(ch) == cap(ch) {
+				return errors.New("DNS query log follower fell behind")
+			}
+		}
+	}
+}
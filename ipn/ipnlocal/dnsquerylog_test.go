@@ -0,0 +1,179 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package ipnlocal
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"tailscale.com/client/tailscale/apitype"
+	"tailscale.com/envknob"
+	"tailscale.com/log/sockstatlog"
+	"tailscale.com/logtail"
+	"tailscale.com/types/logid"
+	"tailscale.com/util/syspolicy/pkey"
+	"tailscale.com/util/syspolicy/policytest"
+)
+
+func TestDNSQueryLogRecord(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dns", "queries.log")
+	b := &LocalBackend{logf: t.Logf}
+	q := &dnsQueryLog{
+		b:      b,
+		path:   path,
+		hooked: true,
+	}
+	b.dnsQueryLog = q
+
+	const n = dnsQueryLogRecent + 10
+	for i := range n {
+		q.record(apitype.DNSQueryLogEntry{Name: fmt.Sprintf("q%d.example.com.", i), Type: "A", RCode: "Success"})
+	}
+	q.mu.Lock()
+	q.f.Close()
+	q.f = nil
+	q.mu.Unlock()
+
+	// The in-memory log has the most recent records, oldest first.
+	recent, err := b.DNSQueryLog()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(recent) != dnsQueryLogRecent {
+		t.Fatalf("got %d recent records; want %d", len(recent), dnsQueryLogRecent)
+	}
+	if got, want := recent[0].Name, "q10.example.com."; got != want {
+		t.Errorf("oldest record = %q; want %q", got, want)
+	}
+	if got, want := recent[len(recent)-1].Name, fmt.Sprintf("q%d.example.com.", n-1); got != want {
+		t.Errorf("newest record = %q; want %q", got, want)
+	}
+
+	// The file has every record.
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var lines int
+	for s := bufio.NewScanner(f); s.Scan(); lines++ {
+		var e apitype.DNSQueryLogEntry
+		if err := json.Unmarshal(s.Bytes(), &e); err != nil {
+			t.Fatalf("line %d: %v", lines, err)
+		}
+		if want := fmt.Sprintf("q%d.example.com.", lines); e.Name != want {
+			t.Fatalf("line %d: name = %q; want %q", lines, e.Name, want)
+		}
+	}
+	if lines != n {
+		t.Errorf("file has %d records; want %d", lines, n)
+	}
+}
+
+func TestRotateDNSQueryLogFiles(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "queries.log")
+	for i := range dnsQueryLogMaxFiles + 2 {
+		if err := os.WriteFile(path, fmt.Appendf(nil, "%d", i), 0600); err != nil {
+			t.Fatal(err)
+		}
+		rotateDNSQueryLogFiles(path)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("%s exists after rotation", path)
+	}
+	for i := 1; i <= dnsQueryLogMaxFiles; i++ {
+		got, err := os.ReadFile(fmt.Sprintf("%s.%d", path, i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := fmt.Sprint(dnsQueryLogMaxFiles + 2 - i); string(got) != want {
+			t.Errorf("%s.%d = %q; want %q", path, i, got, want)
+		}
+	}
+	if _, err := os.Stat(fmt.Sprintf("%s.%d", path, dnsQueryLogMaxFiles+1)); !os.IsNotExist(err) {
+		t.Errorf("more than %d rotated files kept", dnsQueryLogMaxFiles)
+	}
+}
+
+func TestDNSQueryLogPolicy(t *testing.T) {
+	dir := t.TempDir()
+	polc := policytest.Config{}
+	b := &LocalBackend{logf: t.Logf, polc: &polc, varRoot: dir}
+	q := &dnsQueryLog{b: b}
+	b.dnsQueryLog = q
+
+	if got := q.filePath(); got != "" {
+		t.Errorf("filePath with no policy = %q; want none", got)
+	}
+	polc.Set(pkey.DNSQueryLog, true)
+	if got, want := q.filePath(), filepath.Join(dir, dnsQueryLogFileName); got != want {
+		t.Errorf("filePath with policy = %q; want %q", got, want)
+	}
+	envknob.Setenv("TS_DNS_QUERY_LOG_FILE", "/var/log/dns.log")
+	t.Cleanup(func() { envknob.Setenv("TS_DNS_QUERY_LOG_FILE", "") })
+	if got, want := q.filePath(), "/var/log/dns.log"; got != want {
+		t.Errorf("filePath with envknob = %q; want %q", got, want)
+	}
+}
+
+func TestDNSQueryLogLogtail(t *testing.T) {
+	var (
+		mu    sync.Mutex
+		paths []string
+		body  bytes.Buffer
+	)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		paths = append(paths, r.URL.Path)
+		io.Copy(&body, r.Body)
+	}))
+	defer ts.Close()
+
+	logID := logid.PublicID{1, 2, 3}
+	privID := dnsQueryLogID(logID)
+	b := &LocalBackend{logf: t.Logf}
+	q := &dnsQueryLog{
+		b:      b,
+		hooked: true,
+		tail: logtail.NewLogger(logtail.Config{
+			BaseURL:    ts.URL,
+			Collection: dnsQueryLogCollection,
+			PrivateID:  privID,
+			HTTPC:      ts.Client(),
+			Stderr:     io.Discard,
+		}, t.Logf),
+	}
+	b.dnsQueryLog = q
+
+	q.record(apitype.DNSQueryLogEntry{Name: "example.com.", Type: "A", RCode: "Success"})
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	q.shutdown(ctx)
+
+	mu.Lock()
+	defer mu.Unlock()
+	wantPath := "/c/" + dnsQueryLogCollection + "/" + privID.String()
+	if len(paths) == 0 || paths[0] != wantPath {
+		t.Errorf("uploaded to %q; want %q", paths, wantPath)
+	}
+	if !strings.Contains(body.String(), `"Name":"example.com."`) {
+		t.Errorf("uploaded logs %q don't include the record", body.String())
+	}
+	if privID == sockstatlog.SockstatLogID(logID) {
+		t.Errorf("DNS query log ID is the same as the sockstat log ID")
+	}
+}
@@ -102,6 +102,7 @@
 	"tailscale.com/version/distro"
 	"tailscale.com/wgengine"
 	"tailscale.com/wgengine/filter"
+	"tailscale.com/wgengine/filter/filtertype"
 	"tailscale.com/wgengine/magicsock"
 	"tailscale.com/wgengine/router"
 	"tailscale.com/wgengine/wgcfg"
@@ -241,6 +242,8 @@ type LocalBackend struct {
 	// for testing and graceful shutdown purposes.
 	goTracker goroutines.Tracker
 
+	dnsQueryLog *dnsQueryLog // or nil if DNS support is omitted
+
 	startOnce sync.Once // protects the one‑time initialization in [LocalBackend.Start]
 
 	// extHost is the bridge between [LocalBackend] and the registered [ipnext.Extension]s.
@@ -324,6 +327,8 @@ type LocalBackend struct {
 
 	serveListeners     map[netip.AddrPort]*localListener // listeners for local serve traffic
 	serveProxyHandlers sync.Map                          // string (HTTPHandler.Proxy) => *reverseProxy
+	serveBackendPools  sync.Map                          // string (serveBackendPoolKey) => *serveBackendPool
+	serveRateLimiters  serveRateLimiters                 // per-source limiters for HTTPHandler.RateLimit
 
 	// dialPlan is any dial plan that we've received from the control
 	// server during a previous connection; it is cleared on logout.
@@ -567,6 +572,7 @@ func NewLocalBackend(logf logger.Logf, logID logid.PublicID, sys *tsd.System, lo
 	b.e.SetJailedFilter(noneFilter)
 
 	b.setTCPPortsIntercepted(nil)
+	b.initDNSQueryLog()
 
 	b.e.SetStatusCallback(b.setWgengineStatus)
 
@@ -824,6 +830,57 @@ func (b *LocalBackend) QueryDNS(name string, queryType dnsmessage.Type) (res []b
 	return res, rr, nil
 }
 
+// DNSCache returns the state of the DNS forwarder's response cache. If
+// withResponses is true, the cached responses are included.
+func (b *LocalBackend) DNSCache(withResponses bool) (apitype.DNSCache, error) {
+	if !buildfeatures.HasDNS {
+		return apitype.DNSCache{}, feature.ErrUnavailable
+	}
+	manager, ok := b.sys.DNSManager.GetOK()
+	if !ok {
+		return apitype.DNSCache{}, errors.New("DNS manager not available")
+	}
+	r := manager.Resolver()
+	st, ok := r.CacheStats()
+	if !ok {
+		return apitype.DNSCache{}, nil
+	}
+	ret := apitype.DNSCache{
+		Enabled:    true,
+		Entries:    st.Entries,
+		MaxEntries: st.MaxEntries,
+		Hits:       st.Hits,
+		Misses:     st.Misses,
+	}
+	if withResponses {
+		for _, e := range r.CacheEntries() {
+			ret.Responses = append(ret.Responses, apitype.DNSCacheEntry{
+				Route:    e.Route,
+				Name:     e.Name,
+				Type:     strings.TrimPrefix(e.Type.String(), "Type"),
+				RCode:    e.RCode.String(),
+				Negative: e.Negative,
+				Expires:  e.Expires,
+			})
+		}
+	}
+	return ret, nil
+}
+
+// FlushDNSCache removes all responses from the DNS forwarder's response cache.
+func (b *LocalBackend) FlushDNSCache() error {
+	if !buildfeatures.HasDNS {
+		return feature.ErrUnavailable
+	}
+	manager, ok := b.sys.DNSManager.GetOK()
+	if !ok {
+		return errors.New("DNS manager not available")
+	}
+	manager.Resolver().FlushCache()
+	b.logf("DNS cache flushed")
+	return nil
+}
+
 // GetComponentDebugLogging gets the time that component's debug logging is
 // enabled until, or the zero time if component's time is not currently
 // enabled.
@@ -1180,6 +1237,11 @@ func (b *LocalBackend) Shutdown() {
 		defer cancel()
 		b.sockstatLogger.Shutdown(ctx)
 	}
+	if b.dnsQueryLog != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		b.dnsQueryLog.shutdown(ctx)
+	}
 
 	b.unregisterSysPolicyWatch()
 	if cc != nil {
@@ -1556,6 +1618,27 @@ func (b *LocalBackend) PeerCaps(src netip.Addr) tailcfg.PeerCapMap {
 	return b.currentNode().PeerCaps(src)
 }
 
+// PacketFilterStats returns a snapshot of the per-rule hit counters of the
+// current packet filter.
+func (b *LocalBackend) PacketFilterStats() filtertype.Stats {
+	f := b.currentNode().filter()
+	if f == nil {
+		return filtertype.Stats{}
+	}
+	return f.Stats()
+}
+
+// SimulatePacketFilter replays the new inbound flows recently seen by the
+// current packet filter against a packet filter made of the provided
+// matches. See [filter.Filter.Simulate].
+func (b *LocalBackend) SimulatePacketFilter(matches []filter.Match) []filtertype.SimulatedFlow {
+	f := b.currentNode().filter()
+	if f == nil {
+		return nil
+	}
+	return f.Simulate(matches)
+}
+
 func (b *LocalBackend) GetFilterForTest() *filter.Filter {
 	testenv.AssertInTest()
 	nb := b.currentNode()
@@ -2099,6 +2182,10 @@ func (b *LocalBackend) sysPolicyChanged(policy policyclient.PolicyChange) {
 		// will be used when [applySysPolicy] updates the current profile's prefs.
 	}
 
+	if buildfeatures.HasDNS && policy.HasChanged(pkey.DNSQueryLog) {
+		b.dnsQueryLog.reconfigure()
+	}
+
 	if prefs, anyChange := b.reconcilePrefs(); anyChange {
 		b.logf("syspolicy: changed profile prefs: %v", prefs.Pretty())
 	}
@@ -23,6 +23,7 @@
 	"slices"
 	"time"
 
+	"tailscale.com/feature"
 	"tailscale.com/health"
 	"tailscale.com/health/healthmsg"
 	"tailscale.com/ipn"
@@ -31,6 +32,7 @@
 	"tailscale.com/tailcfg"
 	"tailscale.com/tka"
 	"tailscale.com/tsconst"
+	"tailscale.com/tstime"
 	"tailscale.com/types/key"
 	"tailscale.com/types/logger"
 	"tailscale.com/types/netmap"
@@ -49,6 +51,7 @@
 	tkaCompactionDefaults = tka.CompactionOptions{
 		MinChain: 24,                  // Keep at minimum 24 AUMs since head.
 		MinAge:   14 * 24 * time.Hour, // Keep 2 weeks of AUMs.
+		Interval: 24 * time.Hour,      // Also compact daily, between syncs.
 	}
 )
 
@@ -57,12 +60,16 @@ type tkaState struct {
 	authority *tka.Authority
 	storage   tka.CompactableChonk
 	filtered  []ipnstate.TKAPeer
+
+	// compactTimer fires to compact storage in the background, or is nil
+	// if background compaction is disabled.
+	compactTimer tstime.TimerController
 }
 
 func (b *LocalBackend) initTKALocked() error {
 	cp := b.pm.CurrentProfile()
 	if cp.ID() == "" {
-		b.tka = nil
+		b.setTKALocked(nil)
 		return nil
 	}
 	if b.tka != nil {
@@ -71,40 +78,112 @@ func (b *LocalBackend) initTKALocked() error {
 			return nil
 		}
 		// As we're switching profiles, we need to reset the TKA to nil.
-		b.tka = nil
+		b.setTKALocked(nil)
 	}
 	root := b.TailscaleVarRoot()
 	if root == "" {
-		b.tka = nil
+		b.setTKALocked(nil)
 		b.logf("cannot fetch existing TKA state; no state directory for network-lock")
 		return nil
 	}
 
-	chonkDir := b.chonkPathLocked()
-	if _, err := os.Stat(chonkDir); err == nil {
-		// The directory exists, which means network-lock has been initialized.
-		storage, err := tka.ChonkDir(chonkDir)
-		if err != nil {
-			return fmt.Errorf("opening tailchonk: %v", err)
+	storage, err := openChonk(b.chonkPathLocked(), false)
+	if err != nil {
+		return fmt.Errorf("opening tailchonk: %v", err)
+	}
+	if storage == nil {
+		// Network-lock hasn't been initialized.
+		return nil
+	}
+	authority, err := tka.Open(storage)
+	if err != nil {
+		closeChonk(storage)
+		return fmt.Errorf("initializing tka: %v", err)
+	}
+
+	if err := authority.Compact(storage, tkaCompactionDefaults); err != nil {
+		b.logf("tka compaction failed: %v", err)
+	}
+
+	b.setTKALocked(&tkaState{
+		profile:   cp.ID(),
+		authority: authority,
+		storage:   storage,
+	})
+	b.logf("tka initialized at head %x", authority.Head())
+	return nil
+}
+
+// HookOpenTKADatabase is a hook for feature/tkabolt to open the TKA
+// storage of a profile from a database beside dir, the directory it's
+// otherwise kept in, as described by openChonk. It returns nil, nil if
+// the storage isn't kept in a database.
+var HookOpenTKADatabase feature.Hook[func(dir string, create bool) (tka.CompactableChonk, error)]
+
+// openChonk opens the TKA storage of a profile, which is kept in dir or,
+// if feature/tkabolt is linked in, possibly in a database beside dir. If
+// the storage doesn't exist, openChonk returns nil, unless create is set.
+func openChonk(dir string, create bool) (tka.CompactableChonk, error) {
+	if f, ok := HookOpenTKADatabase.GetOk(); ok {
+		if storage, err := f(dir, create); storage != nil || err != nil {
+			return storage, err
 		}
-		authority, err := tka.Open(storage)
-		if err != nil {
-			return fmt.Errorf("initializing tka: %v", err)
+	}
+	if !create {
+		if _, err := os.Stat(dir); err != nil {
+			return nil, nil
 		}
+	}
+	storage, err := tka.ChonkDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	return storage, nil
+}
 
-		if err := authority.Compact(storage, tkaCompactionDefaults); err != nil {
-			b.logf("tka compaction failed: %v", err)
-		}
+// closeChonk releases storage, if it holds resources such as an open
+// database.
+func closeChonk(storage tka.CompactableChonk) error {
+	if c, ok := storage.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
 
-		b.tka = &tkaState{
-			profile:   cp.ID(),
-			authority: authority,
-			storage:   storage,
+// setTKALocked replaces the current TKA state with st, which may be nil,
+// releasing the storage of the previous state and scheduling background
+// compaction of the new one.
+//
+// b.mu must be held.
+func (b *LocalBackend) setTKALocked(st *tkaState) {
+	if old := b.tka; old != nil && old != st {
+		if old.compactTimer != nil {
+			old.compactTimer.Stop()
+		}
+		if err := closeChonk(old.storage); err != nil {
+			b.logf("closing tailchonk: %v", err)
 		}
-		b.logf("tka initialized at head %x", authority.Head())
 	}
+	b.tka = st
+	if st != nil && st.compactTimer == nil && tkaCompactionDefaults.Interval > 0 {
+		st.compactTimer = b.clock.AfterFunc(tkaCompactionDefaults.Interval, func() {
+			go b.compactTKA(st)
+		})
+	}
+}
 
-	return nil
+// compactTKA compacts the storage of st, if it's still the current TKA
+// state, and reschedules itself. It's run by st.compactTimer.
+func (b *LocalBackend) compactTKA(st *tkaState) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.tka != st {
+		return
+	}
+	if err := st.authority.Compact(st.storage, tkaCompactionDefaults); err != nil {
+		b.logf("tka background compaction failed: %v", err)
+	}
+	st.compactTimer.Reset(tkaCompactionDefaults.Interval)
 }
 
 // noNetworkLockStateDirWarnable is a Warnable to warn the user that Tailnet Lock data
@@ -451,7 +530,7 @@ func (b *LocalBackend) tkaApplyDisablementLocked(secret []byte) error {
 		if err := b.tka.storage.RemoveAll(); err != nil {
 			return err
 		}
-		b.tka = nil
+		b.setTKALocked(nil)
 		return nil
 	}
 	return errors.New("incorrect disablement secret")
@@ -495,8 +574,7 @@ func (b *LocalBackend) tkaBootstrapFromGenesisLocked(g tkatype.MarshaledAUM, per
 		b.logf("network-lock using in-memory storage; no state directory")
 		storage = tka.ChonkMem()
 	} else {
-		chonkDir := b.chonkPathLocked()
-		chonk, err := tka.ChonkDir(chonkDir)
+		chonk, err := openChonk(b.chonkPathLocked(), true)
 		if err != nil {
 			return fmt.Errorf("chonk: %v", err)
 		}
@@ -504,14 +582,15 @@ func (b *LocalBackend) tkaBootstrapFromGenesisLocked(g tkatype.MarshaledAUM, per
 	}
 	authority, err := tka.Bootstrap(storage, genesis)
 	if err != nil {
+		closeChonk(storage)
 		return fmt.Errorf("tka bootstrap: %v", err)
 	}
 
-	b.tka = &tkaState{
+	b.setTKALocked(&tkaState{
 		profile:   b.pm.CurrentProfile().ID(),
 		authority: authority,
 		storage:   storage,
-	}
+	})
 	return nil
 }
 
@@ -748,7 +827,7 @@ func (b *LocalBackend) NetworkLockForceLocalDisable() error {
 	if err := b.tka.storage.RemoveAll(); err != nil {
 		return fmt.Errorf("deleting TKA state: %w", err)
 	}
-	b.tka = nil
+	b.setTKALocked(nil)
 	return nil
 }
 
@@ -774,20 +853,10 @@ func (b *LocalBackend) NetworkLockSign(nodeKey key.NodePublic, rotationPublic []
 			return key.NodePublic{}, tka.NodeKeySignature{}, errors.New(tsconst.TailnetLockNotTrustedMsg)
 		}
 
-		p, err := nodeKey.MarshalBinary()
+		sig, err := tka.SignNodeKey(nlPriv, nodeKey, rotationPublic)
 		if err != nil {
 			return key.NodePublic{}, tka.NodeKeySignature{}, err
 		}
-		sig := tka.NodeKeySignature{
-			SigKind:        tka.SigDirect,
-			KeyID:          nlPriv.KeyID(),
-			Pubkey:         p,
-			WrappingPubkey: rotationPublic,
-		}
-		sig.Signature, err = nlPriv.SignNKS(sig.SigHash())
-		if err != nil {
-			return key.NodePublic{}, tka.NodeKeySignature{}, fmt.Errorf("signature failed: %w", err)
-		}
 
 		return b.pm.CurrentPrefs().Persist().PublicNodeKey(), sig, nil
 	}(nodeKey, rotationPublic)
@@ -802,6 +871,80 @@ func (b *LocalBackend) NetworkLockSign(nodeKey key.NodePublic, rotationPublic []
 	return nil
 }
 
+// NetworkLockSubmitSignature submits a node-key signature made elsewhere,
+// such as by an external signer, to the control plane. The signature must
+// be by a trusted key.
+func (b *LocalBackend) NetworkLockSubmitSignature(sig tka.NodeKeySignature) error {
+	var nodeKey key.NodePublic
+	if err := nodeKey.UnmarshalBinary(sig.Pubkey); err != nil {
+		return fmt.Errorf("decoding signed node-key: %w", err)
+	}
+	ourNodeKey, err := func() (key.NodePublic, error) {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		if b.tka == nil {
+			return key.NodePublic{}, errNetworkLockNotActive
+		}
+		if err := b.tka.authority.NodeKeyAuthorized(nodeKey, sig.Serialize()); err != nil {
+			return key.NodePublic{}, fmt.Errorf("signature not valid: %w", err)
+		}
+		if p := b.pm.CurrentPrefs(); p.Valid() && p.Persist().Valid() && !p.Persist().PrivateNodeKey().IsZero() {
+			return p.Persist().PublicNodeKey(), nil
+		}
+		return key.NodePublic{}, errors.New("no node-key: is tailscale logged in?")
+	}()
+	if err != nil {
+		return err
+	}
+
+	b.logf("Submitting network-lock signature for %v to control plane", nodeKey)
+	_, err = b.tkaSubmitSignature(ourNodeKey, sig.Serialize())
+	return err
+}
+
+// NetworkLockGenerateModify returns the unsigned AUMs that add and/or
+// remove keys in the tailnet's key authority, for signing elsewhere with
+// [tka.SignUpdates] and then submitting with NetworkLockSubmitModify.
+func (b *LocalBackend) NetworkLockGenerateModify(addKeys, removeKeys []tka.Key) ([]tka.AUM, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.tka == nil {
+		return nil, errNetworkLockNotActive
+	}
+	return b.tkaBuildModifyLocked(nil, addKeys, removeKeys)
+}
+
+// NetworkLockSubmitModify submits AUMs from NetworkLockGenerateModify, once
+// signed, to the control plane.
+func (b *LocalBackend) NetworkLockSubmitModify(aums []tka.AUM) (err error) {
+	defer func() {
+		if err != nil {
+			err = fmt.Errorf("modify network-lock keys: %w", err)
+		}
+	}()
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var ourNodeKey key.NodePublic
+	if p := b.pm.CurrentPrefs(); p.Valid() && p.Persist().Valid() && !p.Persist().PrivateNodeKey().IsZero() {
+		ourNodeKey = p.Persist().PublicNodeKey()
+	}
+	if ourNodeKey.IsZero() {
+		return errors.New("no node-key: is tailscale logged in?")
+	}
+	if b.tka == nil {
+		return errNetworkLockNotActive
+	}
+	if len(aums) == 0 {
+		return nil
+	}
+	if parent, _ := aums[0].Parent(); parent != b.tka.authority.Head() {
+		return fmt.Errorf("updates no longer apply to head: based on %x but head is %x", parent, b.tka.authority.Head())
+	}
+	return b.tkaSendModifyLocked(ourNodeKey, aums)
+}
+
 // NetworkLockModify adds and/or removes keys in the tailnet's key authority.
 func (b *LocalBackend) NetworkLockModify(addKeys, removeKeys []tka.Key) (err error) {
 	defer func() {
@@ -835,32 +978,47 @@ func (b *LocalBackend) NetworkLockModify(addKeys, removeKeys []tka.Key) (err err
 		return errors.New("this node does not have a trusted tailnet lock key")
 	}
 
-	updater := b.tka.authority.NewUpdater(nlPriv)
+	aums, err := b.tkaBuildModifyLocked(nlPriv, addKeys, removeKeys)
+	if err != nil {
+		return err
+	}
+	if len(aums) == 0 {
+		return nil
+	}
+	return b.tkaSendModifyLocked(ourNodeKey, aums)
+}
+
+// tkaBuildModifyLocked returns the AUMs, signed by signer if non-nil, that
+// add and/or remove keys in the tailnet's key authority.
+//
+// b.mu must be held, and b.tka must be non-nil.
+func (b *LocalBackend) tkaBuildModifyLocked(signer tka.Signer, addKeys, removeKeys []tka.Key) ([]tka.AUM, error) {
+	updater := b.tka.authority.NewUpdater(signer)
 
 	for _, addKey := range addKeys {
 		if err := updater.AddKey(addKey); err != nil {
-			return err
+			return nil, err
 		}
 	}
 	for _, removeKey := range removeKeys {
 		keyID, err := removeKey.ID()
 		if err != nil {
-			return err
+			return nil, err
 		}
 		if err := updater.RemoveKey(keyID); err != nil {
-			return err
+			return nil, err
 		}
 	}
 
-	aums, err := updater.Finalize(b.tka.storage)
-	if err != nil {
-		return err
-	}
-
-	if len(aums) == 0 {
-		return nil
-	}
+	return updater.Finalize(b.tka.storage)
+}
 
+// tkaSendModifyLocked sends aums, which must apply to the current head, to
+// the control plane and checks that it accepted them.
+//
+// b.mu must be held, and b.tka must be non-nil. It is released while
+// communicating with the control plane.
+func (b *LocalBackend) tkaSendModifyLocked(ourNodeKey key.NodePublic, aums []tka.AUM) error {
 	head := b.tka.authority.Head()
 	b.mu.Unlock()
 	resp, err := b.tkaDoSyncSend(ourNodeKey, head, aums, true)
@@ -914,6 +1072,17 @@ func (b *LocalBackend) NetworkLockDisable(secret []byte) error {
 	return err
 }
 
+// NetworkLockExport returns an export of the active chain of AUMs, for
+// auditing.
+func (b *LocalBackend) NetworkLockExport() (*tka.ChainExport, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.tka == nil {
+		return nil, errNetworkLockNotActive
+	}
+	return tka.ExportChain(b.tka.storage, b.tka.authority.Head())
+}
+
 // NetworkLockLog returns the changelog of TKA state up to maxEntries in size.
 func (b *LocalBackend) NetworkLockLog(maxEntries int) ([]ipnstate.NetworkLockUpdate, error) {
 	b.mu.Lock()
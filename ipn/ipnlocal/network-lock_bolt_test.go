@@ -0,0 +1,124 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build !ts_omit_tailnetlock && !plan9 && !js && !wasip1
+
+package ipnlocal
+
+import (
+	"bytes"
+	"os"
+	"testing"
+
+	"tailscale.com/health"
+	"tailscale.com/ipn"
+	"tailscale.com/ipn/store/mem"
+	"tailscale.com/tailcfg"
+	"tailscale.com/tka"
+	"tailscale.com/tka/boltchonk"
+	"tailscale.com/tstest"
+	"tailscale.com/types/key"
+	"tailscale.com/types/persist"
+	"tailscale.com/util/eventbus/eventbustest"
+	"tailscale.com/util/must"
+)
+
+func TestTKABoltStorageMigration(t *testing.T) {
+	// Install the hook as feature/tkabolt does, which can't be imported
+	// here, with migrate in place of its knob.
+	var migrate bool
+	defer HookOpenTKADatabase.SetForTest(func(dir string, create bool) (tka.CompactableChonk, error) {
+		c, err := boltchonk.OpenDir(dir, create, migrate)
+		if c == nil {
+			return nil, err
+		}
+		return c, err
+	})()
+
+	nlPriv := key.NewNLPrivate()
+	pm := must.Get(newProfileManager(new(mem.Store), t.Logf, health.NewTracker(eventbustest.NewBus(t))))
+	must.Do(pm.SetPrefs((&ipn.Prefs{
+		Persist: &persist.Persist{
+			NodeID:         "n1",
+			PrivateNodeKey: key.NewNode(),
+			NetworkLockKey: nlPriv,
+			UserProfile:    tailcfg.UserProfile{ID: 1, LoginName: "user@example.com"},
+		},
+	}).View(), ipn.NetworkProfile{}))
+	if pm.CurrentProfile().ID() == "" {
+		t.Fatal("profile has no ID")
+	}
+
+	b := &LocalBackend{
+		clock:   tstest.NewClock(tstest.ClockOpts{}),
+		varRoot: t.TempDir(),
+		logf:    t.Logf,
+		pm:      pm,
+		store:   pm.Store(),
+	}
+	tkaPath := b.chonkPathLocked()
+	dbPath := tkaPath + ".db"
+
+	// Seed state in the directory format.
+	chonk := must.Get(tka.ChonkDir(tkaPath))
+	authority, _, err := tka.Create(chonk, tka.State{
+		Keys:               []tka.Key{{Kind: tka.Key25519, Public: nlPriv.Public().Verifier(), Votes: 2}},
+		DisablementSecrets: [][]byte{tka.DisablementKDF(bytes.Repeat([]byte{0xa5}, 32))},
+	}, nlPriv)
+	if err != nil {
+		t.Fatalf("tka.Create() failed: %v", err)
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	// With migrate unset, the directory is used as before.
+	if err := b.initTKALocked(); err != nil {
+		t.Fatalf("initTKALocked() failed: %v", err)
+	}
+	if _, ok := b.tka.storage.(*tka.FS); !ok {
+		t.Fatalf("storage is %T, want *tka.FS", b.tka.storage)
+	}
+	b.setTKALocked(nil)
+
+	migrate = true
+	if err := b.initTKALocked(); err != nil {
+		t.Fatalf("initTKALocked() failed: %v", err)
+	}
+	if _, ok := b.tka.storage.(*boltchonk.Chonk); !ok {
+		t.Fatalf("storage is %T, want *boltchonk.Chonk", b.tka.storage)
+	}
+	if got, want := b.tka.authority.Head(), authority.Head(); got != want {
+		t.Errorf("head after migration = %v, want %v", got, want)
+	}
+	if _, err := os.Stat(tkaPath); !os.IsNotExist(err) {
+		t.Errorf("directory still exists after migration: %v", err)
+	}
+
+	// Once migrated, the database is used even with migrate unset, and can
+	// be reopened after the previous state is released.
+	b.setTKALocked(nil)
+	migrate = false
+	if err := b.initTKALocked(); err != nil {
+		t.Fatalf("initTKALocked() failed: %v", err)
+	}
+	if got, want := b.tka.authority.Head(), authority.Head(); got != want {
+		t.Errorf("head after reopening = %v, want %v", got, want)
+	}
+
+	// Removing the state deletes the database, so TKA is no longer
+	// initialized.
+	if err := b.tka.storage.RemoveAll(); err != nil {
+		t.Fatal(err)
+	}
+	b.setTKALocked(nil)
+	if _, err := os.Stat(dbPath); !os.IsNotExist(err) {
+		t.Errorf("database still exists after RemoveAll: %v", err)
+	}
+	if err := b.initTKALocked(); err != nil {
+		t.Fatalf("initTKALocked() failed: %v", err)
+	}
+	if b.tka != nil {
+		t.Errorf("TKA initialized after RemoveAll")
+	}
+}
@@ -153,6 +153,7 @@ func TestTKAEnablementFlow(t *testing.T) {
 	cc := fakeControlClient(t, client)
 	pm := setupProfileManager(t, nodePriv, nlPriv)
 	b := LocalBackend{
+		clock:          tstest.NewClock(tstest.ClockOpts{}),
 		capTailnetLock: true,
 		varRoot:        temp,
 		cc:             cc,
@@ -240,6 +241,7 @@ func TestTKADisablementFlow(t *testing.T) {
 
 	cc := fakeControlClient(t, client)
 	b := LocalBackend{
+		clock:   tstest.NewClock(tstest.ClockOpts{}),
 		varRoot: temp,
 		cc:      cc,
 		ccAuto:  cc,
@@ -424,6 +426,7 @@ type tkaSyncScenario struct {
 			// Setup the client.
 			cc := fakeControlClient(t, client)
 			b := LocalBackend{
+				clock:   tstest.NewClock(tstest.ClockOpts{}),
 				varRoot: temp,
 				cc:      cc,
 				ccAuto:  cc,
@@ -541,6 +544,7 @@ func TestTKASyncTriggersCompact(t *testing.T) {
 	// Setup the client.
 	cc := fakeControlClient(t, client)
 	b := LocalBackend{
+		clock:  tstest.NewClock(tstest.ClockOpts{}),
 		cc:     cc,
 		ccAuto: cc,
 		logf:   t.Logf,
@@ -616,8 +620,9 @@ func TestTKAFilterNetmap(t *testing.T) {
 	}
 
 	b := &LocalBackend{
-		logf: t.Logf,
-		tka:  &tkaState{authority: authority},
+		clock: tstest.NewClock(tstest.ClockOpts{}),
+		logf:  t.Logf,
+		tka:   &tkaState{authority: authority},
 	}
 
 	n1, n2, n3, n4, n5 := key.NewNode(), key.NewNode(), key.NewNode(), key.NewNode(), key.NewNode()
@@ -818,6 +823,7 @@ func TestTKADisable(t *testing.T) {
 
 	cc := fakeControlClient(t, client)
 	b := LocalBackend{
+		clock:   tstest.NewClock(tstest.ClockOpts{}),
 		varRoot: temp,
 		cc:      cc,
 		ccAuto:  cc,
@@ -883,6 +889,7 @@ func TestTKASign(t *testing.T) {
 	defer ts.Close()
 	cc := fakeControlClient(t, client)
 	b := LocalBackend{
+		clock:   tstest.NewClock(tstest.ClockOpts{}),
 		varRoot: temp,
 		cc:      cc,
 		ccAuto:  cc,
@@ -900,6 +907,81 @@ func TestTKASign(t *testing.T) {
 	}
 }
 
+func TestTKASubmitSignature(t *testing.T) {
+	nodePriv := key.NewNode()
+	toSign := key.NewNode()
+	nlPriv := key.NewNLPrivate()
+	offlinePriv := key.NewNLPrivate() // held by an external signer
+	untrustedPriv := key.NewNLPrivate()
+
+	pm := setupProfileManager(t, nodePriv, nlPriv)
+
+	disablementSecret := bytes.Repeat([]byte{0xa5}, 32)
+	temp := t.TempDir()
+	tkaPath := filepath.Join(temp, "tka-profile", string(pm.CurrentProfile().ID()))
+	os.Mkdir(tkaPath, 0755)
+	chonk, err := tka.ChonkDir(tkaPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	authority, _, err := tka.Create(chonk, tka.State{
+		Keys: []tka.Key{
+			{Kind: tka.Key25519, Public: nlPriv.Public().Verifier(), Votes: 2},
+			{Kind: tka.Key25519, Public: offlinePriv.Public().Verifier(), Votes: 1},
+		},
+		DisablementSecrets: [][]byte{tka.DisablementKDF(disablementSecret)},
+	}, nlPriv)
+	if err != nil {
+		t.Fatalf("tka.Create() failed: %v", err)
+	}
+
+	ts, client := fakeNoiseServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer r.Body.Close()
+		switch r.URL.Path {
+		case "/machine/tka/sign":
+			_, _, err := tkatest.HandleTKASign(w, r, authority)
+			if err != nil {
+				t.Errorf("HandleTKASign: %v", err)
+			}
+
+		default:
+			t.Errorf("unhandled endpoint path: %v", r.URL.Path)
+			w.WriteHeader(404)
+		}
+	}))
+	defer ts.Close()
+	cc := fakeControlClient(t, client)
+	b := LocalBackend{
+		clock:   tstest.NewClock(tstest.ClockOpts{}),
+		varRoot: temp,
+		cc:      cc,
+		ccAuto:  cc,
+		logf:    t.Logf,
+		tka: &tkaState{
+			authority: authority,
+			storage:   chonk,
+		},
+		pm:    pm,
+		store: pm.Store(),
+	}
+
+	sig, err := tka.SignNodeKey(offlinePriv, toSign.Public(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := b.NetworkLockSubmitSignature(sig); err != nil {
+		t.Errorf("NetworkLockSubmitSignature() failed: %v", err)
+	}
+
+	sig, err = tka.SignNodeKey(untrustedPriv, toSign.Public(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := b.NetworkLockSubmitSignature(sig); err == nil {
+		t.Error("NetworkLockSubmitSignature() succeeded for an untrusted key")
+	}
+}
+
 func TestTKAForceDisable(t *testing.T) {
 	nodePriv := key.NewNode()
 
@@ -1079,6 +1161,7 @@ func() *tka.NodeKeySignature {
 			defer ts.Close()
 			cc := fakeControlClient(t, client)
 			b := LocalBackend{
+				clock:   tstest.NewClock(tstest.ClockOpts{}),
 				varRoot: temp,
 				cc:      cc,
 				ccAuto:  cc,
@@ -1164,6 +1247,7 @@ func TestTKARecoverCompromisedKeyFlow(t *testing.T) {
 	defer ts.Close()
 	cc := fakeControlClient(t, client)
 	b := LocalBackend{
+		clock:   tstest.NewClock(tstest.ClockOpts{}),
 		varRoot: temp,
 		cc:      cc,
 		ccAuto:  cc,
@@ -1185,6 +1269,7 @@ func TestTKARecoverCompromisedKeyFlow(t *testing.T) {
 	{
 		pm := setupProfileManager(t, nodePriv, cosignPriv)
 		b := LocalBackend{
+			clock:   tstest.NewClock(tstest.ClockOpts{}),
 			varRoot: temp,
 			logf:    t.Logf,
 			tka: &tkaState{
@@ -1295,3 +1380,73 @@ type addDetails struct {
 		})
 	}
 }
+
+func TestTKABackgroundCompaction(t *testing.T) {
+	nodePriv := key.NewNode()
+	nlPriv := key.NewNLPrivate()
+	pm := setupProfileManager(t, nodePriv, nlPriv)
+
+	// Backdate the AUMs so that they're old enough to be compacted.
+	storageClock := tstest.NewClock(tstest.ClockOpts{})
+	storageClock.Advance(-30 * 24 * time.Hour)
+	storage := tka.ChonkMem()
+	storage.SetClock(storageClock)
+
+	someKey := tka.Key{Kind: tka.Key25519, Public: key.NewNLPrivate().Public().Verifier(), Votes: 1}
+	authority, _, err := tka.Create(storage, tka.State{
+		Keys:               []tka.Key{{Kind: tka.Key25519, Public: nlPriv.Public().Verifier(), Votes: 2}, someKey},
+		DisablementSecrets: [][]byte{tka.DisablementKDF(bytes.Repeat([]byte{0xa5}, 32))},
+	}, nlPriv)
+	if err != nil {
+		t.Fatalf("tka.Create() failed: %v", err)
+	}
+	for range 50 {
+		upd := authority.NewUpdater(nlPriv)
+		if err := upd.RemoveKey(someKey.MustID()); err != nil {
+			t.Fatalf("RemoveKey: %v", err)
+		}
+		if err := upd.AddKey(someKey); err != nil {
+			t.Fatalf("AddKey: %v", err)
+		}
+		aums, err := upd.Finalize(storage)
+		if err != nil {
+			t.Fatalf("Finalize: %v", err)
+		}
+		if err := authority.Inform(storage, aums); err != nil {
+			t.Fatalf("Inform() failed: %v", err)
+		}
+	}
+	before := must.Get(storage.AllAUMs())
+
+	clock := tstest.NewClock(tstest.ClockOpts{})
+	b := &LocalBackend{
+		clock: clock,
+		logf:  t.Logf,
+		pm:    pm,
+		store: pm.Store(),
+	}
+	b.mu.Lock()
+	b.setTKALocked(&tkaState{
+		profile:   pm.CurrentProfile().ID(),
+		authority: authority,
+		storage:   storage,
+	})
+	b.mu.Unlock()
+
+	clock.Advance(tkaCompactionDefaults.Interval - time.Minute)
+	if got := must.Get(storage.AllAUMs()); len(got) != len(before) {
+		t.Fatalf("compacted before the interval elapsed: %d AUMs, want %d", len(got), len(before))
+	}
+	clock.Advance(time.Minute)
+	err = tstest.WaitFor(10*time.Second, func() error {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		if after := must.Get(storage.AllAUMs()); len(after) >= len(before) {
+			return fmt.Errorf("not compacted in the background: %d AUMs, had %d", len(after), len(before))
+		}
+		return nil
+	})
+	if err != nil {
+		t.Error(err)
+	}
+}
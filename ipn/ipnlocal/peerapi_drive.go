@@ -85,8 +85,12 @@ func handleServeDrive(hi PeerAPIHandler, w http.ResponseWriter, r *http.Request)
 		}
 	}()
 
+	principal := drive.Principal{
+		LoginName: h.peerUser.LoginName,
+		Tags:      h.peerNode.Tags().AsSlice(),
+	}
 	r.URL.Path = strings.TrimPrefix(r.URL.Path, taildrivePrefix)
-	fs.ServeHTTPWithPerms(p, wr, r)
+	fs.ServeHTTPWithPerms(p, principal, wr, r)
 }
 
 // parseDriveFileExtensionForLog parses the file extension, if available.
@@ -8,6 +8,7 @@
 package ipnlocal
 
 import (
+	"cmp"
 	"context"
 	"crypto/sha256"
 	"crypto/tls"
@@ -17,6 +18,7 @@
 	"fmt"
 	"io"
 	"maps"
+	"math"
 	"mime"
 	"net"
 	"net/http"
@@ -40,13 +42,16 @@
 	"tailscale.com/net/netutil"
 	"tailscale.com/syncs"
 	"tailscale.com/tailcfg"
+	"tailscale.com/tstime/rate"
 	"tailscale.com/types/lazy"
 	"tailscale.com/types/logger"
 	"tailscale.com/types/views"
 	"tailscale.com/util/backoff"
 	"tailscale.com/util/clientmetric"
 	"tailscale.com/util/ctxkey"
+	"tailscale.com/util/lru"
 	"tailscale.com/util/mak"
+	"tailscale.com/util/set"
 	"tailscale.com/util/slicesx"
 	"tailscale.com/version"
 )
@@ -92,6 +97,9 @@ type serveHTTPContext struct {
 	Funnel *funnelFlow
 	// AppCapabilities lists all PeerCapabilities that should be forwarded by serve
 	AppCapabilities views.Slice[tailcfg.PeerCapability]
+	// Handler is the proxy handler serving the request, if any. It carries
+	// the path rewrite and header settings to apply when proxying.
+	Handler ipn.HTTPHandlerView
 }
 
 // funnelFlow represents a funneled connection initiated via IngressPeer
@@ -588,16 +596,15 @@ func (b *LocalBackend) tcpHandlerForVIPService(dstAddr, srcAddr netip.AddrPort)
 		}
 	}
 
-	if backDst := tcph.TCPForward(); backDst != "" {
+	if tcph.TCPForward() != "" {
 		return func(conn net.Conn) error {
 			defer conn.Close()
-			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-			backConn, err := b.dialer.SystemDial(ctx, "tcp", backDst)
-			cancel()
+			backConn, backDst, done, err := b.dialServeTCPForward(tcph)
 			if err != nil {
 				b.logf("localbackend: failed to TCP proxy port %v (from %v) to %s: %v", dport, srcAddr, backDst, err)
 				return nil
 			}
+			defer done()
 			defer backConn.Close()
 			if sni := tcph.TerminateTLS(); sni != "" {
 				conn = tls.Server(conn, &tls.Config{
@@ -666,16 +673,15 @@ func (b *LocalBackend) tcpHandlerForServe(dport uint16, srcAddr netip.AddrPort,
 		}
 	}
 
-	if backDst := tcph.TCPForward(); backDst != "" {
+	if tcph.TCPForward() != "" {
 		return func(conn net.Conn) error {
 			defer conn.Close()
-			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-			backConn, err := b.dialer.SystemDial(ctx, "tcp", backDst)
-			cancel()
+			backConn, backDst, done, err := b.dialServeTCPForward(tcph)
 			if err != nil {
 				b.logf("localbackend: failed to TCP proxy port %v (from %v) to %s: %v", dport, srcAddr, backDst, err)
 				return nil
 			}
+			defer done()
 			defer backConn.Close()
 			if sni := tcph.TerminateTLS(); sni != "" {
 				conn = tls.Server(conn, &tls.Config{
@@ -925,6 +931,10 @@ func (rp *reverseProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	p := &httputil.ReverseProxy{Rewrite: func(r *httputil.ProxyRequest) {
+		c, _ := serveHTTPContextKey.ValueOk(r.Out.Context())
+		if c != nil && c.Handler.Valid() {
+			rewriteProxyPath(r.Out.URL, c.Handler.StripPrefix(), c.Handler.AddPrefix())
+		}
 		oldOutPath := r.Out.URL.Path
 		r.SetURL(rp.url)
 
@@ -951,7 +961,13 @@ func (rp *reverseProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
-	}} // There is no way to autodetect h2c as per RFC 9113
+		if c != nil && c.Handler.Valid() {
+			renameIdentityHeaders(r.Out.Header, c.Handler.IdentityHeaders())
+			for k, v := range c.Handler.SetRequestHeaders().All() {
+				r.Out.Header.Set(k, v)
+			}
+		}
+	}, ErrorHandler: rp.handleError} // There is no way to autodetect h2c as per RFC 9113
 	// https://datatracker.ietf.org/doc/html/rfc9113#name-starting-http-2.
 	// However, we assume that http:// proxy prefix in combination with the
 	// protoccol being HTTP/2 is sufficient to detect h2c for our needs. Only use this for
@@ -965,6 +981,36 @@ func (rp *reverseProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	p.ServeHTTP(w, r)
 }
 
+// handleError is the httputil.ReverseProxy ErrorHandler. Like the default,
+// it logs err and replies with 502 Bad Gateway. It also records the failure
+// for load balancing, unless the client went away.
+func (rp *reverseProxy) handleError(w http.ResponseWriter, r *http.Request, err error) {
+	if r.Context().Err() == nil {
+		if a, ok := serveProxyAttemptKey.ValueOk(r.Context()); ok {
+			a.err = err
+		}
+	}
+	rp.logf("http: proxy error: %v", err)
+	w.WriteHeader(http.StatusBadGateway)
+}
+
+// dial dials a new connection to the backend, as used for health checks.
+func (rp *reverseProxy) dial(ctx context.Context) (net.Conn, error) {
+	if rp.socketPath != "" {
+		var d net.Dialer
+		return d.DialContext(ctx, "unix", rp.socketPath)
+	}
+	host := rp.url.Host
+	if rp.url.Port() == "" {
+		port := "80"
+		if rp.url.Scheme == "https" {
+			port = "443"
+		}
+		host = net.JoinHostPort(rp.url.Hostname(), port)
+	}
+	return rp.lb.dialer.SystemDial(ctx, "tcp", host)
+}
+
 // getTransport returns the Transport used for regular (non-GRPC) requests
 // to the backend. The Transport gets created lazily, at most once.
 func (rp *reverseProxy) getTransport() *http.Transport {
@@ -1074,6 +1120,74 @@ func (b *LocalBackend) addTailscaleIdentityHeaders(r *httputil.ProxyRequest) {
 	r.Out.Header.Set("Tailscale-Headers-Info", "https://tailscale.com/s/serve-headers")
 }
 
+// tailscaleIdentityHeaders are the identity headers set by
+// addTailscaleIdentityHeaders that may be renamed with
+// HTTPHandler.IdentityHeaders.
+var tailscaleIdentityHeaders = []string{
+	"Tailscale-User-Login",
+	"Tailscale-User-Name",
+	"Tailscale-User-Profile-Pic",
+}
+
+// renameIdentityHeaders moves the Tailscale identity headers in h to the
+// names given in names, which maps default header names to new ones. Any
+// values already present under the new names are removed first, so that
+// clients cannot spoof them. Keys in names that are not identity headers
+// are ignored.
+func renameIdentityHeaders(h http.Header, names views.Map[string, string]) {
+	if names.Len() == 0 {
+		return
+	}
+	for _, from := range tailscaleIdentityHeaders {
+		to, ok := names.GetOk(from)
+		if !ok || to == "" {
+			continue
+		}
+		v := h.Get(from)
+		h.Del(from)
+		h.Del(to)
+		if v != "" {
+			h.Set(to, v)
+		}
+	}
+}
+
+// rewriteProxyPath removes the path prefix strip from u, if present, and
+// then prepends add. Prefixes only match whole path segments: stripping
+// "/v1" rewrites "/v1/foo" to "/foo" and "/v1" to "", but leaves "/v10"
+// unchanged.
+func rewriteProxyPath(u *url.URL, strip, add string) {
+	strip = strings.TrimSuffix(strip, "/")
+	add = strings.TrimSuffix(add, "/")
+	if strip != "" {
+		if rest, ok := cutPathPrefix(u.Path, strip); ok {
+			u.Path = rest
+			if u.RawPath != "" {
+				u.RawPath, _ = cutPathPrefix(u.RawPath, strip)
+			}
+		}
+	}
+	if add != "" {
+		if !strings.HasPrefix(add, "/") {
+			add = "/" + add
+		}
+		u.Path = add + u.Path
+		if u.RawPath != "" {
+			u.RawPath = add + u.RawPath
+		}
+	}
+}
+
+// cutPathPrefix returns p without the path prefix prefix and reports whether
+// p started with it at a segment boundary.
+func cutPathPrefix(p, prefix string) (rest string, ok bool) {
+	rest, ok = strings.CutPrefix(p, prefix)
+	if !ok || (rest != "" && rest[0] != '/') {
+		return p, false
+	}
+	return rest, true
+}
+
 // encTailscaleHeaderValue cleans or encodes as necessary v, to be suitable in
 // an HTTP header value. See
 // https://github.com/tailscale/tailscale/issues/11603.
@@ -1144,6 +1258,13 @@ func (b *LocalBackend) serveWebHandler(w http.ResponseWriter, r *http.Request) {
 		http.NotFound(w, r)
 		return
 	}
+	if !b.serveRateLimiters.allow(r, h, mountPoint) {
+		http.Error(w, "too many requests", http.StatusTooManyRequests)
+		return
+	}
+	if hdrs := h.SetResponseHeaders(); hdrs.Len() > 0 {
+		w = &setHeadersResponseWriter{ResponseWriter: w, headers: hdrs}
+	}
 	if s := h.Text(); s != "" {
 		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
 		io.WriteString(w, s)
@@ -1161,6 +1282,24 @@ func (b *LocalBackend) serveWebHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	if v := h.Proxy(); v != "" {
+		if h.Backends().Len() > 0 {
+			targets := serveBackendTargets(v, h.Backends())
+			pv, ok := b.serveBackendPools.Load(serveBackendPoolKey("http", targets, h.LoadBalancer()))
+			if !ok {
+				http.Error(w, "unknown proxy destination", http.StatusInternalServerError)
+				return
+			}
+			pool := pv.(*serveBackendPool)
+			be := pool.pick(nil)
+			v = be.target
+			a := new(serveProxyAttempt)
+			r = r.WithContext(serveProxyAttemptKey.WithValue(r.Context(), a))
+			be.active.Add(1)
+			defer func() {
+				be.active.Add(-1)
+				pool.report(be, a.err)
+			}()
+		}
 		p, ok := b.serveProxyHandlers.Load(v)
 		if !ok {
 			http.Error(w, "unknown proxy destination", http.StatusInternalServerError)
@@ -1172,6 +1311,7 @@ func (b *LocalBackend) serveWebHandler(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 		c.AppCapabilities = h.AcceptAppCaps()
+		c.Handler = h
 		h := p.(http.Handler)
 		// Trim the mount point from the URL path before proxying. (#6571)
 		if r.URL.Path != "/" {
@@ -1254,6 +1394,104 @@ func (w *fixLocationHeaderResponseWriter) Write(p []byte) (int, error) {
 	return w.ResponseWriter.Write(p)
 }
 
+// setHeadersResponseWriter is an http.ResponseWriter wrapper that, upon
+// flushing HTTP headers, sets the static response headers configured in
+// HTTPHandler.SetResponseHeaders.
+type setHeadersResponseWriter struct {
+	http.ResponseWriter
+	headers     views.Map[string, string]
+	wroteHeader bool
+}
+
+func (w *setHeadersResponseWriter) set() {
+	h := w.ResponseWriter.Header()
+	for k, v := range w.headers.All() {
+		h.Set(k, v)
+	}
+}
+
+func (w *setHeadersResponseWriter) WriteHeader(code int) {
+	// Informational (1xx) responses may be followed by the real one, which
+	// is the one that needs the headers.
+	if !w.wroteHeader && code >= 200 {
+		w.set()
+		w.wroteHeader = true
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *setHeadersResponseWriter) Write(p []byte) (int, error) {
+	if !w.wroteHeader {
+		w.set()
+		w.wroteHeader = true
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+// Unwrap returns the underlying ResponseWriter, for use by
+// http.ResponseController (e.g. to flush streamed proxy responses).
+func (w *setHeadersResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// maxServeRateLimiters is the maximum number of per-source rate limiters
+// kept for HTTPHandler.RateLimit. Beyond that, the least recently used
+// sources are forgotten and start over with a full burst.
+const maxServeRateLimiters = 4096
+
+// serveRateLimiters holds the per-source rate limiters for serve
+// HTTPHandlers that have a RateLimit set.
+type serveRateLimiters struct {
+	mu   sync.Mutex
+	lims lru.Cache[serveRateLimitKey, *rate.Limiter] // guarded by mu
+}
+
+// serveRateLimitKey identifies the rate limiter for one source on one
+// HTTPHandler. The limit and burst are part of the key so that changing
+// them in the ServeConfig starts over with new limiters.
+type serveRateLimitKey struct {
+	svc   tailcfg.ServiceName
+	port  uint16
+	mount string
+	src   netip.Addr
+	limit float64
+	burst int
+}
+
+// allow reports whether the request r for the handler h mounted at
+// mountPoint is within h's per-source rate limit, if any.
+func (s *serveRateLimiters) allow(r *http.Request, h ipn.HTTPHandlerView, mountPoint string) bool {
+	limit := h.RateLimit()
+	if limit <= 0 {
+		return true
+	}
+	c, ok := serveHTTPContextKey.ValueOk(r.Context())
+	if !ok {
+		return true
+	}
+	burst := h.RateLimitBurst()
+	if burst <= 0 {
+		burst = max(1, int(math.Ceil(limit)))
+	}
+	k := serveRateLimitKey{
+		svc:   c.ForVIPService,
+		port:  c.DestPort,
+		mount: mountPoint,
+		src:   c.SrcAddr.Addr(),
+		limit: limit,
+		burst: burst,
+	}
+	s.mu.Lock()
+	lim, ok := s.lims.GetOk(k)
+	if !ok {
+		s.lims.MaxEntries = maxServeRateLimiters
+		lim = rate.NewLimiter(rate.Limit(limit), burst)
+		s.lims.Set(k, lim)
+	}
+	s.mu.Unlock()
+	return lim.Allow()
+}
+
 // expandProxyArg returns a URL from s, where s can be of form:
 //
 // * port number ("8080")
@@ -1340,25 +1578,26 @@ func (b *LocalBackend) setServeProxyHandlersLocked() {
 	var backends map[string]bool
 	for _, conf := range b.serveConfig.Webs() {
 		for _, h := range conf.Handlers().All() {
-			backend := h.Proxy()
-			if backend == "" {
+			if h.Proxy() == "" {
 				// Only create proxy handlers for servers with a proxy backend.
 				continue
 			}
-			mak.Set(&backends, backend, true)
-			if _, ok := b.serveProxyHandlers.Load(backend); ok {
-				continue
-			}
+			for _, backend := range serveBackendTargets(h.Proxy(), h.Backends()) {
+				mak.Set(&backends, backend, true)
+				if _, ok := b.serveProxyHandlers.Load(backend); ok {
+					continue
+				}
 
-			b.logf("serve: creating a new proxy handler for %s", backend)
-			p, err := b.proxyHandlerForBackend(backend)
-			if err != nil {
-				// The backend endpoint (h.Proxy) should have been validated by expandProxyTarget
-				// in the CLI, so just log the error here.
-				b.logf("[unexpected] could not create proxy for %v: %s", backend, err)
-				continue
+				b.logf("serve: creating a new proxy handler for %s", backend)
+				p, err := b.proxyHandlerForBackend(backend)
+				if err != nil {
+					// The backend endpoint (h.Proxy) should have been validated by expandProxyTarget
+					// in the CLI, so just log the error here.
+					b.logf("[unexpected] could not create proxy for %v: %s", backend, err)
+					continue
+				}
+				b.serveProxyHandlers.Store(backend, p)
 			}
-			b.serveProxyHandlers.Store(backend, p)
 		}
 	}
 
@@ -1375,6 +1614,326 @@ func (b *LocalBackend) setServeProxyHandlersLocked() {
 	})
 }
 
+// setServeBackendPoolsLocked creates the load balancers for serve handlers
+// with multiple backends, and closes those no longer in the config.
+//
+// It must be called after setServeProxyHandlersLocked, so the proxy handlers
+// of HTTP backends exist.
+func (b *LocalBackend) setServeBackendPoolsLocked() {
+	var want set.Set[string]
+	add := func(kind string, targets []string, lb ipn.LoadBalancer) {
+		key := serveBackendPoolKey(kind, targets, lb)
+		if want.Contains(key) {
+			return
+		}
+		want.Make()
+		want.Add(key)
+		if _, ok := b.serveBackendPools.Load(key); ok {
+			return
+		}
+		b.logf("serve: creating a new load balancer for %s backends %q", kind, targets)
+		check := b.checkServeTCPBackend
+		if kind == "http" {
+			check = func(ctx context.Context, target string) error {
+				return b.checkServeProxyBackend(ctx, target, lb.HealthCheckPath)
+			}
+		}
+		b.serveBackendPools.Store(key, newServeBackendPool(b.ctx, b.logf, targets, lb, check))
+	}
+	addTCP := func(tcph ipn.TCPPortHandlerView) {
+		if tcph.TCPForward() != "" && tcph.Backends().Len() > 0 {
+			add("tcp", serveBackendTargets(tcph.TCPForward(), tcph.Backends()), tcph.LoadBalancer())
+		}
+	}
+	if b.serveConfig.Valid() {
+		for _, conf := range b.serveConfig.Webs() {
+			for _, h := range conf.Handlers().All() {
+				if h.Proxy() != "" && h.Backends().Len() > 0 {
+					add("http", serveBackendTargets(h.Proxy(), h.Backends()), h.LoadBalancer())
+				}
+			}
+		}
+		for _, tcph := range b.serveConfig.TCPs() {
+			addTCP(tcph)
+		}
+		for _, svc := range b.serveConfig.Services().All() {
+			for _, tcph := range svc.TCP().All() {
+				addTCP(tcph)
+			}
+		}
+	}
+
+	b.serveBackendPools.Range(func(key, value any) bool {
+		if !want.Contains(key.(string)) {
+			b.serveBackendPools.Delete(key)
+			value.(*serveBackendPool).close()
+		}
+		return true
+	})
+}
+
+// checkServeProxyBackend actively health checks the HTTP proxy backend
+// target, by requesting path from it or, if path is empty, by connecting to
+// it.
+func (b *LocalBackend) checkServeProxyBackend(ctx context.Context, target, path string) error {
+	v, ok := b.serveProxyHandlers.Load(target)
+	if !ok {
+		return errors.New("no proxy handler")
+	}
+	rp := v.(*reverseProxy)
+	if path == "" {
+		c, err := rp.dial(ctx)
+		if err != nil {
+			return err
+		}
+		return c.Close()
+	}
+	u := *rp.url
+	u.Path = path
+	u.RawPath = ""
+	u.RawQuery = ""
+	req, err := http.NewRequestWithContext(ctx, "GET", u.String(), nil)
+	if err != nil {
+		return err
+	}
+	res, err := rp.getTransport().RoundTrip(req)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	if c := res.StatusCode / 100; c != 2 && c != 3 {
+		return fmt.Errorf("unhealthy status %v", res.Status)
+	}
+	return nil
+}
+
+// checkServeTCPBackend actively health checks the TCP forwarding backend
+// target by connecting to it.
+func (b *LocalBackend) checkServeTCPBackend(ctx context.Context, target string) error {
+	c, err := b.dialer.SystemDial(ctx, "tcp", target)
+	if err != nil {
+		return err
+	}
+	return c.Close()
+}
+
+// dialServeTCPForward dials the destination of the TCP forwarding handler
+// tcph. If tcph has multiple backends, one is picked by its load balancer,
+// and the others are tried in turn if dialing it fails. The returned done
+// func must be called once the forwarded connection is finished.
+func (b *LocalBackend) dialServeTCPForward(tcph ipn.TCPPortHandlerView) (backConn net.Conn, backDst string, done func(), err error) {
+	dial := func(dst string) (net.Conn, error) {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		return b.dialer.SystemDial(ctx, "tcp", dst)
+	}
+	if tcph.Backends().Len() == 0 {
+		backDst = tcph.TCPForward()
+		backConn, err = dial(backDst)
+		return backConn, backDst, func() {}, err
+	}
+
+	targets := serveBackendTargets(tcph.TCPForward(), tcph.Backends())
+	v, ok := b.serveBackendPools.Load(serveBackendPoolKey("tcp", targets, tcph.LoadBalancer()))
+	if !ok {
+		return nil, tcph.TCPForward(), nil, errors.New("no load balancer for backends")
+	}
+	pool := v.(*serveBackendPool)
+	var tried []*serveBackend
+	for {
+		be := pool.pick(tried)
+		if be == nil {
+			return nil, backDst, nil, err
+		}
+		tried = append(tried, be)
+		backDst = be.target
+		be.active.Add(1)
+		backConn, err = dial(backDst)
+		pool.report(be, err)
+		if err == nil {
+			return backConn, backDst, func() { be.active.Add(-1) }, nil
+		}
+		be.active.Add(-1)
+	}
+}
+
+// serveBackendTargets returns the backends of a serve handler: its Proxy
+// or TCPForward destination first, followed by its additional Backends.
+func serveBackendTargets(first string, rest views.Slice[string]) []string {
+	return append([]string{first}, rest.AsSlice()...)
+}
+
+// serveBackendPoolKey returns the serveBackendPools key of the load balancer
+// for the given kind ("http" or "tcp") of backends. Handlers with the same
+// backends and configuration share a load balancer.
+func serveBackendPoolKey(kind string, targets []string, lb ipn.LoadBalancer) string {
+	return fmt.Sprintf("%s %q %+v", kind, targets, lb)
+}
+
+// serveProxyAttemptKey is the context key for the serveProxyAttempt of a
+// request proxied to a load-balanced backend.
+var serveProxyAttemptKey ctxkey.Key[*serveProxyAttempt]
+
+// serveProxyAttempt records the outcome of proxying a request to a
+// load-balanced backend.
+type serveProxyAttempt struct {
+	err error // non-nil if the backend could not be reached
+}
+
+const (
+	defaultServeLBMaxFails      = 3
+	defaultServeLBEjectDuration = 30 * time.Second
+	maxServeLBCheckTimeout      = 5 * time.Second
+)
+
+// serveBackendPool load-balances requests or connections across the backends
+// of a serve handler, and tracks their health.
+type serveBackendPool struct {
+	logf     logger.Logf
+	conf     ipn.LoadBalancer
+	backends []*serveBackend
+	check    func(context.Context, string) error // active health check
+	rr       atomic.Uint32                       // round-robin counter
+
+	ctx    context.Context // canceled by close
+	cancel context.CancelFunc
+}
+
+// serveBackend is a backend of a serveBackendPool.
+type serveBackend struct {
+	target string       // HTTPHandler.Proxy or TCPForward form
+	active atomic.Int32 // in-flight requests or connections
+
+	mu           sync.Mutex
+	fails        int       // consecutive failures; guarded by mu
+	ejectedUntil time.Time // guarded by mu
+	checkFailed  bool      // whether the last active health check failed; guarded by mu
+}
+
+func newServeBackendPool(ctx context.Context, logf logger.Logf, targets []string, conf ipn.LoadBalancer, check func(context.Context, string) error) *serveBackendPool {
+	p := &serveBackendPool{
+		logf:  logf,
+		conf:  conf,
+		check: check,
+	}
+	p.ctx, p.cancel = context.WithCancel(ctx)
+	for _, t := range targets {
+		p.backends = append(p.backends, &serveBackend{target: t})
+	}
+	if conf.HealthCheckInterval.Duration > 0 {
+		go p.runHealthChecks()
+	}
+	return p
+}
+
+// close stops the pool's health checks.
+func (p *serveBackendPool) close() {
+	p.cancel()
+}
+
+// pick returns the backend to use for the next request or connection,
+// ignoring those in skip. It prefers healthy backends, but falls back to
+// unhealthy ones if none are healthy. It returns nil only if every backend
+// is in skip.
+func (p *serveBackendPool) pick(skip []*serveBackend) *serveBackend {
+	now := time.Now()
+	var cands []*serveBackend
+	for _, be := range p.backends {
+		if !slices.Contains(skip, be) && be.healthy(now) {
+			cands = append(cands, be)
+		}
+	}
+	if len(cands) == 0 {
+		for _, be := range p.backends {
+			if !slices.Contains(skip, be) {
+				cands = append(cands, be)
+			}
+		}
+	}
+	if len(cands) == 0 {
+		return nil
+	}
+	start := int((p.rr.Add(1) - 1) % uint32(len(cands)))
+	best := cands[start]
+	if p.conf.Policy == ipn.LoadBalanceLeastConn {
+		for i := 1; i < len(cands); i++ {
+			if be := cands[(start+i)%len(cands)]; be.active.Load() < best.active.Load() {
+				best = be
+			}
+		}
+	}
+	return best
+}
+
+// report records the outcome of a request or connection to be, ejecting it
+// after too many consecutive failures.
+func (p *serveBackendPool) report(be *serveBackend, err error) {
+	be.mu.Lock()
+	defer be.mu.Unlock()
+	if err == nil {
+		be.fails = 0
+		return
+	}
+	be.fails++
+	if be.fails < cmp.Or(p.conf.MaxFails, defaultServeLBMaxFails) {
+		return
+	}
+	d := cmp.Or(p.conf.EjectDuration.Duration, defaultServeLBEjectDuration)
+	p.logf("serve: ejecting backend %s for %v after %d failures: %v", be.target, d, be.fails, err)
+	be.fails = 0
+	be.ejectedUntil = time.Now().Add(d)
+}
+
+// healthy reports whether be is neither ejected nor failing active health
+// checks at time now.
+func (be *serveBackend) healthy(now time.Time) bool {
+	be.mu.Lock()
+	defer be.mu.Unlock()
+	return !be.checkFailed && !now.Before(be.ejectedUntil)
+}
+
+// runHealthChecks actively checks all backends every HealthCheckInterval
+// until the pool is closed.
+func (p *serveBackendPool) runHealthChecks() {
+	t := time.NewTicker(p.conf.HealthCheckInterval.Duration)
+	defer t.Stop()
+	for {
+		p.checkAll()
+		select {
+		case <-p.ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
+
+// checkAll runs an active health check of every backend concurrently.
+func (p *serveBackendPool) checkAll() {
+	timeout := min(p.conf.HealthCheckInterval.Duration, maxServeLBCheckTimeout)
+	var wg sync.WaitGroup
+	for _, be := range p.backends {
+		wg.Go(func() {
+			ctx, cancel := context.WithTimeout(p.ctx, timeout)
+			defer cancel()
+			err := p.check(ctx, be.target)
+			if p.ctx.Err() != nil {
+				return // closed; don't log spurious failures
+			}
+			be.mu.Lock()
+			defer be.mu.Unlock()
+			if failed := err != nil; failed != be.checkFailed {
+				if failed {
+					p.logf("serve: backend %s failed health check: %v", be.target, err)
+				} else {
+					p.logf("serve: backend %s passed health check", be.target)
+				}
+				be.checkFailed = failed
+			}
+		})
+	}
+	wg.Wait()
+}
+
 // VIPServices returns the list of tailnet services that this node
 // is serving as a destination for.
 // The returned memory is owned by the caller.
@@ -1525,6 +2084,7 @@ func serveSetTCPPortsInterceptedFromNetmapAndPrefsLocked(b *LocalBackend, prefs
 			b.updateServeTCPPortNetMapAddrListenersLocked(servePorts)
 		}
 	}
+	b.setServeBackendPoolsLocked()
 
 	b.setVIPServicesTCPPortsInterceptedLocked(vipServicesPorts)
 
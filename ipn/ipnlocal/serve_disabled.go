@@ -26,6 +26,8 @@ func (b *LocalBackend) DeleteForegroundSession(sessionID string) error {
 
 type funnelFlow = struct{}
 
+type serveRateLimiters = struct{}
+
 func (*LocalBackend) hasIngressEnabledLocked() bool         { return false }
 func (*LocalBackend) shouldWireInactiveIngressLocked() bool { return false }
 
@@ -15,6 +15,7 @@
 	"fmt"
 	"io"
 	"mime"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"net/netip"
@@ -23,6 +24,7 @@
 	"path/filepath"
 	"reflect"
 	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -33,12 +35,14 @@
 	"tailscale.com/tailcfg"
 	"tailscale.com/tsd"
 	"tailscale.com/tstest"
+	"tailscale.com/tstime"
 	"tailscale.com/types/logger"
 	"tailscale.com/types/logid"
 	"tailscale.com/types/netmap"
 	"tailscale.com/util/eventbus/eventbustest"
 	"tailscale.com/util/mak"
 	"tailscale.com/util/must"
+	"tailscale.com/util/set"
 	"tailscale.com/util/syspolicy/policyclient"
 	"tailscale.com/wgengine"
 	"tailscale.com/wgengine/filter"
@@ -801,6 +805,374 @@ type headerCheck struct {
 	}
 }
 
+func TestServeHTTPProxyRewrite(t *testing.T) {
+	b := newTestBackend(t)
+	testServ := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Path", r.URL.Path)
+			w.Header().Set("Server", "backend")
+			for key, val := range r.Header {
+				w.Header().Add("Req-"+key, strings.Join(val, ","))
+			}
+		},
+	))
+	defer testServ.Close()
+
+	tests := []struct {
+		name            string
+		handler         ipn.HTTPHandler
+		requestPath     string
+		wantRequestPath string
+		wantHeaders     map[string]string
+	}{
+		{
+			name:            "strip-prefix",
+			handler:         ipn.HTTPHandler{StripPrefix: "/v1"},
+			requestPath:     "/app/v1/foo",
+			wantRequestPath: "/foo",
+		},
+		{
+			name:            "strip-prefix-whole-segment-only",
+			handler:         ipn.HTTPHandler{StripPrefix: "/v1"},
+			requestPath:     "/app/v10/foo",
+			wantRequestPath: "/v10/foo",
+		},
+		{
+			name:            "rewrite-prefix",
+			handler:         ipn.HTTPHandler{StripPrefix: "/v1", AddPrefix: "/api/v2/"},
+			requestPath:     "/app/v1/foo",
+			wantRequestPath: "/api/v2/foo",
+		},
+		{
+			name:            "add-prefix-at-mount-point",
+			handler:         ipn.HTTPHandler{AddPrefix: "/api"},
+			requestPath:     "/app/",
+			wantRequestPath: "/api/",
+		},
+		{
+			name: "static-headers",
+			handler: ipn.HTTPHandler{
+				SetRequestHeaders:  map[string]string{"X-Env": "prod", "X-Client": "overridden"},
+				SetResponseHeaders: map[string]string{"Server": "tailscale", "Strict-Transport-Security": "max-age=300"},
+			},
+			requestPath:     "/app/foo",
+			wantRequestPath: "/foo",
+			wantHeaders: map[string]string{
+				"Req-X-Env":                 "prod",
+				"Req-X-Client":              "overridden",
+				"Server":                    "tailscale",
+				"Strict-Transport-Security": "max-age=300",
+			},
+		},
+		{
+			name: "renamed-identity-headers",
+			handler: ipn.HTTPHandler{
+				IdentityHeaders: map[string]string{
+					"Tailscale-User-Login": "X-Forwarded-User",
+					"X-Not-Identity":       "X-Whatever",
+				},
+			},
+			requestPath:     "/app/foo",
+			wantRequestPath: "/foo",
+			wantHeaders: map[string]string{
+				"Req-X-Forwarded-User":     "someone@example.com",
+				"Req-Tailscale-User-Login": "",
+				"Req-Tailscale-User-Name":  "Some One",
+				"Req-X-Whatever":           "",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := tt.handler
+			h.Proxy = testServ.URL
+			conf := &ipn.ServeConfig{
+				Web: map[ipn.HostPort]*ipn.WebServerConfig{
+					"example.ts.net:443": {Handlers: map[string]*ipn.HTTPHandler{
+						"/app/": &h,
+					}},
+				},
+			}
+			if err := b.SetServeConfig(conf, ""); err != nil {
+				t.Fatal(err)
+			}
+			req := &http.Request{
+				URL:    &url.URL{Path: tt.requestPath},
+				TLS:    &tls.ConnectionState{ServerName: "example.ts.net"},
+				Header: http.Header{"X-Client": {"client"}, "X-Forwarded-User": {"spoofed"}},
+			}
+			req = req.WithContext(serveHTTPContextKey.WithValue(req.Context(), &serveHTTPContext{
+				DestPort: 443,
+				SrcAddr:  netip.MustParseAddrPort("100.150.151.152:1234"),
+			}))
+
+			w := httptest.NewRecorder()
+			b.serveWebHandler(w, req)
+
+			res := w.Result()
+			if got := res.Header.Get("Path"); got != tt.wantRequestPath {
+				t.Errorf("request path = %q; want %q", got, tt.wantRequestPath)
+			}
+			for k, want := range tt.wantHeaders {
+				if got := res.Header.Get(k); got != want {
+					t.Errorf("header %q = %q; want %q", k, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestServeHTTPRateLimit(t *testing.T) {
+	b := newTestBackend(t)
+	conf := &ipn.ServeConfig{
+		Web: map[ipn.HostPort]*ipn.WebServerConfig{
+			"example.ts.net:443": {Handlers: map[string]*ipn.HTTPHandler{
+				"/": {
+					Text:           "hello",
+					RateLimit:      0.001, // effectively no refill during the test
+					RateLimitBurst: 2,
+				},
+			}},
+		},
+	}
+	if err := b.SetServeConfig(conf, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	get := func(src string) int {
+		req := &http.Request{
+			URL: &url.URL{Path: "/"},
+			TLS: &tls.ConnectionState{ServerName: "example.ts.net"},
+		}
+		req = req.WithContext(serveHTTPContextKey.WithValue(req.Context(), &serveHTTPContext{
+			DestPort: 443,
+			SrcAddr:  netip.MustParseAddrPort(src),
+		}))
+		w := httptest.NewRecorder()
+		b.serveWebHandler(w, req)
+		return w.Code
+	}
+
+	for i, want := range []int{200, 200, 429, 429} {
+		// Vary the source port; the limit is per source address.
+		if got := get(fmt.Sprintf("100.150.151.152:%d", 1000+i)); got != want {
+			t.Errorf("request %d from first source: status %d; want %d", i, got, want)
+		}
+	}
+	if got := get("100.150.151.153:1000"); got != 200 {
+		t.Errorf("request from second source: status %d; want 200", got)
+	}
+
+	// Changing the limit starts over.
+	conf.Web["example.ts.net:443"].Handlers["/"].RateLimitBurst = 3
+	if err := b.SetServeConfig(conf, ""); err != nil {
+		t.Fatal(err)
+	}
+	if got := get("100.150.151.152:2000"); got != 200 {
+		t.Errorf("request after config change: status %d; want 200", got)
+	}
+}
+
+func TestServeHTTPLoadBalance(t *testing.T) {
+	b := newTestBackend(t)
+
+	var unhealthy atomic.Bool // whether backend "b" fails health checks
+	newBackend := func(name string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/healthz" && name == "b" && unhealthy.Load() {
+				http.Error(w, "unhealthy", http.StatusServiceUnavailable)
+				return
+			}
+			io.WriteString(w, name)
+		}))
+	}
+	backA, backB, backC := newBackend("a"), newBackend("b"), newBackend("c")
+	defer backA.Close()
+	defer backB.Close()
+
+	conf := &ipn.ServeConfig{
+		Web: map[ipn.HostPort]*ipn.WebServerConfig{
+			"example.ts.net:443": {Handlers: map[string]*ipn.HTTPHandler{
+				"/": {
+					Proxy:    backA.URL,
+					Backends: []string{backB.URL, backC.URL},
+					LoadBalancer: ipn.LoadBalancer{
+						HealthCheckInterval: tstime.GoDuration{Duration: 10 * time.Millisecond},
+						HealthCheckPath:     "/healthz",
+						MaxFails:            1,
+						EjectDuration:       tstime.GoDuration{Duration: time.Hour},
+					},
+				},
+			}},
+		},
+	}
+	if err := b.SetServeConfig(conf, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	get := func() (code int, body string) {
+		req := &http.Request{
+			URL: &url.URL{Path: "/"},
+			TLS: &tls.ConnectionState{ServerName: "example.ts.net"},
+		}
+		req = req.WithContext(serveHTTPContextKey.WithValue(req.Context(), &serveHTTPContext{
+			DestPort: 443,
+			SrcAddr:  netip.MustParseAddrPort("100.150.151.152:1234"),
+		}))
+		w := httptest.NewRecorder()
+		b.serveWebHandler(w, req)
+		return w.Code, w.Body.String()
+	}
+	// backends returns the backends that served n requests, ignoring
+	// failed requests.
+	backends := func(n int) set.Set[string] {
+		got := set.Set[string]{}
+		for range n {
+			if code, body := get(); code == http.StatusOK {
+				got.Add(body)
+			}
+		}
+		return got
+	}
+
+	if got, want := backends(6), set.Of("a", "b", "c"); !got.Equal(want) {
+		t.Fatalf("round-robin served by %v; want %v", got.Slice(), want.Slice())
+	}
+
+	// A backend that can't be reached gets ejected after MaxFails.
+	backC.Close()
+	if got, want := backends(6), set.Of("a", "b"); !got.Equal(want) {
+		t.Fatalf("after closing c, served by %v; want %v", got.Slice(), want.Slice())
+	}
+	for range 4 {
+		if code, body := get(); code != http.StatusOK {
+			t.Fatalf("got %v, %q after c was ejected; want success", code, body)
+		}
+	}
+
+	// A backend that fails active health checks is not used.
+	unhealthy.Store(true)
+	if err := tstest.WaitFor(5*time.Second, func() error {
+		if got := backends(4); !got.Equal(set.Of("a")) {
+			return fmt.Errorf("served by %v; want only a", got.Slice())
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	unhealthy.Store(false)
+	if err := tstest.WaitFor(5*time.Second, func() error {
+		if got, want := backends(4), set.Of("a", "b"); !got.Equal(want) {
+			return fmt.Errorf("served by %v; want %v", got.Slice(), want.Slice())
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Removing the backends closes the load balancer.
+	conf.Web["example.ts.net:443"].Handlers["/"].Backends = nil
+	if err := b.SetServeConfig(conf, ""); err != nil {
+		t.Fatal(err)
+	}
+	n := 0
+	b.serveBackendPools.Range(func(_, _ any) bool { n++; return true })
+	if n != 0 {
+		t.Errorf("%d load balancers remain after removing backends", n)
+	}
+}
+
+func TestServeTCPForwardLoadBalance(t *testing.T) {
+	b := newTestBackend(t)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			c.Close()
+		}
+	}()
+	dead, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	deadAddr := dead.Addr().String()
+	dead.Close()
+
+	conf := &ipn.ServeConfig{
+		TCP: map[uint16]*ipn.TCPPortHandler{
+			5432: {
+				TCPForward:   deadAddr,
+				Backends:     []string{ln.Addr().String()},
+				LoadBalancer: ipn.LoadBalancer{Policy: ipn.LoadBalanceLeastConn},
+			},
+		},
+	}
+	if err := b.SetServeConfig(conf, ""); err != nil {
+		t.Fatal(err)
+	}
+	tcph, ok := b.ServeConfig().FindTCP(5432)
+	if !ok {
+		t.Fatal("no TCP handler")
+	}
+	// Every dial succeeds, failing over from the dead backend until it is
+	// ejected.
+	for i := range 5 {
+		c, dst, done, err := b.dialServeTCPForward(tcph)
+		if err != nil {
+			t.Fatalf("dial %d: %v", i, err)
+		}
+		if dst != ln.Addr().String() {
+			t.Errorf("dial %d went to %v; want %v", i, dst, ln.Addr())
+		}
+		c.Close()
+		done()
+	}
+}
+
+func TestServeBackendPoolPick(t *testing.T) {
+	targets := []string{"a", "b", "c"}
+	check := func(context.Context, string) error { return nil }
+
+	t.Run("least-conn", func(t *testing.T) {
+		p := newServeBackendPool(context.Background(), t.Logf, targets, ipn.LoadBalancer{Policy: ipn.LoadBalanceLeastConn}, check)
+		defer p.close()
+		p.backends[0].active.Store(2)
+		p.backends[2].active.Store(1)
+		for range 3 {
+			if got := p.pick(nil).target; got != "b" {
+				t.Errorf("picked %q; want b", got)
+			}
+		}
+	})
+	t.Run("all-ejected", func(t *testing.T) {
+		p := newServeBackendPool(context.Background(), t.Logf, targets, ipn.LoadBalancer{MaxFails: 1}, check)
+		defer p.close()
+		for _, be := range p.backends {
+			p.report(be, errors.New("boom"))
+		}
+		// With no healthy backend, all are tried.
+		var got []*serveBackend
+		for range 3 {
+			be := p.pick(got)
+			if be == nil {
+				t.Fatal("pick returned nil")
+			}
+			got = append(got, be)
+		}
+		if be := p.pick(got); be != nil {
+			t.Errorf("pick with all skipped = %q; want nil", be.target)
+		}
+	})
+}
+
 func TestServeHTTPProxyGrantHeader(t *testing.T) {
 	b := newTestBackend(t)
 
@@ -128,12 +128,8 @@ func (b *LocalBackend) getTailscaleSSH_HostKeys(existing map[string]ssh.Signer)
 			continue
 		}
 		if keyDir == "" {
-			root := b.TailscaleVarRoot()
-			if root == "" {
-				return nil, errors.New("no var root for ssh keys")
-			}
-			keyDir = filepath.Join(root, "ssh")
-			if err := os.MkdirAll(keyDir, 0700); err != nil {
+			keyDir, err = b.sshKeyDir()
+			if err != nil {
 				return nil, err
 			}
 		}
@@ -150,13 +146,47 @@ func (b *LocalBackend) getTailscaleSSH_HostKeys(existing map[string]ssh.Signer)
 	return keys, nil
 }
 
+// GetSSH_UserCAKey returns the ed25519 key with which this node signs
+// OpenSSH user certificates when acting as an SSH user CA, creating it
+// if needed. Unlike the host keys, it's never read from the system's
+// OpenSSH configuration.
+func (b *LocalBackend) GetSSH_UserCAKey() (ssh.Signer, error) {
+	keyDir, err := b.sshKeyDir()
+	if err != nil {
+		return nil, err
+	}
+	caKey, err := keyFileOrCreate(filepath.Join(keyDir, "ssh_user_ca_ed25519_key"), "ed25519")
+	if err != nil {
+		return nil, fmt.Errorf("error creating SSH user CA key in %q: %w", keyDir, err)
+	}
+	return ssh.ParsePrivateKey(caKey)
+}
+
+// sshKeyDir returns the $TAILSCALE_VAR/ssh dir, creating it if needed.
+func (b *LocalBackend) sshKeyDir() (string, error) {
+	root := b.TailscaleVarRoot()
+	if root == "" {
+		return "", errors.New("no var root for ssh keys")
+	}
+	keyDir := filepath.Join(root, "ssh")
+	if err := os.MkdirAll(keyDir, 0700); err != nil {
+		return "", err
+	}
+	return keyDir, nil
+}
+
 var keyGenMu sync.Mutex
 
 func (b *LocalBackend) hostKeyFileOrCreate(keyDir, typ string) ([]byte, error) {
+	return keyFileOrCreate(filepath.Join(keyDir, "ssh_host_"+typ+"_key"), typ)
+}
+
+// keyFileOrCreate returns the PEM-encoded private key of type typ stored at
+// path, generating and storing a new one if it doesn't exist.
+func keyFileOrCreate(path, typ string) ([]byte, error) {
 	keyGenMu.Lock()
 	defer keyGenMu.Unlock()
 
-	path := filepath.Join(keyDir, "ssh_host_"+typ+"_key")
 	v, err := os.ReadFile(path)
 	if err == nil {
 		return v, nil
@@ -23,9 +23,11 @@
 	"tailscale.com/feature"
 	"tailscale.com/feature/buildfeatures"
 	"tailscale.com/ipn"
+	"tailscale.com/tailcfg"
 	"tailscale.com/types/logger"
 	"tailscale.com/util/eventbus"
 	"tailscale.com/util/httpm"
+	"tailscale.com/wgengine/filter"
 )
 
 func init() {
@@ -40,6 +42,8 @@ func init() {
 	Register("debug-log", (*Handler).serveDebugLog)
 	Register("debug-packet-filter-matches", (*Handler).serveDebugPacketFilterMatches)
 	Register("debug-packet-filter-rules", (*Handler).serveDebugPacketFilterRules)
+	Register("debug-packet-filter-simulate", (*Handler).serveDebugPacketFilterSimulate)
+	Register("debug-packet-filter-stats", (*Handler).serveDebugPacketFilterStats)
 	Register("debug-peer-endpoint-changes", (*Handler).serveDebugPeerEndpointChanges)
 	Register("debug-optional-features", (*Handler).serveDebugOptionalFeatures)
 }
@@ -282,6 +286,61 @@ func (h *Handler) serveDebugPacketFilterRules(w http.ResponseWriter, r *http.Req
 	enc.Encode(nm.PacketFilterRules)
 }
 
+func (h *Handler) serveDebugPacketFilterStats(w http.ResponseWriter, r *http.Request) {
+	if !h.PermitWrite {
+		http.Error(w, "debug access denied", http.StatusForbidden)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "\t")
+	enc.Encode(h.b.PacketFilterStats())
+}
+
+// serveDebugPacketFilterSimulate replays recently seen inbound flows
+// against a candidate packet filter. The request body is a JSON array of
+// tailcfg.FilterRule values or, if the "type" parameter is "matches", of
+// filter.Match values.
+func (h *Handler) serveDebugPacketFilterSimulate(w http.ResponseWriter, r *http.Request) {
+	if !h.PermitWrite {
+		http.Error(w, "debug access denied", http.StatusForbidden)
+		return
+	}
+	if r.Method != httpm.POST {
+		http.Error(w, "only POST allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var matches []filter.Match
+	switch typ := r.FormValue("type"); typ {
+	case "", "rules":
+		var rules []tailcfg.FilterRule
+		if err := json.NewDecoder(r.Body).Decode(&rules); err != nil {
+			http.Error(w, "invalid JSON body", http.StatusBadRequest)
+			return
+		}
+		var err error
+		matches, err = filter.MatchesFromFilterRules(rules)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	case "matches":
+		if err := json.NewDecoder(r.Body).Decode(&matches); err != nil {
+			http.Error(w, "invalid JSON body", http.StatusBadRequest)
+			return
+		}
+	default:
+		http.Error(w, fmt.Sprintf("unknown type %q", typ), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "\t")
+	enc.Encode(h.b.SimulatePacketFilter(matches))
+}
+
 func (h *Handler) serveDebugPacketFilterMatches(w http.ResponseWriter, r *http.Request) {
 	if !h.PermitWrite {
 		http.Error(w, "debug access denied", http.StatusForbidden)
@@ -126,6 +126,9 @@ func init() {
 	if buildfeatures.HasDNS {
 		Register("dns-osconfig", (*Handler).serveDNSOSConfig)
 		Register("dns-query", (*Handler).serveDNSQuery)
+		Register("dns-cache", (*Handler).serveDNSCache)
+		Register("dns-cache-flush", (*Handler).serveDNSCacheFlush)
+		Register("dns-query-log", (*Handler).serveDNSQueryLog)
 	}
 	if buildfeatures.HasUserMetrics {
 		Register("usermetrics", (*Handler).serveUserMetrics)
@@ -1647,6 +1650,105 @@ func (h *Handler) serveDNSQuery(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
+// serveDNSCache serves the state of the DNS forwarder's response cache.
+// URL parameters:
+//   - responses: if true, include the cached responses
+//
+// The response if successful is a DNSCache JSON object.
+func (h *Handler) serveDNSCache(w http.ResponseWriter, r *http.Request) {
+	if !buildfeatures.HasDNS {
+		http.Error(w, feature.ErrUnavailable.Error(), http.StatusNotImplemented)
+		return
+	}
+	if r.Method != httpm.GET {
+		http.Error(w, "only GET allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	// Require write access for privacy reasons.
+	if !h.PermitWrite {
+		http.Error(w, "dns-cache access denied", http.StatusForbidden)
+		return
+	}
+	res, err := h.b.DNSCache(defBool(r.FormValue("responses"), false))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(res)
+}
+
+// serveDNSCacheFlush removes all responses from the DNS forwarder's
+// response cache.
+func (h *Handler) serveDNSCacheFlush(w http.ResponseWriter, r *http.Request) {
+	if !buildfeatures.HasDNS {
+		http.Error(w, feature.ErrUnavailable.Error(), http.StatusNotImplemented)
+		return
+	}
+	if r.Method != httpm.POST {
+		http.Error(w, "only POST allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !h.PermitWrite {
+		http.Error(w, "dns-cache-flush access denied", http.StatusForbidden)
+		return
+	}
+	if err := h.b.FlushDNSCache(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// serveDNSQueryLog returns the records of recent DNS queries answered by
+// the internal resolver as a JSON array, or, if the "follow" parameter is
+// true, streams the records of new queries as they're answered, one JSON
+// object per line.
+func (h *Handler) serveDNSQueryLog(w http.ResponseWriter, r *http.Request) {
+	if !buildfeatures.HasDNS {
+		http.Error(w, feature.ErrUnavailable.Error(), http.StatusNotImplemented)
+		return
+	}
+	if r.Method != httpm.GET {
+		http.Error(w, "only GET allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	// Require write access for privacy reasons.
+	if !h.PermitWrite {
+		http.Error(w, "dns-query-log access denied", http.StatusForbidden)
+		return
+	}
+	if !defBool(r.FormValue("follow"), false) {
+		res, err := h.b.DNSQueryLog()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+	f, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	f.Flush()
+	enc := json.NewEncoder(w)
+	err := h.b.FollowDNSQueryLog(r.Context(), func(e apitype.DNSQueryLogEntry) bool {
+		if err := enc.Encode(e); err != nil {
+			return false
+		}
+		f.Flush()
+		return true
+	})
+	if err != nil {
+		h.logf("dns-query-log: %v", err)
+	}
+}
+
 // dnsMessageTypeForString returns the dnsmessage.Type for the given string.
 // For example, DNSMessageTypeForString("A") returns dnsmessage.TypeA.
 func dnsMessageTypeForString(s string) (t dnsmessage.Type, err error) {
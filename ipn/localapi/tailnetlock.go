@@ -21,14 +21,18 @@ func init() {
 	Register("tka/affected-sigs", (*Handler).serveTKAAffectedSigs)
 	Register("tka/cosign-recovery-aum", (*Handler).serveTKACosignRecoveryAUM)
 	Register("tka/disable", (*Handler).serveTKADisable)
+	Register("tka/export", (*Handler).serveTKAExport)
 	Register("tka/force-local-disable", (*Handler).serveTKALocalDisable)
+	Register("tka/generate-modify", (*Handler).serveTKAGenerateModify)
 	Register("tka/generate-recovery-aum", (*Handler).serveTKAGenerateRecoveryAUM)
 	Register("tka/init", (*Handler).serveTKAInit)
 	Register("tka/log", (*Handler).serveTKALog)
 	Register("tka/modify", (*Handler).serveTKAModify)
 	Register("tka/sign", (*Handler).serveTKASign)
 	Register("tka/status", (*Handler).serveTKAStatus)
+	Register("tka/submit-modify", (*Handler).serveTKASubmitModify)
 	Register("tka/submit-recovery-aum", (*Handler).serveTKASubmitRecoveryAUM)
+	Register("tka/submit-signature", (*Handler).serveTKASubmitSignature)
 	Register("tka/verify-deeplink", (*Handler).serveTKAVerifySigningDeeplink)
 	Register("tka/wrap-preauth-key", (*Handler).serveTKAWrapPreauthKey)
 }
@@ -147,6 +151,109 @@ type modifyRequest struct {
 	w.WriteHeader(204)
 }
 
+// serveTKAGenerateModify returns the unsigned AUMs for a change to the
+// trusted keys, JSON-encoded as a []tkatype.MarshaledAUM, for the caller to
+// sign and send to serveTKASubmitModify.
+func (h *Handler) serveTKAGenerateModify(w http.ResponseWriter, r *http.Request) {
+	if !h.PermitWrite {
+		http.Error(w, "network-lock modify access denied", http.StatusForbidden)
+		return
+	}
+	if r.Method != httpm.POST {
+		http.Error(w, "use POST", http.StatusMethodNotAllowed)
+		return
+	}
+
+	type modifyRequest struct {
+		AddKeys    []tka.Key
+		RemoveKeys []tka.Key
+	}
+	var req modifyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid JSON body", http.StatusBadRequest)
+		return
+	}
+
+	aums, err := h.b.NetworkLockGenerateModify(req.AddKeys, req.RemoveKeys)
+	if err != nil {
+		http.Error(w, "network-lock modify failed: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	res := make([]tkatype.MarshaledAUM, len(aums))
+	for i, aum := range aums {
+		res[i] = aum.Serialize()
+	}
+	j, err := json.MarshalIndent(res, "", "\t")
+	if err != nil {
+		http.Error(w, "JSON encoding error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(j)
+}
+
+// serveTKASubmitModify submits the AUMs from serveTKAGenerateModify, once
+// signed, JSON-encoded as a []tkatype.MarshaledAUM.
+func (h *Handler) serveTKASubmitModify(w http.ResponseWriter, r *http.Request) {
+	if !h.PermitWrite {
+		http.Error(w, "network-lock modify access denied", http.StatusForbidden)
+		return
+	}
+	if r.Method != httpm.POST {
+		http.Error(w, "use POST", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req []tkatype.MarshaledAUM
+	if err := json.NewDecoder(io.LimitReader(r.Body, 1024*1024)).Decode(&req); err != nil {
+		http.Error(w, "invalid JSON body", http.StatusBadRequest)
+		return
+	}
+	aums := make([]tka.AUM, len(req))
+	for i, b := range req {
+		if err := aums[i].Unserialize(b); err != nil {
+			http.Error(w, "decoding AUM", http.StatusBadRequest)
+			return
+		}
+	}
+
+	if err := h.b.NetworkLockSubmitModify(aums); err != nil {
+		http.Error(w, "network-lock modify failed: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(204)
+}
+
+// serveTKASubmitSignature submits a serialized node-key signature made by
+// the caller.
+func (h *Handler) serveTKASubmitSignature(w http.ResponseWriter, r *http.Request) {
+	if !h.PermitWrite {
+		http.Error(w, "lock sign access denied", http.StatusForbidden)
+		return
+	}
+	if r.Method != httpm.POST {
+		http.Error(w, "use POST", http.StatusMethodNotAllowed)
+		return
+	}
+
+	sigBytes, err := io.ReadAll(io.LimitReader(r.Body, 64*1024))
+	if err != nil {
+		http.Error(w, "reading signature", http.StatusBadRequest)
+		return
+	}
+	var sig tka.NodeKeySignature
+	if err := sig.Unserialize(sigBytes); err != nil {
+		http.Error(w, "decoding signature", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.b.NetworkLockSubmitSignature(sig); err != nil {
+		http.Error(w, "signing failed: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
 func (h *Handler) serveTKAWrapPreauthKey(w http.ResponseWriter, r *http.Request) {
 	if !h.PermitWrite {
 		http.Error(w, "network-lock modify access denied", http.StatusForbidden)
@@ -289,6 +396,33 @@ func (h *Handler) serveTKALog(w http.ResponseWriter, r *http.Request) {
 	w.Write(j)
 }
 
+// serveTKAExport returns a JSON-encoded [tka.ChainExport] of the active
+// chain of AUMs.
+func (h *Handler) serveTKAExport(w http.ResponseWriter, r *http.Request) {
+	if !h.PermitRead {
+		http.Error(w, "lock export access denied", http.StatusForbidden)
+		return
+	}
+	if r.Method != httpm.GET {
+		http.Error(w, "use GET", http.StatusMethodNotAllowed)
+		return
+	}
+
+	export, err := h.b.NetworkLockExport()
+	if err != nil {
+		http.Error(w, "exporting failed: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	j, err := json.MarshalIndent(export, "", "\t")
+	if err != nil {
+		http.Error(w, "JSON encoding error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(j)
+}
+
 func (h *Handler) serveTKAAffectedSigs(w http.ResponseWriter, r *http.Request) {
 	if r.Method != httpm.POST {
 		http.Error(w, "use POST", http.StatusMethodNotAllowed)
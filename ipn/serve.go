@@ -17,6 +17,7 @@
 
 	"tailscale.com/ipn/ipnstate"
 	"tailscale.com/tailcfg"
+	"tailscale.com/tstime"
 	"tailscale.com/types/ipproto"
 	"tailscale.com/util/dnsname"
 	"tailscale.com/util/mak"
@@ -146,6 +147,17 @@ type TCPPortHandler struct {
 	// It is mutually exclusive with HTTPS.
 	TCPForward string `json:",omitempty"`
 
+	// Backends, if non-empty, lists additional IP:port destinations to
+	// forward TCP connections to. Connections are load-balanced across
+	// TCPForward and Backends as configured by LoadBalancer. If connecting
+	// to the chosen backend fails, the others are tried in turn.
+	// It is only used if TCPForward is non-empty.
+	Backends []string `json:",omitempty"`
+
+	// LoadBalancer configures how connections are spread across TCPForward
+	// and Backends. It is only used if Backends is non-empty.
+	LoadBalancer LoadBalancer `json:",omitzero"`
+
 	// TerminateTLS, if non-empty, means that tailscaled should terminate the
 	// TLS connections before forwarding them to TCPForward, permitting only the
 	// SNI name with this value. It is only used if TCPForward is non-empty.
@@ -179,10 +191,98 @@ type HTTPHandler struct {
 	//   - ${REQUEST_URI}: replaced with the request's full URI (path and query string)
 	Redirect string `json:",omitempty"`
 
+	// SetResponseHeaders are static headers set on responses sent back to
+	// the client, replacing any values set by the handler or proxy backend.
+	SetResponseHeaders map[string]string `json:",omitempty"`
+
+	// RateLimit, if positive, is the maximum sustained number of requests
+	// per second accepted from each source node (or, for Funnel requests,
+	// each client IP). Requests over the limit get HTTP 429 (Too Many
+	// Requests).
+	RateLimit float64 `json:",omitzero"`
+
+	// RateLimitBurst is the number of requests a source may make at once
+	// before RateLimit applies. If zero, RateLimit rounded up (minimum 1)
+	// is used. It is only used if RateLimit is positive.
+	RateLimitBurst int `json:",omitzero"`
+
+	// The following fields are only used if Proxy is set.
+
+	// Backends, if non-empty, lists additional proxy backends, in the same
+	// forms as Proxy. Requests are load-balanced across Proxy and Backends
+	// as configured by LoadBalancer. Unlike TCP forwarding, a request isn't
+	// retried on another backend if the chosen one fails; it fails with a
+	// 502, and the failure counts against the backend's health.
+	Backends []string `json:",omitempty"`
+
+	// LoadBalancer configures how requests are spread across Proxy and
+	// Backends. It is only used if Backends is non-empty.
+	LoadBalancer LoadBalancer `json:",omitzero"`
+
+	// StripPrefix, if non-empty, is removed from the start of the request
+	// path before it is proxied. It is applied after the mount point has
+	// been trimmed, so for a handler mounted at "/app/" with StripPrefix
+	// "/v1", a request for "/app/v1/foo" is proxied as "/foo".
+	StripPrefix string `json:",omitempty"`
+
+	// AddPrefix, if non-empty, is prepended to the request path before it is
+	// proxied, after any StripPrefix has been removed. Together with
+	// StripPrefix it can be used to rewrite one path prefix to another.
+	AddPrefix string `json:",omitempty"`
+
+	// SetRequestHeaders are static headers set on requests sent to the
+	// proxy backend, replacing any values sent by the client.
+	SetRequestHeaders map[string]string `json:",omitempty"`
+
+	// IdentityHeaders optionally renames the Tailscale identity headers
+	// sent to the proxy backend. The keys are the default header names
+	// ("Tailscale-User-Login", "Tailscale-User-Name" or
+	// "Tailscale-User-Profile-Pic") and the values are the names to use
+	// instead. Client-supplied values for both names are always removed.
+	IdentityHeaders map[string]string `json:",omitempty"`
+
 	// TODO(bradfitz): bool to not enumerate directories? TTL on mapping for
 	// temporary ones? Error codes?
 }
 
+// Load balancing policies for LoadBalancer.Policy.
+const (
+	LoadBalanceRoundRobin = "round-robin" // cycle through healthy backends (the default)
+	LoadBalanceLeastConn  = "least-conn"  // pick the healthy backend with the fewest active requests or connections
+)
+
+// LoadBalancer configures how a serve handler with multiple backends spreads
+// requests or connections across them, and how backend health is tracked.
+//
+// Backends are always checked passively: a backend that fails MaxFails
+// requests or connections in a row is ejected for EjectDuration. If
+// HealthCheckInterval is set, backends are also checked actively, and a
+// backend whose last check failed is not used until a check succeeds.
+// If no backend is healthy, all of them are tried.
+type LoadBalancer struct {
+	// Policy is the backend selection policy, one of the LoadBalance*
+	// constants. The empty string means LoadBalanceRoundRobin.
+	Policy string `json:",omitempty"`
+
+	// HealthCheckInterval, if non-zero, is how often each backend is
+	// actively health checked.
+	HealthCheckInterval tstime.GoDuration `json:",omitzero"`
+
+	// HealthCheckPath is the path requested by active health checks of
+	// HTTP proxy backends. Any 2xx or 3xx response means the backend is
+	// healthy. If empty, or for TCP forwarding backends, a successful TCP
+	// connection means the backend is healthy.
+	HealthCheckPath string `json:",omitempty"`
+
+	// MaxFails is the number of consecutive failed requests or
+	// connections after which a backend is ejected. If zero, 3 is used.
+	MaxFails int `json:",omitzero"`
+
+	// EjectDuration is how long a backend is ejected for after MaxFails
+	// consecutive failures. If zero, 30 seconds is used.
+	EjectDuration tstime.GoDuration `json:",omitzero"`
+}
+
 // WebHandlerExists reports whether if the ServeConfig Web handler exists for
 // the given host:port and mount point.
 func (sc *ServeConfig) WebHandlerExists(svcName tailcfg.ServiceName, hp HostPort, mount string) bool {
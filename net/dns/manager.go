@@ -111,6 +111,9 @@ func NewManager(logf logger.Logf, oscfg OSConfigurator, health *health.Tracker,
 			m.logf("error setting DNS config: %s", err)
 		}
 	})
+	eventbus.SubscribeFunc(m.eventClient, func(delta netmon.ChangeDelta) {
+		m.resolver.LinkChanged(&delta)
+	})
 
 	m.ctx, m.ctxCancel = context.WithCancel(context.Background())
 	m.logf("using %T", m.os)
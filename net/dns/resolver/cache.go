@@ -0,0 +1,297 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package resolver
+
+import (
+	"sync/atomic"
+	"time"
+
+	dns "golang.org/x/net/dns/dnsmessage"
+	"tailscale.com/envknob"
+	"tailscale.com/syncs"
+	"tailscale.com/util/dnsname"
+	"tailscale.com/util/lru"
+)
+
+const (
+	// maxCacheEntries is the maximum number of responses kept in the
+	// forwarder's response cache.
+	maxCacheEntries = 4096
+
+	// maxCacheTTL caps how long a positive response is cached, regardless
+	// of its record TTLs.
+	maxCacheTTL = time.Hour
+
+	// maxNegativeCacheTTL caps how long a negative (NXDOMAIN or NODATA)
+	// response is cached. RFC 2308 section 5 suggests 1-3 hours; we're
+	// more conservative, as split DNS configurations change under us.
+	maxNegativeCacheTTL = 15 * time.Minute
+)
+
+var disableDNSCache = envknob.RegisterBool("TS_DEBUG_DNS_FORWARD_NO_CACHE")
+
+// CacheStats describes the state of the forwarder's response cache.
+type CacheStats struct {
+	Entries    int    // number of cached responses, including expired ones not yet evicted
+	MaxEntries int    // maximum number of cached responses
+	Hits       uint64 // queries answered from the cache
+	Misses     uint64 // cacheable queries sent upstream
+}
+
+// CacheEntry describes a response in the forwarder's cache.
+type CacheEntry struct {
+	Route    string    // DNS route suffix the response was resolved via, or "" for explicit resolvers
+	Name     string    // query name, lowercase with trailing dot
+	Type     dns.Type  // query type
+	RCode    dns.RCode // response code
+	Negative bool      // whether this is a negative (NXDOMAIN or NODATA) response
+	Expires  time.Time // when the entry expires
+}
+
+// cacheKey is the key of a response in a responseCache.
+type cacheKey struct {
+	// partition separates responses from different routes and upstream
+	// resolvers, so that a split DNS route never sees responses from
+	// another route's resolvers.
+	partition string
+	name      dnsname.FQDN // lowercase
+	typ       dns.Type
+	class     dns.Class
+	edns      bool // query had an OPT record
+	do        bool // query had the DNSSEC OK bit set
+	cd        bool // query had the Checking Disabled bit set
+}
+
+// cacheEntry is a cached upstream response.
+type cacheEntry struct {
+	route    string
+	msg      dns.Message // as received, with the question and TTLs of the original query
+	stored   time.Time
+	expires  time.Time
+	negative bool
+}
+
+// responseCache is a size-bounded cache of upstream DNS responses that
+// honors record TTLs and RFC 2308 negative caching TTLs.
+//
+// The zero value is not valid; use newResponseCache.
+type responseCache struct {
+	now func() time.Time // or time.Now
+
+	hits, misses atomic.Uint64
+
+	mu      syncs.Mutex
+	entries lru.Cache[cacheKey, *cacheEntry] // guarded by mu
+}
+
+func newResponseCache() *responseCache {
+	c := &responseCache{now: time.Now}
+	c.entries.MaxEntries = maxCacheEntries
+	return c
+}
+
+// cacheKeyForQuery returns the cache key for the DNS query q, sent via the
+// cache partition partition. It reports false if q is not cacheable.
+func cacheKeyForQuery(partition string, q []byte) (_ cacheKey, ok bool) {
+	var p dns.Parser
+	hdr, err := p.Start(q)
+	if err != nil || hdr.Response || hdr.OpCode != 0 {
+		return cacheKey{}, false
+	}
+	qq, err := p.Question()
+	if err != nil {
+		return cacheKey{}, false
+	}
+	if _, err := p.Question(); err != dns.ErrSectionDone {
+		return cacheKey{}, false // multiple questions
+	}
+	name, err := dnsname.ToFQDN(rawNameToLower(qq.Name.Data[:qq.Name.Length]))
+	if err != nil {
+		return cacheKey{}, false
+	}
+	k := cacheKey{
+		partition: partition,
+		name:      name,
+		typ:       qq.Type,
+		class:     qq.Class,
+		cd:        hdr.CheckingDisabled,
+	}
+	if _, opt := findOPTRecord(q); opt != nil {
+		k.edns = true
+		k.do = opt[7]&0x80 != 0
+	}
+	return k, true
+}
+
+// get returns the cached response to the query q with key k, adjusted for
+// q's transaction ID and question and with TTLs reduced by the time since
+// it was cached.
+func (c *responseCache) get(k cacheKey, q []byte) (res []byte, ok bool) {
+	now := c.now()
+	c.mu.Lock()
+	ent, ok := c.entries.GetOk(k)
+	if ok && !now.Before(ent.expires) {
+		c.entries.Delete(k)
+		ok = false
+	}
+	c.mu.Unlock()
+	if !ok {
+		c.misses.Add(1)
+		return nil, false
+	}
+
+	var p dns.Parser
+	hdr, err := p.Start(q)
+	if err != nil {
+		return nil, false
+	}
+	qq, err := p.Question()
+	if err != nil {
+		return nil, false
+	}
+	age := uint32(now.Sub(ent.stored) / time.Second)
+	msg := ent.msg
+	msg.ID = hdr.ID
+	msg.RecursionDesired = hdr.RecursionDesired
+	msg.Questions = []dns.Question{qq}
+	msg.Answers = agedResources(msg.Answers, age)
+	msg.Authorities = agedResources(msg.Authorities, age)
+	msg.Additionals = agedResources(msg.Additionals, age)
+	res, err = msg.Pack()
+	if err != nil {
+		return nil, false
+	}
+	c.hits.Add(1)
+	return res, true
+}
+
+// agedResources returns a copy of rrs with TTLs reduced by age seconds.
+func agedResources(rrs []dns.Resource, age uint32) []dns.Resource {
+	if len(rrs) == 0 {
+		return nil
+	}
+	ret := make([]dns.Resource, len(rrs))
+	for i, rr := range rrs {
+		if rr.Header.Type != dns.TypeOPT {
+			rr.Header.TTL -= min(age, rr.Header.TTL)
+		}
+		ret[i] = rr
+	}
+	return ret
+}
+
+// put caches the upstream response res to the query with key k, if it is
+// cacheable. route is the route suffix the query was resolved via, for
+// CacheEntries.
+func (c *responseCache) put(k cacheKey, route string, res []byte) {
+	var msg dns.Message
+	if err := msg.Unpack(res); err != nil {
+		return
+	}
+	if !msg.Response || msg.Truncated || len(msg.Questions) != 1 {
+		return
+	}
+	q := msg.Questions[0]
+	if q.Type != k.typ || q.Class != k.class {
+		return
+	}
+	if name, err := dnsname.ToFQDN(rawNameToLower(q.Name.Data[:q.Name.Length])); err != nil || name != k.name {
+		return
+	}
+
+	var ttl uint32
+	var negative bool
+	switch {
+	case msg.RCode == dns.RCodeSuccess && len(msg.Answers) > 0:
+		ttl = minTTL(msg.Answers, msg.Authorities, msg.Additionals)
+	case msg.RCode == dns.RCodeSuccess || msg.RCode == dns.RCodeNameError:
+		// Negative response. Per RFC 2308 section 5, its TTL is the
+		// minimum of the SOA record's TTL and its MINIMUM field, and
+		// responses without an SOA record should not be cached.
+		negative = true
+		for _, rr := range msg.Authorities {
+			if soa, ok := rr.Body.(*dns.SOAResource); ok {
+				ttl = min(rr.Header.TTL, soa.MinTTL)
+				break
+			}
+		}
+	default:
+		return
+	}
+	d := time.Duration(ttl) * time.Second
+	if negative {
+		d = min(d, maxNegativeCacheTTL)
+	} else {
+		d = min(d, maxCacheTTL)
+	}
+	if d <= 0 {
+		return
+	}
+
+	now := c.now()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries.Set(k, &cacheEntry{
+		route:    route,
+		msg:      msg,
+		stored:   now,
+		expires:  now.Add(d),
+		negative: negative,
+	})
+}
+
+// minTTL returns the smallest TTL of the non-OPT records in sections.
+func minTTL(sections ...[]dns.Resource) uint32 {
+	ttl := uint32(1<<31 - 1) // RFC 2181 section 8
+	for _, rrs := range sections {
+		for _, rr := range rrs {
+			if rr.Header.Type != dns.TypeOPT {
+				ttl = min(ttl, rr.Header.TTL)
+			}
+		}
+	}
+	return ttl
+}
+
+// flush removes all cached responses.
+func (c *responseCache) flush() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries.Clear()
+}
+
+// stats returns the cache's statistics.
+func (c *responseCache) stats() CacheStats {
+	c.mu.Lock()
+	n := c.entries.Len()
+	c.mu.Unlock()
+	return CacheStats{
+		Entries:    n,
+		MaxEntries: c.entries.MaxEntries,
+		Hits:       c.hits.Load(),
+		Misses:     c.misses.Load(),
+	}
+}
+
+// list returns the unexpired cached responses, most recently used first.
+func (c *responseCache) list() []CacheEntry {
+	now := c.now()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var ret []CacheEntry
+	c.entries.ForEach(func(k cacheKey, ent *cacheEntry) {
+		if !now.Before(ent.expires) {
+			return
+		}
+		ret = append(ret, CacheEntry{
+			Route:    ent.route,
+			Name:     string(k.name),
+			Type:     k.typ,
+			RCode:    ent.msg.RCode,
+			Negative: ent.negative,
+			Expires:  ent.expires,
+		})
+	})
+	return ret
+}
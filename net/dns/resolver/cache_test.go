@@ -0,0 +1,336 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package resolver
+
+import (
+	"context"
+	"fmt"
+	"net/netip"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	dns "golang.org/x/net/dns/dnsmessage"
+	"tailscale.com/health"
+	"tailscale.com/net/netmon"
+	"tailscale.com/net/tsdial"
+	"tailscale.com/tstest"
+	"tailscale.com/types/dnstype"
+	"tailscale.com/util/dnsname"
+	"tailscale.com/util/eventbus/eventbustest"
+)
+
+// makeNegativeResponse returns a response to an A query for domain with the
+// given rcode and, if soaTTL is non-zero, an SOA record in the authority
+// section with the given TTL and MINIMUM field.
+func makeNegativeResponse(tb testing.TB, domain string, rcode dns.RCode, soaTTL, soaMin uint32) []byte {
+	tb.Helper()
+	name := dns.MustNewName(domain)
+	b := dns.NewBuilder(nil, dns.Header{Response: true, RCode: rcode})
+	b.StartQuestions()
+	b.Question(dns.Question{Name: name, Type: dns.TypeA, Class: dns.ClassINET})
+	if soaTTL > 0 {
+		b.StartAuthorities()
+		b.SOAResource(dns.ResourceHeader{
+			Name:  dns.MustNewName("example.com."),
+			Class: dns.ClassINET,
+			TTL:   soaTTL,
+		}, dns.SOAResource{
+			NS:     dns.MustNewName("ns.example.com."),
+			MBox:   dns.MustNewName("hostmaster.example.com."),
+			MinTTL: soaMin,
+		})
+	}
+	res, err := b.Finish()
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return res
+}
+
+func TestResponseCache(t *testing.T) {
+	const domain = "foo.example.com."
+	addr := netip.MustParseAddr("1.2.3.4")
+
+	now := time.Unix(1_700_000_000, 0)
+	newCache := func() *responseCache {
+		c := newResponseCache()
+		c.now = func() time.Time { return now }
+		return c
+	}
+	mustKey := func(partition string, q []byte) cacheKey {
+		t.Helper()
+		k, ok := cacheKeyForQuery(partition, q)
+		if !ok {
+			t.Fatal("query not cacheable")
+		}
+		return k
+	}
+
+	t.Run("positive", func(t *testing.T) {
+		now = time.Unix(1_700_000_000, 0)
+		c := newCache()
+		req := makeTestRequest(t, domain, dns.TypeA, 0)
+		k := mustKey("p", req)
+		if _, ok := c.get(k, req); ok {
+			t.Fatal("unexpected hit on empty cache")
+		}
+		c.put(k, "", makeTestResponse(t, domain, dns.RCodeSuccess, addr))
+
+		now = now.Add(20 * time.Second)
+		req2 := makeTestRequest(t, "FOO.example.com.", dns.TypeA, 0)
+		req2[0], req2[1] = 0x12, 0x34 // txid
+		res, ok := c.get(mustKey("p", req2), req2)
+		if !ok {
+			t.Fatal("expected hit for case-insensitive name")
+		}
+		var msg dns.Message
+		if err := msg.Unpack(res); err != nil {
+			t.Fatal(err)
+		}
+		if msg.ID != 0x1234 {
+			t.Errorf("ID = %#x; want 0x1234", msg.ID)
+		}
+		if got := msg.Questions[0].Name.String(); got != "FOO.example.com." {
+			t.Errorf("question name = %q; want the query's", got)
+		}
+		if len(msg.Answers) != 1 || msg.Answers[0].Header.TTL != 100 {
+			t.Errorf("answers = %+v; want one with TTL 100", msg.Answers)
+		}
+
+		if _, ok := c.get(mustKey("other", req), req); ok {
+			t.Error("unexpected hit in another partition")
+		}
+		txt := makeTestRequest(t, domain, dns.TypeTXT, 0)
+		if _, ok := c.get(mustKey("p", txt), txt); ok {
+			t.Error("unexpected hit for another type")
+		}
+		edns := makeTestRequest(t, domain, dns.TypeA, 1232)
+		if _, ok := c.get(mustKey("p", edns), edns); ok {
+			t.Error("unexpected hit for EDNS query")
+		}
+
+		now = now.Add(100 * time.Second)
+		if _, ok := c.get(k, req); ok {
+			t.Error("unexpected hit after expiry")
+		}
+		if st := c.stats(); st.Hits != 1 || st.Misses != 5 || st.Entries != 0 {
+			t.Errorf("stats = %+v; want 1 hit, 5 misses, 0 entries", st)
+		}
+	})
+
+	t.Run("negative", func(t *testing.T) {
+		now = time.Unix(1_700_000_000, 0)
+		c := newCache()
+		req := makeTestRequest(t, domain, dns.TypeA, 0)
+		k := mustKey("p", req)
+		c.put(k, "example.com.", makeNegativeResponse(t, domain, dns.RCodeNameError, 3600, 60))
+		ents := c.list()
+		if len(ents) != 1 || !ents[0].Negative || ents[0].RCode != dns.RCodeNameError || ents[0].Route != "example.com." {
+			t.Fatalf("entries = %+v; want one negative NXDOMAIN entry", ents)
+		}
+		if want := now.Add(60 * time.Second); !ents[0].Expires.Equal(want) {
+			t.Errorf("expires = %v; want %v (SOA MINIMUM)", ents[0].Expires, want)
+		}
+		if _, ok := c.get(k, req); !ok {
+			t.Error("expected hit for negative response")
+		}
+		now = now.Add(time.Minute)
+		if _, ok := c.get(k, req); ok {
+			t.Error("unexpected hit after negative TTL")
+		}
+	})
+
+	t.Run("not-cached", func(t *testing.T) {
+		c := newCache()
+		req := makeTestRequest(t, domain, dns.TypeA, 0)
+		k := mustKey("p", req)
+
+		c.put(k, "", makeNegativeResponse(t, domain, dns.RCodeNameError, 0, 0))
+		c.put(k, "", makeNegativeResponse(t, domain, dns.RCodeServerFailure, 300, 300))
+		c.put(k, "", makeTestResponse(t, "bar.example.com.", dns.RCodeSuccess, addr))
+		truncated := makeTestResponse(t, domain, dns.RCodeSuccess, addr)
+		truncated[2] |= 0x02 // TC bit
+		c.put(k, "", truncated)
+		if n := c.stats().Entries; n != 0 {
+			t.Errorf("cached %d entries; want 0", n)
+		}
+
+		c.put(k, "", makeTestResponse(t, domain, dns.RCodeSuccess, addr))
+		c.flush()
+		if _, ok := c.get(k, req); ok {
+			t.Error("unexpected hit after flush")
+		}
+	})
+}
+
+func TestForwarderCache(t *testing.T) {
+	const domain = "cached.example.com."
+	response := makeTestResponse(t, domain, dns.RCodeSuccess, netip.MustParseAddr("1.2.3.4"))
+	var requests atomic.Int32
+	port := runDNSServer(t, nil, response, func(isTCP bool, gotRequest []byte) {
+		requests.Add(1)
+	})
+
+	logf := tstest.WhileTestRunningLogger(t)
+	bus := eventbustest.NewBus(t)
+	netMon, err := netmon.New(bus, logf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var dialer tsdial.Dialer
+	dialer.SetNetMon(netMon)
+	dialer.SetBus(bus)
+	fwd := newForwarder(logf, netMon, nil, &dialer, health.NewTracker(bus), nil)
+	if fwd.cache == nil {
+		t.Skip("cache disabled")
+	}
+	resolvers := []resolverAndDelay{{name: &dnstype.Resolver{Addr: fmt.Sprintf("127.0.0.1:%d", port)}}}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for i := range 3 {
+		rchan := make(chan packet, 1)
+		pkt := packet{
+			bs:     makeTestRequest(t, domain, dns.TypeA, 0),
+			family: "udp",
+			addr:   netip.MustParseAddrPort("127.0.0.1:12345"),
+		}
+		if err := fwd.forwardWithDestChan(ctx, pkt, rchan, resolvers...); err != nil {
+			t.Fatalf("query %d: %v", i, err)
+		}
+		res := <-rchan
+		var msg dns.Message
+		if err := msg.Unpack(res.bs); err != nil {
+			t.Fatalf("query %d: %v", i, err)
+		}
+		if len(msg.Answers) != 1 {
+			t.Fatalf("query %d: got %d answers; want 1", i, len(msg.Answers))
+		}
+	}
+	if n := requests.Load(); n != 1 {
+		t.Errorf("upstream got %d requests; want 1", n)
+	}
+	if st := fwd.cache.stats(); st.Hits != 2 || st.Misses != 1 {
+		t.Errorf("stats = %+v; want 2 hits, 1 miss", st)
+	}
+}
+
+// TestCacheFlushedOnChange tests that cached responses are dropped when
+// the upstream resolvers change or the device may have changed networks.
+func TestCacheFlushedOnChange(t *testing.T) {
+	bus := eventbustest.NewBus(t)
+	dialer := tsdial.NewDialer(netmon.NewStatic())
+	dialer.SetBus(bus)
+	r := New(t.Logf, nil, dialer, health.NewTracker(bus), nil)
+	defer r.Close()
+	if r.forwarder.cache == nil {
+		t.Skip("cache disabled")
+	}
+
+	const domain = "lan.example.com."
+	addCached := func() {
+		t.Helper()
+		req := makeTestRequest(t, domain, dns.TypeA, 0)
+		k, ok := cacheKeyForQuery("example.com. 192.168.1.1", req)
+		if !ok {
+			t.Fatal("query not cacheable")
+		}
+		r.forwarder.cache.put(k, "example.com.", makeTestResponse(t, domain, dns.RCodeSuccess, netip.MustParseAddr("192.168.1.10")))
+	}
+	checkEntries := func(desc string, want int) {
+		t.Helper()
+		st, _ := r.CacheStats()
+		if st.Entries != want {
+			t.Errorf("%s: %d cached entries; want %d", desc, st.Entries, want)
+		}
+	}
+	routes := func(addr string) map[dnsname.FQDN][]*dnstype.Resolver {
+		return map[dnsname.FQDN][]*dnstype.Resolver{"example.com.": {{Addr: addr}}}
+	}
+	delta := func(old *netmon.State, timeJumped bool) *netmon.ChangeDelta {
+		t.Helper()
+		cd, err := netmon.NewChangeDelta(old, &netmon.State{}, timeJumped, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return cd
+	}
+
+	r.forwarder.setRoutes(routes("192.168.1.1"))
+	addCached()
+	r.forwarder.setRoutes(routes("192.168.1.1"))
+	checkEntries("same routes", 1)
+	r.forwarder.setRoutes(routes("10.0.0.1"))
+	checkEntries("changed routes", 0)
+
+	addCached()
+	r.LinkChanged(delta(nil, false))
+	checkEntries("initial state", 1)
+	r.LinkChanged(delta(&netmon.State{}, false))
+	checkEntries("no rebind", 1)
+	r.LinkChanged(delta(&netmon.State{}, true))
+	checkEntries("major change", 0)
+}
+
+func TestForwarderCacheTruncatesForUDP(t *testing.T) {
+	const domain = "large.example.com."
+	response := makeEDNSResponse(t, domain, 800)
+	var requests atomic.Int32
+	port := runDNSServer(t, nil, response, func(isTCP bool, gotRequest []byte) {
+		requests.Add(1)
+	})
+
+	logf := tstest.WhileTestRunningLogger(t)
+	bus := eventbustest.NewBus(t)
+	netMon, err := netmon.New(bus, logf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var dialer tsdial.Dialer
+	dialer.SetNetMon(netMon)
+	dialer.SetBus(bus)
+	fwd := newForwarder(logf, netMon, nil, &dialer, health.NewTracker(bus), nil)
+	if fwd.cache == nil {
+		t.Skip("cache disabled")
+	}
+	resolvers := []resolverAndDelay{{name: &dnstype.Resolver{Addr: fmt.Sprintf("127.0.0.1:%d", port)}}}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	query := func(family string, ednsSize uint16) []byte {
+		t.Helper()
+		rchan := make(chan packet, 1)
+		pkt := packet{
+			bs:     makeTestRequest(t, domain, dns.TypeTXT, ednsSize),
+			family: family,
+			addr:   netip.MustParseAddrPort("127.0.0.1:12345"),
+		}
+		if err := fwd.forwardWithDestChan(ctx, pkt, rchan, resolvers...); err != nil {
+			t.Fatalf("%s query: %v", family, err)
+		}
+		return (<-rchan).bs
+	}
+
+	// The answer to a TCP query is cached, and it fits.
+	if res := query("tcp", 4096); truncatedFlagSet(res) {
+		t.Errorf("TC flag set in response to TCP query")
+	}
+	// A UDP query with a smaller EDNS buffer is answered from the cache,
+	// with the TC flag set, as the answer doesn't fit.
+	res := query("udp", 512)
+	if len(res) <= 512 {
+		t.Fatalf("cached response is %d bytes; want more than 512", len(res))
+	}
+	if !truncatedFlagSet(res) {
+		t.Errorf("TC flag not set in cached response to UDP query with 512-byte EDNS buffer")
+	}
+	if n := requests.Load(); n != 1 {
+		t.Errorf("upstream got %d requests; want 1", n)
+	}
+	// The cached response itself isn't modified.
+	if res := query("tcp", 4096); truncatedFlagSet(res) {
+		t.Errorf("TC flag set in cached response to TCP query")
+	}
+}
@@ -0,0 +1,379 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package resolver
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"net/url"
+	"slices"
+	"strconv"
+	"time"
+
+	dns "golang.org/x/net/dns/dnsmessage"
+	"tailscale.com/net/dnscache"
+	"tailscale.com/net/sockstats"
+	"tailscale.com/syncs"
+	"tailscale.com/types/dnstype"
+)
+
+const (
+	// dotDefaultPort is the default port for DNS over TLS, per RFC 7858.
+	dotDefaultPort = 853
+
+	// dotIdleConnTimeout is how long an idle DNS-over-TLS connection is
+	// kept open for reuse.
+	dotIdleConnTimeout = 30 * time.Second
+
+	// dotQueryTimeout is the maximum time to wait for a DNS-over-TLS
+	// connection to be established and a response to be received.
+	dotQueryTimeout = tcpQueryTimeout
+)
+
+var errDoTConnClosed = errors.New("DNS-over-TLS connection closed")
+
+// parseDoTAddr parses a DNS-over-TLS resolver address of the form
+// "tls://host[:port]". The port defaults to 853.
+func parseDoTAddr(addr string) (host string, port uint16, err error) {
+	u, err := url.Parse(addr)
+	if err != nil {
+		return "", 0, err
+	}
+	if u.Scheme != "tls" || u.Host == "" || u.User != nil || (u.Path != "" && u.Path != "/") || u.RawQuery != "" || u.Fragment != "" {
+		return "", 0, fmt.Errorf("invalid DNS-over-TLS resolver address %q; want tls://host[:port]", addr)
+	}
+	port = dotDefaultPort
+	if ps := u.Port(); ps != "" {
+		p, err := strconv.ParseUint(ps, 10, 16)
+		if err != nil || p == 0 {
+			return "", 0, fmt.Errorf("invalid port in DNS-over-TLS resolver address %q", addr)
+		}
+		port = uint16(p)
+	}
+	return u.Hostname(), port, nil
+}
+
+// dotClient sends DNS queries to a single DNS-over-TLS resolver (RFC 7858).
+//
+// It keeps one connection open to the resolver and pipelines concurrent
+// queries over it, matching out-of-order responses to queries by
+// transaction ID as described in RFC 7766 section 6.2.1.1.
+type dotClient struct {
+	f          *forwarder
+	serverName string       // for SNI and certificate verification
+	port       uint16       // resolver port
+	ips        []netip.Addr // IPs to dial; from the address or BootstrapResolution
+
+	mu   syncs.Mutex
+	conn *dotConn // or nil if not connected
+}
+
+// getDoTClient returns the DNS-over-TLS client for the resolver r, creating
+// it if needed.
+func (f *forwarder) getDoTClient(r *dnstype.Resolver) (*dotClient, error) {
+	host, port, err := parseDoTAddr(r.Addr)
+	if err != nil {
+		return nil, err
+	}
+	var ips []netip.Addr
+	if ip, err := netip.ParseAddr(host); err == nil {
+		ips = []netip.Addr{ip}
+	} else if len(r.BootstrapResolution) > 0 {
+		ips = r.BootstrapResolution
+	} else {
+		// Resolving the resolver's name would likely loop back through
+		// us, so require the control plane to tell us where it is.
+		return nil, fmt.Errorf("DNS-over-TLS resolver %q requires an IP address or BootstrapResolution", r.Addr)
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if c, ok := f.dotClients[r.Addr]; ok {
+		if slices.Equal(c.ips, ips) {
+			return c, nil
+		}
+		c.close()
+	}
+	c := &dotClient{
+		f:          f,
+		serverName: host,
+		port:       port,
+		ips:        ips,
+	}
+	if f.dotClients == nil {
+		f.dotClients = map[string]*dotClient{}
+	}
+	f.dotClients[r.Addr] = c
+	return c, nil
+}
+
+// close closes c's connection, if any.
+func (c *dotClient) close() {
+	c.mu.Lock()
+	conn := c.conn
+	c.conn = nil
+	c.mu.Unlock()
+	if conn != nil {
+		conn.closeWithError(errDoTConnClosed)
+	}
+}
+
+// getConn returns a connection to the resolver, dialing a new one if
+// there's no open connection. It reports whether the connection was reused.
+func (c *dotClient) getConn(ctx context.Context) (_ *dotConn, reused bool, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.conn != nil && !c.conn.isClosed() {
+		return c.conn, true, nil
+	}
+	c.conn = nil
+
+	// Dial with a context that isn't canceled when ctx is done, as the
+	// connection outlives this query.
+	dctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), dotQueryTimeout)
+	defer cancel()
+	stop := context.AfterFunc(ctx, cancel)
+	defer stop()
+
+	var nc net.Conn
+	if len(c.ips) == 1 {
+		nc, err = c.f.getDialerType()(dctx, "tcp", netip.AddrPortFrom(c.ips[0], c.port).String())
+	} else {
+		dial := dnscache.Dialer(c.f.getDialerType(), &dnscache.Resolver{
+			SingleHost:             c.serverName,
+			SingleHostStaticResult: c.ips,
+			Logf:                   c.f.logf,
+		})
+		nc, err = dial(dctx, "tcp", net.JoinHostPort(c.serverName, strconv.Itoa(int(c.port))))
+	}
+	if err != nil {
+		metricDNSFwdDoTErrorDial.Add(1)
+		return nil, false, err
+	}
+	tc := tls.Client(nc, &tls.Config{
+		ServerName: c.serverName,
+		MinVersion: tls.VersionTLS12,
+		RootCAs:    c.f.dotRootCAs,
+	})
+	if err := tc.HandshakeContext(dctx); err != nil {
+		nc.Close()
+		metricDNSFwdDoTErrorDial.Add(1)
+		return nil, false, err
+	}
+	c.conn = newDoTConn(tc)
+	return c.conn, false, nil
+}
+
+// exchange sends the DNS query packet to the resolver and returns its
+// response.
+func (c *dotClient) exchange(ctx context.Context, packet []byte) ([]byte, error) {
+	for attempt := 0; ; attempt++ {
+		conn, reused, err := c.getConn(ctx)
+		if err != nil {
+			return nil, err
+		}
+		res, err := conn.exchange(ctx, packet)
+		if err != nil && reused && attempt == 0 && ctx.Err() == nil && conn.isClosed() {
+			// The resolver likely closed the idle connection just as
+			// we reused it. Retry once on a new connection.
+			metricDNSFwdDoTRetry.Add(1)
+			c.mu.Lock()
+			if c.conn == conn {
+				c.conn = nil
+			}
+			c.mu.Unlock()
+			continue
+		}
+		return res, err
+	}
+}
+
+// dotResult is the result of a query sent on a dotConn.
+type dotResult struct {
+	res []byte
+	err error
+}
+
+// dotConn is a DNS-over-TLS connection on which multiple queries may be
+// in flight at once.
+type dotConn struct {
+	tc *tls.Conn
+
+	wmu syncs.Mutex // serializes writes to tc
+
+	mu      syncs.Mutex
+	pending map[uint16]chan dotResult // by the txid sent on the wire
+	nextID  uint16
+	err     error // non-nil once closed
+}
+
+func newDoTConn(tc *tls.Conn) *dotConn {
+	c := &dotConn{
+		tc:      tc,
+		pending: map[uint16]chan dotResult{},
+	}
+	tc.SetReadDeadline(time.Now().Add(dotIdleConnTimeout))
+	go c.readLoop()
+	return c
+}
+
+func (c *dotConn) isClosed() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.err != nil
+}
+
+// closeWithError closes c, failing all in-flight queries with err.
+func (c *dotConn) closeWithError(err error) {
+	c.mu.Lock()
+	if c.err != nil {
+		c.mu.Unlock()
+		return
+	}
+	c.err = err
+	pending := c.pending
+	c.pending = nil
+	c.mu.Unlock()
+
+	c.tc.Close()
+	for _, ch := range pending {
+		ch <- dotResult{err: err}
+	}
+}
+
+// exchange sends packet on c and waits for the matching response.
+//
+// As queries from different clients may share a transaction ID, each
+// query is sent with an ID unique to c, and the response is rewritten to
+// carry the query's original ID.
+func (c *dotConn) exchange(ctx context.Context, packet []byte) ([]byte, error) {
+	if len(packet) < headerBytes || len(packet) > 0xffff {
+		return nil, fmt.Errorf("invalid DNS query length %d", len(packet))
+	}
+	ch := make(chan dotResult, 1)
+	c.mu.Lock()
+	if c.err != nil {
+		err := c.err
+		c.mu.Unlock()
+		return nil, err
+	}
+	var id uint16
+	for {
+		id = c.nextID
+		c.nextID++
+		if _, ok := c.pending[id]; !ok {
+			break
+		}
+	}
+	c.pending[id] = ch
+	c.mu.Unlock()
+	defer func() {
+		c.mu.Lock()
+		delete(c.pending, id)
+		c.mu.Unlock()
+	}()
+
+	msg := make([]byte, len(packet)+2)
+	binary.BigEndian.PutUint16(msg, uint16(len(packet)))
+	copy(msg[2:], packet)
+	binary.BigEndian.PutUint16(msg[2:], id)
+
+	// Keep the connection from idling out while we wait for a response.
+	c.tc.SetReadDeadline(time.Now().Add(dotIdleConnTimeout))
+
+	c.wmu.Lock()
+	c.tc.SetWriteDeadline(time.Now().Add(dotQueryTimeout))
+	_, err := c.tc.Write(msg)
+	c.wmu.Unlock()
+	if err != nil {
+		c.closeWithError(err)
+		return nil, err
+	}
+
+	select {
+	case r := <-ch:
+		if r.err != nil {
+			return nil, r.err
+		}
+		copy(r.res, packet[:2]) // restore the original txid
+		return r.res, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// readLoop reads responses from c and delivers them to the matching
+// in-flight queries until c is closed or idle for dotIdleConnTimeout.
+func (c *dotConn) readLoop() {
+	var lenBuf [2]byte
+	for {
+		c.mu.Lock()
+		idle := len(c.pending) == 0
+		c.mu.Unlock()
+		if idle {
+			c.tc.SetReadDeadline(time.Now().Add(dotIdleConnTimeout))
+		}
+		if _, err := io.ReadFull(c.tc, lenBuf[:]); err != nil {
+			c.closeWithError(err)
+			return
+		}
+		res := make([]byte, binary.BigEndian.Uint16(lenBuf[:]))
+		if _, err := io.ReadFull(c.tc, res); err != nil {
+			c.closeWithError(err)
+			return
+		}
+		if len(res) < headerBytes {
+			c.closeWithError(io.ErrUnexpectedEOF)
+			return
+		}
+		id := binary.BigEndian.Uint16(res)
+		c.mu.Lock()
+		ch, ok := c.pending[id]
+		if ok {
+			delete(c.pending, id)
+		}
+		c.mu.Unlock()
+		if !ok {
+			// Response to a query that was canceled, or a
+			// misbehaving server. Either way, ignore it.
+			metricDNSFwdDoTErrorTxID.Add(1)
+			continue
+		}
+		ch <- dotResult{res: res}
+	}
+}
+
+// sendDoT sends packet to the DNS-over-TLS resolver r.
+func (f *forwarder) sendDoT(ctx context.Context, r *dnstype.Resolver, packet []byte) ([]byte, error) {
+	ctx = sockstats.WithSockStats(ctx, sockstats.LabelDNSForwarderDoT, f.logf)
+	metricDNSFwdDoT.Add(1)
+	c, err := f.getDoTClient(r)
+	if err != nil {
+		metricDNSFwdErrorType.Add(1)
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, dotQueryTimeout)
+	defer cancel()
+	res, err := c.exchange(ctx, packet)
+	if err != nil {
+		metricDNSFwdDoTErrorTransport.Add(1)
+		return nil, err
+	}
+	if getRCode(res) == dns.RCodeServerFailure {
+		f.logf("sendDoT: response code indicating server failure")
+		metricDNSFwdDoTErrorServer.Add(1)
+		return nil, errServerFailure
+	}
+	if truncatedFlagSet(res) {
+		metricDNSFwdTruncated.Add(1)
+	}
+	metricDNSFwdDoTSuccess.Add(1)
+	return res, nil
+}
@@ -0,0 +1,282 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package resolver
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http/httptest"
+	"net/netip"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	dns "golang.org/x/net/dns/dnsmessage"
+	"tailscale.com/health"
+	"tailscale.com/net/netmon"
+	"tailscale.com/net/tsdial"
+	"tailscale.com/tstest"
+	"tailscale.com/types/dnstype"
+	"tailscale.com/util/eventbus/eventbustest"
+)
+
+func TestParseDoTAddr(t *testing.T) {
+	tests := []struct {
+		addr     string
+		wantHost string
+		wantPort uint16
+		wantErr  bool
+	}{
+		{addr: "tls://1.2.3.4", wantHost: "1.2.3.4", wantPort: 853},
+		{addr: "tls://1.2.3.4:8853", wantHost: "1.2.3.4", wantPort: 8853},
+		{addr: "tls://[2001:db8::1]", wantHost: "2001:db8::1", wantPort: 853},
+		{addr: "tls://dns.example.com/", wantHost: "dns.example.com", wantPort: 853},
+		{addr: "tls://", wantErr: true},
+		{addr: "tls://1.2.3.4:0", wantErr: true},
+		{addr: "tls://1.2.3.4:99999", wantErr: true},
+		{addr: "tls://1.2.3.4/dns-query", wantErr: true},
+		{addr: "tls://user@1.2.3.4", wantErr: true},
+		{addr: "https://1.2.3.4", wantErr: true},
+	}
+	for _, tt := range tests {
+		host, port, err := parseDoTAddr(tt.addr)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseDoTAddr(%q) error = %v; wantErr %v", tt.addr, err, tt.wantErr)
+			continue
+		}
+		if host != tt.wantHost || port != tt.wantPort {
+			t.Errorf("parseDoTAddr(%q) = %q, %d; want %q, %d", tt.addr, host, port, tt.wantHost, tt.wantPort)
+		}
+	}
+}
+
+// dotTestServer is a local stand-in for a DNS-over-TLS resolver. It answers
+// A queries with 127.0.0.1, and answers pipelined queries in reverse order of
+// arrival when they arrive together.
+type dotTestServer struct {
+	port  uint16
+	roots *x509.CertPool
+
+	conns   atomic.Int32 // number of accepted connections
+	queries atomic.Int32 // number of queries answered
+
+	// closeAfter, if non-zero, is the number of queries after which
+	// the server closes each connection.
+	closeAfter int
+}
+
+func newDoTTestServer(t *testing.T, closeAfter int) *dotTestServer {
+	// httptest's TLS certificate is valid for 127.0.0.1, ::1 and
+	// example.com.
+	hs := httptest.NewUnstartedServer(nil)
+	hs.StartTLS()
+	tlsConf := hs.TLS.Clone()
+	tlsConf.NextProtos = nil
+	roots := x509.NewCertPool()
+	roots.AddCert(hs.Certificate())
+	hs.Close()
+
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", tlsConf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &dotTestServer{
+		port:       uint16(ln.Addr().(*net.TCPAddr).Port),
+		roots:      roots,
+		closeAfter: closeAfter,
+	}
+	var wg sync.WaitGroup
+	wg.Go(func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			s.conns.Add(1)
+			wg.Go(func() { s.serveConn(t, c) })
+		}
+	})
+	t.Cleanup(func() {
+		ln.Close()
+		wg.Wait()
+	})
+	return s
+}
+
+func (s *dotTestServer) serveConn(t *testing.T, c net.Conn) {
+	defer c.Close()
+	c.SetDeadline(time.Now().Add(10 * time.Second))
+	var wmu sync.Mutex
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	for n := 0; s.closeAfter == 0 || n < s.closeAfter; n++ {
+		var lenBuf [2]byte
+		if _, err := io.ReadFull(c, lenBuf[:]); err != nil {
+			return
+		}
+		q := make([]byte, binary.BigEndian.Uint16(lenBuf[:]))
+		if _, err := io.ReadFull(c, q); err != nil {
+			return
+		}
+		var msg dns.Message
+		if err := msg.Unpack(q); err != nil || len(msg.Questions) != 1 {
+			t.Errorf("bad query: %v", err)
+			return
+		}
+		msg.Response = true
+		msg.Answers = []dns.Resource{{
+			Header: dns.ResourceHeader{
+				Name:  msg.Questions[0].Name,
+				Type:  dns.TypeA,
+				Class: dns.ClassINET,
+				TTL:   60,
+			},
+			Body: &dns.AResource{A: [4]byte{127, 0, 0, 1}},
+		}}
+		packed, err := msg.Pack()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		res := binary.BigEndian.AppendUint16(nil, uint16(len(packed)))
+		res = append(res, packed...)
+
+		// Delay responses to earlier queries, so that pipelined
+		// queries are answered out of order.
+		delay := time.Duration(max(0, 10-n)) * 5 * time.Millisecond
+		if s.closeAfter != 0 {
+			delay = 0
+		}
+		wg.Go(func() {
+			time.Sleep(delay)
+			wmu.Lock()
+			defer wmu.Unlock()
+			c.Write(res)
+			s.queries.Add(1)
+		})
+	}
+}
+
+func newDoTTestForwarder(t *testing.T, roots *x509.CertPool) *forwarder {
+	logf := tstest.WhileTestRunningLogger(t)
+	bus := eventbustest.NewBus(t)
+	netMon, err := netmon.New(bus, logf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var dialer tsdial.Dialer
+	dialer.SetNetMon(netMon)
+	dialer.SetBus(bus)
+	fwd := newForwarder(logf, netMon, nil, &dialer, health.NewTracker(bus), nil)
+	fwd.cache = nil // always go upstream
+	fwd.dotRootCAs = roots
+	t.Cleanup(func() { fwd.Close() })
+	return fwd
+}
+
+// queryDoT sends an A query for domain via fwd to the resolver r, and
+// checks that the response answers it.
+func queryDoT(ctx context.Context, fwd *forwarder, r *dnstype.Resolver, domain string) error {
+	b := dns.NewBuilder(nil, dns.Header{ID: 1234, RecursionDesired: true})
+	b.StartQuestions()
+	b.Question(dns.Question{Name: dns.MustNewName(domain), Type: dns.TypeA, Class: dns.ClassINET})
+	q, err := b.Finish()
+	if err != nil {
+		return err
+	}
+	rchan := make(chan packet, 1)
+	pkt := packet{bs: q, family: "udp", addr: netip.MustParseAddrPort("127.0.0.1:12345")}
+	if err := fwd.forwardWithDestChan(ctx, pkt, rchan, resolverAndDelay{name: r}); err != nil {
+		return err
+	}
+	var msg dns.Message
+	if err := msg.Unpack((<-rchan).bs); err != nil {
+		return err
+	}
+	if msg.ID != 1234 {
+		return fmt.Errorf("response ID = %d; want 1234", msg.ID)
+	}
+	if len(msg.Answers) != 1 || msg.Answers[0].Header.Name.String() != domain {
+		return fmt.Errorf("response for %q has answers %v", domain, msg.Answers)
+	}
+	return nil
+}
+
+func TestForwarderDoT(t *testing.T) {
+	srv := newDoTTestServer(t, 0)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	t.Run("pipelined", func(t *testing.T) {
+		fwd := newDoTTestForwarder(t, srv.roots)
+		r := &dnstype.Resolver{Addr: fmt.Sprintf("tls://127.0.0.1:%d", srv.port)}
+
+		// Warm up the connection, so the queries below are pipelined
+		// over it.
+		if err := queryDoT(ctx, fwd, r, "warmup.example.com."); err != nil {
+			t.Fatal(err)
+		}
+		var wg sync.WaitGroup
+		for i := range 10 {
+			wg.Go(func() {
+				// All queries have the same txid, as they might
+				// when coming from different clients.
+				if err := queryDoT(ctx, fwd, r, fmt.Sprintf("q%d.example.com.", i)); err != nil {
+					t.Error(err)
+				}
+			})
+		}
+		wg.Wait()
+		if n := srv.conns.Load(); n != 1 {
+			t.Errorf("server accepted %d connections; want 1", n)
+		}
+	})
+
+	t.Run("bootstrap", func(t *testing.T) {
+		fwd := newDoTTestForwarder(t, srv.roots)
+		r := &dnstype.Resolver{
+			Addr:                fmt.Sprintf("tls://example.com:%d", srv.port),
+			BootstrapResolution: []netip.Addr{netip.MustParseAddr("127.0.0.1")},
+		}
+		if err := queryDoT(ctx, fwd, r, "bootstrap.example.com."); err != nil {
+			t.Fatal(err)
+		}
+		r = &dnstype.Resolver{Addr: fmt.Sprintf("tls://example.com:%d", srv.port)}
+		if err := queryDoT(ctx, fwd, r, "nobootstrap.example.com."); err == nil {
+			t.Fatal("unexpected success without BootstrapResolution")
+		}
+	})
+
+	t.Run("untrusted-cert", func(t *testing.T) {
+		fwd := newDoTTestForwarder(t, nil)
+		r := &dnstype.Resolver{Addr: fmt.Sprintf("tls://127.0.0.1:%d", srv.port)}
+		if err := queryDoT(ctx, fwd, r, "untrusted.example.com."); err == nil {
+			t.Fatal("unexpected success with untrusted certificate")
+		}
+	})
+}
+
+func TestForwarderDoTReconnect(t *testing.T) {
+	// The server closes each connection after one query.
+	srv := newDoTTestServer(t, 1)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	fwd := newDoTTestForwarder(t, srv.roots)
+	r := &dnstype.Resolver{Addr: fmt.Sprintf("tls://127.0.0.1:%d", srv.port)}
+	for i := range 3 {
+		if err := queryDoT(ctx, fwd, r, fmt.Sprintf("r%d.example.com.", i)); err != nil {
+			t.Fatalf("query %d: %v", i, err)
+		}
+	}
+	if n := srv.conns.Load(); n != 3 {
+		t.Errorf("server accepted %d connections; want 3", n)
+	}
+}
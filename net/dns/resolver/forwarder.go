@@ -8,6 +8,7 @@
 	"context"
 	"crypto/sha256"
 	"crypto/tls"
+	"crypto/x509"
 	"encoding/base64"
 	"encoding/binary"
 	"errors"
@@ -44,6 +45,7 @@
 	"tailscale.com/util/cloudenv"
 	"tailscale.com/util/dnsname"
 	"tailscale.com/util/race"
+	"tailscale.com/util/set"
 	"tailscale.com/version"
 )
 
@@ -277,6 +279,22 @@ func checkResponseSizeAndSetTC(response []byte, request []byte, family string, l
 type route struct {
 	Suffix    dnsname.FQDN
 	Resolvers []resolverAndDelay
+
+	// cachePartition is the response cache partition for queries
+	// resolved via this route. See cachePartition.
+	cachePartition string
+}
+
+// cachePartition returns the response cache partition for queries sent to
+// resolvers via the route with the given suffix.
+func cachePartition(suffix dnsname.FQDN, resolvers []resolverAndDelay) string {
+	var sb strings.Builder
+	sb.WriteString(string(suffix))
+	for _, r := range resolvers {
+		sb.WriteByte(' ')
+		sb.WriteString(r.name.Addr)
+	}
+	return sb.String()
 }
 
 // resolverAndDelay is an upstream DNS resolver and a delay for how
@@ -300,15 +318,22 @@ type forwarder struct {
 	dialer     *tsdial.Dialer
 	health     *health.Tracker // always non-nil
 	verboseFwd bool            // if true, log all DNS forwarding
+	cache      *responseCache  // or nil if caching is disabled
 
 	controlKnobs *controlknobs.Knobs // or nil
 
+	// dotRootCAs, if non-nil, are the root CAs used to verify
+	// DNS-over-TLS resolvers' certificates instead of the system roots.
+	// It's only set by tests.
+	dotRootCAs *x509.CertPool
+
 	ctx       context.Context    // good until Close
 	ctxCancel context.CancelFunc // closes ctx
 
 	mu syncs.Mutex // guards following
 
-	dohClient map[string]*http.Client // urlBase -> client
+	dohClient  map[string]*http.Client // urlBase -> client
+	dotClients map[string]*dotClient   // resolver Addr -> client
 
 	// routes are per-suffix resolvers to use, with
 	// the most specific routes first.
@@ -341,12 +366,22 @@ func newForwarder(logf logger.Logf, netMon *netmon.Monitor, linkSel ForwardLinkS
 		controlKnobs: knobs,
 		verboseFwd:   verboseDNSForward(),
 	}
+	if !disableDNSCache() {
+		f.cache = newResponseCache()
+	}
 	f.ctx, f.ctxCancel = context.WithCancel(context.Background())
 	return f
 }
 
 func (f *forwarder) Close() error {
 	f.ctxCancel()
+	f.mu.Lock()
+	dotClients := f.dotClients
+	f.dotClients = nil
+	f.mu.Unlock()
+	for _, c := range dotClients {
+		c.close()
+	}
 	return nil
 }
 
@@ -465,13 +500,41 @@ func (f *forwarder) setRoutes(routesBySuffix map[dnsname.FQDN][]*dnstype.Resolve
 	sort.Slice(routes, func(i, j int) bool {
 		return routes[i].Suffix.NumLabels() > routes[j].Suffix.NumLabels()
 	})
+	for i := range routes {
+		routes[i].cachePartition = cachePartition(routes[i].Suffix, routes[i].Resolvers)
+	}
 
 	f.mu.Lock()
 	defer f.mu.Unlock()
+	if f.cache != nil && !sameCachePartitions(f.routes, routes) {
+		// Cached responses were resolved by upstreams that may no longer
+		// be in use, and whose answers are stale by the time they're in
+		// use again, such as a LAN resolver on a network that's since been
+		// left and rejoined.
+		f.cache.flush()
+	}
 	f.routes = routes
 	f.cloudHostFallback = cloudHostFallback
 }
 
+// sameCachePartitions reports whether a and b use the same set of response
+// cache partitions.
+func sameCachePartitions(a, b []route) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	parts := make(set.Set[string], len(a))
+	for _, r := range a {
+		parts.Add(r.cachePartition)
+	}
+	for _, r := range b {
+		if !parts.Contains(r.cachePartition) {
+			return false
+		}
+	}
+	return true
+}
+
 var stdNetPacketListener nettype.PacketListenerWithNetIP = nettype.MakePacketListenerWithNetIP(new(net.ListenConfig))
 
 func (f *forwarder) packetListener(ip netip.Addr) (nettype.PacketListenerWithNetIP, error) {
@@ -638,8 +701,13 @@ func (f *forwarder) send(ctx context.Context, fq *forwardQuery, rr resolverAndDe
 		return nil, fmt.Errorf("arbitrary https:// resolvers not supported yet")
 	}
 	if strings.HasPrefix(rr.name.Addr, "tls://") {
-		metricDNSFwdErrorType.Add(1)
-		return nil, fmt.Errorf("tls:// resolvers not supported yet")
+		res, err := f.sendDoT(ctx, rr.name, fq.packet)
+		if err != nil {
+			return nil, err
+		}
+		// Check response size and set TC flag if needed (only for UDP queries)
+		res = checkResponseSizeAndSetTC(res, fq.packet, fq.family, f.logf)
+		return res, nil
 	}
 
 	ctx, cancel := context.WithCancel(ctx)
@@ -959,16 +1027,26 @@ func (f *forwarder) sendTCP(ctx context.Context, fq *forwardQuery, rr resolverAn
 
 // resolvers returns the resolvers to use for domain.
 func (f *forwarder) resolvers(domain dnsname.FQDN) []resolverAndDelay {
+	return f.route(domain).Resolvers
+}
+
+// route returns the route to use for domain. If no route matches, it
+// returns a route with an empty Suffix and the cloud host fallback
+// resolvers, if any.
+func (f *forwarder) route(domain dnsname.FQDN) route {
 	f.mu.Lock()
 	routes := f.routes
 	cloudHostFallback := f.cloudHostFallback
 	f.mu.Unlock()
 	for _, route := range routes {
 		if route.Suffix == "." || route.Suffix.Contains(domain) {
-			return route.Resolvers
+			return route
 		}
 	}
-	return cloudHostFallback // or nil if no fallback
+	return route{
+		Resolvers:      cloudHostFallback, // or nil if no fallback
+		cachePartition: cachePartition("", cloudHostFallback),
+	}
 }
 
 // GetUpstreamResolvers returns the resolvers that would be used to resolve
@@ -1008,6 +1086,12 @@ type forwardQuery struct {
 	// ...
 }
 
+// upstreamResponse is a response from an upstream resolver.
+type upstreamResponse struct {
+	res      []byte
+	upstream string // the resolver's Addr
+}
+
 // forwardWithDestChan forwards the query to all upstream nameservers
 // and waits for the first response.
 //
@@ -1052,8 +1136,15 @@ func (f *forwarder) forwardWithDestChan(ctx context.Context, query packet, respo
 
 	clampEDNSSize(query.bs, maxResponseBytes)
 
+	qi := queryLogInfoKey.Value(ctx)
+	var routeSuffix dnsname.FQDN
+	var partition string
 	if len(resolvers) == 0 {
-		resolvers = f.resolvers(domain)
+		rt := f.route(domain)
+		resolvers, routeSuffix, partition = rt.Resolvers, rt.Suffix, rt.cachePartition
+		if qi != nil {
+			qi.route = string(routeSuffix)
+		}
 		if len(resolvers) == 0 {
 			metricDNSFwdErrorNoUpstream.Add(1)
 			f.health.SetUnhealthy(dnsForwarderFailing, health.Args{health.ArgDNSServers: ""})
@@ -1072,6 +1163,35 @@ func (f *forwarder) forwardWithDestChan(ctx context.Context, query packet, respo
 		} else {
 			f.health.SetHealthy(dnsForwarderFailing)
 		}
+	} else {
+		partition = cachePartition("", resolvers)
+	}
+
+	var ck cacheKey
+	var cacheable bool
+	if f.cache != nil {
+		ck, cacheable = cacheKeyForQuery(partition, query.bs)
+	}
+	if cacheable {
+		if res, ok := f.cache.get(ck, query.bs); ok {
+			metricDNSFwdCacheHit.Add(1)
+			// The response may have been cached from a TCP query, or
+			// one with a larger EDNS buffer size, than this one.
+			res = checkResponseSizeAndSetTC(res, query.bs, query.family, f.logf)
+			if qi != nil {
+				qi.cached = true
+			}
+			select {
+			case <-ctx.Done():
+				return fmt.Errorf("waiting to send cached response: %w", ctx.Err())
+			case responseChan <- packet{res, query.family, query.addr}:
+				if f.verboseFwd {
+					f.logf("cached response(%v, %d) = %d", typ, len(domain), len(res))
+				}
+				return nil
+			}
+		}
+		metricDNSFwdCacheMiss.Add(1)
 	}
 
 	fq := &forwardQuery{
@@ -1089,8 +1209,8 @@ func (f *forwarder) forwardWithDestChan(ctx context.Context, query packet, respo
 		f.logf("request(%d, %v, %d, %s) %d...", fq.txid, typ, len(domain), domainSig, len(fq.packet))
 	}
 
-	resc := make(chan []byte, 1) // it's fine buffered or not
-	errc := make(chan error, 1)  // it's fine buffered or not too
+	resc := make(chan upstreamResponse, 1) // it's fine buffered or not
+	errc := make(chan error, 1)            // it's fine buffered or not too
 	for i := range resolvers {
 		go func(rr *resolverAndDelay) {
 			if rr.startDelay > 0 {
@@ -1112,7 +1232,7 @@ func (f *forwarder) forwardWithDestChan(ctx context.Context, query packet, respo
 				return
 			}
 			select {
-			case resc <- resb:
+			case resc <- upstreamResponse{resb, rr.name.Addr}:
 			case <-ctx.Done():
 			}
 		}(&resolvers[i])
@@ -1122,7 +1242,8 @@ func (f *forwarder) forwardWithDestChan(ctx context.Context, query packet, respo
 	var numErr int
 	for {
 		select {
-		case v := <-resc:
+		case ur := <-resc:
+			v := ur.res
 			select {
 			case <-ctx.Done():
 				metricDNSFwdErrorContext.Add(1)
@@ -1133,6 +1254,12 @@ func (f *forwarder) forwardWithDestChan(ctx context.Context, query packet, respo
 				}
 				metricDNSFwdSuccess.Add(1)
 				f.health.SetHealthy(dnsForwarderFailing)
+				if qi != nil {
+					qi.upstream = ur.upstream
+				}
+				if cacheable {
+					f.cache.put(ck, string(routeSuffix), v)
+				}
 				return nil
 			}
 		case err := <-errc:
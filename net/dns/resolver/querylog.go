@@ -0,0 +1,95 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package resolver
+
+import (
+	"context"
+	"net/netip"
+	"time"
+
+	dns "golang.org/x/net/dns/dnsmessage"
+	"tailscale.com/util/ctxkey"
+)
+
+// QueryLogEntry is a record of a DNS query answered by a Resolver.
+type QueryLogEntry struct {
+	Time     time.Time      // when the query was received
+	Source   netip.AddrPort // address the query came from
+	FromPeer bool           // whether the query came from a peer via the PeerAPI, rather than from this device
+	Name     string         // query name; empty if the query couldn't be parsed
+	Type     dns.Type       // query type
+
+	// Forwarded is whether the query was forwarded to an upstream
+	// resolver (or answered from the forwarder's cache), rather than
+	// answered by the Resolver itself.
+	Forwarded bool
+	Route     string // DNS route suffix the query was forwarded via, if any
+	Upstream  string // upstream resolver that answered, if any
+	Cached    bool   // whether the response came from the forwarder's cache
+
+	RCode   dns.RCode     // response code; only valid if Err is nil
+	Err     error         // non-nil if no response could be produced
+	Latency time.Duration // time taken to produce the response
+}
+
+// QueryLogger is a function that is called with a record of each DNS query
+// answered by a Resolver. It is called synchronously on the query's
+// goroutine, so it must not block. It must not retain e.
+type QueryLogger func(e *QueryLogEntry)
+
+// queryLogInfo is what the forwarder learns about a query that's needed for
+// its QueryLogEntry.
+type queryLogInfo struct {
+	route    string
+	upstream string
+	cached   bool
+}
+
+// queryLogInfoKey is the context key for the *queryLogInfo of a query
+// being logged. It's only set if a QueryLogger is installed.
+var queryLogInfoKey = ctxkey.New[*queryLogInfo]("resolver.queryLogInfo", nil)
+
+// SetQueryLogger sets the function to call with a record of each DNS query
+// the Resolver answers. A nil logger disables query logging.
+func (r *Resolver) SetQueryLogger(logger QueryLogger) {
+	if logger == nil {
+		r.queryLogger.Store(nil)
+		return
+	}
+	r.queryLogger.Store(&logger)
+}
+
+// startQueryLog returns a context to use for the query bs from src and a
+// function to call with the query's response and error when it's done. If
+// query logging is disabled, it returns ctx and a no-op func.
+func (r *Resolver) startQueryLog(ctx context.Context, bs []byte, src netip.AddrPort, fromPeer bool) (context.Context, func(res []byte, err error)) {
+	lp := r.queryLogger.Load()
+	if lp == nil {
+		return ctx, func([]byte, error) {}
+	}
+	start := time.Now()
+	qi := new(queryLogInfo)
+	ctx = queryLogInfoKey.WithValue(ctx, qi)
+	return ctx, func(res []byte, err error) {
+		e := &QueryLogEntry{
+			Time:     start,
+			Source:   src,
+			FromPeer: fromPeer,
+			Route:    qi.route,
+			Upstream: qi.upstream,
+			Cached:   qi.cached,
+			Err:      err,
+			Latency:  time.Since(start),
+		}
+		e.Forwarded = e.Upstream != "" || e.Cached || e.Route != ""
+		if name, typ, err := nameFromQuery(bs); err == nil {
+			e.Name = string(name)
+			e.Type = typ
+		}
+		if err == nil {
+			e.RCode = getRCode(res)
+		}
+		(*lp)(e)
+	}
+}
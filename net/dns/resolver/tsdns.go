@@ -20,6 +20,7 @@
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	dns "golang.org/x/net/dns/dnsmessage"
@@ -217,6 +218,8 @@ type Resolver struct {
 	// forwarder forwards requests to upstream nameservers.
 	forwarder *forwarder
 
+	queryLogger atomic.Pointer[QueryLogger] // or nil if query logging is disabled
+
 	// closed signals all goroutines to stop.
 	closed chan struct{}
 
@@ -312,7 +315,7 @@ func (r *Resolver) Close() {
 // bound on per-query resource usage.
 const dnsQueryTimeout = 10 * time.Second
 
-func (r *Resolver) Query(ctx context.Context, bs []byte, family string, from netip.AddrPort) ([]byte, error) {
+func (r *Resolver) Query(ctx context.Context, bs []byte, family string, from netip.AddrPort) (_ []byte, err error) {
 	if !buildfeatures.HasDNS {
 		return nil, feature.ErrUnavailable
 	}
@@ -324,7 +327,11 @@ func (r *Resolver) Query(ctx context.Context, bs []byte, family string, from net
 	default:
 	}
 
-	out, err := r.respond(bs)
+	ctx, logQuery := r.startQueryLog(ctx, bs, from, false)
+	var out []byte
+	defer func() { logQuery(out, err) }()
+
+	out, err = r.respond(bs)
 	if err == errNotOurName {
 		responses := make(chan packet, 1)
 		ctx, cancel := context.WithTimeout(ctx, dnsQueryTimeout)
@@ -334,7 +341,8 @@ func (r *Resolver) Query(ctx context.Context, bs []byte, family string, from net
 		if err != nil {
 			return nil, err
 		}
-		return (<-responses).bs, nil
+		out = (<-responses).bs
+		return out, nil
 	}
 
 	if err != nil {
@@ -354,6 +362,43 @@ func (r *Resolver) GetUpstreamResolvers(name dnsname.FQDN) []*dnstype.Resolver {
 	return r.forwarder.GetUpstreamResolvers(name)
 }
 
+// CacheStats returns statistics about the cache of forwarded DNS responses.
+// It reports false if the cache is disabled.
+func (r *Resolver) CacheStats() (_ CacheStats, ok bool) {
+	if !buildfeatures.HasDNS || r.forwarder.cache == nil {
+		return CacheStats{}, false
+	}
+	return r.forwarder.cache.stats(), true
+}
+
+// CacheEntries returns the unexpired cached responses to forwarded DNS
+// queries, most recently used first.
+func (r *Resolver) CacheEntries() []CacheEntry {
+	if !buildfeatures.HasDNS || r.forwarder.cache == nil {
+		return nil
+	}
+	return r.forwarder.cache.list()
+}
+
+// FlushCache removes all cached responses to forwarded DNS queries.
+func (r *Resolver) FlushCache() {
+	if !buildfeatures.HasDNS || r.forwarder.cache == nil {
+		return
+	}
+	r.forwarder.cache.flush()
+}
+
+// LinkChanged is called when the network changes. If the device may have
+// moved to another network, it flushes the cache of forwarded DNS
+// responses, whose upstream resolvers, such as the LAN resolver, may be
+// different ones at the same IPs.
+func (r *Resolver) LinkChanged(delta *netmon.ChangeDelta) {
+	if delta.IsInitialState || !delta.RebindLikelyRequired {
+		return
+	}
+	r.FlushCache()
+}
+
 // parseExitNodeQuery parses a DNS request packet.
 // It returns nil if it's malformed or lacking a question.
 func parseExitNodeQuery(q []byte) *response {
@@ -383,6 +428,8 @@ func (r *Resolver) HandlePeerDNSQuery(ctx context.Context, q []byte, from netip.
 		return nil, feature.ErrUnavailable
 	}
 	metricDNSExitProxyQuery.Add(1)
+	ctx, logQuery := r.startQueryLog(ctx, q, from, true)
+	defer func() { logQuery(res, err) }()
 	ch := make(chan packet, 1)
 
 	resp := parseExitNodeQuery(q)
@@ -1393,6 +1440,8 @@ func unARPA(a string) (ipStr string, ok bool) {
 	metricDNSFwdErrorName            = clientmetric.NewCounter("dns_query_fwd_error_name")
 	metricDNSFwdErrorNoUpstream      = clientmetric.NewCounter("dns_query_fwd_error_no_upstream")
 	metricDNSFwdSuccess              = clientmetric.NewCounter("dns_query_fwd_success")
+	metricDNSFwdCacheHit             = clientmetric.NewCounter("dns_query_fwd_cache_hit")
+	metricDNSFwdCacheMiss            = clientmetric.NewCounter("dns_query_fwd_cache_miss")
 	metricDNSFwdErrorContext         = clientmetric.NewCounter("dns_query_fwd_error_context")
 	metricDNSFwdErrorContextGotError = clientmetric.NewCounter("dns_query_fwd_error_context_got_error")
 
@@ -1415,6 +1464,14 @@ func unARPA(a string) (ipStr string, ok bool) {
 	metricDNSFwdTCPErrorRead   = clientmetric.NewCounter("dns_query_fwd_tcp_error_read")
 	metricDNSFwdTCPSuccess     = clientmetric.NewCounter("dns_query_fwd_tcp_success")
 
+	metricDNSFwdDoT               = clientmetric.NewCounter("dns_query_fwd_dot")
+	metricDNSFwdDoTSuccess        = clientmetric.NewCounter("dns_query_fwd_dot_success")
+	metricDNSFwdDoTRetry          = clientmetric.NewCounter("dns_query_fwd_dot_retry")
+	metricDNSFwdDoTErrorDial      = clientmetric.NewCounter("dns_query_fwd_dot_error_dial")
+	metricDNSFwdDoTErrorTransport = clientmetric.NewCounter("dns_query_fwd_dot_error_transport")
+	metricDNSFwdDoTErrorServer    = clientmetric.NewCounter("dns_query_fwd_dot_error_server")
+	metricDNSFwdDoTErrorTxID      = clientmetric.NewCounter("dns_query_fwd_dot_error_txid")
+
 	metricDNSFwdDoH               = clientmetric.NewCounter("dns_query_fwd_doh")
 	metricDNSFwdDoHErrorStatus    = clientmetric.NewCounter("dns_query_fwd_doh_error_status")
 	metricDNSFwdDoHErrorCT        = clientmetric.NewCounter("dns_query_fwd_doh_error_content_type")
@@ -1671,3 +1671,62 @@ func TestLocalResponseTCFlagIntegration(t *testing.T) {
 		})
 	}
 }
+
+func TestQueryLog(t *testing.T) {
+	server := serveDNS(t, "127.0.0.1:0",
+		"test.site.", resolveToIP(testipv4, testipv6, "dns.test.site."))
+	defer server.Shutdown()
+	upstream := server.PacketConn.LocalAddr().String()
+
+	r := newResolver(t)
+	defer r.Close()
+	cfg := dnsCfg
+	cfg.Routes = map[dnsname.FQDN][]*dnstype.Resolver{
+		"site.": {{Addr: upstream}},
+	}
+	r.SetConfig(cfg)
+
+	var got []QueryLogEntry
+	r.SetQueryLogger(func(e *QueryLogEntry) { got = append(got, *e) })
+
+	from := netip.MustParseAddrPort("100.101.102.103:5353")
+	queries := []struct {
+		name dnsname.FQDN
+		typ  dns.Type
+	}{
+		{"test1.ipn.dev.", dns.TypeA},
+		{"test.site.", dns.TypeA},
+		{"nope.ipn.dev.", dns.TypeAAAA},
+	}
+	for _, q := range queries {
+		if _, err := r.Query(context.Background(), dnspacket(q.name, q.typ, noEdns), "udp", from); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	r.SetQueryLogger(nil)
+	if _, err := syncRespond(r, dnspacket("test1.ipn.dev.", dns.TypeA, noEdns)); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(got) != len(queries) {
+		t.Fatalf("got %d log entries; want %d", len(got), len(queries))
+	}
+	for i, e := range got {
+		if e.Source != from || e.FromPeer || e.Err != nil || e.Time.IsZero() {
+			t.Errorf("entry %d = %+v; want successful query from %v", i, e, from)
+		}
+		if e.Name != string(queries[i].name) || e.Type != queries[i].typ {
+			t.Errorf("entry %d is for %v %v; want %v %v", i, e.Name, e.Type, queries[i].name, queries[i].typ)
+		}
+	}
+	if e := got[0]; e.Forwarded || e.RCode != dns.RCodeSuccess {
+		t.Errorf("local answer logged as %+v", e)
+	}
+	if e := got[1]; !e.Forwarded || e.Route != "site." || e.Upstream != upstream || e.Cached {
+		t.Errorf("forwarded query logged as %+v; want route site. via %v", e, upstream)
+	}
+	if e := got[2]; e.Forwarded || e.RCode != dns.RCodeNameError {
+		t.Errorf("NXDOMAIN logged as %+v", e)
+	}
+}
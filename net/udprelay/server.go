@@ -25,6 +25,7 @@
 	"go4.org/mem"
 	"golang.org/x/crypto/blake2s"
 	"golang.org/x/net/ipv6"
+	"golang.org/x/time/rate"
 	"tailscale.com/disco"
 	"tailscale.com/net/batching"
 	"tailscale.com/net/netaddr"
@@ -66,25 +67,29 @@
 // Server implements an experimental UDP relay server.
 type Server struct {
 	// The following fields are initialized once and never mutated.
-	logf                logger.Logf
-	disco               key.DiscoPrivate
-	discoPublic         key.DiscoPublic
+	logf        logger.Logf
+	disco       key.DiscoPrivate
+	discoPublic key.DiscoPublic
+	bus         *eventbus.Bus
+	uc4         []batching.Conn // length is always nonzero
+	uc4Port     uint16          // always nonzero
+	uc6         []batching.Conn // length may be zero if udp6 bind fails
+	uc6Port     uint16          // zero if len(uc6) is zero, otherwise nonzero
+	closeOnce   sync.Once
+	wg          sync.WaitGroup
+	closeCh     chan struct{}
+	gcResetCh   chan struct{} // signals endpointGCLoop that the lifetimes changed
+	netChecker  *netcheck.Client
+	metrics     *metrics
+	netMon      *netmon.Monitor
+	cloudInfo   *cloudinfo.CloudInfo // used to query cloud metadata services
+
+	mu                  sync.Mutex // guards the following fields
 	bindLifetime        time.Duration
 	steadyStateLifetime time.Duration
-	bus                 *eventbus.Bus
-	uc4                 []batching.Conn // length is always nonzero
-	uc4Port             uint16          // always nonzero
-	uc6                 []batching.Conn // length may be zero if udp6 bind fails
-	uc6Port             uint16          // zero if len(uc6) is zero, otherwise nonzero
-	closeOnce           sync.Once
-	wg                  sync.WaitGroup
-	closeCh             chan struct{}
-	netChecker          *netcheck.Client
-	metrics             *metrics
-	netMon              *netmon.Monitor
-	cloudInfo           *cloudinfo.CloudInfo // used to query cloud metadata services
-
-	mu                  sync.Mutex                      // guards the following fields
+	policy              status.ServerPolicy             // set with [Server.SetPolicy]
+	deniedByPolicy      uint64                          // allocations denied by policy.AllowTags/AllowUsers
+	deniedByQuota       uint64                          // allocations denied by policy.MaxEndpoints
 	macSecrets          views.Slice[[blake2s.Size]byte] // [0] is most recent, max 2 elements
 	macSecretRotatedAt  mono.Time
 	derpMap             *tailcfg.DERPMap
@@ -126,8 +131,12 @@ type serverEndpoint struct {
 	inProgressGeneration [2]uint32         // or zero if a handshake has never started, or has just completed
 	boundAddrPorts       [2]netip.AddrPort // or zero value if a handshake has never completed for that relay leg
 	lastSeen             [2]mono.Time
-	packetsRx            [2]uint64 // num packets received from/sent by each client after they are bound
-	bytesRx              [2]uint64 // num bytes received from/sent by each client after they are bound
+	packetsRx            [2]uint64        // num packets received from/sent by each client after they are bound
+	bytesRx              [2]uint64        // num bytes received from/sent by each client after they are bound
+	packetLimiters       [2]*rate.Limiter // per-client packet rate limits, or nil if unlimited
+	byteLimiters         [2]*rate.Limiter // per-client byte rate limits, or nil if unlimited
+	packetsDropped       [2]uint64        // num packets from each client dropped by rate limits
+	bytesDropped         [2]uint64        // num bytes from each client dropped by rate limits
 }
 
 func blakeMACFromBindMsg(blakeKey [blake2s.Size]byte, src netip.AddrPort, msg disco.BindUDPRelayEndpointCommon) ([blake2s.Size]byte, error) {
@@ -298,11 +307,17 @@ func (e *serverEndpoint) handleDataPacket(from netip.AddrPort, b []byte, now mon
 	}
 	switch {
 	case from == e.boundAddrPorts[0]:
+		if !e.allowLocked(0, len(b)) {
+			return nil, netip.AddrPort{}
+		}
 		e.lastSeen[0] = now
 		e.packetsRx[0]++
 		e.bytesRx[0] += uint64(len(b))
 		return b, e.boundAddrPorts[1]
 	case from == e.boundAddrPorts[1]:
+		if !e.allowLocked(1, len(b)) {
+			return nil, netip.AddrPort{}
+		}
 		e.lastSeen[1] = now
 		e.packetsRx[1]++
 		e.bytesRx[1] += uint64(len(b))
@@ -313,6 +328,49 @@ func (e *serverEndpoint) handleDataPacket(from netip.AddrPort, b []byte, now mon
 	}
 }
 
+// allowLocked reports whether a data packet of n bytes from the client at
+// index i is within the client's rate limits, counting it as dropped if
+// not.
+func (e *serverEndpoint) allowLocked(i, n int) bool {
+	pl, bl := e.packetLimiters[i], e.byteLimiters[i]
+	if pl == nil && bl == nil {
+		return true
+	}
+	now := time.Now()
+	// Check both limits before taking from either, so that a packet
+	// dropped by one limit doesn't count against the other.
+	if (pl != nil && pl.TokensAt(now) < 1) || (bl != nil && bl.TokensAt(now) < float64(n)) {
+		e.packetsDropped[i]++
+		e.bytesDropped[i] += uint64(n)
+		return false
+	}
+	if pl != nil {
+		pl.AllowN(now, 1)
+	}
+	if bl != nil {
+		bl.AllowN(now, n)
+	}
+	return true
+}
+
+// setRateLimits sets the per-client rate limits of e according to p.
+func (e *serverEndpoint) setRateLimits(p status.ServerPolicy) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for i := range e.packetLimiters {
+		e.packetLimiters[i] = nil
+		if p.MaxPacketsPerSecond > 0 {
+			e.packetLimiters[i] = rate.NewLimiter(rate.Limit(p.MaxPacketsPerSecond), int(p.MaxPacketsPerSecond))
+		}
+		e.byteLimiters[i] = nil
+		if p.MaxBytesPerSecond > 0 {
+			// Allow a burst of at least one maximum-sized packet.
+			burst := max(p.MaxBytesPerSecond, 1<<16-1)
+			e.byteLimiters[i] = rate.NewLimiter(rate.Limit(p.MaxBytesPerSecond), int(burst))
+		}
+	}
+}
+
 // maybeExpire checks if the endpoint has expired according to the provided timeouts and sets its closed state accordingly.
 // True is returned if the endpoint was expired and closed.
 func (e *serverEndpoint) maybeExpire(now mono.Time, bindLifetime, steadyStateLifetime time.Duration, m endpointUpdater) bool {
@@ -384,6 +442,7 @@ func NewServer(logf logger.Logf, port uint16, onlyStaticAddrPorts bool, metrics
 		bindLifetime:          defaultBindLifetime,
 		steadyStateLifetime:   defaultSteadyStateLifetime,
 		closeCh:               make(chan struct{}),
+		gcResetCh:             make(chan struct{}, 1),
 		onlyStaticAddrPorts:   onlyStaticAddrPorts,
 		serverEndpointByDisco: make(map[key.SortedPairOfDiscoPublic]*serverEndpoint),
 		nextVNI:               minVNI,
@@ -771,20 +830,38 @@ func (s *Server) endpointGC(bindLifetime, steadyStateLifetime time.Duration) {
 	}
 }
 
+// endpointGCLoop frees expired endpoints. It checks for them as often as
+// the shorter of the bind and steady state lifetimes, so an endpoint is
+// freed at most that long after it expires.
 func (s *Server) endpointGCLoop() {
 	defer s.wg.Done()
-	ticker := time.NewTicker(s.bindLifetime)
+	ticker := time.NewTicker(s.gcInterval())
 	defer ticker.Stop()
 	for {
 		select {
 		case <-ticker.C:
-			s.endpointGC(s.bindLifetime, s.steadyStateLifetime)
+			bindLifetime, steadyStateLifetime := s.getLifetimes()
+			s.endpointGC(bindLifetime, steadyStateLifetime)
+		case <-s.gcResetCh:
+			// The lifetimes were changed by [Server.SetPolicy].
+			ticker.Reset(s.gcInterval())
 		case <-s.closeCh:
 			return
 		}
 	}
 }
 
+// gcInterval returns how often endpointGCLoop checks for expired endpoints.
+func (s *Server) gcInterval() time.Duration {
+	return min(s.getLifetimes())
+}
+
+func (s *Server) getLifetimes() (bindLifetime, steadyStateLifetime time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.bindLifetime, s.steadyStateLifetime
+}
+
 // handlePacket unwraps headers and dispatches packet handling according to its
 // type and destination. If the returned address is valid, write will contain data
 // to transmit, and isDataPacket signals whether input was a data packet or OOB
@@ -1000,19 +1077,91 @@ func (s *Server) getAllAddrPortsCopyLocked() []netip.AddrPort {
 	return addrPorts
 }
 
+// ErrNotAllowed indicates that an endpoint allocation was denied because one
+// of the clients is not allowed to use the server by its policy.
+var ErrNotAllowed = errors.New("client not allowed by peer relay server policy")
+
+// ErrEndpointQuotaExceeded indicates that an endpoint allocation was denied
+// because the server already has the maximum number of endpoints allowed by
+// its policy.
+var ErrEndpointQuotaExceeded = errors.New("peer relay server endpoint quota exceeded")
+
+// ClientIdentity identifies the node behind a peer relay client's disco key,
+// for enforcement of the server's [status.ServerPolicy].
+type ClientIdentity struct {
+	Tags []string // the node's tags, if any
+	User string   // login name of the node's owner, if not tagged; or empty
+}
+
+// allowedBy reports whether the client with identity id is allowed by p's
+// AllowTags and AllowUsers.
+func (id ClientIdentity) allowedBy(p status.ServerPolicy) bool {
+	if !p.HasACL() {
+		return true
+	}
+	for _, tag := range id.Tags {
+		if slices.Contains(p.AllowTags, tag) {
+			return true
+		}
+	}
+	return id.User != "" && slices.Contains(p.AllowUsers, id.User)
+}
+
+// SetPolicy sets the policy limiting use of the server. Its access control
+// and quota apply to future allocations, and its rate limits and lifetimes
+// to existing endpoints as well.
+func (s *Server) SetPolicy(p status.ServerPolicy) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.policy = p
+	s.bindLifetime = defaultBindLifetime
+	if p.BindLifetime > 0 {
+		s.bindLifetime = p.BindLifetime
+	}
+	s.steadyStateLifetime = defaultSteadyStateLifetime
+	if p.SteadyStateLifetime > 0 {
+		s.steadyStateLifetime = p.SteadyStateLifetime
+	}
+	select {
+	case s.gcResetCh <- struct{}{}:
+	default:
+	}
+	for _, e := range s.serverEndpointByDisco {
+		e.setRateLimits(p)
+	}
+}
+
+// GetPolicyStatus returns the server's policy and the number of endpoint
+// allocations it has denied because of the policy's access control and
+// quota, respectively.
+func (s *Server) GetPolicyStatus() (p status.ServerPolicy, deniedByPolicy, deniedByQuota uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.policy, s.deniedByPolicy, s.deniedByQuota
+}
+
 // AllocateEndpoint allocates an [endpoint.ServerEndpoint] for the provided pair
-// of [key.DiscoPublic]'s. If an allocation already exists for discoA and discoB
-// it is returned without modification/reallocation. AllocateEndpoint returns
-// the following notable errors:
+// of [key.DiscoPublic]'s, identified by idA and idB respectively. If an
+// allocation already exists for discoA and discoB it is returned without
+// modification/reallocation. AllocateEndpoint returns the following notable
+// errors:
 //  1. [ErrServerClosed] if the server has been closed.
 //  2. [ErrServerNotReady] if the server is not ready.
-func (s *Server) AllocateEndpoint(discoA, discoB key.DiscoPublic) (endpoint.ServerEndpoint, error) {
+//  3. [ErrNotAllowed] if either client is not allowed by the server's policy.
+//  4. [ErrEndpointQuotaExceeded] if the server already has as many endpoints
+//     as its policy allows.
+func (s *Server) AllocateEndpoint(discoA, discoB key.DiscoPublic, idA, idB ClientIdentity) (endpoint.ServerEndpoint, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 	if s.closed {
 		return endpoint.ServerEndpoint{}, ErrServerClosed
 	}
 
+	if !idA.allowedBy(s.policy) || !idB.allowedBy(s.policy) {
+		s.deniedByPolicy++
+		return endpoint.ServerEndpoint{}, ErrNotAllowed
+	}
+
 	if s.staticAddrPorts.Len() == 0 && len(s.dynamicAddrPorts) == 0 {
 		return endpoint.ServerEndpoint{}, ErrServerNotReady{RetryAfter: endpoint.ServerRetryAfter}
 	}
@@ -1045,6 +1194,11 @@ func (s *Server) AllocateEndpoint(discoA, discoB key.DiscoPublic) (endpoint.Serv
 		}, nil
 	}
 
+	if s.policy.MaxEndpoints > 0 && len(s.serverEndpointByDisco) >= s.policy.MaxEndpoints {
+		s.deniedByQuota++
+		return endpoint.ServerEndpoint{}, ErrEndpointQuotaExceeded
+	}
+
 	vni, err := s.getNextVNILocked()
 	if err != nil {
 		return endpoint.ServerEndpoint{}, err
@@ -1059,6 +1213,7 @@ func (s *Server) AllocateEndpoint(discoA, discoB key.DiscoPublic) (endpoint.Serv
 	}
 	e.discoSharedSecrets[0] = s.disco.Shared(e.discoPubKeys.Get()[0])
 	e.discoSharedSecrets[1] = s.disco.Shared(e.discoPubKeys.Get()[1])
+	e.setRateLimits(s.policy)
 
 	s.serverEndpointByDisco[pair] = e
 	s.serverEndpointByVNI.Store(e.vni, e)
@@ -1087,6 +1242,8 @@ func (e *serverEndpoint) extractClientInfo() [2]status.ClientInfo {
 		ret[i].ShortDisco = e.discoPubKeys.Get()[i].ShortString()
 		ret[i].PacketsTx = e.packetsRx[i]
 		ret[i].BytesTx = e.bytesRx[i]
+		ret[i].PacketsDropped = e.packetsDropped[i]
+		ret[i].BytesDropped = e.bytesDropped[i]
 	}
 	return ret
 }
@@ -19,6 +19,7 @@
 	"golang.org/x/crypto/blake2s"
 	"tailscale.com/disco"
 	"tailscale.com/net/packet"
+	"tailscale.com/net/udprelay/status"
 	"tailscale.com/tstime/mono"
 	"tailscale.com/types/key"
 	"tailscale.com/types/views"
@@ -229,11 +230,11 @@ func TestServer(t *testing.T) {
 			}
 			server.SetStaticAddrPorts(views.SliceOf(addrPorts))
 
-			endpoint, err := server.AllocateEndpoint(discoA.Public(), discoB.Public())
+			endpoint, err := server.AllocateEndpoint(discoA.Public(), discoB.Public(), ClientIdentity{}, ClientIdentity{})
 			if err != nil {
 				t.Fatal(err)
 			}
-			dupEndpoint, err := server.AllocateEndpoint(discoA.Public(), discoB.Public())
+			dupEndpoint, err := server.AllocateEndpoint(discoA.Public(), discoB.Public(), ClientIdentity{}, ClientIdentity{})
 			if err != nil {
 				t.Fatal(err)
 			}
@@ -285,7 +286,7 @@ func TestServer(t *testing.T) {
 				tcB.handshake(t)
 			}
 
-			dupEndpoint, err = server.AllocateEndpoint(discoA.Public(), discoB.Public())
+			dupEndpoint, err = server.AllocateEndpoint(discoA.Public(), discoB.Public(), ClientIdentity{}, ClientIdentity{})
 			if err != nil {
 				t.Fatal(err)
 			}
@@ -545,3 +546,106 @@ func TestServer_endpointGC(t *testing.T) {
 		})
 	}
 }
+
+func TestServer_policy(t *testing.T) {
+	deregisterMetrics()
+	server, err := NewServer(t.Logf, 0, true, new(usermetric.Registry))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	server.SetStaticAddrPorts(views.SliceOf([]netip.AddrPort{netip.AddrPortFrom(netip.MustParseAddr("127.0.0.1"), server.uc4Port)}))
+	server.SetPolicy(status.ServerPolicy{
+		AllowTags:    []string{"tag:relay-ok"},
+		AllowUsers:   []string{"alice@example.com"},
+		MaxEndpoints: 1,
+		BindLifetime: time.Minute,
+	})
+
+	tagged := ClientIdentity{Tags: []string{"tag:other", "tag:relay-ok"}}
+	alice := ClientIdentity{User: "alice@example.com"}
+	bob := ClientIdentity{User: "bob@example.com"}
+	allocate := func(idA, idB ClientIdentity) error {
+		_, err := server.AllocateEndpoint(key.NewDisco().Public(), key.NewDisco().Public(), idA, idB)
+		return err
+	}
+
+	if err := allocate(alice, bob); err != ErrNotAllowed {
+		t.Errorf("alice<->bob: got %v; want %v", err, ErrNotAllowed)
+	}
+	if err := allocate(ClientIdentity{}, tagged); err != ErrNotAllowed {
+		t.Errorf("unknown<->tagged: got %v; want %v", err, ErrNotAllowed)
+	}
+	discoA, discoB := key.NewDisco().Public(), key.NewDisco().Public()
+	ep, err := server.AllocateEndpoint(discoA, discoB, alice, tagged)
+	if err != nil {
+		t.Fatalf("alice<->tagged: %v", err)
+	}
+	if ep.BindLifetime.Duration != time.Minute {
+		t.Errorf("BindLifetime = %v; want %v", ep.BindLifetime, time.Minute)
+	}
+	if got := server.gcInterval(); got != time.Minute {
+		t.Errorf("gcInterval = %v; want %v", got, time.Minute)
+	}
+	if err := allocate(alice, tagged); err != ErrEndpointQuotaExceeded {
+		t.Errorf("second endpoint: got %v; want %v", err, ErrEndpointQuotaExceeded)
+	}
+	// The existing allocation doesn't count against the quota again.
+	if _, err := server.AllocateEndpoint(discoA, discoB, alice, tagged); err != nil {
+		t.Errorf("re-allocating existing endpoint: %v", err)
+	}
+
+	_, byPolicy, byQuota := server.GetPolicyStatus()
+	if byPolicy != 2 || byQuota != 1 {
+		t.Errorf("denied by policy, quota = %d, %d; want 2, 1", byPolicy, byQuota)
+	}
+
+	// Idle endpoints are looked for at least as often as they expire.
+	server.SetPolicy(status.ServerPolicy{SteadyStateLifetime: 10 * time.Second})
+	if got := server.gcInterval(); got != 10*time.Second {
+		t.Errorf("gcInterval = %v; want 10s", got)
+	}
+}
+
+func TestServerEndpoint_rateLimits(t *testing.T) {
+	a := netip.MustParseAddrPort("192.0.2.1:1")
+	b := netip.MustParseAddrPort("192.0.2.2:1")
+	e := &serverEndpoint{boundAddrPorts: [2]netip.AddrPort{a, b}}
+	e.setRateLimits(status.ServerPolicy{MaxPacketsPerSecond: 3})
+
+	pkt := make([]byte, 100)
+	forwarded := 0
+	for range 10 {
+		if _, to := e.handleDataPacket(a, pkt, mono.Now()); to.IsValid() {
+			forwarded++
+		}
+	}
+	// The burst is one second's worth of packets; the test won't run long
+	// enough for more than a few more to be allowed.
+	if forwarded < 3 || forwarded > 5 {
+		t.Errorf("forwarded %d of 10 packets; want about 3", forwarded)
+	}
+	info := e.extractClientInfo()
+	if got, want := info[0].PacketsDropped, uint64(10-forwarded); got != want {
+		t.Errorf("PacketsDropped = %d; want %d", got, want)
+	}
+	if got, want := info[0].BytesDropped, uint64(10-forwarded)*100; got != want {
+		t.Errorf("BytesDropped = %d; want %d", got, want)
+	}
+	if info[0].PacketsTx != uint64(forwarded) {
+		t.Errorf("PacketsTx = %d; want %d", info[0].PacketsTx, forwarded)
+	}
+
+	// Each client has its own limit.
+	if _, to := e.handleDataPacket(b, pkt, mono.Now()); to != a {
+		t.Errorf("packet from b forwarded to %v; want %v", to, a)
+	}
+
+	// Removing the limit takes effect for the existing endpoint.
+	e.setRateLimits(status.ServerPolicy{})
+	for range 10 {
+		if _, to := e.handleDataPacket(a, pkt, mono.Now()); to != b {
+			t.Fatal("packet dropped after removing limit")
+		}
+	}
+}
@@ -7,6 +7,7 @@
 
 import (
 	"net/netip"
+	"time"
 )
 
 // ServerStatus contains the listening UDP port and active sessions (if any) for
@@ -21,6 +22,50 @@ type ServerStatus struct {
 	// relay session that this node's peer relay server is involved with. It
 	// may be empty.
 	Sessions []ServerSession
+	// Policy is the policy limiting use of the peer relay server. Its zero
+	// value means no limits.
+	Policy ServerPolicy
+	// AllocationsDeniedByPolicy is the number of endpoint allocation
+	// requests the peer relay server denied because one of the clients is
+	// not allowed by Policy's AllowTags and AllowUsers.
+	AllocationsDeniedByPolicy uint64
+	// AllocationsDeniedByQuota is the number of endpoint allocation
+	// requests the peer relay server denied because it already had
+	// Policy.MaxEndpoints endpoints allocated.
+	AllocationsDeniedByQuota uint64
+}
+
+// ServerPolicy limits the use of a peer relay server by peer relay clients.
+// Its zero value imposes no limits.
+type ServerPolicy struct {
+	// AllowTags and AllowUsers, if either is non-empty, restrict the use of
+	// the peer relay server to sessions between clients that are tagged
+	// with one of AllowTags or owned by one of AllowUsers (by login name).
+	// Both clients of a session must be allowed.
+	AllowTags  []string `json:",omitempty"`
+	AllowUsers []string `json:",omitempty"`
+	// MaxEndpoints, if positive, is the maximum number of endpoints (and
+	// so sessions) the peer relay server allocates at once.
+	MaxEndpoints int `json:",omitempty"`
+	// MaxBytesPerSecond and MaxPacketsPerSecond, if positive, cap the rate
+	// at which each client may send through a session. Packets in excess
+	// of either are dropped.
+	MaxBytesPerSecond   int64 `json:",omitempty"`
+	MaxPacketsPerSecond int64 `json:",omitempty"`
+	// BindLifetime, if positive, is how long an allocated endpoint waits
+	// for both clients to complete a handshake before it's freed.
+	BindLifetime time.Duration `json:",omitempty"`
+	// SteadyStateLifetime, if positive, is how long an endpoint may go
+	// without traffic from either client before it's freed. Idle
+	// endpoints are looked for periodically rather than on the packet
+	// path, so one is freed up to twice this long after its last traffic.
+	SteadyStateLifetime time.Duration `json:",omitempty"`
+}
+
+// HasACL reports whether p restricts which clients may use the peer relay
+// server.
+func (p ServerPolicy) HasACL() bool {
+	return len(p.AllowTags) > 0 || len(p.AllowUsers) > 0
 }
 
 // ClientInfo contains status-related information about a single peer relay
@@ -51,6 +96,13 @@ type ClientInfo struct {
 	// is identical to the total overlay bytes that the peer relay server has
 	// received from this client.
 	BytesTx uint64
+	// PacketsDropped is the number of packets from this peer relay client
+	// that the relay server dropped for exceeding the rate limits of its
+	// [ServerPolicy].
+	PacketsDropped uint64 `json:",omitempty"`
+	// BytesDropped is the total overlay bytes of the packets counted by
+	// PacketsDropped.
+	BytesDropped uint64 `json:",omitempty"`
 }
 
 // ServerSession contains status information for a single session between two
@@ -0,0 +1,333 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package sessionrecording
+
+import (
+	"bufio"
+	"bytes"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"sync"
+	"time"
+)
+
+// This file contains the tamper-evident recording format. Its recordings are
+// asciinema cast files whose lines are hash-chained: each line's chain hash
+// is the SHA-256 hash of the previous line's chain hash and the line. The
+// chain hash is periodically signed, in event lines of their own, so that a
+// recording's lines can't be modified, removed or added to without it being
+// detected, other than after its last signature. Its final signature marks
+// the recording's end, so that truncation is detected too.
+
+// ChainVersion is the version of the tamper-evident recording format.
+const ChainVersion = 1
+
+// SignatureEventCode is the asciinema event code of the cast lines of
+// tamper-evident recordings that sign their hash chains. The line's data is a
+// [ChainSignature] rather than a string.
+const SignatureEventCode = "s"
+
+// chainSignatureContext is prepended to the chain hashes that are signed.
+const chainSignatureContext = "tailscale-recording-chain-v1\x00"
+
+// Chain describes the hash chain of a tamper-evident recording, in its
+// [CastHeader].
+type Chain struct {
+	// Version is the version of the recording format, [ChainVersion].
+	Version int `json:"version"`
+
+	// PublicKey is the Ed25519 public key that the recording's chain hashes
+	// are signed with. For Tailscale SSH recordings, it's the recording
+	// node's SSH host key. It's only informational: recordings are verified
+	// against the keys that the verifier trusts; see [VerifyChain].
+	PublicKey []byte `json:"publicKey"`
+
+	// SignLines is the most lines that are written between signatures.
+	SignLines int `json:"signLines"`
+
+	// SignInterval is the most seconds that pass between the writing of a
+	// line and its signature, if more lines are written.
+	SignInterval float64 `json:"signInterval"`
+}
+
+// ChainSignature is the data of a [SignatureEventCode] cast line.
+type ChainSignature struct {
+	// Lines is the number of lines of the recording before this one,
+	// including its header.
+	Lines int `json:"lines"`
+
+	// Hash is the chain hash of the line before this one.
+	Hash []byte `json:"hash"`
+
+	// Final is whether this is the last line of the recording.
+	Final bool `json:"final,omitempty"`
+
+	// Signature is the Ed25519 signature of Hash and Final.
+	Signature []byte `json:"sig"`
+}
+
+// signedMessage returns the message that s.Signature signs.
+func (s *ChainSignature) signedMessage() []byte {
+	msg := append([]byte(chainSignatureContext), s.Hash...)
+	if s.Final {
+		return append(msg, 1)
+	}
+	return append(msg, 0)
+}
+
+const (
+	defaultSignLines    = 100
+	defaultSignInterval = 10 * time.Second
+)
+
+// ChainWriter writes a tamper-evident recording. Each call to Write must be
+// of exactly one line of the recording, starting with its header, which
+// must include the ChainWriter's [ChainWriter.Chain].
+type ChainWriter struct {
+	w      io.WriteCloser
+	signer crypto.Signer
+	chain  Chain
+	start  time.Time
+
+	mu         sync.Mutex
+	hash       [sha256.Size]byte
+	lines      int       // lines written
+	lastSigned int       // lines signed by the last signature
+	unsigned   time.Time // when the first line after the last signature was written
+	closed     bool
+}
+
+// NewChainWriter returns a ChainWriter that writes a recording to w, signing
+// it with signer, whose public key must be an [ed25519.PublicKey].
+func NewChainWriter(w io.WriteCloser, signer crypto.Signer) (*ChainWriter, error) {
+	pub, ok := signer.Public().(ed25519.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("recording signer has a %T key, want Ed25519", signer.Public())
+	}
+	return &ChainWriter{
+		w:      w,
+		signer: signer,
+		start:  time.Now(),
+		chain: Chain{
+			Version:      ChainVersion,
+			PublicKey:    pub,
+			SignLines:    defaultSignLines,
+			SignInterval: defaultSignInterval.Seconds(),
+		},
+	}, nil
+}
+
+// Chain returns the description of the recording's hash chain, for its
+// header.
+func (cw *ChainWriter) Chain() *Chain {
+	c := cw.chain
+	return &c
+}
+
+// Write writes the line p, which must end in a newline, and then its chain
+// hash's signature, if it's due.
+func (cw *ChainWriter) Write(p []byte) (int, error) {
+	line, ok := bytes.CutSuffix(p, []byte("\n"))
+	if !ok || bytes.IndexByte(line, '\n') >= 0 {
+		return 0, errors.New("ChainWriter.Write of other than one line")
+	}
+	cw.mu.Lock()
+	defer cw.mu.Unlock()
+	if cw.closed {
+		return 0, io.ErrClosedPipe
+	}
+	if err := cw.writeLineLocked(line); err != nil {
+		return 0, err
+	}
+	now := time.Now()
+	if cw.lines-cw.lastSigned == 1 {
+		cw.unsigned = now
+	}
+	if cw.lines-cw.lastSigned >= cw.chain.SignLines || now.Sub(cw.unsigned).Seconds() >= cw.chain.SignInterval {
+		if err := cw.signLocked(false); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// writeLineLocked writes line and adds it to the hash chain.
+func (cw *ChainWriter) writeLineLocked(line []byte) error {
+	if _, err := cw.w.Write(append(line[:len(line):len(line)], '\n')); err != nil {
+		return err
+	}
+	cw.hash = chainHash(cw.hash, line)
+	cw.lines++
+	return nil
+}
+
+// signLocked writes a signature of the lines written so far.
+func (cw *ChainWriter) signLocked(final bool) error {
+	s := &ChainSignature{
+		Lines: cw.lines,
+		Hash:  bytes.Clone(cw.hash[:]),
+		Final: final,
+	}
+	sig, err := cw.signer.Sign(rand.Reader, s.signedMessage(), crypto.Hash(0))
+	if err != nil {
+		return fmt.Errorf("signing recording: %w", err)
+	}
+	s.Signature = sig
+	j, err := json.Marshal([]any{time.Since(cw.start).Seconds(), SignatureEventCode, s})
+	if err != nil {
+		return err
+	}
+	if err := cw.writeLineLocked(j); err != nil {
+		return err
+	}
+	cw.lastSigned = cw.lines
+	return nil
+}
+
+// Close writes the recording's final signature and closes the underlying
+// writer.
+func (cw *ChainWriter) Close() error {
+	cw.mu.Lock()
+	defer cw.mu.Unlock()
+	if cw.closed {
+		return nil
+	}
+	cw.closed = true
+	err := cw.signLocked(true)
+	return errors.Join(err, cw.w.Close())
+}
+
+// chainHash returns the chain hash of line, given that of the previous line.
+func chainHash(prev [sha256.Size]byte, line []byte) [sha256.Size]byte {
+	h := sha256.New()
+	h.Write(prev[:])
+	h.Write(line)
+	var sum [sha256.Size]byte
+	h.Sum(sum[:0])
+	return sum
+}
+
+// ErrRecordingTruncated is the error that [VerifyChain] returns, wrapped, for
+// recordings that don't end with a final signature, as those still being
+// written don't.
+var ErrRecordingTruncated = errors.New("recording truncated")
+
+// ErrUntrustedKey is the error that [VerifyChain] returns for recordings
+// that aren't signed with any of the keys it's given to trust.
+var ErrUntrustedKey = errors.New("recording not signed with a trusted key")
+
+// ChainVerification is the result of verifying a tamper-evident recording.
+type ChainVerification struct {
+	// Header is the recording's header.
+	Header CastHeader
+
+	// Lines is the number of lines, including the header, that are
+	// covered by a valid signature.
+	Lines int
+
+	// Signatures is the number of valid signatures.
+	Signatures int
+
+	// Complete is whether the recording ends with a valid final signature.
+	Complete bool
+}
+
+// VerifyChain verifies the tamper-evident recording read from r, returning
+// an error if any line has been modified, added or removed. The recording
+// must be signed with one of the trusted keys, such as the SSH host key of
+// the node that made it, as known to the verifier. Otherwise, it returns
+// the verification with only the recording's header and [ErrUntrustedKey].
+//
+// If the recording doesn't end with a final signature, it returns the
+// verification of the lines before the last signature and an error
+// wrapping [ErrRecordingTruncated].
+func VerifyChain(r io.Reader, trusted []ed25519.PublicKey) (*ChainVerification, error) {
+	br := bufio.NewReader(r)
+	line, err := br.ReadBytes('\n')
+	if err != nil {
+		if err == io.EOF {
+			return nil, errors.New("empty recording")
+		}
+		return nil, err
+	}
+	v := new(ChainVerification)
+	line = bytes.TrimSuffix(line, []byte("\n"))
+	if err := json.Unmarshal(line, &v.Header); err != nil {
+		return nil, fmt.Errorf("invalid recording header: %w", err)
+	}
+	c := v.Header.Chain
+	if c == nil {
+		return nil, errors.New("recording is not tamper-evident")
+	}
+	if c.Version != ChainVersion {
+		return nil, fmt.Errorf("unsupported tamper-evident recording version %d", c.Version)
+	}
+	i := slices.IndexFunc(trusted, func(k ed25519.PublicKey) bool {
+		return len(k) == ed25519.PublicKeySize && k.Equal(ed25519.PublicKey(c.PublicKey))
+	})
+	if i < 0 {
+		return v, ErrUntrustedKey
+	}
+	pub := trusted[i]
+
+	hash := chainHash([sha256.Size]byte{}, line)
+	lines := 1
+	for {
+		line, err := br.ReadBytes('\n')
+		if err == io.EOF {
+			if len(line) > 0 {
+				return v, fmt.Errorf("%w: incomplete line %d", ErrRecordingTruncated, lines+1)
+			}
+			break
+		}
+		if err != nil {
+			return v, err
+		}
+		if v.Complete {
+			return v, fmt.Errorf("line %d after final signature", lines+1)
+		}
+		line = bytes.TrimSuffix(line, []byte("\n"))
+		if s, ok := parseChainSignature(line); ok {
+			if s.Lines != lines || !bytes.Equal(s.Hash, hash[:]) {
+				return v, fmt.Errorf("recording modified before line %d", lines+1)
+			}
+			if !ed25519.Verify(pub, s.signedMessage(), s.Signature) {
+				return v, fmt.Errorf("invalid signature on line %d", lines+1)
+			}
+			v.Lines = lines + 1
+			v.Signatures++
+			v.Complete = s.Final
+		}
+		hash = chainHash(hash, line)
+		lines++
+	}
+	if !v.Complete {
+		return v, fmt.Errorf("%w: %d lines after the last signature", ErrRecordingTruncated, lines-v.Lines)
+	}
+	return v, nil
+}
+
+// parseChainSignature parses line as a signature event.
+func parseChainSignature(line []byte) (_ *ChainSignature, ok bool) {
+	var ev []json.RawMessage
+	if json.Unmarshal(line, &ev) != nil || len(ev) != 3 {
+		return nil, false
+	}
+	var code string
+	if json.Unmarshal(ev[1], &code) != nil || code != SignatureEventCode {
+		return nil, false
+	}
+	s := new(ChainSignature)
+	if json.Unmarshal(ev[2], s) != nil {
+		return nil, false
+	}
+	return s, true
+}
@@ -0,0 +1,201 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package sessionrecording
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+)
+
+type nopWriteCloser struct{ io.Writer }
+
+func (nopWriteCloser) Close() error { return nil }
+
+// writeChainRecording returns a tamper-evident recording of the given number
+// of output events, signed with priv.
+func writeChainRecording(t *testing.T, priv ed25519.PrivateKey, events int) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	cw, err := NewChainWriter(nopWriteCloser{&buf}, priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ch := CastHeader{Version: 2, Width: 80, Height: 24, Chain: cw.Chain()}
+	j, err := json.Marshal(ch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cw.Write(append(j, '\n')); err != nil {
+		t.Fatal(err)
+	}
+	for i := range events {
+		if _, err := fmt.Fprintf(cw, "[0, \"o\", \"line %d\\r\\n\"]\n", i); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := cw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestChain(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec := writeChainRecording(t, priv, 250)
+	lines := strings.SplitAfter(string(rec), "\n")
+	lines = lines[:len(lines)-1] // empty after the last newline
+
+	v, err := VerifyChain(bytes.NewReader(rec), []ed25519.PublicKey{pub})
+	if err != nil {
+		t.Fatalf("VerifyChain: %v", err)
+	}
+	// Signatures on lines 101 and 202, each after 100 lines more, and a
+	// final one on line 254.
+	if !v.Complete || v.Signatures != 3 || v.Lines != len(lines) || v.Lines != 254 {
+		t.Errorf("VerifyChain = %+v, want complete with 3 signatures of 254 lines", v)
+	}
+	if !bytes.Equal(v.Header.Chain.PublicKey, pub) {
+		t.Errorf("public key = %x, want %x", v.Header.Chain.PublicKey, pub)
+	}
+
+	// The recording is played as usual.
+	var out bytes.Buffer
+	if err := Play(t.Context(), &out, bytes.NewReader(rec), PlayOptions{}); err != nil {
+		t.Fatalf("Play: %v", err)
+	}
+	if !strings.HasPrefix(out.String(), "line 0\r\nline 1\r\n") || !strings.HasSuffix(out.String(), "line 249\r\n") {
+		t.Errorf("played %q", out.String())
+	}
+
+	otherPub, otherPriv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherRec := writeChainRecording(t, otherPriv, 250)
+	other := strings.SplitAfter(string(otherRec), "\n")
+
+	// Recordings are only verified with the trusted keys, not the one in
+	// their header.
+	v, err = VerifyChain(bytes.NewReader(otherRec), []ed25519.PublicKey{pub})
+	if err != ErrUntrustedKey {
+		t.Errorf("VerifyChain with other key: err = %v, want %v", err, ErrUntrustedKey)
+	}
+	if v == nil || !bytes.Equal(v.Header.Chain.PublicKey, otherPub) || v.Signatures != 0 {
+		t.Errorf("VerifyChain with other key = %+v, want header only", v)
+	}
+	if _, err := VerifyChain(bytes.NewReader(otherRec), nil); err != ErrUntrustedKey {
+		t.Errorf("VerifyChain without keys: err = %v, want %v", err, ErrUntrustedKey)
+	}
+	if _, err := VerifyChain(bytes.NewReader(otherRec), []ed25519.PublicKey{pub, otherPub}); err != nil {
+		t.Errorf("VerifyChain with both keys: %v", err)
+	}
+
+	join := func(parts ...[]string) string {
+		var sb strings.Builder
+		for _, p := range parts {
+			sb.WriteString(strings.Join(p, ""))
+		}
+		return sb.String()
+	}
+	tests := []struct {
+		name          string
+		rec           string
+		wantErr       string
+		wantTruncated bool
+	}{
+		{
+			name:    "modified",
+			rec:     join(lines[:50], []string{strings.Replace(lines[50], "line", "LINE", 1)}, lines[51:]),
+			wantErr: "recording modified before line 101",
+		},
+		{
+			name:    "removed",
+			rec:     join(lines[:150], lines[151:]),
+			wantErr: "recording modified before line 201",
+		},
+		{
+			name:    "inserted",
+			rec:     join(lines[:250], []string{"[1, \"o\", \"rm -rf /\"]\n"}, lines[250:]),
+			wantErr: "recording modified before line 255",
+		},
+		{
+			name:    "header-key-replaced",
+			rec:     join(other[:1], lines[1:]),
+			wantErr: "recording not signed with a trusted key",
+		},
+		{
+			name:    "re-signed",
+			rec:     join(lines[:1], other[1:]),
+			wantErr: "recording modified before line 101",
+		},
+		{
+			name:          "truncated",
+			rec:           join(lines[:230]),
+			wantErr:       "recording truncated: 28 lines after the last signature",
+			wantTruncated: true,
+		},
+		{
+			name:          "truncated-mid-line",
+			rec:           join(lines[:230], []string{lines[230][:5]}),
+			wantErr:       "recording truncated: incomplete line 231",
+			wantTruncated: true,
+		},
+		{
+			name:          "final-signature-removed",
+			rec:           join(lines[:len(lines)-1]),
+			wantErr:       "recording truncated: 51 lines after the last signature",
+			wantTruncated: true,
+		},
+		{
+			name:    "appended",
+			rec:     join(lines, []string{"[1, \"o\", \"more\"]\n"}),
+			wantErr: "line 255 after final signature",
+		},
+		{
+			name:    "not-tamper-evident",
+			rec:     "{\"version\":2}\n[0, \"o\", \"hi\"]\n",
+			wantErr: "recording is not tamper-evident",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := VerifyChain(strings.NewReader(tt.rec), []ed25519.PublicKey{pub})
+			if err == nil || err.Error() != tt.wantErr {
+				t.Fatalf("VerifyChain error = %v, want %q", err, tt.wantErr)
+			}
+			if got := errors.Is(err, ErrRecordingTruncated); got != tt.wantTruncated {
+				t.Errorf("errors.Is(err, ErrRecordingTruncated) = %v, want %v", got, tt.wantTruncated)
+			}
+		})
+	}
+}
+
+func TestChainWriterLines(t *testing.T) {
+	_, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cw, err := NewChainWriter(nopWriteCloser{io.Discard}, priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range []string{"", "no newline", "two\nlines\n"} {
+		if _, err := cw.Write([]byte(p)); err == nil {
+			t.Errorf("Write(%q) succeeded", p)
+		}
+	}
+	cw.Close()
+	if _, err := cw.Write([]byte("{}\n")); err == nil {
+		t.Errorf("Write after Close succeeded")
+	}
+}
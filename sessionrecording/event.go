@@ -13,6 +13,73 @@
 	KubernetesAPIEventType = "kubernetes-api-request"
 )
 
+// FileTransferEventCode is the asciinema event code of cast lines that record
+// file transfers in SSH session recordings, in place of the "o" code of
+// terminal output. The line's data is a [FileTransfer] rather than a string.
+const FileTransferEventCode = "f"
+
+// FileTransfer describes a file transferred, or otherwise operated on, over
+// SFTP or scp in an SSH session.
+type FileTransfer struct {
+	// Protocol is "sftp" or "scp".
+	Protocol string `json:"protocol"`
+
+	// Op is "upload" or "download" for file transfers. For SFTP, it may
+	// also be "remove", "rename", "mkdir" or "rmdir".
+	Op string `json:"op"`
+
+	// Path is the path on the server, as given by the client.
+	// For scp, it's the target or source path argument of the scp command,
+	// and Name is the path of the file transferred relative to it.
+	Path string `json:"path"`
+
+	// NewPath is the new path of a renamed file.
+	NewPath string `json:"newPath,omitempty"`
+
+	// Name is the slash-separated name of a file transferred with scp,
+	// including those of the directories it's in when transferring
+	// directories recursively.
+	Name string `json:"name,omitempty"`
+
+	// Bytes is the number of bytes of file data transferred.
+	Bytes int64 `json:"bytes,omitempty"`
+}
+
+// ForwardedChannelEventCode is the asciinema event code of cast lines that
+// record forwarded channels in SSH connection recordings. The line's data is a
+// [ForwardedChannel] rather than a string.
+const ForwardedChannelEventCode = "c"
+
+// ForwardedChannel describes a channel of port or agent forwarding in an SSH
+// connection. It's recorded when the channel is closed.
+type ForwardedChannel struct {
+	// Type is "local" for local port forwarding (ssh -L), "remote" for
+	// remote port forwarding (ssh -R), or "agent" for agent forwarding.
+	Type string `json:"type"`
+
+	// Dest is the host:port that the channel was forwarded to. For remote
+	// port forwarding, it's the address that the server listened on.
+	// It's empty for agent forwarding.
+	Dest string `json:"dest,omitempty"`
+
+	// Origin is the host:port that the channel was forwarded from, as
+	// reported by the client for local port forwarding, or of the peer
+	// that connected to the server for remote port forwarding.
+	// It's empty for agent forwarding.
+	Origin string `json:"origin,omitempty"`
+
+	// BytesFromClient is the number of bytes sent by the SSH client over
+	// the channel.
+	BytesFromClient int64 `json:"bytesFromClient"`
+
+	// BytesToClient is the number of bytes sent to the SSH client over
+	// the channel.
+	BytesToClient int64 `json:"bytesToClient"`
+
+	// Duration is the number of seconds that the channel was open.
+	Duration float64 `json:"duration"`
+}
+
 // Event represents the top-level structure of a tsrecorder event.
 type Event struct {
 	// Type specifies the kind of event being recorded (e.g., "kubernetes-api-request").
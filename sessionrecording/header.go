@@ -61,6 +61,15 @@ type CastHeader struct {
 	// case of SSH multiplexing.
 	ConnectionID string `json:"connectionID"`
 
+	// Forwarding is whether the recording is of the port and agent
+	// forwarding channels of a connection, as [ForwardedChannel] events,
+	// rather than of a session.
+	Forwarding bool `json:"forwarding,omitempty"`
+
+	// Chain, if non-nil, describes the hash chain of a tamper-evident
+	// recording. See [ChainWriter].
+	Chain *Chain `json:"chain,omitempty"`
+
 	// Fields that are only set for Kubernetes API server proxy session recordings:
 	Kubernetes *Kubernetes `json:"kubernetes,omitempty"`
 }
@@ -0,0 +1,245 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package sessionrecording
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"tailscale.com/util/mak"
+)
+
+// localRecordingExt is the file name extension of local recordings.
+const localRecordingExt = ".cast"
+
+// LocalRecorder stores recordings as asciinema cast files in a local
+// directory, for setups without recorder nodes. It deletes the oldest
+// recordings as needed to stay within its retention limits, other than
+// those still being written.
+type LocalRecorder struct {
+	// Dir is the directory that recordings are stored in.
+	// It's created if it doesn't exist.
+	Dir string
+
+	// MaxAge, if non-zero, is how long recordings are kept after they
+	// were last written to.
+	MaxAge time.Duration
+
+	// MaxFiles, if non-zero, is the maximum number of recordings kept.
+	// It may be exceeded by recordings still being written, which aren't
+	// deleted, in which case the most recent recording is kept too.
+	MaxFiles int
+
+	// MaxBytes, if non-zero, is the maximum total size of the recordings
+	// kept. Like the other limits, it's enforced as recordings are
+	// created and listed rather than as they grow, and the most recent
+	// recording is always kept, even if it's larger.
+	MaxBytes int64
+}
+
+// openLocalRecordings are the paths of the recordings created by any
+// LocalRecorder that haven't been closed yet, which aren't pruned.
+var openLocalRecordings struct {
+	mu    sync.Mutex
+	paths map[string]int // number of open files, by path
+}
+
+func setLocalRecordingOpen(path string, open bool) {
+	openLocalRecordings.mu.Lock()
+	defer openLocalRecordings.mu.Unlock()
+	if open {
+		mak.Set(&openLocalRecordings.paths, path, openLocalRecordings.paths[path]+1)
+	} else if n := openLocalRecordings.paths[path]; n > 1 {
+		openLocalRecordings.paths[path] = n - 1
+	} else {
+		delete(openLocalRecordings.paths, path)
+	}
+}
+
+func isLocalRecordingOpen(path string) bool {
+	openLocalRecordings.mu.Lock()
+	defer openLocalRecordings.mu.Unlock()
+	return openLocalRecordings.paths[path] > 0
+}
+
+// LocalRecordingFile is the file of a recording being written, as created
+// by [LocalRecorder.Create]. It's not pruned until it's closed.
+type LocalRecordingFile struct {
+	*os.File
+	path      string // as in openLocalRecordings
+	closeOnce sync.Once
+}
+
+// Close closes the file, after which it may be pruned.
+func (f *LocalRecordingFile) Close() error {
+	err := f.File.Close()
+	f.closeOnce.Do(func() { setLocalRecordingOpen(f.path, false) })
+	return err
+}
+
+// Create creates the file of a new recording, with a name starting with
+// prefix and the time now, after deleting old recordings to make room for it.
+// The caller writes the recording's CastHeader and events to the file, and
+// closes it once the recording ends.
+func (lr *LocalRecorder) Create(prefix string, now time.Time) (*LocalRecordingFile, error) {
+	if err := os.MkdirAll(lr.Dir, 0700); err != nil {
+		return nil, err
+	}
+	if err := lr.prune(now, 1); err != nil {
+		return nil, err
+	}
+	f, err := os.CreateTemp(lr.Dir, fmt.Sprintf("%s-%v-*%s", prefix, now.UnixNano(), localRecordingExt))
+	if err != nil {
+		return nil, err
+	}
+	rf := &LocalRecordingFile{File: f, path: filepath.Join(lr.Dir, filepath.Base(f.Name()))}
+	setLocalRecordingOpen(rf.path, true)
+	return rf, nil
+}
+
+// Prune deletes the oldest recordings in lr.Dir until it's within the
+// retention limits.
+func (lr *LocalRecorder) Prune(now time.Time) error {
+	return lr.prune(now, 0)
+}
+
+// prune is like Prune, but makes room for extra recordings to be created.
+func (lr *LocalRecorder) prune(now time.Time, extra int) error {
+	recs, err := listLocalRecordingFiles(lr.Dir)
+	if err != nil {
+		return err
+	}
+	var total int64
+	for _, rec := range recs {
+		total += rec.Size
+	}
+	var errs []error
+	kept := len(recs)
+	// Delete from the oldest, leaving the most recent recording for
+	// MaxFiles and MaxBytes, and any still being written.
+	for i, rec := range recs {
+		tooOld := lr.MaxAge > 0 && now.Sub(rec.ModTime) > lr.MaxAge
+		tooMany := lr.MaxFiles > 0 && kept+extra > lr.MaxFiles && (extra > 0 || i < len(recs)-1)
+		tooBig := lr.MaxBytes > 0 && total > lr.MaxBytes && i < len(recs)-1
+		if !tooOld && !tooMany && !tooBig {
+			break
+		}
+		path := filepath.Join(lr.Dir, rec.Name)
+		if isLocalRecordingOpen(path) {
+			continue
+		}
+		if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			errs = append(errs, err)
+		}
+		kept--
+		total -= rec.Size
+	}
+	return errors.Join(errs...)
+}
+
+// LocalRecording describes a recording stored by a [LocalRecorder].
+type LocalRecording struct {
+	// Name is the recording's file name.
+	Name string
+
+	// Size is the size of the recording in bytes.
+	Size int64
+
+	// ModTime is when the recording was last written to.
+	ModTime time.Time
+
+	// Header is the recording's header, or nil if it couldn't be read.
+	Header *CastHeader
+}
+
+// ListLocalRecordings returns the recordings in dir, oldest first.
+// It returns no recordings, rather than an error, if dir doesn't exist.
+func ListLocalRecordings(dir string) ([]LocalRecording, error) {
+	recs, err := listLocalRecordingFiles(dir)
+	if err != nil {
+		return nil, err
+	}
+	for i := range recs {
+		recs[i].Header, _ = readLocalRecordingHeader(filepath.Join(dir, recs[i].Name))
+	}
+	return recs, nil
+}
+
+// listLocalRecordingFiles is like ListLocalRecordings, without reading the
+// recordings' headers.
+func listLocalRecordingFiles(dir string) ([]LocalRecording, error) {
+	des, err := os.ReadDir(dir)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var recs []LocalRecording
+	for _, de := range des {
+		if !de.Type().IsRegular() || !strings.HasSuffix(de.Name(), localRecordingExt) {
+			continue
+		}
+		fi, err := de.Info()
+		if err != nil {
+			continue // deleted since ReadDir
+		}
+		recs = append(recs, LocalRecording{
+			Name:    de.Name(),
+			Size:    fi.Size(),
+			ModTime: fi.ModTime(),
+		})
+	}
+	slices.SortFunc(recs, func(a, b LocalRecording) int {
+		if c := a.ModTime.Compare(b.ModTime); c != 0 {
+			return c
+		}
+		return strings.Compare(a.Name, b.Name)
+	})
+	return recs, nil
+}
+
+// maxHeaderSize is the maximum size of a recording's header line.
+const maxHeaderSize = 64 << 10
+
+func readLocalRecordingHeader(path string) (*CastHeader, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	line, err := bufio.NewReaderSize(f, maxHeaderSize).ReadSlice('\n')
+	if err != nil {
+		return nil, err
+	}
+	ch := new(CastHeader)
+	if err := json.Unmarshal(line, ch); err != nil {
+		return nil, err
+	}
+	return ch, nil
+}
+
+// OpenLocalRecording opens the recording with the given file name in dir.
+func OpenLocalRecording(dir, name string) (*os.File, error) {
+	if !validLocalRecordingName(name) {
+		return nil, fmt.Errorf("invalid recording name %q", name)
+	}
+	return os.Open(filepath.Join(dir, name))
+}
+
+func validLocalRecordingName(name string) bool {
+	return strings.HasSuffix(name, localRecordingExt) &&
+		filepath.Base(name) == name &&
+		!strings.ContainsAny(name, `/\`) &&
+		!strings.HasPrefix(name, ".")
+}
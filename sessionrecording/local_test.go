@@ -0,0 +1,170 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package sessionrecording
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+// createLocalRecording creates a recording with lr at now, with the header ch
+// and data of the given size after it.
+func createLocalRecording(t *testing.T, lr *LocalRecorder, now time.Time, ch CastHeader, size int) string {
+	t.Helper()
+	f, err := lr.Create("ssh-session", now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if err := json.NewEncoder(f).Encode(ch); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Write(make([]byte, size)); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(f.Name(), now, now); err != nil {
+		t.Fatal(err)
+	}
+	return filepath.Base(f.Name())
+}
+
+func localRecordingNames(t *testing.T, dir string) []string {
+	t.Helper()
+	recs, err := ListLocalRecordings(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, rec := range recs {
+		names = append(names, rec.Name)
+	}
+	return names
+}
+
+func TestLocalRecorderRetention(t *testing.T) {
+	start := time.Unix(1700000000, 0)
+	tests := []struct {
+		name string
+		lr   LocalRecorder
+		want []int // indexes of the recordings kept, of 5 an hour apart
+	}{
+		{"unlimited", LocalRecorder{}, []int{0, 1, 2, 3, 4}},
+		{"max-files", LocalRecorder{MaxFiles: 2}, []int{3, 4}},
+		{"max-files-1", LocalRecorder{MaxFiles: 1}, []int{4}},
+		{"max-age", LocalRecorder{MaxAge: 90 * time.Minute}, []int{3, 4}},
+		{"max-bytes", LocalRecorder{MaxBytes: 3000}, []int{2, 3, 4}},
+		// The limit is enforced before each recording is created.
+		{"max-bytes-too-small", LocalRecorder{MaxBytes: 10}, []int{3, 4}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lr := tt.lr
+			lr.Dir = filepath.Join(t.TempDir(), "recordings")
+			var names []string
+			for i := range 5 {
+				now := start.Add(time.Duration(i) * time.Hour)
+				names = append(names, createLocalRecording(t, &lr, now, CastHeader{Version: 2}, 900))
+			}
+			var want []string
+			for _, i := range tt.want {
+				want = append(want, names[i])
+			}
+			if got := localRecordingNames(t, lr.Dir); !slices.Equal(got, want) {
+				t.Errorf("recordings = %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestLocalRecorderKeepsOpenRecordings(t *testing.T) {
+	lr := &LocalRecorder{Dir: t.TempDir(), MaxFiles: 1, MaxAge: time.Hour}
+	start := time.Unix(1700000000, 0)
+	open, err := lr.Create("ssh-session", start)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer open.Close()
+	openName := filepath.Base(open.Name())
+	if err := os.Chtimes(open.Name(), start, start); err != nil {
+		t.Fatal(err)
+	}
+
+	// The recording still being written is kept, however old.
+	now := start.Add(2 * time.Hour)
+	closed := createLocalRecording(t, lr, now, CastHeader{Version: 2}, 10)
+	if err := lr.Prune(now); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := localRecordingNames(t, lr.Dir), []string{openName, closed}; !slices.Equal(got, want) {
+		t.Errorf("recordings = %q, want %q", got, want)
+	}
+
+	if err := open.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := lr.Prune(now); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := localRecordingNames(t, lr.Dir), []string{closed}; !slices.Equal(got, want) {
+		t.Errorf("recordings after close = %q, want %q", got, want)
+	}
+}
+
+func TestListLocalRecordings(t *testing.T) {
+	dir := t.TempDir()
+	if recs, err := ListLocalRecordings(filepath.Join(dir, "missing")); err != nil || len(recs) != 0 {
+		t.Errorf("ListLocalRecordings of missing dir = %v, %v; want none", recs, err)
+	}
+
+	lr := &LocalRecorder{Dir: dir}
+	now := time.Unix(1700000000, 0)
+	name := createLocalRecording(t, lr, now, CastHeader{Version: 2, Timestamp: now.Unix(), SSHUser: "alice", Command: "ls"}, 10)
+	if err := os.WriteFile(filepath.Join(dir, "bad.cast"), []byte("not a header\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "other.txt"), nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(filepath.Join(dir, "bad.cast"), now.Add(time.Hour), now.Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+
+	recs, err := ListLocalRecordings(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(recs) != 2 || recs[0].Name != name || recs[1].Name != "bad.cast" {
+		t.Fatalf("recordings = %+v; want %q and bad.cast", recs, name)
+	}
+	if h := recs[0].Header; h == nil || h.SSHUser != "alice" || h.Command != "ls" {
+		t.Errorf("header = %+v", h)
+	}
+	if !recs[0].ModTime.Equal(now) {
+		t.Errorf("ModTime = %v, want %v", recs[0].ModTime, now)
+	}
+	if recs[1].Header != nil {
+		t.Errorf("header of bad.cast = %+v, want nil", recs[1].Header)
+	}
+
+	f, err := OpenLocalRecording(dir, name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := io.ReadAll(f)
+	f.Close()
+	if err != nil || int64(len(b)) != recs[0].Size {
+		t.Errorf("read %d bytes, %v; want %d", len(b), err, recs[0].Size)
+	}
+	for _, bad := range []string{"", "../x.cast", "sub/x.cast", ".x.cast", "other.txt", `a\b.cast`} {
+		if f, err := OpenLocalRecording(dir, bad); err == nil {
+			f.Close()
+			t.Errorf("OpenLocalRecording(%q) succeeded", bad)
+		}
+	}
+}
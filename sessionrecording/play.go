@@ -0,0 +1,145 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package sessionrecording
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// PlayOptions are the options of [Play].
+type PlayOptions struct {
+	// Speed is the factor to speed up playback by. If zero, recordings are
+	// played at the speed they were recorded at.
+	Speed float64
+
+	// MaxIdle, if non-zero, is the longest pause between events during
+	// playback, after speeding it up.
+	MaxIdle time.Duration
+}
+
+// Play replays the asciinema cast recording read from r to the terminal w,
+// with the timing it was recorded with. Terminal output is written as
+// recorded, and file transfers and forwarded channels are described on lines
+// of their own. It returns when the recording ends or ctx is done.
+//
+// A truncated final line, as of a recording still being written, is ignored.
+func Play(ctx context.Context, w io.Writer, r io.Reader, opts PlayOptions) error {
+	speed := opts.Speed
+	if speed <= 0 {
+		speed = 1
+	}
+	br := bufio.NewReader(r)
+	line, err := br.ReadBytes('\n')
+	if err != nil {
+		if err == io.EOF {
+			return errors.New("empty recording")
+		}
+		return err
+	}
+	var ch CastHeader
+	if err := json.Unmarshal(line, &ch); err != nil {
+		return fmt.Errorf("invalid recording header: %w", err)
+	}
+	if ch.Version != 2 {
+		return fmt.Errorf("unsupported recording version %d", ch.Version)
+	}
+
+	var last float64 // elapsed seconds of the last event
+	for n := 2; ; n++ {
+		line, err := br.ReadBytes('\n')
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		var ev []json.RawMessage
+		var elapsed float64
+		var code string
+		if err := json.Unmarshal(line, &ev); err != nil || len(ev) != 3 ||
+			json.Unmarshal(ev[0], &elapsed) != nil || json.Unmarshal(ev[1], &code) != nil {
+			return fmt.Errorf("invalid recording line %d", n)
+		}
+
+		if elapsed > last {
+			d := time.Duration((elapsed - last) / speed * float64(time.Second))
+			if opts.MaxIdle > 0 {
+				d = min(d, opts.MaxIdle)
+			}
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(d):
+			}
+			last = elapsed
+		}
+
+		var out string
+		switch code {
+		case "o":
+			if err := json.Unmarshal(ev[2], &out); err != nil {
+				return fmt.Errorf("invalid recording line %d: %w", n, err)
+			}
+		case FileTransferEventCode:
+			var ft FileTransfer
+			if err := json.Unmarshal(ev[2], &ft); err != nil {
+				return fmt.Errorf("invalid recording line %d: %w", n, err)
+			}
+			out = describeEvent(describeFileTransfer(ft))
+		case ForwardedChannelEventCode:
+			var fc ForwardedChannel
+			if err := json.Unmarshal(ev[2], &fc); err != nil {
+				return fmt.Errorf("invalid recording line %d: %w", n, err)
+			}
+			out = describeEvent(describeForwardedChannel(fc))
+		default:
+			// Input ("i"), terminal resizes ("r"), and events of
+			// codes we don't know about aren't played.
+			continue
+		}
+		if _, err := io.WriteString(w, out); err != nil {
+			return err
+		}
+	}
+}
+
+// describeEvent returns the line that a non-output event is played as.
+func describeEvent(s string) string {
+	return "\r\n[" + s + "]\r\n"
+}
+
+func describeFileTransfer(ft FileTransfer) string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "%s %s %s", ft.Protocol, ft.Op, ft.Path)
+	if ft.Name != "" {
+		fmt.Fprintf(&sb, " %s", ft.Name)
+	}
+	if ft.NewPath != "" {
+		fmt.Fprintf(&sb, " to %s", ft.NewPath)
+	}
+	if ft.Op == "upload" || ft.Op == "download" {
+		fmt.Fprintf(&sb, " (%d bytes)", ft.Bytes)
+	}
+	return sb.String()
+}
+
+func describeForwardedChannel(fc ForwardedChannel) string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "%s forwarding", fc.Type)
+	if fc.Origin != "" {
+		fmt.Fprintf(&sb, " from %s", fc.Origin)
+	}
+	if fc.Dest != "" {
+		fmt.Fprintf(&sb, " to %s", fc.Dest)
+	}
+	fmt.Fprintf(&sb, ": %d bytes from client, %d bytes to client in %.1fs", fc.BytesFromClient, fc.BytesToClient, fc.Duration)
+	return sb.String()
+}
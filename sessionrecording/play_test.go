@@ -0,0 +1,62 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package sessionrecording
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPlay(t *testing.T) {
+	const rec = `{"version":2,"width":80,"height":24,"timestamp":1700000000,"srcNode":"","srcNodeID":"","env":null,"sshUser":"alice","localUser":"alice","connectionID":"c"}
+[0.1,"o","hello "]
+[0.2,"i","ignored"]
+[0.3,"r","100x50"]
+[0.4,"o","world\r\n"]
+[0.5,"f",{"protocol":"scp","op":"upload","path":"/tmp","name":"a.txt","bytes":5}]
+[0.6,"c",{"type":"local","dest":"127.0.0.1:80","origin":"127.0.0.1:1234","bytesFromClient":1,"bytesToClient":2,"duration":1.5}]
+[0.7,"o","truncated`
+	const want = "hello world\r\n" +
+		"\r\n[scp upload /tmp a.txt (5 bytes)]\r\n" +
+		"\r\n[local forwarding from 127.0.0.1:1234 to 127.0.0.1:80: 1 bytes from client, 2 bytes to client in 1.5s]\r\n"
+
+	var out bytes.Buffer
+	start := time.Now()
+	if err := Play(context.Background(), &out, strings.NewReader(rec), PlayOptions{Speed: 10}); err != nil {
+		t.Fatal(err)
+	}
+	if got := out.String(); got != want {
+		t.Errorf("played %q, want %q", got, want)
+	}
+	if d := time.Since(start); d < 60*time.Millisecond {
+		t.Errorf("played in %v, want at least 60ms", d)
+	}
+
+	// A long pause is shortened to MaxIdle.
+	out.Reset()
+	start = time.Now()
+	long := `{"version":2}` + "\n" + `[3600,"o","late"]` + "\n"
+	if err := Play(context.Background(), &out, strings.NewReader(long), PlayOptions{MaxIdle: time.Millisecond}); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != "late" || time.Since(start) > 10*time.Second {
+		t.Errorf("played %q in %v", out.String(), time.Since(start))
+	}
+
+	// Playback stops when the context is done.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := Play(ctx, &out, strings.NewReader(long), PlayOptions{}); err != context.Canceled {
+		t.Errorf("Play with canceled context = %v, want %v", err, context.Canceled)
+	}
+
+	for _, bad := range []string{"", "not json\n", `{"version":1}` + "\n", `{"version":2}` + "\nnot an event\n"} {
+		if err := Play(context.Background(), &out, strings.NewReader(bad), PlayOptions{}); err == nil {
+			t.Errorf("Play(%q) succeeded", bad)
+		}
+	}
+}
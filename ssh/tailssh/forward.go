@@ -0,0 +1,258 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build (linux && !android) || (darwin && !ios) || freebsd || openbsd || plan9
+
+package tailssh
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	gossh "golang.org/x/crypto/ssh"
+	"tailscale.com/sessionrecording"
+	"tailscale.com/tailcfg"
+	"tailscale.com/tempfork/gliderlabs/ssh"
+)
+
+// This file contains the recording of port and agent forwarding. The
+// forwarded channels of a connection are recorded, as
+// sessionrecording.ForwardedChannel events, in a recording of their own that's
+// started when forwarding is first requested.
+
+// errForwardingRecordingFailed is the error that forwarded channels are closed
+// with when their recording fails and the SSHAction requires it.
+var errForwardingRecordingFailed = errors.New("forwarding recording failed")
+
+// forwardingState is the state of the recording of a connection's forwarded
+// channels.
+type forwardingState struct {
+	mu      sync.Mutex
+	started bool       // whether starting a recording has been attempted
+	rec     *recording // or nil if not recording
+	failed  bool       // whether recording is required and has failed
+	ctx     context.Context
+	cancel  context.CancelCauseFunc
+	chans   map[*forwardedChannel]bool // open channels being recorded
+}
+
+// requireForwardingRecording reports whether forwarding is only permitted
+// while recorded.
+func (c *conn) requireForwardingRecording() bool {
+	return c.finalAction != nil && c.finalAction.RequireForwardingRecording
+}
+
+// mayForward reports whether port or agent forwarding is permitted by
+// recording, starting the recording of forwarded channels if need be.
+// It's only false if the SSHAction requires forwarding to be recorded and
+// no recording is available.
+func (c *conn) mayForward() bool {
+	rec, err := c.startForwardingRecording()
+	if err != nil {
+		c.logf("recording: can't record forwarding: %v", err)
+	}
+	if !c.requireForwardingRecording() {
+		return true
+	}
+	return rec != nil
+}
+
+// startForwardingRecording starts the recording of forwarded channels if it
+// hasn't been started, and returns it. It returns a nil recording if the
+// connection isn't recorded or the recording isn't available.
+func (c *conn) startForwardingRecording() (*recording, error) {
+	fs := &c.fwd
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if fs.failed {
+		return nil, errForwardingRecordingFailed
+	}
+	if fs.started {
+		return fs.rec, nil
+	}
+	fs.started = true
+	rec, err := c.startForwardingRecordingLocked()
+	if err != nil && c.requireForwardingRecording() {
+		// Remember the failure, so that later forwarding requests and
+		// channels are refused rather than passed through unrecorded.
+		fs.failed = true
+	}
+	fs.rec = rec
+	return rec, err
+}
+
+// startForwardingRecordingLocked starts the recording of forwarded channels.
+// It returns a nil recording if the connection isn't recorded.
+//
+// c.fwd.mu must be held.
+func (c *conn) startForwardingRecordingLocked() (*recording, error) {
+	fs := &c.fwd
+	if !c.shouldRecord() {
+		if c.requireForwardingRecording() {
+			return nil, errors.New("no recorders configured")
+		}
+		return nil, nil
+	}
+
+	// Forwarding recordings notify control of failures the same way that
+	// session recordings do, but only fail closed if the SSHAction requires
+	// forwarding to be recorded.
+	onFailure := new(tailcfg.SSHRecorderFailureAction)
+	if _, f := c.recorders(); f != nil {
+		onFailure.NotifyURL = f.NotifyURL
+	}
+	if c.requireForwardingRecording() {
+		onFailure.RejectSessionWithMessage = "forwarding requires recording"
+		onFailure.TerminateSessionWithMessage = "forwarding requires recording"
+	}
+	fs.ctx, fs.cancel = context.WithCancelCause(context.Background())
+	rec, err := c.startRecording(fs.ctx, c.failForwardingRecording, c.logf, "forwarding", onFailure, sessionrecording.CastHeader{
+		Forwarding: true,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return rec, nil
+}
+
+// failForwardingRecording is called when the recording of forwarded channels
+// fails and must not fail open. It closes the open forwarded channels, and
+// causes new ones to be rejected or closed.
+func (c *conn) failForwardingRecording(err error) {
+	fs := &c.fwd
+	fs.mu.Lock()
+	chans := fs.chans
+	fs.chans = nil
+	fs.failed = true
+	fs.mu.Unlock()
+	c.logf("recording: closing forwarded channels: %v", err)
+	for ch := range chans {
+		ch.Channel.Close()
+	}
+}
+
+// endForwardingRecording records the forwarded channels still open at the end
+// of the connection, and closes the recording of forwarded channels.
+func (c *conn) endForwardingRecording() {
+	fs := &c.fwd
+	fs.mu.Lock()
+	chans := fs.chans
+	fs.chans = nil
+	fs.mu.Unlock()
+	for ch := range chans {
+		ch.record()
+	}
+
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if fs.cancel != nil {
+		fs.cancel(errSessionDone)
+	}
+	if fs.rec != nil {
+		fs.rec.Close()
+	}
+}
+
+// forwardedChannelTypes maps the SSH channel types of forwarding to the types
+// of recorded sessionrecording.ForwardedChannel events.
+var forwardedChannelTypes = map[string]string{
+	"direct-tcpip":           "local",
+	"forwarded-tcpip":        "remote",
+	"auth-agent@openssh.com": "agent",
+}
+
+// wrapForwardedChannel is the ssh.ForwardedChannelCallback of a connection. It
+// returns ch wrapped to be recorded, if forwarded channels are recorded.
+func (c *conn) wrapForwardedChannel(ctx ssh.Context, channelType, dest, origin string, ch gossh.Channel) gossh.Channel {
+	rec, err := c.startForwardingRecording()
+	if rec == nil {
+		if c.requireForwardingRecording() {
+			c.logf("recording: closing forwarded %s channel: %v", channelType, cmp.Or(err, errForwardingRecordingFailed))
+			ch.Close()
+		}
+		return ch
+	}
+	fc := &forwardedChannel{
+		Channel: ch,
+		c:       c,
+		rec:     rec,
+		start:   time.Now(),
+		ev: sessionrecording.ForwardedChannel{
+			Type:   forwardedChannelTypes[channelType],
+			Dest:   dest,
+			Origin: origin,
+		},
+	}
+	fs := &c.fwd
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if fs.failed {
+		ch.Close()
+		return ch
+	}
+	if fs.chans == nil {
+		fs.chans = make(map[*forwardedChannel]bool)
+	}
+	fs.chans[fc] = true
+	return fc
+}
+
+// forwardedChannel is a forwarded gossh.Channel that counts the bytes sent
+// over it, and records them when it's closed.
+type forwardedChannel struct {
+	gossh.Channel
+	c     *conn
+	rec   *recording
+	start time.Time
+	ev    sessionrecording.ForwardedChannel
+
+	fromClient atomic.Int64
+	toClient   atomic.Int64
+	recordOnce sync.Once
+}
+
+func (ch *forwardedChannel) Read(p []byte) (int, error) {
+	n, err := ch.Channel.Read(p)
+	ch.fromClient.Add(int64(n))
+	return n, err
+}
+
+func (ch *forwardedChannel) Write(p []byte) (int, error) {
+	n, err := ch.Channel.Write(p)
+	ch.toClient.Add(int64(n))
+	return n, err
+}
+
+func (ch *forwardedChannel) Close() error {
+	err := ch.Channel.Close()
+	fs := &ch.c.fwd
+	fs.mu.Lock()
+	_, open := fs.chans[ch]
+	delete(fs.chans, ch)
+	fs.mu.Unlock()
+	if open {
+		ch.record()
+	}
+	return err
+}
+
+// record writes the channel's event to the recording.
+func (ch *forwardedChannel) record() {
+	ch.recordOnce.Do(func() {
+		ev := ch.ev
+		ev.BytesFromClient = ch.fromClient.Load()
+		ev.BytesToClient = ch.toClient.Load()
+		ev.Duration = time.Since(ch.start).Seconds()
+		if err := ch.rec.writeEvent(sessionrecording.ForwardedChannelEventCode, ev); err != nil {
+			if ch.rec.failOpen {
+				ch.c.logf("recording: error recording forwarded channel (failing open): %v", err)
+				return
+			}
+			ch.c.failForwardingRecording(err)
+		}
+	})
+}
@@ -0,0 +1,213 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build linux || darwin
+
+package tailssh
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"tailscale.com/envknob"
+	"tailscale.com/net/memnet"
+	"tailscale.com/sessionrecording"
+	"tailscale.com/tailcfg"
+	testssh "tailscale.com/tempfork/sshtest/ssh"
+	"tailscale.com/tstest"
+	"tailscale.com/util/must"
+)
+
+func setRecordToLocalDisk(t *testing.T, on bool) {
+	envknob.Setenv("TS_DEBUG_LOG_SSH", fmt.Sprint(on))
+	t.Cleanup(func() { envknob.Setenv("TS_DEBUG_LOG_SSH", "") })
+}
+
+// echoServer returns the address of a TCP server that echoes what it reads.
+func echoServer(t *testing.T) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer c.Close()
+				io.Copy(c, c)
+			}()
+		}
+	}()
+	return ln.Addr().String()
+}
+
+func TestForwardingRecording(t *testing.T) {
+	echoAddr := echoServer(t)
+	tests := []struct {
+		name      string
+		require   bool
+		record    bool // to local disk
+		wantError bool
+	}{
+		{name: "recorded", record: true},
+		{name: "required-and-recorded", require: true, record: true},
+		{name: "not-recorded"},
+		{name: "required-not-recorded", require: true, wantError: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setRecordToLocalDisk(t, tt.record)
+			varRoot := t.TempDir()
+			s := &server{
+				logf: tstest.WhileTestRunningLogger(t),
+				lb: &localState{
+					sshEnabled: true,
+					varRoot:    varRoot,
+					matchingRule: newSSHRule(&tailcfg.SSHAction{
+						Accept:                     true,
+						AllowLocalPortForwarding:   true,
+						RequireForwardingRecording: tt.require,
+					}),
+				},
+			}
+			defer s.Shutdown()
+
+			src, dst := must.Get(netip.ParseAddrPort("100.100.100.101:2231")), must.Get(netip.ParseAddrPort("100.100.100.102:22"))
+			sc, dc := memnet.NewTCPConn(src, dst, 1024)
+			cfg := &testssh.ClientConfig{
+				User:            "alice",
+				HostKeyCallback: testssh.InsecureIgnoreHostKey(),
+			}
+			var wg sync.WaitGroup
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				c, chans, reqs, err := testssh.NewClientConn(sc, sc.RemoteAddr().String(), cfg)
+				if err != nil {
+					t.Errorf("client: %v", err)
+					return
+				}
+				client := testssh.NewClient(c, chans, reqs)
+				defer client.Close()
+				fc, err := client.Dial("tcp", echoAddr)
+				if err != nil {
+					if !tt.wantError {
+						t.Errorf("Dial: %v", err)
+					}
+					return
+				}
+				defer fc.Close()
+				if tt.wantError {
+					t.Errorf("Dial succeeded; want error")
+				}
+				if _, err := io.WriteString(fc, "hello"); err != nil {
+					t.Errorf("Write: %v", err)
+					return
+				}
+				buf := make([]byte, 5)
+				if _, err := io.ReadFull(fc, buf); err != nil || string(buf) != "hello" {
+					t.Errorf("ReadFull = %q, %v; want %q", buf, err, "hello")
+				}
+			}()
+			if err := s.HandleSSHConn(dc); err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+			wg.Wait()
+
+			files, err := filepath.Glob(filepath.Join(varRoot, "ssh-sessions", "ssh-forwarding-*.cast"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !tt.record {
+				if len(files) != 0 {
+					t.Errorf("recordings = %q; want none", files)
+				}
+				return
+			}
+			if len(files) != 1 {
+				t.Fatalf("recordings = %q; want one", files)
+			}
+			ch, evs := readForwardingRecording(t, files[0])
+			if !ch.Forwarding || ch.SSHUser != "alice" {
+				t.Errorf("header Forwarding = %v, SSHUser = %q; want true, %q", ch.Forwarding, ch.SSHUser, "alice")
+			}
+			if len(evs) != 1 {
+				t.Fatalf("events = %+v; want one", evs)
+			}
+			ev := evs[0]
+			if ev.Type != "local" || ev.Dest != echoAddr || ev.BytesFromClient != 5 || ev.BytesToClient != 5 {
+				t.Errorf("event = %+v; want local channel to %s with 5 bytes each way", ev, echoAddr)
+			}
+		})
+	}
+}
+
+func TestForwardingRecordingFailureRemembered(t *testing.T) {
+	setRecordToLocalDisk(t, false)
+	c := &conn{
+		srv:     &server{logf: tstest.WhileTestRunningLogger(t)},
+		action0: &tailcfg.SSHAction{},
+		finalAction: &tailcfg.SSHAction{
+			Accept:                     true,
+			AllowLocalPortForwarding:   true,
+			RequireForwardingRecording: true,
+		},
+	}
+	for i := range 2 {
+		if rec, err := c.startForwardingRecording(); rec != nil || err == nil {
+			t.Errorf("attempt %d: startForwardingRecording = %v, %v; want error", i, rec, err)
+		}
+		if c.mayForward() {
+			t.Errorf("attempt %d: mayForward = true; want false", i)
+		}
+	}
+}
+
+// readForwardingRecording returns the header and ForwardedChannel events of
+// the recording in file.
+func readForwardingRecording(t *testing.T, file string) (sessionrecording.CastHeader, []sessionrecording.ForwardedChannel) {
+	t.Helper()
+	rec, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sc := bufio.NewScanner(bytes.NewReader(rec))
+	var ch sessionrecording.CastHeader
+	if !sc.Scan() {
+		t.Fatalf("empty recording")
+	}
+	if err := json.Unmarshal(sc.Bytes(), &ch); err != nil {
+		t.Fatal(err)
+	}
+	var evs []sessionrecording.ForwardedChannel
+	for sc.Scan() {
+		var ev []json.RawMessage
+		if err := json.Unmarshal(sc.Bytes(), &ev); err != nil || len(ev) != 3 {
+			t.Fatalf("bad cast line %q: %v", sc.Bytes(), err)
+		}
+		var code string
+		json.Unmarshal(ev[1], &code)
+		if code != sessionrecording.ForwardedChannelEventCode {
+			continue
+		}
+		var fc sessionrecording.ForwardedChannel
+		if err := json.Unmarshal(ev[2], &fc); err != nil {
+			t.Fatal(err)
+		}
+		evs = append(evs, fc)
+	}
+	return ch, evs
+}
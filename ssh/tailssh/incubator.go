@@ -57,6 +57,7 @@
 func init() {
 	childproc.Add("ssh", beIncubator)
 	childproc.Add("sftp", beSFTP)
+	childproc.Add("scp", beSCP)
 }
 
 var ptyName = func(f *os.File) (string, error) {
@@ -225,7 +226,12 @@ func (ss *sshSession) newIncubatorCommand(logf logger.Logf) (cmd *exec.Cmd, err
 	case isShell:
 		incubatorArgs = append(incubatorArgs, "--shell")
 	default:
-		incubatorArgs = append(incubatorArgs, "--cmd="+ss.RawCommand())
+		cmd := ss.RawCommand()
+		if scpCmd, ok := ss.builtinSCPCommand(); ok {
+			logf("serving scp with the built-in scp server")
+			cmd = scpCmd
+		}
+		incubatorArgs = append(incubatorArgs, "--cmd="+cmd)
 	}
 
 	allowSendEnv := nm.HasCap(tailcfg.NodeAttrSSHEnvironmentVariables)
@@ -0,0 +1,165 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build (linux && !android) || (darwin && !ios) || freebsd || openbsd || plan9
+
+package tailssh
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"math"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"tailscale.com/client/tailscale/apitype"
+	"tailscale.com/envknob"
+	"tailscale.com/ipn/localapi"
+	"tailscale.com/sessionrecording"
+	"tailscale.com/util/syspolicy/pkey"
+	"tailscale.com/util/syspolicy/policyclient"
+)
+
+// The following knobs configure the local storage of SSH session
+// recordings, for tailnets without recorder nodes. Sessions are recorded
+// locally only if the SSH policy doesn't configure recorders for them.
+var (
+	// sshRecordingDir is the directory to store SSH session recordings
+	// in. If empty, sessions aren't recorded locally, unless
+	// TS_DEBUG_LOG_SSH is set, in which case they're stored in the
+	// ssh-sessions directory in the var root.
+	sshRecordingDir = envknob.RegisterString("TS_SSH_RECORDING_DIR")
+
+	// sshRecordingMaxAge, sshRecordingMaxFiles and sshRecordingMaxBytes
+	// are the retention limits of locally stored recordings, which are
+	// unlimited if zero, unless they're set by the system policy settings
+	// pkey.SSHRecordingMaxAge, pkey.SSHRecordingMaxFiles and
+	// pkey.SSHRecordingMaxBytes. See [sessionrecording.LocalRecorder].
+	sshRecordingMaxAge   = envknob.RegisterDuration("TS_SSH_RECORDING_MAX_AGE")
+	sshRecordingMaxFiles = envknob.RegisterInt("TS_SSH_RECORDING_MAX_FILES")
+	sshRecordingMaxBytes = envknob.RegisterInt("TS_SSH_RECORDING_MAX_BYTES")
+)
+
+func init() {
+	localapi.Register("ssh-recordings/", serveSSHRecordings)
+}
+
+// recordLocally reports whether SSH sessions without recorders are recorded
+// to local storage.
+func recordLocally() bool {
+	return sshRecordingDir() != "" || recordSSHToLocalDisk()
+}
+
+var errNoLocalRecordings = errors.New("SSH sessions are not recorded locally")
+
+// localRecorder returns the recorder of SSH sessions to local storage, given
+// tailscaled's var root and its system policy. It returns
+// errNoLocalRecordings if sessions aren't recorded locally.
+func localRecorder(varRoot string, polc policyclient.Client) (*sessionrecording.LocalRecorder, error) {
+	dir := sshRecordingDir()
+	if dir == "" {
+		if !recordSSHToLocalDisk() {
+			return nil, errNoLocalRecordings
+		}
+		if varRoot == "" {
+			return nil, errors.New("no var root for recording storage")
+		}
+		dir = filepath.Join(varRoot, "ssh-sessions")
+	}
+	lr := &sessionrecording.LocalRecorder{
+		Dir:      dir,
+		MaxAge:   sshRecordingMaxAge(),
+		MaxFiles: sshRecordingMaxFiles(),
+		MaxBytes: int64(sshRecordingMaxBytes()),
+	}
+	if v, err := polc.GetDuration(pkey.SSHRecordingMaxAge, lr.MaxAge); err == nil {
+		lr.MaxAge = v
+	}
+	if v, err := polc.GetUint64(pkey.SSHRecordingMaxFiles, uint64(lr.MaxFiles)); err == nil && v <= math.MaxInt32 {
+		lr.MaxFiles = int(v)
+	}
+	if v, err := polc.GetUint64(pkey.SSHRecordingMaxBytes, uint64(lr.MaxBytes)); err == nil && v <= math.MaxInt64 {
+		lr.MaxBytes = int64(v)
+	}
+	return lr, nil
+}
+
+// serveSSHRecordings serves the LocalAPI endpoint /ssh-recordings/, which
+// lists the locally stored SSH session recordings, and
+// /ssh-recordings/<name>, which returns one of them.
+func serveSSHRecordings(h *localapi.Handler, w http.ResponseWriter, r *http.Request) {
+	// Recordings are of everything that sessions output, so only those
+	// permitted to change the node's configuration may read them.
+	if !h.PermitWrite {
+		http.Error(w, "access denied", http.StatusForbidden)
+		return
+	}
+	if r.Method != "GET" {
+		http.Error(w, "want GET", http.StatusMethodNotAllowed)
+		return
+	}
+	lb := h.LocalBackend()
+	lr, err := localRecorder(lb.TailscaleVarRoot(), lb.PolicyClient())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	name, ok := strings.CutPrefix(r.URL.Path, "/localapi/v0/ssh-recordings/")
+	if !ok {
+		http.Error(w, "misconfigured", http.StatusInternalServerError)
+		return
+	}
+	if name != "" {
+		f, err := sessionrecording.OpenLocalRecording(lr.Dir, name)
+		if errors.Is(err, fs.ErrNotExist) {
+			http.Error(w, "recording not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		defer f.Close()
+		fi, err := f.Stat()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/x-asciicast")
+		http.ServeContent(w, r, name, fi.ModTime(), f)
+		return
+	}
+
+	// Don't list recordings past their retention.
+	if err := lr.Prune(time.Now()); err != nil {
+		h.Logf("ssh-recordings: %v", err)
+	}
+	recs, err := sessionrecording.ListLocalRecordings(lr.Dir)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	res := make([]apitype.SSHRecording, 0, len(recs))
+	for _, rec := range recs {
+		sr := apitype.SSHRecording{
+			Name:    rec.Name,
+			Size:    rec.Size,
+			ModTime: rec.ModTime,
+		}
+		if ch := rec.Header; ch != nil {
+			sr.Start = time.Unix(ch.Timestamp, 0)
+			sr.SSHUser = ch.SSHUser
+			sr.LocalUser = ch.LocalUser
+			sr.SrcNode = ch.SrcNode
+			sr.Command = ch.Command
+			sr.Forwarding = ch.Forwarding
+			sr.ConnectionID = ch.ConnectionID
+		}
+		res = append(res, sr)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(res)
+}
@@ -0,0 +1,213 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build linux || darwin
+
+package tailssh
+
+import (
+	"crypto/ed25519"
+	"fmt"
+	"net/netip"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	gossh "golang.org/x/crypto/ssh"
+	"tailscale.com/envknob"
+	"tailscale.com/net/memnet"
+	"tailscale.com/sessionrecording"
+	"tailscale.com/tailcfg"
+	testssh "tailscale.com/tempfork/sshtest/ssh"
+	"tailscale.com/tstest"
+	"tailscale.com/util/must"
+	"tailscale.com/util/syspolicy/pkey"
+	"tailscale.com/util/syspolicy/policyclient"
+	"tailscale.com/util/syspolicy/policytest"
+)
+
+func TestLocalRecorder(t *testing.T) {
+	setEnv := func(k, v string) {
+		envknob.Setenv(k, v)
+		t.Cleanup(func() { envknob.Setenv(k, "") })
+	}
+
+	if _, err := localRecorder("/var/lib/tailscale", policyclient.NoPolicyClient{}); err != errNoLocalRecordings {
+		t.Errorf("localRecorder without knobs: err = %v, want %v", err, errNoLocalRecordings)
+	}
+	setRecordToLocalDisk(t, true)
+	if lr, err := localRecorder("/var/lib/tailscale", policyclient.NoPolicyClient{}); err != nil || lr.Dir != "/var/lib/tailscale/ssh-sessions" {
+		t.Errorf("localRecorder with TS_DEBUG_LOG_SSH = %+v, %v", lr, err)
+	}
+	if _, err := localRecorder("", policyclient.NoPolicyClient{}); err == nil {
+		t.Errorf("localRecorder with TS_DEBUG_LOG_SSH and no var root succeeded")
+	}
+
+	setEnv("TS_SSH_RECORDING_DIR", "/recordings")
+	setEnv("TS_SSH_RECORDING_MAX_AGE", "24h")
+	setEnv("TS_SSH_RECORDING_MAX_FILES", "10")
+	setEnv("TS_SSH_RECORDING_MAX_BYTES", "1000000")
+	lr, err := localRecorder("", policyclient.NoPolicyClient{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := sessionrecording.LocalRecorder{Dir: "/recordings", MaxAge: 24 * 60 * 60e9, MaxFiles: 10, MaxBytes: 1000000}
+	if *lr != want {
+		t.Errorf("localRecorder = %+v, want %+v", *lr, want)
+	}
+
+	// System policy takes precedence over the environment.
+	polc := policytest.Config{
+		pkey.SSHRecordingMaxAge:   time.Hour,
+		pkey.SSHRecordingMaxFiles: uint64(5),
+	}
+	lr, err = localRecorder("", polc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = sessionrecording.LocalRecorder{Dir: "/recordings", MaxAge: time.Hour, MaxFiles: 5, MaxBytes: 1000000}
+	if *lr != want {
+		t.Errorf("localRecorder with policy = %+v, want %+v", *lr, want)
+	}
+}
+
+func TestSSHRecordingLocalDir(t *testing.T) {
+	dir := t.TempDir()
+	envknob.Setenv("TS_SSH_RECORDING_DIR", dir)
+	envknob.Setenv("TS_SSH_RECORDING_MAX_FILES", "2")
+	t.Cleanup(func() {
+		envknob.Setenv("TS_SSH_RECORDING_DIR", "")
+		envknob.Setenv("TS_SSH_RECORDING_MAX_FILES", "")
+	})
+
+	s := &server{
+		logf: tstest.WhileTestRunningLogger(t),
+		lb: &localState{
+			sshEnabled:   true,
+			matchingRule: newSSHRule(&tailcfg.SSHAction{Accept: true}),
+		},
+	}
+	defer s.Shutdown()
+	src, dst := must.Get(netip.ParseAddrPort("100.100.100.101:2231")), must.Get(netip.ParseAddrPort("100.100.100.102:22"))
+	for i := range 3 {
+		sc, dc := memnet.NewTCPConn(src, dst, 1024)
+		cfg := &testssh.ClientConfig{
+			User:            "alice",
+			HostKeyCallback: testssh.InsecureIgnoreHostKey(),
+		}
+		var wg sync.WaitGroup
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c, chans, reqs, err := testssh.NewClientConn(sc, sc.RemoteAddr().String(), cfg)
+			if err != nil {
+				t.Errorf("client: %v", err)
+				return
+			}
+			client := testssh.NewClient(c, chans, reqs)
+			defer client.Close()
+			session, err := client.NewSession()
+			if err != nil {
+				t.Errorf("client: %v", err)
+				return
+			}
+			defer session.Close()
+			if _, err := session.CombinedOutput(fmt.Sprintf("echo %d", i)); err != nil {
+				t.Errorf("client: %v", err)
+			}
+		}()
+		if err := s.HandleSSHConn(dc); err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+		wg.Wait()
+	}
+
+	recs, err := sessionrecording.ListLocalRecordings(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, rec := range recs {
+		if rec.Header == nil {
+			t.Fatalf("recording %s has no header", rec.Name)
+		}
+		got = append(got, rec.Header.Command)
+		if m, _ := filepath.Match("ssh-session-*.cast", rec.Name); !m {
+			t.Errorf("recording name %q", rec.Name)
+		}
+	}
+	// The oldest recording is deleted to keep to TS_SSH_RECORDING_MAX_FILES.
+	if fmt.Sprint(got) != "[echo 1 echo 2]" {
+		t.Errorf("recorded commands = %q, want [echo 1 echo 2]", got)
+	}
+}
+
+func TestSSHRecordingTamperEvident(t *testing.T) {
+	dir := t.TempDir()
+	envknob.Setenv("TS_SSH_RECORDING_DIR", dir)
+	t.Cleanup(func() { envknob.Setenv("TS_SSH_RECORDING_DIR", "") })
+
+	lb := &localState{
+		sshEnabled:   true,
+		matchingRule: newSSHRule(&tailcfg.SSHAction{Accept: true, TamperEvidentRecording: true}),
+	}
+	s := &server{
+		logf: tstest.WhileTestRunningLogger(t),
+		lb:   lb,
+	}
+	defer s.Shutdown()
+	src, dst := must.Get(netip.ParseAddrPort("100.100.100.101:2231")), must.Get(netip.ParseAddrPort("100.100.100.102:22"))
+	sc, dc := memnet.NewTCPConn(src, dst, 1024)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		c, chans, reqs, err := testssh.NewClientConn(sc, sc.RemoteAddr().String(), &testssh.ClientConfig{
+			User:            "alice",
+			HostKeyCallback: testssh.InsecureIgnoreHostKey(),
+		})
+		if err != nil {
+			t.Errorf("client: %v", err)
+			return
+		}
+		client := testssh.NewClient(c, chans, reqs)
+		defer client.Close()
+		session, err := client.NewSession()
+		if err != nil {
+			t.Errorf("client: %v", err)
+			return
+		}
+		defer session.Close()
+		if _, err := session.CombinedOutput("echo hello"); err != nil {
+			t.Errorf("client: %v", err)
+		}
+	}()
+	if err := s.HandleSSHConn(dc); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	wg.Wait()
+
+	recs, err := sessionrecording.ListLocalRecordings(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(recs) != 1 {
+		t.Fatalf("got %d recordings, want 1", len(recs))
+	}
+	f, err := sessionrecording.OpenLocalRecording(dir, recs[0].Name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	// The recording is signed with the node's Ed25519 host key.
+	keys := must.Get(lb.GetSSH_HostKeys())
+	pub := keys[0].PublicKey().(gossh.CryptoPublicKey).CryptoPublicKey().(ed25519.PublicKey)
+	v, err := sessionrecording.VerifyChain(f, []ed25519.PublicKey{pub})
+	if err != nil {
+		t.Fatalf("VerifyChain: %v", err)
+	}
+	if !v.Complete || v.Header.Command != "echo hello" {
+		t.Errorf("VerifyChain = %+v", v)
+	}
+}
@@ -0,0 +1,490 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+// This file contains a built-in server for the legacy scp protocol, used by
+// scp clients in their original mode (scp -O) and by older ones always. It
+// serves scp commands on hosts without an scp binary, running within the
+// incubator with the local user's privileges like the SFTP server.
+
+//go:build (linux && !android) || (darwin && !ios) || freebsd || openbsd
+
+package tailssh
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"tailscale.com/envknob"
+)
+
+// sshBuiltinSCP is whether scp commands are served by the built-in scp
+// server. If unset, they are only when there's no scp binary on the PATH.
+var sshBuiltinSCP = envknob.RegisterOptBool("TS_SSH_BUILTIN_SCP")
+
+// builtinSCPCommand returns the command to run in place of the session's
+// command to serve it with the built-in scp server, and whether it should
+// be.
+func (ss *sshSession) builtinSCPCommand() (cmd string, ok bool) {
+	args := ss.Command()
+	if _, ok := parseSCPArgs(args); !ok {
+		return "", false
+	}
+	if use, ok := sshBuiltinSCP().Get(); ok {
+		if !use {
+			return "", false
+		}
+	} else if _, err := exec.LookPath("scp"); err == nil {
+		return "", false
+	}
+	words := []string{shellQuote(ss.conn.srv.tailscaledPath), "be-child", "scp"}
+	for _, a := range args[1:] {
+		words = append(words, shellQuote(a))
+	}
+	return strings.Join(words, " "), true
+}
+
+// shellQuote quotes s as a single word for a POSIX shell.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// beSCP serves scp in-process, with args being those of scp in server mode
+// after "scp".
+func beSCP(args []string) error {
+	a, ok := parseSCPArgs(append([]string{"scp"}, args...))
+	if !ok {
+		return fmt.Errorf("unsupported scp arguments %q", args)
+	}
+	return serveSCP(os.Stdin, os.Stdout, a)
+}
+
+// errSCPWarnings is returned by serveSCP when some files couldn't be
+// transferred, as reported to the client.
+var errSCPWarnings = errors.New("scp: some files could not be transferred")
+
+// serveSCP serves an scp client, reading from r and writing to w, as scp in
+// server mode with arguments a.
+func serveSCP(r io.Reader, w io.Writer, a scpArgs) error {
+	s := &scpServer{r: bufio.NewReader(r), w: w, args: a}
+	var err error
+	if a.sink {
+		err = s.sink()
+	} else {
+		err = s.source()
+	}
+	if err == nil && s.warned {
+		err = errSCPWarnings
+	}
+	return err
+}
+
+type scpServer struct {
+	r      *bufio.Reader
+	w      io.Writer
+	args   scpArgs
+	warned bool // whether a warning was sent to the client
+}
+
+// ack acknowledges a protocol line or file.
+func (s *scpServer) ack() error {
+	_, err := s.w.Write([]byte{0})
+	return err
+}
+
+// warn sends a warning to the client, which reports it and goes on to the
+// next file.
+func (s *scpServer) warn(format string, args ...any) error {
+	s.warned = true
+	_, err := fmt.Fprintf(s.w, "\x01scp: %s\n", strings.ReplaceAll(fmt.Sprintf(format, args...), "\n", " "))
+	return err
+}
+
+// fatal sends a fatal error to the client and returns it.
+func (s *scpServer) fatal(format string, args ...any) error {
+	msg := strings.ReplaceAll(fmt.Sprintf(format, args...), "\n", " ")
+	fmt.Fprintf(s.w, "\x02scp: %s\n", msg)
+	return errors.New("scp: " + msg)
+}
+
+// response reads the client's response to a protocol line or file. It
+// returns a non-nil error for warnings, and fatal is whether the client
+// gave up.
+func (s *scpServer) response() (fatal bool, err error) {
+	b, err := s.r.ReadByte()
+	if err != nil {
+		return true, err
+	}
+	if b == 0 {
+		return false, nil
+	}
+	msg, err := s.r.ReadString('\n')
+	if err != nil {
+		return true, err
+	}
+	msg = strings.TrimSuffix(msg, "\n")
+	switch b {
+	case 1:
+		return false, errors.New(msg)
+	case 2:
+		return true, errors.New(msg)
+	}
+	return true, fmt.Errorf("scp: unexpected response %q", string(b)+msg)
+}
+
+// sink receives files from the client into args.paths[0].
+func (s *scpServer) sink() error {
+	target := s.args.paths[0]
+	fi, err := os.Stat(target)
+	targetIsDir := err == nil && fi.IsDir()
+	if s.args.targetDir && !targetIsDir {
+		return s.fatal("%s: Not a directory", target)
+	}
+	if err := s.ack(); err != nil {
+		return err
+	}
+	var dirs []scpDir          // directories being received into, innermost last
+	var mtime, atime time.Time // of the next file or directory, if sent
+	for {
+		line, err := s.r.ReadString('\n')
+		if err == io.EOF && line == "" {
+			if len(dirs) > 0 {
+				return io.ErrUnexpectedEOF
+			}
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		line = strings.TrimSuffix(line, "\n")
+		if line == "" {
+			return s.fatal("unexpected <newline>")
+		}
+		switch line[0] {
+		case 1:
+			// The client couldn't send a file, and has reported it.
+			s.warned = true
+			continue
+		case 2:
+			return errors.New(line[1:])
+		case 'E':
+			if len(dirs) == 0 {
+				return s.fatal("unexpected end of directory")
+			}
+			dir := dirs[len(dirs)-1]
+			dirs = dirs[:len(dirs)-1]
+			if !dir.mtime.IsZero() {
+				os.Chtimes(dir.path, dir.atime, dir.mtime)
+			}
+			if err := s.ack(); err != nil {
+				return err
+			}
+			continue
+		case 'T':
+			mtime, atime, err = parseSCPTimes(line[1:])
+			if err != nil {
+				return s.fatal("%v", err)
+			}
+			if err := s.ack(); err != nil {
+				return err
+			}
+			continue
+		case 'C', 'D':
+		default:
+			return s.fatal("expected control record")
+		}
+
+		mode, size, name, err := parseSCPEntry(line[1:])
+		if err != nil {
+			return s.fatal("%v", err)
+		}
+		if strings.Contains(name, "/") || name == "." || name == ".." {
+			return s.fatal("error: unexpected filename: %s", name)
+		}
+		dst := target
+		switch {
+		case len(dirs) > 0:
+			dst = filepath.Join(dirs[len(dirs)-1].path, name)
+		case targetIsDir:
+			dst = filepath.Join(target, name)
+		}
+
+		if line[0] == 'D' {
+			if !s.args.recursive {
+				return s.fatal("received directory without -r")
+			}
+			if fi, err := os.Stat(dst); err == nil {
+				if !fi.IsDir() {
+					return s.fatal("%s: Not a directory", dst)
+				}
+				if s.args.preserve {
+					os.Chmod(dst, mode)
+				}
+			} else if err := os.Mkdir(dst, mode|0o700); err != nil {
+				return s.fatal("%v", err)
+			}
+			dirs = append(dirs, scpDir{dst, mtime, atime})
+			mtime, atime = time.Time{}, time.Time{}
+			if err := s.ack(); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if err := s.receiveFile(dst, mode, size); err != nil {
+			return err
+		}
+		if !mtime.IsZero() {
+			os.Chtimes(dst, atime, mtime)
+			mtime, atime = time.Time{}, time.Time{}
+		}
+	}
+}
+
+// scpDir is a directory being received.
+type scpDir struct {
+	path         string
+	mtime, atime time.Time // to set once received, if non-zero
+}
+
+// receiveFile receives size bytes of a file's data from the client into
+// dst. Errors writing the file are sent to the client as warnings; only
+// errors of the connection are returned.
+func (s *scpServer) receiveFile(dst string, mode fs.FileMode, size int64) error {
+	f, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE, mode|0o200)
+	if err != nil {
+		// The client sends no data for files that aren't acknowledged.
+		return s.warn("%v", err)
+	}
+	defer f.Close()
+	if err := s.ack(); err != nil {
+		return err
+	}
+	// Keep reading the file's data if writing it fails, so the protocol
+	// stays in sync.
+	fw := &scpFileWriter{w: f}
+	if _, err := io.CopyN(fw, s.r, size); err != nil {
+		return err
+	}
+	werr := fw.err
+	if werr == nil {
+		werr = f.Truncate(size)
+	}
+	if werr == nil && s.args.preserve {
+		werr = f.Chmod(mode)
+	}
+	if werr == nil {
+		werr = f.Close()
+	}
+	if fatal, err := s.response(); err != nil {
+		if fatal {
+			return err
+		}
+		s.warned = true
+		return nil
+	}
+	if werr != nil {
+		return s.warn("%s: %v", dst, werr)
+	}
+	return s.ack()
+}
+
+// parseSCPTimes parses the rest of an scp "T" protocol line, after its
+// first byte, as in "1700000000 0 1700000000 0".
+func parseSCPTimes(s string) (mtime, atime time.Time, err error) {
+	f := strings.Fields(s)
+	if len(f) != 4 {
+		return time.Time{}, time.Time{}, fmt.Errorf("malformed scp times %q", s)
+	}
+	var v [4]int64
+	for i := range f {
+		v[i], err = strconv.ParseInt(f[i], 10, 64)
+		if err != nil || v[i] < 0 {
+			return time.Time{}, time.Time{}, fmt.Errorf("malformed scp times %q", s)
+		}
+	}
+	return time.Unix(v[0], v[1]*1000), time.Unix(v[2], v[3]*1000), nil
+}
+
+// source sends the files at args.paths to the client.
+func (s *scpServer) source() error {
+	// Wait for the client to be ready.
+	if _, err := s.response(); err != nil {
+		return err
+	}
+	for _, arg := range s.args.paths {
+		for _, p := range scpGlob(arg) {
+			if err := s.send(p); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// scpGlob returns the paths matching the scp source path p, as the shell
+// that scp's arguments would otherwise have been passed through expands
+// them: wildcards don't match a leading '.' of a name, and patterns that
+// match nothing are left as they are, so that they're reported as missing.
+func scpGlob(p string) []string {
+	if !isSCPPattern(p) {
+		return []string{p}
+	}
+	matches, err := filepath.Glob(p)
+	if err != nil {
+		return []string{p}
+	}
+	pattern := strings.Split(p, string(filepath.Separator))
+	matches = slices.DeleteFunc(matches, func(m string) bool {
+		names := strings.Split(m, string(filepath.Separator))
+		if len(names) != len(pattern) {
+			return false
+		}
+		for i, name := range names {
+			if strings.HasPrefix(name, ".") && !strings.HasPrefix(pattern[i], ".") {
+				return true
+			}
+		}
+		return false
+	})
+	if len(matches) == 0 {
+		return []string{p}
+	}
+	return matches
+}
+
+// send sends the file or directory at p to the client. Files that can't be
+// sent are reported to the client as warnings; only errors of the
+// connection are returned.
+func (s *scpServer) send(p string) error {
+	fi, err := os.Stat(p)
+	if err != nil {
+		return s.warn("%v", err)
+	}
+	if s.args.preserve {
+		if err := s.sendTimes(fi); err != nil {
+			return err
+		}
+	}
+	name := filepath.Base(p)
+	if fi.IsDir() {
+		if !s.args.recursive {
+			return s.warn("%s: not a regular file", p)
+		}
+		ents, err := os.ReadDir(p)
+		if err != nil {
+			return s.warn("%v", err)
+		}
+		if _, err := fmt.Fprintf(s.w, "D%04o 0 %s\n", fi.Mode().Perm(), name); err != nil {
+			return err
+		}
+		if fatal, err := s.response(); err != nil {
+			if fatal {
+				return err
+			}
+			return nil
+		}
+		for _, e := range ents {
+			if err := s.send(filepath.Join(p, e.Name())); err != nil {
+				return err
+			}
+		}
+		if _, err := io.WriteString(s.w, "E\n"); err != nil {
+			return err
+		}
+		_, err = s.response()
+		return err
+	}
+	if !fi.Mode().IsRegular() {
+		return s.warn("%s: not a regular file", p)
+	}
+
+	f, err := os.Open(p)
+	if err != nil {
+		return s.warn("%v", err)
+	}
+	defer f.Close()
+	size := fi.Size()
+	if _, err := fmt.Fprintf(s.w, "C%04o %d %s\n", fi.Mode().Perm(), size, name); err != nil {
+		return err
+	}
+	if fatal, err := s.response(); err != nil {
+		if fatal {
+			return err
+		}
+		return nil
+	}
+	fr := &scpFileReader{r: f}
+	if _, err := io.CopyN(s.w, fr, size); err != nil {
+		return err
+	}
+	if fr.err != nil {
+		if err := s.warn("%s: %v", p, fr.err); err != nil {
+			return err
+		}
+	} else if err := s.ack(); err != nil {
+		return err
+	}
+	if fatal, err := s.response(); err != nil && fatal {
+		return err
+	}
+	return nil
+}
+
+// sendTimes sends a "T" protocol line with the modification time of fi.
+func (s *scpServer) sendTimes(fi fs.FileInfo) error {
+	mt := fi.ModTime()
+	if _, err := fmt.Fprintf(s.w, "T%d 0 %d 0\n", mt.Unix(), mt.Unix()); err != nil {
+		return err
+	}
+	_, err := s.response()
+	return err
+}
+
+// scpFileWriter writes a file being received, discarding what's written
+// after an error.
+type scpFileWriter struct {
+	w   io.Writer
+	err error // first write error
+}
+
+func (w *scpFileWriter) Write(p []byte) (int, error) {
+	if w.err == nil {
+		_, w.err = w.w.Write(p)
+	}
+	return len(p), nil
+}
+
+// scpFileReader reads a file being sent. If the file can't be read, or
+// shrinks, it's padded with zeros to the size announced to the client.
+type scpFileReader struct {
+	r   io.Reader
+	err error // first read error
+}
+
+func (r *scpFileReader) Read(p []byte) (int, error) {
+	if r.err == nil {
+		n, err := r.r.Read(p)
+		if err == io.EOF {
+			// Reads are limited to the size announced, so it's early.
+			err = io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			r.err = err
+		}
+		if n > 0 || err == nil {
+			return n, nil
+		}
+	}
+	clear(p)
+	return len(p), nil
+}
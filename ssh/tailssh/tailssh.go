@@ -9,6 +9,8 @@
 import (
 	"bytes"
 	"context"
+	"crypto"
+	"crypto/ed25519"
 	"crypto/rand"
 	"encoding/json"
 	"errors"
@@ -41,10 +43,12 @@
 	"tailscale.com/types/key"
 	"tailscale.com/types/logger"
 	"tailscale.com/types/netmap"
+	"tailscale.com/types/views"
 	"tailscale.com/util/backoff"
 	"tailscale.com/util/clientmetric"
 	"tailscale.com/util/httpm"
 	"tailscale.com/util/mak"
+	"tailscale.com/util/syspolicy/policyclient"
 )
 
 var (
@@ -80,7 +84,9 @@ type ipnLocalBackend interface {
 	DoNoiseRequest(req *http.Request) (*http.Response, error)
 	Dialer() *tsdial.Dialer
 	TailscaleVarRoot() string
+	PolicyClient() policyclient.Client
 	NodeKey() key.NodePublic
+	GetSSH_UserCAKey() (gossh.Signer, error)
 }
 
 type server struct {
@@ -168,6 +174,7 @@ func (srv *server) HandleSSHConn(nc net.Conn) error {
 	srv.trackActiveConn(c, true)        // add
 	defer srv.trackActiveConn(c, false) // remove
 	c.HandleConn(nc)
+	c.endForwardingRecording()
 
 	// Return nil to signal to netstack's interception that it doesn't need to
 	// log. If ss.HandleConn had problems, it can log itself (ideally on an
@@ -251,6 +258,8 @@ type conn struct {
 	// acquire mu and then srv.mu.
 	mu       sync.Mutex // protects the following
 	sessions []*sshSession
+
+	fwd forwardingState // recording of forwarded channels
 }
 
 func (c *conn) logf(format string, args ...any) {
@@ -303,11 +312,16 @@ func (c *conn) errUnexpected(err error) error {
 
 // clientAuth is responsible for performing client authentication.
 //
+// If cert is non-nil, the client is authenticated as the Tailscale identity
+// that the SSH user certificate was issued to, rather than by where the
+// connection came from.
+//
 // If policy evaluation fails, it returns an error.
 // If access is denied, it returns an error. This must always be an empty
 // gossh.PartialSuccessError to prevent further authentication methods from
-// being tried.
-func (c *conn) clientAuth(cm gossh.ConnMetadata) (perms *gossh.Permissions, retErr error) {
+// being tried, except for errUserCertRequired when this node is an SSH user
+// CA and the client may still authenticate with a certificate.
+func (c *conn) clientAuth(cm gossh.ConnMetadata, cert *gossh.Certificate) (perms *gossh.Permissions, retErr error) {
 	defer func() {
 		if pse, ok := retErr.(*gossh.PartialSuccessError); ok {
 			if pse.Next.GSSAPIWithMICConfig != nil ||
@@ -316,7 +330,7 @@ func (c *conn) clientAuth(cm gossh.ConnMetadata) (perms *gossh.Permissions, retE
 				pse.Next.PublicKeyCallback != nil {
 				panic("clientAuth attempted to return a non-empty PartialSuccessError")
 			}
-		} else if retErr != nil {
+		} else if retErr != nil && retErr != errUserCertRequired {
 			panic(fmt.Sprintf("clientAuth attempted to return a non-PartialSuccessError error of type: %t", retErr))
 		}
 	}()
@@ -325,11 +339,43 @@ func (c *conn) clientAuth(cm gossh.ConnMetadata) (perms *gossh.Permissions, retE
 		return &gossh.Permissions{}, nil
 	}
 
-	if err := c.setInfo(cm); err != nil {
+	if cert != nil {
+		ci, err := c.setCertInfo(cm, cert)
+		if err != nil {
+			metricUserCertRejected.Add(1)
+			c.logf("rejecting SSH user certificate %q: %v", cert.KeyId, err)
+			return nil, errUserCertRequired
+		}
+		// Only keep the certificate's identity if it's accepted, as the
+		// client hasn't yet proven that it holds the certificate's key.
+		prevInfo := c.info
+		c.idH = string(cm.SessionID())
+		c.info = ci
+		c.logf("handling conn with user certificate %q: %v", cert.KeyId, ci.String())
+		defer func() {
+			if retErr != nil {
+				c.info = prevInfo
+			} else {
+				metricUserCertAccepted.Add(1)
+			}
+		}()
+	} else if c.info != nil && c.info.cert != nil {
+		// A user certificate was accepted earlier in this connection, so
+		// the client is to authenticate with it.
+		return nil, errUserCertRequired
+	} else if err := c.setInfo(cm); err != nil {
+		if sshUserCA() {
+			c.logf("failed to get connection info: %v", err)
+			return nil, errUserCertRequired
+		}
 		return nil, c.errBanner("failed to get connection info", err)
 	}
 
 	action, localUser, acceptEnv, result := c.evaluatePolicy()
+	if result != accepted && cert == nil && sshUserCA() {
+		c.logf("policy result for %v: %s; requiring user certificate", c.info.uprof.LoginName, result)
+		return nil, errUserCertRequired
+	}
 	switch result {
 	case accepted:
 		// do nothing
@@ -413,7 +459,7 @@ func (c *conn) ServerConfig(ctx ssh.Context) *gossh.ServerConfig {
 			// First perform client authentication, which can potentially
 			// involve multiple steps (for example prompting user to log in to
 			// Tailscale admin panel to confirm identity).
-			perms, err := c.clientAuth(cm)
+			perms, err := c.clientAuth(cm, nil)
 			if err != nil {
 				return nil, err
 			}
@@ -439,14 +485,19 @@ func (c *conn) ServerConfig(ctx ssh.Context) *gossh.ServerConfig {
 			// immediately supply a password. We humor them by accepting the
 			// password, but authenticate as usual, ignoring the actual value of
 			// the password.
-			return c.clientAuth(cm)
+			return c.clientAuth(cm, nil)
 		},
 		PublicKeyCallback: func(cm gossh.ConnMetadata, key gossh.PublicKey) (*gossh.Permissions, error) {
+			// When acting as an SSH user CA, certificates that it issued
+			// authenticate the client as the identity they were issued to.
+			if cert, ok := key.(*gossh.Certificate); ok && sshUserCA() {
+				return c.clientAuth(cm, cert)
+			}
 			// Some clients don't request 'none' authentication. Instead, they
 			// immediately supply a public key. We humor them by accepting the
 			// key, but authenticate as usual, ignoring the actual content of
 			// the key.
-			return c.clientAuth(cm)
+			return c.clientAuth(cm, nil)
 		},
 	}
 }
@@ -472,6 +523,7 @@ func (srv *server) newConn() (*conn, error) {
 		Handler:                       c.handleSessionPostSSHAuth,
 		LocalPortForwardingCallback:   c.mayForwardLocalPortTo,
 		ReversePortForwardingCallback: c.mayReversePortForwardTo,
+		ForwardedChannelCallback:      c.wrapForwardedChannel,
 		SubsystemHandlers: map[string]ssh.SubsystemHandler{
 			"sftp": c.handleSessionPostSSHAuth,
 		},
@@ -513,7 +565,7 @@ func (c *conn) mayReversePortForwardTo(ctx ssh.Context, destinationHost string,
 	if sshDisableForwarding() {
 		return false
 	}
-	if c.finalAction != nil && c.finalAction.AllowRemotePortForwarding {
+	if c.finalAction != nil && c.finalAction.AllowRemotePortForwarding && c.mayForward() {
 		metricRemotePortForward.Add(1)
 		return true
 	}
@@ -527,7 +579,7 @@ func (c *conn) mayForwardLocalPortTo(ctx ssh.Context, destinationHost string, de
 	if sshDisableForwarding() {
 		return false
 	}
-	if c.finalAction != nil && c.finalAction.AllowLocalPortForwarding {
+	if c.finalAction != nil && c.finalAction.AllowLocalPortForwarding && c.mayForward() {
 		metricLocalPortForward.Add(1)
 		return true
 	}
@@ -858,6 +910,10 @@ func (ss *sshSession) handleSSHAgentForwarding(s ssh.Session, lu *userMeta) erro
 		// don't work, like the condition above.
 		return nil
 	}
+	if !ss.conn.mayForward() {
+		ss.logf("ssh: agent forwarding requested, but can't be recorded")
+		return nil
+	}
 	ss.logf("ssh: agent forwarding requested")
 	ln, err := ssh.NewAgentListener()
 	if err != nil {
@@ -938,7 +994,6 @@ func (ss *sshSession) run() {
 	// See https://github.com/tailscale/tailscale/issues/4146
 	ss.DisablePTYEmulation()
 
-	var rec *recording // or nil if disabled
 	if ss.Subsystem() != "sftp" {
 		if err := ss.handleSSHAgentForwarding(ss, lu); err != nil {
 			ss.logf("agent forwarding failed: %v", err)
@@ -946,25 +1001,35 @@ func (ss *sshSession) run() {
 			// TODO(maisem/bradfitz): add a way to close all session resources
 			defer ss.agentListener.Close()
 		}
+	}
 
-		if ss.shouldRecord() {
-			var err error
-			rec, err = ss.startNewRecording()
-			if err != nil {
-				var uve userVisibleError
-				if errors.As(err, &uve) {
-					fmt.Fprintf(ss, "%s\r\n", uve.SSHTerminationMessage())
-				} else {
-					fmt.Fprintf(ss, "can't start new recording\r\n")
-				}
-				ss.logf("startNewRecording: %v", err)
-				ss.Exit(1)
-				return
-			}
-			ss.logf("startNewRecording: <nil>")
-			if rec != nil {
-				defer rec.Close()
+	var rec *recording // or nil if disabled
+	if ss.conn.shouldRecord() {
+		var err error
+		rec, err = ss.startNewRecording()
+		if err != nil {
+			var uve userVisibleError
+			if errors.As(err, &uve) {
+				fmt.Fprintf(ss, "%s\r\n", uve.SSHTerminationMessage())
+			} else {
+				fmt.Fprintf(ss, "can't start new recording\r\n")
 			}
+			ss.logf("startNewRecording: %v", err)
+			ss.Exit(1)
+			return
+		}
+		ss.logf("startNewRecording: <nil>")
+		if rec != nil {
+			defer rec.Close()
+		}
+	}
+
+	// SFTP and scp sessions record their file transfers, rather than the
+	// protocol streams as output.
+	var tap transferTap
+	if rec != nil {
+		if tap = ss.newTransferTap(rec); tap != nil {
+			defer tap.finish()
 		}
 	}
 
@@ -983,10 +1048,16 @@ func (ss *sshSession) run() {
 	}
 	go ss.killProcessOnContextDone()
 
+	stdin, stdout := rec.writer("i", ss.wrStdin), rec.writer("o", ss)
+	if tap != nil {
+		stdin = tapWriter{ss.wrStdin, tap.clientData}
+		stdout = tapWriter{ss, tap.serverData}
+	}
+
 	var processDone atomic.Bool
 	go func() {
 		defer ss.wrStdin.Close()
-		if _, err := io.Copy(rec.writer("i", ss.wrStdin), ss); err != nil {
+		if _, err := io.Copy(stdin, ss); err != nil {
 			logf("stdin copy: %v", err)
 			ss.cancelCtx(err)
 		}
@@ -1000,7 +1071,7 @@ func (ss *sshSession) run() {
 	}
 	go func() {
 		defer ss.rdStdout.Close()
-		_, err := io.Copy(rec.writer("o", ss), ss.rdStdout)
+		_, err := io.Copy(stdout, ss.rdStdout)
 		if err != nil && !errors.Is(err, io.EOF) {
 			isErrBecauseProcessExited := processDone.Load() && errors.Is(err, syscall.EIO)
 			if !isErrBecauseProcessExited {
@@ -1067,20 +1138,20 @@ func (ss *sshSession) run() {
 // coordination server. This will be removed in the future.
 var recordSSHToLocalDisk = envknob.RegisterBool("TS_DEBUG_LOG_SSH")
 
-// recorders returns the list of recorders to use for this session.
+// recorders returns the list of recorders to use for this connection.
 // If the final action has a non-empty list of recorders, that list is
 // returned. Otherwise, the list of recorders from the initial action
 // is returned.
-func (ss *sshSession) recorders() ([]netip.AddrPort, *tailcfg.SSHRecorderFailureAction) {
-	if len(ss.conn.finalAction.Recorders) > 0 {
-		return ss.conn.finalAction.Recorders, ss.conn.finalAction.OnRecordingFailure
+func (c *conn) recorders() ([]netip.AddrPort, *tailcfg.SSHRecorderFailureAction) {
+	if len(c.finalAction.Recorders) > 0 {
+		return c.finalAction.Recorders, c.finalAction.OnRecordingFailure
 	}
-	return ss.conn.action0.Recorders, ss.conn.action0.OnRecordingFailure
+	return c.action0.Recorders, c.action0.OnRecordingFailure
 }
 
-func (ss *sshSession) shouldRecord() bool {
-	recs, _ := ss.recorders()
-	return len(recs) > 0 || recordSSHToLocalDisk()
+func (c *conn) shouldRecord() bool {
+	recs, _ := c.recorders()
+	return len(recs) > 0 || recordLocally()
 }
 
 type sshConnInfo struct {
@@ -1098,6 +1169,11 @@ type sshConnInfo struct {
 
 	// uprof is node's UserProfile.
 	uprof tailcfg.UserProfile
+
+	// cert, if non-nil, is the SSH user certificate that the connection
+	// was authenticated with. In that case, node is the node that the
+	// certificate was issued to, which needn't be srcIP's node.
+	cert *gossh.Certificate
 }
 
 func (ci *sshConnInfo) String() string {
@@ -1207,7 +1283,14 @@ func (c *conn) principalMatchesTailscaleIdentity(p *tailcfg.SSHPrincipal) bool {
 		return true
 	}
 	if p.NodeIP != "" {
-		if ip, _ := netip.ParseAddr(p.NodeIP); ip == ci.src.Addr() {
+		ip, _ := netip.ParseAddr(p.NodeIP)
+		if ci.cert == nil && ip == ci.src.Addr() {
+			return true
+		}
+		// Connections authenticated with a user certificate may come
+		// from anywhere, so match the addresses of the certificate's
+		// node instead.
+		if ci.cert != nil && ci.node.Valid() && ip.IsValid() && views.SliceContains(ci.node.Addresses(), netip.PrefixFrom(ip, ip.BitLen())) {
 			return true
 		}
 	}
@@ -1225,101 +1308,135 @@ func randBytes(n int) []byte {
 	return b
 }
 
-func (ss *sshSession) openFileForRecording(now time.Time) (_ io.WriteCloser, err error) {
-	varRoot := ss.conn.srv.lb.TailscaleVarRoot()
-	if varRoot == "" {
-		return nil, errors.New("no var root for recording storage")
+// startNewRecording starts a new SSH session recording.
+// It may return a nil recording if recording is not available.
+func (ss *sshSession) startNewRecording() (_ *recording, err error) {
+	var w ssh.Window
+	if ptyReq, _, isPtyReq := ss.Pty(); isPtyReq {
+		w = ptyReq.Window
 	}
-	dir := filepath.Join(varRoot, "ssh-sessions")
-	if err := os.MkdirAll(dir, 0700); err != nil {
-		return nil, err
+
+	term := envValFromList(ss.Environ(), "TERM")
+	if term == "" {
+		term = "xterm-256color" // something non-empty
 	}
-	f, err := os.CreateTemp(dir, fmt.Sprintf("ssh-session-%v-*.cast", now.UnixNano()))
-	if err != nil {
-		return nil, err
+
+	command := strings.Join(ss.Command(), " ")
+	if ss.Subsystem() != "" {
+		// Subsystems, such as SFTP, run no command of the client's.
+		command = ss.Subsystem()
+	}
+	ch := sessionrecording.CastHeader{
+		Width:   w.Width,
+		Height:  w.Height,
+		Command: command,
+		Env: map[string]string{
+			"TERM": term,
+			// TODO(bradfitz): anything else important?
+			// including all seems noisey, but maybe we should
+			// for auditing. But first need to break
+			// launchProcess's startWithStdPipes and
+			// startWithPTY up so that they first return the cmd
+			// without starting it, and then a step that starts
+			// it. Then we can (1) make the cmd, (2) start the
+			// recording, (3) start the process.
+		},
 	}
-	return f, nil
+	_, onFailure := ss.conn.recorders()
+	return ss.conn.startRecording(ss.ctx, ss.cancelCtx, ss.logf, "session", onFailure, ch)
 }
 
-// startNewRecording starts a new SSH session recording.
-// It may return a nil recording if recording is not available.
-func (ss *sshSession) startNewRecording() (_ *recording, err error) {
+// startRecording starts a new recording of the given kind, "session" or
+// "forwarding", with the header ch, to which it adds the details of the
+// connection. It may return a nil recording if recording is not available
+// and onFailure permits failing open.
+//
+// The ctx is done when what's recorded ends, and cancel is called to end it
+// if uploading the recording fails and onFailure doesn't permit failing open.
+func (c *conn) startRecording(ctx context.Context, cancel context.CancelCauseFunc, logf logger.Logf, kind string, onFailure *tailcfg.SSHRecorderFailureAction, ch sessionrecording.CastHeader) (_ *recording, err error) {
 	// We store the node key as soon as possible when creating
 	// a new recording incase of FUS.
-	nodeKey := ss.conn.srv.lb.NodeKey()
+	nodeKey := c.srv.lb.NodeKey()
 	if nodeKey.IsZero() {
 		return nil, errors.New("ssh server is unavailable: no node key")
 	}
 
-	recorders, onFailure := ss.recorders()
-	var localRecording bool
+	recorders, _ := c.recorders()
+	var lr *sessionrecording.LocalRecorder
 	if len(recorders) == 0 {
-		if recordSSHToLocalDisk() {
-			localRecording = true
-		} else {
+		lr, err = localRecorder(c.srv.lb.TailscaleVarRoot(), c.srv.lb.PolicyClient())
+		if err == errNoLocalRecordings {
 			return nil, errors.New("no recorders configured")
 		}
+		if err != nil {
+			return nil, err
+		}
 	}
 
-	var w ssh.Window
-	if ptyReq, _, isPtyReq := ss.Pty(); isPtyReq {
-		w = ptyReq.Window
-	}
-
-	term := envValFromList(ss.Environ(), "TERM")
-	if term == "" {
-		term = "xterm-256color" // something non-empty
+	var signer crypto.Signer
+	if c.finalAction != nil && c.finalAction.TamperEvidentRecording {
+		signer, err = c.recordingSigner()
+		if err != nil {
+			if onFailure != nil && onFailure.RejectSessionWithMessage != "" {
+				logf("recording: error starting tamper-evident recording (rejecting %s): %v", kind, err)
+				return nil, userVisibleError{
+					error: err,
+					msg:   onFailure.RejectSessionWithMessage,
+				}
+			}
+			logf("recording: error starting tamper-evident recording (failing open): %v", err)
+			return nil, nil
+		}
 	}
 
 	now := time.Now()
 	rec := &recording{
-		ss:       ss,
 		start:    now,
 		failOpen: onFailure == nil || onFailure.TerminateSessionWithMessage == "",
 	}
 
-	// We want to use a background context for uploading and not ss.ctx.
-	// ss.ctx is closed when the session closes, but we don't want to break the upload at that time.
+	// We want to use a background context for uploading and not ctx.
+	// ctx is closed when the session closes, but we don't want to break the upload at that time.
 	// Instead we want to wait for the session to close the writer when it finishes.
-	ctx := context.Background()
-	if localRecording {
-		rec.out, err = ss.openFileForRecording(now)
+	bctx := context.Background()
+	if lr != nil {
+		rec.out, err = lr.Create("ssh-"+kind, now)
 		if err != nil {
 			return nil, err
 		}
 	} else {
 		var errChan <-chan error
 		var attempts []*tailcfg.SSHRecordingAttempt
-		rec.out, attempts, errChan, err = sessionrecording.ConnectToRecorder(ctx, recorders, ss.conn.srv.lb.Dialer().UserDial)
+		rec.out, attempts, errChan, err = sessionrecording.ConnectToRecorder(bctx, recorders, c.srv.lb.Dialer().UserDial)
 		if err != nil {
 			if onFailure != nil && onFailure.NotifyURL != "" && len(attempts) > 0 {
 				eventType := tailcfg.SSHSessionRecordingFailed
 				if onFailure.RejectSessionWithMessage != "" {
 					eventType = tailcfg.SSHSessionRecordingRejected
 				}
-				ss.notifyControl(ctx, nodeKey, eventType, attempts, onFailure.NotifyURL)
+				c.notifyControl(bctx, logf, nodeKey, eventType, attempts, onFailure.NotifyURL)
 			}
 
 			if onFailure != nil && onFailure.RejectSessionWithMessage != "" {
-				ss.logf("recording: error starting recording (rejecting session): %v", err)
+				logf("recording: error starting recording (rejecting %s): %v", kind, err)
 				return nil, userVisibleError{
 					error: err,
 					msg:   onFailure.RejectSessionWithMessage,
 				}
 			}
-			ss.logf("recording: error starting recording (failing open): %v", err)
+			logf("recording: error starting recording (failing open): %v", err)
 			return nil, nil
 		}
 		go func() {
 			err := <-errChan
 			if err == nil {
 				select {
-				case <-ss.ctx.Done():
+				case <-ctx.Done():
 					// Success.
-					ss.logf("recording: finished uploading recording")
+					logf("recording: finished uploading recording")
 					return
 				default:
-					err = errors.New("recording upload ended before the SSH session")
+					err = fmt.Errorf("recording upload ended before the SSH %s", kind)
 				}
 			}
 			if onFailure != nil && onFailure.NotifyURL != "" && len(attempts) > 0 {
@@ -1331,48 +1448,42 @@ func (ss *sshSession) startNewRecording() (_ *recording, err error) {
 					eventType = tailcfg.SSHSessionRecordingTerminated
 				}
 
-				ss.notifyControl(ctx, nodeKey, eventType, attempts, onFailure.NotifyURL)
+				c.notifyControl(bctx, logf, nodeKey, eventType, attempts, onFailure.NotifyURL)
 			}
 			if onFailure != nil && onFailure.TerminateSessionWithMessage != "" {
-				ss.logf("recording: error uploading recording (closing session): %v", err)
-				ss.cancelCtx(userVisibleError{
+				logf("recording: error uploading recording (closing %s): %v", kind, err)
+				cancel(userVisibleError{
 					error: err,
 					msg:   onFailure.TerminateSessionWithMessage,
 				})
 				return
 			}
-			ss.logf("recording: error uploading recording (failing open): %v", err)
+			logf("recording: error uploading recording (failing open): %v", err)
 		}()
 	}
 
-	ch := sessionrecording.CastHeader{
-		Version:   2,
-		Width:     w.Width,
-		Height:    w.Height,
-		Timestamp: now.Unix(),
-		Command:   strings.Join(ss.Command(), " "),
-		Env: map[string]string{
-			"TERM": term,
-			// TODO(bradfitz): anything else important?
-			// including all seems noisey, but maybe we should
-			// for auditing. But first need to break
-			// launchProcess's startWithStdPipes and
-			// startWithPTY up so that they first return the cmd
-			// without starting it, and then a step that starts
-			// it. Then we can (1) make the cmd, (2) start the
-			// recording, (3) start the process.
-		},
-		SSHUser:      ss.conn.info.sshUser,
-		LocalUser:    ss.conn.localUser.Username,
-		SrcNode:      strings.TrimSuffix(ss.conn.info.node.Name(), "."),
-		SrcNodeID:    ss.conn.info.node.StableID(),
-		ConnectionID: ss.conn.connID,
-	}
-	if !ss.conn.info.node.IsTagged() {
-		ch.SrcNodeUser = ss.conn.info.uprof.LoginName
-		ch.SrcNodeUserID = ss.conn.info.node.User()
+	if signer != nil {
+		cw, err := sessionrecording.NewChainWriter(rec.out, signer)
+		if err != nil {
+			rec.out.Close()
+			return nil, err
+		}
+		rec.out = cw
+		ch.Chain = cw.Chain()
+	}
+
+	ch.Version = 2
+	ch.Timestamp = now.Unix()
+	ch.SSHUser = c.info.sshUser
+	ch.LocalUser = c.localUser.Username
+	ch.SrcNode = strings.TrimSuffix(c.info.node.Name(), ".")
+	ch.SrcNodeID = c.info.node.StableID()
+	ch.ConnectionID = c.connID
+	if !c.info.node.IsTagged() {
+		ch.SrcNodeUser = c.info.uprof.LoginName
+		ch.SrcNodeUserID = c.info.node.User()
 	} else {
-		ch.SrcNodeTags = ss.conn.info.node.Tags().AsSlice()
+		ch.SrcNodeTags = c.info.node.Tags().AsSlice()
 	}
 	j, err := json.Marshal(ch)
 	if err != nil {
@@ -1380,59 +1491,94 @@ func (ss *sshSession) startNewRecording() (_ *recording, err error) {
 	}
 	j = append(j, '\n')
 	if _, err := rec.out.Write(j); err != nil {
-		if errors.Is(err, io.ErrClosedPipe) && ss.ctx.Err() != nil {
+		if errors.Is(err, io.ErrClosedPipe) && ctx.Err() != nil {
 			// If we got an io.ErrClosedPipe, it's likely because
 			// the recording server closed the connection on us. Return
 			// the original context error instead.
-			return nil, context.Cause(ss.ctx)
+			return nil, context.Cause(ctx)
 		}
 		return nil, err
 	}
 	return rec, nil
 }
 
+// recordingSigner returns the signer of tamper-evident recordings, which
+// uses the node's Ed25519 SSH host key.
+func (c *conn) recordingSigner() (crypto.Signer, error) {
+	keys, err := c.srv.lb.GetSSH_HostKeys()
+	if err != nil {
+		return nil, err
+	}
+	for _, k := range keys {
+		cpk, ok := k.PublicKey().(gossh.CryptoPublicKey)
+		if !ok {
+			continue
+		}
+		if pub, ok := cpk.CryptoPublicKey().(ed25519.PublicKey); ok {
+			return hostKeySigner{pub: pub, s: k}, nil
+		}
+	}
+	return nil, errors.New("no Ed25519 SSH host key to sign recordings with")
+}
+
+// hostKeySigner is a crypto.Signer for an Ed25519 SSH host key, whose SSH
+// signatures' blobs are plain Ed25519 signatures.
+type hostKeySigner struct {
+	pub ed25519.PublicKey
+	s   gossh.Signer
+}
+
+func (s hostKeySigner) Public() crypto.PublicKey { return s.pub }
+
+func (s hostKeySigner) Sign(rand io.Reader, msg []byte, _ crypto.SignerOpts) ([]byte, error) {
+	sig, err := s.s.Sign(rand, msg)
+	if err != nil {
+		return nil, err
+	}
+	return sig.Blob, nil
+}
+
 // notifyControl sends a SSHEventNotifyRequest to control over noise.
 // A SSHEventNotifyRequest is sent when an action or state reached during
 // an SSH session is a defined EventType.
-func (ss *sshSession) notifyControl(ctx context.Context, nodeKey key.NodePublic, notifyType tailcfg.SSHEventType, attempts []*tailcfg.SSHRecordingAttempt, url string) {
+func (c *conn) notifyControl(ctx context.Context, logf logger.Logf, nodeKey key.NodePublic, notifyType tailcfg.SSHEventType, attempts []*tailcfg.SSHRecordingAttempt, url string) {
 	re := tailcfg.SSHEventNotifyRequest{
 		EventType:         notifyType,
-		ConnectionID:      ss.conn.connID,
+		ConnectionID:      c.connID,
 		CapVersion:        tailcfg.CurrentCapabilityVersion,
 		NodeKey:           nodeKey,
-		SrcNode:           ss.conn.info.node.ID(),
-		SSHUser:           ss.conn.info.sshUser,
-		LocalUser:         ss.conn.localUser.Username,
+		SrcNode:           c.info.node.ID(),
+		SSHUser:           c.info.sshUser,
+		LocalUser:         c.localUser.Username,
 		RecordingAttempts: attempts,
 	}
 
 	body, err := json.Marshal(re)
 	if err != nil {
-		ss.logf("notifyControl: unable to marshal SSHNotifyRequest:", err)
+		logf("notifyControl: unable to marshal SSHNotifyRequest:", err)
 		return
 	}
 
 	req, err := http.NewRequestWithContext(ctx, httpm.POST, url, bytes.NewReader(body))
 	if err != nil {
-		ss.logf("notifyControl: unable to create request:", err)
+		logf("notifyControl: unable to create request:", err)
 		return
 	}
 
-	resp, err := ss.conn.srv.lb.DoNoiseRequest(req)
+	resp, err := c.srv.lb.DoNoiseRequest(req)
 	if err != nil {
-		ss.logf("notifyControl: unable to send noise request:", err)
+		logf("notifyControl: unable to send noise request:", err)
 		return
 	}
 
 	if resp.StatusCode != http.StatusCreated {
-		ss.logf("notifyControl: noise request returned status code %v", resp.StatusCode)
+		logf("notifyControl: noise request returned status code %v", resp.StatusCode)
 		return
 	}
 }
 
 // recording is the state for an SSH session recording.
 type recording struct {
-	ss    *sshSession
 	start time.Time
 
 	// failOpen specifies whether the session should be allowed to
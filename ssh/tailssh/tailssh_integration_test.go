@@ -34,12 +34,15 @@
 	"golang.org/x/crypto/ssh"
 	gossh "golang.org/x/crypto/ssh"
 	"golang.org/x/crypto/ssh/agent"
+	"tailscale.com/envknob"
 	"tailscale.com/net/tsdial"
+	"tailscale.com/sessionrecording"
 	"tailscale.com/tailcfg"
 	glider "tailscale.com/tempfork/gliderlabs/ssh"
 	"tailscale.com/types/key"
 	"tailscale.com/types/netmap"
 	"tailscale.com/util/set"
+	"tailscale.com/util/syspolicy/policyclient"
 )
 
 // This file contains integration tests of the SSH functionality. These tests
@@ -320,6 +323,114 @@ func TestIntegrationSCP(t *testing.T) {
 	}
 }
 
+func TestIntegrationSCPBuiltin(t *testing.T) {
+	debugTest.Store(true)
+	envknob.Setenv("TS_SSH_BUILTIN_SCP", "true")
+	t.Cleanup(func() {
+		debugTest.Store(false)
+		envknob.Setenv("TS_SSH_BUILTIN_SCP", "")
+	})
+
+	for _, forceV1Behavior := range []bool{false, true} {
+		name := "v2"
+		if forceV1Behavior {
+			name = "v1"
+		}
+		t.Run(name, func(t *testing.T) {
+			filePath := "/home/testuser/scpbuiltintest.dat"
+			if !fallbackToSUAvailable() {
+				filePath = "/tmp/scpbuiltintest.dat"
+			}
+			wantText := "hello from the built-in scp server"
+
+			cl := testClient(t, forceV1Behavior, false)
+			scl, err := scp.NewClientBySSH(cl)
+			if err != nil {
+				t.Fatalf("can't get scp client: %s", err)
+			}
+
+			err = scl.Copy(context.Background(), strings.NewReader(wantText), filePath, "0640", int64(len(wantText)))
+			if err != nil {
+				t.Fatalf("can't create file: %s", err)
+			}
+
+			var got bytes.Buffer
+			if err := scl.CopyFromRemotePassThru(context.Background(), &got, filePath, nil); err != nil {
+				t.Fatalf("can't copy file from remote: %s", err)
+			}
+			if diff := cmp.Diff(got.String(), wantText); diff != "" {
+				t.Fatalf("unexpected file contents (-got +want):\n%s", diff)
+			}
+
+			s := testSessionFor(t, cl, nil)
+			out := s.run(t, "ls -l "+filePath, false)
+			if !strings.Contains(out, "testuser") {
+				t.Fatalf("unexpected file owner user: %s", out)
+			}
+			if !strings.HasPrefix(out, "-rw-r-----") {
+				t.Fatalf("unexpected file mode: %s", out)
+			}
+		})
+	}
+}
+
+func TestIntegrationSFTPRecording(t *testing.T) {
+	debugTest.Store(true)
+	envknob.Setenv("TS_DEBUG_LOG_SSH", "true")
+	t.Cleanup(func() {
+		debugTest.Store(false)
+		envknob.Setenv("TS_DEBUG_LOG_SSH", "")
+	})
+
+	varRoot := t.TempDir()
+	cl := dialTestServer(t, testServerFor(t, &testBackend{
+		localUser: "testuser",
+		varRoot:   varRoot,
+		nodeKey:   key.NewNode().Public(),
+	}))
+	scl, err := sftp.NewClient(cl)
+	if err != nil {
+		t.Fatalf("can't get sftp client: %s", err)
+	}
+	filePath := "/tmp/sftprecordingtest.dat"
+	wantText := "recorded transfer"
+	file, err := scl.Create(filePath)
+	if err != nil {
+		t.Fatalf("can't create file: %s", err)
+	}
+	if _, err := file.Write([]byte(wantText)); err != nil {
+		t.Fatalf("can't write to file: %s", err)
+	}
+	if err := file.Close(); err != nil {
+		t.Fatalf("can't close file: %s", err)
+	}
+	if err := scl.Remove(filePath); err != nil {
+		t.Fatalf("can't remove file: %s", err)
+	}
+	scl.Close()
+
+	// Transfers are recorded before the server's responses are passed on
+	// to the client, so they're in the recording by now.
+	casts, err := filepath.Glob(filepath.Join(varRoot, "ssh-sessions", "*.cast"))
+	if err != nil || len(casts) != 1 {
+		t.Fatalf("recordings = %q, %v; want one", casts, err)
+	}
+	rec, err := os.ReadFile(casts[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []sessionrecording.FileTransfer{
+		{Protocol: "sftp", Op: "upload", Path: filePath, Bytes: int64(len(wantText))},
+		{Protocol: "sftp", Op: "remove", Path: filePath},
+	}
+	if diff := cmp.Diff(want, recordedTransfers(t, rec)); diff != "" {
+		t.Fatalf("unexpected transfers (-want +got):\n%s", diff)
+	}
+	if bytes.Contains(rec, []byte(wantText)) {
+		t.Fatalf("recording contains file data: %q", rec)
+	}
+}
+
 func TestSSHAgentForwarding(t *testing.T) {
 	debugTest.Store(true)
 	t.Cleanup(func() {
@@ -530,6 +641,11 @@ func testClient(t *testing.T, forceV1Behavior bool, allowSendEnv bool, authMetho
 
 	username := "testuser"
 	addr := testServer(t, username, forceV1Behavior, allowSendEnv)
+	return dialTestServer(t, addr, authMethods...)
+}
+
+func dialTestServer(t *testing.T, addr string, authMethods ...ssh.AuthMethod) *ssh.Client {
+	t.Helper()
 
 	cl, err := ssh.Dial("tcp", addr, &ssh.ClientConfig{
 		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
@@ -544,8 +660,12 @@ func testClient(t *testing.T, forceV1Behavior bool, allowSendEnv bool, authMetho
 }
 
 func testServer(t *testing.T, username string, forceV1Behavior bool, allowSendEnv bool) string {
+	return testServerFor(t, &testBackend{localUser: username, forceV1Behavior: forceV1Behavior, allowSendEnv: allowSendEnv})
+}
+
+func testServerFor(t *testing.T, tb *testBackend) string {
 	srv := &server{
-		lb:             &testBackend{localUser: username, forceV1Behavior: forceV1Behavior, allowSendEnv: allowSendEnv},
+		lb:             tb,
 		logf:           log.Printf,
 		tailscaledPath: os.Getenv("TAILSCALED_PATH"),
 		timeNow:        time.Now,
@@ -629,6 +749,8 @@ type testBackend struct {
 	localUser       string
 	forceV1Behavior bool
 	allowSendEnv    bool
+	varRoot         string         // for recordings, if non-empty
+	nodeKey         key.NodePublic // must be non-zero to record
 }
 
 func (tb *testBackend) GetSSH_HostKeys() ([]gossh.Signer, error) {
@@ -695,11 +817,19 @@ func (tb *testBackend) Dialer() *tsdial.Dialer {
 }
 
 func (tb *testBackend) TailscaleVarRoot() string {
-	return ""
+	return tb.varRoot
+}
+
+func (tb *testBackend) PolicyClient() policyclient.Client {
+	return policyclient.NoPolicyClient{}
+}
+
+func (tb *testBackend) GetSSH_UserCAKey() (gossh.Signer, error) {
+	return nil, errors.New("no SSH user CA")
 }
 
 func (tb *testBackend) NodeKey() key.NodePublic {
-	return key.NodePublic{}
+	return tb.nodeKey
 }
 
 type addressFakingConn struct {
@@ -54,6 +54,7 @@
 	"tailscale.com/util/cibuild"
 	"tailscale.com/util/lineiter"
 	"tailscale.com/util/must"
+	"tailscale.com/util/syspolicy/policyclient"
 	"tailscale.com/version/distro"
 	"tailscale.com/wgengine"
 )
@@ -386,12 +387,21 @@ type localState struct {
 	// It is served for paths like https://unused/ssh-action/<action-name>.
 	// The action name is the last part of the action URL.
 	serverActions map[string]*tailcfg.SSHAction
+
+	varRoot string // returned by TailscaleVarRoot
 }
 
 var (
 	currentUser    = os.Getenv("USER") // Use the current user for the test.
 	testSigner     gossh.Signer
 	testSignerOnce sync.Once
+	testUserCA     = sync.OnceValue(func() gossh.Signer {
+		_, priv, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			panic(err)
+		}
+		return must.Get(gossh.NewSignerFromSigner(priv))
+	})
 )
 
 func (ts *localState) Dialer() *tsdial.Dialer {
@@ -431,21 +441,33 @@ func (ts *localState) NetMap() *netmap.NetworkMap {
 		SelfNode: (&tailcfg.Node{
 			ID: 1,
 		}).View(),
+		Peers:     []tailcfg.NodeView{testPeer()},
 		SSHPolicy: policy,
+		UserProfiles: map[tailcfg.UserID]tailcfg.UserProfileView{
+			2: (&tailcfg.UserProfile{ID: 2, LoginName: "peer"}).View(),
+		},
 	}
 }
 
+// testPeer returns the node that localState.WhoIs reports for every
+// connection.
+func testPeer() tailcfg.NodeView {
+	return (&tailcfg.Node{
+		ID:        2,
+		StableID:  "peer-id",
+		User:      2,
+		Addresses: []netip.Prefix{netip.MustParsePrefix("100.100.100.50/32")},
+	}).View()
+}
+
 func (ts *localState) WhoIs(proto string, ipp netip.AddrPort) (n tailcfg.NodeView, u tailcfg.UserProfile, ok bool) {
 	if proto != "tcp" {
 		return tailcfg.NodeView{}, tailcfg.UserProfile{}, false
 	}
 
-	return (&tailcfg.Node{
-			ID:       2,
-			StableID: "peer-id",
-		}).View(), tailcfg.UserProfile{
-			LoginName: "peer",
-		}, true
+	return testPeer(), tailcfg.UserProfile{
+		LoginName: "peer",
+	}, true
 
 }
 
@@ -468,13 +490,21 @@ func (ts *localState) DoNoiseRequest(req *http.Request) (*http.Response, error)
 }
 
 func (ts *localState) TailscaleVarRoot() string {
-	return ""
+	return ts.varRoot
+}
+
+func (ts *localState) PolicyClient() policyclient.Client {
+	return policyclient.NoPolicyClient{}
 }
 
 func (ts *localState) NodeKey() key.NodePublic {
 	return key.NewNode().Public()
 }
 
+func (ts *localState) GetSSH_UserCAKey() (gossh.Signer, error) {
+	return testUserCA(), nil
+}
+
 func newSSHRule(action *tailcfg.SSHAction) *tailcfg.SSHRule {
 	return &tailcfg.SSHRule{
 		SSHUsers: map[string]string{
@@ -0,0 +1,612 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build (linux && !android) || (darwin && !ios) || freebsd || openbsd || plan9
+
+package tailssh
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"maps"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"tailscale.com/sessionrecording"
+)
+
+// This file contains the recording of file transfers. Rather than recording
+// the raw protocol streams of SFTP and scp sessions as terminal output, taps
+// on the streams parse enough of the protocols to record each transfer as a
+// sessionrecording.FileTransfer event.
+
+// scpArgs are the arguments of scp run in server mode, as the ssh client
+// runs it on the remote host with -t to receive files or -f to send them.
+type scpArgs struct {
+	sink      bool // -t: receive files; otherwise -f: send them
+	recursive bool // -r
+	targetDir bool // -d: the target must be a directory
+	preserve  bool // -p: preserve modification times and modes
+	paths     []string
+}
+
+// parseSCPArgs parses args, including args[0], as a command line of scp in
+// server mode. It reports false if they aren't one.
+func parseSCPArgs(args []string) (a scpArgs, ok bool) {
+	if len(args) == 0 || path.Base(args[0]) != "scp" {
+		return scpArgs{}, false
+	}
+	var to, from bool
+	i := 1
+	for ; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" {
+			i++
+			break
+		}
+		if !strings.HasPrefix(arg, "-") || arg == "-" {
+			break
+		}
+		for _, f := range arg[1:] {
+			switch f {
+			case 't':
+				to = true
+			case 'f':
+				from = true
+			case 'r':
+				a.recursive = true
+			case 'd':
+				a.targetDir = true
+			case 'p':
+				a.preserve = true
+			case 'v', 'q':
+				// Verbose and quiet; they don't change the protocol.
+			default:
+				return scpArgs{}, false
+			}
+		}
+	}
+	a.paths = args[i:]
+	if to == from || len(a.paths) == 0 || (to && len(a.paths) != 1) {
+		return scpArgs{}, false
+	}
+	a.sink = to
+	return a, true
+}
+
+// isSCPPattern reports whether the scp source path p is a pattern for
+// scpGlob to expand.
+func isSCPPattern(p string) bool {
+	return strings.ContainsAny(p, `*?[`)
+}
+
+// parseSCPEntry parses the rest of an scp "C" (file) or "D" (directory)
+// protocol line, after its first byte, as in "0644 1234 name".
+func parseSCPEntry(s string) (mode fs.FileMode, size int64, name string, err error) {
+	modeStr, rest, ok1 := strings.Cut(s, " ")
+	sizeStr, name, ok2 := strings.Cut(rest, " ")
+	if !ok1 || !ok2 || name == "" {
+		return 0, 0, "", fmt.Errorf("malformed scp entry %q", s)
+	}
+	m, err := strconv.ParseUint(modeStr, 8, 32)
+	if err != nil || m > 0o7777 {
+		return 0, 0, "", fmt.Errorf("bad scp mode %q", modeStr)
+	}
+	size, err = strconv.ParseInt(sizeStr, 10, 64)
+	if err != nil || size < 0 {
+		return 0, 0, "", fmt.Errorf("bad scp size %q", sizeStr)
+	}
+	return fs.FileMode(m).Perm(), size, name, nil
+}
+
+// transferTap observes the streams of an SFTP or scp session to record its
+// file transfers.
+type transferTap interface {
+	// clientData and serverData are called with data sent by the client
+	// and the server, before it's passed on. An error ends the session.
+	clientData(p []byte) error
+	serverData(p []byte) error
+
+	// finish records transfers still in progress when the session ends.
+	finish()
+}
+
+// newTransferTap returns a tap that records the file transfers of ss in
+// rec, or nil if ss isn't an SFTP or scp session.
+func (ss *sshSession) newTransferTap(rec *recording) transferTap {
+	if ss.Subsystem() == "sftp" {
+		return &sftpTap{
+			rec:     &transferRecorder{r: rec, protocol: "sftp"},
+			pending: map[uint32]sftpRequest{},
+			files:   map[string]*sftpFile{},
+		}
+	}
+	if a, ok := parseSCPArgs(ss.Command()); ok {
+		return &scpTap{rec: &transferRecorder{r: rec, protocol: "scp"}, args: a}
+	}
+	return nil
+}
+
+// tapWriter is an io.Writer that passes writes to tap before writing them
+// to w.
+type tapWriter struct {
+	w   io.Writer
+	tap func([]byte) error
+}
+
+func (w tapWriter) Write(p []byte) (int, error) {
+	if err := w.tap(p); err != nil {
+		return 0, err
+	}
+	return w.w.Write(p)
+}
+
+// transferRecorder writes FileTransfer events to a recording.
+type transferRecorder struct {
+	r        *recording
+	protocol string
+
+	// failedOpen is whether writing to r failed and r.failOpen is set,
+	// so no more events are written.
+	failedOpen bool
+}
+
+// record writes ft to the recording. It only returns an error if the
+// write fails and the recording doesn't fail open.
+func (tr *transferRecorder) record(ft sessionrecording.FileTransfer) error {
+	if tr.failedOpen {
+		return nil
+	}
+	ft.Protocol = tr.protocol
+	if err := tr.r.writeEvent(sessionrecording.FileTransferEventCode, ft); err != nil {
+		if !tr.r.failOpen {
+			return err
+		}
+		tr.failedOpen = true
+	}
+	return nil
+}
+
+// writeEvent writes a cast line with the given event code and data.
+func (r *recording) writeEvent(code string, data any) error {
+	j, err := json.Marshal([]any{
+		time.Since(r.start).Seconds(),
+		code,
+		data,
+	})
+	if err != nil {
+		return err
+	}
+	j = append(j, '\n')
+	return loggingWriter{r: r}.writeCastLine(j)
+}
+
+// SFTP packet types, from draft-ietf-secsh-filexfer-02.
+const (
+	sftpOpen     = 3
+	sftpClose    = 4
+	sftpRead     = 5
+	sftpWrite    = 6
+	sftpRemove   = 13
+	sftpMkdir    = 14
+	sftpRmdir    = 15
+	sftpRename   = 18
+	sftpStatus   = 101
+	sftpHandle   = 102
+	sftpData     = 103
+	sftpExtended = 200
+
+	sftpFlagWrite = 0x2 // SSH_FXF_WRITE open flag
+
+	// maxSFTPPacket is the largest SFTP packet the tap parses. Larger
+	// ones mean the stream isn't SFTP, or is out of sync, and stop the
+	// tap. Clients and servers limit packets to 256 KiB.
+	maxSFTPPacket = 1 << 20
+
+	// maxSFTPPending is the maximum number of requests awaiting responses
+	// that the tap tracks.
+	maxSFTPPending = 4096
+)
+
+// sftpTap is a transferTap for SFTP sessions.
+type sftpTap struct {
+	rec *transferRecorder
+
+	mu      sync.Mutex
+	client  sftpStream
+	server  sftpStream
+	pending map[uint32]sftpRequest // by request ID
+	files   map[string]*sftpFile   // open files, by handle
+}
+
+// sftpRequest is a client request that the tap awaits the response to.
+type sftpRequest struct {
+	typ    byte
+	handle string // of CLOSE, READ and WRITE
+	n      int64  // bytes of WRITE
+	write  bool   // whether OPEN is for writing
+	ft     sessionrecording.FileTransfer
+}
+
+// sftpFile is a file opened over SFTP.
+type sftpFile struct {
+	path  string
+	write bool
+	n     int64 // bytes read or written
+}
+
+func (t *sftpTap) clientData(p []byte) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.client.packets(p, t.clientPacket)
+}
+
+func (t *sftpTap) serverData(p []byte) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.server.packets(p, t.serverPacket)
+}
+
+func (t *sftpTap) clientPacket(typ byte, b sftpBuf) error {
+	switch typ {
+	case sftpOpen, sftpClose, sftpRead, sftpWrite, sftpRemove, sftpMkdir, sftpRmdir, sftpRename, sftpExtended:
+	default:
+		return nil
+	}
+	id, ok := b.uint32()
+	if !ok || len(t.pending) >= maxSFTPPending {
+		return nil
+	}
+	req := sftpRequest{typ: typ}
+	switch typ {
+	case sftpOpen:
+		req.ft.Path, ok = b.string()
+		flags, _ := b.uint32()
+		req.write = flags&sftpFlagWrite != 0
+	case sftpClose, sftpRead:
+		req.handle, ok = b.string()
+	case sftpWrite:
+		req.handle, _ = b.string()
+		b.uint64() // offset
+		req.n, ok = b.skipString()
+	case sftpRemove, sftpMkdir, sftpRmdir:
+		req.ft.Op = map[byte]string{sftpRemove: "remove", sftpMkdir: "mkdir", sftpRmdir: "rmdir"}[typ]
+		req.ft.Path, ok = b.string()
+	case sftpRename:
+		req.ft.Op = "rename"
+		req.ft.Path, _ = b.string()
+		req.ft.NewPath, ok = b.string()
+	case sftpExtended:
+		if name, _ := b.string(); name != "posix-rename@openssh.com" {
+			return nil
+		}
+		req.ft.Op = "rename"
+		req.ft.Path, _ = b.string()
+		req.ft.NewPath, ok = b.string()
+	}
+	if ok {
+		t.pending[id] = req
+	}
+	return nil
+}
+
+func (t *sftpTap) serverPacket(typ byte, b sftpBuf) error {
+	switch typ {
+	case sftpStatus, sftpHandle, sftpData:
+	default:
+		return nil
+	}
+	id, ok := b.uint32()
+	if !ok {
+		return nil
+	}
+	req, ok := t.pending[id]
+	if !ok {
+		return nil
+	}
+	delete(t.pending, id)
+	switch typ {
+	case sftpHandle:
+		if h, ok := b.string(); ok && req.typ == sftpOpen {
+			t.files[h] = &sftpFile{path: req.ft.Path, write: req.write}
+		}
+	case sftpData:
+		if n, ok := b.skipString(); ok && req.typ == sftpRead {
+			if f := t.files[req.handle]; f != nil {
+				f.n += n
+			}
+		}
+	case sftpStatus:
+		code, _ := b.uint32()
+		if req.typ == sftpClose {
+			f := t.files[req.handle]
+			delete(t.files, req.handle)
+			if f != nil {
+				return t.rec.record(f.transfer())
+			}
+			return nil
+		}
+		if code != 0 { // SSH_FX_OK
+			return nil
+		}
+		switch req.typ {
+		case sftpWrite:
+			if f := t.files[req.handle]; f != nil {
+				f.n += req.n
+			}
+		case sftpRemove, sftpMkdir, sftpRmdir, sftpRename, sftpExtended:
+			return t.rec.record(req.ft)
+		}
+	}
+	return nil
+}
+
+func (t *sftpTap) finish() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, h := range slices.Sorted(maps.Keys(t.files)) {
+		t.rec.record(t.files[h].transfer())
+	}
+	clear(t.files)
+}
+
+func (f *sftpFile) transfer() sessionrecording.FileTransfer {
+	op := "download"
+	if f.write {
+		op = "upload"
+	}
+	return sessionrecording.FileTransfer{Op: op, Path: f.path, Bytes: f.n}
+}
+
+// sftpStream splits one direction of an SFTP session into packets.
+type sftpStream struct {
+	buf    []byte // unparsed data
+	broken bool   // whether the stream isn't SFTP, so parsing stopped
+}
+
+// packets adds p to the stream and calls f with the type and contents of
+// each packet completed by it.
+func (s *sftpStream) packets(p []byte, f func(typ byte, b sftpBuf) error) error {
+	if s.broken {
+		return nil
+	}
+	s.buf = append(s.buf, p...)
+	var err error
+	for err == nil && len(s.buf) >= 4 {
+		n := binary.BigEndian.Uint32(s.buf)
+		if n == 0 || n > maxSFTPPacket {
+			s.broken = true
+			s.buf = nil
+			return nil
+		}
+		if len(s.buf) < 4+int(n) {
+			break
+		}
+		pkt := s.buf[4 : 4+n]
+		err = f(pkt[0], sftpBuf(pkt[1:]))
+		s.buf = s.buf[4+n:]
+	}
+	if len(s.buf) == 0 {
+		s.buf = nil
+	} else {
+		s.buf = bytes.Clone(s.buf)
+	}
+	return err
+}
+
+// sftpBuf is the unread part of an SFTP packet.
+type sftpBuf []byte
+
+func (b *sftpBuf) uint32() (uint32, bool) {
+	if len(*b) < 4 {
+		return 0, false
+	}
+	v := binary.BigEndian.Uint32(*b)
+	*b = (*b)[4:]
+	return v, true
+}
+
+func (b *sftpBuf) uint64() (uint64, bool) {
+	if len(*b) < 8 {
+		return 0, false
+	}
+	v := binary.BigEndian.Uint64(*b)
+	*b = (*b)[8:]
+	return v, true
+}
+
+func (b *sftpBuf) string() (string, bool) {
+	n, ok := b.uint32()
+	if !ok || uint64(len(*b)) < uint64(n) {
+		return "", false
+	}
+	s := string((*b)[:n])
+	*b = (*b)[n:]
+	return s, true
+}
+
+// skipString skips a string, such as the data of a READ or WRITE, and
+// returns its length.
+func (b *sftpBuf) skipString() (int64, bool) {
+	n, ok := b.uint32()
+	if !ok || uint64(len(*b)) < uint64(n) {
+		return 0, false
+	}
+	*b = (*b)[n:]
+	return int64(n), true
+}
+
+// maxSCPLine is the longest scp protocol line the tap parses. Longer ones
+// mean the stream isn't scp, or is out of sync, and stop the tap.
+const maxSCPLine = 4096
+
+// scpTap is a transferTap for scp sessions. Only the stream carrying files,
+// from the client to a sink or from a source to the client, is parsed.
+type scpTap struct {
+	rec  *transferRecorder
+	args scpArgs
+
+	mu      sync.Mutex
+	broken  bool     // whether the stream isn't scp, so parsing stopped
+	line    []byte   // partial protocol line
+	dirs    []string // names of the directories being transferred
+	arg     int      // index in args.paths of the path being sent, as a source
+	dirPath string   // path of the top-level directory being sent, as a source
+
+	file      *sessionrecording.FileTransfer // file being transferred, if any
+	remaining int64                          // bytes of file data left, plus its status byte
+}
+
+func (t *scpTap) clientData(p []byte) error {
+	if !t.args.sink {
+		return nil
+	}
+	return t.data(p)
+}
+
+func (t *scpTap) serverData(p []byte) error {
+	if t.args.sink {
+		return nil
+	}
+	return t.data(p)
+}
+
+func (t *scpTap) data(p []byte) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for len(p) > 0 && !t.broken {
+		if t.file != nil {
+			n := min(int64(len(p)), t.remaining)
+			t.remaining -= n
+			p = p[n:]
+			if t.remaining == 0 {
+				ft := *t.file
+				t.file = nil
+				if err := t.rec.record(ft); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		i := bytes.IndexByte(p, '\n')
+		if i < 0 {
+			t.line = append(t.line, p...)
+			if len(t.line) > maxSCPLine {
+				t.broken = true
+				t.line = nil
+			}
+			return nil
+		}
+		line := string(append(t.line, p[:i]...))
+		t.line = t.line[:0]
+		p = p[i+1:]
+		t.handleLine(strings.TrimLeft(line, "\x00"))
+	}
+	return nil
+}
+
+func (t *scpTap) handleLine(line string) {
+	if line == "" {
+		return
+	}
+	switch line[0] {
+	case 'C':
+		_, size, name, err := parseSCPEntry(line[1:])
+		if err != nil {
+			t.broken = true
+			return
+		}
+		op, p := "upload", t.args.paths[0]
+		if !t.args.sink {
+			op, p = "download", t.sourcePath(name)
+			if len(t.dirs) == 0 {
+				t.nextArg()
+			}
+		}
+		t.file = &sessionrecording.FileTransfer{
+			Op:    op,
+			Path:  p,
+			Name:  path.Join(append(slices.Clone(t.dirs), name)...),
+			Bytes: size,
+		}
+		t.remaining = size + 1
+	case 'D':
+		_, _, name, err := parseSCPEntry(line[1:])
+		if err != nil {
+			t.broken = true
+			return
+		}
+		if !t.args.sink && len(t.dirs) == 0 {
+			t.dirPath = t.sourcePath(name)
+		}
+		t.dirs = append(t.dirs, name)
+	case 'E':
+		if len(t.dirs) > 0 {
+			t.dirs = t.dirs[:len(t.dirs)-1]
+			if len(t.dirs) == 0 {
+				t.nextArg()
+			}
+		}
+	case '\x01', '\x02':
+		// A source's warnings and errors about the paths it can't send.
+		if len(t.dirs) == 0 {
+			t.nextArg()
+		}
+	}
+}
+
+// sourcePath returns the path, as sent by a source, of the file or
+// directory being transferred within which is the one named name. It's
+// that of the top-level file or directory being sent, which is name itself
+// if the source is at the top level.
+//
+// Sources send the paths in args.paths in turn, with those that are
+// patterns expanded by scpGlob into the paths they match, so the path is
+// found by matching name against them.
+func (t *scpTap) sourcePath(name string) string {
+	if len(t.dirs) > 0 {
+		return t.dirPath
+	}
+	for t.arg < len(t.args.paths)-1 && !scpArgMatches(t.args.paths[t.arg], name) {
+		t.arg++
+	}
+	arg := t.args.paths[min(t.arg, len(t.args.paths)-1)]
+	if isSCPPattern(arg) && scpArgMatches(arg, name) {
+		return path.Join(path.Dir(arg), name)
+	}
+	return arg
+}
+
+// nextArg notes that a top-level file or directory has been sent, or
+// couldn't be. Paths other than patterns are each sent once.
+func (t *scpTap) nextArg() {
+	if t.arg < len(t.args.paths) && !isSCPPattern(t.args.paths[t.arg]) {
+		t.arg++
+	}
+}
+
+// scpArgMatches reports whether the file or directory named name may have
+// been sent by a source for the path arg.
+func scpArgMatches(arg, name string) bool {
+	ok, _ := path.Match(path.Base(arg), name)
+	return ok || path.Base(arg) == name
+}
+
+func (t *scpTap) finish() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.file != nil {
+		ft := *t.file
+		ft.Bytes -= max(t.remaining-1, 0)
+		t.rec.record(ft)
+		t.file = nil
+	}
+}
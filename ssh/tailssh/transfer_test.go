@@ -0,0 +1,379 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build linux || darwin
+
+package tailssh
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/pkg/sftp"
+	"tailscale.com/sessionrecording"
+)
+
+func TestParseSCPArgs(t *testing.T) {
+	tests := []struct {
+		args []string
+		want scpArgs
+		ok   bool
+	}{
+		{[]string{"scp", "-t", "dst"}, scpArgs{sink: true, paths: []string{"dst"}}, true},
+		{[]string{"/usr/bin/scp", "-qrpt", "--", "-dst"}, scpArgs{sink: true, recursive: true, preserve: true, paths: []string{"-dst"}}, true},
+		{[]string{"scp", "-v", "-d", "-t", "dir"}, scpArgs{sink: true, targetDir: true, paths: []string{"dir"}}, true},
+		{[]string{"scp", "-f", "a", "b c"}, scpArgs{paths: []string{"a", "b c"}}, true},
+		{[]string{"scp", "-t", "a", "b"}, scpArgs{}, false},
+		{[]string{"scp", "-f"}, scpArgs{}, false},
+		{[]string{"scp", "-tf", "a"}, scpArgs{}, false},
+		{[]string{"scp", "a", "host:b"}, scpArgs{}, false},
+		{[]string{"scp", "-x", "-t", "a"}, scpArgs{}, false},
+		{[]string{"scpx", "-t", "a"}, scpArgs{}, false},
+		{nil, scpArgs{}, false},
+	}
+	for _, tt := range tests {
+		got, ok := parseSCPArgs(tt.args)
+		if ok != tt.ok || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseSCPArgs(%q) = %+v, %v; want %+v, %v", tt.args, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+type nopWriteCloser struct{ io.Writer }
+
+func (nopWriteCloser) Close() error { return nil }
+
+// newTestRecording returns a recording written to buf.
+func newTestRecording(buf *bytes.Buffer) *recording {
+	return &recording{start: time.Now(), failOpen: true, out: nopWriteCloser{buf}}
+}
+
+// recordedTransfers returns the FileTransfer events in a recording.
+func recordedTransfers(t *testing.T, rec []byte) []sessionrecording.FileTransfer {
+	t.Helper()
+	var got []sessionrecording.FileTransfer
+	sc := bufio.NewScanner(bytes.NewReader(rec))
+	for sc.Scan() {
+		var ev []json.RawMessage
+		if err := json.Unmarshal(sc.Bytes(), &ev); err != nil || len(ev) != 3 {
+			continue // header
+		}
+		var code string
+		json.Unmarshal(ev[1], &code)
+		if code != sessionrecording.FileTransferEventCode {
+			continue
+		}
+		var ft sessionrecording.FileTransfer
+		if err := json.Unmarshal(ev[2], &ft); err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, ft)
+	}
+	return got
+}
+
+func TestSCPServer(t *testing.T) {
+	src, dst := t.TempDir(), t.TempDir()
+	mtime := time.Unix(1700000000, 0)
+	files := map[string]string{
+		"dir/a.txt":       "hello",
+		"dir/empty":       "",
+		"dir/sub/b c.txt": "world",
+	}
+	for name, data := range files {
+		p := filepath.Join(src, name)
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(data), 0o640); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(p, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(src, "top"), []byte("top file"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	// Connect a source to a sink, as an scp client does with two remote
+	// hosts, and tap the stream of files between them.
+	sourceRec, sinkRec, sourceErr, sinkErr := runSCP(t,
+		[]string{"scp", "-rpf", filepath.Join(src, "dir"), filepath.Join(src, "missing"), filepath.Join(src, "top")},
+		[]string{"scp", "-rpdt", dst})
+	if sourceErr != errSCPWarnings {
+		t.Errorf("source: err = %v, want %v", sourceErr, errSCPWarnings)
+	}
+	if sinkErr != errSCPWarnings {
+		t.Errorf("sink: err = %v, want %v", sinkErr, errSCPWarnings)
+	}
+
+	for name, want := range files {
+		p := filepath.Join(dst, name)
+		got, err := os.ReadFile(p)
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		if string(got) != want {
+			t.Errorf("%s = %q, want %q", name, got, want)
+		}
+		fi, err := os.Stat(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fi.Mode().Perm() != 0o640 || !fi.ModTime().Equal(mtime) {
+			t.Errorf("%s: mode %v, mtime %v; want %v, %v", name, fi.Mode().Perm(), fi.ModTime(), os.FileMode(0o640), mtime)
+		}
+	}
+	if got, err := os.ReadFile(filepath.Join(dst, "top")); string(got) != "top file" {
+		t.Errorf("top = %q, %v", got, err)
+	}
+
+	want := []sessionrecording.FileTransfer{
+		{Protocol: "scp", Op: "download", Path: filepath.Join(src, "dir"), Name: "dir/a.txt", Bytes: 5},
+		{Protocol: "scp", Op: "download", Path: filepath.Join(src, "dir"), Name: "dir/empty"},
+		{Protocol: "scp", Op: "download", Path: filepath.Join(src, "dir"), Name: "dir/sub/b c.txt", Bytes: 5},
+		{Protocol: "scp", Op: "download", Path: filepath.Join(src, "top"), Name: "top", Bytes: 8},
+	}
+	if diff := cmp.Diff(want, recordedTransfers(t, sourceRec)); diff != "" {
+		t.Errorf("source transfers (-want +got):\n%s", diff)
+	}
+	for i := range want {
+		want[i].Op, want[i].Path = "upload", dst
+	}
+	if diff := cmp.Diff(want, recordedTransfers(t, sinkRec)); diff != "" {
+		t.Errorf("sink transfers (-want +got):\n%s", diff)
+	}
+}
+
+// runSCP connects an scp source to a sink, with the given arguments, as an
+// scp client does with two remote hosts, and taps the stream of files
+// between them. It returns their recordings and errors.
+func runSCP(t *testing.T, sourceArgv, sinkArgv []string) (sourceRec, sinkRec []byte, sourceErr, sinkErr error) {
+	t.Helper()
+	sourceArgs, ok := parseSCPArgs(sourceArgv)
+	if !ok {
+		t.Fatalf("parseSCPArgs(%q) failed", sourceArgv)
+	}
+	sinkArgs, ok := parseSCPArgs(sinkArgv)
+	if !ok {
+		t.Fatalf("parseSCPArgs(%q) failed", sinkArgv)
+	}
+	var sourceBuf, sinkBuf bytes.Buffer
+	sourceTap := &scpTap{rec: &transferRecorder{r: newTestRecording(&sourceBuf), protocol: "scp"}, args: sourceArgs}
+	sinkTap := &scpTap{rec: &transferRecorder{r: newTestRecording(&sinkBuf), protocol: "scp"}, args: sinkArgs}
+
+	toSink, fromSource := io.Pipe()
+	toSource, fromSink := io.Pipe()
+	stream := tapWriter{tapWriter{fromSource, sinkTap.clientData}, sourceTap.serverData}
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer fromSink.Close()
+		sinkErr = serveSCP(toSink, fromSink, sinkArgs)
+	}()
+	sourceErr = serveSCP(toSource, stream, sourceArgs)
+	fromSource.Close()
+	wg.Wait()
+	return sourceBuf.Bytes(), sinkBuf.Bytes(), sourceErr, sinkErr
+}
+
+func TestSCPServerGlob(t *testing.T) {
+	src, dst := t.TempDir(), t.TempDir()
+	for _, name := range []string{"a.log", "b.log", ".hidden.log", "c.txt", "logs/d.log"} {
+		p := filepath.Join(src, name)
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(name), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// As with "scp -r host:'src/*.log' host:'src/l*' host:'src/none*' host:src/c.txt dst".
+	sourceRec, sinkRec, sourceErr, sinkErr := runSCP(t,
+		[]string{"scp", "-rf", filepath.Join(src, "*.log"), filepath.Join(src, "l*"), filepath.Join(src, "none*"), filepath.Join(src, "c.txt")},
+		[]string{"scp", "-rdt", dst})
+	// The pattern matching nothing is reported as missing.
+	if sourceErr != errSCPWarnings {
+		t.Errorf("source: err = %v, want %v", sourceErr, errSCPWarnings)
+	}
+	if sinkErr != errSCPWarnings {
+		t.Errorf("sink: err = %v, want %v", sinkErr, errSCPWarnings)
+	}
+	var got []string
+	filepath.WalkDir(dst, func(p string, d fs.DirEntry, err error) error {
+		if err == nil && !d.IsDir() {
+			rel, _ := filepath.Rel(dst, p)
+			got = append(got, filepath.ToSlash(rel))
+		}
+		return nil
+	})
+	if diff := cmp.Diff([]string{"a.log", "b.log", "c.txt", "logs/d.log"}, got); diff != "" {
+		t.Errorf("received files (-want +got):\n%s", diff)
+	}
+
+	// The transfers are recorded with the paths that the patterns matched.
+	want := []sessionrecording.FileTransfer{
+		{Protocol: "scp", Op: "download", Path: filepath.Join(src, "a.log"), Name: "a.log", Bytes: 5},
+		{Protocol: "scp", Op: "download", Path: filepath.Join(src, "b.log"), Name: "b.log", Bytes: 5},
+		{Protocol: "scp", Op: "download", Path: filepath.Join(src, "logs"), Name: "logs/d.log", Bytes: 10},
+		{Protocol: "scp", Op: "download", Path: filepath.Join(src, "c.txt"), Name: "c.txt", Bytes: 5},
+	}
+	if diff := cmp.Diff(want, recordedTransfers(t, sourceRec)); diff != "" {
+		t.Errorf("source transfers (-want +got):\n%s", diff)
+	}
+	for i := range want {
+		want[i].Op, want[i].Path = "upload", dst
+	}
+	if diff := cmp.Diff(want, recordedTransfers(t, sinkRec)); diff != "" {
+		t.Errorf("sink transfers (-want +got):\n%s", diff)
+	}
+}
+
+func TestSCPServerRejectsBadNames(t *testing.T) {
+	dst := t.TempDir()
+	a, _ := parseSCPArgs([]string{"scp", "-t", dst})
+	for _, name := range []string{"..", ".", "a/b", "../x"} {
+		var out bytes.Buffer
+		in := bytes.NewBufferString("C0644 1 " + name + "\nx\x00")
+		if err := serveSCP(in, &out, a); err == nil {
+			t.Errorf("name %q: no error", name)
+		}
+		if !bytes.Contains(out.Bytes(), []byte("\x02")) {
+			t.Errorf("name %q: output %q has no fatal error", name, out.Bytes())
+		}
+	}
+	if ents, _ := os.ReadDir(dst); len(ents) != 0 {
+		t.Errorf("files created: %v", ents)
+	}
+}
+
+func TestSFTPTap(t *testing.T) {
+	var rec bytes.Buffer
+	tap := &sftpTap{
+		rec:     &transferRecorder{r: newTestRecording(&rec), protocol: "sftp"},
+		pending: map[uint32]sftpRequest{},
+		files:   map[string]*sftpFile{},
+	}
+	serverIn, clientOut := io.Pipe()
+	clientIn, serverOut := io.Pipe()
+	srv, err := sftp.NewServer(struct {
+		io.Reader
+		io.Writer
+		io.Closer
+	}{serverIn, tapWriter{serverOut, tap.serverData}, serverOut})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		srv.Serve()
+		serverOut.Close()
+	}()
+	cl, err := sftp.NewClientPipe(clientIn, struct {
+		io.Writer
+		io.Closer
+	}{tapWriter{clientOut, tap.clientData}, clientOut})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cl.Close()
+
+	dir := t.TempDir()
+	up := filepath.Join(dir, "up.txt")
+	f, err := cl.Create(up)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Write(bytes.Repeat([]byte("x"), 100000)); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	f, err = cl.Open(up)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.Copy(io.Discard, f); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	moved := filepath.Join(dir, "moved.txt")
+	if err := cl.Rename(up, moved); err != nil {
+		t.Fatal(err)
+	}
+	if err := cl.PosixRename(moved, up); err != nil {
+		t.Fatal(err)
+	}
+	sub := filepath.Join(dir, "sub")
+	if err := cl.Mkdir(sub); err != nil {
+		t.Fatal(err)
+	}
+	if err := cl.RemoveDirectory(sub); err != nil {
+		t.Fatal(err)
+	}
+	if err := cl.Remove(up); err != nil {
+		t.Fatal(err)
+	}
+	if err := cl.Remove(up); err == nil {
+		t.Fatal("second Remove succeeded")
+	}
+	// A file still open at the end of the session is recorded as
+	// transferred so far.
+	open := filepath.Join(dir, "open.txt")
+	f, err = cl.Create(open)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Write([]byte("partial")); err != nil {
+		t.Fatal(err)
+	}
+	tap.finish()
+
+	want := []sessionrecording.FileTransfer{
+		{Protocol: "sftp", Op: "upload", Path: up, Bytes: 100000},
+		{Protocol: "sftp", Op: "download", Path: up, Bytes: 100000},
+		{Protocol: "sftp", Op: "rename", Path: up, NewPath: moved},
+		{Protocol: "sftp", Op: "rename", Path: moved, NewPath: up},
+		{Protocol: "sftp", Op: "mkdir", Path: sub},
+		{Protocol: "sftp", Op: "rmdir", Path: sub},
+		{Protocol: "sftp", Op: "remove", Path: up},
+		{Protocol: "sftp", Op: "upload", Path: open, Bytes: 7},
+	}
+	if diff := cmp.Diff(want, recordedTransfers(t, rec.Bytes())); diff != "" {
+		t.Errorf("transfers (-want +got):\n%s", diff)
+	}
+}
+
+func TestSFTPTapIgnoresNonSFTP(t *testing.T) {
+	var rec bytes.Buffer
+	tap := &sftpTap{
+		rec:     &transferRecorder{r: newTestRecording(&rec), protocol: "sftp"},
+		pending: map[uint32]sftpRequest{},
+		files:   map[string]*sftpFile{},
+	}
+	for range 3 {
+		if err := tap.clientData([]byte("not an sftp stream\n")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !tap.client.broken || tap.client.buf != nil {
+		t.Errorf("stream not marked broken: %+v", tap.client)
+	}
+	if rec.Len() != 0 {
+		t.Errorf("recorded %q", rec.Bytes())
+	}
+}
@@ -0,0 +1,321 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build (linux && !android) || (darwin && !ios) || freebsd || openbsd || plan9
+
+package tailssh
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/netip"
+	"slices"
+	"strings"
+	"time"
+
+	gossh "golang.org/x/crypto/ssh"
+	"tailscale.com/client/tailscale/apitype"
+	"tailscale.com/envknob"
+	"tailscale.com/ipn/ipnlocal"
+	"tailscale.com/ipn/localapi"
+	"tailscale.com/net/tsaddr"
+	"tailscale.com/tailcfg"
+	"tailscale.com/util/clientmetric"
+)
+
+// sshUserCA is whether this node acts as an OpenSSH user CA, issuing
+// short-lived user certificates to tailnet identities that the SSH policy
+// permits to access it, and accepting those certificates from clients
+// whose connections the policy doesn't otherwise permit, such as those
+// arriving through a jump host.
+var sshUserCA = envknob.RegisterBool("TS_SSH_USER_CA")
+
+const (
+	// defaultUserCertLifetime is how long user certificates are valid for
+	// if the request doesn't specify a duration.
+	defaultUserCertLifetime = time.Hour
+
+	// maxUserCertLifetime is the longest that user certificates are
+	// valid for.
+	maxUserCertLifetime = 24 * time.Hour
+
+	// userCertClockSkew is how far before their issuance user certificates
+	// become valid, to tolerate clock skew between the CA and other
+	// servers that trust it.
+	userCertClockSkew = 5 * time.Minute
+
+	// maxUserCertUsers is the maximum number of users that a certificate
+	// may be requested for.
+	maxUserCertUsers = 32
+
+	// certExtNode is the user certificate extension that holds the stable
+	// node ID of the tailnet identity that the certificate was issued to.
+	certExtNode = "node@tailscale.com"
+)
+
+var (
+	errUserCADisabled     = errors.New("this node is not an SSH user CA")
+	errInvalidCertRequest = errors.New("invalid SSH user certificate request")
+	errNoCertUsers        = errors.New("tailnet policy does not permit access as any of the requested users")
+
+	// errUserCertRequired is returned by clientAuth when the connection
+	// isn't permitted by its Tailscale identity but may still be permitted
+	// with a user certificate. Unlike the other errors it returns, it's not
+	// terminal, so the client can go on to offer a certificate.
+	errUserCertRequired = errors.New("tailscale: SSH user certificate required")
+)
+
+var (
+	metricUserCertsIssued   = clientmetric.NewCounter("ssh_user_certs_issued")
+	metricUserCertAccepted  = clientmetric.NewCounter("ssh_user_cert_accepted")
+	metricUserCertRejected  = clientmetric.NewCounter("ssh_user_cert_rejected")
+	metricLocalAPIUserCerts = clientmetric.NewCounter("localapi_ssh_user_cert")
+)
+
+func init() {
+	ipnlocal.RegisterPeerAPIHandler("/v0/ssh-user-cert", handlePeerAPIUserCert)
+	localapi.Register("ssh-user-cert", serveSSHUserCert)
+}
+
+// handlePeerAPIUserCert handles a peer's request to sign an OpenSSH user
+// certificate, with a JSON [apitype.SSHUserCertRequest] body.
+func handlePeerAPIUserCert(h ipnlocal.PeerAPIHandler, w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "want POST", http.StatusMethodNotAllowed)
+		return
+	}
+	if !sshUserCA() {
+		http.Error(w, errUserCADisabled.Error(), http.StatusForbidden)
+		return
+	}
+	var req apitype.SSHUserCertRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&req); err != nil {
+		http.Error(w, "invalid JSON body", http.StatusBadRequest)
+		return
+	}
+	lb := h.LocalBackend()
+	srv := &server{
+		lb:   lb,
+		logf: h.Logf,
+		timeNow: func() time.Time {
+			return lb.ControlNow(time.Now())
+		},
+	}
+	res, err := srv.issueUserCert(h.RemoteAddr(), req)
+	if err != nil {
+		code := http.StatusInternalServerError
+		if errors.Is(err, errNoCertUsers) {
+			code = http.StatusForbidden
+		} else if errors.Is(err, errInvalidCertRequest) {
+			code = http.StatusBadRequest
+		}
+		http.Error(w, err.Error(), code)
+		return
+	}
+	h.Logf("ssh: issued user certificate to %v for %q until %v", h.Peer().ComputedName(), res.Principals, res.ValidBefore.Format(time.RFC3339))
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(res)
+}
+
+// issueUserCert signs an OpenSSH user certificate for the tailnet identity
+// connecting from src, as requested by req.
+//
+// The certificate's principals are those of req.Users that the SSH policy
+// immediately accepts src's identity as, without mapping them to another
+// local user. Users whose rules require a check are left out, as the check
+// can't be done when the certificate is used by a server other than this
+// one.
+func (srv *server) issueUserCert(src netip.AddrPort, req apitype.SSHUserCertRequest) (*apitype.SSHUserCertResponse, error) {
+	if !sshUserCA() {
+		return nil, errUserCADisabled
+	}
+	pub, _, _, _, err := gossh.ParseAuthorizedKey([]byte(req.PublicKey))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errInvalidCertRequest, err)
+	}
+	if _, ok := pub.(*gossh.Certificate); ok {
+		return nil, fmt.Errorf("%w: public key is a certificate", errInvalidCertRequest)
+	}
+	if len(req.Users) == 0 || len(req.Users) > maxUserCertUsers {
+		return nil, fmt.Errorf("%w: must request between 1 and %d users", errInvalidCertRequest, maxUserCertUsers)
+	}
+	node, uprof, ok := srv.lb.WhoIs("tcp", src)
+	if !ok {
+		return nil, fmt.Errorf("unknown Tailscale identity from src %v", src)
+	}
+
+	lifetime := defaultUserCertLifetime
+	if req.Duration > 0 {
+		lifetime = min(req.Duration, maxUserCertLifetime)
+	}
+	var principals []string
+	for _, u := range req.Users {
+		if u == "" || slices.Contains(principals, u) {
+			continue
+		}
+		c := &conn{srv: srv, connID: "ssh-user-cert", info: &sshConnInfo{
+			sshUser: u,
+			src:     src,
+			node:    node,
+			uprof:   uprof,
+		}}
+		action, localUser, _, result := c.evaluatePolicy()
+		if result != accepted || !action.Accept || localUser != u {
+			continue
+		}
+		principals = append(principals, u)
+		if action.SessionDuration > 0 {
+			lifetime = min(lifetime, action.SessionDuration)
+		}
+	}
+	if len(principals) == 0 {
+		return nil, errNoCertUsers
+	}
+
+	ca, err := srv.lb.GetSSH_UserCAKey()
+	if err != nil {
+		return nil, err
+	}
+	keyID := uprof.LoginName
+	if node.IsTagged() {
+		keyID = node.Name()
+	}
+	now := srv.now()
+	cert := &gossh.Certificate{
+		Key:             pub,
+		Serial:          binary.LittleEndian.Uint64(randBytes(8)),
+		CertType:        gossh.UserCert,
+		KeyId:           keyID,
+		ValidPrincipals: principals,
+		ValidAfter:      uint64(now.Add(-userCertClockSkew).Unix()),
+		ValidBefore:     uint64(now.Add(lifetime).Unix()),
+		Permissions: gossh.Permissions{
+			Extensions: map[string]string{
+				"permit-agent-forwarding": "",
+				"permit-port-forwarding":  "",
+				"permit-pty":              "",
+				"permit-user-rc":          "",
+				certExtNode:               string(node.StableID()),
+			},
+		},
+	}
+	if err := cert.SignCert(rand.Reader, ca); err != nil {
+		return nil, err
+	}
+	metricUserCertsIssued.Add(1)
+	return &apitype.SSHUserCertResponse{
+		Certificate: strings.TrimSpace(string(gossh.MarshalAuthorizedKey(cert))),
+		CAPublicKey: strings.TrimSpace(string(gossh.MarshalAuthorizedKey(ca.PublicKey()))),
+		Principals:  principals,
+		ValidBefore: time.Unix(int64(cert.ValidBefore), 0),
+	}, nil
+}
+
+// setCertInfo returns the sshConnInfo for a connection authenticated with
+// cert, which must be a valid user certificate issued by this node's SSH
+// user CA for the requested user. The Tailscale identity is that of the
+// node the certificate was issued to, rather than the one the connection
+// came from.
+func (c *conn) setCertInfo(cm gossh.ConnMetadata, cert *gossh.Certificate) (*sshConnInfo, error) {
+	ci := &sshConnInfo{
+		sshUser: strings.TrimSuffix(cm.User(), forcePasswordSuffix),
+		src:     toIPPort(cm.RemoteAddr()),
+		dst:     toIPPort(cm.LocalAddr()),
+		cert:    cert,
+	}
+	if !tsaddr.IsTailscaleIP(ci.dst.Addr()) {
+		return nil, fmt.Errorf("tailssh: rejecting non-Tailscale local address %v", ci.dst)
+	}
+	if cert.CertType != gossh.UserCert {
+		return nil, errors.New("not a user certificate")
+	}
+	ca, err := c.srv.lb.GetSSH_UserCAKey()
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(cert.SignatureKey.Marshal(), ca.PublicKey().Marshal()) {
+		return nil, errors.New("certificate not signed by this node's SSH user CA")
+	}
+	// CheckCert checks the certificate's signature, validity period and
+	// principals, but not who signed it.
+	checker := &gossh.CertChecker{Clock: c.srv.now}
+	if err := checker.CheckCert(ci.sshUser, cert); err != nil {
+		return nil, err
+	}
+
+	nm := c.srv.lb.NetMap()
+	if nm == nil {
+		return nil, errors.New("no netmap")
+	}
+	id := tailcfg.StableNodeID(cert.Extensions[certExtNode])
+	node, ok := nm.PeerWithStableID(id)
+	if !ok && nm.SelfNode.Valid() && nm.SelfNode.StableID() == id {
+		node, ok = nm.SelfNode, true
+	}
+	if !ok {
+		return nil, fmt.Errorf("unknown Tailscale node %q in certificate %q", id, cert.KeyId)
+	}
+	ci.node = node
+	if up, ok := nm.UserProfiles[node.User()]; ok {
+		ci.uprof = *up.AsStruct()
+	}
+	return ci, nil
+}
+
+// serveSSHUserCert requests an OpenSSH user certificate from a peer acting
+// as an SSH user CA, forwarding the JSON [apitype.SSHUserCertRequest] body
+// to its PeerAPI and returning its JSON [apitype.SSHUserCertResponse].
+//
+// URL format:
+//
+//   - POST /localapi/v0/ssh-user-cert?peer=:stableID
+func serveSSHUserCert(h *localapi.Handler, w http.ResponseWriter, r *http.Request) {
+	metricLocalAPIUserCerts.Add(1)
+
+	if !h.PermitWrite {
+		http.Error(w, "access denied", http.StatusForbidden)
+		return
+	}
+	if r.Method != "POST" {
+		http.Error(w, "want POST", http.StatusMethodNotAllowed)
+		return
+	}
+	lb := h.LocalBackend()
+	nb := lb.NodeBackend()
+	peerID := tailcfg.StableNodeID(r.FormValue("peer"))
+	peers := nb.AppendMatchingPeers(nil, func(p tailcfg.NodeView) bool {
+		return p.StableID() == peerID
+	})
+	if len(peers) == 0 {
+		http.Error(w, "node not found", http.StatusNotFound)
+		return
+	}
+	base := nb.PeerAPIBase(peers[0])
+	if base == "" {
+		http.Error(w, "node has no PeerAPI", http.StatusBadGateway)
+		return
+	}
+	outReq, err := http.NewRequestWithContext(r.Context(), "POST", base+"/v0/ssh-user-cert", http.MaxBytesReader(w, r.Body, 1<<20))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	outReq.Header.Set("Content-Type", "application/json")
+	res, err := lb.Dialer().PeerAPIHTTPClient().Do(outReq)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	defer res.Body.Close()
+	if ct := res.Header.Get("Content-Type"); ct != "" {
+		w.Header().Set("Content-Type", ct)
+	}
+	w.WriteHeader(res.StatusCode)
+	io.Copy(w, res.Body)
+}
@@ -0,0 +1,206 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build linux || darwin
+
+package tailssh
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"net/netip"
+	"runtime"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	gossh "golang.org/x/crypto/ssh"
+	"tailscale.com/client/tailscale/apitype"
+	"tailscale.com/envknob"
+	"tailscale.com/net/memnet"
+	"tailscale.com/tailcfg"
+	testssh "tailscale.com/tempfork/sshtest/ssh"
+	"tailscale.com/tstest"
+	"tailscale.com/util/must"
+)
+
+func setUserCA(t *testing.T, on bool) {
+	envknob.Setenv("TS_SSH_USER_CA", fmt.Sprint(on))
+	t.Cleanup(func() { envknob.Setenv("TS_SSH_USER_CA", "") })
+}
+
+func newUserKey(t *testing.T) gossh.Signer {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return must.Get(gossh.NewSignerFromSigner(priv))
+}
+
+func TestIssueUserCert(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	srv := &server{
+		lb: &localState{
+			sshEnabled: true,
+			matchingRule: &tailcfg.SSHRule{
+				Principals: []*tailcfg.SSHPrincipal{{NodeIP: "100.100.100.50"}},
+				SSHUsers:   map[string]string{"alice": "=", "bob": "=", "root": "alice"},
+				Action:     &tailcfg.SSHAction{Accept: true, SessionDuration: 30 * time.Minute},
+			},
+		},
+		logf:    t.Logf,
+		timeNow: func() time.Time { return now },
+	}
+	src := netip.MustParseAddrPort("100.100.100.50:1234")
+	userKey := newUserKey(t)
+	req := apitype.SSHUserCertRequest{
+		PublicKey: string(gossh.MarshalAuthorizedKey(userKey.PublicKey())),
+		Users:     []string{"alice", "root", "carol", "alice", "bob"},
+	}
+
+	if _, err := srv.issueUserCert(src, req); err != errUserCADisabled {
+		t.Fatalf("issueUserCert without CA: err = %v, want %v", err, errUserCADisabled)
+	}
+	setUserCA(t, true)
+
+	res, err := srv.issueUserCert(src, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// root is mapped to another local user, and carol isn't permitted.
+	if want := []string{"alice", "bob"}; !slices.Equal(res.Principals, want) {
+		t.Errorf("Principals = %q, want %q", res.Principals, want)
+	}
+	if want := now.Add(30 * time.Minute); !res.ValidBefore.Equal(want) {
+		t.Errorf("ValidBefore = %v, want %v (capped by SessionDuration)", res.ValidBefore, want)
+	}
+	if want := string(gossh.MarshalAuthorizedKey(testUserCA().PublicKey())); res.CAPublicKey+"\n" != want {
+		t.Errorf("CAPublicKey = %q, want %q", res.CAPublicKey, want)
+	}
+	pub, _, _, _, err := gossh.ParseAuthorizedKey([]byte(res.Certificate))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, ok := pub.(*gossh.Certificate)
+	if !ok {
+		t.Fatalf("Certificate is a %T", pub)
+	}
+	if cert.KeyId != "peer" || cert.CertType != gossh.UserCert || cert.Extensions[certExtNode] != "peer-id" {
+		t.Errorf("cert KeyId=%q CertType=%v node=%q", cert.KeyId, cert.CertType, cert.Extensions[certExtNode])
+	}
+	if string(cert.SignatureKey.Marshal()) != string(testUserCA().PublicKey().Marshal()) {
+		t.Error("certificate not signed by CA")
+	}
+	checker := &gossh.CertChecker{Clock: func() time.Time { return now }}
+	if err := checker.CheckCert("bob", cert); err != nil {
+		t.Errorf("CheckCert: %v", err)
+	}
+
+	// Source addresses that the policy doesn't permit get no certificate.
+	if _, err := srv.issueUserCert(netip.MustParseAddrPort("100.100.100.101:1234"), req); err != errNoCertUsers {
+		t.Errorf("issueUserCert from other node: err = %v, want %v", err, errNoCertUsers)
+	}
+	req.PublicKey = res.Certificate
+	if _, err := srv.issueUserCert(src, req); !errors.Is(err, errInvalidCertRequest) {
+		t.Errorf("issueUserCert of certificate: err = %v, want %v", err, errInvalidCertRequest)
+	}
+}
+
+func TestSSHUserCertAuth(t *testing.T) {
+	if runtime.GOOS != "linux" && runtime.GOOS != "darwin" {
+		t.Skipf("skipping on %q; only runs on linux and darwin", runtime.GOOS)
+	}
+	// Only the node that certificates are issued to is permitted, and
+	// connections come from another node, as if through a jump host.
+	rule := newSSHRule(&tailcfg.SSHAction{Accept: true})
+	rule.Principals = []*tailcfg.SSHPrincipal{{NodeIP: "100.100.100.50"}}
+	state := &localState{sshEnabled: true, matchingRule: rule}
+
+	now := time.Now()
+	_, userPriv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	userKey := must.Get(gossh.NewSignerFromSigner(userPriv))
+	newCert := func(principal string, signer gossh.Signer, validBefore time.Time) testssh.Signer {
+		cert := &gossh.Certificate{
+			Key:             userKey.PublicKey(),
+			CertType:        gossh.UserCert,
+			KeyId:           "peer",
+			ValidPrincipals: []string{principal},
+			ValidAfter:      uint64(now.Add(-time.Minute).Unix()),
+			ValidBefore:     uint64(validBefore.Unix()),
+			Permissions: gossh.Permissions{
+				Extensions: map[string]string{certExtNode: "peer-id"},
+			},
+		}
+		must.Do(cert.SignCert(rand.Reader, signer))
+		tcert := must.Get(testssh.ParsePublicKey(cert.Marshal())).(*testssh.Certificate)
+		return must.Get(testssh.NewCertSigner(tcert, must.Get(testssh.NewSignerFromSigner(userPriv))))
+	}
+	plainKey := must.Get(testssh.NewSignerFromSigner(userPriv))
+	later := now.Add(time.Hour)
+
+	tests := []struct {
+		name    string
+		ca      bool
+		keys    []testssh.Signer
+		authErr bool
+	}{
+		{"cert", true, []testssh.Signer{newCert("alice", testUserCA(), later)}, false},
+		{"plain-key-then-cert", true, []testssh.Signer{plainKey, newCert("alice", testUserCA(), later)}, false},
+		{"no-cert", true, []testssh.Signer{plainKey}, true},
+		{"ca-disabled", false, []testssh.Signer{newCert("alice", testUserCA(), later)}, true},
+		{"other-principal", true, []testssh.Signer{newCert("bob", testUserCA(), later)}, true},
+		{"expired", true, []testssh.Signer{newCert("alice", testUserCA(), now.Add(-time.Second))}, true},
+		{"other-ca", true, []testssh.Signer{newCert("alice", newUserKey(t), later)}, true},
+	}
+	s := &server{logf: tstest.WhileTestRunningLogger(t), lb: state}
+	defer s.Shutdown()
+	src, dst := must.Get(netip.ParseAddrPort("100.100.100.101:2231")), must.Get(netip.ParseAddrPort("100.100.100.102:22"))
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setUserCA(t, tt.ca)
+			s.logf = tstest.WhileTestRunningLogger(t)
+			sc, dc := memnet.NewTCPConn(src, dst, 1024)
+			cfg := &testssh.ClientConfig{
+				User:            "alice",
+				HostKeyCallback: testssh.InsecureIgnoreHostKey(),
+				Auth:            []testssh.AuthMethod{testssh.PublicKeys(tt.keys...)},
+			}
+			var wg sync.WaitGroup
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				c, chans, reqs, err := testssh.NewClientConn(sc, sc.RemoteAddr().String(), cfg)
+				if err != nil {
+					if !tt.authErr {
+						t.Errorf("client: %v", err)
+					}
+					return
+				}
+				if tt.authErr {
+					t.Errorf("client: expected error, got nil")
+				}
+				client := testssh.NewClient(c, chans, reqs)
+				defer client.Close()
+				session, err := client.NewSession()
+				if err != nil {
+					t.Errorf("client: %v", err)
+					return
+				}
+				defer session.Close()
+				if _, err := session.CombinedOutput("echo Ran echo!"); err != nil {
+					t.Errorf("client: %v", err)
+				}
+			}()
+			if err := s.HandleSSHConn(dc); err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+			wg.Wait()
+		})
+	}
+}
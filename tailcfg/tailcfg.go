@@ -178,7 +178,10 @@
 //   - 129: 2025-10-04: Fixed sleep/wake deadlock in magicsock when using peer relay (PR #17449)
 //   - 130: 2025-10-06: client can send key.HardwareAttestationPublic and key.HardwareAttestationKeySignature in MapRequest
 //   - 131: 2025-11-25: client respects [NodeAttrDefaultAutoUpdate]
-const CurrentCapabilityVersion CapabilityVersion = 131
+//   - 132: 2026-10-16: Client records forwarded SSH channels and understands SSHAction.RequireForwardingRecording.
+//   - 133: 2026-10-16: Client understands SSHAction.TamperEvidentRecording.
+//   - 134: 2026-10-16: Client applies [NodeAttrRelayServerPolicy] to its peer relay server.
+const CurrentCapabilityVersion CapabilityVersion = 134
 
 // ID is an integer ID for a user, node, or login allocated by the
 // control plane.
@@ -2691,6 +2694,15 @@ type Oauth2Token struct {
 	// only needs to be present in [NodeCapMap] to take effect.
 	NodeAttrDisableRelayServer NodeCapability = "disable-relay-server"
 
+	// NodeAttrRelayServerPolicy limits the use of the node's UDP relay
+	// server, if it runs one. Its value is a JSON object with any of the
+	// fields AllowTags, AllowUsers, MaxEndpoints, MaxBytesPerSecond,
+	// MaxPacketsPerSecond, BindLifetime and SteadyStateLifetime (the last
+	// two as Go duration strings, such as "5m"), as documented on the
+	// relay server's status.ServerPolicy. The node applies changes to it
+	// without restarting the relay server.
+	NodeAttrRelayServerPolicy NodeCapability = "relay-server-policy"
+
 	// NodeAttrDisableRelayClient prevents the node from both allocating UDP
 	// relay server endpoints itself, and from using endpoints allocated by
 	// its peers. This attribute can be added to the node dynamically; if added
@@ -2969,6 +2981,21 @@ type SSHAction struct {
 	// OnRecorderFailure is the action to take if recording fails.
 	// If nil, the default action is to fail open.
 	OnRecordingFailure *SSHRecorderFailureAction `json:"onRecordingFailure,omitempty"`
+
+	// RequireForwardingRecording, if true, allows agent forwarding and
+	// local and remote port forwarding only while the forwarded channels
+	// are recorded by one of Recorders. Forwarding is rejected if no
+	// recording can be started, and forwarded channels are closed if the
+	// recording fails. If false, forwarded channels are recorded on a
+	// best-effort basis when Recorders is non-empty.
+	RequireForwardingRecording bool `json:"requireForwardingRecording,omitempty"`
+
+	// TamperEvidentRecording, if true, specifies that recordings are made
+	// in the tamper-evident format of sessionrecording.ChainWriter, with
+	// their lines hash-chained and signed with the node's Ed25519 SSH host
+	// key. Sessions that can't be recorded in that format are handled as if
+	// their recording failed to start.
+	TamperEvidentRecording bool `json:"tamperEvidentRecording,omitempty"`
 }
 
 // SSHRecorderFailureAction is the action to take if recording fails.
@@ -2134,18 +2134,35 @@ func (v SSHActionView) OnRecordingFailure() views.ValuePointer[SSHRecorderFailur
 	return views.ValuePointerOf(v.ж.OnRecordingFailure)
 }
 
+// RequireForwardingRecording, if true, allows agent forwarding and
+// local and remote port forwarding only while the forwarded channels
+// are recorded by one of Recorders. Forwarding is rejected if no
+// recording can be started, and forwarded channels are closed if the
+// recording fails. If false, forwarded channels are recorded on a
+// best-effort basis when Recorders is non-empty.
+func (v SSHActionView) RequireForwardingRecording() bool { return v.ж.RequireForwardingRecording }
+
+// TamperEvidentRecording, if true, specifies that recordings are made
+// in the tamper-evident format of sessionrecording.ChainWriter, with
+// their lines hash-chained and signed with the node's Ed25519 SSH host
+// key. Sessions that can't be recorded in that format are handled as if
+// their recording failed to start.
+func (v SSHActionView) TamperEvidentRecording() bool { return v.ж.TamperEvidentRecording }
+
 // A compilation failure here means this code must be regenerated, with the command at the top of this file.
 var _SSHActionViewNeedsRegeneration = SSHAction(struct {
-	Message                   string
-	Reject                    bool
-	Accept                    bool
-	SessionDuration           time.Duration
-	AllowAgentForwarding      bool
-	HoldAndDelegate           string
-	AllowLocalPortForwarding  bool
-	AllowRemotePortForwarding bool
-	Recorders                 []netip.AddrPort
-	OnRecordingFailure        *SSHRecorderFailureAction
+	Message                    string
+	Reject                     bool
+	Accept                     bool
+	SessionDuration            time.Duration
+	AllowAgentForwarding       bool
+	HoldAndDelegate            string
+	AllowLocalPortForwarding   bool
+	AllowRemotePortForwarding  bool
+	Recorders                  []netip.AddrPort
+	OnRecordingFailure         *SSHRecorderFailureAction
+	RequireForwardingRecording bool
+	TamperEvidentRecording     bool
 }{})
 
 // View returns a read-only view of SSHPrincipal.
@@ -63,8 +63,13 @@ func ForwardAgentConnections(l net.Listener, s Session) {
 			if err != nil {
 				return
 			}
-			defer channel.Close()
 			go gossh.DiscardRequests(reqs)
+			if ctx, ok := s.Context().(Context); ok {
+				if srv, ok := ctx.Value(ContextKeyServer).(*Server); ok && srv.ForwardedChannelCallback != nil {
+					channel = srv.ForwardedChannelCallback(ctx, agentChannelType, "", "", channel)
+				}
+			}
+			defer channel.Close()
 			var wg sync.WaitGroup
 			wg.Add(2)
 			go func() {
@@ -65,6 +65,13 @@
 // ReversePortForwardingCallback is a hook for allowing reverse port forwarding
 type ReversePortForwardingCallback func(ctx Context, bindHost string, bindPort uint32) bool
 
+// ForwardedChannelCallback is a hook for observing the channels of local and
+// reverse port forwarding and agent forwarding. It's called with the type of
+// each channel once it's open, the destination and origin addresses of port
+// forwards, and the channel, and returns the channel to forward the
+// connection over, which may wrap ch.
+type ForwardedChannelCallback func(ctx Context, channelType, dest, origin string, ch gossh.Channel) gossh.Channel
+
 // ServerConfigCallback is a hook for creating custom default server configs
 type ServerConfigCallback func(ctx Context) *gossh.ServerConfig
 
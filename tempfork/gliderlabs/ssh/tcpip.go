@@ -52,6 +52,10 @@ func DirectTCPIPHandler(srv *Server, conn *gossh.ServerConn, newChan gossh.NewCh
 		return
 	}
 	go gossh.DiscardRequests(reqs)
+	if srv.ForwardedChannelCallback != nil {
+		origin := net.JoinHostPort(d.OriginAddr, strconv.FormatInt(int64(d.OriginPort), 10))
+		ch = srv.ForwardedChannelCallback(ctx, "direct-tcpip", dest, origin, ch)
+	}
 
 	go func() {
 		defer ch.Close()
@@ -155,6 +159,10 @@ func (h *ForwardedTCPHandler) HandleSSHRequest(ctx Context, srv *Server, req *go
 						return
 					}
 					go gossh.DiscardRequests(reqs)
+					if srv.ForwardedChannelCallback != nil {
+						dest := net.JoinHostPort(reqPayload.BindAddr, strconv.Itoa(destPort))
+						ch = srv.ForwardedChannelCallback(ctx, forwardedTCPChannelType, dest, c.RemoteAddr().String(), ch)
+					}
 					go func() {
 						defer ch.Close()
 						defer c.Close()
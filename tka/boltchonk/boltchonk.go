@@ -0,0 +1,489 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build !ts_omit_tailnetlock && !plan9 && !js && !wasip1
+
+// Package boltchonk implements storage of Tailnet Lock state in a single
+// bbolt database file.
+//
+// Unlike [tka.FS], which stores each AUM in its own file, a [Chonk] keeps
+// all state in one file, and actually deletes AUMs when they're purged by
+// compaction.
+package boltchonk
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+	"tailscale.com/tka"
+)
+
+// Buckets of the database.
+var (
+	// aumsBucket maps an AUM hash to the serialized AUM.
+	aumsBucket = []byte("aums")
+	// commitTimesBucket maps an AUM hash to the time it was committed,
+	// as big-endian unix nanoseconds.
+	commitTimesBucket = []byte("commit-times")
+	// childrenBucket holds a key of the parent hash followed by the
+	// child hash for each stored AUM that has a parent. Values are empty.
+	childrenBucket = []byte("children")
+	// metaBucket holds the keys below.
+	metaBucket = []byte("meta")
+)
+
+var (
+	formatKey             = []byte("format")
+	lastActiveAncestorKey = []byte("last-active-ancestor")
+)
+
+// formatVersion is the version of the database layout, stored in the meta
+// bucket.
+const formatVersion = 1
+
+// Chonk implements storage of TKA state in a bbolt database.
+//
+// Chonk implements the [tka.CompactableChonk] interface.
+//
+// Chonk is thread-safe.
+type Chonk struct {
+	path string
+
+	mu sync.RWMutex
+	db *bolt.DB // nil after RemoveAll or Close
+}
+
+var _ tka.CompactableChonk = (*Chonk)(nil)
+
+// Open returns a Chonk which stores TKA state in the database file at path,
+// creating it if it doesn't exist.
+//
+// The database is locked while open, so the Chonk must be closed with
+// [Chonk.Close] before the file can be opened again.
+func Open(path string) (*Chonk, error) {
+	db, err := openDB(path)
+	if err != nil {
+		return nil, err
+	}
+	return &Chonk{path: path, db: db}, nil
+}
+
+func openDB(path string) (*bolt.DB, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("opening %s: %w", path, err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{aumsBucket, commitTimesBucket, childrenBucket, metaBucket} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		meta := tx.Bucket(metaBucket)
+		if v := meta.Get(formatKey); v != nil {
+			if len(v) != 8 || binary.BigEndian.Uint64(v) != formatVersion {
+				return fmt.Errorf("unsupported database format %x", v)
+			}
+			return nil
+		}
+		return meta.Put(formatKey, binary.BigEndian.AppendUint64(nil, formatVersion))
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("initializing %s: %w", path, err)
+	}
+	return db, nil
+}
+
+// Close closes the database.
+func (c *Chonk) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.db == nil {
+		return nil
+	}
+	err := c.db.Close()
+	c.db = nil
+	return err
+}
+
+// view runs f in a read-only transaction. If the database has been removed,
+// f isn't called and errRemoved is returned.
+func (c *Chonk) view(f func(*bolt.Tx) error) error {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if c.db == nil {
+		return errRemoved
+	}
+	return c.db.View(f)
+}
+
+// errRemoved is returned by view when the database has been removed by
+// RemoveAll. Readers treat it as the database being empty.
+var errRemoved = errors.New("chonk removed")
+
+// update runs f in a read-write transaction, recreating the database if it
+// was removed by RemoveAll.
+func (c *Chonk) update(f func(*bolt.Tx) error) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.db == nil {
+		db, err := openDB(c.path)
+		if err != nil {
+			return err
+		}
+		c.db = db
+	}
+	return c.db.Update(f)
+}
+
+// childKey returns the key of the children bucket recording that child's
+// parent is parent.
+func childKey(parent, child tka.AUMHash) []byte {
+	return append(bytes.Clone(parent[:]), child[:]...)
+}
+
+func getAUM(tx *bolt.Tx, h tka.AUMHash) (tka.AUM, error) {
+	v := tx.Bucket(aumsBucket).Get(h[:])
+	if v == nil {
+		return tka.AUM{}, os.ErrNotExist
+	}
+	var aum tka.AUM
+	if err := aum.Unserialize(v); err != nil {
+		return tka.AUM{}, fmt.Errorf("decoding %v: %w", h, err)
+	}
+	if aum.Hash() != h {
+		return tka.AUM{}, fmt.Errorf("stored AUM %v does not match its hash %v", aum.Hash(), h)
+	}
+	return aum, nil
+}
+
+// AUM returns the AUM with the specified digest.
+//
+// If the AUM does not exist, then os.ErrNotExist is returned.
+func (c *Chonk) AUM(hash tka.AUMHash) (out tka.AUM, err error) {
+	err = c.view(func(tx *bolt.Tx) error {
+		out, err = getAUM(tx, hash)
+		return err
+	})
+	if err == errRemoved {
+		return tka.AUM{}, os.ErrNotExist
+	}
+	return out, err
+}
+
+// ChildAUMs returns all AUMs with the specified previous AUM hash.
+func (c *Chonk) ChildAUMs(prevAUMHash tka.AUMHash) ([]tka.AUM, error) {
+	var out []tka.AUM
+	err := c.view(func(tx *bolt.Tx) error {
+		cur := tx.Bucket(childrenBucket).Cursor()
+		for k, _ := cur.Seek(prevAUMHash[:]); k != nil && bytes.HasPrefix(k, prevAUMHash[:]); k, _ = cur.Next() {
+			child := tka.AUMHash(k[len(prevAUMHash):])
+			aum, err := getAUM(tx, child)
+			if err != nil {
+				return err
+			}
+			out = append(out, aum)
+		}
+		return nil
+	})
+	if err == errRemoved {
+		return nil, nil
+	}
+	return out, err
+}
+
+// Heads returns AUMs for which there are no children. In other
+// words, the latest AUM in all possible chains (the 'leaves').
+func (c *Chonk) Heads() ([]tka.AUM, error) {
+	var out []tka.AUM
+	err := c.view(func(tx *bolt.Tx) error {
+		children := tx.Bucket(childrenBucket).Cursor()
+		return tx.Bucket(aumsBucket).ForEach(func(k, _ []byte) error {
+			if ck, _ := children.Seek(k); ck != nil && bytes.HasPrefix(ck, k) {
+				return nil
+			}
+			aum, err := getAUM(tx, tka.AUMHash(k))
+			if err != nil {
+				return err
+			}
+			out = append(out, aum)
+			return nil
+		})
+	})
+	if err == errRemoved {
+		return nil, nil
+	}
+	return out, err
+}
+
+// AllAUMs returns the hashes of all AUMs stored in the chonk.
+func (c *Chonk) AllAUMs() ([]tka.AUMHash, error) {
+	var out []tka.AUMHash
+	err := c.view(func(tx *bolt.Tx) error {
+		return tx.Bucket(aumsBucket).ForEach(func(k, _ []byte) error {
+			out = append(out, tka.AUMHash(k))
+			return nil
+		})
+	})
+	if err == errRemoved {
+		return nil, nil
+	}
+	return out, err
+}
+
+// CommitTime returns the time at which the AUM was committed.
+//
+// If the AUM does not exist, then os.ErrNotExist is returned.
+func (c *Chonk) CommitTime(h tka.AUMHash) (time.Time, error) {
+	var out time.Time
+	err := c.view(func(tx *bolt.Tx) error {
+		v := tx.Bucket(commitTimesBucket).Get(h[:])
+		if v == nil {
+			return os.ErrNotExist
+		}
+		if len(v) != 8 {
+			return fmt.Errorf("commit time of %v has wrong length %d", h, len(v))
+		}
+		out = time.Unix(0, int64(binary.BigEndian.Uint64(v)))
+		return nil
+	})
+	if err == errRemoved {
+		return time.Time{}, os.ErrNotExist
+	}
+	return out, err
+}
+
+// SetLastActiveAncestor is called to record the oldest-known AUM
+// that contributed to the current state.
+func (c *Chonk) SetLastActiveAncestor(hash tka.AUMHash) error {
+	return c.update(func(tx *bolt.Tx) error {
+		return tx.Bucket(metaBucket).Put(lastActiveAncestorKey, hash[:])
+	})
+}
+
+// LastActiveAncestor returns the oldest-known AUM that was (in a
+// previous run) an ancestor of the current state.
+//
+// Nil is returned if no last-active ancestor is set.
+func (c *Chonk) LastActiveAncestor() (*tka.AUMHash, error) {
+	var out *tka.AUMHash
+	err := c.view(func(tx *bolt.Tx) error {
+		v := tx.Bucket(metaBucket).Get(lastActiveAncestorKey)
+		if v == nil {
+			return nil
+		}
+		var h tka.AUMHash
+		if len(v) != len(h) {
+			return fmt.Errorf("stored hash is of wrong length: %d != %d", len(v), len(h))
+		}
+		copy(h[:], v)
+		out = &h
+		return nil
+	})
+	if err == errRemoved {
+		return nil, nil
+	}
+	return out, err
+}
+
+// CommitVerifiedAUMs durably stores the provided AUMs.
+// Callers MUST ONLY provide AUMs which are verified (specifically,
+// a call to aumVerify must return a nil error), as the
+// implementation assumes that only verified AUMs are stored.
+func (c *Chonk) CommitVerifiedAUMs(updates []tka.AUM) error {
+	now := time.Now()
+	return c.update(func(tx *bolt.Tx) error {
+		for i, aum := range updates {
+			if err := putAUM(tx, aum, now); err != nil {
+				return fmt.Errorf("committing update[%d] (%v): %w", i, aum.Hash(), err)
+			}
+		}
+		return nil
+	})
+}
+
+// putAUM stores aum, recording it as committed at the given time unless
+// it's already stored.
+func putAUM(tx *bolt.Tx, aum tka.AUM, committed time.Time) error {
+	h := aum.Hash()
+	times := tx.Bucket(commitTimesBucket)
+	if times.Get(h[:]) == nil {
+		if err := times.Put(h[:], binary.BigEndian.AppendUint64(nil, uint64(committed.UnixNano()))); err != nil {
+			return err
+		}
+	}
+	if err := tx.Bucket(aumsBucket).Put(h[:], aum.Serialize()); err != nil {
+		return err
+	}
+	if parent, ok := aum.Parent(); ok {
+		return tx.Bucket(childrenBucket).Put(childKey(parent, h), nil)
+	}
+	return nil
+}
+
+// PurgeAUMs deletes the specified AUMs from storage.
+//
+// Children of purged AUMs can still be found with ChildAUMs.
+func (c *Chonk) PurgeAUMs(hashes []tka.AUMHash) error {
+	return c.update(func(tx *bolt.Tx) error {
+		for i, h := range hashes {
+			aum, err := getAUM(tx, h)
+			if errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+			if err != nil {
+				return fmt.Errorf("reading %d (%v): %w", i, h, err)
+			}
+			if parent, ok := aum.Parent(); ok {
+				if err := tx.Bucket(childrenBucket).Delete(childKey(parent, h)); err != nil {
+					return err
+				}
+			}
+			if err := tx.Bucket(aumsBucket).Delete(h[:]); err != nil {
+				return err
+			}
+			if err := tx.Bucket(commitTimesBucket).Delete(h[:]); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// RemoveAll permanently and completely clears the TKA state, deleting the
+// database file. The database is recreated if the Chonk is written to
+// again.
+func (c *Chonk) RemoveAll() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.db != nil {
+		if err := c.db.Close(); err != nil {
+			return err
+		}
+		c.db = nil
+	}
+	if err := os.Remove(c.path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// MigrateDir moves the TKA state stored by [tka.ChonkDir] in dir to a
+// database at path, and returns a Chonk for the database.
+//
+// The migration is atomic: the database is written to a temporary file,
+// which is renamed to path once complete, and only then is dir deleted.
+// If the migration is interrupted, dir is left intact and the migration
+// can be retried; if the database already exists at path, only the
+// deletion of dir is completed.
+func MigrateDir(dir, path string) (*Chonk, error) {
+	if _, err := os.Stat(path); err == nil {
+		c, err := Open(path)
+		if err != nil {
+			return nil, err
+		}
+		if err := os.RemoveAll(dir); err != nil {
+			c.Close()
+			return nil, fmt.Errorf("removing migrated directory: %w", err)
+		}
+		return c, nil
+	}
+
+	src, err := tka.ChonkDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	tmp := path + ".tmp"
+	if err := os.Remove(tmp); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if err := copyDir(src, tmp); err != nil {
+		os.Remove(tmp)
+		return nil, err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return nil, err
+	}
+	c, err := Open(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.RemoveAll(dir); err != nil {
+		c.Close()
+		return nil, fmt.Errorf("removing migrated directory: %w", err)
+	}
+	return c, nil
+}
+
+// OpenDir returns a Chonk for the TKA state that [tka.ChonkDir] would
+// keep in dir, if it's kept in a database beside dir instead, at
+// dir+".db". If the database exists, any state left in dir is migrated
+// into it.
+//
+// If migrate is set, the state is moved to the database if it isn't
+// there yet: state in dir is migrated, and if there's none, the database
+// is created if create is set.
+//
+// If the state isn't, and isn't to be, kept in a database, OpenDir
+// returns nil, nil.
+func OpenDir(dir string, create, migrate bool) (*Chonk, error) {
+	path := dir + ".db"
+	_, dbErr := os.Stat(path)
+	_, dirErr := os.Stat(dir)
+	switch {
+	case dirErr == nil && (dbErr == nil || migrate):
+		// Migrate the directory, or finish an interrupted migration.
+		return MigrateDir(dir, path)
+	case dbErr == nil, dirErr != nil && create && migrate:
+		return Open(path)
+	}
+	return nil, nil
+}
+
+// copyDir writes the contents of src to a new database at path.
+func copyDir(src *tka.FS, path string) error {
+	hashes, err := src.AllAUMs()
+	if err != nil {
+		return fmt.Errorf("listing AUMs: %w", err)
+	}
+	ancestor, err := src.LastActiveAncestor()
+	if err != nil {
+		return fmt.Errorf("reading last active ancestor: %w", err)
+	}
+
+	db, err := openDB(path)
+	if err != nil {
+		return err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, h := range hashes {
+			aum, err := src.AUM(h)
+			if err != nil {
+				return fmt.Errorf("reading %v: %w", h, err)
+			}
+			committed, err := src.CommitTime(h)
+			if err != nil {
+				return fmt.Errorf("reading commit time of %v: %w", h, err)
+			}
+			if err := putAUM(tx, aum, committed); err != nil {
+				return fmt.Errorf("writing %v: %w", h, err)
+			}
+		}
+		if ancestor != nil {
+			return tx.Bucket(metaBucket).Put(lastActiveAncestorKey, ancestor[:])
+		}
+		return nil
+	})
+	if cerr := db.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
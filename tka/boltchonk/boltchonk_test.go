@@ -0,0 +1,149 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build !ts_omit_tailnetlock && !plan9 && !js && !wasip1
+
+package boltchonk
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"tailscale.com/tka"
+	"tailscale.com/tstest/chonktest"
+	"tailscale.com/util/must"
+)
+
+func newTestChonk(t *testing.T) *Chonk {
+	c := must.Get(Open(filepath.Join(t.TempDir(), "tka.db")))
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+func TestImplementsChonk(t *testing.T) {
+	chonktest.RunChonkTests(t, func(t *testing.T) tka.Chonk {
+		return newTestChonk(t)
+	})
+}
+
+func TestImplementsCompactableChonk(t *testing.T) {
+	chonktest.RunCompactableChonkTests(t, func(t *testing.T) tka.CompactableChonk {
+		return newTestChonk(t)
+	})
+}
+
+func TestPersistence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tka.db")
+	genesis := tka.AUM{MessageKind: tka.AUMRemoveKey, KeyID: []byte{1, 2}}
+	gh := genesis.Hash()
+	child := tka.AUM{MessageKind: tka.AUMNoOp, PrevAUMHash: gh[:]}
+
+	c := must.Get(Open(path))
+	if err := c.CommitVerifiedAUMs([]tka.AUM{genesis, child}); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.SetLastActiveAncestor(gh); err != nil {
+		t.Fatal(err)
+	}
+	committed := must.Get(c.CommitTime(gh))
+	if err := c.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	c = must.Get(Open(path))
+	defer c.Close()
+	heads := must.Get(c.Heads())
+	if len(heads) != 1 || heads[0].Hash() != child.Hash() {
+		t.Errorf("Heads() = %v, want [%v]", heads, child.Hash())
+	}
+	if got := must.Get(c.LastActiveAncestor()); got == nil || *got != gh {
+		t.Errorf("LastActiveAncestor() = %v, want %v", got, gh)
+	}
+	if got := must.Get(c.CommitTime(gh)); !got.Equal(committed) {
+		t.Errorf("CommitTime() = %v, want %v", got, committed)
+	}
+
+	// Committing an AUM again keeps its original commit time.
+	if err := c.CommitVerifiedAUMs([]tka.AUM{genesis}); err != nil {
+		t.Fatal(err)
+	}
+	if got := must.Get(c.CommitTime(gh)); !got.Equal(committed) {
+		t.Errorf("CommitTime() after recommit = %v, want %v", got, committed)
+	}
+
+	if err := c.RemoveAll(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("database still exists after RemoveAll: %v", err)
+	}
+}
+
+func TestMigrateDir(t *testing.T) {
+	tmp := t.TempDir()
+	dir := filepath.Join(tmp, "profile")
+	path := filepath.Join(tmp, "profile.db")
+
+	fs := must.Get(tka.ChonkDir(dir))
+	genesis := tka.AUM{MessageKind: tka.AUMRemoveKey, KeyID: []byte{1, 2}}
+	gh := genesis.Hash()
+	child := tka.AUM{MessageKind: tka.AUMNoOp, PrevAUMHash: gh[:]}
+	ch := child.Hash()
+	purged := tka.AUM{MessageKind: tka.AUMRemoveKey, KeyID: []byte{3, 4}, PrevAUMHash: gh[:]}
+	if err := fs.CommitVerifiedAUMs([]tka.AUM{genesis, child, purged}); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.PurgeAUMs([]tka.AUMHash{purged.Hash()}); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.SetLastActiveAncestor(gh); err != nil {
+		t.Fatal(err)
+	}
+	wantTime := must.Get(fs.CommitTime(ch))
+
+	// A temporary file left by an interrupted migration is replaced.
+	if err := os.WriteFile(path+".tmp", []byte("junk"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	c := must.Get(MigrateDir(dir, path))
+	if _, err := os.Stat(dir); !os.IsNotExist(err) {
+		t.Errorf("directory still exists after migration: %v", err)
+	}
+	if _, err := os.Stat(path + ".tmp"); !os.IsNotExist(err) {
+		t.Errorf("temporary file still exists after migration: %v", err)
+	}
+	all := must.Get(c.AllAUMs())
+	if len(all) != 2 {
+		t.Errorf("AllAUMs() = %v, want 2 AUMs", all)
+	}
+	if _, err := c.AUM(purged.Hash()); !os.IsNotExist(err) {
+		t.Errorf("purged AUM was migrated: err = %v", err)
+	}
+	if got := must.Get(c.LastActiveAncestor()); got == nil || *got != gh {
+		t.Errorf("LastActiveAncestor() = %v, want %v", got, gh)
+	}
+	// The FS only stores commit times in seconds.
+	if got := must.Get(c.CommitTime(ch)); got.Sub(wantTime).Abs() > time.Second {
+		t.Errorf("CommitTime() = %v, want %v", got, wantTime)
+	}
+	if err := c.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// If the migration was interrupted after the database was moved into
+	// place, the directory is removed and the database kept.
+	if err := os.MkdirAll(filepath.Join(dir, "xx"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	c = must.Get(MigrateDir(dir, path))
+	defer c.Close()
+	if _, err := os.Stat(dir); !os.IsNotExist(err) {
+		t.Errorf("directory still exists after migration: %v", err)
+	}
+	if got := must.Get(c.AllAUMs()); len(got) != 2 {
+		t.Errorf("AllAUMs() after second migration = %v, want 2 AUMs", got)
+	}
+}
@@ -18,6 +18,28 @@ type Signer interface {
 	SignAUM(tkatype.AUMSigHash) ([]tkatype.Signature, error)
 }
 
+// SignUpdates signs updates, which must be a chain of AUMs such as those
+// returned by UpdateBuilder.Finalize, appending signer's signatures to each.
+//
+// Since an AUM's hash covers its signatures, signing an AUM changes the
+// hash its child refers to; SignUpdates updates the parent hash of each AUM
+// after the first to match. This allows updates to be built by one party
+// without a signer (see Authority.NewUpdater) and signed by another.
+func SignUpdates(updates []AUM, signer Signer) error {
+	for i := range updates {
+		if i > 0 {
+			h := updates[i-1].Hash()
+			updates[i].PrevAUMHash = h[:]
+		}
+		sigs, err := signer.SignAUM(updates[i].SigHash())
+		if err != nil {
+			return fmt.Errorf("signing failed: %v", err)
+		}
+		updates[i].Signatures = append(updates[i].Signatures, sigs...)
+	}
+	return nil
+}
+
 // UpdateBuilder implements a builder for changes to the tailnet
 // key authority.
 //
@@ -0,0 +1,141 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build !ts_omit_tailnetlock
+
+package tka
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"tailscale.com/types/tkatype"
+)
+
+// ChainExportVersion is the current version of the ChainExport format.
+const ChainExportVersion = 1
+
+// ChainExport is a portable copy of the active chain of AUMs of a tailnet
+// key authority, for auditing its history without access to a node. It's
+// normally stored as JSON.
+type ChainExport struct {
+	// Version is the version of the format, ChainExportVersion.
+	Version int
+
+	// Head is the hash of the last AUM in the chain.
+	Head AUMHash
+
+	// AUMs are the serialized AUMs of the chain, oldest first. The first
+	// is the genesis AUM, or the oldest checkpoint retained by the node
+	// if older AUMs were compacted away.
+	AUMs []tkatype.MarshaledAUM
+}
+
+// ExportChain returns an export of the chain of AUMs in storage that ends
+// at head, which is normally the head of an Authority.
+func ExportChain(storage Chonk, head AUMHash) (*ChainExport, error) {
+	var chain []tkatype.MarshaledAUM
+	cursor := head
+	for i := 0; ; i++ {
+		if i >= maxScanIterations {
+			return nil, fmt.Errorf("iteration limit exceeded (%d)", maxScanIterations)
+		}
+		aum, err := storage.AUM(cursor)
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) && len(chain) > 0 {
+				// Older AUMs were compacted away.
+				break
+			}
+			return nil, fmt.Errorf("reading AUM %v: %w", cursor, err)
+		}
+		chain = append(chain, aum.Serialize())
+		parent, ok := aum.Parent()
+		if !ok {
+			break
+		}
+		cursor = parent
+	}
+	for i, j := 0, len(chain)-1; i < j; i, j = i+1, j-1 {
+		chain[i], chain[j] = chain[j], chain[i]
+	}
+	return &ChainExport{
+		Version: ChainExportVersion,
+		Head:    head,
+		AUMs:    chain,
+	}, nil
+}
+
+// AuditStep is the result of verifying and applying one AUM of a chain.
+type AuditStep struct {
+	Hash  AUMHash
+	AUM   AUM
+	State State // the authority's state after applying AUM
+}
+
+// Verify replays the exported chain, checking that each AUM is well-formed,
+// follows the previous one, and is signed by keys trusted at that point,
+// as a node does when receiving AUMs. It returns the state of the authority
+// after each AUM.
+//
+// The first AUM must be a checkpoint. Its signatures are checked against
+// the keys in its own state, so if it isn't the genesis AUM, the audit only
+// establishes the history from that checkpoint on.
+func (e *ChainExport) Verify() ([]AuditStep, error) {
+	if e.Version != ChainExportVersion {
+		return nil, fmt.Errorf("unsupported export version %d", e.Version)
+	}
+	if len(e.AUMs) == 0 {
+		return nil, errors.New("export contains no AUMs")
+	}
+
+	steps := make([]AuditStep, 0, len(e.AUMs))
+	var state State
+	for i, b := range e.AUMs {
+		var aum AUM
+		if err := aum.Unserialize(b); err != nil {
+			return steps, fmt.Errorf("AUM %d: decoding: %w", i, err)
+		}
+		h := aum.Hash()
+		if i == 0 {
+			if aum.MessageKind != AUMCheckpoint || aum.State == nil {
+				return steps, fmt.Errorf("AUM %d (%v): chain must start with a checkpoint, got %v", i, h, aum.MessageKind)
+			}
+			state = *aum.State
+		}
+		if err := aumVerify(aum, state, i == 0); err != nil {
+			return steps, fmt.Errorf("AUM %d (%v): %w", i, h, err)
+		}
+		next, err := state.applyVerifiedAUM(aum)
+		if err != nil {
+			return steps, fmt.Errorf("AUM %d (%v): applying: %w", i, h, err)
+		}
+		state = next
+		steps = append(steps, AuditStep{Hash: h, AUM: aum, State: state.Clone()})
+	}
+	if last := steps[len(steps)-1].Hash; last != e.Head {
+		return steps, fmt.Errorf("chain ends at %v, but export's head is %v", last, e.Head)
+	}
+
+	// Check that a node loading the chain from storage arrives at the same
+	// head.
+	storage := ChonkMem()
+	aums := make([]AUM, len(steps))
+	for i, s := range steps {
+		aums[i] = s.AUM
+	}
+	if err := storage.CommitVerifiedAUMs(aums); err != nil {
+		return steps, err
+	}
+	if err := storage.SetLastActiveAncestor(steps[0].Hash); err != nil {
+		return steps, err
+	}
+	a, err := Open(storage)
+	if err != nil {
+		return steps, fmt.Errorf("opening chain: %w", err)
+	}
+	if a.Head() != e.Head {
+		return steps, fmt.Errorf("opened chain has head %v, want %v", a.Head(), e.Head)
+	}
+	return steps, nil
+}
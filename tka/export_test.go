@@ -0,0 +1,136 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package tka
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestExportChain(t *testing.T) {
+	pub, priv := testingKey25519(t, 1)
+	key := Key{Kind: Key25519, Public: pub, Votes: 2}
+
+	storage := ChonkMem()
+	a, _, err := Create(storage, State{
+		Keys:               []Key{key},
+		DisablementSecrets: [][]byte{DisablementKDF([]byte{1, 2, 3})},
+	}, signer25519(priv))
+	if err != nil {
+		t.Fatalf("Create() failed: %v", err)
+	}
+
+	pub2, _ := testingKey25519(t, 2)
+	key2 := Key{Kind: Key25519, Public: pub2, Votes: 1}
+	b := a.NewUpdater(signer25519(priv))
+	if err := b.AddKey(key2); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.SetKeyVote(key2.MustID(), 3); err != nil {
+		t.Fatal(err)
+	}
+	updates, err := b.Finalize(storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Inform(storage, updates); err != nil {
+		t.Fatal(err)
+	}
+	b = a.NewUpdater(signer25519(priv))
+	if err := b.RemoveKey(key2.MustID()); err != nil {
+		t.Fatal(err)
+	}
+	updates, err = b.Finalize(storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Inform(storage, updates); err != nil {
+		t.Fatal(err)
+	}
+
+	export, err := ExportChain(storage, a.Head())
+	if err != nil {
+		t.Fatalf("ExportChain() failed: %v", err)
+	}
+	if len(export.AUMs) != 4 {
+		t.Fatalf("exported %d AUMs, want 4", len(export.AUMs))
+	}
+
+	// Round-trip through JSON, as exports are stored.
+	j, err := json.Marshal(export)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got ChainExport
+	if err := json.Unmarshal(j, &got); err != nil {
+		t.Fatal(err)
+	}
+
+	steps, err := got.Verify()
+	if err != nil {
+		t.Fatalf("Verify() failed: %v", err)
+	}
+	wantKeys := []int{1, 2, 2, 1}
+	wantKinds := []AUMKind{AUMCheckpoint, AUMAddKey, AUMUpdateKey, AUMRemoveKey}
+	for i, s := range steps {
+		if s.AUM.MessageKind != wantKinds[i] {
+			t.Errorf("step %d kind = %v, want %v", i, s.AUM.MessageKind, wantKinds[i])
+		}
+		if len(s.State.Keys) != wantKeys[i] {
+			t.Errorf("step %d has %d keys, want %d", i, len(s.State.Keys), wantKeys[i])
+		}
+	}
+	if k, err := steps[2].State.GetKey(key2.MustID()); err != nil || k.Votes != 3 {
+		t.Errorf("step 2 key2 = %+v, %v; want 3 votes", k, err)
+	}
+
+	t.Run("tampered-signature", func(t *testing.T) {
+		tampered := got
+		tampered.AUMs = append(tampered.AUMs[:0:0], got.AUMs...)
+		var aum AUM
+		if err := aum.Unserialize(tampered.AUMs[2]); err != nil {
+			t.Fatal(err)
+		}
+		aum.Signatures[0].Signature = bytes.Clone(aum.Signatures[0].Signature)
+		aum.Signatures[0].Signature[0] ^= 1
+		tampered.AUMs[2] = aum.Serialize()
+
+		steps, err := tampered.Verify()
+		if err == nil || !strings.Contains(err.Error(), "AUM 2") {
+			t.Fatalf("Verify() error = %v, want failure at AUM 2", err)
+		}
+		if len(steps) != 2 {
+			t.Errorf("got %d verified steps, want 2", len(steps))
+		}
+	})
+
+	t.Run("untrusted-signer", func(t *testing.T) {
+		_, priv3 := testingKey25519(t, 3)
+		var aum AUM
+		if err := aum.Unserialize(got.AUMs[3]); err != nil {
+			t.Fatal(err)
+		}
+		aum.Signatures = nil
+		sigs, _ := signer25519(priv3).SignAUM(aum.SigHash())
+		aum.Signatures = sigs
+
+		bad := got
+		bad.AUMs = append(bad.AUMs[:3:3], aum.Serialize())
+		h := aum.Hash()
+		bad.Head = h
+		if _, err := bad.Verify(); err == nil || !strings.Contains(err.Error(), "AUM 3") {
+			t.Fatalf("Verify() error = %v, want failure at AUM 3", err)
+		}
+	})
+
+	t.Run("wrong-head", func(t *testing.T) {
+		bad := got
+		bad.Head = AUMHash{}
+		if _, err := bad.Verify(); err == nil || !strings.Contains(err.Error(), "head") {
+			t.Fatalf("Verify() error = %v, want head mismatch", err)
+		}
+	})
+}
@@ -0,0 +1,192 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build !ts_omit_tailnetlock
+
+package tka
+
+import (
+	"bufio"
+	"crypto/ed25519"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	"tailscale.com/types/key"
+	"tailscale.com/types/tkatype"
+)
+
+// The external signer protocol lets a process other than tailscaled or the
+// tailscale CLI hold a tailnet lock key, much like ssh-agent holds SSH keys.
+// This allows tailnet lock keys to be kept in hardware, such as an HSM or a
+// security key.
+//
+// The signer reads requests from its input and writes one response to its
+// output for each, in order. Both are newline-delimited JSON objects:
+// ExternalSignerRequest and ExternalSignerResponse respectively.
+//
+// The operations are:
+//
+//   - "public": the response's Public is the signer's key.
+//   - "sign": the response's Signature is the ed25519 signature of Hash
+//     by the signer's key. Kind describes what's being signed ("aum" or
+//     "node-key"), which the signer may use to ask the user for
+//     confirmation or to refuse.
+//
+// A signer that can't carry out a request sets the response's Error.
+
+// ExternalSignerRequest is a request to an external signer.
+type ExternalSignerRequest struct {
+	Op   string // "public" or "sign"
+	Kind string `json:",omitempty"` // for "sign": "aum" or "node-key"
+	Hash []byte `json:",omitempty"` // for "sign": the digest to sign
+}
+
+// ExternalSignerResponse is an external signer's response to an
+// ExternalSignerRequest.
+type ExternalSignerResponse struct {
+	Public    key.NLPublic `json:",omitzero"`
+	Signature []byte       `json:",omitempty"`
+	Error     string       `json:",omitempty"`
+}
+
+// Kinds of digest signed by external signers.
+const (
+	ExternalSignAUM     = "aum"
+	ExternalSignNodeKey = "node-key"
+)
+
+// ExternalSigner is a Signer which signs using an external signer process,
+// which it talks to using the external signer protocol.
+//
+// It's safe for concurrent use.
+type ExternalSigner struct {
+	pub key.NLPublic
+
+	mu  sync.Mutex
+	enc *json.Encoder
+	dec *json.Decoder
+}
+
+// NewExternalSigner returns an ExternalSigner which sends requests to w and
+// reads the responses from r, which are normally connected to the input and
+// output of a signer process. It asks the signer for its key before
+// returning.
+func NewExternalSigner(r io.Reader, w io.Writer) (*ExternalSigner, error) {
+	s := &ExternalSigner{
+		enc: json.NewEncoder(w),
+		dec: json.NewDecoder(bufio.NewReader(r)),
+	}
+	resp, err := s.roundTrip(ExternalSignerRequest{Op: "public"})
+	if err != nil {
+		return nil, err
+	}
+	if resp.Public.IsZero() {
+		return nil, errors.New("external signer returned no public key")
+	}
+	s.pub = resp.Public
+	return s, nil
+}
+
+func (s *ExternalSigner) roundTrip(req ExternalSignerRequest) (*ExternalSignerResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.enc.Encode(req); err != nil {
+		return nil, fmt.Errorf("writing to external signer: %w", err)
+	}
+	var resp ExternalSignerResponse
+	if err := s.dec.Decode(&resp); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, fmt.Errorf("reading from external signer: %w", err)
+	}
+	if resp.Error != "" {
+		return nil, fmt.Errorf("external signer: %s", resp.Error)
+	}
+	return &resp, nil
+}
+
+// sign asks the signer to sign hash and checks the signature it returns.
+func (s *ExternalSigner) sign(kind string, hash []byte) ([]byte, error) {
+	resp, err := s.roundTrip(ExternalSignerRequest{Op: "sign", Kind: kind, Hash: hash})
+	if err != nil {
+		return nil, err
+	}
+	if !ed25519.Verify(s.pub.Verifier(), hash, resp.Signature) {
+		return nil, errors.New("external signer returned an invalid signature")
+	}
+	return resp.Signature, nil
+}
+
+// Public returns the signer's public key.
+func (s *ExternalSigner) Public() key.NLPublic { return s.pub }
+
+// KeyID returns the ID of the signer's key.
+func (s *ExternalSigner) KeyID() tkatype.KeyID { return s.pub.KeyID() }
+
+// SignAUM implements Signer.
+func (s *ExternalSigner) SignAUM(sigHash tkatype.AUMSigHash) ([]tkatype.Signature, error) {
+	sig, err := s.sign(ExternalSignAUM, sigHash[:])
+	if err != nil {
+		return nil, err
+	}
+	return []tkatype.Signature{{KeyID: s.KeyID(), Signature: sig}}, nil
+}
+
+// SignNKS implements NodeKeySigner.
+func (s *ExternalSigner) SignNKS(sigHash tkatype.NKSSigHash) ([]byte, error) {
+	return s.sign(ExternalSignNodeKey, sigHash[:])
+}
+
+// ServeExternalSigner is a software implementation of an external signer,
+// signing with priv. It serves requests read from r, writing responses to
+// w, until r is exhausted.
+func ServeExternalSigner(r io.Reader, w io.Writer, priv key.NLPrivate) error {
+	dec := json.NewDecoder(bufio.NewReader(r))
+	enc := json.NewEncoder(w)
+	for {
+		var req ExternalSignerRequest
+		if err := dec.Decode(&req); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		var resp ExternalSignerResponse
+		switch req.Op {
+		case "public":
+			resp.Public = priv.Public()
+		case "sign":
+			if len(req.Hash) != 32 {
+				resp.Error = fmt.Sprintf("invalid hash length %d", len(req.Hash))
+				break
+			}
+			switch req.Kind {
+			case ExternalSignAUM:
+				sigs, err := priv.SignAUM(tkatype.AUMSigHash(req.Hash))
+				if err != nil {
+					resp.Error = err.Error()
+					break
+				}
+				resp.Signature = sigs[0].Signature
+			case ExternalSignNodeKey:
+				sig, err := priv.SignNKS(tkatype.NKSSigHash(req.Hash))
+				if err != nil {
+					resp.Error = err.Error()
+					break
+				}
+				resp.Signature = sig
+			default:
+				resp.Error = fmt.Sprintf("unknown kind %q", req.Kind)
+			}
+		default:
+			resp.Error = fmt.Sprintf("unknown op %q", req.Op)
+		}
+		if err := enc.Encode(resp); err != nil {
+			return err
+		}
+	}
+}
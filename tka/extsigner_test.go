@@ -0,0 +1,166 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package tka
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io"
+	"strings"
+	"testing"
+
+	"tailscale.com/types/key"
+)
+
+// startTestSigner returns an ExternalSigner connected to a software signer
+// for priv running in a goroutine.
+func startTestSigner(t *testing.T, priv key.NLPrivate) *ExternalSigner {
+	t.Helper()
+	reqR, reqW := io.Pipe()
+	respR, respW := io.Pipe()
+	done := make(chan error, 1)
+	go func() {
+		err := ServeExternalSigner(reqR, respW, priv)
+		respW.Close()
+		done <- err
+	}()
+	t.Cleanup(func() {
+		reqW.Close()
+		if err := <-done; err != nil {
+			t.Errorf("ServeExternalSigner: %v", err)
+		}
+	})
+
+	s, err := NewExternalSigner(respR, reqW)
+	if err != nil {
+		t.Fatalf("NewExternalSigner: %v", err)
+	}
+	return s
+}
+
+func TestExternalSignerModify(t *testing.T) {
+	priv := key.NewNLPrivate()
+	signer := startTestSigner(t, priv)
+	if !signer.Public().Equal(priv.Public()) {
+		t.Fatalf("Public() = %v, want %v", signer.Public(), priv.Public())
+	}
+
+	k := Key{Kind: Key25519, Public: priv.Public().Verifier(), Votes: 2}
+	storage := ChonkMem()
+	a, _, err := Create(storage, State{
+		Keys:               []Key{k},
+		DisablementSecrets: [][]byte{DisablementKDF([]byte{1, 2, 3})},
+	}, signer)
+	if err != nil {
+		t.Fatalf("Create() failed: %v", err)
+	}
+
+	// Build updates without a signer, as tailscaled does for an external
+	// signer, then sign them afterwards.
+	b := a.NewUpdater(nil)
+	var added []Key
+	for i := range 3 {
+		pub, _ := testingKey25519(t, int64(2+i))
+		k := Key{Kind: Key25519, Public: pub, Votes: 1}
+		if err := b.AddKey(k); err != nil {
+			t.Fatalf("AddKey(%v) failed: %v", k, err)
+		}
+		added = append(added, k)
+	}
+	updates, err := b.Finalize(storage)
+	if err != nil {
+		t.Fatalf("Finalize() failed: %v", err)
+	}
+	if err := SignUpdates(updates, signer); err != nil {
+		t.Fatalf("SignUpdates() failed: %v", err)
+	}
+	if err := a.Inform(storage, updates); err != nil {
+		t.Fatalf("could not apply signed updates: %v", err)
+	}
+	for _, k := range added {
+		if !a.KeyTrusted(k.MustID()) {
+			t.Errorf("key %x not trusted after update", k.Public)
+		}
+	}
+}
+
+func TestExternalSignerNodeKey(t *testing.T) {
+	priv := key.NewNLPrivate()
+	signer := startTestSigner(t, priv)
+
+	k := Key{Kind: Key25519, Public: priv.Public().Verifier(), Votes: 2}
+	a, _, err := Create(ChonkMem(), State{
+		Keys:               []Key{k},
+		DisablementSecrets: [][]byte{DisablementKDF([]byte{1, 2, 3})},
+	}, priv)
+	if err != nil {
+		t.Fatalf("Create() failed: %v", err)
+	}
+
+	nodeKey := key.NewNode().Public()
+	sig, err := SignNodeKey(signer, nodeKey, nil)
+	if err != nil {
+		t.Fatalf("SignNodeKey() failed: %v", err)
+	}
+	if err := a.NodeKeyAuthorized(nodeKey, sig.Serialize()); err != nil {
+		t.Errorf("NodeKeyAuthorized() failed: %v", err)
+	}
+
+	// The external signer's signature must be the same as one made with
+	// the key directly.
+	want, err := SignNodeKey(priv, nodeKey, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(sig.Serialize(), want.Serialize()) {
+		t.Errorf("external signature differs from local one")
+	}
+}
+
+func TestExternalSignerErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		resp    string // signer output
+		wantErr string
+	}{
+		{
+			name:    "no-key",
+			resp:    "{}\n",
+			wantErr: "no public key",
+		},
+		{
+			name:    "signer-error",
+			resp:    `{"Error":"token not present"}` + "\n",
+			wantErr: "external signer: token not present",
+		},
+		{
+			name:    "eof",
+			resp:    "",
+			wantErr: "unexpected EOF",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewExternalSigner(strings.NewReader(tt.resp), io.Discard)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("NewExternalSigner() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+
+	// A signer that signs with a different key than it claims is caught.
+	priv, other := key.NewNLPrivate(), key.NewNLPrivate()
+	pub, _ := priv.Public().MarshalText()
+	otherSigs, _ := other.SignAUM(AUM{}.SigHash())
+	var out bytes.Buffer
+	out.WriteString(`{"Public":"` + string(pub) + `"}` + "\n")
+	out.WriteString(`{"Signature":"` + base64.StdEncoding.EncodeToString(otherSigs[0].Signature) + `"}` + "\n")
+	s, err := NewExternalSigner(&out, io.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.SignAUM(AUM{}.SigHash()); err == nil || !strings.Contains(err.Error(), "invalid signature") {
+		t.Errorf("SignAUM() error = %v, want invalid signature", err)
+	}
+}
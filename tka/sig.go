@@ -347,6 +347,37 @@ func (s *NodeKeySignature) rotationDetails() (*RotationDetails, error) {
 	return sri, nil
 }
 
+// NodeKeySigner is implemented by types that can sign node-key signatures,
+// such as key.NLPrivate and ExternalSigner.
+type NodeKeySigner interface {
+	// KeyID returns the ID of the signing key.
+	KeyID() tkatype.KeyID
+	// SignNKS returns the signature of the NodeKeySignature with the given
+	// SigHash.
+	SignNKS(tkatype.NKSSigHash) ([]byte, error)
+}
+
+// SignNodeKey returns a direct signature of nodeKey by signer.
+// rotationPublic, if non-empty, must be an ed25519 public key, which the
+// node may use to sign rotations of its node-key.
+func SignNodeKey(signer NodeKeySigner, nodeKey key.NodePublic, rotationPublic []byte) (NodeKeySignature, error) {
+	p, err := nodeKey.MarshalBinary()
+	if err != nil {
+		return NodeKeySignature{}, err
+	}
+	sig := NodeKeySignature{
+		SigKind:        SigDirect,
+		KeyID:          signer.KeyID(),
+		Pubkey:         p,
+		WrappingPubkey: rotationPublic,
+	}
+	sig.Signature, err = signer.SignNKS(sig.SigHash())
+	if err != nil {
+		return NodeKeySignature{}, fmt.Errorf("signature failed: %w", err)
+	}
+	return sig, nil
+}
+
 // ResignNKS re-signs a node-key signature for a new node-key.
 //
 // This only matters on network-locked tailnets, because node-key signatures are
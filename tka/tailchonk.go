@@ -678,6 +678,11 @@ type CompactionOptions struct {
 	MinChain int
 	// The minimum duration to store an AUM before it is a candidate for deletion.
 	MinAge time.Duration
+	// Interval is how often to compact storage in the background, in
+	// addition to any explicit calls to Compact. Zero disables background
+	// compaction. Compact itself ignores this field; it's used by callers
+	// which schedule compaction, such as tailscaled.
+	Interval time.Duration
 }
 
 // retainState tracks the state of an AUM hash as it is being considered for
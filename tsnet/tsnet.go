@@ -24,6 +24,7 @@
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/tailscale/wireguard-go/tun"
@@ -70,6 +71,22 @@
 // Server is an embedded Tailscale server.
 //
 // Its exported fields may be changed until the first method call.
+//
+// Multiple Servers may run in the same process, each joined to the same or
+// different tailnets. Each has its own state directory, logger, network
+// monitor, dialer and event bus, and reads the environment variables that
+// configure it, such as TS_AUTHKEY and TSNET_FORCE_LOGIN, through its own
+// Getenv func if set.
+//
+// Isolation doesn't extend to state that describes or tunes the process as
+// a whole, which all Servers in the process share:
+//
+//   - the debugging knobs that other packages read from the process
+//     environment (see package envknob), such as the TS_DEBUG_* variables;
+//   - the clientmetric registry, whose metrics count events of all
+//     Servers and are uploaded by the logger of only one Server at a time;
+//   - the process-wide fields of package hostinfo, such as the package
+//     name and OS version reported to control.
 type Server struct {
 	// Dir specifies the name of the directory to use for
 	// state. If empty, a directory is selected automatically
@@ -80,7 +97,9 @@ type Server struct {
 	// binary, you will need to make sure that Dir is set uniquely
 	// for each service. A good pattern for this is to have a
 	// "base" directory (such as your mutable storage folder) and
-	// then append the hostname on the end of it.
+	// then append the hostname on the end of it. Start fails if
+	// the directory is already in use by another running Server
+	// in the same process.
 	Dir string
 
 	// Store specifies the state store to use.
@@ -153,6 +172,15 @@ type Server struct {
 	// If empty, the Tailscale default is used.
 	ControlURL string
 
+	// Getenv, if non-nil, is used instead of os.Getenv to read the
+	// environment variables that configure the Server: TS_AUTHKEY,
+	// TS_AUTH_KEY, TS_CLIENT_SECRET, TS_CLIENT_ID, TS_ID_TOKEN and
+	// TS_AUDIENCE, when the corresponding fields are empty, and
+	// TSNET_FORCE_LOGIN. Setting it lets Servers in the same process be
+	// configured independently of each other and of the process
+	// environment.
+	Getenv func(key string) string
+
 	// RunWebClient, if true, runs a client for managing this node over
 	// its Tailscale interface on port 5252.
 	RunWebClient bool
@@ -180,6 +208,7 @@ type Server struct {
 	netstack             *netstack.Impl
 	netMon               *netmon.Monitor
 	rootPath             string // the state directory
+	claimedRootPath      string // absolute rootPath, if claimed in rootPathsInUse
 	hostname             string
 	shutdownCtx          context.Context
 	shutdownCancel       context.CancelFunc
@@ -201,6 +230,20 @@ type Server struct {
 	closed              bool
 }
 
+var (
+	// rootPathsMu guards rootPathsInUse.
+	rootPathsMu sync.Mutex
+	// rootPathsInUse is the set of state directories used by running
+	// Servers in this process, keyed by absolute path.
+	rootPathsInUse set.Set[string]
+
+	// metricsDeltaOwner is the Server whose logtail uploads the process-wide
+	// clientmetric deltas. The clientmetric registry is global and its
+	// delta encoding is stateful, so if more than one logger consumed it,
+	// each would see only a fraction of the deltas.
+	metricsDeltaOwner atomic.Pointer[Server]
+)
+
 // FallbackTCPHandler describes the callback which
 // conditionally handles an incoming TCP flow for the
 // provided (src/port, dst/port) 4-tuple. These are registered
@@ -493,7 +536,11 @@ func (s *Server) Close() error {
 		ln.closeLocked()
 	}
 	wg.Wait()
-	s.sys.Bus.Get().Close()
+	if s.sys != nil {
+		s.sys.Bus.Get().Close()
+	}
+	s.releaseRootPath()
+	metricsDeltaOwner.CompareAndSwap(s, nil)
 	s.closed = true
 	return nil
 }
@@ -553,38 +600,57 @@ func (s *Server) getAuthKey() string {
 	if v := s.AuthKey; v != "" {
 		return v
 	}
-	if v := os.Getenv("TS_AUTHKEY"); v != "" {
+	if v := s.getenv("TS_AUTHKEY"); v != "" {
 		return v
 	}
-	return os.Getenv("TS_AUTH_KEY")
+	return s.getenv("TS_AUTH_KEY")
 }
 
 func (s *Server) getClientSecret() string {
 	if v := s.ClientSecret; v != "" {
 		return v
 	}
-	return os.Getenv("TS_CLIENT_SECRET")
+	return s.getenv("TS_CLIENT_SECRET")
 }
 
 func (s *Server) getClientID() string {
 	if v := s.ClientID; v != "" {
 		return v
 	}
-	return os.Getenv("TS_CLIENT_ID")
+	return s.getenv("TS_CLIENT_ID")
 }
 
 func (s *Server) getIDToken() string {
 	if v := s.IDToken; v != "" {
 		return v
 	}
-	return os.Getenv("TS_ID_TOKEN")
+	return s.getenv("TS_ID_TOKEN")
 }
 
 func (s *Server) getAudience() string {
 	if v := s.Audience; v != "" {
 		return v
 	}
-	return os.Getenv("TS_AUDIENCE")
+	return s.getenv("TS_AUDIENCE")
+}
+
+// getenv returns the value of the environment variable key, as read by
+// s.Getenv if set.
+func (s *Server) getenv(key string) string {
+	if s.Getenv != nil {
+		return s.Getenv(key)
+	}
+	return os.Getenv(key)
+}
+
+// forceLogin reports whether TSNET_FORCE_LOGIN is set, to log in even if
+// the node is already logged in.
+func (s *Server) forceLogin() bool {
+	if s.Getenv == nil {
+		return envknob.Bool("TSNET_FORCE_LOGIN")
+	}
+	v, _ := strconv.ParseBool(s.Getenv("TSNET_FORCE_LOGIN"))
+	return v
 }
 
 func (s *Server) start() (reterr error) {
@@ -639,6 +705,10 @@ func (s *Server) start() (reterr error) {
 	} else if !fi.IsDir() {
 		return fmt.Errorf("%v is not a directory", s.rootPath)
 	}
+	if err := s.claimRootPath(); err != nil {
+		return err
+	}
+	closePool.addFunc(s.releaseRootPath)
 
 	tsLogf := func(format string, a ...any) {
 		if s.logtail != nil {
@@ -775,7 +845,7 @@ func (s *Server) start() (reterr error) {
 		return fmt.Errorf("starting backend: %w", err)
 	}
 	st := lb.State()
-	if st == ipn.NeedsLogin || envknob.Bool("TSNET_FORCE_LOGIN") {
+	if st == ipn.NeedsLogin || s.forceLogin() {
 		s.logf("LocalBackend state is %v; running StartLoginInteractive...", st)
 		if err := s.lb.StartLoginInteractive(s.shutdownCtx); err != nil {
 			return fmt.Errorf("StartLoginInteractive: %w", err)
@@ -890,13 +960,54 @@ func (s *Server) startLogger(closePool *closeOnErrorPool, health *health.Tracker
 		CompressLogs: true,
 		Bus:          s.sys.Bus.Get(),
 		HTTPC:        &http.Client{Transport: logpolicy.NewLogtailTransport(logtail.DefaultHost, s.netMon, health, tsLogf)},
-		MetricsDelta: clientmetric.EncodeLogTailMetricsDelta,
+		MetricsDelta: s.logtailMetricsDelta,
 	}
 	s.logtail = logtail.NewLogger(c, tsLogf)
 	closePool.addFunc(func() { s.logtail.Shutdown(context.Background()) })
 	return nil
 }
 
+// logtailMetricsDelta is the logtail.Config.MetricsDelta func for s.
+//
+// Only the first Server in the process to call it uploads clientmetric
+// deltas; it keeps doing so until it is closed, at which point another
+// Server may take over.
+func (s *Server) logtailMetricsDelta() string {
+	if metricsDeltaOwner.CompareAndSwap(nil, s) || metricsDeltaOwner.Load() == s {
+		return clientmetric.EncodeLogTailMetricsDelta()
+	}
+	return ""
+}
+
+// claimRootPath records s.rootPath as in use by s, returning an error if
+// another running Server in this process already uses it.
+func (s *Server) claimRootPath() error {
+	abs, err := filepath.Abs(s.rootPath)
+	if err != nil {
+		return err
+	}
+	rootPathsMu.Lock()
+	defer rootPathsMu.Unlock()
+	if rootPathsInUse.Contains(abs) {
+		return fmt.Errorf("state directory %q is already in use by another Server in this process; set Server.Dir uniquely for each Server", abs)
+	}
+	rootPathsInUse.Make()
+	rootPathsInUse.Add(abs)
+	s.claimedRootPath = abs
+	return nil
+}
+
+// releaseRootPath undoes claimRootPath.
+func (s *Server) releaseRootPath() {
+	if s.claimedRootPath == "" {
+		return
+	}
+	rootPathsMu.Lock()
+	defer rootPathsMu.Unlock()
+	rootPathsInUse.Delete(s.claimedRootPath)
+	s.claimedRootPath = ""
+}
+
 type closeOnErrorPool []func()
 
 func (p *closeOnErrorPool) add(c io.Closer)   { *p = append(*p, func() { c.Close() }) }
@@ -1145,6 +1256,145 @@ func (s *Server) ListenTLS(network, addr string) (net.Listener, error) {
 	}), nil
 }
 
+// Identity is the Tailscale identity of the remote end of a connection,
+// as known to the Server at the time the connection was accepted.
+type Identity struct {
+	// Node is the peer node that opened the connection.
+	Node *tailcfg.Node
+
+	// UserProfile is the profile of the user owning Node. For tagged
+	// nodes, it is the tagged-devices pseudo-user.
+	UserProfile *tailcfg.UserProfile
+
+	// CapMap is the set of peer capabilities granted to Node
+	// when connecting to this Server.
+	CapMap tailcfg.PeerCapMap
+}
+
+// IdentityConn is a net.Conn returned by the Accept method of listeners
+// created with [Server.ListenIdentity].
+type IdentityConn struct {
+	net.Conn
+	id *Identity
+}
+
+// Identity returns the identity of the connection's peer, captured
+// when the connection was accepted. It is never nil.
+func (c *IdentityConn) Identity() *Identity { return c.id }
+
+// ListenIdentity is like [Server.Listen] but the returned listener only
+// yields connections from known tailnet peers, as [*IdentityConn] values
+// whose Identity reports the peer's node, user and capabilities.
+//
+// The identity is looked up once, when the connection is accepted, so it
+// does not change if the netmap is updated while the connection is open.
+// Connections whose source cannot be resolved to a peer are closed.
+//
+// Only TCP networks are supported.
+func (s *Server) ListenIdentity(network, addr string) (net.Listener, error) {
+	switch network {
+	case "", "tcp", "tcp4", "tcp6":
+	default:
+		return nil, fmt.Errorf("tsnet.ListenIdentity(%q, %q): only tcp is supported", network, addr)
+	}
+	ln, err := s.listen(network, addr, listenOnTailnet)
+	if err != nil {
+		return nil, err
+	}
+	return &identityListener{Listener: ln, s: s}, nil
+}
+
+// identityListener wraps a listener to resolve the identity of each
+// accepted conn's peer.
+type identityListener struct {
+	net.Listener
+	s *Server
+}
+
+func (ln *identityListener) Accept() (net.Conn, error) {
+	for {
+		c, err := ln.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+		id, err := ln.s.identityForAddr(c.RemoteAddr().String())
+		if err != nil {
+			ln.s.logf("tsnet: rejecting conn from %v: %v", c.RemoteAddr(), err)
+			c.Close()
+			continue
+		}
+		return &IdentityConn{Conn: c, id: id}, nil
+	}
+}
+
+// Server returns the tsnet Server associated with the listener.
+func (ln *identityListener) Server() *Server { return ln.s }
+
+// identityForAddr returns the identity of the peer with the
+// provided "ip:port" address.
+func (s *Server) identityForAddr(remoteAddr string) (*Identity, error) {
+	if s.lb == nil {
+		return nil, errors.New("tsnet: server not started")
+	}
+	ipp, err := netip.ParseAddrPort(remoteAddr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid remote address %q: %w", remoteAddr, err)
+	}
+	ipp = netip.AddrPortFrom(ipp.Addr().Unmap(), ipp.Port())
+	n, up, ok := s.lb.WhoIs("tcp", ipp)
+	if !ok {
+		return nil, fmt.Errorf("no peer found for %v", ipp)
+	}
+	return &Identity{
+		Node:        n.AsStruct(),
+		UserProfile: &up,
+		CapMap:      s.lb.PeerCaps(ipp.Addr()),
+	}, nil
+}
+
+type identityContextKey struct{}
+
+// IdentityConnContext is a function suitable for use as an
+// [http.Server.ConnContext]. For connections accepted from a
+// [Server.ListenIdentity] listener, it attaches the peer's identity to the
+// context, to be retrieved later with [IdentityFromContext].
+func IdentityConnContext(ctx context.Context, c net.Conn) context.Context {
+	if ic, ok := c.(*IdentityConn); ok {
+		return context.WithValue(ctx, identityContextKey{}, ic.id)
+	}
+	return ctx
+}
+
+// IdentityFromContext returns the peer identity stored in ctx by
+// [IdentityConnContext] or [Server.IdentityHandler], if any.
+func IdentityFromContext(ctx context.Context) (id *Identity, ok bool) {
+	id, ok = ctx.Value(identityContextKey{}).(*Identity)
+	return id, ok
+}
+
+// IdentityHandler returns an http.Handler that makes the identity of each
+// request's peer available to h via [IdentityFromContext].
+//
+// If the request's context already carries an identity (because the
+// http.Server serving a [Server.ListenIdentity] listener uses
+// [IdentityConnContext]), that identity is used. Otherwise the identity is
+// looked up from the request's RemoteAddr. Requests from unknown peers are
+// rejected with 403 Forbidden.
+func (s *Server) IdentityHandler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := IdentityFromContext(r.Context()); ok {
+			h.ServeHTTP(w, r)
+			return
+		}
+		id, err := s.identityForAddr(r.RemoteAddr)
+		if err != nil {
+			http.Error(w, "unknown peer", http.StatusForbidden)
+			return
+		}
+		h.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), identityContextKey{}, id)))
+	})
+}
+
 // RegisterFallbackTCPHandler registers a callback which will be called
 // to handle a TCP flow to this tsnet node, for which no listeners will handle.
 //
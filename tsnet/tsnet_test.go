@@ -1345,6 +1345,204 @@ func TestFallbackTCPHandler(t *testing.T) {
 	}
 }
 
+// TestMultipleTailnets tests that Servers in one process can each be joined
+// to a different tailnet and pass traffic independently.
+func TestMultipleTailnets(t *testing.T) {
+	tstest.Shard(t)
+	tstest.ResourceCheck(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+
+	controlURLA, _ := startControl(t)
+	controlURLB, _ := startControl(t)
+
+	a1, a1ip, _ := startServer(t, ctx, controlURLA, "a1")
+	a2, a2ip, a2Key := startServer(t, ctx, controlURLA, "a2")
+	b1, b1ip, _ := startServer(t, ctx, controlURLB, "b1")
+	b2, b2ip, b2Key := startServer(t, ctx, controlURLB, "b2")
+
+	// Each tailnet's nodes must see only their own peers.
+	for _, tc := range []struct {
+		s       *Server
+		want    key.NodePublic
+		notWant key.NodePublic
+	}{
+		{a1, a2Key, b2Key},
+		{b1, b2Key, a2Key},
+	} {
+		st := must.Get(must.Get(tc.s.LocalClient()).Status(ctx))
+		if _, ok := st.Peer[tc.want]; !ok {
+			t.Errorf("%s: missing peer %v", st.Self.HostName, tc.want.ShortString())
+		}
+		if _, ok := st.Peer[tc.notWant]; ok {
+			t.Errorf("%s: unexpected peer %v from other tailnet", st.Self.HostName, tc.notWant.ShortString())
+		}
+	}
+
+	var wg sync.WaitGroup
+	errc := make(chan error, 2)
+	for _, p := range [][2]*Server{{a1, a2}, {b1, b2}} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s1ip, s2ip := a1ip, a2ip
+			if p[0] == b1 {
+				s1ip, s2ip = b1ip, b2ip
+			}
+			errc <- sendData(t.Logf, ctx, 1<<20, p[0], p[1], s1ip, s2ip)
+		}()
+	}
+	wg.Wait()
+	close(errc)
+	for err := range errc {
+		if err != nil {
+			t.Error(err)
+		}
+	}
+
+	// A second Server may not share a running Server's state directory.
+	dup := &Server{
+		Dir:        a1.GetRootPath(),
+		ControlURL: controlURLA,
+		Hostname:   "dup",
+		Store:      new(mem.Store),
+		Ephemeral:  true,
+	}
+	defer dup.Close()
+	if err := dup.Start(); err == nil || !strings.Contains(err.Error(), "already in use") {
+		t.Errorf("Start with shared Dir = %v; want already-in-use error", err)
+	}
+}
+
+// TestServerGetenv tests that Servers in one process read their
+// configuration from their own Getenv rather than the process environment.
+func TestServerGetenv(t *testing.T) {
+	t.Setenv("TS_AUTHKEY", "tskey-process")
+	t.Setenv("TS_CLIENT_ID", "client-process")
+	t.Setenv("TSNET_FORCE_LOGIN", "1")
+
+	env := func(kv map[string]string) func(string) string {
+		return func(key string) string { return kv[key] }
+	}
+	a := &Server{Getenv: env(map[string]string{"TS_AUTHKEY": "tskey-a", "TSNET_FORCE_LOGIN": "true"})}
+	b := &Server{Getenv: env(map[string]string{"TS_AUTH_KEY": "tskey-b", "TS_CLIENT_ID": "client-b"})}
+	c := &Server{}
+
+	for _, tc := range []struct {
+		name                      string
+		s                         *Server
+		wantAuthKey, wantClientID string
+		wantForceLogin            bool
+	}{
+		{"a", a, "tskey-a", "", true},
+		{"b", b, "tskey-b", "client-b", false},
+		{"process", c, "tskey-process", "client-process", true},
+	} {
+		if got := tc.s.getAuthKey(); got != tc.wantAuthKey {
+			t.Errorf("%s: getAuthKey = %q; want %q", tc.name, got, tc.wantAuthKey)
+		}
+		if got := tc.s.getClientID(); got != tc.wantClientID {
+			t.Errorf("%s: getClientID = %q; want %q", tc.name, got, tc.wantClientID)
+		}
+		if got := tc.s.forceLogin(); got != tc.wantForceLogin {
+			t.Errorf("%s: forceLogin = %v; want %v", tc.name, got, tc.wantForceLogin)
+		}
+	}
+
+	// Fields still take precedence over Getenv.
+	a.AuthKey = "tskey-field"
+	if got, want := a.getAuthKey(), "tskey-field"; got != want {
+		t.Errorf("getAuthKey with AuthKey set = %q; want %q", got, want)
+	}
+}
+
+func TestListenIdentity(t *testing.T) {
+	tstest.Shard(t)
+	tstest.ResourceCheck(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	controlURL, _ := startControl(t)
+	s1, s1ip, _ := startServer(t, ctx, controlURL, "s1")
+	s2, _, s2PubKey := startServer(t, ctx, controlURL, "s2")
+
+	if _, err := s1.ListenIdentity("udp", ":8081"); err == nil {
+		t.Fatal("ListenIdentity on udp succeeded; want error")
+	}
+
+	ln, err := s1.ListenIdentity("tcp", ":8081")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	t.Run("conn", func(t *testing.T) {
+		errc := make(chan error, 1)
+		go func() {
+			c, err := s2.Dial(ctx, "tcp", fmt.Sprintf("%s:8081", s1ip))
+			if err != nil {
+				errc <- err
+				return
+			}
+			defer c.Close()
+			_, err = io.WriteString(c, "hi")
+			errc <- err
+		}()
+
+		c, err := ln.Accept()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer c.Close()
+		ic, ok := c.(*IdentityConn)
+		if !ok {
+			t.Fatalf("Accept returned %T; want *IdentityConn", c)
+		}
+		id := ic.Identity()
+		if id.Node.Key != s2PubKey {
+			t.Errorf("Identity().Node.Key = %v; want %v", id.Node.Key, s2PubKey)
+		}
+		if id.UserProfile == nil || id.UserProfile.ID != id.Node.User {
+			t.Errorf("Identity().UserProfile = %+v; want user %v", id.UserProfile, id.Node.User)
+		}
+		if err := <-errc; err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("http", func(t *testing.T) {
+		hs := &http.Server{
+			ConnContext: IdentityConnContext,
+			Handler: s1.IdentityHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				id, ok := IdentityFromContext(r.Context())
+				if !ok {
+					http.Error(w, "no identity", http.StatusInternalServerError)
+					return
+				}
+				io.WriteString(w, id.Node.Key.String())
+			})),
+		}
+		go hs.Serve(ln)
+		defer hs.Close()
+
+		res, err := s2.HTTPClient().Get(fmt.Sprintf("http://%s:8081/", s1ip))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		body, err := io.ReadAll(res.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.StatusCode != http.StatusOK {
+			t.Fatalf("status = %v: %s", res.Status, body)
+		}
+		if got, want := string(body), s2PubKey.String(); got != want {
+			t.Errorf("got identity %q; want %q", got, want)
+		}
+	})
+}
+
 func TestCapturePcap(t *testing.T) {
 	tstest.Shard(t)
 	const timeLimit = 120
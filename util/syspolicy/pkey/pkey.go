@@ -141,6 +141,42 @@
 	// It's a noop on other platforms.
 	EncryptState Key = "EncryptState"
 
+	// DNSQueryLog is a boolean key that controls whether the DNS queries
+	// answered by Tailscale's internal resolver are logged to a rotating
+	// file in the tailscaled state directory. The log is kept on the device
+	// and is not uploaded.
+	DNSQueryLog Key = "DNSQueryLog"
+
+	// TaildropAllowUsers and TaildropAllowTags are string array keys that,
+	// if either is set, restrict the senders that Taildrop receives files
+	// from to untagged nodes owned by the listed users (by login name) and
+	// nodes with any of the listed tags.
+	TaildropAllowUsers Key = "TaildropAllowUsers"
+	TaildropAllowTags  Key = "TaildropAllowTags"
+	// TaildropAllowExtensions is a string array key that, if set, restricts
+	// the files that Taildrop receives to those with the listed extensions,
+	// such as "jpg".
+	TaildropAllowExtensions Key = "TaildropAllowExtensions"
+	// TaildropMaxFileSize and TaildropMaxInboxSize are integer keys that, if
+	// set, limit the size in bytes of each file that Taildrop receives and
+	// the total size of the received files waiting to be picked up.
+	TaildropMaxFileSize  Key = "TaildropMaxFileSize"
+	TaildropMaxInboxSize Key = "TaildropMaxInboxSize"
+	// TaildropScanner is a string key that, if set, is the path of a command
+	// that Taildrop runs with the path of each received file, deleting the
+	// file if the command fails.
+	TaildropScanner Key = "TaildropScanner"
+
+	// SSHRecordingMaxAge is a duration key that, if set, is how long SSH
+	// session recordings stored locally, for tailnets without recorder
+	// nodes, are kept after they were last written to.
+	SSHRecordingMaxAge Key = "SSHRecordingMaxAge"
+	// SSHRecordingMaxFiles and SSHRecordingMaxBytes are integer keys that, if
+	// set, limit the number and total size in bytes of the SSH session
+	// recordings stored locally.
+	SSHRecordingMaxFiles Key = "SSHRecordingMaxFiles"
+	SSHRecordingMaxBytes Key = "SSHRecordingMaxBytes"
+
 	// HardwareAttestation is a boolean key that controls whether to use a
 	// hardware-backed key to bind the node identity to this device.
 	HardwareAttestation Key = "HardwareAttestation"
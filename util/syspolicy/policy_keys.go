@@ -25,6 +25,7 @@
 	setting.NewDefinition(pkey.CheckUpdates, setting.DeviceSetting, setting.PreferenceOptionValue),
 	setting.NewDefinition(pkey.ControlURL, setting.DeviceSetting, setting.StringValue),
 	setting.NewDefinition(pkey.DeviceSerialNumber, setting.DeviceSetting, setting.StringValue),
+	setting.NewDefinition(pkey.DNSQueryLog, setting.DeviceSetting, setting.BooleanValue),
 	setting.NewDefinition(pkey.EnableDNSRegistration, setting.DeviceSetting, setting.PreferenceOptionValue),
 	setting.NewDefinition(pkey.EnableIncomingConnections, setting.DeviceSetting, setting.PreferenceOptionValue),
 	setting.NewDefinition(pkey.EnableRunExitNode, setting.DeviceSetting, setting.PreferenceOptionValue),
@@ -44,6 +45,15 @@
 	setting.NewDefinition(pkey.ReconnectAfter, setting.DeviceSetting, setting.DurationValue),
 	setting.NewDefinition(pkey.Tailnet, setting.DeviceSetting, setting.StringValue),
 	setting.NewDefinition(pkey.HardwareAttestation, setting.DeviceSetting, setting.BooleanValue),
+	setting.NewDefinition(pkey.TaildropAllowUsers, setting.DeviceSetting, setting.StringListValue),
+	setting.NewDefinition(pkey.TaildropAllowTags, setting.DeviceSetting, setting.StringListValue),
+	setting.NewDefinition(pkey.TaildropAllowExtensions, setting.DeviceSetting, setting.StringListValue),
+	setting.NewDefinition(pkey.TaildropMaxFileSize, setting.DeviceSetting, setting.IntegerValue),
+	setting.NewDefinition(pkey.TaildropMaxInboxSize, setting.DeviceSetting, setting.IntegerValue),
+	setting.NewDefinition(pkey.TaildropScanner, setting.DeviceSetting, setting.StringValue),
+	setting.NewDefinition(pkey.SSHRecordingMaxAge, setting.DeviceSetting, setting.DurationValue),
+	setting.NewDefinition(pkey.SSHRecordingMaxFiles, setting.DeviceSetting, setting.IntegerValue),
+	setting.NewDefinition(pkey.SSHRecordingMaxBytes, setting.DeviceSetting, setting.IntegerValue),
 
 	// User policy settings (can be configured on a user- or device-basis):
 	setting.NewDefinition(pkey.AdminConsoleVisibility, setting.UserSetting, setting.VisibilityValue),
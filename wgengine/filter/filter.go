@@ -9,6 +9,7 @@
 	"net/netip"
 	"slices"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"go4.org/netipx"
@@ -55,6 +56,15 @@ type Filter struct {
 	matches4 matches
 	matches6 matches
 
+	// rules4 and rules6 are the hit counters of each element of
+	// matches4 and matches6, respectively.
+	rules4, rules6 ruleCounters
+
+	// rules are the hit counters of the packet-matching Matches passed
+	// to New, in order, and ruleStrs are their String forms.
+	rules    ruleCounters
+	ruleStrs []string
+
 	// cap4 and cap6 are the subsets of the matches that are about
 	// capability grants, partitioned by source IP address family.
 	cap4, cap6 matches
@@ -72,6 +82,72 @@ type Filter struct {
 type filterState struct {
 	mu  sync.Mutex
 	lru *flowtrack.Cache[struct{}] // from flowtrack.Tuple -> struct{}
+
+	// rules maps the ruleKey of each Match of the most recently created
+	// Filter using this state to its hit counter, so that counters
+	// survive filter updates that keep the rule. Guarded by mu.
+	rules map[string]*ruleCounter
+
+	// noMatch counts new inbound flows that no Match accepted.
+	noMatch ruleCounter
+
+	// recent holds the most recently seen new inbound flows that were
+	// evaluated against the Matches, for Filter.Simulate.
+	recent recentRing
+}
+
+// ruleCounter counts the new flows accepted by a Match.
+type ruleCounter struct {
+	hits    atomic.Uint64
+	lastHit atomic.Int64 // unix nanoseconds; zero if never hit
+}
+
+// hit records a hit at now, in unix nanoseconds.
+func (c *ruleCounter) hit(now int64) {
+	c.hits.Add(1)
+	c.lastHit.Store(now)
+}
+
+func (c *ruleCounter) load() (hits uint64, lastHit time.Time) {
+	hits = c.hits.Load()
+	if ns := c.lastHit.Load(); ns != 0 {
+		lastHit = time.Unix(0, ns)
+	}
+	return hits, lastHit
+}
+
+// ruleCounters is a list of hit counters, one per Match.
+type ruleCounters []*ruleCounter
+
+// acceptIn records that the i'th Match of rules accepted the new
+// inbound flow q.
+func (f *Filter) acceptIn(rules ruleCounters, i int, q *packet.Parsed) {
+	now := time.Now().UnixNano()
+	rules[i].hit(now)
+	f.state.addRecent(q, true, now)
+}
+
+// dropIn records that no Match accepted the new inbound flow q.
+func (f *Filter) dropIn(q *packet.Parsed) {
+	now := time.Now().UnixNano()
+	f.state.noMatch.hit(now)
+	f.state.addRecent(q, false, now)
+}
+
+func (s *filterState) addRecent(q *packet.Parsed, accepted bool, now int64) {
+	s.recent.add(recentFlow{
+		proto:    q.IPProto,
+		src:      q.Src.Addr(),
+		dst:      q.Dst,
+		accepted: accepted,
+		lastSeen: now,
+	})
+}
+
+// ruleKey returns the identity of m for the purpose of carrying over
+// its hit counter to a new Filter.
+func ruleKey(m Match) string {
+	return fmt.Sprint(m.String(), m.SrcCaps)
 }
 
 // lruMax is the size of the LRU cache in filterState.
@@ -202,10 +278,43 @@ func New(matches []Match, capTest CapTestFunc, localNets, logIPs *netipx.IPSet,
 		}
 	}
 
+	// Set up hit counters for the packet-matching rules, reusing those of
+	// the filter we share state with for unchanged rules.
+	counters := make(ruleCounters, len(matches))
+	var rules ruleCounters
+	var ruleStrs []string
+	state.mu.Lock()
+	oldRules := state.rules
+	state.rules = make(map[string]*ruleCounter, len(matches))
+	for i, m := range matches {
+		if len(m.Dsts) == 0 {
+			continue
+		}
+		k := ruleKey(m)
+		c, ok := state.rules[k]
+		if !ok {
+			c, ok = oldRules[k]
+			if !ok {
+				c = new(ruleCounter)
+			}
+			state.rules[k] = c
+		}
+		counters[i] = c
+		rules = append(rules, c)
+		ruleStrs = append(ruleStrs, m.String())
+	}
+	state.mu.Unlock()
+
+	matches4, idx4 := matchesFamily(matches, netip.Addr.Is4)
+	matches6, idx6 := matchesFamily(matches, netip.Addr.Is6)
 	f := &Filter{
 		logf:        logf,
-		matches4:    matchesFamily(matches, netip.Addr.Is4),
-		matches6:    matchesFamily(matches, netip.Addr.Is6),
+		matches4:    matches4,
+		matches6:    matches6,
+		rules4:      countersAt(counters, idx4),
+		rules6:      countersAt(counters, idx6),
+		rules:       rules,
+		ruleStrs:    ruleStrs,
 		cap4:        capMatchesFunc(matches, netip.Addr.Is4),
 		cap6:        capMatchesFunc(matches, netip.Addr.Is6),
 		local4:      ipset.FalseContainsIPFunc(),
@@ -232,10 +341,10 @@ func New(matches []Match, capTest CapTestFunc, localNets, logIPs *netipx.IPSet,
 }
 
 // matchesFamily returns the subset of ms for which keep(srcNet.IP)
-// and keep(dstNet.IP) are both true.
-func matchesFamily(ms matches, keep func(netip.Addr) bool) matches {
-	var ret matches
-	for _, m := range ms {
+// and keep(dstNet.IP) are both true, along with the index in ms of
+// each returned Match.
+func matchesFamily(ms matches, keep func(netip.Addr) bool) (ret matches, idx []int) {
+	for i, m := range ms {
 		var retm Match
 		retm.IPProto = m.IPProto
 		retm.SrcCaps = m.SrcCaps
@@ -253,11 +362,38 @@ func matchesFamily(ms matches, keep func(netip.Addr) bool) matches {
 		if (len(retm.Srcs) > 0 || len(retm.SrcCaps) > 0) && len(retm.Dsts) > 0 {
 			retm.SrcsContains = ipset.NewContainsIPFunc(views.SliceOf(retm.Srcs))
 			ret = append(ret, retm)
+			idx = append(idx, i)
 		}
 	}
+	return ret, idx
+}
+
+// countersAt returns the elements of counters at the provided indices.
+func countersAt(counters ruleCounters, idx []int) ruleCounters {
+	ret := make(ruleCounters, len(idx))
+	for i, j := range idx {
+		ret[i] = counters[j]
+	}
 	return ret
 }
 
+// Stats returns a snapshot of the filter's per-rule hit counters.
+//
+// Counters are shared with filters created from f with New's shareStateWith,
+// for as long as they keep the same rule.
+func (f *Filter) Stats() filtertype.Stats {
+	st := filtertype.Stats{
+		Rules: make([]filtertype.RuleStats, len(f.rules)),
+	}
+	for i, c := range f.rules {
+		rs := &st.Rules[i]
+		rs.Rule = f.ruleStrs[i]
+		rs.Hits, rs.LastHit = c.load()
+	}
+	st.NoMatch, st.LastNoMatch = f.state.noMatch.load()
+	return st
+}
+
 // capMatchesFunc returns a copy of the subset of ms for which keep(srcNet.IP)
 // and the match is a capability grant.
 func capMatchesFunc(ms matches, keep func(netip.Addr) bool) matches {
@@ -344,13 +480,24 @@ func (f *Filter) logRateLimit(runflags RunFlags, q *packet.Parsed, dir direction
 // Check determines whether traffic from srcIP to dstIP:dstPort is allowed
 // using protocol proto.
 func (f *Filter) Check(srcIP, dstIP netip.Addr, dstPort uint16, proto ipproto.Proto) Response {
+	pkt, ok := synthPacket(srcIP, dstIP, dstPort, proto)
+	if !ok {
+		// Mismatched address families, no filters will
+		// match.
+		return Drop
+	}
+	return f.RunIn(pkt, 0)
+}
+
+// synthPacket returns a packet that starts a new flow from srcIP to
+// dstIP:dstPort using protocol proto, for evaluation by the filter.
+// It reports false if srcIP and dstIP are of different address families.
+func synthPacket(srcIP, dstIP netip.Addr, dstPort uint16, proto ipproto.Proto) (_ *packet.Parsed, ok bool) {
 	pkt := &packet.Parsed{}
 	pkt.Decode(dummyPacket) // initialize private fields
 	switch {
 	case (srcIP.Is4() && dstIP.Is6()) || (srcIP.Is6() && srcIP.Is4()):
-		// Mismatched address families, no filters will
-		// match.
-		return Drop
+		return nil, false
 	case srcIP.Is4():
 		pkt.IPVersion = 4
 	case srcIP.Is6():
@@ -364,8 +511,38 @@ func (f *Filter) Check(srcIP, dstIP netip.Addr, dstPort uint16, proto ipproto.Pr
 	if proto == ipproto.TCP {
 		pkt.TCPFlags = packet.TCPSyn
 	}
+	return pkt, true
+}
 
-	return f.RunIn(pkt, 0)
+// Simulate replays the new inbound flows recently evaluated by f, and by
+// the filters it shares state with, against a filter with f's local networks
+// and capability test but with the provided matches. It returns one result
+// per flow, most recently seen first.
+//
+// Only flows that were evaluated against the filter's rules are replayed;
+// packets of established flows and those dropped for being addressed to
+// non-local IPs are not tracked.
+func (f *Filter) Simulate(matches []Match) []filtertype.SimulatedFlow {
+	flows := f.state.recent.flows()
+
+	cand := New(matches, f.srcIPHasCap, nil, nil, nil, logger.Discard)
+	cand.local4, cand.local6 = f.local4, f.local6
+
+	ret := make([]filtertype.SimulatedFlow, 0, len(flows))
+	for _, fl := range flows {
+		sf := filtertype.SimulatedFlow{
+			Proto:       fl.proto,
+			Src:         fl.src,
+			Dst:         fl.dst,
+			LastSeen:    time.Unix(0, fl.lastSeen),
+			WasAccepted: fl.accepted,
+		}
+		if pkt, ok := synthPacket(sf.Src, sf.Dst.Addr(), sf.Dst.Port(), sf.Proto); ok {
+			sf.WouldAccept = cand.RunIn(pkt, 0) == Accept
+		}
+		ret = append(ret, sf)
+	}
+	return ret
 }
 
 // CheckTCP determines whether TCP traffic from srcIP to dstIP:dstPort
@@ -472,8 +649,9 @@ func (f *Filter) runIn4(q *packet.Parsed) (r Response, why string) {
 			//  related to an existing ICMP-Echo, TCP, or UDP
 			//  session.
 			return Accept, "icmp response ok"
-		} else if f.matches4.matchIPsOnly(q, f.srcIPHasCap) {
+		} else if i, ok := f.matches4.matchIPsOnly(q, f.srcIPHasCap); ok {
 			// If any port is open to an IP, allow ICMP to it.
+			f.acceptIn(f.rules4, i, q)
 			return Accept, "icmp ok"
 		}
 	case ipproto.TCP:
@@ -488,7 +666,8 @@ func (f *Filter) runIn4(q *packet.Parsed) (r Response, why string) {
 		if !q.IsTCPSyn() {
 			return Accept, "tcp non-syn"
 		}
-		if f.matches4.match(q, f.srcIPHasCap) {
+		if i, ok := f.matches4.match(q, f.srcIPHasCap); ok {
+			f.acceptIn(f.rules4, i, q)
 			return Accept, "tcp ok"
 		}
 	case ipproto.UDP, ipproto.SCTP:
@@ -501,17 +680,21 @@ func (f *Filter) runIn4(q *packet.Parsed) (r Response, why string) {
 		if ok {
 			return Accept, "cached"
 		}
-		if f.matches4.match(q, f.srcIPHasCap) {
+		if i, ok := f.matches4.match(q, f.srcIPHasCap); ok {
+			f.acceptIn(f.rules4, i, q)
 			return Accept, "ok"
 		}
 	case ipproto.TSMP:
 		return Accept, "tsmp ok"
 	default:
-		if f.matches4.matchProtoAndIPsOnlyIfAllPorts(q) {
+		if i, ok := f.matches4.matchProtoAndIPsOnlyIfAllPorts(q); ok {
+			f.acceptIn(f.rules4, i, q)
 			return Accept, "other-portless ok"
 		}
+		f.dropIn(q)
 		return Drop, unknownProtoString(q.IPProto)
 	}
+	f.dropIn(q)
 	return Drop, "no rules matched"
 }
 
@@ -532,8 +715,9 @@ func (f *Filter) runIn6(q *packet.Parsed) (r Response, why string) {
 			//  related to an existing ICMP-Echo, TCP, or UDP
 			//  session.
 			return Accept, "icmp response ok"
-		} else if f.matches6.matchIPsOnly(q, f.srcIPHasCap) {
+		} else if i, ok := f.matches6.matchIPsOnly(q, f.srcIPHasCap); ok {
 			// If any port is open to an IP, allow ICMP to it.
+			f.acceptIn(f.rules6, i, q)
 			return Accept, "icmp ok"
 		}
 	case ipproto.TCP:
@@ -548,7 +732,8 @@ func (f *Filter) runIn6(q *packet.Parsed) (r Response, why string) {
 		if q.IPProto == ipproto.TCP && !q.IsTCPSyn() {
 			return Accept, "tcp non-syn"
 		}
-		if f.matches6.match(q, f.srcIPHasCap) {
+		if i, ok := f.matches6.match(q, f.srcIPHasCap); ok {
+			f.acceptIn(f.rules6, i, q)
 			return Accept, "tcp ok"
 		}
 	case ipproto.UDP, ipproto.SCTP:
@@ -561,17 +746,21 @@ func (f *Filter) runIn6(q *packet.Parsed) (r Response, why string) {
 		if ok {
 			return Accept, "cached"
 		}
-		if f.matches6.match(q, f.srcIPHasCap) {
+		if i, ok := f.matches6.match(q, f.srcIPHasCap); ok {
+			f.acceptIn(f.rules6, i, q)
 			return Accept, "ok"
 		}
 	case ipproto.TSMP:
 		return Accept, "tsmp ok"
 	default:
-		if f.matches6.matchProtoAndIPsOnlyIfAllPorts(q) {
+		if i, ok := f.matches6.matchProtoAndIPsOnlyIfAllPorts(q); ok {
+			f.acceptIn(f.rules6, i, q)
 			return Accept, "other-portless ok"
 		}
+		f.dropIn(q)
 		return Drop, unknownProtoString(q.IPProto)
 	}
+	f.dropIn(q)
 	return Drop, "no rules matched"
 }
 
@@ -14,6 +14,7 @@
 	"strconv"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/google/go-cmp/cmp"
 	"github.com/google/go-cmp/cmp/cmpopts"
@@ -237,6 +238,97 @@ func TestUDPState(t *testing.T) {
 	}
 }
 
+func TestStats(t *testing.T) {
+	acl := newFilter(t.Logf)
+
+	run := func(f *Filter, p packet.Parsed, want Response) {
+		t.Helper()
+		if got := f.RunIn(&p, 0); got != want {
+			t.Fatalf("RunIn(%v) = %v; want %v", p, got, want)
+		}
+	}
+	run(acl, parsed(ipproto.TCP, "8.1.1.1", "1.2.3.4", 999, 22), Accept)
+	run(acl, parsed(ipproto.TCP, "8.2.2.2", "5.6.7.8", 999, 23), Accept)
+	run(acl, parsed(ipproto.TCP, "17.34.51.68", "8.1.34.51", 999, 443), Accept)
+	run(acl, parsed(ipproto.TCP, "8.3.3.3", "1.2.3.4", 999, 22), Drop)
+
+	// Non-SYN packets of established flows aren't counted.
+	nonSyn := parsed(ipproto.TCP, "8.1.1.1", "1.2.3.4", 999, 22)
+	nonSyn.TCPFlags = packet.TCPAck
+	run(acl, nonSyn, Accept)
+
+	st := acl.Stats()
+	if got, want := len(st.Rules), 12; got != want {
+		t.Fatalf("len(Rules) = %d; want %d", got, want)
+	}
+	if got := st.Rules[0].Hits; got != 2 {
+		t.Errorf("rule 0 hits = %d; want 2", got)
+	}
+	if st.Rules[0].LastHit.IsZero() {
+		t.Errorf("rule 0 LastHit is zero")
+	}
+	if got := st.Rules[5].Hits; got != 1 {
+		t.Errorf("rule 5 hits = %d; want 1", got)
+	}
+	if got := st.Rules[1].Hits; got != 0 {
+		t.Errorf("rule 1 hits = %d; want 0", got)
+	}
+	if !st.Rules[1].LastHit.IsZero() {
+		t.Errorf("rule 1 LastHit = %v; want zero", st.Rules[1].LastHit)
+	}
+	if st.NoMatch != 1 {
+		t.Errorf("NoMatch = %d; want 1", st.NoMatch)
+	}
+
+	// A new filter sharing state keeps the counters of unchanged rules
+	// and starts new rules at zero.
+	acl2 := New([]Match{
+		m(nets("0.0.0.0/0"), netports("0.0.0.0/0:443")),
+		m(nets("8.1.1.1"), netports("1.2.3.4:80")),
+	}, nil, nil, nil, acl, t.Logf)
+	st = acl2.Stats()
+	if got := []uint64{st.Rules[0].Hits, st.Rules[1].Hits}; !slices.Equal(got, []uint64{1, 0}) {
+		t.Errorf("hits after update = %v; want [1 0]", got)
+	}
+	if st.NoMatch != 1 {
+		t.Errorf("NoMatch after update = %d; want 1", st.NoMatch)
+	}
+}
+
+func TestSimulate(t *testing.T) {
+	acl := newFilter(t.Logf)
+	for _, p := range []packet.Parsed{
+		parsed(ipproto.TCP, "8.1.1.1", "1.2.3.4", 999, 22),   // accepted
+		parsed(ipproto.TCP, "8.1.1.1", "1.2.3.4", 1000, 22),  // same flow, other source port
+		parsed(ipproto.UDP, "8.3.3.3", "1.2.3.4", 999, 22),   // dropped
+		parsed(ipproto.TCP, "8.1.1.1", "16.32.48.64", 0, 22), // non-local; not tracked
+	} {
+		acl.RunIn(&p, 0)
+	}
+
+	got := acl.Simulate([]Match{
+		m(nets("8.3.3.3"), netports("1.2.3.4:22")),
+	})
+	want := []filtertype.SimulatedFlow{
+		{Proto: ipproto.UDP, Src: netip.MustParseAddr("8.3.3.3"), Dst: mustIPPort("1.2.3.4:22"), WasAccepted: false, WouldAccept: true},
+		{Proto: ipproto.TCP, Src: netip.MustParseAddr("8.1.1.1"), Dst: mustIPPort("1.2.3.4:22"), WasAccepted: true, WouldAccept: false},
+	}
+	for i := range got {
+		if got[i].LastSeen.IsZero() {
+			t.Errorf("flow %d: zero LastSeen", i)
+		}
+		got[i].LastSeen = time.Time{}
+	}
+	if diff := cmp.Diff(want, got, cmp.Comparer(func(a, b netip.Addr) bool { return a == b }), cmp.Comparer(func(a, b netip.AddrPort) bool { return a == b })); diff != "" {
+		t.Errorf("Simulate mismatch (-want +got):\n%s", diff)
+	}
+
+	// Simulating doesn't affect the filter's own state.
+	if st := acl.Stats(); st.NoMatch != 1 {
+		t.Errorf("NoMatch after Simulate = %d; want 1", st.NoMatch)
+	}
+}
+
 func TestNoAllocs(t *testing.T) {
 	acl := newFilter(t.Logf)
 
@@ -244,6 +336,13 @@ func TestNoAllocs(t *testing.T) {
 	udp4Packet := raw4(ipproto.UDP, "8.1.1.1", "1.2.3.4", 999, 22, 0)
 	tcp6Packet := raw6(ipproto.TCP, "2001::1", "2001::2", 999, 22, 0)
 	udp6Packet := raw6(ipproto.UDP, "2001::1", "2001::2", 999, 22, 0)
+	// The TCP packets are SYNs, so these are inbound new flows that are
+	// accepted by a rule, and that no rule accepts.
+	tcp4AcceptPacket := raw4(ipproto.TCP, "8.1.1.1", "1.2.3.4", 999, 22, 0)
+	tcp6AcceptPacket := raw6(ipproto.TCP, "2001::1", "2001::2", 999, 443, 0)
+	tcp4DropPacket := raw4(ipproto.TCP, "8.1.1.1", "1.2.3.4", 999, 23, 0)
+	udp4DropPacket := raw4(ipproto.UDP, "8.1.1.1", "1.2.3.4", 999, 23, 0)
+	tcp6DropPacket := raw6(ipproto.TCP, "2001::1", "2001::2", 999, 23, 0)
 
 	tests := []struct {
 		name   string
@@ -252,6 +351,11 @@ func TestNoAllocs(t *testing.T) {
 	}{
 		{"tcp4_in", in, tcp4Packet},
 		{"tcp6_in", in, tcp6Packet},
+		{"tcp4_in_syn_accept", in, tcp4AcceptPacket},
+		{"tcp6_in_syn_accept", in, tcp6AcceptPacket},
+		{"tcp4_in_drop", in, tcp4DropPacket},
+		{"udp4_in_drop", in, udp4DropPacket},
+		{"tcp6_in_drop", in, tcp6DropPacket},
 		{"tcp4_out", out, tcp4Packet},
 		{"tcp6_out", out, tcp6Packet},
 		{"udp4_in", in, udp4Packet},
@@ -920,7 +1024,7 @@ func TestMatchesMatchProtoAndIPsOnlyIfAllPorts(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			matches := matches{tt.m}
-			got := matches.matchProtoAndIPsOnlyIfAllPorts(&tt.p)
+			_, got := matches.matchProtoAndIPsOnlyIfAllPorts(&tt.p)
 			if got != tt.want {
 				t.Errorf("got = %v; want %v", got, tt.want)
 			}
@@ -1142,3 +1246,35 @@ func benchmarkFile(b *testing.B, file string, opt benchOpt) {
 		}
 	}
 }
+
+func TestRecentRing(t *testing.T) {
+	var r recentRing
+	if got := r.flows(); len(got) != 0 {
+		t.Fatalf("empty ring has flows %v", got)
+	}
+	flow := func(i int, accepted bool) recentFlow {
+		return recentFlow{
+			proto:    ipproto.TCP,
+			src:      netip.AddrFrom4([4]byte{8, 1, 1, byte(i)}),
+			dst:      mustIPPort("[2001::2]:443"),
+			accepted: accepted,
+			lastSeen: int64(i),
+		}
+	}
+	// Overwrite the ring more than once, adding each flow twice.
+	const n = recentMax*2 + 100
+	for i := range n {
+		r.add(flow(i%200, false))
+		r.add(flow(i%200, true))
+	}
+	got := r.flows()
+	if len(got) != 200 {
+		t.Fatalf("got %d flows; want 200", len(got))
+	}
+	for i, rf := range got {
+		want := flow((n-1-i)%200, true)
+		if rf != want {
+			t.Errorf("flow %d = %+v; want %+v", i, rf, want)
+		}
+	}
+}
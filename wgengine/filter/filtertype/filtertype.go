@@ -8,6 +8,7 @@
 	"fmt"
 	"net/netip"
 	"strings"
+	"time"
 
 	"tailscale.com/tailcfg"
 	"tailscale.com/types/ipproto"
@@ -114,3 +115,51 @@ func (m Match) String() string {
 	}
 	return fmt.Sprintf("%v%v=>%v", m.IPProto, ss, ds)
 }
+
+// RuleStats reports how often a single Match in a packet filter has
+// accepted traffic.
+type RuleStats struct {
+	// Rule is the Match, in the form of Match.String.
+	Rule string
+
+	// Hits is the number of new flows the Match has accepted.
+	// Packets of already-established flows are not counted.
+	Hits uint64
+
+	// LastHit is when the Match last accepted a new flow.
+	// It is the zero value if Hits is zero.
+	LastHit time.Time `json:",omitzero"`
+}
+
+// Stats is a snapshot of a packet filter's per-Match counters.
+type Stats struct {
+	// Rules contains one entry per packet-matching Match of the filter,
+	// in filter order. Capability-grant-only Matches are omitted.
+	Rules []RuleStats
+
+	// NoMatch is the number of new inbound flows that were dropped
+	// because no Match accepted them.
+	NoMatch uint64
+
+	// LastNoMatch is when a new inbound flow was last dropped because
+	// no Match accepted it.
+	LastNoMatch time.Time `json:",omitzero"`
+}
+
+// SimulatedFlow is a recently seen new inbound flow, with the packet
+// filter's verdict on it and that of a candidate packet filter.
+type SimulatedFlow struct {
+	Proto ipproto.Proto
+	Src   netip.Addr // the source port is not tracked
+	Dst   netip.AddrPort
+
+	// LastSeen is when the flow was last seen.
+	LastSeen time.Time
+
+	// WasAccepted is whether the packet filter accepted the flow when it
+	// was last seen.
+	WasAccepted bool
+
+	// WouldAccept is whether the candidate packet filter accepts the flow.
+	WouldAccept bool
+}
@@ -14,7 +14,9 @@
 
 type matches []filtertype.Match
 
-func (ms matches) match(q *packet.Parsed, hasCap CapTestFunc) bool {
+// match reports whether q matches any Match in ms and, if so,
+// the index of the first one that does.
+func (ms matches) match(q *packet.Parsed, hasCap CapTestFunc) (i int, ok bool) {
 	for i := range ms {
 		m := &ms[i]
 		if !views.SliceContains(m.IPProto, q.IPProto) {
@@ -30,10 +32,10 @@ func (ms matches) match(q *packet.Parsed, hasCap CapTestFunc) bool {
 			if !dst.Ports.Contains(q.Dst.Port()) {
 				continue
 			}
-			return true
+			return i, true
 		}
 	}
-	return false
+	return -1, false
 }
 
 // srcMatches reports whether srcAddr matche the src requirements in m, either
@@ -59,35 +61,38 @@ func srcMatches(m *filtertype.Match, srcAddr netip.Addr, hasCap CapTestFunc) boo
 // It it used in the fast path of evaluating filter rules so should be fast.
 type CapTestFunc = func(srcIP netip.Addr, cap tailcfg.NodeCapability) bool
 
-func (ms matches) matchIPsOnly(q *packet.Parsed, hasCap CapTestFunc) bool {
+// matchIPsOnly reports whether q matches any Match in ms by IP address
+// alone and, if so, the index of the Match that does.
+func (ms matches) matchIPsOnly(q *packet.Parsed, hasCap CapTestFunc) (i int, ok bool) {
 	srcAddr := q.Src.Addr()
-	for _, m := range ms {
+	for i, m := range ms {
 		if !m.SrcsContains(srcAddr) {
 			continue
 		}
 		for _, dst := range m.Dsts {
 			if dst.Net.Contains(q.Dst.Addr()) {
-				return true
+				return i, true
 			}
 		}
 	}
 	if hasCap != nil {
-		for _, m := range ms {
+		for i, m := range ms {
 			for _, c := range m.SrcCaps {
 				if hasCap(srcAddr, c) {
-					return true
+					return i, true
 				}
 			}
 		}
 	}
-	return false
+	return -1, false
 }
 
 // matchProtoAndIPsOnlyIfAllPorts reports q matches any Match in ms where the
 // Match if for the right IP Protocol and IP address, but ports are
 // ignored, as long as the match is for the entire uint16 port range.
-func (ms matches) matchProtoAndIPsOnlyIfAllPorts(q *packet.Parsed) bool {
-	for _, m := range ms {
+// If so, it also returns the index of the first such Match.
+func (ms matches) matchProtoAndIPsOnlyIfAllPorts(q *packet.Parsed) (i int, ok bool) {
+	for i, m := range ms {
 		if !views.SliceContains(m.IPProto, q.IPProto) {
 			continue
 		}
@@ -99,9 +104,9 @@ func (ms matches) matchProtoAndIPsOnlyIfAllPorts(q *packet.Parsed) bool {
 				continue
 			}
 			if dst.Net.Contains(q.Dst.Addr()) {
-				return true
+				return i, true
 			}
 		}
 	}
-	return false
+	return -1, false
 }
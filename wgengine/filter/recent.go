@@ -0,0 +1,149 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package filter
+
+import (
+	"cmp"
+	"encoding/binary"
+	"net/netip"
+	"slices"
+	"sync/atomic"
+
+	"tailscale.com/types/ipproto"
+)
+
+// recentMax is the number of recent inbound flows kept in filterState.
+const recentMax = 1024
+
+// recentFlow is the verdict on a new inbound flow.
+type recentFlow struct {
+	proto    ipproto.Proto
+	src      netip.Addr
+	dst      netip.AddrPort
+	accepted bool
+	lastSeen int64 // unix nanoseconds
+}
+
+// recentRing is a fixed-size ring of the most recently seen new inbound
+// flows, for Filter.Simulate. It's written to on the packet path, so adding
+// a flow takes no locks and doesn't allocate: each slot is guarded by a
+// sequence number, and adds that would race with another add to the same
+// slot are skipped, as are reads that race with an add.
+//
+// The zero value is an empty ring.
+type recentRing struct {
+	next  atomic.Uint64 // index of the next flow to add
+	slots [recentMax]recentSlot
+}
+
+// recentSlot is a slot of a recentRing, holding an encoded recentFlow.
+type recentSlot struct {
+	// seq is zero if the slot is empty, odd while it's being written, and
+	// 2*(i+1) once it holds the flow with index i.
+	seq atomic.Uint64
+
+	src, dst [2]atomic.Uint64 // 16-byte addresses
+	meta     atomic.Uint64    // see encodeMeta
+	lastSeen atomic.Int64
+}
+
+// add adds rf to the ring.
+func (r *recentRing) add(rf recentFlow) {
+	i := r.next.Add(1) - 1
+	s := &r.slots[i%recentMax]
+	old := s.seq.Load()
+	if old%2 == 1 || !s.seq.CompareAndSwap(old, 2*i+1) {
+		return // another add is writing the slot
+	}
+	src, dst := rf.src.As16(), rf.dst.Addr().As16()
+	for j := range 2 {
+		s.src[j].Store(binary.BigEndian.Uint64(src[j*8:]))
+		s.dst[j].Store(binary.BigEndian.Uint64(dst[j*8:]))
+	}
+	s.meta.Store(encodeMeta(rf))
+	s.lastSeen.Store(rf.lastSeen)
+	s.seq.Store(2 * (i + 1))
+}
+
+// encodeMeta packs the fields of rf other than its addresses and time into
+// a uint64.
+func encodeMeta(rf recentFlow) uint64 {
+	m := uint64(rf.proto) | uint64(rf.dst.Port())<<8
+	if rf.src.Is4() {
+		m |= 1 << 24
+	}
+	if rf.dst.Addr().Is4() {
+		m |= 1 << 25
+	}
+	if rf.accepted {
+		m |= 1 << 26
+	}
+	return m
+}
+
+// load returns the flow in s and whether it was read consistently.
+func (s *recentSlot) load() (rf recentFlow, seq uint64, ok bool) {
+	seq = s.seq.Load()
+	if seq == 0 || seq%2 == 1 {
+		return rf, 0, false
+	}
+	var src, dst [16]byte
+	for j := range 2 {
+		binary.BigEndian.PutUint64(src[j*8:], s.src[j].Load())
+		binary.BigEndian.PutUint64(dst[j*8:], s.dst[j].Load())
+	}
+	m := s.meta.Load()
+	rf.lastSeen = s.lastSeen.Load()
+	if s.seq.Load() != seq {
+		return rf, 0, false
+	}
+	rf.proto = ipproto.Proto(m)
+	rf.src = netip.AddrFrom16(src)
+	if m&(1<<24) != 0 {
+		rf.src = rf.src.Unmap()
+	}
+	dstAddr := netip.AddrFrom16(dst)
+	if m&(1<<25) != 0 {
+		dstAddr = dstAddr.Unmap()
+	}
+	rf.dst = netip.AddrPortFrom(dstAddr, uint16(m>>8))
+	rf.accepted = m&(1<<26) != 0
+	return rf, seq, true
+}
+
+// flows returns the distinct flows in the ring, most recently added first.
+// Flows that differ only in their source ports are the same flow, as the
+// source port doesn't affect the verdict.
+func (r *recentRing) flows() []recentFlow {
+	type flowKey struct {
+		proto ipproto.Proto
+		src   netip.Addr
+		dst   netip.AddrPort
+	}
+	type seqFlow struct {
+		seq uint64
+		rf  recentFlow
+	}
+	latest := make(map[flowKey]seqFlow)
+	for i := range r.slots {
+		rf, seq, ok := r.slots[i].load()
+		if !ok {
+			continue
+		}
+		k := flowKey{rf.proto, rf.src, rf.dst}
+		if prev, ok := latest[k]; !ok || seq > prev.seq {
+			latest[k] = seqFlow{seq, rf}
+		}
+	}
+	sfs := make([]seqFlow, 0, len(latest))
+	for _, sf := range latest {
+		sfs = append(sfs, sf)
+	}
+	slices.SortFunc(sfs, func(a, b seqFlow) int { return cmp.Compare(b.seq, a.seq) })
+	ret := make([]recentFlow, len(sfs))
+	for i, sf := range sfs {
+		ret[i] = sf.rf
+	}
+	return ret
+}
@@ -189,7 +189,7 @@ func (c *Conn) maybeSetNearestDERP(report *netcheck.Report) (preferredDERP int)
 		// strictly better than doing nothing.
 	}
 
-	preferredDERP = report.PreferredDERP
+	preferredDERP = c.avoidDrainingDERP(report.PreferredDERP)
 	if preferredDERP == 0 {
 		// Perhaps UDP is blocked. Pick a deterministic but arbitrary
 		// one.
@@ -206,6 +206,72 @@ func (c *Conn) maybeSetNearestDERP(report *netcheck.Report) (preferredDERP int)
 	return
 }
 
+// drainingDERPAvoidTime is how long a DERP region whose server said it's
+// going away, suggesting an alternate region, is avoided as our home.
+const drainingDERPAvoidTime = 10 * time.Minute
+
+// drainingDERP is a DERP region that was our home until its server said
+// it's going away, suggesting an alternate region to use instead.
+type drainingDERP struct {
+	regionID int
+	altID    int
+	until    time.Time // when to stop avoiding regionID
+}
+
+// noteDERPRestarting handles a restarting message from the DERP server of
+// regionID. If the server is our home and suggests an alternate region, as
+// draining servers do, it moves our home to that region, and tells control
+// so that peers reach us there.
+//
+// c.mu must NOT be held.
+func (c *Conn) noteDERPRestarting(regionID int, m derp.ServerRestartingMessage) {
+	alt := m.AltRegionID
+	if alt == 0 || alt == regionID {
+		return
+	}
+	c.mu.Lock()
+	if regionID != c.myDerp || c.derpMap == nil || c.derpMap.Regions[alt] == nil {
+		c.mu.Unlock()
+		return
+	}
+	c.logf("magicsock: derp-%d is going away; moving home to suggested derp-%d", regionID, alt)
+	c.drainingDerp = drainingDERP{
+		regionID: regionID,
+		altID:    alt,
+		until:    time.Now().Add(drainingDERPAvoidTime),
+	}
+	c.mu.Unlock()
+
+	if !c.setNearestDERP(alt) {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.netInfoLast != nil {
+		ni := c.netInfoLast.Clone()
+		ni.PreferredDERP = alt
+		c.callNetInfoCallbackLocked(ni)
+	}
+}
+
+// avoidDrainingDERP returns the DERP region to use as our home instead of
+// preferred, if preferred's server recently said it's going away: the
+// alternate region that it suggested. Otherwise it returns preferred.
+//
+// c.mu must NOT be held.
+func (c *Conn) avoidDrainingDERP(preferred int) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	d := c.drainingDerp
+	if preferred == 0 || preferred != d.regionID || time.Now().After(d.until) {
+		return preferred
+	}
+	if c.derpMap == nil || c.derpMap.Regions[d.altID] == nil {
+		return preferred
+	}
+	return d.altID
+}
+
 func (c *Conn) derpRegionCodeLocked(regionID int) string {
 	if c.derpMap == nil {
 		return ""
@@ -616,6 +682,9 @@ func (c *Conn) runDerpReader(ctx context.Context, regionID int, dc *derphttp.Cli
 		case derp.HealthMessage:
 			c.health.SetDERPRegionHealth(regionID, m.Problem)
 			continue
+		case derp.ServerRestartingMessage:
+			c.noteDERPRestarting(regionID, m)
+			continue
 		case derp.PeerGoneMessage:
 			switch m.Reason {
 			case derp.PeerGoneReasonDisconnected:
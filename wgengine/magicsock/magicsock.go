@@ -374,6 +374,7 @@ type Conn struct {
 	derpStarted        chan struct{}                 // closed on first connection to DERP; for tests & cleaner Close
 	activeDerp         map[int]activeDerp            // DERP regionID -> connection to a node in that region
 	prevDerp           map[int]*syncs.WaitGroupChan
+	drainingDerp       drainingDERP // home DERP region whose server said it's going away, if any
 
 	// derpRoute contains optional alternate routes to use as an
 	// optimization instead of contacting a peer via their home
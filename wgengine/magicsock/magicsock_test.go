@@ -41,6 +41,7 @@
 	"golang.org/x/net/ipv4"
 	"tailscale.com/cmd/testwrapper/flakytest"
 	"tailscale.com/control/controlknobs"
+	"tailscale.com/derp"
 	"tailscale.com/derp/derpserver"
 	"tailscale.com/disco"
 	"tailscale.com/envknob"
@@ -3059,6 +3060,68 @@ func TestMaybeSetNearestDERP(t *testing.T) {
 	}
 }
 
+func TestNoteDERPRestarting(t *testing.T) {
+	region := func(id int) *tailcfg.DERPRegion {
+		return &tailcfg.DERPRegion{
+			RegionID:   id,
+			RegionCode: fmt.Sprintf("r%d", id),
+			Nodes: []*tailcfg.DERPNode{{
+				Name:     fmt.Sprintf("%db", id),
+				RegionID: id,
+				HostName: fmt.Sprintf("r%d.test-node.unused", id),
+				IPv4:     "127.0.0.1",
+				IPv6:     "none",
+			}},
+		}
+	}
+	derpMap := &tailcfg.DERPMap{
+		Regions: map[int]*tailcfg.DERPRegion{
+			1: region(1),
+			2: region(2),
+		},
+	}
+
+	c := newConn(t.Logf)
+	c.derpMap = derpMap
+	c.health = health.NewTracker(eventbustest.NewBus(t))
+	c.myDerp = 1
+	c.netInfoLast = &tailcfg.NetInfo{PreferredDERP: 1}
+	netInfo := make(chan *tailcfg.NetInfo, 1)
+	c.netInfoFunc = func(ni *tailcfg.NetInfo) { netInfo <- ni }
+
+	// Ignored: not from our home, without an alternate region, or with one
+	// that's not in the DERP map.
+	c.noteDERPRestarting(2, derp.ServerRestartingMessage{AltRegionID: 1})
+	c.noteDERPRestarting(1, derp.ServerRestartingMessage{})
+	c.noteDERPRestarting(1, derp.ServerRestartingMessage{AltRegionID: 3})
+	if c.myDerp != 1 {
+		t.Fatalf("home = derp-%d; want unchanged derp-1", c.myDerp)
+	}
+
+	c.noteDERPRestarting(1, derp.ServerRestartingMessage{AltRegionID: 2})
+	if c.myDerp != 2 {
+		t.Errorf("home = derp-%d; want derp-2", c.myDerp)
+	}
+	select {
+	case ni := <-netInfo:
+		if ni.PreferredDERP != 2 {
+			t.Errorf("reported PreferredDERP = %d; want 2", ni.PreferredDERP)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("home change not reported")
+	}
+
+	// Later netchecks don't move home back to the draining region...
+	if got := c.maybeSetNearestDERP(&netcheck.Report{PreferredDERP: 1}); got != 2 {
+		t.Errorf("maybeSetNearestDERP while draining = %d; want 2", got)
+	}
+	// ... until it's no longer avoided.
+	c.drainingDerp.until = time.Now().Add(-time.Second)
+	if got := c.maybeSetNearestDERP(&netcheck.Report{PreferredDERP: 1}); got != 1 {
+		t.Errorf("maybeSetNearestDERP after draining = %d; want 1", got)
+	}
+}
+
 func TestShouldRebind(t *testing.T) {
 	tests := []struct {
 		err    error